@@ -0,0 +1,196 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package actions run locally defined commands on request of Bleemeo.
+//
+// Only the actions defined in the configuration could be run, Bleemeo only gives the
+// name of the action and never the command. An action could be disabled without removing
+// it, it never runs concurrently with itself, at most once per cooldown and is killed
+// after its timeout. Each request is logged.
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"glouton/command"
+	"glouton/logger"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCooldown = time.Minute
+	defaultTimeout  = time.Minute
+	maxOutputLength = 2000
+)
+
+var (
+	errMissingName   = errors.New("missing name")
+	errUnknownAction = errors.New("unknown action")
+	errNotAllowed    = errors.New("action is not allowed")
+	errRunning       = errors.New("action is already running")
+	errCooldown      = errors.New("action ran recently")
+)
+
+// Action is a command which could be run on request of Bleemeo.
+type Action struct {
+	Name     string
+	Command  []string
+	Allowed  bool
+	Cooldown time.Duration
+	Timeout  time.Duration
+}
+
+// NewAction returns an Action. The command is split like a shell would do. Zero cooldown
+// and timeout use the defaults.
+func NewAction(name string, cmd string, allowed bool, cooldown time.Duration, timeout time.Duration) (Action, error) {
+	if name == "" {
+		return Action{}, errMissingName
+	}
+
+	args, err := command.Split(cmd)
+	if err != nil {
+		return Action{}, err
+	}
+
+	if cooldown == 0 {
+		cooldown = defaultCooldown
+	}
+
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	return Action{
+		Name:     name,
+		Command:  args,
+		Allowed:  allowed,
+		Cooldown: cooldown,
+		Timeout:  timeout,
+	}, nil
+}
+
+// String returns a description of the action for logs.
+func (a Action) String() string {
+	if !a.Allowed {
+		return fmt.Sprintf("%s: %q (not allowed)", a.Name, a.Command)
+	}
+
+	return fmt.Sprintf("%s: %q", a.Name, a.Command)
+}
+
+// Registry runs the actions.
+type Registry struct {
+	actions map[string]Action
+
+	l       sync.Mutex
+	lastRun map[string]time.Time
+	running map[string]bool
+}
+
+// New returns a Registry for the given actions. When two actions have the same name,
+// the last one is used.
+func New(actions []Action) *Registry {
+	r := &Registry{
+		actions: make(map[string]Action, len(actions)),
+		lastRun: make(map[string]time.Time),
+		running: make(map[string]bool),
+	}
+
+	for _, a := range actions {
+		r.actions[a.Name] = a
+	}
+
+	return r
+}
+
+// Names returns the sorted names of the allowed actions.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.actions))
+
+	for name, a := range r.actions {
+		if a.Allowed {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// reserve marks the action as running if it could run now.
+func (r *Registry) reserve(name string, now time.Time) (Action, error) {
+	a, ok := r.actions[name]
+	if !ok {
+		return Action{}, fmt.Errorf("%w %#v", errUnknownAction, name)
+	}
+
+	if !a.Allowed {
+		return Action{}, fmt.Errorf("%w: %s", errNotAllowed, name)
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if r.running[name] {
+		return Action{}, fmt.Errorf("%w: %s", errRunning, name)
+	}
+
+	if last, ok := r.lastRun[name]; ok && now.Sub(last) < a.Cooldown {
+		return Action{}, fmt.Errorf(
+			"%w: %s ran %v ago, cooldown is %v",
+			errCooldown, name, now.Sub(last).Truncate(time.Second), a.Cooldown,
+		)
+	}
+
+	r.running[name] = true
+	r.lastRun[name] = now
+
+	return a, nil
+}
+
+// Run executes the action and returns its output, truncated if too long.
+func (r *Registry) Run(ctx context.Context, name string) (string, error) {
+	a, err := r.reserve(name, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		r.l.Lock()
+		r.running[name] = false
+		r.l.Unlock()
+	}()
+
+	logger.Printf("Action %s: running %q", a.Name, a.Command)
+
+	start := time.Now()
+	output, err := command.Run(ctx, a.Command, a.Timeout, maxOutputLength)
+	duration := time.Since(start).Truncate(time.Millisecond)
+
+	if err != nil {
+		logger.Printf("Action %s: failed after %v: %v. Output: %s", a.Name, duration, err, output)
+
+		return output, err
+	}
+
+	logger.Printf("Action %s: succeeded in %v. Output: %s", a.Name, duration, output)
+
+	return output, nil
+}
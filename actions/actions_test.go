@@ -0,0 +1,113 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package actions
+
+import (
+	"context"
+	"errors"
+	"glouton/command"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestNewAction(t *testing.T) {
+	a, err := NewAction("restart-nginx", "systemctl restart 'nginx.service'", true, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"systemctl", "restart", "nginx.service"}; !reflect.DeepEqual(a.Command, want) {
+		t.Errorf("Command = %q, want %q", a.Command, want)
+	}
+
+	if a.Cooldown != defaultCooldown || a.Timeout != defaultTimeout {
+		t.Errorf("Cooldown = %v, Timeout = %v, want the defaults", a.Cooldown, a.Timeout)
+	}
+
+	if _, err := NewAction("", "true", true, 0, 0); !errors.Is(err, errMissingName) {
+		t.Errorf("NewAction(no name) = %v, want %v", err, errMissingName)
+	}
+
+	if _, err := NewAction("empty", "", true, 0, 0); !errors.Is(err, command.ErrMissingCommand) {
+		t.Errorf("NewAction(no command) = %v, want %v", err, command.ErrMissingCommand)
+	}
+
+	if _, err := NewAction("invalid", "echo 'unterminated", true, 0, 0); err == nil {
+		t.Error("NewAction(invalid command) succeeded, want an error")
+	}
+}
+
+func TestReserve(t *testing.T) {
+	allowed, _ := NewAction("allowed", "true", true, time.Hour, 0)
+	denied, _ := NewAction("denied", "true", false, 0, 0)
+	r := New([]Action{allowed, denied})
+	now := time.Now()
+
+	if names := r.Names(); !reflect.DeepEqual(names, []string{"allowed"}) {
+		t.Errorf("Names() = %v, want [allowed]", names)
+	}
+
+	if _, err := r.reserve("rm-rf", now); !errors.Is(err, errUnknownAction) {
+		t.Errorf("reserve(unknown) = %v, want %v", err, errUnknownAction)
+	}
+
+	if _, err := r.reserve("denied", now); !errors.Is(err, errNotAllowed) {
+		t.Errorf("reserve(denied) = %v, want %v", err, errNotAllowed)
+	}
+
+	if _, err := r.reserve("allowed", now); err != nil {
+		t.Errorf("reserve(allowed) = %v, want nil", err)
+	}
+
+	if _, err := r.reserve("allowed", now); !errors.Is(err, errRunning) {
+		t.Errorf("reserve(running) = %v, want %v", err, errRunning)
+	}
+
+	r.running["allowed"] = false
+
+	if _, err := r.reserve("allowed", now.Add(time.Minute)); !errors.Is(err, errCooldown) {
+		t.Errorf("reserve(cooldown) = %v, want %v", err, errCooldown)
+	}
+
+	if _, err := r.reserve("allowed", now.Add(2*time.Hour)); err != nil {
+		t.Errorf("reserve(after cooldown) = %v, want nil", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("echo isn't an executable on Windows")
+	}
+
+	hello, _ := NewAction("hello", "echo hello", true, 0, 0)
+	r := New([]Action{hello})
+
+	output, err := r.Run(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != "hello" {
+		t.Errorf("output = %#v, want \"hello\"", output)
+	}
+
+	if r.running["hello"] {
+		t.Error("action is still marked as running")
+	}
+}
@@ -20,6 +20,8 @@ package agent
 import (
 	"archive/zip"
 	"context"
+	cryptoRand "crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -28,6 +30,7 @@ import (
 	"math/rand"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
@@ -40,10 +43,15 @@ import (
 	"syscall"
 	"time"
 
+	"glouton/actions"
 	"glouton/agent/state"
 	"glouton/api"
+	"glouton/audit"
 	"glouton/bleemeo"
+	"glouton/bleemeo/relay"
 	bleemeoTypes "glouton/bleemeo/types"
+	"glouton/check"
+	"glouton/clock"
 	"glouton/collector"
 	"glouton/config"
 	"glouton/debouncer"
@@ -55,14 +63,25 @@ import (
 	"glouton/inputs/docker"
 	processInput "glouton/inputs/process"
 	"glouton/inputs/statsd"
+	"glouton/inputs/ipmi"
+	"glouton/inputs/saturation"
+	"glouton/inputs/sessions"
+	"glouton/inputs/tcpconn"
+	"glouton/inputs/timesync"
 	"glouton/jmxtrans"
 	"glouton/logger"
+	"glouton/metricquery"
+	"glouton/notifier"
 	"glouton/nrpe"
 	"glouton/prometheus/exporter/blackbox"
 	"glouton/prometheus/exporter/common"
+	"glouton/prometheus/exporter/selfmetrics"
 	"glouton/prometheus/process"
 	"glouton/prometheus/registry"
 	"glouton/prometheus/scrapper"
+	"glouton/proxy"
+	"glouton/remediation"
+	"glouton/rules"
 	"glouton/store"
 	"glouton/task"
 	"glouton/threshold"
@@ -70,36 +89,55 @@ import (
 	"glouton/version"
 	"glouton/zabbix"
 
+	"net"
 	"net/http"
 	"net/url"
+
+	"github.com/influxdata/telegraf"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type agent struct {
 	taskRegistry *task.Registry
 	config       *config.Configuration
+	cfg          Config
 	state        *state.State
 	cancel       context.CancelFunc
 	context      context.Context
 
 	hostRootPath      string
 	discovery         *discovery.Discovery
+	checkPools        *check.Pools
+	dockerHealth      *check.DockerHealthCheck
+	packageInventory  *facts.PackageInventory
 	dockerFact        *facts.DockerProvider
 	collector         *collector.Collector
 	factProvider      *facts.FactProvider
 	bleemeoConnector  *bleemeo.Connector
+	relay             *relay.Relay
 	influxdbConnector *influxdb.Client
+	influxdbWriter    *influxdb.Writer
 	threshold         *threshold.Registry
 	jmx               *jmxtrans.JMX
 	store             *store.Store
 	gathererRegistry  *registry.Registry
+	auditLog          *audit.Log
 	metricFormat      types.MetricFormat
 	dynamicScrapper   *promexporter.DynamicScrapper
 	lastHealCheck     int64
 
+	// processCheckThresholds are the thresholds of the process checks, set once
+	// at startup. They are merged with the other thresholds.
+	processCheckThresholds map[threshold.MetricNameItem]threshold.Threshold
+	// bleemeoThresholds are the last thresholds received from Bleemeo, used to audit the changes.
+	bleemeoThresholds map[threshold.MetricNameItem]threshold.Threshold
+
 	triggerHandler            *debouncer.Debouncer
 	triggerLock               sync.Mutex
 	triggerDiscAt             time.Time
 	triggerDiscImmediate      bool
+	triggerContainers         map[string]bool
+	triggerContainersAt       map[string]time.Time
 	triggerFact               bool
 	triggerSystemUpdateMetric bool
 
@@ -111,16 +149,23 @@ type agent struct {
 	metricResolution time.Duration
 }
 
-func zabbixResponse(key string, args []string) (string, error) {
-	if key == "agent.ping" {
-		return "1", nil
-	}
+// zabbixResponse returns the Zabbix callback. Metric values are read from querier.
+func zabbixResponse(querier *metricquery.Querier) func(key string, args []string) (string, error) {
+	return func(key string, args []string) (string, error) {
+		if key == "agent.ping" {
+			return "1", nil
+		}
 
-	if key == "agent.version" {
-		return fmt.Sprintf("4 (Glouton %s)", version.Version), nil
-	}
+		if key == "agent.version" {
+			return fmt.Sprintf("4 (Glouton %s)", version.Version), nil
+		}
+
+		if key == metricquery.ZabbixKey {
+			return querier.ZabbixResponse(args)
+		}
 
-	return "", errors.New("Unsupported item key") // nolint: stylecheck
+		return "", errors.New("Unsupported item key") // nolint: stylecheck
+	}
 }
 
 type taskInfo struct {
@@ -128,13 +173,49 @@ type taskInfo struct {
 	name     string
 }
 
+// stateKeyFilename is the file, next to the state file, with the state encryption key.
+const stateKeyFilename = "state.key"
+
+var errStateKeyPermission = errors.New("the state encryption key must only be readable by its owner")
+
+// mandatoryTasks are the tasks without which the agent can't work. When they crashed and
+// their restarts failed, the agent is stopped.
+var mandatoryTasks = []string{"Bleemeo SAAS connector", "Metric collector", "Metric store"} //nolint:gochecknoglobals
+
+// taskPolicy returns the restart policy of an agent task. Tasks are restarted on failure,
+// mandatory tasks only a few times before the agent is stopped.
+func taskPolicy(name string) task.Policy {
+	for _, n := range mandatoryTasks {
+		if n == name {
+			return task.Policy{
+				Restart:    task.RestartOnFailure,
+				MaxRetries: 3,
+				MinBackoff: 10 * time.Second,
+				MaxBackoff: time.Minute,
+			}
+		}
+	}
+
+	return task.Policy{
+		Restart:    task.RestartOnFailure,
+		MinBackoff: 10 * time.Second,
+		MaxBackoff: 10 * time.Minute,
+	}
+}
+
 func (a *agent) init(configFiles []string) (ok bool) {
-	atomic.StoreInt64(&a.lastHealCheck, time.Now().Unix())
+	atomic.StoreInt64(&a.lastHealCheck, clock.Nanotime())
 
 	a.taskRegistry = task.NewRegistry(context.Background())
 	cfg, warnings, err := a.loadConfiguration(configFiles)
 	a.config = cfg
 
+	var typedWarnings []error
+
+	a.cfg, typedWarnings = newConfig(cfg)
+	warnings = append(warnings, typedWarnings...)
+	warnings = append(warnings, unknownConfigKeys(cfg)...)
+
 	a.setupLogger()
 
 	if err != nil {
@@ -146,31 +227,61 @@ func (a *agent) init(configFiles []string) (ok bool) {
 		logger.Printf("Warning while loading configuration: %v", w)
 	}
 
-	a.state, err = state.Load(a.config.String("agent.state_file"))
+	proxy.Configure(
+		a.cfg.Proxy.HTTPProxy,
+		a.cfg.Proxy.HTTPSProxy,
+		a.cfg.Proxy.NoProxy,
+	)
+
+	a.state, err = state.Load(a.cfg.Agent.StateFile)
 	if err != nil {
 		logger.Printf("Error while loading state file: %v", err)
 		return false
 	}
 
+	a.state.SetBackupCount(a.cfg.Agent.StateBackupCount)
+	if err := a.setupStateEncryption(); err != nil {
+		logger.Printf("Unable to setup the state encryption, refusing to start: %v", err)
+		return false
+	}
+
 	a.migrateState()
 
+	if cacheFile := a.cfg.Agent.CacheFile; cacheFile != "" {
+		// Those keys are caches which could be rebuilt. They change often and
+		// must not risk the registration information.
+		cacheKeys := []string{"CacheBleemeoConnector", "CacheStatusState", "DiscoveredServices", audit.StateKey}
+		interval := a.cfg.Agent.CacheSaveInterval
+
+		if err := a.state.SetCache(cacheFile, cacheKeys, interval); err != nil {
+			logger.Printf("Unable to use the cache file %s: %v", cacheFile, err)
+		}
+	}
+
+	if err := a.state.SetCompression(a.cfg.Agent.StateCompression.Enabled); err != nil {
+		logger.Printf("Unable to change the state file compression: %v", err)
+	}
+
 	if err := a.state.Save(); err != nil {
 		logger.Printf("State file is not writable, stopping agent: %v", err)
 		return false
 	}
 
+	a.auditLog = audit.New(a.state, audit.DefaultSize)
+
 	return true
 }
 
 func (a *agent) setupLogger() {
 	logger.SetBufferCapacity(
-		a.config.Int("logging.buffer.head_size"),
-		a.config.Int("logging.buffer.tail_size"),
+		a.cfg.Logging.Buffer.HeadSize,
+		a.cfg.Logging.Buffer.TailSize,
 	)
+	logger.SetRingSize(a.cfg.Logging.Buffer.Entries)
 
 	var err error
 
-	switch a.config.String("logging.output") {
+	switch a.cfg.Logging.Output {
 	case "syslog":
 		err = logger.UseSyslog()
 	case "file":
@@ -178,7 +289,7 @@ func (a *agent) setupLogger() {
 	}
 
 	if err != nil {
-		fmt.Printf("Unable to use logging backend '%s': %v\n", a.config.String("logging.output"), err)
+		fmt.Printf("Unable to use logging backend '%s': %v\n", a.cfg.Logging.Output, err)
 	}
 
 	if level := a.config.Int("logging.level"); level != 0 {
@@ -197,7 +308,7 @@ func (a *agent) setupLogger() {
 		}
 	}
 
-	logger.SetPkgLevels(a.config.String("logging.package_levels"))
+	logger.SetPkgLevels(a.cfg.Logging.PackageLevels)
 }
 
 // Run runs Glouton.
@@ -268,11 +379,23 @@ func (a *agent) BleemeoConnected() bool {
 	return a.bleemeoConnector.Connected()
 }
 
+// BleemeoScheduleFullSync requests a full synchronization with Bleemeo.
+// It returns false if the Bleemeo connector is disabled.
+func (a *agent) BleemeoScheduleFullSync() bool {
+	if a.bleemeoConnector == nil {
+		return false
+	}
+
+	a.bleemeoConnector.ScheduleFullSync()
+
+	return true
+}
+
 // Tags returns tags of this Agent.
 func (a *agent) Tags() []string {
 	tagsSet := make(map[string]bool)
 
-	for _, t := range a.config.StringList("tags") {
+	for _, t := range a.cfg.Tags {
 		tagsSet[t] = true
 	}
 
@@ -291,6 +414,28 @@ func (a *agent) Tags() []string {
 	return tags
 }
 
+// globalLabels returns the labels added to all points: the labels of metric.global_labels
+// and the facts of metric.fact_labels. Facts which are unknown or empty are skipped.
+func (a *agent) globalLabels(factsMap map[string]string) map[string]string {
+	result := make(map[string]string, len(a.cfg.Metric.GlobalLabels)+len(a.cfg.Metric.FactLabels))
+
+	for name, value := range a.cfg.Metric.GlobalLabels {
+		result[name] = value
+	}
+
+	for name, fact := range a.cfg.Metric.FactLabels {
+		if value := factsMap[fact]; value != "" {
+			result[name] = value
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
+
 // UpdateThresholds update the thresholds definition.
 // This method will merge with threshold definition present in configuration file.
 func (a *agent) UpdateThresholds(thresholds map[threshold.MetricNameItem]threshold.Threshold, firstUpdate bool) {
@@ -321,6 +466,78 @@ func (a *agent) updateMetricResolution(resolution time.Duration) {
 	}
 }
 
+// updateUnits set the units from the configuration. They apply to any item of the metric
+// and are used when the Bleemeo connector is disabled or doesn't define a unit for the metric.
+func (a *agent) updateUnits() {
+	rawValue, ok := a.config.Get("units")
+	if !ok {
+		return
+	}
+
+	rawUnits, ok := rawValue.(map[string]interface{})
+	if !ok {
+		logger.V(1).Printf("Units in configuration file is not map")
+
+		return
+	}
+
+	configUnits := make(map[string]threshold.Unit, len(rawUnits))
+
+	for k, v := range rawUnits {
+		v2, ok := v.(map[string]interface{})
+		if !ok {
+			logger.V(1).Printf("Units in configuration file is not well-formated: %v value is not a map", k)
+
+			continue
+		}
+
+		unit, err := threshold.UnitFromInterfaceMap(v2)
+		if err != nil {
+			logger.V(1).Printf("Units in configuration file is not well-formated: %s: %v", k, err)
+
+			continue
+		}
+
+		configUnits[k] = unit
+	}
+
+	a.threshold.SetUnitsAllItem(configUnits)
+}
+
+// tcpServices returns the active services listening on the host network with their TCP ports.
+func (a *agent) tcpServices() []tcpconn.Service {
+	services, err := a.discovery.Discovery(a.context, 2*time.Hour)
+	if err != nil {
+		logger.V(2).Printf("Unable to get the services for the TCP connections: %v", err)
+
+		return nil
+	}
+
+	result := make([]tcpconn.Service, 0, len(services))
+
+	for _, service := range services {
+		if !service.Active || service.ContainerID != "" {
+			continue
+		}
+
+		var addresses []net.TCPAddr
+
+		for _, address := range service.ListenAddresses {
+			ip := net.ParseIP(address.Address)
+
+			if address.NetworkFamily == "tcp" && ip != nil {
+				addresses = append(addresses, net.TCPAddr{IP: ip, Port: address.Port})
+			}
+		}
+
+		if len(addresses) > 0 {
+			result = append(result, tcpconn.Service{Name: service.Name, ListenAddresses: addresses})
+		}
+	}
+
+	return result
+}
+
 func (a *agent) updateThresholds(thresholds map[threshold.MetricNameItem]threshold.Threshold, firstUpdate bool) {
 	rawValue, ok := a.config.Get("thresholds")
 	if !ok {
@@ -361,6 +578,8 @@ func (a *agent) updateThresholds(thresholds map[threshold.MetricNameItem]thresho
 		configThreshold[k] = t
 	}
 
+	a.auditThresholdsChange(thresholds, firstUpdate)
+
 	oldThresholds := map[string]threshold.Threshold{
 		"system_pending_updates":          {},
 		"system_pending_security_updates": {},
@@ -373,6 +592,21 @@ func (a *agent) updateThresholds(thresholds map[threshold.MetricNameItem]thresho
 		oldThresholds[name] = a.threshold.GetThreshold(key)
 	}
 
+	if len(a.processCheckThresholds) > 0 {
+		withChecks := make(map[threshold.MetricNameItem]threshold.Threshold, len(thresholds)+len(a.processCheckThresholds))
+
+		for k, v := range a.processCheckThresholds {
+			withChecks[k] = v
+		}
+
+		// Thresholds from Bleemeo take precedence over the process checks.
+		for k, v := range thresholds {
+			withChecks[k] = v
+		}
+
+		thresholds = withChecks
+	}
+
 	a.threshold.SetThresholds(thresholds, configThreshold)
 
 	for name := range oldThresholds {
@@ -388,6 +622,75 @@ func (a *agent) updateThresholds(thresholds map[threshold.MetricNameItem]thresho
 	}
 }
 
+// auditThresholdsChange records in the audit log the thresholds from the Bleemeo API which changed.
+func (a *agent) auditThresholdsChange(thresholds map[threshold.MetricNameItem]threshold.Threshold, firstUpdate bool) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	previous := a.bleemeoThresholds
+	a.bleemeoThresholds = thresholds
+
+	if firstUpdate {
+		return
+	}
+
+	var added, updated, removed int
+
+	// Metrics without threshold are ignored, they are added and removed with the metrics.
+	for key, t := range thresholds {
+		old := previous[key]
+
+		switch {
+		case t.IsZero():
+		case old.IsZero():
+			added++
+		case !old.Equal(t):
+			updated++
+		}
+	}
+
+	for key, old := range previous {
+		if !old.IsZero() && thresholds[key].IsZero() {
+			removed++
+		}
+	}
+
+	if added+updated+removed > 0 {
+		a.auditLog.Record(
+			audit.KindThreshold,
+			"Thresholds updated from the Bleemeo API: %d added, %d updated, %d removed",
+			added, updated, removed,
+		)
+	}
+}
+
+// anonymizeSalt return the salt used to anonymize data sent to Bleemeo.
+// When not configured, a random salt is generated once and kept in the state.
+func (a *agent) anonymizeSalt() string {
+	salt := a.cfg.Bleemeo.Anonymize.Salt
+	if salt != "" {
+		return salt
+	}
+
+	if err := a.state.Get("anonymize_salt", &salt); err == nil && salt != "" {
+		return salt
+	}
+
+	buffer := make([]byte, 32)
+
+	if _, err := cryptoRand.Read(buffer); err != nil {
+		logger.Printf("Unable to generate the anonymization salt: %v", err)
+	}
+
+	salt = hex.EncodeToString(buffer)
+
+	if err := a.state.Set("anonymize_salt", salt); err != nil {
+		logger.Printf("Unable to save the anonymization salt, anonymized values will change on restart: %v", err)
+	}
+
+	return salt
+}
+
 // Run will start the agent. It will terminate when sigquit/sigterm/sigint is received.
 func (a *agent) run() { //nolint:gocyclo
 	ctx, cancel := context.WithCancel(context.Background())
@@ -398,8 +701,8 @@ func (a *agent) run() { //nolint:gocyclo
 	a.hostRootPath = "/"
 	a.context = ctx
 
-	if a.config.String("container.type") != "" {
-		a.hostRootPath = a.config.String("df.host_mount_point")
+	if a.cfg.Container.Type != "" {
+		a.hostRootPath = a.cfg.DF.HostMountPoint
 		setupContainer(a.hostRootPath)
 	}
 
@@ -408,9 +711,9 @@ func (a *agent) run() { //nolint:gocyclo
 		10*time.Second,
 	)
 	a.factProvider = facts.NewFacter(
-		a.config.String("agent.facts_file"),
+		a.cfg.Agent.FactsFile,
 		a.hostRootPath,
-		a.config.String("agent.public_ip_indicator"),
+		a.cfg.Agent.PublicIPIndicator,
 	)
 
 	factsMap, err := a.factProvider.Facts(ctx, 0)
@@ -423,7 +726,7 @@ func (a *agent) run() { //nolint:gocyclo
 		fqdn = "localhost"
 	}
 
-	cloudImageFile := a.config.String("agent.cloudimage_creation_file")
+	cloudImageFile := a.cfg.Agent.CloudImageCreationFile
 
 	content, err := ioutil.ReadFile(cloudImageFile)
 	if err != nil && !os.IsNotExist(err) {
@@ -446,19 +749,19 @@ func (a *agent) run() { //nolint:gocyclo
 
 	logger.Printf("Starting agent version %v (commit %v)", version.Version, version.BuildHash)
 
-	_ = os.Remove(a.config.String("agent.upgrade_file"))
+	_ = os.Remove(a.cfg.Agent.UpgradeFile)
 
-	a.metricFormat = types.StringToMetricFormat(a.config.String("agent.metrics_format"))
+	a.metricFormat = types.StringToMetricFormat(a.cfg.Agent.MetricsFormat)
 	if a.metricFormat == types.MetricFormatUnknown {
-		logger.Printf("Invalid metric format %#v. Supported option are \"Bleemeo\" and \"Prometheus\". Falling back to Bleemeo", a.config.String("agent.metrics_format"))
+		logger.Printf("Invalid metric format %#v. Supported option are \"Bleemeo\" and \"Prometheus\". Falling back to Bleemeo", a.cfg.Agent.MetricsFormat)
 		a.metricFormat = types.MetricFormatBleemeo
 	}
 
-	apiBindAddress := fmt.Sprintf("%s:%d", a.config.String("web.listener.address"), a.config.Int("web.listener.port"))
+	apiBindAddress := fmt.Sprintf("%s:%d", a.cfg.Web.Listener.Address, a.cfg.Web.Listener.Port)
 
-	if a.config.Bool("agent.http_debug.enabled") {
+	if a.cfg.Agent.HTTPDebug.Enabled {
 		go func() {
-			debugAddress := a.config.String("agent.http_debug.bind_address")
+			debugAddress := a.cfg.Agent.HTTPDebug.BindAddress
 
 			logger.Printf("Starting debug server on http://%s/debug/pprof/", debugAddress)
 			log.Println(http.ListenAndServe(debugAddress, nil))
@@ -466,22 +769,45 @@ func (a *agent) run() { //nolint:gocyclo
 	}
 
 	a.store = store.New()
+	a.store.SetRetention(a.cfg.Metric.StoreRetention)
+	a.store.SetLimits(a.cfg.Metric.MaxSeries, a.cfg.Metric.MaxSeriesPerMetric)
+
+	if a.cfg.Metric.PersistFile != "" {
+		if err := a.store.Load(a.cfg.Metric.PersistFile); err != nil {
+			logger.Printf("Unable to load the points saved before the restart: %v", err)
+		}
+	}
+
 	a.gathererRegistry = &registry.Registry{
-		PushPoint:      a.store,
-		FQDN:           fqdn,
-		BleemeoAgentID: a.BleemeoAgentID(),
-		GloutonPort:    strconv.FormatInt(int64(a.config.Int("web.listener.port")), 10),
-		MetricFormat:   a.metricFormat,
+		PushPoint:       a.store,
+		FQDN:            fqdn,
+		BleemeoAgentID:  a.BleemeoAgentID(),
+		GloutonPort:     strconv.FormatInt(int64(a.cfg.Web.Listener.Port), 10),
+		MetricFormat:    a.metricFormat,
+		AlignTimestamps: a.cfg.Metric.AlignTimestamps,
+		GlobalLabels:    a.globalLabels(factsMap),
+	}
+
+	if relabelConfigs, err := registry.ParseRelabelConfigs(a.cfg.Metric.RelabelConfigs); err != nil {
+		logger.Printf("Ignoring invalid metric.relabel_configs config: %v", err)
+	} else {
+		a.gathererRegistry.MetricRelabelConfigs = relabelConfigs
+	}
+
+	if file, address := a.cfg.Agent.HeartbeatFile, a.cfg.Agent.HeartbeatUDP; file != "" || address != "" {
+		hb := &heartbeat{file: file, udpAddress: address, fqdn: fqdn}
+		a.gathererRegistry.CollectionDone = hb.beat
 	}
+
 	a.threshold = threshold.New(a.state)
 	acc := &inputs.Accumulator{Pusher: a.threshold.WithPusher(a.gathererRegistry.WithTTL(5 * time.Minute))}
 
 	var kubernetesProvider *facts.KubernetesProvider
 
-	if a.config.Bool("kubernetes.enabled") {
+	if a.cfg.Kubernetes.Enabled {
 		kubernetesProvider = &facts.KubernetesProvider{
-			NodeName:   a.config.String("kubernetes.nodename"),
-			KubeConfig: a.config.String("kubernetes.kubeconfig"),
+			NodeName:   a.cfg.Kubernetes.NodeName,
+			KubeConfig: a.cfg.Kubernetes.KubeConfig,
 		}
 
 		_, err := kubernetesProvider.PODs(ctx, 0)
@@ -491,12 +817,24 @@ func (a *agent) run() { //nolint:gocyclo
 	}
 
 	a.dockerFact = facts.NewDocker(a.deletedContainersCallback, kubernetesProvider)
+	a.dockerFact.SetChurnProtection(
+		a.cfg.Container.Churn.Threshold,
+		a.cfg.Container.Churn.EphemeralAge,
+	)
+	a.dockerFact.SetRestartLoopDetection(
+		a.cfg.Container.RestartLoop.Count,
+		a.cfg.Container.RestartLoop.Period,
+	)
+	a.dockerHealth = check.NewDockerHealth(
+		a.cfg.Container.Health.SoftPeriod,
+		a.gathererRegistry.WithTTL(5*time.Minute),
+	)
 
 	var (
 		psLister facts.ProcessLister
 	)
 
-	useProc := a.config.String("container.type") == "" || a.config.Bool("container.pid_namespace_host")
+	useProc := a.cfg.Container.Type == "" || a.cfg.Container.PIDNamespaceHost
 	if !useProc {
 		logger.V(1).Printf("The agent is running in a container and \"container.pid_namespace_host\", is not true. Not all processes will be seen")
 	} else {
@@ -512,29 +850,116 @@ func (a *agent) run() { //nolint:gocyclo
 		a.hostRootPath,
 		a.dockerFact,
 	)
-	netstat := &facts.NetstatProvider{FilePath: a.config.String("agent.netstat_file")}
+	netstat := &facts.NetstatProvider{FilePath: a.cfg.Agent.NetstatFile, HostRootPath: a.hostRootPath}
 
 	a.factProvider.AddCallback(a.dockerFact.DockerFact)
-	a.factProvider.SetFact("installation_format", a.config.String("agent.installation_format"))
+
+	if a.cfg.PackageInventory.Enabled {
+		a.packageInventory = &facts.PackageInventory{
+			HostRootPath: a.hostRootPath,
+			InContainer:  a.cfg.Container.Type != "",
+			SendList:     a.cfg.PackageInventory.SendList,
+		}
+		a.factProvider.AddCallback(a.packageInventory.PackageFacts)
+	}
+
+	a.factProvider.SetFact("installation_format", a.cfg.Agent.InstallationFormat)
+
+	if rawChecks, ok := a.config.Get("process_checks"); ok {
+		checks, err := processInput.ParseChecks(rawChecks)
+		if err != nil {
+			logger.Printf("Invalid process_checks, process checks are disabled: %v", err)
+		} else if len(checks) > 0 {
+			a.processCheckThresholds = processInput.ChecksThresholds(checks)
+
+			checksInput := processInput.NewChecks(psFact, checks, a.threshold.WithPusher(a.gathererRegistry.WithTTL(5*time.Minute)))
+			a.gathererRegistry.AddPushPointsCallback(checksInput.Gather)
+		}
+	}
 
 	processInput := processInput.New(psFact, a.threshold.WithPusher(a.gathererRegistry.WithTTL(5*time.Minute)))
 
 	a.collector = collector.New(acc)
+	a.collector.SetGatherLimits(
+		a.cfg.Metric.GatherWorkers,
+		a.cfg.Metric.GatherTimeout,
+	)
 	a.gathererRegistry.AddPushPointsCallback(a.collector.RunGather)
 
 	if a.metricFormat == types.MetricFormatBleemeo {
 		a.gathererRegistry.AddPushPointsCallback(processInput.Gather)
 	}
 
+	var timeDriftInput *timesync.Input
+
+	if a.cfg.Agent.TimeDrift.Enabled {
+		timeDriftInput = timesync.New(
+			a.cfg.Agent.TimeDrift.Servers,
+			a.threshold.WithPusher(a.gathererRegistry.WithTTL(5*time.Minute)),
+		)
+		a.gathererRegistry.AddPushPointsCallback(timeDriftInput.Gather)
+	}
+
+	if a.cfg.Agent.Sessions.Enabled {
+		sessionsInput := sessions.New(
+			a.hostRootPath,
+			a.threshold.WithPusher(a.gathererRegistry.WithTTL(5*time.Minute)),
+		)
+		a.gathererRegistry.AddPushPointsCallback(sessionsInput.Gather)
+	}
+
+	if runtime.GOOS == "linux" && a.cfg.Agent.Saturation.Enabled {
+		saturationInput := saturation.New(
+			a.hostRootPath,
+			a.cfg.Agent.Saturation.PerCoreCPU,
+			a.threshold.WithPusher(a.gathererRegistry.WithTTL(5*time.Minute)),
+		)
+		a.gathererRegistry.AddPushPointsCallback(saturationInput.Gather)
+	}
+
+	if a.cfg.Agent.IPMI.Enabled {
+		ipmiInput := ipmi.New(
+			a.cfg.Agent.IPMI.Timeout,
+			a.threshold.WithPusher(a.gathererRegistry.WithTTL(5*time.Minute)),
+		)
+		a.gathererRegistry.AddPushPointsCallback(ipmiInput.Gather)
+	}
+
 	services, _ := a.config.Get("service")
+	servicesIgnore, _ := a.config.Get("service_ignore")
 	servicesIgnoreCheck, _ := a.config.Get("service_ignore_check")
 	servicesIgnoreMetrics, _ := a.config.Get("service_ignore_metrics")
 	overrideServices := confFieldToSliceMap(services, "service override")
+	serviceIgnore := confFieldToSliceMap(servicesIgnore, "service ignore")
 	serviceIgnoreCheck := confFieldToSliceMap(servicesIgnoreCheck, "service ignore check")
 	serviceIgnoreMetrics := confFieldToSliceMap(servicesIgnoreMetrics, "service ignore metrics")
 	isCheckIgnored := discovery.NewIgnoredService(serviceIgnoreCheck).IsServiceIgnored
 	isInputIgnored := discovery.NewIgnoredService(serviceIgnoreMetrics).IsServiceIgnored
-	dynamicDiscovery := discovery.NewDynamic(psFact, netstat, a.dockerFact, discovery.SudoFileReader{HostRootPath: a.hostRootPath}, a.config.String("stack"))
+	a.checkPools = check.NewPools(checkPoolSizes(a.config.Get("check.pools")))
+
+	if rawChecks, ok := a.config.Get("file_checks"); ok {
+		fileChecks, err := check.ParseFileChecks(rawChecks)
+		if err != nil {
+			logger.Printf("Invalid file_checks, file checks are disabled: %v", err)
+		}
+
+		for _, cfg := range fileChecks {
+			fileCheck := check.NewFile(
+				cfg,
+				map[string]string{types.LabelName: "file_check_status", "check": cfg.ID},
+				types.MetricAnnotations{BleemeoItem: cfg.ID},
+				acc,
+			)
+			fileCheck.SetPool(a.checkPools.Get(check.PoolLocal))
+
+			if _, err := a.taskRegistry.AddTask(fileCheck.Run, fmt.Sprintf("file check for %s", cfg.ID)); err != nil {
+				logger.V(1).Printf("Unable to add file check: %v", err)
+			}
+		}
+	}
+	dynamicDiscovery := discovery.NewDynamic(psFact, netstat, a.dockerFact, discovery.SudoFileReader{HostRootPath: a.hostRootPath}, a.cfg.Stack)
+	dynamicDiscovery.SetGroupReplicas(a.cfg.Container.GroupReplicas)
+
 	a.discovery = discovery.New(
 		dynamicDiscovery,
 		a.collector,
@@ -547,7 +972,19 @@ func (a *agent) run() { //nolint:gocyclo
 		isCheckIgnored,
 		isInputIgnored,
 		a.metricFormat,
+		a.checkPools,
 	)
+	a.discovery.SetServiceIgnored(discovery.NewIgnoredService(serviceIgnore).IsServiceIgnored)
+	a.discovery.SetAuditLog(a.auditLog)
+
+	if runtime.GOOS == "linux" && a.cfg.Agent.ServiceTCP.Enabled {
+		tcpInput := tcpconn.New(
+			a.hostRootPath,
+			a.tcpServices,
+			a.threshold.WithPusher(a.gathererRegistry.WithTTL(5*time.Minute)),
+		)
+		a.gathererRegistry.AddPushPointsCallback(tcpInput.Gather)
+	}
 
 	var targets map[string]string
 
@@ -573,6 +1010,10 @@ func (a *agent) run() { //nolint:gocyclo
 		}
 	}
 
+	if jobsCfg, found := a.config.Get("metric.scrape_jobs"); found {
+		a.registerScrapeJobs(jobsCfg)
+	}
+
 	a.gathererRegistry.AddDefaultCollector()
 
 	if _, found := a.config.Get("metric.pull"); found {
@@ -587,7 +1028,7 @@ func (a *agent) run() { //nolint:gocyclo
 
 	var monitorManager *blackbox.RegisterManager
 
-	if a.config.Bool("blackbox.enabled") {
+	if a.cfg.Blackbox.Enabled {
 		logger.V(1).Println("Starting blackbox_exporter...")
 		// the config is present, otherwise we would not be in this block
 		blackboxConf, _ := a.config.Get("blackbox")
@@ -602,10 +1043,37 @@ func (a *agent) run() { //nolint:gocyclo
 
 	promExporter := a.gathererRegistry.Exporter()
 
-	if a.config.Bool("agent.process_exporter.enabled") {
+	passiveChecksConfig, _ := a.config.Get("passive_check")
+	passiveChecks := passiveChecksFromConfig(confFieldToSliceMap(passiveChecksConfig, "passive check"), acc)
+
+	selfMetrics := selfmetrics.New()
+	selfMetrics.Store = a.store
+	selfMetrics.Inputs = a.collector
+	selfMetrics.Discovery = a.discovery
+	selfMetrics.Tasks = a.taskRegistry
+	selfMetrics.State = a.state
+	selfMetrics.Checks = a.checkPools
+
+	if a.cfg.Agent.ProcessExporter.Enabled {
 		process.RegisterExporter(a.gathererRegistry, psLister, dynamicDiscovery, a.metricFormat == types.MetricFormatBleemeo)
 	}
 
+	if a.cfg.Relay.Enabled {
+		relayServer, err := relay.New(relay.Option{
+			Config:             a.config,
+			Tokens:             a.cfg.Relay.Tokens,
+			MaxPendingMessages: a.cfg.Relay.MaxPendingMessages,
+			ListenAddress:      net.JoinHostPort(a.cfg.Relay.Listener.Address, strconv.Itoa(a.cfg.Relay.Listener.Port)),
+			CertFile:           a.cfg.Relay.TLS.CertFile,
+			KeyFile:            a.cfg.Relay.TLS.KeyFile,
+		})
+		if err != nil {
+			logger.Printf("The relay is disabled: %v", err)
+		} else {
+			a.relay = relayServer
+		}
+	}
+
 	api := &api.API{
 		DB:                 a.store,
 		DockerFact:         a.dockerFact,
@@ -616,16 +1084,38 @@ func (a *agent) run() { //nolint:gocyclo
 		AgentInfo:          a,
 		PrometheurExporter: promExporter,
 		Threshold:          a.threshold,
-		StaticCDNURL:       a.config.String("web.static_cdn_url"),
+		StaticCDNURL:       a.cfg.Web.StaticCDNURL,
 		DiagnosticPage:     a.DiagnosticPage,
 		DiagnosticZip:      a.DiagnosticZip,
+		RequestsCounter:    selfMetrics.APIRequests,
+		PassiveChecks:      passiveChecks,
+		Tasks:              a.taskRegistry,
+		Inputs:             a.collector,
+		Gatherers:          a.gathererRegistry,
+		ConfigSources:      a.config.Sources,
+		ConfigDump:         a.redactedConfig,
+		AuditLog:           a.auditLog,
+		PointsHub:          api.NewPointsHub(),
+		Auth: &api.Authenticator{
+			StaticTokens: a.cfg.Web.Auth.Tokens,
+			Issuer:       a.cfg.Web.Auth.OIDC.Issuer,
+			Audience:     a.cfg.Web.Auth.OIDC.Audience,
+		},
+	}
+
+	if a.packageInventory != nil {
+		api.Packages = a.packageInventory
 	}
 
+	a.store.AddNotifiee(api.PointsHub.PushPoints)
+	a.threshold.AddStatusNotifiee(api.PointsHub.OnStatusChanges)
+
 	a.FireTrigger(true, true, false, false)
 
 	tasks := []taskInfo{
 		{a.watchdog, "Agent Watchdog"},
 		{a.store.Run, "Metric store"},
+		{a.state.Run, "State cache"},
 		{a.triggerHandler.Run, "Internal trigger handler"},
 		{a.dockerFact.Run, "Docker connector"},
 		{api.Run, "Local Web UI"},
@@ -633,36 +1123,136 @@ func (a *agent) run() { //nolint:gocyclo
 		{a.hourlyDiscovery, "Service Discovery"},
 		{a.dailyFact, "Facts gatherer"},
 		{a.dockerWatcher, "Docker event watcher"},
-		{a.netstatWatcher, "Netstat file watcher"},
 		{a.miscTasks, "Miscelanous tasks"},
 		{a.minuteMetric, "Metrics every minute"},
 	}
 
-	if a.config.Bool("jmx.enabled") {
-		perm, err := strconv.ParseInt(a.config.String("jmxtrans.file_permission"), 8, 0)
+	if a.relay != nil {
+		tasks = append(tasks, taskInfo{a.relay.Run, "Bleemeo relay"})
+	}
+
+	if timeDriftInput != nil {
+		tasks = append(tasks, taskInfo{timeDriftInput.Run, "Local clock offset measure"})
+	}
+
+	if a.cfg.Discovery.WatchNetstat {
+		tasks = append(tasks, taskInfo{a.netstatWatcher, "Netstat file watcher"})
+	}
+
+	if a.cfg.Metric.PersistFile != "" {
+		tasks = append(tasks, taskInfo{a.persistStore, "Metric store persistence"})
+	}
+
+	for name, c := range passiveChecks {
+		tasks = append(tasks, taskInfo{c.Run, fmt.Sprintf("passive check for %s", name)})
+	}
+
+	notifierConfig, _ := a.config.Get("notification.channels")
+	if channels := notificationChannelsFromConfig(confFieldToSliceMap(notifierConfig, "notification channel")); len(channels) > 0 {
+		localNotifier := notifier.New(
+			channels,
+			a.cfg.Notification.RenotifyInterval,
+			a.cfg.Notification.SendResolved,
+		)
+		a.threshold.AddStatusNotifiee(localNotifier.OnStatusChanges)
+
+		tasks = append(tasks, taskInfo{localNotifier.Run, "Local notifications"})
+	}
+
+	if a.cfg.TLSScan.Enabled {
+		tasks = append(tasks, taskInfo{a.tlsScan, "TLS endpoints scan"})
+	}
+
+	if a.cfg.Remediation.Enabled {
+		hooksConfig, _ := a.config.Get("remediation.hooks")
+		hooks := remediationHooksFromConfig(confFieldToSliceMap(hooksConfig, "remediation hook"))
+
+		for _, h := range hooks {
+			logger.V(1).Printf("Remediation hook enabled for %v", h)
+		}
+
+		remediationManager := remediation.New(hooks)
+		a.threshold.AddStatusNotifiee(remediationManager.OnStatusChanges)
+
+		tasks = append(tasks, taskInfo{remediationManager.Run, "Remediation hooks"})
+	}
+
+	if ruleFiles := a.cfg.Rules.Files; len(ruleFiles) > 0 {
+		rulesManager, err := rules.New(
+			ruleFiles,
+			a.cfg.Rules.EvaluationInterval,
+			a.store,
+			a.threshold.WithPusher(a.gathererRegistry.WithTTL(5*time.Minute)),
+		)
+		if err != nil {
+			logger.Printf("Unable to load rule files, rules are disabled: %v", err)
+		} else {
+			logger.V(1).Printf("Loaded %d recording and alerting rules", rulesManager.RulesCount())
+
+			tasks = append(tasks, taskInfo{rulesManager.Run, "Rules evaluation"})
+		}
+	}
+
+	if a.cfg.JMX.Enabled {
+		perm, err := strconv.ParseInt(a.cfg.JMXTrans.FilePermission, 8, 0)
 		if err != nil {
-			logger.Printf("invalid permission %#v: %v", a.config.String("jmxtrans.file_permission"), err)
+			logger.Printf("invalid permission %#v: %v", a.cfg.JMXTrans.FilePermission, err)
 			logger.Printf("using the default 0640")
 
 			perm = 0640
 		}
 
 		a.jmx = &jmxtrans.JMX{
-			OutputConfigurationFile:       a.config.String("jmxtrans.config_file"),
+			OutputConfigurationFile:       a.cfg.JMXTrans.ConfigFile,
 			OutputConfigurationPermission: os.FileMode(perm),
-			ContactPort:                   a.config.Int("jmxtrans.graphite_port"),
+			ContactPort:                   a.cfg.JMXTrans.GraphitePort,
 			Pusher:                        a.threshold.WithPusher(a.gathererRegistry.WithTTL(5 * time.Minute)),
 		}
 
 		tasks = append(tasks, taskInfo{a.jmx.Run, "jmxtrans"})
 	}
 
-	if a.config.Bool("bleemeo.enabled") {
+	var runAction func(ctx context.Context, name string) (string, error)
+
+	if a.cfg.Actions.Enabled {
+		commandsConfig, _ := a.config.Get("actions.commands")
+		actionList := actionsFromConfig(confFieldToSliceMap(commandsConfig, "action"))
+
+		for _, action := range actionList {
+			logger.V(1).Printf("Action defined for %v", action)
+		}
+
+		if !a.cfg.Bleemeo.RemoteCommands.Enabled {
+			logger.Printf("Actions are enabled but bleemeo.remote_commands.enabled is false, Bleemeo can't trigger them")
+		}
+
+		actionRegistry := actions.New(actionList)
+		runAction = actionRegistry.Run
+
+		// Let Bleemeo know which actions could be triggered.
+		a.factProvider.SetFact("remote_actions", strings.Join(actionRegistry.Names(), ","))
+	}
+
+	if a.cfg.Bleemeo.Enabled {
+		var (
+			bleemeoFacts   bleemeoTypes.FactProvider    = a.factProvider
+			bleemeoProcess bleemeoTypes.ProcessProvider = psFact
+		)
+
+		// Only data sent to Bleemeo are anonymized, the local API keep the real values.
+		if a.cfg.Bleemeo.Anonymize.Enabled {
+			anonymizer := facts.NewAnonymizer(a.anonymizeSalt())
+			bleemeoFacts = facts.AnonymizedFactProvider{Provider: a.factProvider, Anonymizer: anonymizer}
+			bleemeoProcess = facts.AnonymizedProcessProvider{Provider: psFact, Anonymizer: anonymizer}
+
+			logger.V(1).Printf("Anonymization of hostnames, usernames and command lines sent to Bleemeo is enabled")
+		}
+
 		a.bleemeoConnector = bleemeo.New(bleemeoTypes.GlobalOption{
 			Config:                  a.config,
 			State:                   a.state,
-			Facts:                   a.factProvider,
-			Process:                 psFact,
+			Facts:                   bleemeoFacts,
+			Process:                 bleemeoProcess,
 			Docker:                  a.dockerFact,
 			Store:                   a.store,
 			Acc:                     acc,
@@ -673,48 +1263,108 @@ func (a *agent) run() { //nolint:gocyclo
 			UpdateUnits:             a.threshold.SetUnits,
 			MetricFormat:            a.metricFormat,
 			NotifyFirstRegistration: a.notifyBleemeoFirstRegistration,
+			TriggerDiscovery: func() {
+				a.FireTrigger(true, true, false, false)
+			},
+			DiagnosticZip: a.DiagnosticZip,
+			RunAction:     runAction,
+			AuditLog:      a.auditLog,
 		})
 		a.gathererRegistry.UpdateBleemeoAgentID(ctx, a.BleemeoAgentID())
 		tasks = append(tasks, taskInfo{a.bleemeoConnector.Run, "Bleemeo SAAS connector"})
 
+		selfMetrics.MQTT = a.bleemeoConnector
+
 		if a.metricFormat == types.MetricFormatPrometheus {
 			logger.Printf("Prometheus format is not yet supported with Bleemeo")
 			return
 		}
 	}
 
-	if a.config.Bool("nrpe.enabled") {
-		nrpeConfFile := a.config.StringList("nrpe.conf_paths")
-		nrperesponse := nrpe.NewResponse(overrideServices, a.discovery, nrpeConfFile)
-		server := nrpe.New(
-			fmt.Sprintf("%s:%d", a.config.String("nrpe.address"), a.config.Int("nrpe.port")),
-			a.config.Bool("nrpe.ssl"),
-			nrperesponse.Response,
-		)
-		tasks = append(tasks, taskInfo{server.Run, "NRPE server"})
+	if a.cfg.NRPE.Enabled {
+		nrpeConfFile := a.cfg.NRPE.ConfPaths
+		nrperesponse := nrpe.NewResponse(overrideServices, a.discovery, nrpeConfFile, metricquery.New(a.store, a.threshold))
+
+		allowedNetworks, err := nrpe.ParseAllowedHosts(a.cfg.NRPE.AllowedHosts)
+		if err != nil {
+			logger.Printf("NRPE server disabled: %v", err)
+		} else {
+			server := nrpe.New(
+				fmt.Sprintf("%s:%d", a.cfg.NRPE.Address, a.cfg.NRPE.Port),
+				a.cfg.NRPE.SSL,
+				allowedNetworks,
+				nrperesponse.Response,
+			)
+			tasks = append(tasks, taskInfo{server.Run, "NRPE server"})
+		}
 	}
 
-	if a.config.Bool("zabbix.enabled") {
-		server := zabbix.New(
-			fmt.Sprintf("%s:%d", a.config.String("zabbix.address"), a.config.Int("zabbix.port")),
-			zabbixResponse,
+	if a.cfg.Zabbix.Enabled {
+		server, err := zabbix.New(
+			fmt.Sprintf("%s:%d", a.cfg.Zabbix.Address, a.cfg.Zabbix.Port),
+			zabbix.TLSOptions{
+				Accept:            a.cfg.Zabbix.TLS.Accept,
+				CAFile:            a.cfg.Zabbix.TLS.CAFile,
+				CertFile:          a.cfg.Zabbix.TLS.CertFile,
+				KeyFile:           a.cfg.Zabbix.TLS.KeyFile,
+				ServerCertIssuer:  a.cfg.Zabbix.TLS.ServerCertIssuer,
+				ServerCertSubject: a.cfg.Zabbix.TLS.ServerCertSubject,
+				PSKIdentity:       a.cfg.Zabbix.TLS.PSKIdentity,
+				PSKFile:           a.cfg.Zabbix.TLS.PSKFile,
+			},
+			zabbixResponse(metricquery.New(a.store, a.threshold)),
 		)
-		tasks = append(tasks, taskInfo{server.Run, "Zabbix server"})
+		if err != nil {
+			logger.Printf("Zabbix server disabled: %v", err)
+		} else {
+			tasks = append(tasks, taskInfo{server.Run, "Zabbix server"})
+		}
 	}
 
-	if a.config.Bool("influxdb.enabled") {
-		server := influxdb.New(
-			fmt.Sprintf("http://%s:%s", a.config.String("influxdb.host"), a.config.String("influxdb.port")),
-			a.config.String("influxdb.db_name"),
-			a.store,
-			a.config.StringMap("influxdb.tags"),
-		)
-		a.influxdbConnector = server
-		tasks = append(tasks, taskInfo{server.Run, "influxdb"})
+	if a.cfg.InfluxDB.Enabled {
+		switch output := a.cfg.InfluxDB.Output; output {
+		case "v1":
+			server := influxdb.New(
+				fmt.Sprintf("http://%s:%d", a.cfg.InfluxDB.Host, a.cfg.InfluxDB.Port),
+				a.cfg.InfluxDB.DBName,
+				a.store,
+				a.cfg.InfluxDB.Tags,
+			)
+			a.influxdbConnector = server
+			tasks = append(tasks, taskInfo{server.Run, "influxdb"})
+
+			logger.V(2).Printf("Influxdb is activated !")
+		default:
+			address := a.cfg.InfluxDB.URL
+			if address == "" && output == influxdb.OutputUDP {
+				address = fmt.Sprintf("%s:%d", a.cfg.InfluxDB.Host, a.cfg.InfluxDB.Port)
+			} else if address == "" {
+				address = fmt.Sprintf("http://%s:%d", a.cfg.InfluxDB.Host, a.cfg.InfluxDB.Port)
+			}
+
+			writer, err := influxdb.NewWriter(influxdb.WriterOptions{
+				Output:         output,
+				URL:            address,
+				Token:          a.cfg.InfluxDB.Token,
+				Org:            a.cfg.InfluxDB.Org,
+				Bucket:         a.cfg.InfluxDB.Bucket,
+				AdditionalTags: a.cfg.InfluxDB.Tags,
+				Store:          a.store,
+			})
+			if err != nil {
+				logger.Printf("Unable to start the InfluxDB output: %v", err)
+			} else {
+				a.influxdbWriter = writer
+				selfMetrics.InfluxDB = writer
+				tasks = append(tasks, taskInfo{writer.Run, "influxdb"})
 
-		logger.V(2).Printf("Influxdb is activated !")
+				logger.V(2).Printf("InfluxDB %s output is activated", output)
+			}
+		}
 	}
 
+	a.updateUnits()
+
 	if a.bleemeoConnector == nil {
 		a.updateThresholds(nil, true)
 	} else {
@@ -724,12 +1374,28 @@ func (a *agent) run() { //nolint:gocyclo
 	tmp, _ := a.config.Get("metric.softstatus_period")
 
 	a.threshold.SetSoftPeriod(
-		time.Duration(a.config.Int("metric.softstatus_period_default"))*time.Second,
+		a.cfg.Metric.SoftstatusPeriodDefault,
 		softPeriodsFromInterface(tmp),
 	)
+	a.threshold.SetPendingStatusMetric(a.cfg.Metric.PendingStatus)
+	a.threshold.SetStatusMetric(
+		a.cfg.Metric.StatusMetrics,
+		a.cfg.Metric.StatusMetricsIgnore,
+	)
+
+	rulesConfig, _ := a.config.Get("threshold_rules")
+	a.threshold.SetRules(thresholdRulesFromConfig(
+		confFieldToSliceMap(rulesConfig, "threshold rule"),
+		a.cfg.Metric.SoftstatusPeriodDefault,
+	))
+
+	maintenanceConfig, _ := a.config.Get("maintenance")
+	a.threshold.SetMaintenanceWindows(maintenanceWindowsFromConfig(
+		confFieldToSliceMap(maintenanceConfig, "maintenance window"),
+	))
 
-	if !reflect.DeepEqual(a.config.StringList("disk_monitor"), defaultConfig["disk_monitor"]) {
-		if a.metricFormat == types.MetricFormatBleemeo && len(a.config.StringList("disk_ignore")) > 0 {
+	if !reflect.DeepEqual(a.cfg.DiskMonitor, defaultConfig["disk_monitor"]) {
+		if a.metricFormat == types.MetricFormatBleemeo && len(a.cfg.DiskIgnore) > 0 {
 			logger.Printf("Warning: both \"disk_monitor\" and \"disk_ignore\" are set. Only \"disk_ignore\" will be used")
 		} else if a.metricFormat != types.MetricFormatBleemeo {
 			logger.Printf("Warning: configuration \"disk_monitor\" is not used in Prometheus mode. Use \"disk_ignore\"")
@@ -757,8 +1423,8 @@ func (a *agent) run() { //nolint:gocyclo
 		"Metric collector",
 	})
 
-	if a.config.Bool("telegraf.statsd.enabled") {
-		input, err := statsd.New(fmt.Sprintf("%s:%d", a.config.String("telegraf.statsd.address"), a.config.Int("telegraf.statsd.port")))
+	if a.cfg.Telegraf.Statsd.Enabled {
+		input, err := statsd.New(fmt.Sprintf("%s:%d", a.cfg.Telegraf.Statsd.Address, a.cfg.Telegraf.Statsd.Port))
 		if err != nil {
 			logger.Printf("Unable to create StatsD input: %v", err)
 			a.config.Set("telegraf.statsd.enabled", false)
@@ -777,6 +1443,13 @@ func (a *agent) run() { //nolint:gocyclo
 
 	a.factProvider.SetFact("statsd_enabled", a.config.String("telegraf.statsd.enabled"))
 
+	selfMetricsRegistry := prometheus.NewRegistry()
+	selfMetricsRegistry.MustRegister(selfMetrics)
+
+	if _, err := a.gathererRegistry.RegisterGatherer(selfMetricsRegistry, nil, nil); err != nil {
+		logger.Printf("Unable to add Glouton self-metrics: %v", err)
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 
@@ -788,6 +1461,8 @@ func (a *agent) run() { //nolint:gocyclo
 			}
 
 			if s == syscall.SIGHUP {
+				a.auditLog.Record(audit.KindConfig, "SIGHUP received, reloading monitors, facts and services")
+
 				if a.bleemeoConnector != nil {
 					a.bleemeoConnector.UpdateMonitors()
 				}
@@ -797,31 +1472,46 @@ func (a *agent) run() { //nolint:gocyclo
 		}
 	}()
 
+	a.discovery.WarmStart()
 	a.startTasks(tasks)
 
+	if _, err := sdNotify(sdNotifyReady); err != nil {
+		logger.V(1).Printf("Unable to notify systemd: %v", err)
+	}
+
 	<-ctx.Done()
 	logger.V(2).Printf("Stopping agent...")
+
+	if _, err := sdNotify(sdNotifyStopping); err != nil {
+		logger.V(1).Printf("Unable to notify systemd: %v", err)
+	}
+
 	signal.Stop(c)
 	close(c)
 	a.taskRegistry.Close()
 	a.discovery.Close()
+
+	if a.cfg.Metric.PersistFile != "" {
+		a.saveStore()
+	}
+
 	logger.V(2).Printf("Agent stopped")
 }
 
 func (a *agent) buildCollectorsConfig() (conf inputs.CollectorConfig, err error) {
-	whitelistRE, err := common.CompileREs(a.config.StringList("disk_monitor"))
+	whitelistRE, err := common.CompileREs(a.cfg.DiskMonitor)
 	if err != nil {
 		logger.V(1).Printf("the whitelist for diskio regexp couldn't compile: %s", err)
 		return
 	}
 
-	blacklistRE, err := common.CompileREs(a.config.StringList("disk_ignore"))
+	blacklistRE, err := common.CompileREs(a.cfg.DiskIgnore)
 	if err != nil {
 		logger.V(1).Printf("the blacklist for diskio regexp couldn't compile: %s", err)
 		return
 	}
 
-	pathBlacklist := a.config.StringList("df.path_ignore")
+	pathBlacklist := a.cfg.DF.PathIgnore
 	pathBlacklistTrimed := make([]string, len(pathBlacklist))
 
 	for i, v := range pathBlacklist {
@@ -829,14 +1519,35 @@ func (a *agent) buildCollectorsConfig() (conf inputs.CollectorConfig, err error)
 	}
 
 	return inputs.CollectorConfig{
-		DFRootPath:      a.hostRootPath,
-		NetIfBlacklist:  a.config.StringList("network_interface_blacklist"),
-		IODiskWhitelist: whitelistRE,
-		IODiskBlacklist: blacklistRE,
-		DFPathBlacklist: pathBlacklistTrimed,
+		DFRootPath:        a.hostRootPath,
+		NetIfBlacklist:    a.cfg.NetworkInterfaceBlacklist,
+		IODiskWhitelist:   whitelistRE,
+		IODiskBlacklist:   blacklistRE,
+		DFPathBlacklist:   pathBlacklistTrimed,
+		DFFSTypeBlacklist: validGlobs("df.fs_type_ignore", a.cfg.DF.FSTypeIgnore),
+		DFDeviceBlacklist: validGlobs("df.device_ignore", a.cfg.DF.DeviceIgnore),
+		DFTimeout:         a.cfg.DF.Timeout,
+		DFCooldown:        a.cfg.DF.UnreachableCooldown,
 	}, nil
 }
 
+// validGlobs returns the patterns which are valid globs and warns about the others.
+func validGlobs(key string, patterns []string) []string {
+	result := make([]string, 0, len(patterns))
+
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			logger.Printf("Ignoring the invalid pattern %#v in %s: %v", p, key, err)
+
+			continue
+		}
+
+		result = append(result, p)
+	}
+
+	return result
+}
+
 func (a *agent) minuteMetric(ctx context.Context) error {
 	for {
 		select {
@@ -936,6 +1647,14 @@ func (a *agent) miscTasks(ctx context.Context) error {
 			a.triggerDiscImmediate = true
 			a.triggerHandler.Trigger()
 		}
+
+		for containerID, at := range a.triggerContainersAt {
+			if time.Now().After(at) {
+				delete(a.triggerContainersAt, containerID)
+				a.triggerContainers[containerID] = true
+				a.triggerHandler.Trigger()
+			}
+		}
 		a.triggerLock.Unlock()
 	}
 }
@@ -945,7 +1664,7 @@ func (a *agent) startTasks(tasks []taskInfo) {
 	defer a.l.Unlock()
 
 	for _, t := range tasks {
-		id, err := a.taskRegistry.AddTask(t.function, t.name)
+		id, err := a.taskRegistry.AddTaskWithPolicy(t.function, t.name, taskPolicy(t.name))
 		if err != nil {
 			logger.V(1).Printf("Unable to start %s: %v", t.name, err)
 		}
@@ -967,16 +1686,16 @@ func (a *agent) watchdog(ctx context.Context) error {
 			return nil
 		}
 
-		timestamp := atomic.LoadInt64(&a.lastHealCheck)
-		lastHealCheck := time.Unix(timestamp, 0)
+		// Use the monotonic clock, a jump of the wall clock must not kill the agent.
+		sinceHealCheck := clock.SinceNanotime(atomic.LoadInt64(&a.lastHealCheck))
 
 		switch {
-		case time.Since(lastHealCheck) > 15*time.Minute && !failing:
-			logger.V(2).Printf("Healcheck are no longer running. Last run was at %s", lastHealCheck.Format(time.RFC3339))
+		case sinceHealCheck > 15*time.Minute && !failing:
+			logger.V(2).Printf("Healcheck are no longer running. Last run was %v ago", sinceHealCheck.Truncate(time.Second))
 
 			failing = true
-		case time.Since(lastHealCheck) > 15*time.Minute && failing:
-			logger.Printf("Healcheck are no longer running. Last run was at %s", lastHealCheck.Format(time.RFC3339))
+		case sinceHealCheck > 15*time.Minute && failing:
+			logger.Printf("Healcheck are no longer running. Last run was %v ago", sinceHealCheck.Truncate(time.Second))
 			// We don't know how big the buffer needs to be to collect
 			// all the goroutines. Use 2MB buffer which hopefully is enough
 			buffer := make([]byte, 1<<21)
@@ -992,25 +1711,53 @@ func (a *agent) watchdog(ctx context.Context) error {
 }
 
 func (a *agent) healthCheck(ctx context.Context) error {
-	ticker := time.NewTicker(time.Minute)
+	// When systemd watchdog is enabled, it must be notified at least twice per period.
+	interval := time.Minute
+	if watchdog := sdWatchdogInterval(); watchdog > 0 && watchdog/2 < interval {
+		interval = watchdog / 2
+	}
+
+	lastCheck := time.Now()
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
+		var now time.Time
+
 		select {
-		case <-ticker.C:
+		case now = <-ticker.C:
 		case <-ctx.Done():
 			return nil
 		}
 
-		mandatoryTasks := []string{"Bleemeo SAAS connector", "Metric collector", "Metric store"}
+		healthy := true
+
 		for _, name := range mandatoryTasks {
-			if crashed, err := a.doesTaskCrashed(ctx, name); crashed && err != nil {
-				logger.Printf("Task %#v crashed: %v", name, err)
+			crashed, err := a.doesTaskCrashed(ctx, name)
+			if crashed {
+				healthy = false
+			}
+
+			if crashed && err != nil {
+				logger.Printf("Task %#v crashed and its restarts failed: %v", name, err)
 				logger.Printf("Stopping the agent as task %#v is critical", name)
 				a.cancel()
 			}
 		}
 
+		if healthy {
+			if _, err := sdNotify(sdNotifyWatchdog); err != nil {
+				logger.V(1).Printf("Unable to notify systemd watchdog: %v", err)
+			}
+		}
+
+		if now.Sub(lastCheck) < time.Minute {
+			continue
+		}
+
+		lastCheck = now
+
 		if a.bleemeoConnector != nil {
 			a.bleemeoConnector.HealthCheck()
 		}
@@ -1019,7 +1766,11 @@ func (a *agent) healthCheck(ctx context.Context) error {
 			a.influxdbConnector.HealthCheck()
 		}
 
-		atomic.StoreInt64(&a.lastHealCheck, time.Now().Unix())
+		if a.influxdbWriter != nil {
+			a.influxdbWriter.HealthCheck()
+		}
+
+		atomic.StoreInt64(&a.lastHealCheck, clock.Nanotime())
 	}
 }
 
@@ -1040,6 +1791,44 @@ func (a *agent) doesTaskCrashed(ctx context.Context, name string) (bool, error)
 	return false, nil
 }
 
+// tlsScan periodically scan TLS endpoints of services. The last results are sent
+// every minute.
+func (a *agent) tlsScan(ctx context.Context) error {
+	interval := a.cfg.TLSScan.Interval
+	lastScan := time.Time{}
+
+	var points []types.MetricPoint
+
+	for {
+		select {
+		case <-time.After(time.Minute):
+		case <-ctx.Done():
+			return nil
+		}
+
+		if time.Since(lastScan) >= interval {
+			services, err := a.discovery.Discovery(ctx, 2*time.Hour)
+			if err != nil {
+				logger.V(1).Printf("get service failed for TLS scan: %v", err)
+				continue
+			}
+
+			points = tlsEndpointsPoints(ctx, services)
+			lastScan = time.Now()
+		}
+
+		now := time.Now()
+
+		for i := range points {
+			points[i].Time = now
+		}
+
+		if len(points) > 0 {
+			a.threshold.WithPusher(a.gathererRegistry.WithTTL(5 * time.Minute)).PushPoints(points)
+		}
+	}
+}
+
 func (a *agent) hourlyDiscovery(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
@@ -1049,7 +1838,12 @@ func (a *agent) hourlyDiscovery(ctx context.Context) error {
 
 	a.FireTrigger(false, false, true, false)
 
-	ticker := time.NewTicker(time.Hour)
+	interval := a.cfg.Discovery.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -1076,6 +1870,27 @@ func (a *agent) dailyFact(ctx context.Context) error {
 	}
 }
 
+// persistStore saves the recent points of the store, they are reloaded on the next start.
+func (a *agent) persistStore(ctx context.Context) error {
+	ticker := time.NewTicker(a.cfg.Metric.PersistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			a.saveStore()
+		}
+	}
+}
+
+func (a *agent) saveStore() {
+	if err := a.store.Save(a.cfg.Metric.PersistFile, a.cfg.Metric.PersistDuration); err != nil {
+		logger.V(1).Printf("Unable to save the points of the store: %v", err)
+	}
+}
+
 func (a *agent) dockerWatcher(ctx context.Context) error {
 	var wg sync.WaitGroup
 
@@ -1091,18 +1906,27 @@ func (a *agent) dockerWatcher(ctx context.Context) error {
 	for {
 		select {
 		case ev := <-a.dockerFact.Events():
+			switch ev.Action {
+			case "start", "unpause":
+				a.FireContainerTrigger(ev.ActorID, true)
+			case "die", "destroy", "pause":
+				a.FireContainerTrigger(ev.ActorID, false)
+			}
+
 			if ev.Action == "start" {
-				a.FireTrigger(true, false, false, true)
-			} else if ev.Action == "die" || ev.Action == "destroy" {
-				a.FireTrigger(true, false, false, false)
+				a.sendDockerRestartLoops()
+			}
+
+			if ev.Action == "destroy" {
+				a.dockerHealth.Forget(ev.ActorID)
 			}
 
-			if strings.HasPrefix(ev.Action, "health_status:") && ev.Container != nil {
+			if (strings.HasPrefix(ev.Action, "health_status:") || ev.Action == "pause" || ev.Action == "unpause") && ev.Container != nil {
 				if a.bleemeoConnector != nil {
 					a.bleemeoConnector.UpdateContainers()
 				}
 
-				a.sendDockerContainerHealth(*ev.Container)
+				a.dockerHealth.Update(*ev.Container)
 			}
 		case <-ctx.Done():
 			return nil
@@ -1117,6 +1941,8 @@ func (a *agent) dockerWatcherContainerHealth(ctx context.Context) {
 	for {
 		select {
 		case <-ticker.C:
+			a.sendDockerRestartLoops()
+
 			// It not needed to have fresh container information. When health event occur,
 			// DockerFact already update the container information
 			containers, err := a.dockerFact.Containers(ctx, 3600*time.Second, false)
@@ -1130,7 +1956,7 @@ func (a *agent) dockerWatcherContainerHealth(ctx context.Context) {
 					continue
 				}
 
-				a.sendDockerContainerHealth(c)
+				a.dockerHealth.Update(c)
 			}
 		case <-ctx.Done():
 			return
@@ -1138,46 +1964,36 @@ func (a *agent) dockerWatcherContainerHealth(ctx context.Context) {
 	}
 }
 
-func (a *agent) sendDockerContainerHealth(container facts.Container) {
-	inspect := container.Inspect()
-	if inspect.State == nil || inspect.State.Health == nil {
+// sendDockerRestartLoops sends the docker_container_restart_loop_status of containers
+// started during the restart loop period. It's critical when the container is looping.
+func (a *agent) sendDockerRestartLoops() {
+	restarts := a.dockerFact.ContainerRestarts()
+	if len(restarts) == 0 {
 		return
 	}
 
-	state := container.State()
-	healthStatus := inspect.State.Health.Status
-	status := types.StatusDescription{}
+	period := a.cfg.Container.RestartLoop.Period
+	points := make([]types.MetricPoint, 0, len(restarts))
 
-	index := len(inspect.State.Health.Log) - 1
-	if len(inspect.State.Health.Log) > 0 && inspect.State.Health.Log[index] != nil {
-		status.StatusDescription = inspect.State.Health.Log[index].Output
-	}
+	for _, r := range restarts {
+		container, found := a.dockerFact.Container(r.ID)
+		if !found || container.Ignored() {
+			continue
+		}
 
-	switch {
-	case state != "running":
-		status.CurrentStatus = types.StatusCritical
-		status.StatusDescription = "Container stopped"
-	case healthStatus == "healthy":
-		status.CurrentStatus = types.StatusOk
-	case healthStatus == "starting":
-		startedAt := container.StartedAt()
-		if time.Since(startedAt) < time.Minute || startedAt.IsZero() {
-			status.CurrentStatus = types.StatusOk
-		} else {
-			status.CurrentStatus = types.StatusWarning
-			status.StatusDescription = "Container is still starting"
+		status := types.StatusDescription{
+			CurrentStatus:     types.StatusOk,
+			StatusDescription: fmt.Sprintf("Container started %d times in the last %v", r.Starts, period),
 		}
-	case healthStatus == "unhealthy":
-		status.CurrentStatus = types.StatusCritical
-	default:
-		status.CurrentStatus = types.StatusUnknown
-		status.StatusDescription = fmt.Sprintf("Unknown health status %#v", healthStatus)
-	}
 
-	a.gathererRegistry.WithTTL(5 * time.Minute).PushPoints([]types.MetricPoint{
-		{
+		if r.Looping {
+			status.CurrentStatus = types.StatusCritical
+			status.StatusDescription = fmt.Sprintf("Container is restarting in loop: started %d times in the last %v", r.Starts, period)
+		}
+
+		points = append(points, types.MetricPoint{
 			Labels: map[string]string{
-				types.LabelName:              "docker_container_health_status",
+				types.LabelName:              "docker_container_restart_loop_status",
 				types.LabelMetaContainerName: container.Name(),
 			},
 			Annotations: types.MetricAnnotations{
@@ -1189,12 +2005,14 @@ func (a *agent) sendDockerContainerHealth(container facts.Container) {
 				Time:  time.Now(),
 				Value: float64(status.CurrentStatus.NagiosCode()),
 			},
-		},
-	})
+		})
+	}
+
+	a.gathererRegistry.WithTTL(5 * time.Minute).PushPoints(points)
 }
 
 func (a *agent) netstatWatcher(ctx context.Context) error {
-	filePath := a.config.String("agent.netstat_file")
+	filePath := a.cfg.Agent.NetstatFile
 	stat, _ := os.Stat(filePath)
 
 	ticker := time.NewTicker(15 * time.Second)
@@ -1242,7 +2060,28 @@ func (a *agent) FireTrigger(discovery bool, sendFacts bool, systemUpdateMetric b
 	a.triggerHandler.Trigger()
 }
 
-func (a *agent) cleanTrigger() (discovery bool, sendFacts bool, systemUpdateMetric bool) {
+// FireContainerTrigger requests the discovery of the services of a container, without a
+// full discovery. If secondDiscovery is true, it's done again one minute later, for services
+// slow to start.
+func (a *agent) FireContainerTrigger(containerID string, secondDiscovery bool) {
+	a.triggerLock.Lock()
+	defer a.triggerLock.Unlock()
+
+	if a.triggerContainers == nil {
+		a.triggerContainers = make(map[string]bool)
+		a.triggerContainersAt = make(map[string]time.Time)
+	}
+
+	a.triggerContainers[containerID] = true
+
+	if secondDiscovery {
+		a.triggerContainersAt[containerID] = time.Now().Add(time.Minute)
+	}
+
+	a.triggerHandler.Trigger()
+}
+
+func (a *agent) cleanTrigger() (discovery bool, sendFacts bool, systemUpdateMetric bool, containers []string) {
 	a.triggerLock.Lock()
 	defer a.triggerLock.Unlock()
 
@@ -1253,13 +2092,34 @@ func (a *agent) cleanTrigger() (discovery bool, sendFacts bool, systemUpdateMetr
 	a.triggerDiscImmediate = false
 	a.triggerFact = false
 
+	for containerID := range a.triggerContainers {
+		containers = append(containers, containerID)
+	}
+
+	a.triggerContainers = make(map[string]bool)
+
 	return
 }
 
 func (a *agent) handleTrigger(ctx context.Context) {
-	runDiscovery, runFact, runSystemUpdateMetric := a.cleanTrigger()
-	if runDiscovery {
-		services, err := a.discovery.Discovery(ctx, 0)
+	runDiscovery, runFact, runSystemUpdateMetric, containers := a.cleanTrigger()
+	if runDiscovery || len(containers) > 0 {
+		var (
+			services []discovery.Service
+			err      error
+		)
+
+		if runDiscovery {
+			services, err = a.discovery.Discovery(ctx, 0)
+		} else {
+			for _, containerID := range containers {
+				services, err = a.discovery.UpdateContainer(ctx, containerID)
+				if err != nil {
+					break
+				}
+			}
+		}
+
 		if err != nil {
 			logger.V(1).Printf("error during discovery: %v", err)
 		} else {
@@ -1286,8 +2146,18 @@ func (a *agent) handleTrigger(ctx context.Context) {
 		}
 
 		hasConnection := a.dockerFact.HasConnection(ctx)
-		if hasConnection && !a.dockerInputPresent && a.config.Bool("telegraf.docker_metrics_enabled") {
-			i, err := docker.New()
+		if hasConnection && !a.dockerInputPresent && a.cfg.Telegraf.DockerMetricsEnabled {
+			var (
+				i   telegraf.Input
+				err error
+			)
+
+			if a.cfg.Container.NativeStats {
+				i = docker.NewNative(a.dockerFact)
+			} else {
+				i, err = docker.New(a.dockerFact)
+			}
+
 			if err != nil {
 				logger.V(1).Printf("error when creating Docker input: %v", err)
 			} else {
@@ -1303,15 +2173,17 @@ func (a *agent) handleTrigger(ctx context.Context) {
 	}
 
 	if runFact {
-		if _, err := a.factProvider.Facts(ctx, 0); err != nil {
+		if factsMap, err := a.factProvider.Facts(ctx, 0); err != nil {
 			logger.V(1).Printf("error during facts gathering: %v", err)
+		} else {
+			a.gathererRegistry.UpdateGlobalLabels(ctx, a.globalLabels(factsMap))
 		}
 	}
 
 	if runSystemUpdateMetric {
 		pendingUpdate, pendingSecurityUpdate := facts.PendingSystemUpdate(
 			ctx,
-			a.config.String("container.type") != "",
+			a.cfg.Container.Type != "",
 			a.hostRootPath,
 		)
 
@@ -1342,6 +2214,15 @@ func (a *agent) handleTrigger(ctx context.Context) {
 		}
 
 		a.threshold.WithPusher(a.gathererRegistry.WithTTL(time.Hour)).PushPoints(points)
+
+		if a.packageInventory != nil {
+			changed, err := a.packageInventory.Update(ctx)
+			if err != nil {
+				logger.V(1).Printf("Unable to list installed packages: %v", err)
+			} else if changed {
+				a.FireTrigger(false, true, false, false)
+			}
+		}
 	}
 }
 
@@ -1369,6 +2250,137 @@ func (a *agent) deletedContainersCallback(containersID []string) {
 }
 
 // migrateState update older state to latest version.
+// setupStateEncryption encrypts the sensitive keys of the state file when enabled. When it's
+// disabled, values encrypted previously are decrypted if the key is still available.
+// It fails when the key is unusable or encrypted values can't be decrypted, the agent
+// would register again.
+func (a *agent) setupStateEncryption() error {
+	enabled := a.cfg.Agent.StateEncryption.Enabled
+	keyFile := filepath.Join(filepath.Dir(a.cfg.Agent.StateFile), stateKeyFilename)
+
+	var oldKeys [][]byte
+
+	// Older versions derived the key from the machine-id, which is readable by all users.
+	if machineKey, err := machineIDStateKey(); err == nil {
+		oldKeys = append(oldKeys, machineKey)
+	}
+
+	key, err := stateEncryptionKey(a.cfg.Agent.StateEncryption.Key, keyFile, enabled)
+
+	switch {
+	case os.IsNotExist(err) && len(oldKeys) > 0:
+		// The encryption is disabled, values could only be encrypted by older versions.
+		key, oldKeys = oldKeys[0], nil
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	sensitiveKeys := []string{"password", "agent_uuid"}
+	if !enabled {
+		sensitiveKeys = nil
+	}
+
+	err = a.state.SetEncryption(key, oldKeys, sensitiveKeys)
+	if errors.Is(err, state.ErrDecryption) {
+		return err
+	}
+
+	if err != nil {
+		logger.Printf("Unable to setup state encryption: %v", err)
+	}
+
+	return nil
+}
+
+// stateEncryptionKey returns the key from the configuration or from keyFile. When create
+// is true, keyFile is created with a random key if it doesn't exist. keyFile must only
+// be readable by the user running the agent.
+func stateEncryptionKey(configKey string, keyFile string, create bool) ([]byte, error) {
+	if configKey != "" {
+		return []byte(configKey), nil
+	}
+
+	info, err := os.Stat(keyFile)
+	if os.IsNotExist(err) && create {
+		return createStateKey(keyFile)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%w: %s", errStateKeyPermission, keyFile)
+	}
+
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%s is empty", keyFile)
+	}
+
+	return key, nil
+}
+
+// createStateKey writes a random key in keyFile, only readable by its owner.
+func createStateKey(keyFile string) ([]byte, error) {
+	buffer := make([]byte, 32)
+	if _, err := cryptoRand.Read(buffer); err != nil {
+		return nil, err
+	}
+
+	key := []byte(hex.EncodeToString(buffer))
+
+	file, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Write(key); err != nil {
+		file.Close()
+		os.Remove(keyFile)
+
+		return nil, err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(keyFile)
+
+		return nil, err
+	}
+
+	logger.V(1).Printf("Created the state encryption key %s", keyFile)
+
+	return key, nil
+}
+
+// machineIDStateKey returns the key derived from the machine-id used by older versions.
+func machineIDStateKey() ([]byte, error) {
+	var err error
+
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		var content []byte
+
+		content, err = ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		if id := strings.TrimSpace(string(content)); id != "" {
+			return []byte("glouton-state:" + id), nil
+		}
+
+		err = fmt.Errorf("%s is empty", path)
+	}
+
+	return nil, err
+}
+
 func (a *agent) migrateState() {
 	// This "secret" was only present in Bleemeo agent and not really used.
 	_ = a.state.Delete("web_secret_key")
@@ -1390,7 +2402,7 @@ func (a *agent) DiagnosticPage() string {
 		runtime.Version(),
 	)
 
-	if a.config.Bool("bleemeo.enabled") {
+	if a.cfg.Bleemeo.Enabled {
 		fmt.Fprintln(builder, "Glouton has Bleemeo connection enabled")
 
 		if a.bleemeoConnector == nil {
@@ -1402,6 +2414,10 @@ func (a *agent) DiagnosticPage() string {
 		fmt.Fprintln(builder, "Glouton has Bleemeo connection DISABLED")
 	}
 
+	if a.relay != nil {
+		builder.WriteString(a.relay.DiagnosticPage())
+	}
+
 	allMetrics, err := a.store.Metrics(nil)
 	if err != nil {
 		fmt.Fprintf(builder, "Unable to query internal metrics store: %v\n", err)
@@ -1429,6 +2445,21 @@ func (a *agent) DiagnosticPage() string {
 		}
 	}
 
+	if topErrors := logger.TopErrors(10); len(topErrors) > 0 {
+		fmt.Fprintln(builder, "Top recurring errors:")
+
+		for _, e := range topErrors {
+			fmt.Fprintf(
+				builder,
+				" * %d times since %s (last at %s): %s\n",
+				e.Count,
+				e.FirstSeen.Format(time.RFC3339),
+				e.LastSeen.Format(time.RFC3339),
+				e.Message,
+			)
+		}
+	}
+
 	return builder.String()
 }
 
@@ -1473,6 +2504,37 @@ func (a *agent) DiagnosticZip(w io.Writer) error {
 		return err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := a.diagnosticConfig(zipFile); err != nil {
+		return err
+	}
+
+	if err := a.diagnosticConfigSources(zipFile); err != nil {
+		return err
+	}
+
+	if err := a.diagnosticServices(ctx, zipFile); err != nil {
+		return err
+	}
+
+	if err := a.diagnosticMetrics(zipFile); err != nil {
+		return err
+	}
+
+	if err := a.diagnosticTasks(zipFile); err != nil {
+		return err
+	}
+
+	if err := a.diagnosticLogEntries(zipFile); err != nil {
+		return err
+	}
+
+	if err := a.diagnosticAuditLog(zipFile); err != nil {
+		return err
+	}
+
 	if a.bleemeoConnector != nil {
 		err = a.bleemeoConnector.DiagnosticZip(zipFile)
 		if err != nil {
@@ -1564,6 +2626,29 @@ func setupContainer(hostRootPath string) {
 // config:
 //   your_custom_name_here:
 //     url: http://localhost:9100/metrics
+// registerScrapeJobs adds a gatherer for each target of the configured scrape jobs.
+func (a *agent) registerScrapeJobs(jobsCfg interface{}) {
+	jobs, err := scrapper.ParseJobs(jobsCfg)
+	if err != nil {
+		logger.Printf("Ignoring invalid metric.scrape_jobs config: %v", err)
+
+		return
+	}
+
+	for _, job := range jobs {
+		for _, target := range job.Targets() {
+			extraLabels := map[string]string{
+				types.LabelMetaScrapeJob:      job.JobName,
+				types.LabelMetaScrapeInstance: target.HostPort(),
+			}
+
+			if _, err := a.gathererRegistry.RegisterGatherer(target, nil, extraLabels); err != nil {
+				logger.Printf("Unable to add Prometheus scrapper for target %s: %v", target.URL.String(), err)
+			}
+		}
+	}
+}
+
 func prometheusConfigToURLs(config interface{}) map[string]string {
 	result := make(map[string]string)
 
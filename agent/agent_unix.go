@@ -27,14 +27,14 @@ func (a *agent) initOSSpecificParts() {
 }
 
 func (a *agent) registerOSSpecificComponents() {
-	if a.config.Bool("agent.node_exporter.enabled") {
+	if a.cfg.Agent.NodeExporter.Enabled {
 		nodeOption := node.Option{
 			RootFS:            a.hostRootPath,
-			EnabledCollectors: a.config.StringList("agent.node_exporter.collectors"),
+			EnabledCollectors: a.cfg.Agent.NodeExporter.Collectors,
 		}
 
-		nodeOption.WithPathIgnore(a.config.StringList("df.path_ignore"))
-		nodeOption.WithNetworkIgnore(a.config.StringList("network_interface_blacklist"))
+		nodeOption.WithPathIgnore(a.cfg.DF.PathIgnore)
+		nodeOption.WithNetworkIgnore(a.cfg.NetworkInterfaceBlacklist)
 
 		if err := a.gathererRegistry.AddNodeExporter(nodeOption); err != nil {
 			logger.Printf("Unable to start node_exporter, system metrics will be missing: %v", err)
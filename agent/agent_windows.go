@@ -85,14 +85,14 @@ func (a *agent) initOSSpecificParts() {
 }
 
 func (a *agent) registerOSSpecificComponents() {
-	if a.config.Bool("agent.windows_exporter.enabled") {
+	if a.cfg.Agent.WindowsExporter.Enabled {
 		conf, err := a.buildCollectorsConfig()
 		if err != nil {
 			logger.V(0).Printf("Couldn't build configuration for windows_exporter: %v", err)
 			return
 		}
 
-		collectors := a.config.StringList("agent.windows_exporter.collectors")
+		collectors := a.cfg.Agent.WindowsExporter.Collectors
 		if err := a.gathererRegistry.AddWindowsExporter(collectors, conf); err != nil {
 			logger.Printf("Unable to start windows_exporter, system metrics will be missing: %v", err)
 		}
@@ -19,13 +19,22 @@ package agent
 import (
 	"encoding/json"
 	"fmt"
+	"glouton/actions"
+	"glouton/check"
+	"glouton/command"
 	"glouton/config"
+	"glouton/inputs"
 	"glouton/logger"
-	"io/ioutil"
+	"glouton/notifier"
+	"glouton/remediation"
+	"glouton/threshold"
+	"glouton/types"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/prometheus/common/model"
 )
 
 //nolint:gochecknoglobals
@@ -44,15 +53,33 @@ var defaultConfig = map[string]interface{}{
 			},
 		},
 	},
+	"actions.enabled":                   false,
+	"actions.commands":                  []interface{}{},
 	"agent.cloudimage_creation_file":    "cloudimage_creation",
 	"agent.facts_file":                  "facts.yaml",
+	"agent.heartbeat_file":              "",
+	"agent.heartbeat_udp":               "",
 	"agent.http_debug.enabled":          false,
 	"agent.http_debug.bind_address":     "localhost:6060",
 	"agent.installation_format":         "manual",
 	"agent.netstat_file":                "netstat.out",
 	"agent.process_exporter.enabled":    true,
 	"agent.public_ip_indicator":         "https://myip.bleemeo.com",
+	"agent.cache_file":                  "",
+	"agent.cache_save_interval":         60,
+	"agent.state_backup_count":          3,
 	"agent.state_file":                  "state.json",
+	"agent.state_compression.enabled":   false,
+	"agent.state_encryption.enabled":    false,
+	"agent.state_encryption.key":        "",
+	"agent.ipmi.enabled":                false,
+	"agent.ipmi.timeout":                10,
+	"agent.saturation.enabled":          true,
+	"agent.saturation.per_core_cpu":     false,
+	"agent.service_tcp.enabled":         false,
+	"agent.sessions.enabled":            false,
+	"agent.time_drift.enabled":          false,
+	"agent.time_drift.servers":          []string{},
 	"agent.upgrade_file":                "upgrade",
 	"agent.metrics_format":              "Bleemeo",
 	"agent.node_exporter.enabled":       true,
@@ -60,17 +87,32 @@ var defaultConfig = map[string]interface{}{
 	"agent.windows_exporter.enabled":    true,
 	"agent.windows_exporter.collectors": []string{"cpu", "cs", "logical_disk", "logon", "memory", "net", "os", "system", "tcp"},
 	"bleemeo.account_id":                "",
+	"bleemeo.anonymize.enabled":         false,
+	"bleemeo.anonymize.salt":            "",
 	"bleemeo.api_base":                  "https://api.bleemeo.com/",
+	"bleemeo.api_cafile":                "",
+	"bleemeo.api_fingerprints":          []interface{}{},
 	"bleemeo.api_ssl_insecure":          false,
 	"bleemeo.enabled":                   true,
 	"bleemeo.initial_agent_name":        "",
 	"bleemeo.mqtt.cafile":               "",
+	"bleemeo.mqtt.fingerprints":         []interface{}{},
 	"bleemeo.mqtt.host":                 "mqtt.bleemeo.com",
 	"bleemeo.mqtt.port":                 8883,
 	"bleemeo.mqtt.ssl_insecure":         false,
 	"bleemeo.mqtt.ssl":                  true,
+	"bleemeo.mqtt.transport":            "auto",
+	"bleemeo.mqtt.websocket_path":       "/mqtt",
+	"bleemeo.mqtt.websocket_port":       443,
 	"bleemeo.registration_key":          "",
+	"bleemeo.relay.cafile":              "",
+	"bleemeo.relay.fingerprints":        []interface{}{},
+	"bleemeo.relay.ssl_insecure":        false,
+	"bleemeo.relay.token":               "",
+	"bleemeo.relay.url":                 "",
+	"bleemeo.remote_commands.enabled":   true,
 	"bleemeo.sentry.dsn":                "",
+	"bleemeo.topinfo_min_period":        0,
 	"config_files": []string{ // This settings could not be overridden by configuration files
 		"/etc/glouton/glouton.conf",
 		"/etc/glouton/conf.d",
@@ -79,9 +121,22 @@ var defaultConfig = map[string]interface{}{
 		"C:\\ProgramData\\glouton\\glouton.conf",
 		"C:\\ProgramData\\glouton\\conf.d",
 	},
-	"container.pid_namespace_host": false,
-	"container.type":               "",
-	"df.host_mount_point":          "",
+	"check.pools":                   map[string]interface{}{},
+	"config_extra_dirs":             []string{},
+	"container.churn.ephemeral_age": 1800,
+	"container.churn.threshold":     0,
+	"container.group_replicas":      false,
+	"container.health.soft_period":  0,
+	"container.native_stats":        false,
+	"container.pid_namespace_host":  false,
+	"container.restart_loop.count":  5,
+	"container.restart_loop.period": 600,
+	"container.type":                "",
+	"df.device_ignore":              []interface{}{},
+	"discovery.interval":            3600,
+	"discovery.watch_netstat":       true,
+	"df.fs_type_ignore":             []interface{}{},
+	"df.host_mount_point":           "",
 	"df.path_ignore": []interface{}{
 		"/var/lib/docker/aufs",
 		"/var/lib/docker/overlay",
@@ -93,7 +148,9 @@ var defaultConfig = map[string]interface{}{
 		"/var/lib/docker/plugins",
 		"/snap",
 	},
-	"disk_ignore": []string{},
+	"df.timeout":              10,
+	"df.unreachable_cooldown": 300,
+	"disk_ignore":             []string{},
 	"disk_monitor": []string{
 		"^(hd|sd|vd|xvd)[a-z]$",
 		"^mmcblk[0-9]$",
@@ -106,38 +163,87 @@ var defaultConfig = map[string]interface{}{
 		"^rsxx[0-9]$",
 		"^[A-Z]:$",
 	},
+	"influxdb.bucket":                  "glouton",
 	"influxdb.db_name":                 "glouton",
 	"influxdb.enabled":                 false,
 	"influxdb.host":                    "localhost",
+	"influxdb.org":                     "",
+	"influxdb.output":                  "v1",
 	"influxdb.port":                    8086,
 	"influxdb.tags":                    map[string]string{},
+	"influxdb.token":                   "",
+	"influxdb.url":                     "",
 	"jmx.enabled":                      true,
 	"jmxtrans.config_file":             "/var/lib/jmxtrans/glouton-generated.json",
 	"jmxtrans.file_permission":         "0640",
 	"jmxtrans.graphite_port":           2004,
+	"file_checks":                      []interface{}{},
 	"kubernetes.enabled":               false,
 	"kubernetes.nodename":              "",
 	"kubernetes.kubeconfig":            "",
+	"logging.buffer.entries":           1000,
 	"logging.buffer.head_size":         150,
 	"logging.buffer.tail_size":         1000,
 	"logging.level":                    "INFO",
 	"logging.output":                   "console",
 	"logging.package_levels":           "",
+	"maintenance":                      []interface{}{},
+	"mode":                             "",
+	"resource_profile":                 "",
+	"metric.align_timestamps":          false,
+	"metric.fact_labels":               map[string]string{},
+	"metric.gather_timeout":            9,
+	"metric.gather_workers":            8,
+	"metric.global_labels":             map[string]string{},
+	"metric.max_series":                100000,
+	"metric.max_series_per_metric":     10000,
+	"metric.pending_status":            false,
+	"metric.persist_duration":          900,
+	"metric.persist_file":              "store.bin",
+	"metric.persist_interval":          300,
 	"metric.prometheus":                map[string]interface{}{},
+	"metric.relabel_configs":           []interface{}{},
+	"metric.scrape_jobs":               []interface{}{},
 	"metric.softstatus_period_default": 5 * 60,
+	"metric.status_metrics":            true,
+	"metric.store_retention":           3600,
+	"metric.status_metrics_ignore":     []interface{}{},
 	"metric.softstatus_period": map[string]interface{}{
 		"system_pending_updates":          86400,
 		"system_pending_security_updates": 86400,
 		"time_elapsed_since_last_data":    0,
 	},
 	"network_interface_blacklist":        []interface{}{"docker", "lo", "veth", "virbr", "vnet", "isatap"},
+	"notification.channels":              []interface{}{},
+	"notification.renotify_interval":     3600,
+	"notification.send_resolved":         true,
 	"nrpe.enabled":                       false,
 	"nrpe.address":                       "0.0.0.0",
 	"nrpe.port":                          5666,
 	"nrpe.ssl":                           true,
 	"nrpe.conf_paths":                    []interface{}{"/etc/nagios/nrpe.cfg"},
+	"nrpe.allowed_hosts":                 []interface{}{},
+	"service_ignore":                     []interface{}{},
 	"service_ignore_check":               []interface{}{},
 	"service_ignore_metrics":             []interface{}{},
+	"proxy.http_proxy":                   "",
+	"proxy.https_proxy":                  "",
+	"proxy.no_proxy":                     "",
+	"package_inventory.enabled":          false,
+	"package_inventory.send_list":        false,
+	"passive_check":                      []interface{}{},
+	"process_checks":                     []interface{}{},
+	"relay.enabled":                      false,
+	"relay.listener.address":             "0.0.0.0",
+	"relay.listener.port":                8016,
+	"relay.max_pending_messages":         10000,
+	"relay.tls.cert_file":                "",
+	"relay.tls.key_file":                 "",
+	"relay.tokens":                       []interface{}{},
+	"remediation.enabled":                false,
+	"remediation.hooks":                  []interface{}{},
+	"rules.evaluation_interval":          60,
+	"rules.files":                        []interface{}{},
 	"service":                            []interface{}{},
 	"stack":                              "",
 	"tags":                               []string{},
@@ -146,7 +252,14 @@ var defaultConfig = map[string]interface{}{
 	"telegraf.statsd.address":            "127.0.0.1",
 	"telegraf.statsd.enabled":            true,
 	"telegraf.statsd.port":               8125,
+	"threshold_rules":                    []interface{}{},
+	"tls_scan.enabled":                   false,
+	"tls_scan.interval":                  86400,
 	"thresholds":                         map[string]interface{}{},
+	"units":                              map[string]interface{}{},
+	"web.auth.oidc.audience":             "",
+	"web.auth.oidc.issuer":               "",
+	"web.auth.tokens":                    []interface{}{},
 	"web.enabled":                        true,
 	"web.listener.address":               "127.0.0.1",
 	"web.listener.port":                  8015,
@@ -154,15 +267,18 @@ var defaultConfig = map[string]interface{}{
 	"zabbix.enabled":                     false,
 	"zabbix.address":                     "127.0.0.1",
 	"zabbix.port":                        10050,
+	"zabbix.tls.accept":                  []interface{}{"unencrypted"},
+	"zabbix.tls.ca_file":                 "",
+	"zabbix.tls.cert_file":               "",
+	"zabbix.tls.key_file":                "",
+	"zabbix.tls.server_cert_issuer":      "",
+	"zabbix.tls.server_cert_subject":     "",
+	"zabbix.tls.psk_identity":            "",
+	"zabbix.tls.psk_file":                "",
 }
 
 func configLoadFile(filePath string, cfg *config.Configuration) error {
-	buffer, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return err
-	}
-
-	err = cfg.LoadByte(buffer)
+	err := cfg.LoadFile(filePath)
 	if err != nil {
 		logger.Printf("Unable to load %#v: %v", filePath, err)
 	}
@@ -170,6 +286,64 @@ func configLoadFile(filePath string, cfg *config.Configuration) error {
 	return err
 }
 
+// standaloneConfig contains the default values used with "mode: standalone".
+// Like defaultConfig, they only apply to keys not set by the configuration.
+var standaloneConfig = map[string]interface{}{
+	"bleemeo.enabled":        false,
+	"metric.store_retention": 6 * 3600,
+	"rules.files":            []interface{}{"/etc/glouton/rules/*.yml"},
+}
+
+// lowResourceConfig contains the default values used with "resource_profile: low".
+// They reduce the memory and CPU footprint of the agent, e.g. on Raspberry Pi or edge gateways.
+var lowResourceConfig = map[string]interface{}{
+	"bleemeo.topinfo_min_period": 60,
+	"discovery.interval":         600,
+	"discovery.watch_netstat":    false,
+	"metric.gather_workers":      2,
+	"metric.store_retention":     600,
+}
+
+// loadResourceProfile apply the defaults of the profile selected by the "resource_profile" key.
+func loadResourceProfile(cfg *config.Configuration) (warnings []error) {
+	switch profile := cfg.String("resource_profile"); profile {
+	case "", "default":
+	case "low":
+		for key, value := range lowResourceConfig {
+			if _, ok := cfg.Get(key); !ok {
+				cfg.SetFrom(key, value, "resource_profile low")
+			}
+		}
+	default:
+		warnings = append(warnings, fmt.Errorf("unknown resource_profile %#v, supported profile is \"low\"", profile))
+	}
+
+	return warnings
+}
+
+// loadModeDefault apply the defaults of the mode selected by the "mode" key.
+func loadModeDefault(cfg *config.Configuration) (warnings []error) {
+	switch mode := cfg.String("mode"); mode {
+	case "":
+	case "standalone":
+		if value, ok := cfg.Get("bleemeo.enabled"); ok && value == true {
+			warnings = append(warnings, fmt.Errorf("bleemeo.enabled is ignored in standalone mode, the Bleemeo connector is disabled"))
+		}
+
+		cfg.SetFrom("bleemeo.enabled", false, "mode standalone")
+
+		for key, value := range standaloneConfig {
+			if _, ok := cfg.Get(key); !ok {
+				cfg.SetFrom(key, value, "mode standalone")
+			}
+		}
+	default:
+		warnings = append(warnings, fmt.Errorf("unknown mode %#v, supported mode is \"standalone\"", mode))
+	}
+
+	return warnings
+}
+
 func loadDefault(cfg *config.Configuration) {
 	for key, value := range defaultConfig {
 		if _, ok := cfg.Get(key); !ok {
@@ -241,6 +415,10 @@ func loadEnvironmentVariable(cfg *config.Configuration, key string, envName stri
 		varType = config.TypeMap
 	}
 
+	if unitType := unitValueType(key); unitType != config.TypeUnknown {
+		varType = unitType
+	}
+
 	found, err = cfg.LoadEnv(key, varType, envName)
 	if varType == config.TypeUnknown && found {
 		return false, fmt.Errorf("update %#v from environment variable %#v is not supported", key, envName)
@@ -260,8 +438,12 @@ func (a *agent) loadConfiguration(configFiles []string) (cfg *config.Configurati
 		return cfg, nil, err
 	}
 
+	if _, err := loadEnvironmentVariable(cfg, "config_extra_dirs", keyToEnvironemntName("config_extra_dirs"), defaultConfig["config_extra_dirs"]); err != nil {
+		return cfg, nil, err
+	}
+
 	if len(configFiles) > 0 && len(configFiles[0]) > 0 {
-		cfg.Set("config_files", configFiles)
+		cfg.SetFrom("config_files", configFiles, "command line")
 	}
 
 	if _, ok := cfg.Get("config_files"); !ok {
@@ -290,11 +472,28 @@ func (a *agent) loadConfiguration(configFiles []string) (cfg *config.Configurati
 		}
 	}
 
+	// Extra directories are loaded after config_files, they could be set by the environment or
+	// by any of the config_files. Directories set by an extra directory are ignored.
+	for _, dirname := range cfg.StringList("config_extra_dirs") {
+		if err := cfg.LoadDirectory(dirname); err != nil {
+			if os.IsNotExist(err) {
+				warnings = append(warnings, fmt.Errorf("config_extra_dirs: directory %#v doesn't exist", dirname))
+
+				continue
+			}
+
+			finalError = err
+		}
+	}
+
 	moreMarnings, err := loadEnvironmentVariables(cfg)
 	if err != nil {
 		finalError = err
 	}
 
+	moreMarnings = append(moreMarnings, loadResourceProfile(cfg)...)
+	moreMarnings = append(moreMarnings, loadModeDefault(cfg)...)
+
 	loadDefault(cfg)
 
 	return cfg, append(warnings, moreMarnings...), finalError
@@ -368,6 +567,246 @@ func confFieldToSliceMap(input interface{}, confType string) []map[string]string
 	return result
 }
 
+// checkPoolSizes returns the concurrency of each check pool from the configuration.
+func checkPoolSizes(input interface{}, found bool) map[string]int {
+	if !found {
+		return nil
+	}
+
+	inputMap, ok := convertToMap(input)
+	if !ok {
+		logger.Printf("check.pools in configuration file is not a map")
+
+		return nil
+	}
+
+	result := make(map[string]int, len(inputMap))
+
+	for name, value := range inputMap {
+		size, err := strconv.ParseInt(convertToString(value), 10, 0)
+		if err != nil || size <= 0 {
+			logger.Printf("Invalid size %#v for check pool %s, ignoring it", value, name)
+
+			continue
+		}
+
+		result[name] = int(size)
+	}
+
+	return result
+}
+
+// passiveChecksFromConfig create the passive checks defined in the configuration.
+func passiveChecksFromConfig(fragments []map[string]string, acc inputs.AnnotationAccumulator) map[string]*check.PassiveCheck {
+	result := make(map[string]*check.PassiveCheck, len(fragments))
+
+	for _, fragment := range fragments {
+		name := fragment["name"]
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			logger.Printf("Passive check name %#v is invalid, ignoring it", name)
+			continue
+		}
+
+		var freshness time.Duration
+
+		if value, ok := fragment["freshness"]; ok {
+			seconds, err := strconv.ParseInt(value, 10, 0)
+			if err != nil || seconds < 0 {
+				logger.Printf("Invalid freshness %#v for passive check %s, ignoring it", value, name)
+			} else {
+				freshness = time.Duration(seconds) * time.Second
+			}
+		}
+
+		result[name] = check.NewPassive(name, freshness, acc)
+	}
+
+	return result
+}
+
+// notificationChannelsFromConfig create the notification channels defined in the configuration.
+func notificationChannelsFromConfig(fragments []map[string]string) []notifier.Channel {
+	result := make([]notifier.Channel, 0, len(fragments))
+
+	for i, fragment := range fragments {
+		var (
+			channel notifier.Channel
+			err     error
+		)
+
+		switch fragment["type"] {
+		case "webhook":
+			channel, err = notifier.NewWebhook(fragment["url"], fragment["format"], fragment["routing_key"])
+		case "email":
+			channel = &notifier.Email{
+				Address:  fragment["smtp_address"],
+				From:     fragment["from"],
+				To:       strings.Split(fragment["to"], ","),
+				Username: fragment["username"],
+				Password: fragment["password"],
+			}
+
+			if fragment["smtp_address"] == "" || fragment["from"] == "" || fragment["to"] == "" {
+				err = fmt.Errorf("smtp_address, from and to are required")
+			}
+		case "exec":
+			channel, err = notifier.NewExec(fragment["command"])
+		default:
+			err = fmt.Errorf("unknown type %#v", fragment["type"])
+		}
+
+		if err != nil {
+			logger.Printf("Notification channel #%d is invalid, ignoring it: %v", i, err)
+			continue
+		}
+
+		result = append(result, channel)
+	}
+
+	return result
+}
+
+// actionsFromConfig create the actions defined in the configuration.
+func actionsFromConfig(fragments []map[string]string) []actions.Action {
+	result := make([]actions.Action, 0, len(fragments))
+
+	for i, fragment := range fragments {
+		cooldown, timeout, err := command.Durations(fragment)
+		if err != nil {
+			logger.Printf("Action #%d is invalid, ignoring it: %v", i, err)
+			continue
+		}
+
+		allowed := true
+
+		if value, ok := fragment["allowed"]; ok {
+			allowed, err = strconv.ParseBool(value)
+			if err != nil {
+				logger.Printf("Invalid allowed %#v for action #%d, ignoring it", value, i)
+				continue
+			}
+		}
+
+		action, err := actions.NewAction(fragment["name"], fragment["command"], allowed, cooldown, timeout)
+		if err != nil {
+			logger.Printf("Action #%d is invalid, ignoring it: %v", i, err)
+			continue
+		}
+
+		result = append(result, action)
+	}
+
+	return result
+}
+
+// remediationHooksFromConfig create the remediation hooks defined in the configuration.
+func remediationHooksFromConfig(fragments []map[string]string) []remediation.Hook {
+	result := make([]remediation.Hook, 0, len(fragments))
+
+	for i, fragment := range fragments {
+		cooldown, timeout, err := command.Durations(fragment)
+		if err != nil {
+			logger.Printf("Remediation hook #%d is invalid, ignoring it: %v", i, err)
+			continue
+		}
+
+		if fragment["metric"] == "" {
+			logger.Printf("Remediation hook #%d has no metric, ignoring it", i)
+			continue
+		}
+
+		hook, err := remediation.NewHook(fragment["metric"], fragment["item"], fragment["command"], cooldown, timeout)
+		if err != nil {
+			logger.Printf("Remediation hook #%d is invalid, ignoring it: %v", i, err)
+			continue
+		}
+
+		result = append(result, hook)
+	}
+
+	return result
+}
+
+func thresholdRulesFromConfig(fragments []map[string]string, defaultSoftPeriod time.Duration) []threshold.Rule {
+	result := make([]threshold.Rule, 0, len(fragments))
+
+	for _, fragment := range fragments {
+		name := fragment["name"]
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			logger.Printf("Threshold rule name %#v is invalid, ignoring it", name)
+			continue
+		}
+
+		status := types.StatusWarning
+
+		switch strings.ToLower(fragment["status"]) {
+		case "", "warning":
+		case "critical":
+			status = types.StatusCritical
+		default:
+			logger.Printf("Invalid status %#v for threshold rule %s, ignoring it", fragment["status"], name)
+			continue
+		}
+
+		softPeriod := defaultSoftPeriod
+
+		if value, ok := fragment["soft_period"]; ok {
+			seconds, err := strconv.ParseInt(value, 10, 0)
+			if err != nil || seconds < 0 {
+				logger.Printf("Invalid soft_period %#v for threshold rule %s, ignoring it", value, name)
+				continue
+			}
+
+			softPeriod = time.Duration(seconds) * time.Second
+		}
+
+		rule, err := threshold.NewRule(name, fragment["expression"], status, softPeriod)
+		if err != nil {
+			logger.Printf("Threshold rule %s is invalid, ignoring it: %v", name, err)
+			continue
+		}
+
+		result = append(result, rule)
+	}
+
+	return result
+}
+
+func maintenanceWindowsFromConfig(fragments []map[string]string) []threshold.MaintenanceWindow {
+	result := make([]threshold.MaintenanceWindow, 0, len(fragments))
+
+	for i, fragment := range fragments {
+		start, err := time.Parse(time.RFC3339, fragment["start"])
+		if err != nil {
+			logger.Printf("Invalid start %#v for maintenance window #%d, ignoring it: %v", fragment["start"], i, err)
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(fragment["duration"], 10, 0)
+		if err != nil {
+			logger.Printf("Invalid duration %#v for maintenance window #%d, ignoring it", fragment["duration"], i)
+			continue
+		}
+
+		window := threshold.MaintenanceWindow{
+			Metric:  fragment["metric"],
+			Service: fragment["service"],
+			Start:   start,
+			End:     start.Add(time.Duration(seconds) * time.Second),
+			Reason:  fragment["reason"],
+		}
+
+		if err := window.Validate(); err != nil {
+			logger.Printf("Maintenance window #%d is invalid, ignoring it: %v", i, err)
+			continue
+		}
+
+		result = append(result, window)
+	}
+
+	return result
+}
+
 func softPeriodsFromInterface(input interface{}) map[string]time.Duration {
 	if input == nil {
 		return nil
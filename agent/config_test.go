@@ -17,8 +17,10 @@
 package agent
 
 import (
+	"glouton/config"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_confFieldToSliceMap(t *testing.T) {
@@ -163,3 +165,166 @@ func Test_confFieldToSliceMap(t *testing.T) {
 		})
 	}
 }
+
+func Test_loadModeDefault(t *testing.T) {
+	cfg := &config.Configuration{}
+	cfg.Set("mode", "standalone")
+	cfg.Set("bleemeo.enabled", true)
+	cfg.Set("metric.store_retention", 600)
+
+	warnings := loadModeDefault(cfg)
+	if len(warnings) != 1 {
+		t.Errorf("len(warnings) = %d, want 1", len(warnings))
+	}
+
+	loadDefault(cfg)
+
+	if cfg.Bool("bleemeo.enabled") {
+		t.Errorf("bleemeo.enabled = true, want false")
+	}
+
+	if got := cfg.Int("metric.store_retention"); got != 600 {
+		t.Errorf("metric.store_retention = %d, want 600", got)
+	}
+
+	if got := cfg.StringList("rules.files"); !reflect.DeepEqual(got, []string{"/etc/glouton/rules/*.yml"}) {
+		t.Errorf("rules.files = %v", got)
+	}
+
+	cfg = &config.Configuration{}
+	cfg.Set("mode", "unknown")
+
+	if warnings := loadModeDefault(cfg); len(warnings) != 1 {
+		t.Errorf("len(warnings) = %d, want 1", len(warnings))
+	}
+
+	loadDefault(cfg)
+
+	if !cfg.Bool("bleemeo.enabled") {
+		t.Errorf("bleemeo.enabled = false, want true")
+	}
+}
+
+func Test_loadResourceProfile(t *testing.T) {
+	cfg := &config.Configuration{}
+	cfg.Set("resource_profile", "low")
+	cfg.Set("mode", "standalone")
+	cfg.Set("discovery.interval", 1800)
+
+	if warnings := loadResourceProfile(cfg); len(warnings) != 0 {
+		t.Errorf("warnings = %v, want none", warnings)
+	}
+
+	_ = loadModeDefault(cfg)
+
+	loadDefault(cfg)
+
+	if got := cfg.Int("metric.store_retention"); got != 600 {
+		t.Errorf("metric.store_retention = %d, want 600", got)
+	}
+
+	if got := cfg.Int("metric.gather_workers"); got != 2 {
+		t.Errorf("metric.gather_workers = %d, want 2", got)
+	}
+
+	if got := cfg.Int("discovery.interval"); got != 1800 {
+		t.Errorf("discovery.interval = %d, want 1800", got)
+	}
+
+	if cfg.Bool("discovery.watch_netstat") {
+		t.Errorf("discovery.watch_netstat = true, want false")
+	}
+
+	cfg = &config.Configuration{}
+	cfg.Set("resource_profile", "tiny")
+
+	if warnings := loadResourceProfile(cfg); len(warnings) != 1 {
+		t.Errorf("len(warnings) = %d, want 1", len(warnings))
+	}
+
+	loadDefault(cfg)
+
+	if got := cfg.Int("metric.gather_workers"); got != 8 {
+		t.Errorf("metric.gather_workers = %d, want 8", got)
+	}
+}
+
+func Test_newConfig(t *testing.T) {
+	cfg := &config.Configuration{}
+	loadDefault(cfg)
+
+	typed, warnings := newConfig(cfg)
+	if len(warnings) != 0 {
+		t.Fatalf("warnings = %v, want none", warnings)
+	}
+
+	if typed.Web.Listener.Port != 8015 {
+		t.Errorf("web.listener.port = %d, want 8015", typed.Web.Listener.Port)
+	}
+
+	if typed.Metric.StoreRetention != time.Hour {
+		t.Errorf("metric.store_retention = %v, want 1h", typed.Metric.StoreRetention)
+	}
+
+	cfg = &config.Configuration{}
+	cfg.Set("metric.store_retention", "30m")
+	cfg.Set("discovery.interval", 0)
+	cfg.Set("web.listener.port", 70000)
+	cfg.Set("nrpe.enabled", "yes")
+	loadDefault(cfg)
+
+	typed, warnings = newConfig(cfg)
+	if len(warnings) != 2 {
+		t.Errorf("len(warnings) = %d, want 2: %v", len(warnings), warnings)
+	}
+
+	if typed.Metric.StoreRetention != 30*time.Minute {
+		t.Errorf("metric.store_retention = %v, want 30m", typed.Metric.StoreRetention)
+	}
+
+	if typed.Discovery.Interval != time.Hour {
+		t.Errorf("discovery.interval = %v, want 1h", typed.Discovery.Interval)
+	}
+
+	if typed.Web.Listener.Port != 8015 {
+		t.Errorf("web.listener.port = %d, want 8015", typed.Web.Listener.Port)
+	}
+
+	if !typed.NRPE.Enabled {
+		t.Errorf("nrpe.enabled = false, want true")
+	}
+}
+
+func Test_unknownConfigKeys(t *testing.T) {
+	cfg := &config.Configuration{}
+	cfg.Set("web.listener.prot", 8015)
+	cfg.Set("thresholds", map[string]interface{}{"cpu_used": map[string]interface{}{"high_warning": 80}})
+	loadDefault(cfg)
+
+	warnings := unknownConfigKeys(cfg)
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, want 1 warning", warnings)
+	}
+
+	want := `unknown key "web.listener.prot", did you mean "web.listener.port"?`
+	if got := warnings[0].Error(); got != want {
+		t.Errorf("warning = %#v, want %#v", got, want)
+	}
+}
+
+func Test_suggestConfigKeys(t *testing.T) {
+	cases := []struct {
+		key  string
+		want []string
+	}{
+		{key: "bleemeo.enable", want: []string{"bleemeo.enabled"}},
+		{key: "listener.port", want: []string{"relay.listener.port", "web.listener.port"}},
+		{key: "completely_unrelated", want: []string{}},
+	}
+
+	for _, c := range cases {
+		if got := suggestConfigKeys(c.key); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("suggestConfigKeys(%#v) = %v, want %v", c.key, got, c.want)
+		}
+	}
+}
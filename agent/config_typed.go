@@ -0,0 +1,810 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"glouton/config"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// maxSuggestions is the number of near-miss keys suggested for an unknown key.
+const maxSuggestions = 3
+
+//nolint:gochecknoglobals
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	sizeType     = reflect.TypeOf(config.Size(0))
+	// configFieldTypes are the types of the fields of Config by configuration key.
+	configFieldTypes = fieldTypes(reflect.TypeOf(Config{}), "")
+)
+
+// Config is the typed configuration of Glouton.
+type Config struct {
+	Actions                   ActionsConfig          `yaml:"actions"`
+	Agent                     AgentConfig            `yaml:"agent"`
+	Blackbox                  BlackboxConfig         `yaml:"blackbox"`
+	Bleemeo                   BleemeoConfig          `yaml:"bleemeo"`
+	Check                     CheckConfig            `yaml:"check"`
+	ConfigExtraDirs           []string               `yaml:"config_extra_dirs"`
+	ConfigFiles               []string               `yaml:"config_files"`
+	Container                 ContainerConfig        `yaml:"container"`
+	DF                        DFConfig               `yaml:"df"`
+	Discovery                 DiscoveryConfig        `yaml:"discovery"`
+	DiskIgnore                []string               `yaml:"disk_ignore"`
+	DiskMonitor               []string               `yaml:"disk_monitor"`
+	Distribution              string                 `yaml:"distribution"`
+	FileChecks                interface{}            `yaml:"file_checks"`
+	InfluxDB                  InfluxDBConfig         `yaml:"influxdb"`
+	JMX                       EnabledConfig          `yaml:"jmx"`
+	JMXTrans                  JMXTransConfig         `yaml:"jmxtrans"`
+	Kubernetes                KubernetesConfig       `yaml:"kubernetes"`
+	Logging                   LoggingConfig          `yaml:"logging"`
+	Maintenance               interface{}            `yaml:"maintenance"`
+	Metric                    MetricConfig           `yaml:"metric"`
+	Mode                      string                 `yaml:"mode"`
+	NetworkInterfaceBlacklist []string               `yaml:"network_interface_blacklist"`
+	Notification              NotificationConfig     `yaml:"notification"`
+	NRPE                      NRPEConfig             `yaml:"nrpe"`
+	PackageInventory          PackageInventoryConfig `yaml:"package_inventory"`
+	PassiveCheck              interface{}            `yaml:"passive_check"`
+	ProcessChecks             interface{}            `yaml:"process_checks"`
+	Proxy                     ProxyConfig            `yaml:"proxy"`
+	Relay                     RelayConfig            `yaml:"relay"`
+	Remediation               RemediationConfig      `yaml:"remediation"`
+	ResourceProfile           string                 `yaml:"resource_profile"`
+	Rules                     RulesConfig            `yaml:"rules"`
+	Service                   interface{}            `yaml:"service"`
+	ServiceIgnore             interface{}            `yaml:"service_ignore"`
+	ServiceIgnoreCheck        interface{}            `yaml:"service_ignore_check"`
+	ServiceIgnoreMetrics      interface{}            `yaml:"service_ignore_metrics"`
+	Stack                     string                 `yaml:"stack"`
+	Tags                      []string               `yaml:"tags"`
+	Telegraf                  TelegrafConfig         `yaml:"telegraf"`
+	ThresholdRules            interface{}            `yaml:"threshold_rules"`
+	Thresholds                interface{}            `yaml:"thresholds"`
+	TLSScan                   TLSScanConfig          `yaml:"tls_scan"`
+	Units                     interface{}            `yaml:"units"`
+	Web                       WebConfig              `yaml:"web"`
+	Zabbix                    ZabbixConfig           `yaml:"zabbix"`
+}
+
+// ActionsConfig is the actions section of the configuration.
+type ActionsConfig struct {
+	Commands interface{} `yaml:"commands"`
+	Enabled  bool        `yaml:"enabled"`
+}
+
+// AgentConfig is the agent section of the configuration.
+type AgentConfig struct {
+	CacheFile              string                     `yaml:"cache_file"`
+	CacheSaveInterval      time.Duration              `yaml:"cache_save_interval"`
+	CloudImageCreationFile string                     `yaml:"cloudimage_creation_file"`
+	FactsFile              string                     `yaml:"facts_file"`
+	HeartbeatFile          string                     `yaml:"heartbeat_file"`
+	HeartbeatUDP           string                     `yaml:"heartbeat_udp"`
+	HTTPDebug              AgentHTTPDebugConfig       `yaml:"http_debug"`
+	InstallationFormat     string                     `yaml:"installation_format"`
+	IPMI                   AgentIPMIConfig            `yaml:"ipmi"`
+	MetricsFormat          string                     `yaml:"metrics_format"`
+	NetstatFile            string                     `yaml:"netstat_file"`
+	NodeExporter           AgentNodeExporterConfig    `yaml:"node_exporter"`
+	ProcessExporter        EnabledConfig              `yaml:"process_exporter"`
+	PublicIPIndicator      string                     `yaml:"public_ip_indicator"`
+	Saturation             AgentSaturationConfig      `yaml:"saturation"`
+	ServiceTCP             EnabledConfig              `yaml:"service_tcp"`
+	Sessions               EnabledConfig              `yaml:"sessions"`
+	StateBackupCount       int                        `yaml:"state_backup_count"`
+	StateCompression       EnabledConfig              `yaml:"state_compression"`
+	StateEncryption        AgentStateEncryptionConfig `yaml:"state_encryption"`
+	StateFile              string                     `yaml:"state_file"`
+	TimeDrift              AgentTimeDriftConfig       `yaml:"time_drift"`
+	UpgradeFile            string                     `yaml:"upgrade_file"`
+	WindowsExporter        AgentWindowsExporterConfig `yaml:"windows_exporter"`
+}
+
+// AgentHTTPDebugConfig is the agent.http_debug section of the configuration.
+type AgentHTTPDebugConfig struct {
+	BindAddress string `yaml:"bind_address"`
+	Enabled     bool   `yaml:"enabled"`
+}
+
+// AgentIPMIConfig is the agent.ipmi section of the configuration.
+type AgentIPMIConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// AgentNodeExporterConfig is the agent.node_exporter section of the configuration.
+type AgentNodeExporterConfig struct {
+	Collectors []string `yaml:"collectors"`
+	Enabled    bool     `yaml:"enabled"`
+}
+
+// AgentSaturationConfig is the agent.saturation section of the configuration.
+type AgentSaturationConfig struct {
+	Enabled    bool `yaml:"enabled"`
+	PerCoreCPU bool `yaml:"per_core_cpu"`
+}
+
+// AgentStateEncryptionConfig is the agent.state_encryption section of the configuration.
+type AgentStateEncryptionConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Key     string `yaml:"key"`
+}
+
+// AgentTimeDriftConfig is the agent.time_drift section of the configuration.
+type AgentTimeDriftConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Servers []string `yaml:"servers"`
+}
+
+// AgentWindowsExporterConfig is the agent.windows_exporter section of the configuration.
+type AgentWindowsExporterConfig struct {
+	Collectors []string `yaml:"collectors"`
+	Enabled    bool     `yaml:"enabled"`
+}
+
+// BlackboxConfig is the blackbox section of the configuration.
+type BlackboxConfig struct {
+	Enabled     bool        `yaml:"enabled"`
+	Modules     interface{} `yaml:"modules"`
+	ScraperName string      `yaml:"scraper_name"`
+	Targets     interface{} `yaml:"targets"`
+}
+
+// BleemeoConfig is the bleemeo section of the configuration.
+type BleemeoConfig struct {
+	AccountID        string                 `yaml:"account_id"`
+	Anonymize        BleemeoAnonymizeConfig `yaml:"anonymize"`
+	APIBase          string                 `yaml:"api_base"`
+	APICAFile        string                 `yaml:"api_cafile"`
+	APIFingerprints  []string               `yaml:"api_fingerprints"`
+	APISSLInsecure   bool                   `yaml:"api_ssl_insecure"`
+	Enabled          bool                   `yaml:"enabled"`
+	InitialAgentName string                 `yaml:"initial_agent_name"`
+	MQTT             BleemeoMQTTConfig      `yaml:"mqtt"`
+	RegistrationKey  string                 `yaml:"registration_key"`
+	Relay            BleemeoRelayConfig     `yaml:"relay"`
+	RemoteCommands   EnabledConfig          `yaml:"remote_commands"`
+	Sentry           BleemeoSentryConfig    `yaml:"sentry"`
+	TopinfoMinPeriod time.Duration          `yaml:"topinfo_min_period"`
+}
+
+// BleemeoAnonymizeConfig is the bleemeo.anonymize section of the configuration.
+type BleemeoAnonymizeConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Salt    string `yaml:"salt"`
+}
+
+// BleemeoMQTTConfig is the bleemeo.mqtt section of the configuration.
+type BleemeoMQTTConfig struct {
+	CAFile        string   `yaml:"cafile"`
+	Fingerprints  []string `yaml:"fingerprints"`
+	Host          string   `yaml:"host"`
+	Port          int      `yaml:"port"`
+	SSL           bool     `yaml:"ssl"`
+	SSLInsecure   bool     `yaml:"ssl_insecure"`
+	Transport     string   `yaml:"transport"`
+	WebsocketPath string   `yaml:"websocket_path"`
+	WebsocketPort int      `yaml:"websocket_port"`
+}
+
+// BleemeoRelayConfig is the bleemeo.relay section of the configuration.
+type BleemeoRelayConfig struct {
+	CAFile       string   `yaml:"cafile"`
+	Fingerprints []string `yaml:"fingerprints"`
+	SSLInsecure  bool     `yaml:"ssl_insecure"`
+	Token        string   `yaml:"token"`
+	URL          string   `yaml:"url"`
+}
+
+// BleemeoSentryConfig is the bleemeo.sentry section of the configuration.
+type BleemeoSentryConfig struct {
+	DSN string `yaml:"dsn"`
+}
+
+// CheckConfig is the check section of the configuration.
+type CheckConfig struct {
+	Pools interface{} `yaml:"pools"`
+}
+
+// ContainerConfig is the container section of the configuration.
+type ContainerConfig struct {
+	Churn            ContainerChurnConfig       `yaml:"churn"`
+	GroupReplicas    bool                       `yaml:"group_replicas"`
+	Health           ContainerHealthConfig      `yaml:"health"`
+	NativeStats      bool                       `yaml:"native_stats"`
+	PIDNamespaceHost bool                       `yaml:"pid_namespace_host"`
+	RestartLoop      ContainerRestartLoopConfig `yaml:"restart_loop"`
+	Type             string                     `yaml:"type"`
+}
+
+// ContainerChurnConfig is the container.churn section of the configuration.
+type ContainerChurnConfig struct {
+	EphemeralAge time.Duration `yaml:"ephemeral_age"`
+	Threshold    int           `yaml:"threshold"`
+}
+
+// ContainerHealthConfig is the container.health section of the configuration.
+type ContainerHealthConfig struct {
+	SoftPeriod time.Duration `yaml:"soft_period"`
+}
+
+// ContainerRestartLoopConfig is the container.restart_loop section of the configuration.
+type ContainerRestartLoopConfig struct {
+	Count  int           `yaml:"count"`
+	Period time.Duration `yaml:"period"`
+}
+
+// DFConfig is the df section of the configuration.
+type DFConfig struct {
+	DeviceIgnore        []string      `yaml:"device_ignore"`
+	FSTypeIgnore        []string      `yaml:"fs_type_ignore"`
+	HostMountPoint      string        `yaml:"host_mount_point"`
+	PathIgnore          []string      `yaml:"path_ignore"`
+	Timeout             time.Duration `yaml:"timeout"`
+	UnreachableCooldown time.Duration `yaml:"unreachable_cooldown"`
+}
+
+// DiscoveryConfig is the discovery section of the configuration.
+type DiscoveryConfig struct {
+	Interval     time.Duration `yaml:"interval"`
+	WatchNetstat bool          `yaml:"watch_netstat"`
+}
+
+// InfluxDBConfig is the influxdb section of the configuration.
+type InfluxDBConfig struct {
+	Bucket  string            `yaml:"bucket"`
+	DBName  string            `yaml:"db_name"`
+	Enabled bool              `yaml:"enabled"`
+	Host    string            `yaml:"host"`
+	Org     string            `yaml:"org"`
+	Output  string            `yaml:"output"`
+	Port    int               `yaml:"port"`
+	Tags    map[string]string `yaml:"tags"`
+	Token   string            `yaml:"token"`
+	URL     string            `yaml:"url"`
+}
+
+// JMXTransConfig is the jmxtrans section of the configuration.
+type JMXTransConfig struct {
+	ConfigFile     string `yaml:"config_file"`
+	FilePermission string `yaml:"file_permission"`
+	GraphitePort   int    `yaml:"graphite_port"`
+}
+
+// KubernetesConfig is the kubernetes section of the configuration.
+type KubernetesConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	KubeConfig string `yaml:"kubeconfig"`
+	NodeName   string `yaml:"nodename"`
+}
+
+// LoggingConfig is the logging section of the configuration.
+type LoggingConfig struct {
+	Buffer        LoggingBufferConfig `yaml:"buffer"`
+	Filename      string              `yaml:"filename"`
+	Level         string              `yaml:"level"`
+	Output        string              `yaml:"output"`
+	PackageLevels string              `yaml:"package_levels"`
+}
+
+// LoggingBufferConfig is the logging.buffer section of the configuration.
+type LoggingBufferConfig struct {
+	Entries  int `yaml:"entries"`
+	HeadSize int `yaml:"head_size"`
+	TailSize int `yaml:"tail_size"`
+}
+
+// MetricConfig is the metric section of the configuration.
+type MetricConfig struct {
+	AlignTimestamps         bool              `yaml:"align_timestamps"`
+	FactLabels              map[string]string `yaml:"fact_labels"`
+	GatherTimeout           time.Duration     `yaml:"gather_timeout"`
+	GatherWorkers           int               `yaml:"gather_workers"`
+	GlobalLabels            map[string]string `yaml:"global_labels"`
+	MaxSeries               int               `yaml:"max_series"`
+	MaxSeriesPerMetric      int               `yaml:"max_series_per_metric"`
+	PendingStatus           bool              `yaml:"pending_status"`
+	PersistDuration         time.Duration     `yaml:"persist_duration"`
+	PersistFile             string            `yaml:"persist_file"`
+	PersistInterval         time.Duration     `yaml:"persist_interval"`
+	Prometheus              interface{}       `yaml:"prometheus"`
+	RelabelConfigs          interface{}       `yaml:"relabel_configs"`
+	ScrapeJobs              interface{}       `yaml:"scrape_jobs"`
+	SoftstatusPeriod        interface{}       `yaml:"softstatus_period"`
+	SoftstatusPeriodDefault time.Duration     `yaml:"softstatus_period_default"`
+	StatusMetrics           bool              `yaml:"status_metrics"`
+	StatusMetricsIgnore     []string          `yaml:"status_metrics_ignore"`
+	StoreRetention          time.Duration     `yaml:"store_retention"`
+}
+
+// NotificationConfig is the notification section of the configuration.
+type NotificationConfig struct {
+	Channels         interface{}   `yaml:"channels"`
+	RenotifyInterval time.Duration `yaml:"renotify_interval"`
+	SendResolved     bool          `yaml:"send_resolved"`
+}
+
+// NRPEConfig is the nrpe section of the configuration.
+type NRPEConfig struct {
+	Address      string   `yaml:"address"`
+	AllowedHosts []string `yaml:"allowed_hosts"`
+	ConfPaths    []string `yaml:"conf_paths"`
+	Enabled      bool     `yaml:"enabled"`
+	Port         int      `yaml:"port"`
+	SSL          bool     `yaml:"ssl"`
+}
+
+// PackageInventoryConfig is the package_inventory section of the configuration.
+type PackageInventoryConfig struct {
+	Enabled  bool `yaml:"enabled"`
+	SendList bool `yaml:"send_list"`
+}
+
+// ProxyConfig is the proxy section of the configuration.
+type ProxyConfig struct {
+	HTTPProxy  string `yaml:"http_proxy"`
+	HTTPSProxy string `yaml:"https_proxy"`
+	NoProxy    string `yaml:"no_proxy"`
+}
+
+// RelayConfig is the relay section of the configuration.
+type RelayConfig struct {
+	Enabled            bool                `yaml:"enabled"`
+	Listener           RelayListenerConfig `yaml:"listener"`
+	MaxPendingMessages int                 `yaml:"max_pending_messages"`
+	TLS                RelayTLSConfig      `yaml:"tls"`
+	Tokens             []string            `yaml:"tokens"`
+}
+
+// RelayListenerConfig is the relay.listener section of the configuration.
+type RelayListenerConfig struct {
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+}
+
+// RelayTLSConfig is the relay.tls section of the configuration.
+type RelayTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// RemediationConfig is the remediation section of the configuration.
+type RemediationConfig struct {
+	Enabled bool        `yaml:"enabled"`
+	Hooks   interface{} `yaml:"hooks"`
+}
+
+// RulesConfig is the rules section of the configuration.
+type RulesConfig struct {
+	EvaluationInterval time.Duration `yaml:"evaluation_interval"`
+	Files              []string      `yaml:"files"`
+}
+
+// TelegrafConfig is the telegraf section of the configuration.
+type TelegrafConfig struct {
+	DockerMetricsEnabled bool                 `yaml:"docker_metrics_enabled"`
+	Statsd               TelegrafStatsdConfig `yaml:"statsd"`
+	WinPerfCounters      EnabledConfig        `yaml:"win_perf_counters"`
+}
+
+// TelegrafStatsdConfig is the telegraf.statsd section of the configuration.
+type TelegrafStatsdConfig struct {
+	Address string `yaml:"address"`
+	Enabled bool   `yaml:"enabled"`
+	Port    int    `yaml:"port"`
+}
+
+// TLSScanConfig is the tls_scan section of the configuration.
+type TLSScanConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// WebConfig is the web section of the configuration.
+type WebConfig struct {
+	Auth         WebAuthConfig     `yaml:"auth"`
+	Enabled      bool              `yaml:"enabled"`
+	Listener     WebListenerConfig `yaml:"listener"`
+	StaticCDNURL string            `yaml:"static_cdn_url"`
+}
+
+// WebAuthConfig is the web.auth section of the configuration.
+type WebAuthConfig struct {
+	OIDC   WebAuthOIDCConfig `yaml:"oidc"`
+	Tokens []string          `yaml:"tokens"`
+}
+
+// WebAuthOIDCConfig is the web.auth.oidc section of the configuration.
+type WebAuthOIDCConfig struct {
+	Audience string `yaml:"audience"`
+	Issuer   string `yaml:"issuer"`
+}
+
+// WebListenerConfig is the web.listener section of the configuration.
+type WebListenerConfig struct {
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+}
+
+// ZabbixConfig is the zabbix section of the configuration.
+type ZabbixConfig struct {
+	Address string          `yaml:"address"`
+	Enabled bool            `yaml:"enabled"`
+	Port    int             `yaml:"port"`
+	TLS     ZabbixTLSConfig `yaml:"tls"`
+}
+
+// ZabbixTLSConfig is the zabbix.tls section of the configuration.
+type ZabbixTLSConfig struct {
+	Accept            []string `yaml:"accept"`
+	CAFile            string   `yaml:"ca_file"`
+	CertFile          string   `yaml:"cert_file"`
+	KeyFile           string   `yaml:"key_file"`
+	PSKFile           string   `yaml:"psk_file"`
+	PSKIdentity       string   `yaml:"psk_identity"`
+	ServerCertIssuer  string   `yaml:"server_cert_issuer"`
+	ServerCertSubject string   `yaml:"server_cert_subject"`
+}
+
+// EnabledConfig is a section of the configuration with only an enabled key.
+type EnabledConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// newConfig returns the typed configuration from cfg, which must have its defaults loaded.
+//
+// Values which could not be converted to the type of their key or which are invalid
+// are reported as warnings and replaced by their default.
+func newConfig(cfg *config.Configuration) (typed Config, warnings []error) {
+	defaults := &config.Configuration{}
+	loadDefault(defaults)
+
+	// The defaults always decode, this is checked by the tests.
+	_ = defaults.Decode(&typed)
+	defaultTyped := typed
+
+	for _, err := range cfg.Decode(&typed) {
+		warnings = append(warnings, fmt.Errorf("%w, using the default value", err))
+	}
+
+	warnings = append(warnings, typed.validate(defaultTyped)...)
+
+	return typed, warnings
+}
+
+// fieldTypes returns the type of the fields of the struct typ by configuration key.
+func fieldTypes(typ reflect.Type, prefix string) map[string]reflect.Type {
+	result := make(map[string]reflect.Type)
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		key := field.Tag.Get("yaml")
+
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if field.Type.Kind() != reflect.Struct {
+			result[key] = field.Type
+
+			continue
+		}
+
+		for k, t := range fieldTypes(field.Type, key) {
+			result[k] = t
+		}
+	}
+
+	return result
+}
+
+// unitValueType returns the type of keys whose value has a unit, or config.TypeUnknown.
+// Those keys are integers in defaultConfig but also accept strings like "5m".
+func unitValueType(key string) config.ValueType {
+	switch configFieldTypes[key] {
+	case durationType:
+		return config.TypeDuration
+	case sizeType:
+		return config.TypeSize
+	default:
+		return config.TypeUnknown
+	}
+}
+
+// validate checks values which have a valid type but are not usable. Invalid values
+// are replaced by their default.
+func (c *Config) validate(defaults Config) (warnings []error) {
+	ports := []struct {
+		key          string
+		value        *int
+		defaultValue int
+	}{
+		{"bleemeo.mqtt.port", &c.Bleemeo.MQTT.Port, defaults.Bleemeo.MQTT.Port},
+		{"bleemeo.mqtt.websocket_port", &c.Bleemeo.MQTT.WebsocketPort, defaults.Bleemeo.MQTT.WebsocketPort},
+		{"influxdb.port", &c.InfluxDB.Port, defaults.InfluxDB.Port},
+		{"jmxtrans.graphite_port", &c.JMXTrans.GraphitePort, defaults.JMXTrans.GraphitePort},
+		{"nrpe.port", &c.NRPE.Port, defaults.NRPE.Port},
+		{"relay.listener.port", &c.Relay.Listener.Port, defaults.Relay.Listener.Port},
+		{"telegraf.statsd.port", &c.Telegraf.Statsd.Port, defaults.Telegraf.Statsd.Port},
+		{"web.listener.port", &c.Web.Listener.Port, defaults.Web.Listener.Port},
+		{"zabbix.port", &c.Zabbix.Port, defaults.Zabbix.Port},
+	}
+
+	for _, p := range ports {
+		if *p.value < 1 || *p.value > 65535 {
+			warnings = append(warnings, fmt.Errorf("%s: %d is not a valid port, using the default %d", p.key, *p.value, p.defaultValue))
+			*p.value = p.defaultValue
+		}
+	}
+
+	// Those durations are intervals of periodic tasks and must be positive,
+	// others may be zero to disable what they control.
+	intervals := []struct {
+		key          string
+		value        *time.Duration
+		defaultValue time.Duration
+	}{
+		{"discovery.interval", &c.Discovery.Interval, defaults.Discovery.Interval},
+		{"metric.gather_timeout", &c.Metric.GatherTimeout, defaults.Metric.GatherTimeout},
+		{"metric.persist_interval", &c.Metric.PersistInterval, defaults.Metric.PersistInterval},
+		{"rules.evaluation_interval", &c.Rules.EvaluationInterval, defaults.Rules.EvaluationInterval},
+		{"tls_scan.interval", &c.TLSScan.Interval, defaults.TLSScan.Interval},
+	}
+
+	for _, d := range intervals {
+		if *d.value <= 0 {
+			warnings = append(warnings, fmt.Errorf("%s: %v must be positive, using the default %v", d.key, *d.value, d.defaultValue))
+			*d.value = d.defaultValue
+		}
+	}
+
+	durations := []struct {
+		key          string
+		value        *time.Duration
+		defaultValue time.Duration
+	}{
+		{"agent.cache_save_interval", &c.Agent.CacheSaveInterval, defaults.Agent.CacheSaveInterval},
+		{"agent.ipmi.timeout", &c.Agent.IPMI.Timeout, defaults.Agent.IPMI.Timeout},
+		{"bleemeo.topinfo_min_period", &c.Bleemeo.TopinfoMinPeriod, defaults.Bleemeo.TopinfoMinPeriod},
+		{"container.churn.ephemeral_age", &c.Container.Churn.EphemeralAge, defaults.Container.Churn.EphemeralAge},
+		{"container.health.soft_period", &c.Container.Health.SoftPeriod, defaults.Container.Health.SoftPeriod},
+		{"container.restart_loop.period", &c.Container.RestartLoop.Period, defaults.Container.RestartLoop.Period},
+		{"df.timeout", &c.DF.Timeout, defaults.DF.Timeout},
+		{"df.unreachable_cooldown", &c.DF.UnreachableCooldown, defaults.DF.UnreachableCooldown},
+		{"metric.persist_duration", &c.Metric.PersistDuration, defaults.Metric.PersistDuration},
+		{"metric.softstatus_period_default", &c.Metric.SoftstatusPeriodDefault, defaults.Metric.SoftstatusPeriodDefault},
+		{"metric.store_retention", &c.Metric.StoreRetention, defaults.Metric.StoreRetention},
+		{"notification.renotify_interval", &c.Notification.RenotifyInterval, defaults.Notification.RenotifyInterval},
+	}
+
+	for _, d := range durations {
+		if *d.value < 0 {
+			warnings = append(warnings, fmt.Errorf("%s: %v must not be negative, using the default %v", d.key, *d.value, d.defaultValue))
+			*d.value = d.defaultValue
+		}
+	}
+
+	labels := []struct {
+		key    string
+		labels map[string]string
+	}{
+		{"metric.fact_labels", c.Metric.FactLabels},
+		{"metric.global_labels", c.Metric.GlobalLabels},
+	}
+
+	for _, l := range labels {
+		names := make([]string, 0, len(l.labels))
+
+		for name := range l.labels {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+				warnings = append(warnings, fmt.Errorf("%s: %#v is not a valid label name, the label is ignored", l.key, name))
+				delete(l.labels, name)
+			}
+		}
+	}
+
+	switch c.Logging.Output {
+	case "console", "syslog", "file":
+	default:
+		warnings = append(warnings, fmt.Errorf("logging.output: unknown output %#v, supported outputs are \"console\", \"syslog\" and \"file\"", c.Logging.Output))
+		c.Logging.Output = defaults.Logging.Output
+	}
+
+	return warnings
+}
+
+// unknownConfigKeys returns a warning for each key of the configuration unknown to Glouton.
+// The warning suggests the known keys which are close to the unknown key.
+func unknownConfigKeys(cfg *config.Configuration) (warnings []error) {
+	var walk func(prefix string, values map[string]interface{})
+
+	walk = func(prefix string, values map[string]interface{}) {
+		keys := make([]string, 0, len(values))
+
+		for k := range values {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+
+			if _, ok := deprecatedConfigKeys[key]; ok {
+				continue
+			}
+
+			if _, _, ok := lookupConfigKey(key); ok {
+				continue
+			}
+
+			if subValues, ok := values[k].(map[string]interface{}); ok && isConfigKeyPrefix(key) {
+				walk(key, subValues)
+
+				continue
+			}
+
+			warnings = append(warnings, fmt.Errorf("unknown key %#v%s", key, suggestionMessage(suggestConfigKeys(key))))
+		}
+	}
+
+	walk("", cfg.Dump())
+
+	return warnings
+}
+
+// suggestConfigKeys returns the known keys and sections close to key: keys with a small
+// edit distance and keys ending with key (e.g. "web.listener.port" for "listener.port").
+func suggestConfigKeys(key string) []string {
+	type suggestion struct {
+		key      string
+		distance int
+	}
+
+	candidates := make(map[string]bool)
+
+	addCandidate := func(k string) {
+		parts := strings.Split(k, ".")
+
+		for i := 1; i <= len(parts); i++ {
+			candidates[strings.Join(parts[:i], ".")] = true
+		}
+	}
+
+	for k := range defaultConfig {
+		addCandidate(k)
+	}
+
+	for k := range extraConfigKeys {
+		addCandidate(k)
+	}
+
+	maxDistance := 2
+	if len(key) <= 4 {
+		maxDistance = 1
+	}
+
+	suggestions := make([]suggestion, 0)
+
+	for k := range candidates {
+		distance := editDistance(key, k)
+
+		switch {
+		case distance <= maxDistance:
+		case strings.HasSuffix(k, "."+key):
+			distance = maxDistance + 1
+		default:
+			continue
+		}
+
+		suggestions = append(suggestions, suggestion{key: k, distance: distance})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance == suggestions[j].distance {
+			return suggestions[i].key < suggestions[j].key
+		}
+
+		return suggestions[i].distance < suggestions[j].distance
+	})
+
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+
+	result := make([]string, len(suggestions))
+
+	for i, s := range suggestions {
+		result[i] = s.key
+	}
+
+	return result
+}
+
+func suggestionMessage(suggestions []string) string {
+	if len(suggestions) == 0 {
+		return ""
+	}
+
+	quoted := make([]string, len(suggestions))
+
+	for i, s := range suggestions {
+		quoted[i] = fmt.Sprintf("%#v", s)
+	}
+
+	if len(quoted) == 1 {
+		return ", did you mean " + quoted[0] + "?"
+	}
+
+	return ", did you mean " + strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1] + "?"
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+
+	return result
+}
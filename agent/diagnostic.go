@@ -0,0 +1,368 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redactedValue = "*****"
+
+// secretKeyParts are the parts of configuration keys whose value is redacted.
+//nolint:gochecknoglobals
+var secretKeyParts = []string{"password", "secret", "token", "key", "salt"}
+
+// redactConfig returns a copy of the configuration where secrets are replaced.
+// The values of secret keys and of HTTP headers are replaced, the credentials
+// of URLs are removed.
+func redactConfig(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+
+		for k, v := range value {
+			result[k] = redactConfigEntry(k, v)
+		}
+
+		return result
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+
+		for k, v := range value {
+			key := fmt.Sprint(k)
+			result[key] = redactConfigEntry(key, v)
+		}
+
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+
+		for i, v := range value {
+			result[i] = redactConfig(v)
+		}
+
+		return result
+	case string:
+		return redactURL(value)
+	default:
+		return value
+	}
+}
+
+func redactConfigEntry(key string, value interface{}) interface{} {
+	switch {
+	case value == nil:
+		return nil
+	case isSecretKey(key):
+		return redactedValue
+	case strings.HasSuffix(strings.ToLower(key), "headers"):
+		return redactHeaders(value)
+	default:
+		return redactConfig(value)
+	}
+}
+
+// redactHeaders keeps the names of HTTP headers, their values may hold credentials.
+func redactHeaders(value interface{}) interface{} {
+	var names []string
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k := range value {
+			names = append(names, k)
+		}
+	case map[interface{}]interface{}:
+		for k := range value {
+			names = append(names, fmt.Sprint(k))
+		}
+	default:
+		return redactedValue
+	}
+
+	result := make(map[string]interface{}, len(names))
+
+	for _, name := range names {
+		result[name] = redactedValue
+	}
+
+	return result
+}
+
+// redactURL strips the user and password of a URL, e.g. in a proxy setting.
+func redactURL(value string) string {
+	if !strings.Contains(value, "@") {
+		return value
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+
+	u.User = nil
+
+	return u.String()
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactedConfig returns the effective configuration, secrets are replaced.
+func (a *agent) redactedConfig() interface{} {
+	return redactConfig(a.config.Dump())
+}
+
+func (a *agent) diagnosticConfig(zipFile *zip.Writer) error {
+	file, err := zipFile.Create("config.yaml")
+	if err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(file)
+	defer enc.Close()
+
+	return enc.Encode(a.redactedConfig())
+}
+
+func (a *agent) diagnosticConfigSources(zipFile *zip.Writer) error {
+	file, err := zipFile.Create("config-sources.txt")
+	if err != nil {
+		return err
+	}
+
+	for _, ks := range a.config.Sources() {
+		fmt.Fprintf(file, "%s: %s\n", ks.Key, strings.Join(ks.Sources, ", "))
+	}
+
+	return nil
+}
+
+func (a *agent) diagnosticServices(ctx context.Context, zipFile *zip.Writer) error {
+	file, err := zipFile.Create("services.txt")
+	if err != nil {
+		return err
+	}
+
+	services, err := a.discovery.Discovery(ctx, 24*time.Hour)
+	if err != nil {
+		fmt.Fprintf(file, "Unable to get services: %v\n", err)
+		return nil
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name == services[j].Name {
+			return services[i].ContainerName < services[j].ContainerName
+		}
+
+		return services[i].Name < services[j].Name
+	})
+
+	fmt.Fprintf(file, "%d services, last discovery at %s\n", len(services), a.discovery.LastUpdate().Format(time.RFC3339))
+
+	for _, srv := range services {
+		addresses := make([]string, 0, len(srv.ListenAddresses))
+
+		for _, addr := range srv.ListenAddresses {
+			addresses = append(addresses, addr.String())
+		}
+
+		fmt.Fprintf(
+			file,
+			"%s: type=%s container=%#v ip=%s listen=%v active=%v stale=%v expired=%v last_seen=%s\n",
+			srv.Name, srv.ServiceType, srv.ContainerName, srv.IPAddress, addresses,
+			srv.Active, srv.Stale, srv.Expired, srv.LastSeen.Format(time.RFC3339),
+		)
+	}
+
+	return nil
+}
+
+func (a *agent) diagnosticMetrics(zipFile *zip.Writer) error {
+	file, err := zipFile.Create("metrics.txt")
+	if err != nil {
+		return err
+	}
+
+	metrics, err := a.store.Metrics(nil)
+	if err != nil {
+		fmt.Fprintf(file, "Unable to query internal metrics store: %v\n", err)
+		return nil
+	}
+
+	end := time.Now()
+	start := end.Add(-time.Hour)
+	lines := make([]string, 0, len(metrics))
+
+	for _, m := range metrics {
+		line := types.LabelsToText(m.Labels())
+
+		if item := m.Annotations().BleemeoItem; item != "" {
+			line += fmt.Sprintf(" (item %s)", item)
+		}
+
+		points, err := m.Points(start, end)
+
+		switch {
+		case err != nil:
+			line += fmt.Sprintf(": %v", err)
+		case len(points) == 0:
+			line += ": no point in the last hour"
+		default:
+			last := points[len(points)-1]
+			line += fmt.Sprintf(" = %f at %s", last.Value, last.Time.Format(time.RFC3339))
+		}
+
+		lines = append(lines, line)
+	}
+
+	sort.Strings(lines)
+
+	for _, line := range lines {
+		fmt.Fprintln(file, line)
+	}
+
+	return nil
+}
+
+func (a *agent) diagnosticLogEntries(zipFile *zip.Writer) error {
+	file, err := zipFile.Create("log_entries.txt")
+	if err != nil {
+		return err
+	}
+
+	for _, e := range logger.Entries() {
+		fmt.Fprintf(file, "%s [%d] %s\n", e.Time.Format(time.RFC3339), e.Level, e.Message)
+	}
+
+	return nil
+}
+
+func (a *agent) diagnosticAuditLog(zipFile *zip.Writer) error {
+	file, err := zipFile.Create("audit_log.txt")
+	if err != nil {
+		return err
+	}
+
+	for _, e := range a.auditLog.Events() {
+		fmt.Fprintf(file, "%s [%s] %s\n", e.Time.Format(time.RFC3339), e.Kind, e.Message)
+	}
+
+	return nil
+}
+
+func (a *agent) diagnosticTasks(zipFile *zip.Writer) error {
+	file, err := zipFile.Create("tasks.txt")
+	if err != nil {
+		return err
+	}
+
+	for _, t := range a.taskRegistry.Statuses() {
+		state := "running"
+
+		switch {
+		case t.Restarting:
+			state = fmt.Sprintf("restarting after: %v", t.ExitError)
+		case !t.Running && t.ExitError != nil:
+			state = fmt.Sprintf("failed: %v", t.ExitError)
+		case !t.Running:
+			state = "stopped"
+		}
+
+		fmt.Fprintf(file, "%d %s: %s (%d restarts)\n", t.ID, t.Name, state, t.Restarts)
+	}
+
+	return nil
+}
+
+// Diagnostic write the diagnostic archive of the running Glouton to output.
+// The archive is downloaded from the local API.
+func Diagnostic(configFiles []string, output string) error {
+	a := &agent{}
+
+	cfg, _, err := a.loadConfiguration(configFiles)
+	if err != nil {
+		return err
+	}
+
+	address := cfg.String("web.listener.address")
+	if address == "" || address == "0.0.0.0" {
+		address = "127.0.0.1"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s:%d/diagnostic.zip", address, cfg.Int("web.listener.port"))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	if tokens := cfg.StringList("web.auth.tokens"); len(tokens) > 0 {
+		req.Header.Set("Authorization", "Bearer "+tokens[0])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("is Glouton running? %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 250))
+
+		return fmt.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(content)))
+	}
+
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
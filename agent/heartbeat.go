@@ -0,0 +1,84 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"glouton/logger"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// heartbeat signals to supervisors other than systemd that Glouton is alive. It's
+// sent after each successful collection cycle, so a wedged agent stops sending it
+// even when its process is still running.
+type heartbeat struct {
+	// file is rewritten with the current timestamp. Supervisors could check its modification time.
+	file string
+	// udpAddress receives a datagram "glouton <fqdn> <timestamp>".
+	udpAddress string
+	fqdn       string
+
+	l         sync.Mutex
+	lastError string
+}
+
+func (h *heartbeat) beat(now time.Time) {
+	if h.file != "" {
+		h.logError(writeHeartbeatFile(h.file, now))
+	}
+
+	if h.udpAddress != "" {
+		h.logError(sendHeartbeatUDP(h.udpAddress, h.fqdn, now))
+	}
+}
+
+// logError logs an error only when it differs from the previous one, the heartbeat
+// being sent after each collection cycle.
+func (h *heartbeat) logError(err error) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	if err == nil || err.Error() == h.lastError {
+		return
+	}
+
+	h.lastError = err.Error()
+
+	logger.V(1).Printf("Unable to send heartbeat: %v", err)
+}
+
+// writeHeartbeatFile write the current timestamp to path.
+func writeHeartbeatFile(path string, now time.Time) error {
+	return ioutil.WriteFile(path, []byte(strconv.FormatInt(now.Unix(), 10)+"\n"), 0644)
+}
+
+func sendHeartbeatUDP(address string, fqdn string, now time.Time) error {
+	conn, err := net.DialTimeout("udp", address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "glouton %s %d\n", fqdn, now.Unix())
+
+	return err
+}
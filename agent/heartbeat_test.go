@@ -0,0 +1,72 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-heartbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("UDP socket not supported: %v", err)
+	}
+
+	defer conn.Close()
+
+	hb := &heartbeat{
+		file:       filepath.Join(dir, "heartbeat"),
+		udpAddress: conn.LocalAddr().String(),
+		fqdn:       "server.example.com",
+	}
+
+	hb.beat(time.Unix(1600000000, 0))
+
+	content, err := ioutil.ReadFile(hb.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "1600000000\n"; string(content) != want {
+		t.Errorf("heartbeat file = %#v, want %#v", string(content), want)
+	}
+
+	buffer := make([]byte, 128)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(buffer[:n]), "glouton server.example.com 1600000000\n"; got != want {
+		t.Errorf("heartbeat datagram = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-sdnotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram socket not supported: %v", err)
+	}
+
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", "")
+
+	if sent, err := sdNotify(sdNotifyReady); sent || err != nil {
+		t.Errorf("sdNotify() = %v, %v, want false, nil without NOTIFY_SOCKET", sent, err)
+	}
+
+	os.Setenv("NOTIFY_SOCKET", socketPath)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	if sent, err := sdNotify(sdNotifyReady); !sent || err != nil {
+		t.Fatalf("sdNotify() = %v, %v, want true, nil", sent, err)
+	}
+
+	buffer := make([]byte, 64)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(buffer[:n]); got != sdNotifyReady {
+		t.Errorf("received %#v, want %#v", got, sdNotifyReady)
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	if got := sdWatchdogInterval(); got != 30*time.Second {
+		t.Errorf("sdWatchdogInterval() = %v, want 30s", got)
+	}
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+
+	if got := sdWatchdogInterval(); got != 0 {
+		t.Errorf("sdWatchdogInterval() = %v, want 0 for another process", got)
+	}
+}
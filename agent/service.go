@@ -19,11 +19,17 @@ package agent
 import (
 	"context"
 	"errors"
+	"fmt"
 	"glouton/discovery"
+	"glouton/inputs/tlsscan"
+	"glouton/logger"
+	"glouton/types"
+	"net"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //nolint:gochecknoglobals
@@ -92,3 +98,68 @@ func eximQueueSize(ctx context.Context, srv discovery.Service, hostRootPath stri
 
 	return 0, errors.New("can't gather the postfix running on host because Glouton run in a container")
 }
+
+// tlsEndpointsPoints scan the TCP listening addresses of active services and
+// returns the tls_min_version metrics of those speaking TLS.
+func tlsEndpointsPoints(ctx context.Context, services []discovery.Service) []types.MetricPoint {
+	var points []types.MetricPoint
+
+	for _, srv := range services {
+		if !srv.Active {
+			continue
+		}
+
+		scannedPorts := make(map[int]bool)
+
+		for _, addr := range srv.ListenAddresses {
+			if addr.Network() != "tcp" || scannedPorts[addr.Port] || srv.IgnoredPorts[addr.Port] {
+				continue
+			}
+
+			scannedPorts[addr.Port] = true
+
+			address := addr.Address
+			if address == net.IPv4zero.String() || address == net.IPv6zero.String() {
+				address = srv.IPAddress
+			}
+
+			if address == "" {
+				continue
+			}
+
+			result, err := tlsscan.Scan(ctx, net.JoinHostPort(address, strconv.Itoa(addr.Port)))
+			if err != nil {
+				if !errors.Is(err, tlsscan.ErrNotTLS) {
+					logger.V(2).Printf("Unable to scan TLS endpoint %s of service %s: %v", addr, srv.Name, err)
+				}
+
+				continue
+			}
+
+			logger.V(2).Printf("TLS endpoint %s of service %s accepts %v", addr, srv.Name, result.Versions)
+
+			itemPrefix := srv.Name
+			if srv.ContainerName != "" {
+				itemPrefix = srv.ContainerName
+			}
+
+			labels := map[string]string{
+				"service":                    srv.Name,
+				"port":                       strconv.Itoa(addr.Port),
+				types.LabelMetaServiceName:   srv.Name,
+				types.LabelMetaContainerName: srv.ContainerName,
+				types.LabelMetaContainerID:   srv.ContainerID,
+			}
+
+			annotations := types.MetricAnnotations{
+				BleemeoItem: fmt.Sprintf("%s:%d", itemPrefix, addr.Port),
+				ContainerID: srv.ContainerID,
+				ServiceName: srv.Name,
+			}
+
+			points = append(points, tlsscan.Points(result, labels, annotations, time.Now())...)
+		}
+	}
+
+	return points
+}
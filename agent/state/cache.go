@@ -0,0 +1,131 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"glouton/logger"
+	"os"
+	"time"
+)
+
+// SetCache stores the values of cacheKeys in a separate cache file. Values are written at
+// most once per saveInterval (or on each change if zero), so the state file holding the
+// identity is rarely rewritten.
+//
+// A cache file that can't be read is ignored, the cache is then rebuilt.
+// Values of cacheKeys found in the state file are moved to the cache file.
+func (s *State) SetCache(path string, cacheKeys []string, saveInterval time.Duration) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	cache, _, err := readFile(path)
+
+	switch {
+	case err != nil && os.IsNotExist(err):
+		cache = make(map[string]json.RawMessage)
+	case err != nil:
+		logger.Printf("Cache file %s is unusable, starting with an empty cache: %v", path, err)
+
+		cache = make(map[string]json.RawMessage)
+	}
+
+	s.cachePath = path
+	s.cache = cache
+	s.cacheInterval = saveInterval
+	s.cacheKeys = make(map[string]bool, len(cacheKeys))
+
+	moved := false
+
+	for _, k := range cacheKeys {
+		s.cacheKeys[k] = true
+
+		// The state file is more recent, the cache file was not used when it was written.
+		if v, ok := s.data[k]; ok {
+			s.cache[k] = v
+			moved = true
+
+			delete(s.data, k)
+		}
+	}
+
+	if !moved {
+		return nil
+	}
+
+	if err := s.saveCache(); err != nil {
+		return err
+	}
+
+	return s.save()
+}
+
+// Run saves the cache file when it changed. It must run when SetCache is used with a
+// saveInterval, otherwise the last changes are only saved by Save.
+func (s *State) Run(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.l.Lock()
+			s.saveCacheIfDue()
+			s.l.Unlock()
+		case <-ctx.Done():
+			s.l.Lock()
+			defer s.l.Unlock()
+
+			if s.cacheDirty {
+				if err := s.saveCache(); err != nil {
+					logger.Printf("Unable to save the cache file: %v", err)
+				}
+			}
+
+			return nil
+		}
+	}
+}
+
+func (s *State) isCacheKey(key string) bool {
+	return s.cachePath != "" && s.cacheKeys[key]
+}
+
+func (s *State) saveCacheIfDue() {
+	if !s.cacheDirty || time.Since(s.lastCacheSave) < s.cacheInterval {
+		return
+	}
+
+	if err := s.saveCache(); err != nil {
+		logger.Printf("Unable to save the cache file: %v", err)
+	}
+}
+
+func (s *State) saveCache() error {
+	fileSize, rawSize, err := s.writeFile(s.cachePath, s.cache, nil)
+	if err != nil {
+		return err
+	}
+
+	s.cacheFileSize = fileSize
+	s.cacheRawSize = rawSize
+	s.cacheDirty = false
+	s.lastCacheSave = time.Now()
+
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	cachePath := filepath.Join(dir, "cache.json")
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set("agent_uuid", "uuid"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set("CacheBleemeoConnector", "old cache"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetCache(cachePath, []string{"CacheBleemeoConnector"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	stateContent, _ := ioutil.ReadFile(path)
+	if bytes.Contains(stateContent, []byte("old cache")) {
+		t.Error("cache key wasn't moved out of the state file")
+	}
+
+	if err := s.Set("CacheBleemeoConnector", "new cache"); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := ioutil.ReadFile(path); !bytes.Equal(content, stateContent) {
+		t.Error("the state file was rewritten on a cache change")
+	}
+
+	if content, _ := ioutil.ReadFile(cachePath); bytes.Contains(content, []byte("new cache")) {
+		t.Error("the cache file was saved before the save interval")
+	}
+
+	var got string
+
+	if err := s.Get("CacheBleemeoConnector", &got); err != nil || got != "new cache" {
+		t.Errorf("Get(CacheBleemeoConnector) = %#v, %v, want %#v", got, err, "new cache")
+	}
+
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := ioutil.ReadFile(cachePath); !bytes.Contains(content, []byte("new cache")) {
+		t.Error("Save() didn't write the cache file")
+	}
+
+	// A corrupted cache must not break the identity.
+	if err := ioutil.WriteFile(cachePath, []byte("{garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetCache(cachePath, []string{"CacheBleemeoConnector"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	got = ""
+
+	if err := s.Get("agent_uuid", &got); err != nil || got != "uuid" {
+		t.Errorf("Get(agent_uuid) = %#v, %v, want %#v", got, err, "uuid")
+	}
+
+	got = ""
+
+	if err := s.Get("CacheBleemeoConnector", &got); err != nil || got != "" {
+		t.Errorf("Get(CacheBleemeoConnector) = %#v, %v, want an empty cache", got, err)
+	}
+
+	// Without save interval, the cache is written on each change.
+	if err := s.Set("CacheBleemeoConnector", "rebuilt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := ioutil.ReadFile(cachePath); !bytes.Contains(content, []byte("rebuilt")) {
+		t.Error("the cache file wasn't saved")
+	}
+}
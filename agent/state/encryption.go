@@ -0,0 +1,160 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// encryptedField is the only field of the JSON object replacing an encrypted value.
+const encryptedField = "glouton_encrypted_v1"
+
+var (
+	// ErrDecryption is returned by SetEncryption when a value can't be decrypted.
+	ErrDecryption = errors.New("unable to decrypt the state")
+
+	errCiphertextTooShort = errors.New("encrypted value is too short")
+)
+
+type encryptedValue map[string]string
+
+// SetEncryption encrypts in state.json the values of sensitiveKeys, using AES-GCM with a
+// SHA-256 of the key. Values already stored in plain text are migrated on the next save
+// and encrypted values of keys no longer sensitive are stored back in plain text.
+// Values encrypted with one of the oldKeys are encrypted again with key.
+//
+// When a value can't be decrypted (e.g. the key changed), ErrDecryption is returned and
+// the state is unchanged. Without a successful call to SetEncryption, encrypted values
+// are kept as-is but Get ignores them.
+func (s *State) SetEncryption(key []byte, oldKeys [][]byte, sensitiveKeys []string) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	keys := []cipher.AEAD{gcm}
+
+	for _, oldKey := range oldKeys {
+		oldGCM, err := newGCM(oldKey)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, oldGCM)
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	decrypted := make(map[string]json.RawMessage)
+
+	for k, raw := range s.data {
+		ciphertext, ok := encryptedPayload(raw)
+		if !ok {
+			continue
+		}
+
+		plaintext, err := decrypt(keys, ciphertext)
+		if err != nil {
+			return fmt.Errorf("%w: %#v (did the encryption key change?): %v", ErrDecryption, k, err)
+		}
+
+		decrypted[k] = plaintext
+	}
+
+	s.gcm = gcm
+	s.sensitive = make(map[string]bool, len(sensitiveKeys))
+
+	for _, k := range sensitiveKeys {
+		s.sensitive[k] = true
+	}
+
+	for k, plaintext := range decrypted {
+		s.data[k] = plaintext
+	}
+
+	return s.save()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	sum := sha256.Sum256(key)
+
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptedPayload returns the ciphertext if raw is an encrypted value.
+func encryptedPayload(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || raw[0] != '{' {
+		return "", false
+	}
+
+	var value encryptedValue
+
+	if err := json.Unmarshal(raw, &value); err != nil || len(value) != 1 {
+		return "", false
+	}
+
+	ciphertext, ok := value[encryptedField]
+
+	return ciphertext, ok
+}
+
+func (s *State) encrypt(plaintext json.RawMessage) (json.RawMessage, error) {
+	nonce := make([]byte, s.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := s.gcm.Seal(nonce, nonce, plaintext, nil)
+
+	return json.Marshal(encryptedValue{encryptedField: base64.StdEncoding.EncodeToString(sealed)})
+}
+
+// decrypt returns the plaintext of the first key which could decrypt the ciphertext.
+func decrypt(keys []cipher.AEAD, ciphertext string) (plaintext json.RawMessage, err error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, gcm := range keys {
+		if len(sealed) < gcm.NonceSize() {
+			return nil, errCiphertextTooShort
+		}
+
+		nonce, payload := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+		plaintext, err = gcm.Open(nil, nonce, payload, nil)
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,148 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	if err := ioutil.WriteFile(path, []byte(`{"password": "hunter2", "other": 42}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrate the existing plain text state.
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetEncryption([]byte("key"), nil, []string{"password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(content), "hunter2") || !strings.Contains(string(content), encryptedField) {
+		t.Errorf("state.json = %s, want the password to be encrypted", content)
+	}
+
+	check := func(s *State, wantPassword string) {
+		t.Helper()
+
+		var (
+			password string
+			other    int
+		)
+
+		if err := s.Get("password", &password); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Get("other", &other); err != nil {
+			t.Fatal(err)
+		}
+
+		if password != wantPassword || other != 42 {
+			t.Errorf("password, other = %#v, %d, want %#v, 42", password, other, wantPassword)
+		}
+	}
+
+	check(s, "hunter2")
+
+	// Without the key, the encrypted value is missing and kept as-is.
+	s, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check(s, "")
+
+	// With the key, it's decrypted.
+	s, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetEncryption([]byte("key"), nil, []string{"password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	check(s, "hunter2")
+
+	// Disabling the encryption stores the value in plain text again.
+	if err := s.SetEncryption([]byte("key"), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ = ioutil.ReadFile(path)
+	if !strings.Contains(string(content), "hunter2") {
+		t.Errorf("state.json = %s, want the password in plain text", content)
+	}
+
+	// A wrong key is an error and the encrypted value is kept.
+	if err := s.SetEncryption([]byte("key"), nil, []string{"password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetEncryption([]byte("other key"), nil, []string{"password"}); !errors.Is(err, ErrDecryption) {
+		t.Errorf("SetEncryption(wrong key) = %v, want %v", err, ErrDecryption)
+	}
+
+	check(s, "")
+
+	content, _ = ioutil.ReadFile(path)
+	if !strings.Contains(string(content), encryptedField) {
+		t.Errorf("state.json = %s, want the encrypted password to be kept", content)
+	}
+
+	// The previous key allows to change the key.
+	if err := s.SetEncryption([]byte("other key"), [][]byte{[]byte("key")}, []string{"password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	check(s, "hunter2")
+
+	s, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetEncryption([]byte("other key"), nil, []string{"password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	check(s, "hunter2")
+}
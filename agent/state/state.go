@@ -17,79 +17,408 @@
 package state
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
 	"encoding/json"
+	"fmt"
 	"glouton/logger"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
+// zstdMagic starts all Zstandard frames. It's used to detect a compressed state file.
+//
+//nolint:gochecknoglobals
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// backupInterval is the minimal delay between two backups rotation. The state is saved on
+// each change, rotating on each save would make all backups nearly identical.
+const backupInterval = time.Hour
+
 // State is state.json.
 type State struct {
 	data map[string]json.RawMessage
 
 	l    sync.Mutex
 	path string
+
+	// gcm encrypts the values of sensitive keys, see SetEncryption.
+	gcm       cipher.AEAD
+	sensitive map[string]bool
+
+	compress     bool
+	fileSize     int64
+	rawSize      int64
+	saveDuration time.Duration
+
+	backups      int
+	lastRotation time.Time
+
+	// cache holds the values of cacheKeys when a cache file is used, see SetCache.
+	cachePath     string
+	cache         map[string]json.RawMessage
+	cacheKeys     map[string]bool
+	cacheInterval time.Duration
+	cacheDirty    bool
+	lastCacheSave time.Time
+	cacheFileSize int64
+	cacheRawSize  int64
 }
 
 // Load load state.json file.
+//
+// If the file is corrupted, the most recent valid backup (state.json.1, state.json.2...) is
+// used and the corrupted file is kept as state.json.corrupted.
 func Load(path string) (*State, error) {
 	state := State{
 		path: path,
 		data: make(map[string]json.RawMessage),
 	}
 
-	f, err := os.Open(path)
-	if err != nil && os.IsNotExist(err) {
+	data, compressed, err := readFile(path)
+
+	switch {
+	case err != nil && os.IsNotExist(err):
 		return &state, nil
-	} else if err != nil {
-		return nil, err
+	case err != nil:
+		if _, ok := err.(*os.PathError); ok {
+			return nil, err
+		}
+
+		data, compressed, err = state.loadBackup(err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	state.data = data
+	state.compress = compressed
+
+	return &state, nil
+}
+
+// readFile reads and decodes a state file, compressed or not.
+func readFile(path string) (data map[string]json.RawMessage, compressed bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
 	}
 
 	defer f.Close()
 
-	decoder := json.NewDecoder(f)
-	err = decoder.Decode(&state.data)
+	var r io.Reader = bufio.NewReader(f)
+
+	if magic, _ := r.(*bufio.Reader).Peek(len(zstdMagic)); bytes.Equal(magic, zstdMagic) {
+		compressed = true
+
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, compressed, err
+		}
+
+		defer zr.Close()
+
+		r = zr
+	}
+
+	decoder := json.NewDecoder(r)
+	err = decoder.Decode(&data)
+
+	if err == nil && data == nil {
+		err = fmt.Errorf("%s doesn't contain a JSON object", path)
+	}
+
+	return data, compressed, err
+}
+
+// loadBackup returns the content of the most recent valid backup.
+func (s *State) loadBackup(loadErr error) (map[string]json.RawMessage, bool, error) {
+	for i := 1; ; i++ {
+		backup := backupPath(s.path, i)
+
+		stat, err := os.Stat(backup)
+		if err != nil {
+			break
+		}
+
+		data, compressed, err := readFile(backup)
+		if err != nil {
+			logger.Printf("State backup %s is also unusable: %v", backup, err)
+
+			continue
+		}
+
+		logger.Printf(
+			"State file %s is unusable (%v), restored backup %s. Changes made since %s are lost",
+			s.path, loadErr, backup, stat.ModTime().Format(time.RFC3339),
+		)
+
+		if err := os.Rename(s.path, s.path+".corrupted"); err != nil {
+			logger.V(1).Printf("Unable to keep the corrupted state file: %v", err)
+		}
+
+		return data, compressed, nil
+	}
+
+	return nil, false, loadErr
+}
+
+func backupPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// SetBackupCount sets the number of backups of the state file to keep. Backups are rotated
+// at most once per hour and used by Load when the state file is corrupted.
+func (s *State) SetBackupCount(n int) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.backups = n
+}
+
+// SetCompression enables the Zstandard compression of the state file. The file is
+// rewritten if its format changes. Load detects the format, so it could be disabled later.
+func (s *State) SetCompression(enabled bool) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.compress == enabled {
+		return nil
+	}
+
+	s.compress = enabled
+
+	if s.cachePath != "" {
+		if err := s.saveCache(); err != nil {
+			return err
+		}
+	}
+
+	return s.save()
+}
+
+// Sizes returns the size of the state files (including the cache file), their uncompressed
+// size and the duration of the last save of the state file.
+func (s *State) Sizes() (fileSize int64, rawSize int64, saveDuration time.Duration) {
+	s.l.Lock()
+	defer s.l.Unlock()
 
-	return &state, err
+	return s.fileSize + s.cacheFileSize, s.rawSize + s.cacheRawSize, s.saveDuration
 }
 
-// Save will write back the State to state.json.
+// Save will write back the State to state.json, and the cache to its file.
 func (s *State) Save() error {
 	s.l.Lock()
 	defer s.l.Unlock()
 
+	if s.cachePath != "" {
+		if err := s.saveCache(); err != nil {
+			logger.Printf("Unable to save the cache file: %v", err)
+		}
+	}
+
 	return s.save()
 }
 
 func (s *State) save() error {
-	err := s.saveTo(s.path + ".tmp")
+	start := time.Now()
+
+	data := s.data
+
+	if s.gcm != nil && len(s.sensitive) > 0 {
+		data = make(map[string]json.RawMessage, len(s.data))
+
+		for k, v := range s.data {
+			if s.sensitive[k] {
+				var err error
+
+				if v, err = s.encrypt(v); err != nil {
+					return err
+				}
+			}
+
+			data[k] = v
+		}
+	}
+
+	fileSize, rawSize, err := s.writeFile(s.path, data, s.rotateBackups)
 	if err != nil {
 		return err
 	}
 
-	err = os.Rename(s.path+".tmp", s.path)
+	s.fileSize = fileSize
+	s.rawSize = rawSize
+	s.saveDuration = time.Since(start)
 
-	return err
+	return nil
 }
 
-func (s *State) saveTo(path string) error {
-	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+// writeFile atomically replaces the file at path. beforeReplace is called once the new
+// content is written and validated.
+func (s *State) writeFile(path string, data map[string]json.RawMessage, beforeReplace func() error) (fileSize int64, rawSize int64, err error) {
+	tmpPath := path + ".tmp"
+
+	fileSize, rawSize, err = s.writeTo(tmpPath, data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Never replace the state file by one we can't load.
+	if _, _, err := readFile(tmpPath); err != nil {
+		return 0, 0, fmt.Errorf("the written state is invalid: %v", err)
+	}
+
+	if beforeReplace != nil {
+		if err := beforeReplace(); err != nil {
+			logger.V(1).Printf("Unable to rotate state file backups: %v", err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, 0, err
+	}
+
+	syncDir(filepath.Dir(path))
+
+	return fileSize, rawSize, nil
+}
+
+// rotateBackups shifts the backups and copies the current state file as the first one.
+func (s *State) rotateBackups() error {
+	if s.backups <= 0 || time.Since(s.lastRotation) < backupInterval {
+		return nil
+	}
+
+	if _, err := os.Stat(s.path); err != nil {
+		// Nothing to backup, e.g. the corrupted file was moved away by Load.
+		return nil
+	}
+
+	s.lastRotation = time.Now()
+
+	// Remove the oldest backup and the ones exceeding a lowered backup count.
+	for i := s.backups; ; i++ {
+		if err := os.Remove(backupPath(s.path, i)); err != nil {
+			break
+		}
+	}
+
+	for i := s.backups - 1; i >= 1; i-- {
+		err := os.Rename(backupPath(s.path, i), backupPath(s.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return copyFile(s.path, backupPath(s.path, 1))
+}
+
+// copyFile atomically copies src to dst.
+func copyFile(src string, dst string) error {
+	r, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 
-	defer w.Close()
+	defer r.Close()
+
+	w, err := os.OpenFile(dst+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
 
-	encoder := json.NewEncoder(w)
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = w.Sync()
+	}
+
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
 
-	err = encoder.Encode(s.data)
 	if err != nil {
 		return err
 	}
 
+	return os.Rename(dst+".tmp", dst)
+}
+
+// syncDir flushes the directory entries, which make a rename durable. It's not supported
+// on all systems and errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+
+	defer d.Close()
+
+	_ = d.Sync()
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+
+	return n, err
+}
+
+func (s *State) writeTo(path string, data map[string]json.RawMessage) (fileSize int64, rawSize int64, err error) {
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer w.Close()
+
+	buffer := bufio.NewWriter(w)
+	file := &countingWriter{w: buffer}
+	raw := file
+
+	var zw *zstd.Encoder
+
+	if s.compress {
+		zw, err = zstd.NewWriter(file, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return 0, 0, err
+		}
+
+		raw = &countingWriter{w: zw}
+	}
+
+	encoder := json.NewEncoder(raw)
+
+	err = encoder.Encode(data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := buffer.Flush(); err != nil {
+		return 0, 0, err
+	}
+
 	_ = w.Sync()
 
-	return nil
+	return file.count, raw.count, nil
 }
 
 // Set save an object.
@@ -102,6 +431,15 @@ func (s *State) Set(key string, object interface{}) error {
 		return err
 	}
 
+	if s.isCacheKey(key) {
+		s.cache[key] = json.RawMessage(buffer)
+		s.cacheDirty = true
+
+		s.saveCacheIfDue()
+
+		return nil
+	}
+
 	s.data[key] = json.RawMessage(buffer)
 
 	err = s.save()
@@ -117,6 +455,17 @@ func (s *State) Delete(key string) error {
 	s.l.Lock()
 	defer s.l.Unlock()
 
+	if s.isCacheKey(key) {
+		if _, ok := s.cache[key]; ok {
+			delete(s.cache, key)
+			s.cacheDirty = true
+
+			s.saveCacheIfDue()
+		}
+
+		return nil
+	}
+
 	if _, ok := s.data[key]; !ok {
 		return nil
 	}
@@ -136,11 +485,21 @@ func (s *State) Get(key string, result interface{}) error {
 	s.l.Lock()
 	defer s.l.Unlock()
 
-	buffer, ok := s.data[key]
+	data := s.data
+	if s.isCacheKey(key) {
+		data = s.cache
+	}
+
+	buffer, ok := data[key]
 	if !ok {
 		return nil
 	}
 
+	// Without the key, an encrypted value is the same as a missing one.
+	if _, encrypted := encryptedPayload(buffer); encrypted {
+		return nil
+	}
+
 	err := json.Unmarshal(buffer, &result)
 
 	return err
@@ -0,0 +1,175 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := make([]string, 1000)
+	for i := range cache {
+		cache[i] = "container_cpu_used{container_name=\"web\"}"
+	}
+
+	if err := s.Set("cache", cache); err != nil {
+		t.Fatal(err)
+	}
+
+	plainSize, _, _ := s.Sizes()
+
+	if err := s.SetCompression(true); err != nil {
+		t.Fatal(err)
+	}
+
+	fileSize, rawSize, _ := s.Sizes()
+	if fileSize >= plainSize/10 || rawSize != plainSize {
+		t.Errorf("Sizes() = %d, %d, want a compressed size much lower than %d", fileSize, rawSize, plainSize)
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	if !bytes.HasPrefix(content, zstdMagic) {
+		t.Errorf("state file isn't compressed")
+	}
+
+	for _, compressed := range []bool{true, false} {
+		s, err = Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+
+		if err := s.Get("cache", &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != len(cache) || got[0] != cache[0] {
+			t.Errorf("Get(cache) returned %d entries, want %d", len(got), len(cache))
+		}
+
+		// Disable the compression for the next iteration.
+		if compressed {
+			if err := s.SetCompression(false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	content, _ = ioutil.ReadFile(path)
+	if content[0] != '{' {
+		t.Errorf("state file is still compressed")
+	}
+}
+
+func TestBackupRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetBackupCount(2)
+
+	for _, value := range []string{"v1", "v2", "v3", "v4"} {
+		if err := s.Set("key", value); err != nil {
+			t.Fatal(err)
+		}
+
+		// Force a rotation on next save
+		s.lastRotation = time.Time{}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than 2 backups are kept")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"key": "v4`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed despite backups: %v", err)
+	}
+
+	var got string
+
+	if err := s.Get("key", &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "v3" {
+		t.Errorf("Get(key) = %#v, want %#v", got, "v3")
+	}
+
+	if _, err := os.Stat(path + ".corrupted"); err != nil {
+		t.Errorf("corrupted state file wasn't kept: %v", err)
+	}
+
+	// The corrupted file must never become a backup.
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := readFile(path + ".1"); err != nil {
+		t.Errorf("backup is invalid: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path+".1", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path+".2", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Error("Load() succeeded without valid state file nor backup")
+	}
+}
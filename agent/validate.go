@@ -0,0 +1,497 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"glouton/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	bbConf "github.com/prometheus/blackbox_exporter/config"
+	"gopkg.in/yaml.v3"
+)
+
+//nolint:gochecknoglobals
+var (
+	// extraConfigKeys are keys without a default value which are read by Glouton or
+	// written by the packages.
+	extraConfigKeys = map[string]interface{}{
+		"distribution":     "",
+		"logging.filename": "",
+	}
+	deprecatedConfigKeys = map[string]string{
+		"metric.pull": "it's not supported by Glouton, use metric.prometheus for custom metrics",
+	}
+	thresholdKeys  = []string{"low_critical", "low_warning", "high_warning", "high_critical"}
+	yamlLineRegexp = regexp.MustCompile(`line (\d+): `)
+)
+
+// ConfigIssue is a problem found in a configuration file.
+type ConfigIssue struct {
+	File    string
+	Line    int
+	Key     string
+	Message string
+}
+
+func (i ConfigIssue) String() string {
+	location := i.File
+	if i.Line > 0 {
+		location = fmt.Sprintf("%s:%d", i.File, i.Line)
+	}
+
+	if i.Key == "" {
+		return fmt.Sprintf("%s: %s", location, i.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", location, i.Key, i.Message)
+}
+
+type configValidator struct {
+	issues []ConfigIssue
+	// targets are the blackbox targets. Their module is checked once all files are loaded.
+	targets []blackboxTargetNode
+}
+
+type blackboxTargetNode struct {
+	file   string
+	line   int
+	module string
+}
+
+// ValidateConfig checks the configuration files and returns the issues found: unknown keys,
+// values with the wrong type, invalid thresholds and invalid blackbox modules.
+func ValidateConfig(configFiles []string) ([]ConfigIssue, error) {
+	a := &agent{}
+
+	// Errors are ignored, each file is checked below to report them with their location.
+	cfg, _, _ := a.loadConfiguration(configFiles)
+
+	v := &configValidator{}
+
+	for _, path := range cfg.StringList("config_files") {
+		stat, err := os.Stat(path)
+		if err != nil && os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !stat.IsDir() {
+			v.validateFile(path)
+
+			continue
+		}
+
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			if strings.HasSuffix(f.Name(), ".conf") {
+				v.validateFile(filepath.Join(path, f.Name()))
+			}
+		}
+	}
+
+	modules, _ := cfg.Get("blackbox.modules")
+	modulesMap, _ := convertToMap(modules)
+
+	for _, target := range v.targets {
+		if _, ok := modulesMap[target.module]; !ok {
+			v.addIssue(target.file, target.line, "blackbox.targets", "unknown module %#v", target.module)
+		}
+	}
+
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		if v.issues[i].File == v.issues[j].File {
+			return v.issues[i].Line < v.issues[j].Line
+		}
+
+		return v.issues[i].File < v.issues[j].File
+	})
+
+	return v.issues, nil
+}
+
+func (v *configValidator) addIssue(file string, line int, key string, format string, args ...interface{}) {
+	v.issues = append(v.issues, ConfigIssue{
+		File:    file,
+		Line:    line,
+		Key:     key,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *configValidator) validateFile(file string) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		v.addIssue(file, 0, "", "%v", err)
+
+		return
+	}
+
+	var root yaml.Node
+
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		line := 0
+
+		if m := yamlLineRegexp.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+
+		v.addIssue(file, line, "", "invalid YAML: %s", yamlLineRegexp.ReplaceAllString(err.Error(), ""))
+
+		return
+	}
+
+	if len(root.Content) == 0 {
+		return
+	}
+
+	v.validateMap(file, "", root.Content[0])
+}
+
+func (v *configValidator) validateMap(file string, prefix string, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		if prefix == "" && node.Tag != "!!null" {
+			v.addIssue(file, node.Line, "", "the configuration must be a map")
+		}
+
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		key := keyNode.Value
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if reason, ok := deprecatedConfigKeys[key]; ok {
+			v.addIssue(file, keyNode.Line, key, "deprecated key, %s", reason)
+
+			continue
+		}
+
+		knownKey, sample, ok := lookupConfigKey(key)
+
+		switch {
+		case ok:
+			v.validateValue(file, knownKey, valueNode, sample)
+		case isConfigKeyPrefix(key):
+			if valueNode.Kind != yaml.MappingNode {
+				v.addIssue(file, valueNode.Line, key, "expected a map")
+
+				continue
+			}
+
+			v.validateMap(file, key, valueNode)
+		default:
+			v.addIssue(file, keyNode.Line, key, "unknown key%s", suggestionMessage(suggestConfigKeys(key)))
+		}
+	}
+}
+
+// lookupConfigKey returns the key set by key and its default value, which gives its type.
+// It's a different key for secret files, e.g. "bleemeo.registration_key" for
+// "bleemeo.registration_key_file".
+func lookupConfigKey(key string) (knownKey string, sample interface{}, ok bool) {
+	sample, ok = defaultConfig[key]
+	if !ok {
+		sample, ok = extraConfigKeys[key]
+	}
+
+	if base, isSecretFile := config.SecretFileKey(key); !ok && isSecretFile {
+		if _, isString := defaultConfig[base].(string); isString {
+			return base, "", true
+		}
+	}
+
+	return key, sample, ok
+}
+
+func isConfigKeyPrefix(prefix string) bool {
+	for key := range defaultConfig {
+		if strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+
+	for key := range extraConfigKeys {
+		if strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *configValidator) validateValue(file string, key string, node *yaml.Node, sample interface{}) {
+	var value interface{}
+
+	if err := node.Decode(&value); err != nil {
+		v.addIssue(file, node.Line, key, "%v", err)
+
+		return
+	}
+
+	// An empty value is the same as not setting the key. Environment variables are
+	// only known when Glouton runs.
+	if str, ok := value.(string); value == nil || ok && config.HasInterpolation(str) {
+		return
+	}
+
+	varType := config.TypeUnknown
+
+	switch sample.(type) {
+	case string:
+		varType = config.TypeString
+	case []string, []interface{}:
+		varType = config.TypeStringList
+	case int:
+		varType = config.TypeInteger
+	case bool:
+		varType = config.TypeBoolean
+	case map[string]string, map[string]interface{}:
+		varType = config.TypeMap
+	}
+
+	if unitType := unitValueType(key); unitType != config.TypeUnknown {
+		varType = unitType
+	}
+
+	if err := config.CheckType(value, varType); err != nil {
+		v.addIssue(file, node.Line, key, "%v", err)
+
+		return
+	}
+
+	switch key {
+	case "thresholds":
+		v.validateThresholds(file, node)
+	case "blackbox.modules":
+		v.validateBlackboxModules(file, node)
+	case "blackbox.targets":
+		v.validateBlackboxTargets(file, node)
+	}
+}
+
+func (v *configValidator) validateThresholds(file string, node *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		metric, thresholdNode := node.Content[i].Value, node.Content[i+1]
+		key := "thresholds." + metric
+
+		if thresholdNode.Kind != yaml.MappingNode {
+			v.addIssue(file, thresholdNode.Line, key, "expected a map")
+
+			continue
+		}
+
+		values := make(map[string]float64)
+
+		for j := 0; j+1 < len(thresholdNode.Content); j += 2 {
+			nameNode, valueNode := thresholdNode.Content[j], thresholdNode.Content[j+1]
+
+			if !isThresholdKey(nameNode.Value) {
+				v.addIssue(file, nameNode.Line, key, "unknown threshold %#v, expected one of %s", nameNode.Value, strings.Join(thresholdKeys, ", "))
+
+				continue
+			}
+
+			if valueNode.Tag == "!!null" {
+				continue
+			}
+
+			var value float64
+
+			if valueNode.Kind != yaml.ScalarNode || (valueNode.Tag != "!!int" && valueNode.Tag != "!!float") || valueNode.Decode(&value) != nil {
+				v.addIssue(file, valueNode.Line, key+"."+nameNode.Value, "expected a number, got %#v", valueNode.Value)
+
+				continue
+			}
+
+			values[nameNode.Value] = value
+		}
+
+		// Thresholds must be in increasing order: low_critical <= low_warning <= high_warning <= high_critical.
+		previous := ""
+
+		for _, name := range thresholdKeys {
+			value, ok := values[name]
+			if !ok {
+				continue
+			}
+
+			if previous != "" && value < values[previous] {
+				v.addIssue(file, thresholdNode.Line, key, "%s (%v) is lower than %s (%v)", name, value, previous, values[previous])
+			}
+
+			previous = name
+		}
+	}
+}
+
+func isThresholdKey(name string) bool {
+	for _, k := range thresholdKeys {
+		if k == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *configValidator) validateBlackboxModules(file string, node *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, moduleNode := node.Content[i].Value, node.Content[i+1]
+		key := "blackbox.modules." + name
+
+		if moduleNode.Kind != yaml.MappingNode {
+			v.addIssue(file, moduleNode.Line, key, "expected a map")
+
+			continue
+		}
+
+		v.checkFields(file, key, moduleNode, reflect.TypeOf(bbConf.Module{}))
+
+		var module bbConf.Module
+
+		if err := moduleNode.Decode(&module); err != nil {
+			v.addIssue(file, moduleNode.Line, key, "%s", yamlLineRegexp.ReplaceAllString(err.Error(), ""))
+
+			continue
+		}
+
+		switch module.Prober {
+		case proberHTTP, proberTCP, proberICMP, proberDNS:
+		default:
+			v.addIssue(file, moduleNode.Line, key, "unknown prober %#v", module.Prober)
+		}
+	}
+}
+
+// The probers supported by the blackbox exporter.
+const (
+	proberHTTP = "http"
+	proberTCP  = "tcp"
+	proberICMP = "icmp"
+	proberDNS  = "dns"
+)
+
+// checkFields reports the keys of node which don't match a field of typ.
+func (v *configValidator) checkFields(file string, key string, node *yaml.Node, typ reflect.Type) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch {
+	case node.Kind == yaml.SequenceNode && (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array):
+		for _, item := range node.Content {
+			v.checkFields(file, key, item, typ.Elem())
+		}
+	case node.Kind == yaml.MappingNode && typ.Kind() == reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.checkFields(file, key+"."+node.Content[i].Value, node.Content[i+1], typ.Elem())
+		}
+	case node.Kind == yaml.MappingNode && typ.Kind() == reflect.Struct:
+		fields := make(map[string]reflect.Type)
+		yamlFields(typ, fields)
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+
+			fieldType, ok := fields[name]
+			if !ok {
+				v.addIssue(file, node.Content[i].Line, key, "unknown field %#v", name)
+
+				continue
+			}
+
+			v.checkFields(file, key+"."+name, node.Content[i+1], fieldType)
+		}
+	}
+}
+
+// yamlFields fills fields with the YAML name and type of the fields of the struct typ.
+func yamlFields(typ reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+
+		if tag[0] == "-" {
+			continue
+		}
+
+		if len(tag) > 1 && tag[1] == "inline" && field.Type.Kind() == reflect.Struct {
+			yamlFields(field.Type, fields)
+
+			continue
+		}
+
+		name := tag[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		fields[name] = field.Type
+	}
+}
+
+func (v *configValidator) validateBlackboxTargets(file string, node *yaml.Node) {
+	for _, targetNode := range node.Content {
+		if targetNode.Kind != yaml.MappingNode {
+			v.addIssue(file, targetNode.Line, "blackbox.targets", "expected a map")
+
+			continue
+		}
+
+		var target struct {
+			URL    string                 `yaml:"url"`
+			Module string                 `yaml:"module"`
+			DNS    map[string]interface{} `yaml:"dns"`
+		}
+
+		if err := targetNode.Decode(&target); err != nil {
+			v.addIssue(file, targetNode.Line, "blackbox.targets", "%s", yamlLineRegexp.ReplaceAllString(err.Error(), ""))
+
+			continue
+		}
+
+		if target.URL == "" {
+			v.addIssue(file, targetNode.Line, "blackbox.targets", "url is required")
+		}
+
+		// Targets using the DNS shortcut don't need a module.
+		if target.DNS != nil {
+			continue
+		}
+
+		v.targets = append(v.targets, blackboxTargetNode{file: file, line: targetNode.Line, module: target.Module})
+	}
+}
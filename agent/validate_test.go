@@ -0,0 +1,97 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	content := `web:
+    enabled: maybe
+    listener:
+        port: 8015
+        adress: 127.0.0.1
+thresholds:
+    cpu_used:
+        high_warning: 90
+        high_critical: 80
+    mem_used_perc:
+        high_warn: 1
+blackbox:
+    modules:
+        custom:
+            prober: http
+            http:
+                bogus: true
+    targets:
+        - url: https://example.com
+          module: custom
+        - url: https://example.com
+          module: missing
+`
+	filename := filepath.Join(dir, "glouton.conf")
+
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := ValidateConfig([]string{filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ConfigIssue{
+		{File: filename, Line: 2, Key: "web.enabled"},
+		{File: filename, Line: 5, Key: "web.listener.adress"},
+		{File: filename, Line: 8, Key: "thresholds.cpu_used"},
+		{File: filename, Line: 11, Key: "thresholds.mem_used_perc"},
+		{File: filename, Line: 17, Key: "blackbox.modules.custom.http"},
+		{File: filename, Line: 21, Key: "blackbox.targets"},
+	}
+
+	if len(issues) != len(want) {
+		t.Fatalf("ValidateConfig() = %v, want %d issues", issues, len(want))
+	}
+
+	for i, issue := range issues {
+		if issue.File != want[i].File || issue.Line != want[i].Line || issue.Key != want[i].Key {
+			t.Errorf("issues[%d] = %v, want %s:%d %s", i, issue, want[i].File, want[i].Line, want[i].Key)
+		}
+	}
+}
+
+func TestValidateConfigShipped(t *testing.T) {
+	issues, err := ValidateConfig([]string{"../etc/glouton.conf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, issue := range issues {
+		t.Error(issue)
+	}
+}
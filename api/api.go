@@ -27,32 +27,65 @@ import (
 	"net/http"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
+	"glouton/audit"
+	"glouton/check"
+	"glouton/collector"
+	"glouton/config"
 	"glouton/discovery"
 	"glouton/facts"
 	"glouton/logger"
+	"glouton/prometheus/registry"
+	"glouton/task"
 	"glouton/threshold"
 	"glouton/types"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/rs/cors"
 )
 
 type storeInterface interface {
 	Metrics(filters map[string]string) (result []types.Metric, err error)
+	MetricsMatching(matchers []*labels.Matcher) []types.Metric
 }
 
 type dockerInterface interface {
 	Containers(ctx context.Context, maxAge time.Duration, includeIgnored bool) (containers []facts.Container, err error)
 }
 
+type packagesInterface interface {
+	Packages() (packages []facts.Package, hash string, updatedAt time.Time)
+}
+
+type tasksInterface interface {
+	Statuses() []task.Status
+}
+
+type inputsInterface interface {
+	GatherResults() []collector.GatherResult
+}
+
+type gatherersInterface interface {
+	GathererStatuses() []registry.GathererStatus
+}
+
+type auditLogInterface interface {
+	Events() []audit.Event
+}
+
 type agentInterface interface {
 	BleemeoRegistrationAt() time.Time
 	BleemeoLastReport() time.Time
 	BleemeoConnected() bool
+	BleemeoScheduleFullSync() bool
+	FireTrigger(discovery bool, sendFacts bool, systemUpdateMetric bool, secondDiscovery bool)
 	Tags() []string
 }
 
@@ -70,8 +103,22 @@ type API struct {
 	Threshold          *threshold.Registry
 	DiagnosticPage     func() string
 	DiagnosticZip      func(w io.Writer) error
+	RequestsCounter    *prometheus.CounterVec
+	PassiveChecks      map[string]*check.PassiveCheck
+	Packages           packagesInterface
+	Tasks              tasksInterface
+	Inputs             inputsInterface
+	Gatherers          gatherersInterface
+	ConfigSources      func() []config.KeySource
+	ConfigDump         func() interface{}
+	AuditLog           auditLogInterface
+	PointsHub          *PointsHub
+	Auth               *Authenticator
 
 	router http.Handler
+
+	triggerLock     sync.Mutex
+	lastBleemeoSync time.Time
 }
 
 type gloutonUIConfig struct {
@@ -95,10 +142,12 @@ func (f *assetsFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (api *API) init() {
 	router := chi.NewRouter()
+	// Other origins could only read from the API. Routes which change the agent
+	// require a JSON body or a token, so web pages can't call them.
 	router.Use(cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowCredentials: true,
-		Debug:            false,
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet, http.MethodHead},
+		Debug:          false,
 	}).Handler)
 
 	staticFolder := AssetFile()
@@ -146,37 +195,63 @@ func (api *API) init() {
 		}
 	}
 
-	router.Handle("/metrics", api.PrometheurExporter)
-	router.Handle("/playground", playground.Handler("GraphQL playground", "/graphql"))
-	router.Handle("/graphql", handler.NewDefaultServer(NewExecutableSchema(Config{Resolvers: &Resolver{api: api}})))
-	router.HandleFunc("/diagnostic", func(w http.ResponseWriter, r *http.Request) {
-		content := api.DiagnosticPage()
-
-		var err error
-
-		if diagnosticTmpl == nil {
-			fmt.Fprintln(w, "diagnostic.html load failed. Fallback to simple text")
-			_, err = w.Write([]byte(content))
-		} else {
-			err = diagnosticTmpl.Execute(w, content)
-		}
-
-		if err != nil {
-			logger.V(2).Printf("failed to serve index.html: %v", err)
-		}
-	})
-
-	router.HandleFunc("/diagnostic.zip", func(w http.ResponseWriter, r *http.Request) {
-		hdr := w.Header()
-		hdr.Add("Content-Type", "application/zip")
-
-		if err := api.DiagnosticZip(w); err != nil {
-			logger.V(1).Printf("failed to serve diagnostic.zip: %v", err)
-		}
+	// Pages of the local UI only contain static content and are served without
+	// authentication, the data are fetched through the authenticated routes.
+	router.Group(func(r chi.Router) {
+		r.Use(api.Auth.Middleware)
+
+		r.Handle("/metrics", api.PrometheurExporter)
+		r.Handle("/graphql", handler.NewDefaultServer(NewExecutableSchema(Config{Resolvers: &Resolver{api: api}})))
+		r.HandleFunc("/diagnostic", func(w http.ResponseWriter, r *http.Request) {
+			content := api.DiagnosticPage()
+
+			var err error
+
+			if diagnosticTmpl == nil {
+				fmt.Fprintln(w, "diagnostic.html load failed. Fallback to simple text")
+				_, err = w.Write([]byte(content))
+			} else {
+				err = diagnosticTmpl.Execute(w, content)
+			}
+
+			if err != nil {
+				logger.V(2).Printf("failed to serve index.html: %v", err)
+			}
+		})
+
+		r.HandleFunc("/diagnostic.zip", func(w http.ResponseWriter, r *http.Request) {
+			hdr := w.Header()
+			hdr.Add("Content-Type", "application/zip")
+
+			if err := api.DiagnosticZip(w); err != nil {
+				logger.V(1).Printf("failed to serve diagnostic.zip: %v", err)
+			}
+		})
+
+		r.Get("/logs", api.logsHandler)
+		r.Get("/audit", api.auditHandler)
+		r.Post("/passive_check", api.passiveCheckHandler)
+		r.Get("/maintenance", api.maintenanceListHandler)
+		r.Post("/maintenance", api.maintenanceAddHandler)
+		r.Delete("/maintenance/{id}", api.maintenanceDeleteHandler)
+		r.Get("/services/manual", api.manualServicesListHandler)
+		r.Post("/services/manual", api.manualServiceSetHandler)
+		r.Put("/services/manual/{name}", api.manualServiceSetHandler)
+		r.Delete("/services/manual/{name}", api.manualServiceDeleteHandler)
+		r.Post("/trigger/{name}", api.triggerHandler)
+		r.Get("/packages", api.packagesHandler)
+		r.Get("/tasks", api.tasksHandler)
+		r.Get("/config/sources", api.configSourcesHandler)
+		r.Get("/debug/runtime", api.debugRuntimeHandler)
+		r.Get("/debug/tasks", api.tasksHandler)
+		r.Get("/debug/config", api.debugConfigHandler)
+		r.Get("/debug/inputs", api.debugInputsHandler)
+		r.Get("/topinfo/stream", api.topInfoStreamHandler)
 	})
 
+	router.Handle("/playground", playground.Handler("GraphQL playground", "/graphql"))
 	router.Handle("/static/*", http.StripPrefix("/static", &assetsFileServer{fs: http.FileServer(staticFolder)}))
-	router.HandleFunc("/*", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/*", api.Auth.LoginHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		if indexTmpl == nil {
 			_, err = w.Write(fallbackIndex)
@@ -188,9 +263,13 @@ func (api *API) init() {
 		if err != nil {
 			logger.V(2).Printf("fail to serve index.html: %v", err)
 		}
-	})
+	})))
 
-	api.router = router
+	if api.RequestsCounter != nil {
+		api.router = promhttp.InstrumentHandlerCounter(api.RequestsCounter, router)
+	} else {
+		api.router = router
+	}
 }
 
 // Run starts our API.
@@ -0,0 +1,71 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"glouton/audit"
+	"glouton/logger"
+	"net/http"
+	"strconv"
+)
+
+// auditHandler returns the audit log, oldest first. The query parameter "kind"
+// keeps only the events of this kind and "limit" keeps only the most recent events.
+func (api *API) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if api.AuditLog == nil {
+		http.Error(w, "audit log is not available", http.StatusNotFound)
+		return
+	}
+
+	events := api.AuditLog.Events()
+
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		filtered := events[:0]
+
+		for _, e := range events {
+			if e.Kind == kind {
+				filtered = append(filtered, e)
+			}
+		}
+
+		events = filtered
+	}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %#v", value), http.StatusBadRequest)
+			return
+		}
+
+		if len(events) > limit {
+			events = events[len(events)-limit:]
+		}
+	}
+
+	if events == nil {
+		events = []audit.Event{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		logger.V(1).Printf("Failed to encode audit log: %v", err)
+	}
+}
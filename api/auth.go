@@ -0,0 +1,552 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // register SHA-256 for crypto.SHA256
+	_ "crypto/sha512" // register SHA-384 and SHA-512
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"glouton/logger"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minKeysRefreshInterval limit how often the OIDC signing keys are fetched
+	// when a token use an unknown key.
+	minKeysRefreshInterval = time.Minute
+	// clockSkew is the tolerance on token expiration and not-before.
+	clockSkew = time.Minute
+	// tokenCookieName is the cookie holding the token of the local UI. Browsers can't add
+	// an Authorization header to the requests of the UI, like the GraphQL WebSocket.
+	tokenCookieName = "glouton_token"
+	// tokenQueryParameter is the query parameter with a token, used to login on the local
+	// UI and accepted on WebSocket handshakes.
+	tokenQueryParameter = "access_token"
+)
+
+var (
+	errUnauthenticated = errors.New("missing bearer token")
+	errInvalidToken    = errors.New("invalid token")
+	errUnknownKey      = errors.New("unknown signing key")
+)
+
+// Authenticator validate bearer tokens on the local API.
+//
+// A token is accepted if it's one of the StaticTokens or if it's a JWT signed by the
+// OIDC Issuer and valid for the Audience. When neither are configured, all requests are allowed.
+type Authenticator struct {
+	StaticTokens []string
+	Issuer       string
+	Audience     string
+
+	httpClient *http.Client
+
+	l             sync.Mutex
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+	keysFetching  chan struct{}
+}
+
+// Enabled returns whether requests need to be authenticated.
+func (a *Authenticator) Enabled() bool {
+	return a != nil && (len(a.StaticTokens) > 0 || a.Issuer != "")
+}
+
+// Middleware returns an handler which reject unauthenticated requests.
+// Routes that must stay reachable without a token, like the local UI pages,
+// are registered outside of this middleware.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	if !a.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := a.authenticate(r.Context(), requestToken(r)); err != nil {
+			logger.V(2).Printf("Rejected unauthenticated request on %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="glouton"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LoginHandler returns an handler which stores the token given in the access_token query
+// parameter in a cookie and redirects to the same page without the token, so the local UI
+// opened with http://localhost:8015/?access_token=<token> could fetch its data.
+// Requests without this parameter are served by next.
+func (a *Authenticator) LoginHandler(next http.Handler) http.Handler {
+	if !a.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get(tokenQueryParameter)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := a.authenticate(r.Context(), token); err != nil {
+			logger.V(2).Printf("Rejected login on the local UI from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		// SameSite prevents other sites from sending requests, including WebSocket
+		// handshakes, with this cookie.
+		http.SetCookie(w, &http.Cookie{
+			Name:     tokenCookieName,
+			Value:    token,
+			Path:     "/",
+			Secure:   r.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+	})
+}
+
+// requestToken returns the token of the request: the bearer token of the Authorization header,
+// the token query parameter of a WebSocket handshake or the token cookie of the local UI.
+func requestToken(r *http.Request) string {
+	const prefix = "bearer "
+
+	if header := r.Header.Get("Authorization"); header != "" {
+		if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+			return ""
+		}
+
+		return strings.TrimSpace(header[len(prefix):])
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		if token := r.URL.Query().Get(tokenQueryParameter); token != "" {
+			return token
+		}
+	}
+
+	if cookie, err := r.Cookie(tokenCookieName); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+func (a *Authenticator) authenticate(ctx context.Context, token string) error {
+	if token == "" {
+		return errUnauthenticated
+	}
+
+	for _, static := range a.StaticTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(static)) == 1 {
+			return nil
+		}
+	}
+
+	if a.Issuer == "" {
+		return errInvalidToken
+	}
+
+	return a.verifyJWT(ctx, token, time.Now())
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+}
+
+func (c jwtClaims) hasAudience(audience string) bool {
+	var single string
+
+	if err := json.Unmarshal(c.Audience, &single); err == nil {
+		return single == audience
+	}
+
+	var list []string
+
+	if err := json.Unmarshal(c.Audience, &list); err != nil {
+		return false
+	}
+
+	for _, v := range list {
+		if v == audience {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func (a *Authenticator) verifyJWT(ctx context.Context, token string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: not a JWT", errInvalidToken)
+	}
+
+	var (
+		header jwtHeader
+		claims jwtClaims
+	)
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+
+	key, err := a.key(ctx, header.KeyID)
+	if err != nil {
+		return err
+	}
+
+	if err := verifySignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return err
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(a.Issuer, "/") {
+		return fmt.Errorf("%w: issuer is %#v", errInvalidToken, claims.Issuer)
+	}
+
+	if a.Audience != "" && !claims.hasAudience(a.Audience) {
+		return fmt.Errorf("%w: audience %s not allowed", errInvalidToken, claims.Audience)
+	}
+
+	if claims.ExpiresAt == 0 || now.Add(-clockSkew).Unix() > claims.ExpiresAt {
+		return fmt.Errorf("%w: token is expired", errInvalidToken)
+	}
+
+	if claims.NotBefore != 0 && now.Add(clockSkew).Unix() < claims.NotBefore {
+		return fmt.Errorf("%w: token is not yet valid", errInvalidToken)
+	}
+
+	return nil
+}
+
+// ecdsaCurves is the curve required by each ECDSA algorithm (RFC 7518 section 3.4).
+var ecdsaCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+func verifySignature(algorithm string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	var hash crypto.Hash
+
+	switch algorithm {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %#v", errInvalidToken, algorithm)
+	}
+
+	h := hash.New()
+	_, _ = h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(algorithm, "RS") {
+			return fmt.Errorf("%w: algorithm %s doesn't match a RSA key", errInvalidToken, algorithm)
+		}
+
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidToken, err)
+		}
+	case *ecdsa.PublicKey:
+		curve, ok := ecdsaCurves[algorithm]
+		if !ok || k.Curve.Params().Name != curve.Params().Name {
+			return fmt.Errorf("%w: algorithm %s doesn't match an EC key on %s", errInvalidToken, algorithm, k.Curve.Params().Name)
+		}
+
+		// The signature is R and S as fixed size big-endian integers.
+		size := (curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("%w: signature has %d bytes, want %d", errInvalidToken, len(signature), 2*size)
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("%w: bad signature", errInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key type %T", errInvalidToken, key)
+	}
+
+	return nil
+}
+
+// key returns the issuer signing key with given ID. Keys are fetched again when
+// the ID is unknown, for example after a key rotation.
+//
+// The keys are fetched without holding the lock, so requests using a known key
+// aren't blocked by a slow issuer. Concurrent requests wait for the same fetch.
+func (a *Authenticator) key(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	a.l.Lock()
+
+	if key := a.lookupKey(keyID); key != nil {
+		a.l.Unlock()
+
+		return key, nil
+	}
+
+	done := a.keysFetching
+
+	if done == nil {
+		if time.Since(a.keysFetchedAt) < minKeysRefreshInterval {
+			a.l.Unlock()
+
+			return nil, fmt.Errorf("%w %#v", errUnknownKey, keyID)
+		}
+
+		a.keysFetchedAt = time.Now()
+		done = make(chan struct{})
+		a.keysFetching = done
+
+		go a.refreshKeys(done)
+	}
+
+	a.l.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if key := a.lookupKey(keyID); key != nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w %#v", errUnknownKey, keyID)
+}
+
+// refreshKeys fetches the issuer signing keys and closes done once they are updated.
+// It doesn't use the context of the request which triggered it, since other
+// requests may be waiting for the same fetch.
+func (a *Authenticator) refreshKeys(done chan struct{}) {
+	keys, err := a.fetchKeys(context.Background())
+	if err != nil {
+		logger.V(1).Printf("Unable to fetch OIDC signing keys from %s: %v", a.Issuer, err)
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if err == nil {
+		a.keys = keys
+	}
+
+	a.keysFetching = nil
+
+	close(done)
+}
+
+// lookupKey returns the known key with given ID. A token without key ID
+// is accepted when the issuer has a single key.
+func (a *Authenticator) lookupKey(keyID string) crypto.PublicKey {
+	if key, ok := a.keys[keyID]; ok {
+		return key
+	}
+
+	if keyID == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key
+		}
+	}
+
+	return nil
+}
+
+func (a *Authenticator) getJSON(ctx context.Context, url string, v interface{}) error {
+	if a.httpClient == nil {
+		a.httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: response code %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func (a *Authenticator) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+
+	if err := a.getJSON(ctx, strings.TrimSuffix(a.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := a.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			logger.V(2).Printf("Ignoring OIDC key %#v: %v", jwk.KeyID, err)
+			continue
+		}
+
+		keys[jwk.KeyID] = key
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %#v", k.Curve)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %#v", k.KeyType)
+	}
+}
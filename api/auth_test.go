@@ -0,0 +1,324 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, keyID string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": keyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var issuer string
+
+	jwksRequests := 0
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": %q}`, issuer, issuer+"/keys")
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		jwksRequests++
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": "key1",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	issuer = server.URL
+	now := time.Now()
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": issuer,
+			"aud": []string{"other", "glouton"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+	}
+
+	expired := validClaims()
+	expired["exp"] = now.Add(-time.Hour).Unix()
+
+	badAudience := validClaims()
+	badAudience["aud"] = "other"
+
+	badIssuer := validClaims()
+	badIssuer["iss"] = "https://evil.example.com"
+
+	auth := &Authenticator{
+		StaticTokens: []string{"static-secret"},
+		Issuer:       issuer,
+		Audience:     "glouton",
+	}
+
+	cases := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "no token", header: "", want: http.StatusUnauthorized},
+		{name: "static token", header: "Bearer static-secret", want: http.StatusOK},
+		{name: "wrong static token", header: "Bearer static-secre", want: http.StatusUnauthorized},
+		{name: "valid JWT", header: "Bearer " + signJWT(t, key, "key1", validClaims()), want: http.StatusOK},
+		{name: "expired JWT", header: "Bearer " + signJWT(t, key, "key1", expired), want: http.StatusUnauthorized},
+		{name: "bad audience", header: "Bearer " + signJWT(t, key, "key1", badAudience), want: http.StatusUnauthorized},
+		{name: "bad issuer", header: "Bearer " + signJWT(t, key, "key1", badIssuer), want: http.StatusUnauthorized},
+		{name: "bad signature", header: "Bearer " + signJWT(t, otherKey, "key1", validClaims()), want: http.StatusUnauthorized},
+		{name: "unknown key", header: "Bearer " + signJWT(t, key, "key2", validClaims()), want: http.StatusUnauthorized},
+		{name: "not a JWT", header: "Bearer a.b", want: http.StatusUnauthorized},
+	}
+
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+			if c.header != "" {
+				req.Header.Set("Authorization", c.header)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != c.want {
+				t.Errorf("status = %d, want %d", rec.Code, c.want)
+			}
+		})
+	}
+
+	if jwksRequests != 1 {
+		t.Errorf("jwksRequests = %d, want 1", jwksRequests)
+	}
+}
+
+func TestVerifySignatureECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := []byte("header.payload")
+	digest := sha256.Sum256(signed)
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := make([]byte, 64)
+	copy(signature[32-len(r.Bytes()):32], r.Bytes())
+	copy(signature[64-len(s.Bytes()):], s.Bytes())
+
+	cases := []struct {
+		name      string
+		algorithm string
+		key       crypto.PublicKey
+		signature []byte
+		wantValid bool
+	}{
+		{name: "valid", algorithm: "ES256", key: &key.PublicKey, signature: signature, wantValid: true},
+		{name: "algorithm for another curve", algorithm: "ES384", key: &key.PublicKey, signature: signature},
+		{name: "RSA algorithm", algorithm: "RS256", key: &key.PublicKey, signature: signature},
+		{name: "EC algorithm with RSA key", algorithm: "ES256", key: &rsaKey.PublicKey, signature: signature},
+		{name: "none algorithm", algorithm: "none", key: &key.PublicKey, signature: nil},
+		{name: "short signature", algorithm: "ES256", key: &key.PublicKey, signature: signature[1:]},
+		{name: "long signature", algorithm: "ES256", key: &key.PublicKey, signature: append([]byte{0}, signature...)},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			err := verifySignature(c.algorithm, c.key, signed, c.signature)
+			if c.wantValid && err != nil {
+				t.Errorf("verifySignature() = %v, want nil", err)
+			}
+
+			if !c.wantValid && !errors.Is(err, errInvalidToken) {
+				t.Errorf("verifySignature() = %v, want %v", err, errInvalidToken)
+			}
+		})
+	}
+}
+
+func TestAPIAuthRoutes(t *testing.T) {
+	api := &API{
+		Auth:               &Authenticator{StaticTokens: []string{"static-secret"}},
+		PrometheurExporter: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	api.init()
+
+	cases := []struct {
+		path   string
+		header string
+		want   int
+	}{
+		{path: "/", want: http.StatusOK},
+		{path: "/dashboard", want: http.StatusOK},
+		{path: "/playground", want: http.StatusOK},
+		{path: "/metrics", want: http.StatusUnauthorized},
+		{path: "/metrics", header: "Bearer static-secret", want: http.StatusOK},
+		{path: "/logs", want: http.StatusUnauthorized},
+		{path: "/graphql", want: http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if c.header != "" {
+			req.Header.Set("Authorization", c.header)
+		}
+
+		rec := httptest.NewRecorder()
+		api.router.ServeHTTP(rec, req)
+
+		if rec.Code != c.want {
+			t.Errorf("GET %s with header %#v: status = %d, want %d", c.path, c.header, rec.Code, c.want)
+		}
+	}
+}
+
+// TestAPIAuthBrowser checks that the local UI, which can't send an Authorization header,
+// authenticates with a cookie set from the access_token query parameter.
+func TestAPIAuthBrowser(t *testing.T) {
+	api := &API{
+		Auth:               &Authenticator{StaticTokens: []string{"static-secret"}},
+		PrometheurExporter: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	api.init()
+
+	rec := httptest.NewRecorder()
+	api.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard?access_token=wrong", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong token: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = httptest.NewRecorder()
+	api.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard?access_token=static-secret", nil))
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("login: status = %d to %#v, want %d to /dashboard", rec.Code, rec.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != tokenCookieName || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("login cookies = %v, want one HttpOnly and SameSite=Strict %s cookie", cookies, tokenCookieName)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.AddCookie(cookies[0])
+
+	rec = httptest.NewRecorder()
+	api.router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /metrics with the cookie: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// The query parameter is only accepted on WebSocket handshakes.
+	req = httptest.NewRequest(http.MethodGet, "/metrics?access_token=static-secret", nil)
+
+	rec = httptest.NewRecorder()
+	api.router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /metrics with the query token: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+
+	rec = httptest.NewRecorder()
+	api.router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("WebSocket handshake with the query token: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestAuthenticatorDisabled(t *testing.T) {
+	var auth *Authenticator
+
+	if auth.Enabled() {
+		t.Error("nil Authenticator is enabled")
+	}
+
+	handler := (&Authenticator{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/graphql", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
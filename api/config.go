@@ -0,0 +1,38 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"glouton/logger"
+	"net/http"
+)
+
+// configSourcesHandler returns where each value of the configuration comes from.
+// Values aren't returned, they could contain secrets.
+func (api *API) configSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if api.ConfigSources == nil {
+		http.Error(w, "configuration sources are not available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(api.ConfigSources()); err != nil {
+		logger.V(1).Printf("Failed to encode configuration sources: %v", err)
+	}
+}
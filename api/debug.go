@@ -0,0 +1,159 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"glouton/logger"
+	"glouton/version"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+)
+
+type runtimeResponse struct {
+	Version        string    `json:"version"`
+	GoVersion      string    `json:"go_version"`
+	NumCPU         int       `json:"num_cpu"`
+	Goroutines     int       `json:"goroutines"`
+	HeapAllocBytes uint64    `json:"heap_alloc_bytes"`
+	HeapObjects    uint64    `json:"heap_objects"`
+	SysBytes       uint64    `json:"sys_bytes"`
+	NumGC          uint32    `json:"num_gc"`
+	LastGC         time.Time `json:"last_gc,omitempty"`
+}
+
+type inputResponse struct {
+	Input           string    `json:"input"`
+	Item            string    `json:"item,omitempty"`
+	Success         bool      `json:"success"`
+	LastGather      time.Time `json:"last_gather"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Error           string    `json:"error,omitempty"`
+}
+
+type gathererResponse struct {
+	ID              int               `json:"id"`
+	Labels          map[string]string `json:"labels"`
+	LastGather      *time.Time        `json:"last_gather,omitempty"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Error           string            `json:"error,omitempty"`
+}
+
+type inputsResponse struct {
+	Inputs    []inputResponse    `json:"inputs"`
+	Gatherers []gathererResponse `json:"gatherers"`
+}
+
+// debugRuntimeHandler returns statistics of the Go runtime. Unlike the pprof endpoints,
+// it's always available and cheap enough to be polled.
+func (api *API) debugRuntimeHandler(w http.ResponseWriter, r *http.Request) {
+	var memStats runtime.MemStats
+
+	runtime.ReadMemStats(&memStats)
+
+	response := runtimeResponse{
+		Version:        version.Version,
+		GoVersion:      runtime.Version(),
+		NumCPU:         runtime.NumCPU(),
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: memStats.HeapAlloc,
+		HeapObjects:    memStats.HeapObjects,
+		SysBytes:       memStats.Sys,
+		NumGC:          memStats.NumGC,
+	}
+
+	if memStats.LastGC != 0 {
+		response.LastGC = time.Unix(0, int64(memStats.LastGC))
+	}
+
+	api.writeDebugJSON(w, response)
+}
+
+// debugConfigHandler returns the effective configuration, secrets are redacted.
+func (api *API) debugConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if api.ConfigDump == nil {
+		http.Error(w, "configuration is not available", http.StatusNotFound)
+		return
+	}
+
+	api.writeDebugJSON(w, api.ConfigDump())
+}
+
+// debugInputsHandler returns the inputs and the gatherers with the result of their last gather.
+func (api *API) debugInputsHandler(w http.ResponseWriter, r *http.Request) {
+	response := inputsResponse{
+		Inputs:    []inputResponse{},
+		Gatherers: []gathererResponse{},
+	}
+
+	if api.Inputs != nil {
+		for _, result := range api.Inputs.GatherResults() {
+			response.Inputs = append(response.Inputs, inputResponse{
+				Input:           result.Input,
+				Item:            result.Item,
+				Success:         result.Success,
+				LastGather:      result.LastGather,
+				DurationSeconds: result.Duration.Seconds(),
+				Error:           result.Error,
+			})
+		}
+
+		sort.Slice(response.Inputs, func(i, j int) bool {
+			if response.Inputs[i].Input != response.Inputs[j].Input {
+				return response.Inputs[i].Input < response.Inputs[j].Input
+			}
+
+			return response.Inputs[i].Item < response.Inputs[j].Item
+		})
+	}
+
+	if api.Gatherers != nil {
+		for _, status := range api.Gatherers.GathererStatuses() {
+			gatherer := gathererResponse{
+				ID:              status.ID,
+				Labels:          status.Labels,
+				DurationSeconds: status.LastDuration.Seconds(),
+			}
+
+			if !status.LastGather.IsZero() {
+				lastGather := status.LastGather
+				gatherer.LastGather = &lastGather
+			}
+
+			if status.LastError != nil {
+				gatherer.Error = status.LastError.Error()
+			}
+
+			response.Gatherers = append(response.Gatherers, gatherer)
+		}
+	}
+
+	api.writeDebugJSON(w, response)
+}
+
+func (api *API) writeDebugJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(response); err != nil {
+		logger.V(1).Printf("Failed to encode debug response: %v", err)
+	}
+}
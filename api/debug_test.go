@@ -0,0 +1,107 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"glouton/collector"
+	"glouton/prometheus/registry"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockInputs []collector.GatherResult
+
+func (i mockInputs) GatherResults() []collector.GatherResult { return i }
+
+type mockGatherers []registry.GathererStatus
+
+func (g mockGatherers) GathererStatuses() []registry.GathererStatus { return g }
+
+func TestDebugInputsHandler(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 30, 0, 0, time.UTC)
+
+	api := &API{
+		Inputs: mockInputs{
+			{Input: "redis", Item: "redis-2", Success: false, LastGather: t0, Duration: time.Second, Error: "connection refused"},
+			{Input: "cpu", Success: true, LastGather: t0, Duration: 10 * time.Millisecond},
+			{Input: "redis", Item: "redis-1", Success: true, LastGather: t0, Duration: time.Second},
+		},
+		Gatherers: mockGatherers{
+			{ID: 1, Labels: map[string]string{"glouton_job": "exporters"}, LastGather: t0, LastError: errors.New("timeout")},
+			{ID: 2, Labels: map[string]string{}},
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	api.debugInputsHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/inputs", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /debug/inputs = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got inputsResponse
+
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	wantOrder := []string{"cpu", "redis/redis-1", "redis/redis-2"}
+
+	if len(got.Inputs) != len(wantOrder) {
+		t.Fatalf("len(inputs) = %d, want %d", len(got.Inputs), len(wantOrder))
+	}
+
+	for i, input := range got.Inputs {
+		name := input.Input
+		if input.Item != "" {
+			name += "/" + input.Item
+		}
+
+		if name != wantOrder[i] {
+			t.Errorf("inputs[%d] = %s, want %s", i, name, wantOrder[i])
+		}
+	}
+
+	if got.Inputs[2].Error != "connection refused" {
+		t.Errorf("inputs[2].error = %#v, want \"connection refused\"", got.Inputs[2].Error)
+	}
+
+	if len(got.Gatherers) != 2 {
+		t.Fatalf("len(gatherers) = %d, want 2", len(got.Gatherers))
+	}
+
+	if got.Gatherers[0].Error != "timeout" || got.Gatherers[0].LastGather == nil || !got.Gatherers[0].LastGather.Equal(t0) {
+		t.Errorf("gatherers[0] = %+v, want the timeout error of %v", got.Gatherers[0], t0)
+	}
+
+	if got.Gatherers[1].LastGather != nil {
+		t.Errorf("gatherers[1].last_gather = %v, want nil for a gatherer which never ran", got.Gatherers[1].LastGather)
+	}
+}
+
+func TestDebugConfigHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&API{}).debugConfigHandler(rec, httptest.NewRequest(http.MethodGet, "/debug/config", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /debug/config = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -36,6 +37,7 @@ type Config struct {
 
 type ResolverRoot interface {
 	Query() QueryResolver
+	Subscription() SubscriptionResolver
 }
 
 type DirectiveRoot struct {
@@ -92,6 +94,13 @@ type ComplexityRoot struct {
 		Name       func(childComplexity int) int
 		Points     func(childComplexity int) int
 		Thresholds func(childComplexity int) int
+		Unit       func(childComplexity int) int
+	}
+
+	MetricPoint struct {
+		Labels func(childComplexity int) int
+		Time   func(childComplexity int) int
+		Value  func(childComplexity int) int
 	}
 
 	Point struct {
@@ -119,8 +128,8 @@ type ComplexityRoot struct {
 		AgentStatus      func(childComplexity int) int
 		Containers       func(childComplexity int, input *Pagination, allContainers bool, search string) int
 		Facts            func(childComplexity int) int
-		Metrics          func(childComplexity int, metricsFilter []*MetricInput) int
-		Points           func(childComplexity int, metricsFilter []*MetricInput, start string, end string, minutes int) int
+		Metrics          func(childComplexity int, metricsFilter []*MetricInput, pagination *Pagination) int
+		Points           func(childComplexity int, metricsFilter []*MetricInput, start string, end string, minutes int, pagination *Pagination, limit *int) int
 		Processes        func(childComplexity int, containerID *string) int
 		Services         func(childComplexity int, isActive bool) int
 		Tags             func(childComplexity int) int
@@ -130,6 +139,7 @@ type ComplexityRoot struct {
 		Active            func(childComplexity int) int
 		ContainerID       func(childComplexity int) int
 		ExePath           func(childComplexity int) int
+		Expired           func(childComplexity int) int
 		IPAddress         func(childComplexity int) int
 		ListenAddresses   func(childComplexity int) int
 		Name              func(childComplexity int) int
@@ -137,6 +147,19 @@ type ComplexityRoot struct {
 		StatusDescription func(childComplexity int) int
 	}
 
+	StatusChange struct {
+		Labels            func(childComplexity int) int
+		PreviousStatus    func(childComplexity int) int
+		Status            func(childComplexity int) int
+		StatusDescription func(childComplexity int) int
+		Time              func(childComplexity int) int
+	}
+
+	Subscription struct {
+		Points        func(childComplexity int, metricsFilter []*MetricInput) int
+		StatusChanges func(childComplexity int, metricsFilter []*MetricInput) int
+	}
+
 	Tag struct {
 		TagName func(childComplexity int) int
 	}
@@ -155,8 +178,8 @@ type ComplexityRoot struct {
 }
 
 type QueryResolver interface {
-	Metrics(ctx context.Context, metricsFilter []*MetricInput) ([]*Metric, error)
-	Points(ctx context.Context, metricsFilter []*MetricInput, start string, end string, minutes int) ([]*Metric, error)
+	Metrics(ctx context.Context, metricsFilter []*MetricInput, pagination *Pagination) ([]*Metric, error)
+	Points(ctx context.Context, metricsFilter []*MetricInput, start string, end string, minutes int, pagination *Pagination, limit *int) ([]*Metric, error)
 	Containers(ctx context.Context, input *Pagination, allContainers bool, search string) (*Containers, error)
 	Processes(ctx context.Context, containerID *string) (*Topinfo, error)
 	Facts(ctx context.Context) ([]*Fact, error)
@@ -165,6 +188,10 @@ type QueryResolver interface {
 	Tags(ctx context.Context) ([]*Tag, error)
 	AgentStatus(ctx context.Context) (*AgentStatus, error)
 }
+type SubscriptionResolver interface {
+	Points(ctx context.Context, metricsFilter []*MetricInput) (<-chan *MetricPoint, error)
+	StatusChanges(ctx context.Context, metricsFilter []*MetricInput) (<-chan *StatusChange, error)
+}
 
 type executableSchema struct {
 	resolvers  ResolverRoot
@@ -398,6 +425,34 @@ func (e *executableSchema) Complexity(typeName, field string, childComplexity in
 
 		return e.complexity.Metric.Thresholds(childComplexity), true
 
+	case "Metric.unit":
+		if e.complexity.Metric.Unit == nil {
+			break
+		}
+
+		return e.complexity.Metric.Unit(childComplexity), true
+
+	case "MetricPoint.labels":
+		if e.complexity.MetricPoint.Labels == nil {
+			break
+		}
+
+		return e.complexity.MetricPoint.Labels(childComplexity), true
+
+	case "MetricPoint.time":
+		if e.complexity.MetricPoint.Time == nil {
+			break
+		}
+
+		return e.complexity.MetricPoint.Time(childComplexity), true
+
+	case "MetricPoint.value":
+		if e.complexity.MetricPoint.Value == nil {
+			break
+		}
+
+		return e.complexity.MetricPoint.Value(childComplexity), true
+
 	case "Point.time":
 		if e.complexity.Point.Time == nil {
 			break
@@ -539,7 +594,7 @@ func (e *executableSchema) Complexity(typeName, field string, childComplexity in
 			return 0, false
 		}
 
-		return e.complexity.Query.Metrics(childComplexity, args["metricsFilter"].([]*MetricInput)), true
+		return e.complexity.Query.Metrics(childComplexity, args["metricsFilter"].([]*MetricInput), args["pagination"].(*Pagination)), true
 
 	case "Query.points":
 		if e.complexity.Query.Points == nil {
@@ -551,7 +606,7 @@ func (e *executableSchema) Complexity(typeName, field string, childComplexity in
 			return 0, false
 		}
 
-		return e.complexity.Query.Points(childComplexity, args["metricsFilter"].([]*MetricInput), args["start"].(string), args["end"].(string), args["minutes"].(int)), true
+		return e.complexity.Query.Points(childComplexity, args["metricsFilter"].([]*MetricInput), args["start"].(string), args["end"].(string), args["minutes"].(int), args["pagination"].(*Pagination), args["limit"].(*int)), true
 
 	case "Query.processes":
 		if e.complexity.Query.Processes == nil {
@@ -605,6 +660,13 @@ func (e *executableSchema) Complexity(typeName, field string, childComplexity in
 
 		return e.complexity.Service.ExePath(childComplexity), true
 
+	case "Service.expired":
+		if e.complexity.Service.Expired == nil {
+			break
+		}
+
+		return e.complexity.Service.Expired(childComplexity), true
+
 	case "Service.ipAddress":
 		if e.complexity.Service.IPAddress == nil {
 			break
@@ -640,6 +702,65 @@ func (e *executableSchema) Complexity(typeName, field string, childComplexity in
 
 		return e.complexity.Service.StatusDescription(childComplexity), true
 
+	case "StatusChange.labels":
+		if e.complexity.StatusChange.Labels == nil {
+			break
+		}
+
+		return e.complexity.StatusChange.Labels(childComplexity), true
+
+	case "StatusChange.previousStatus":
+		if e.complexity.StatusChange.PreviousStatus == nil {
+			break
+		}
+
+		return e.complexity.StatusChange.PreviousStatus(childComplexity), true
+
+	case "StatusChange.status":
+		if e.complexity.StatusChange.Status == nil {
+			break
+		}
+
+		return e.complexity.StatusChange.Status(childComplexity), true
+
+	case "StatusChange.statusDescription":
+		if e.complexity.StatusChange.StatusDescription == nil {
+			break
+		}
+
+		return e.complexity.StatusChange.StatusDescription(childComplexity), true
+
+	case "StatusChange.time":
+		if e.complexity.StatusChange.Time == nil {
+			break
+		}
+
+		return e.complexity.StatusChange.Time(childComplexity), true
+
+	case "Subscription.points":
+		if e.complexity.Subscription.Points == nil {
+			break
+		}
+
+		args, err := ec.field_Subscription_points_args(context.TODO(), rawArgs)
+		if err != nil {
+			return 0, false
+		}
+
+		return e.complexity.Subscription.Points(childComplexity, args["metricsFilter"].([]*MetricInput)), true
+
+	case "Subscription.statusChanges":
+		if e.complexity.Subscription.StatusChanges == nil {
+			break
+		}
+
+		args, err := ec.field_Subscription_statusChanges_args(context.TODO(), rawArgs)
+		if err != nil {
+			return 0, false
+		}
+
+		return e.complexity.Subscription.StatusChanges(childComplexity, args["metricsFilter"].([]*MetricInput)), true
+
 	case "Tag.tagName":
 		if e.complexity.Tag.TagName == nil {
 			break
@@ -709,6 +830,23 @@ func (e *executableSchema) Exec(ctx context.Context) graphql.ResponseHandler {
 			var buf bytes.Buffer
 			data.MarshalGQL(&buf)
 
+			return &graphql.Response{
+				Data: buf.Bytes(),
+			}
+		}
+	case ast.Subscription:
+		next := ec._Subscription(ctx, rc.Operation.SelectionSet)
+
+		var buf bytes.Buffer
+		return func(ctx context.Context) *graphql.Response {
+			buf.Reset()
+			data := next()
+
+			if data == nil {
+				return nil
+			}
+			data.MarshalGQL(&buf)
+
 			return &graphql.Response{
 				Data: buf.Bytes(),
 			}
@@ -754,6 +892,7 @@ type Metric {
   labels: [Label!]!
   points: [Point!]
   thresholds: Threshold!
+  unit: String
 }
 
 type Threshold {
@@ -814,6 +953,7 @@ type Service {
   listenAddresses: [String!]!
   exePath: String!
   active: Boolean!
+  expired: Boolean!
   status: Float!
   statusDescription: String
 }
@@ -838,9 +978,24 @@ type AgentStatus {
   statusDescription: [String!]!
 }
 
+type MetricPoint {
+  labels: [Label!]!
+  time: Time!
+  value: Float!
+}
+
+type StatusChange {
+  labels: [Label!]!
+  time: Time!
+  previousStatus: Float!
+  status: Float!
+  statusDescription: String!
+}
+
 input LabelInput {
   key: String!
   value: String!
+  regex: Boolean
 }
 
 input MetricInput {
@@ -853,8 +1008,8 @@ input Pagination {
 }
 
 type Query {
-  metrics(metricsFilter: [MetricInput!]!): [Metric!]!
-  points(metricsFilter: [MetricInput!]!, start: String!, end: String!, minutes: Int!): [Metric!]!
+  metrics(metricsFilter: [MetricInput!]!, pagination: Pagination): [Metric!]!
+  points(metricsFilter: [MetricInput!]!, start: String!, end: String!, minutes: Int!, pagination: Pagination, limit: Int): [Metric!]!
   containers(input: Pagination, allContainers: Boolean!, search: String!): Containers!
   processes(containerId: String): Topinfo!
   facts: [Fact!]!
@@ -864,6 +1019,11 @@ type Query {
   agentStatus: AgentStatus!
 }
 
+type Subscription {
+  points(metricsFilter: [MetricInput!]!): MetricPoint!
+  statusChanges(metricsFilter: [MetricInput!]!): StatusChange!
+}
+
 scalar Time
 `, BuiltIn: false},
 }
@@ -928,6 +1088,14 @@ func (ec *executionContext) field_Query_metrics_args(ctx context.Context, rawArg
 		}
 	}
 	args["metricsFilter"] = arg0
+	var arg1 *Pagination
+	if tmp, ok := rawArgs["pagination"]; ok {
+		arg1, err = ec.unmarshalOPagination2ᚖgloutonᚋapiᚐPagination(ctx, tmp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	args["pagination"] = arg1
 	return args, nil
 }
 
@@ -966,6 +1134,22 @@ func (ec *executionContext) field_Query_points_args(ctx context.Context, rawArgs
 		}
 	}
 	args["minutes"] = arg3
+	var arg4 *Pagination
+	if tmp, ok := rawArgs["pagination"]; ok {
+		arg4, err = ec.unmarshalOPagination2ᚖgloutonᚋapiᚐPagination(ctx, tmp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	args["pagination"] = arg4
+	var arg5 *int
+	if tmp, ok := rawArgs["limit"]; ok {
+		arg5, err = ec.unmarshalOInt2ᚖint(ctx, tmp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	args["limit"] = arg5
 	return args, nil
 }
 
@@ -997,6 +1181,34 @@ func (ec *executionContext) field_Query_services_args(ctx context.Context, rawAr
 	return args, nil
 }
 
+func (ec *executionContext) field_Subscription_points_args(ctx context.Context, rawArgs map[string]interface{}) (map[string]interface{}, error) {
+	var err error
+	args := map[string]interface{}{}
+	var arg0 []*MetricInput
+	if tmp, ok := rawArgs["metricsFilter"]; ok {
+		arg0, err = ec.unmarshalNMetricInput2ᚕᚖgloutonᚋapiᚐMetricInputᚄ(ctx, tmp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	args["metricsFilter"] = arg0
+	return args, nil
+}
+
+func (ec *executionContext) field_Subscription_statusChanges_args(ctx context.Context, rawArgs map[string]interface{}) (map[string]interface{}, error) {
+	var err error
+	args := map[string]interface{}{}
+	var arg0 []*MetricInput
+	if tmp, ok := rawArgs["metricsFilter"]; ok {
+		arg0, err = ec.unmarshalNMetricInput2ᚕᚖgloutonᚋapiᚐMetricInputᚄ(ctx, tmp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	args["metricsFilter"] = arg0
+	return args, nil
+}
+
 func (ec *executionContext) field___Type_enumValues_args(ctx context.Context, rawArgs map[string]interface{}) (map[string]interface{}, error) {
 	var err error
 	args := map[string]interface{}{}
@@ -2069,6 +2281,139 @@ func (ec *executionContext) _Metric_thresholds(ctx context.Context, field graphq
 	return ec.marshalNThreshold2ᚖgloutonᚋapiᚐThreshold(ctx, field.Selections, res)
 }
 
+func (ec *executionContext) _Metric_unit(ctx context.Context, field graphql.CollectedField, obj *Metric) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "Metric",
+		Field:    field,
+		Args:     nil,
+		IsMethod: false,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return obj.Unit, nil
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		return graphql.Null
+	}
+	res := resTmp.(*string)
+	fc.Result = res
+	return ec.marshalOString2ᚖstring(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _MetricPoint_labels(ctx context.Context, field graphql.CollectedField, obj *MetricPoint) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "MetricPoint",
+		Field:    field,
+		Args:     nil,
+		IsMethod: false,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return obj.Labels, nil
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	res := resTmp.([]*Label)
+	fc.Result = res
+	return ec.marshalNLabel2ᚕᚖgloutonᚋapiᚐLabelᚄ(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _MetricPoint_time(ctx context.Context, field graphql.CollectedField, obj *MetricPoint) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "MetricPoint",
+		Field:    field,
+		Args:     nil,
+		IsMethod: false,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return obj.Time, nil
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	res := resTmp.(time.Time)
+	fc.Result = res
+	return ec.marshalNTime2timeᚐTime(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _MetricPoint_value(ctx context.Context, field graphql.CollectedField, obj *MetricPoint) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "MetricPoint",
+		Field:    field,
+		Args:     nil,
+		IsMethod: false,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return obj.Value, nil
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	res := resTmp.(float64)
+	fc.Result = res
+	return ec.marshalNFloat2float64(ctx, field.Selections, res)
+}
+
 func (ec *executionContext) _Point_time(ctx context.Context, field graphql.CollectedField, obj *Point) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -2569,7 +2914,7 @@ func (ec *executionContext) _Query_metrics(ctx context.Context, field graphql.Co
 	fc.Args = args
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return ec.resolvers.Query().Metrics(rctx, args["metricsFilter"].([]*MetricInput))
+		return ec.resolvers.Query().Metrics(rctx, args["metricsFilter"].([]*MetricInput), args["pagination"].(*Pagination))
 	})
 	if err != nil {
 		ec.Error(ctx, err)
@@ -2610,7 +2955,7 @@ func (ec *executionContext) _Query_points(ctx context.Context, field graphql.Col
 	fc.Args = args
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return ec.resolvers.Query().Points(rctx, args["metricsFilter"].([]*MetricInput), args["start"].(string), args["end"].(string), args["minutes"].(int))
+		return ec.resolvers.Query().Points(rctx, args["metricsFilter"].([]*MetricInput), args["start"].(string), args["end"].(string), args["minutes"].(int), args["pagination"].(*Pagination), args["limit"].(*int))
 	})
 	if err != nil {
 		ec.Error(ctx, err)
@@ -2852,7 +3197,280 @@ func (ec *executionContext) _Query_tags(ctx context.Context, field graphql.Colle
 	return ec.marshalNTag2ᚕᚖgloutonᚋapiᚐTagᚄ(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Query_agentStatus(ctx context.Context, field graphql.CollectedField) (ret graphql.Marshaler) {
+func (ec *executionContext) _Query_agentStatus(ctx context.Context, field graphql.CollectedField) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "Query",
+		Field:    field,
+		Args:     nil,
+		IsMethod: true,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return ec.resolvers.Query().AgentStatus(rctx)
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	res := resTmp.(*AgentStatus)
+	fc.Result = res
+	return ec.marshalNAgentStatus2ᚖgloutonᚋapiᚐAgentStatus(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _Query___type(ctx context.Context, field graphql.CollectedField) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "Query",
+		Field:    field,
+		Args:     nil,
+		IsMethod: true,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	rawArgs := field.ArgumentMap(ec.Variables)
+	args, err := ec.field_Query___type_args(ctx, rawArgs)
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	fc.Args = args
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return ec.introspectType(args["name"].(string))
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		return graphql.Null
+	}
+	res := resTmp.(*introspection.Type)
+	fc.Result = res
+	return ec.marshalO__Type2ᚖgithubᚗcomᚋ99designsᚋgqlgenᚋgraphqlᚋintrospectionᚐType(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _Query___schema(ctx context.Context, field graphql.CollectedField) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "Query",
+		Field:    field,
+		Args:     nil,
+		IsMethod: true,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return ec.introspectSchema()
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		return graphql.Null
+	}
+	res := resTmp.(*introspection.Schema)
+	fc.Result = res
+	return ec.marshalO__Schema2ᚖgithubᚗcomᚋ99designsᚋgqlgenᚋgraphqlᚋintrospectionᚐSchema(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _Service_name(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "Service",
+		Field:    field,
+		Args:     nil,
+		IsMethod: false,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return obj.Name, nil
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	res := resTmp.(string)
+	fc.Result = res
+	return ec.marshalNString2string(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _Service_containerId(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "Service",
+		Field:    field,
+		Args:     nil,
+		IsMethod: false,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return obj.ContainerID, nil
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	res := resTmp.(string)
+	fc.Result = res
+	return ec.marshalNString2string(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _Service_ipAddress(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "Service",
+		Field:    field,
+		Args:     nil,
+		IsMethod: false,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return obj.IPAddress, nil
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	res := resTmp.(string)
+	fc.Result = res
+	return ec.marshalNString2string(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _Service_listenAddresses(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "Service",
+		Field:    field,
+		Args:     nil,
+		IsMethod: false,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return obj.ListenAddresses, nil
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	res := resTmp.([]string)
+	fc.Result = res
+	return ec.marshalNString2ᚕstringᚄ(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _Service_exePath(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+	defer func() {
+		if r := recover(); r != nil {
+			ec.Error(ctx, ec.Recover(ctx, r))
+			ret = graphql.Null
+		}
+	}()
+	fc := &graphql.FieldContext{
+		Object:   "Service",
+		Field:    field,
+		Args:     nil,
+		IsMethod: false,
+	}
+
+	ctx = graphql.WithFieldContext(ctx, fc)
+	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
+		ctx = rctx // use context from middleware stack in children
+		return obj.ExePath, nil
+	})
+	if err != nil {
+		ec.Error(ctx, err)
+		return graphql.Null
+	}
+	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	res := resTmp.(string)
+	fc.Result = res
+	return ec.marshalNString2string(ctx, field.Selections, res)
+}
+
+func (ec *executionContext) _Service_active(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
@@ -2860,16 +3478,16 @@ func (ec *executionContext) _Query_agentStatus(ctx context.Context, field graphq
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Query",
+		Object:   "Service",
 		Field:    field,
 		Args:     nil,
-		IsMethod: true,
+		IsMethod: false,
 	}
 
 	ctx = graphql.WithFieldContext(ctx, fc)
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return ec.resolvers.Query().AgentStatus(rctx)
+		return obj.Active, nil
 	})
 	if err != nil {
 		ec.Error(ctx, err)
@@ -2881,12 +3499,12 @@ func (ec *executionContext) _Query_agentStatus(ctx context.Context, field graphq
 		}
 		return graphql.Null
 	}
-	res := resTmp.(*AgentStatus)
+	res := resTmp.(bool)
 	fc.Result = res
-	return ec.marshalNAgentStatus2ᚖgloutonᚋapiᚐAgentStatus(ctx, field.Selections, res)
+	return ec.marshalNBoolean2bool(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Query___type(ctx context.Context, field graphql.CollectedField) (ret graphql.Marshaler) {
+func (ec *executionContext) _Service_expired(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
@@ -2894,37 +3512,33 @@ func (ec *executionContext) _Query___type(ctx context.Context, field graphql.Col
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Query",
+		Object:   "Service",
 		Field:    field,
 		Args:     nil,
-		IsMethod: true,
+		IsMethod: false,
 	}
 
 	ctx = graphql.WithFieldContext(ctx, fc)
-	rawArgs := field.ArgumentMap(ec.Variables)
-	args, err := ec.field_Query___type_args(ctx, rawArgs)
-	if err != nil {
-		ec.Error(ctx, err)
-		return graphql.Null
-	}
-	fc.Args = args
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return ec.introspectType(args["name"].(string))
+		return obj.Expired, nil
 	})
 	if err != nil {
 		ec.Error(ctx, err)
 		return graphql.Null
 	}
 	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
 		return graphql.Null
 	}
-	res := resTmp.(*introspection.Type)
+	res := resTmp.(bool)
 	fc.Result = res
-	return ec.marshalO__Type2ᚖgithubᚗcomᚋ99designsᚋgqlgenᚋgraphqlᚋintrospectionᚐType(ctx, field.Selections, res)
+	return ec.marshalNBoolean2bool(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Query___schema(ctx context.Context, field graphql.CollectedField) (ret graphql.Marshaler) {
+func (ec *executionContext) _Service_status(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
@@ -2932,30 +3546,33 @@ func (ec *executionContext) _Query___schema(ctx context.Context, field graphql.C
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Query",
+		Object:   "Service",
 		Field:    field,
 		Args:     nil,
-		IsMethod: true,
+		IsMethod: false,
 	}
 
 	ctx = graphql.WithFieldContext(ctx, fc)
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return ec.introspectSchema()
+		return obj.Status, nil
 	})
 	if err != nil {
 		ec.Error(ctx, err)
 		return graphql.Null
 	}
 	if resTmp == nil {
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
 		return graphql.Null
 	}
-	res := resTmp.(*introspection.Schema)
+	res := resTmp.(float64)
 	fc.Result = res
-	return ec.marshalO__Schema2ᚖgithubᚗcomᚋ99designsᚋgqlgenᚋgraphqlᚋintrospectionᚐSchema(ctx, field.Selections, res)
+	return ec.marshalNFloat2float64(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Service_name(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+func (ec *executionContext) _Service_statusDescription(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
@@ -2972,24 +3589,21 @@ func (ec *executionContext) _Service_name(ctx context.Context, field graphql.Col
 	ctx = graphql.WithFieldContext(ctx, fc)
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return obj.Name, nil
+		return obj.StatusDescription, nil
 	})
 	if err != nil {
 		ec.Error(ctx, err)
 		return graphql.Null
 	}
 	if resTmp == nil {
-		if !graphql.HasFieldError(ctx, fc) {
-			ec.Errorf(ctx, "must not be null")
-		}
 		return graphql.Null
 	}
-	res := resTmp.(string)
+	res := resTmp.(*string)
 	fc.Result = res
-	return ec.marshalNString2string(ctx, field.Selections, res)
+	return ec.marshalOString2ᚖstring(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Service_containerId(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+func (ec *executionContext) _StatusChange_labels(ctx context.Context, field graphql.CollectedField, obj *StatusChange) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
@@ -2997,7 +3611,7 @@ func (ec *executionContext) _Service_containerId(ctx context.Context, field grap
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Service",
+		Object:   "StatusChange",
 		Field:    field,
 		Args:     nil,
 		IsMethod: false,
@@ -3006,7 +3620,7 @@ func (ec *executionContext) _Service_containerId(ctx context.Context, field grap
 	ctx = graphql.WithFieldContext(ctx, fc)
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return obj.ContainerID, nil
+		return obj.Labels, nil
 	})
 	if err != nil {
 		ec.Error(ctx, err)
@@ -3018,12 +3632,12 @@ func (ec *executionContext) _Service_containerId(ctx context.Context, field grap
 		}
 		return graphql.Null
 	}
-	res := resTmp.(string)
+	res := resTmp.([]*Label)
 	fc.Result = res
-	return ec.marshalNString2string(ctx, field.Selections, res)
+	return ec.marshalNLabel2ᚕᚖgloutonᚋapiᚐLabelᚄ(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Service_ipAddress(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+func (ec *executionContext) _StatusChange_time(ctx context.Context, field graphql.CollectedField, obj *StatusChange) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
@@ -3031,7 +3645,7 @@ func (ec *executionContext) _Service_ipAddress(ctx context.Context, field graphq
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Service",
+		Object:   "StatusChange",
 		Field:    field,
 		Args:     nil,
 		IsMethod: false,
@@ -3040,7 +3654,7 @@ func (ec *executionContext) _Service_ipAddress(ctx context.Context, field graphq
 	ctx = graphql.WithFieldContext(ctx, fc)
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return obj.IPAddress, nil
+		return obj.Time, nil
 	})
 	if err != nil {
 		ec.Error(ctx, err)
@@ -3052,12 +3666,12 @@ func (ec *executionContext) _Service_ipAddress(ctx context.Context, field graphq
 		}
 		return graphql.Null
 	}
-	res := resTmp.(string)
+	res := resTmp.(time.Time)
 	fc.Result = res
-	return ec.marshalNString2string(ctx, field.Selections, res)
+	return ec.marshalNTime2timeᚐTime(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Service_listenAddresses(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+func (ec *executionContext) _StatusChange_previousStatus(ctx context.Context, field graphql.CollectedField, obj *StatusChange) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
@@ -3065,7 +3679,7 @@ func (ec *executionContext) _Service_listenAddresses(ctx context.Context, field
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Service",
+		Object:   "StatusChange",
 		Field:    field,
 		Args:     nil,
 		IsMethod: false,
@@ -3074,7 +3688,7 @@ func (ec *executionContext) _Service_listenAddresses(ctx context.Context, field
 	ctx = graphql.WithFieldContext(ctx, fc)
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return obj.ListenAddresses, nil
+		return obj.PreviousStatus, nil
 	})
 	if err != nil {
 		ec.Error(ctx, err)
@@ -3086,12 +3700,12 @@ func (ec *executionContext) _Service_listenAddresses(ctx context.Context, field
 		}
 		return graphql.Null
 	}
-	res := resTmp.([]string)
+	res := resTmp.(float64)
 	fc.Result = res
-	return ec.marshalNString2ᚕstringᚄ(ctx, field.Selections, res)
+	return ec.marshalNFloat2float64(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Service_exePath(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+func (ec *executionContext) _StatusChange_status(ctx context.Context, field graphql.CollectedField, obj *StatusChange) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
@@ -3099,7 +3713,7 @@ func (ec *executionContext) _Service_exePath(ctx context.Context, field graphql.
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Service",
+		Object:   "StatusChange",
 		Field:    field,
 		Args:     nil,
 		IsMethod: false,
@@ -3108,7 +3722,7 @@ func (ec *executionContext) _Service_exePath(ctx context.Context, field graphql.
 	ctx = graphql.WithFieldContext(ctx, fc)
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return obj.ExePath, nil
+		return obj.Status, nil
 	})
 	if err != nil {
 		ec.Error(ctx, err)
@@ -3120,12 +3734,12 @@ func (ec *executionContext) _Service_exePath(ctx context.Context, field graphql.
 		}
 		return graphql.Null
 	}
-	res := resTmp.(string)
+	res := resTmp.(float64)
 	fc.Result = res
-	return ec.marshalNString2string(ctx, field.Selections, res)
+	return ec.marshalNFloat2float64(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Service_active(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+func (ec *executionContext) _StatusChange_statusDescription(ctx context.Context, field graphql.CollectedField, obj *StatusChange) (ret graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
@@ -3133,7 +3747,7 @@ func (ec *executionContext) _Service_active(ctx context.Context, field graphql.C
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Service",
+		Object:   "StatusChange",
 		Field:    field,
 		Args:     nil,
 		IsMethod: false,
@@ -3142,7 +3756,7 @@ func (ec *executionContext) _Service_active(ctx context.Context, field graphql.C
 	ctx = graphql.WithFieldContext(ctx, fc)
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return obj.Active, nil
+		return obj.StatusDescription, nil
 	})
 	if err != nil {
 		ec.Error(ctx, err)
@@ -3154,74 +3768,111 @@ func (ec *executionContext) _Service_active(ctx context.Context, field graphql.C
 		}
 		return graphql.Null
 	}
-	res := resTmp.(bool)
+	res := resTmp.(string)
 	fc.Result = res
-	return ec.marshalNBoolean2bool(ctx, field.Selections, res)
+	return ec.marshalNString2string(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Service_status(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+func (ec *executionContext) _Subscription_points(ctx context.Context, field graphql.CollectedField) (ret func() graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
-			ret = graphql.Null
+			ret = nil
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Service",
+		Object:   "Subscription",
 		Field:    field,
 		Args:     nil,
-		IsMethod: false,
+		IsMethod: true,
 	}
 
 	ctx = graphql.WithFieldContext(ctx, fc)
+	rawArgs := field.ArgumentMap(ec.Variables)
+	args, err := ec.field_Subscription_points_args(ctx, rawArgs)
+	if err != nil {
+		ec.Error(ctx, err)
+		return nil
+	}
+	fc.Args = args
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return obj.Status, nil
+		return ec.resolvers.Subscription().Points(rctx, args["metricsFilter"].([]*MetricInput))
 	})
 	if err != nil {
 		ec.Error(ctx, err)
-		return graphql.Null
+		return nil
 	}
 	if resTmp == nil {
 		if !graphql.HasFieldError(ctx, fc) {
 			ec.Errorf(ctx, "must not be null")
 		}
-		return graphql.Null
+		return nil
+	}
+	return func() graphql.Marshaler {
+		res, ok := <-resTmp.(<-chan *MetricPoint)
+		if !ok {
+			return nil
+		}
+		return graphql.WriterFunc(func(w io.Writer) {
+			w.Write([]byte{'{'})
+			graphql.MarshalString(field.Alias).MarshalGQL(w)
+			w.Write([]byte{':'})
+			ec.marshalNMetricPoint2ᚖgloutonᚋapiᚐMetricPoint(ctx, field.Selections, res).MarshalGQL(w)
+			w.Write([]byte{'}'})
+		})
 	}
-	res := resTmp.(float64)
-	fc.Result = res
-	return ec.marshalNFloat2float64(ctx, field.Selections, res)
 }
 
-func (ec *executionContext) _Service_statusDescription(ctx context.Context, field graphql.CollectedField, obj *Service) (ret graphql.Marshaler) {
+func (ec *executionContext) _Subscription_statusChanges(ctx context.Context, field graphql.CollectedField) (ret func() graphql.Marshaler) {
 	defer func() {
 		if r := recover(); r != nil {
 			ec.Error(ctx, ec.Recover(ctx, r))
-			ret = graphql.Null
+			ret = nil
 		}
 	}()
 	fc := &graphql.FieldContext{
-		Object:   "Service",
+		Object:   "Subscription",
 		Field:    field,
 		Args:     nil,
-		IsMethod: false,
+		IsMethod: true,
 	}
 
 	ctx = graphql.WithFieldContext(ctx, fc)
+	rawArgs := field.ArgumentMap(ec.Variables)
+	args, err := ec.field_Subscription_statusChanges_args(ctx, rawArgs)
+	if err != nil {
+		ec.Error(ctx, err)
+		return nil
+	}
+	fc.Args = args
 	resTmp, err := ec.ResolverMiddleware(ctx, func(rctx context.Context) (interface{}, error) {
 		ctx = rctx // use context from middleware stack in children
-		return obj.StatusDescription, nil
+		return ec.resolvers.Subscription().StatusChanges(rctx, args["metricsFilter"].([]*MetricInput))
 	})
 	if err != nil {
 		ec.Error(ctx, err)
-		return graphql.Null
+		return nil
 	}
 	if resTmp == nil {
-		return graphql.Null
+		if !graphql.HasFieldError(ctx, fc) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return nil
+	}
+	return func() graphql.Marshaler {
+		res, ok := <-resTmp.(<-chan *StatusChange)
+		if !ok {
+			return nil
+		}
+		return graphql.WriterFunc(func(w io.Writer) {
+			w.Write([]byte{'{'})
+			graphql.MarshalString(field.Alias).MarshalGQL(w)
+			w.Write([]byte{':'})
+			ec.marshalNStatusChange2ᚖgloutonᚋapiᚐStatusChange(ctx, field.Selections, res).MarshalGQL(w)
+			w.Write([]byte{'}'})
+		})
 	}
-	res := resTmp.(*string)
-	fc.Result = res
-	return ec.marshalOString2ᚖstring(ctx, field.Selections, res)
 }
 
 func (ec *executionContext) _Tag_tagName(ctx context.Context, field graphql.CollectedField, obj *Tag) (ret graphql.Marshaler) {
@@ -4523,6 +5174,12 @@ func (ec *executionContext) unmarshalInputLabelInput(ctx context.Context, obj in
 			if err != nil {
 				return it, err
 			}
+		case "regex":
+			var err error
+			it.Regex, err = ec.unmarshalOBoolean2ᚖbool(ctx, v)
+			if err != nil {
+				return it, err
+			}
 		}
 	}
 
@@ -4859,6 +5516,45 @@ func (ec *executionContext) _Metric(ctx context.Context, sel ast.SelectionSet, o
 			if out.Values[i] == graphql.Null {
 				invalids++
 			}
+		case "unit":
+			out.Values[i] = ec._Metric_unit(ctx, field, obj)
+		default:
+			panic("unknown field " + strconv.Quote(field.Name))
+		}
+	}
+	out.Dispatch()
+	if invalids > 0 {
+		return graphql.Null
+	}
+	return out
+}
+
+var metricPointImplementors = []string{"MetricPoint"}
+
+func (ec *executionContext) _MetricPoint(ctx context.Context, sel ast.SelectionSet, obj *MetricPoint) graphql.Marshaler {
+	fields := graphql.CollectFields(ec.OperationContext, sel, metricPointImplementors)
+
+	out := graphql.NewFieldSet(fields)
+	var invalids uint32
+	for i, field := range fields {
+		switch field.Name {
+		case "__typename":
+			out.Values[i] = graphql.MarshalString("MetricPoint")
+		case "labels":
+			out.Values[i] = ec._MetricPoint_labels(ctx, field, obj)
+			if out.Values[i] == graphql.Null {
+				invalids++
+			}
+		case "time":
+			out.Values[i] = ec._MetricPoint_time(ctx, field, obj)
+			if out.Values[i] == graphql.Null {
+				invalids++
+			}
+		case "value":
+			out.Values[i] = ec._MetricPoint_value(ctx, field, obj)
+			if out.Values[i] == graphql.Null {
+				invalids++
+			}
 		default:
 			panic("unknown field " + strconv.Quote(field.Name))
 		}
@@ -5181,6 +5877,11 @@ func (ec *executionContext) _Service(ctx context.Context, sel ast.SelectionSet,
 			if out.Values[i] == graphql.Null {
 				invalids++
 			}
+		case "expired":
+			out.Values[i] = ec._Service_expired(ctx, field, obj)
+			if out.Values[i] == graphql.Null {
+				invalids++
+			}
 		case "status":
 			out.Values[i] = ec._Service_status(ctx, field, obj)
 			if out.Values[i] == graphql.Null {
@@ -5199,6 +5900,75 @@ func (ec *executionContext) _Service(ctx context.Context, sel ast.SelectionSet,
 	return out
 }
 
+var statusChangeImplementors = []string{"StatusChange"}
+
+func (ec *executionContext) _StatusChange(ctx context.Context, sel ast.SelectionSet, obj *StatusChange) graphql.Marshaler {
+	fields := graphql.CollectFields(ec.OperationContext, sel, statusChangeImplementors)
+
+	out := graphql.NewFieldSet(fields)
+	var invalids uint32
+	for i, field := range fields {
+		switch field.Name {
+		case "__typename":
+			out.Values[i] = graphql.MarshalString("StatusChange")
+		case "labels":
+			out.Values[i] = ec._StatusChange_labels(ctx, field, obj)
+			if out.Values[i] == graphql.Null {
+				invalids++
+			}
+		case "time":
+			out.Values[i] = ec._StatusChange_time(ctx, field, obj)
+			if out.Values[i] == graphql.Null {
+				invalids++
+			}
+		case "previousStatus":
+			out.Values[i] = ec._StatusChange_previousStatus(ctx, field, obj)
+			if out.Values[i] == graphql.Null {
+				invalids++
+			}
+		case "status":
+			out.Values[i] = ec._StatusChange_status(ctx, field, obj)
+			if out.Values[i] == graphql.Null {
+				invalids++
+			}
+		case "statusDescription":
+			out.Values[i] = ec._StatusChange_statusDescription(ctx, field, obj)
+			if out.Values[i] == graphql.Null {
+				invalids++
+			}
+		default:
+			panic("unknown field " + strconv.Quote(field.Name))
+		}
+	}
+	out.Dispatch()
+	if invalids > 0 {
+		return graphql.Null
+	}
+	return out
+}
+
+var subscriptionImplementors = []string{"Subscription"}
+
+func (ec *executionContext) _Subscription(ctx context.Context, sel ast.SelectionSet) func() graphql.Marshaler {
+	fields := graphql.CollectFields(ec.OperationContext, sel, subscriptionImplementors)
+	ctx = graphql.WithFieldContext(ctx, &graphql.FieldContext{
+		Object: "Subscription",
+	})
+	if len(fields) != 1 {
+		ec.Errorf(ctx, "must subscribe to exactly one stream")
+		return nil
+	}
+
+	switch fields[0].Name {
+	case "points":
+		return ec._Subscription_points(ctx, fields[0])
+	case "statusChanges":
+		return ec._Subscription_statusChanges(ctx, fields[0])
+	default:
+		panic("unknown field " + strconv.Quote(fields[0].Name))
+	}
+}
+
 var tagImplementors = []string{"Tag"}
 
 func (ec *executionContext) _Tag(ctx context.Context, sel ast.SelectionSet, obj *Tag) graphql.Marshaler {
@@ -5885,6 +6655,20 @@ func (ec *executionContext) unmarshalNMetricInput2ᚖgloutonᚋapiᚐMetricInput
 	return &res, err
 }
 
+func (ec *executionContext) marshalNMetricPoint2gloutonᚋapiᚐMetricPoint(ctx context.Context, sel ast.SelectionSet, v MetricPoint) graphql.Marshaler {
+	return ec._MetricPoint(ctx, sel, &v)
+}
+
+func (ec *executionContext) marshalNMetricPoint2ᚖgloutonᚋapiᚐMetricPoint(ctx context.Context, sel ast.SelectionSet, v *MetricPoint) graphql.Marshaler {
+	if v == nil {
+		if !graphql.HasFieldError(ctx, graphql.GetFieldContext(ctx)) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	return ec._MetricPoint(ctx, sel, v)
+}
+
 func (ec *executionContext) marshalNPoint2gloutonᚋapiᚐPoint(ctx context.Context, sel ast.SelectionSet, v Point) graphql.Marshaler {
 	return ec._Point(ctx, sel, &v)
 }
@@ -6001,6 +6785,20 @@ func (ec *executionContext) marshalNService2ᚖgloutonᚋapiᚐService(ctx conte
 	return ec._Service(ctx, sel, v)
 }
 
+func (ec *executionContext) marshalNStatusChange2gloutonᚋapiᚐStatusChange(ctx context.Context, sel ast.SelectionSet, v StatusChange) graphql.Marshaler {
+	return ec._StatusChange(ctx, sel, &v)
+}
+
+func (ec *executionContext) marshalNStatusChange2ᚖgloutonᚋapiᚐStatusChange(ctx context.Context, sel ast.SelectionSet, v *StatusChange) graphql.Marshaler {
+	if v == nil {
+		if !graphql.HasFieldError(ctx, graphql.GetFieldContext(ctx)) {
+			ec.Errorf(ctx, "must not be null")
+		}
+		return graphql.Null
+	}
+	return ec._StatusChange(ctx, sel, v)
+}
+
 func (ec *executionContext) unmarshalNString2string(ctx context.Context, v interface{}) (string, error) {
 	return graphql.UnmarshalString(v)
 }
@@ -6409,6 +7207,29 @@ func (ec *executionContext) marshalOFloat2ᚖfloat64(ctx context.Context, sel as
 	return ec.marshalOFloat2float64(ctx, sel, *v)
 }
 
+func (ec *executionContext) unmarshalOInt2int(ctx context.Context, v interface{}) (int, error) {
+	return graphql.UnmarshalInt(v)
+}
+
+func (ec *executionContext) marshalOInt2int(ctx context.Context, sel ast.SelectionSet, v int) graphql.Marshaler {
+	return graphql.MarshalInt(v)
+}
+
+func (ec *executionContext) unmarshalOInt2ᚖint(ctx context.Context, v interface{}) (*int, error) {
+	if v == nil {
+		return nil, nil
+	}
+	res, err := ec.unmarshalOInt2int(ctx, v)
+	return &res, err
+}
+
+func (ec *executionContext) marshalOInt2ᚖint(ctx context.Context, sel ast.SelectionSet, v *int) graphql.Marshaler {
+	if v == nil {
+		return graphql.Null
+	}
+	return ec.marshalOInt2int(ctx, sel, *v)
+}
+
 func (ec *executionContext) unmarshalOPagination2gloutonᚋapiᚐPagination(ctx context.Context, v interface{}) (Pagination, error) {
 	return ec.unmarshalInputPagination(ctx, v)
 }
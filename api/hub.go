@@ -0,0 +1,234 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"glouton/logger"
+	"glouton/threshold"
+	"glouton/types"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// subscriptionBuffer is the number of messages buffered for each subscription. Messages are
+// dropped when a client is too slow, the store must never be blocked.
+const subscriptionBuffer = 1000
+
+// PointsHub fans out the points pushed to the store and the status changes of the threshold
+// registry to the GraphQL subscriptions.
+//
+// PushPoints must be registered as a notifiee of the store and OnStatusChanges as a status
+// notifiee of the threshold registry.
+type PointsHub struct {
+	// subscriberCount is read without the lock to skip the points when nobody subscribed.
+	subscriberCount int32
+
+	l           sync.Mutex
+	nextID      int
+	subscribers map[int]*subscriber
+}
+
+type subscriber struct {
+	filters  [][]*labels.Matcher
+	points   chan *MetricPoint
+	statuses chan *StatusChange
+	dropped  int
+}
+
+// NewPointsHub returns a hub without subscribers.
+func NewPointsHub() *PointsHub {
+	return &PointsHub{
+		subscribers: make(map[int]*subscriber),
+	}
+}
+
+// PushPoints sends the points to the matching subscriptions.
+func (h *PointsHub) PushPoints(points []types.MetricPoint) {
+	if atomic.LoadInt32(&h.subscriberCount) == 0 {
+		return
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	for _, p := range points {
+		var labelsList []*Label
+
+		for _, s := range h.subscribers {
+			if s.points == nil || !s.match(p.Labels) {
+				continue
+			}
+
+			if labelsList == nil {
+				labelsList = sortedLabels(p.Labels)
+			}
+
+			point := &MetricPoint{Labels: labelsList, Time: p.Time, Value: p.Value}
+
+			s.send(func() bool {
+				select {
+				case s.points <- point:
+					return true
+				default:
+					return false
+				}
+			})
+		}
+	}
+}
+
+// OnStatusChanges sends the status changes to the matching subscriptions.
+func (h *PointsHub) OnStatusChanges(changes []threshold.StatusChange) {
+	if atomic.LoadInt32(&h.subscriberCount) == 0 {
+		return
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	for _, c := range changes {
+		var change *StatusChange
+
+		for _, s := range h.subscribers {
+			if s.statuses == nil || !s.match(c.Labels) {
+				continue
+			}
+
+			if change == nil {
+				change = &StatusChange{
+					Labels:            sortedLabels(c.Labels),
+					Time:              c.Time,
+					PreviousStatus:    float64(c.PreviousStatus.NagiosCode()),
+					Status:            float64(c.CurrentStatus.NagiosCode()),
+					StatusDescription: c.StatusDescription,
+				}
+			}
+
+			s.send(func() bool {
+				select {
+				case s.statuses <- change:
+					return true
+				default:
+					return false
+				}
+			})
+		}
+	}
+}
+
+// SubscribePoints returns the points of the metrics matching one of the filters (all metrics
+// without filters). The channel is closed when ctx is cancelled.
+func (h *PointsHub) SubscribePoints(ctx context.Context, filters [][]*labels.Matcher) <-chan *MetricPoint {
+	s := &subscriber{
+		filters: filters,
+		points:  make(chan *MetricPoint, subscriptionBuffer),
+	}
+
+	h.subscribe(ctx, s)
+
+	return s.points
+}
+
+// SubscribeStatusChanges returns the status transitions of the metrics matching one of the
+// filters (all metrics without filters). The channel is closed when ctx is cancelled.
+func (h *PointsHub) SubscribeStatusChanges(ctx context.Context, filters [][]*labels.Matcher) <-chan *StatusChange {
+	s := &subscriber{
+		filters:  filters,
+		statuses: make(chan *StatusChange, subscriptionBuffer),
+	}
+
+	h.subscribe(ctx, s)
+
+	return s.statuses
+}
+
+func (h *PointsHub) subscribe(ctx context.Context, s *subscriber) {
+	h.l.Lock()
+
+	id := h.nextID
+	h.nextID++
+	h.subscribers[id] = s
+	atomic.AddInt32(&h.subscriberCount, 1)
+
+	h.l.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		h.l.Lock()
+		defer h.l.Unlock()
+
+		delete(h.subscribers, id)
+		atomic.AddInt32(&h.subscriberCount, -1)
+
+		if s.points != nil {
+			close(s.points)
+		}
+
+		if s.statuses != nil {
+			close(s.statuses)
+		}
+
+		if s.dropped > 0 {
+			logger.V(2).Printf("A subscription of the API dropped %d messages, the client is too slow", s.dropped)
+		}
+	}()
+}
+
+// send runs trySend and counts the messages dropped.
+func (s *subscriber) send(trySend func() bool) {
+	if !trySend() {
+		s.dropped++
+	}
+}
+
+func (s *subscriber) match(lbls map[string]string) bool {
+	if len(s.filters) == 0 {
+		return true
+	}
+
+filterLoop:
+	for _, filter := range s.filters {
+		for _, matcher := range filter {
+			if !matcher.Matches(lbls[matcher.Name]) {
+				continue filterLoop
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func sortedLabels(labels map[string]string) []*Label {
+	result := make([]*Label, 0, len(labels))
+
+	for k, v := range labels {
+		result = append(result, &Label{Key: k, Value: v})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
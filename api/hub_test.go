@@ -0,0 +1,74 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"glouton/threshold"
+	"glouton/types"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestPointsHub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	hub := NewPointsHub()
+
+	points := hub.SubscribePoints(ctx, [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, types.LabelName, "cpu_used")}})
+	statuses := hub.SubscribeStatusChanges(ctx, nil)
+
+	hub.PushPoints([]types.MetricPoint{
+		{Labels: map[string]string{types.LabelName: "cpu_used"}, Point: types.Point{Value: 42}},
+		{Labels: map[string]string{types.LabelName: "mem_used"}, Point: types.Point{Value: 1024}},
+	})
+	hub.OnStatusChanges([]threshold.StatusChange{
+		{
+			Labels:         map[string]string{types.LabelName: "disk_used_status"},
+			PreviousStatus: types.StatusOk,
+			CurrentStatus:  types.StatusCritical,
+			Time:           time.Now(),
+		},
+	})
+
+	cancel()
+
+	var gotPoints []*MetricPoint
+
+	for p := range points {
+		gotPoints = append(gotPoints, p)
+	}
+
+	if len(gotPoints) != 1 || gotPoints[0].Value != 42 {
+		t.Errorf("points = %v, want only cpu_used", gotPoints)
+	}
+
+	var gotStatuses []*StatusChange
+
+	for s := range statuses {
+		gotStatuses = append(gotStatuses, s)
+	}
+
+	if len(gotStatuses) != 1 {
+		t.Fatalf("len(status changes) = %d, want 1", len(gotStatuses))
+	}
+
+	if gotStatuses[0].PreviousStatus != 0 || gotStatuses[0].Status != 2 {
+		t.Errorf("status change = %v -> %v, want 0 -> 2", gotStatuses[0].PreviousStatus, gotStatuses[0].Status)
+	}
+}
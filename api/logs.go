@@ -0,0 +1,68 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"glouton/logger"
+	"net/http"
+	"strconv"
+)
+
+// logsHandler returns the last log entries, oldest first. The query parameter
+// "level" filters out entries with a higher level and "limit" keeps only the
+// most recent entries.
+func (api *API) logsHandler(w http.ResponseWriter, r *http.Request) {
+	entries := logger.Entries()
+
+	if value := r.URL.Query().Get("level"); value != "" {
+		level, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid level %#v", value), http.StatusBadRequest)
+			return
+		}
+
+		filtered := entries[:0]
+
+		for _, e := range entries {
+			if e.Level <= level {
+				filtered = append(filtered, e)
+			}
+		}
+
+		entries = filtered
+	}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %#v", value), http.StatusBadRequest)
+			return
+		}
+
+		if len(entries) > limit {
+			entries = entries[len(entries)-limit:]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		logger.V(1).Printf("Failed to encode log entries: %v", err)
+	}
+}
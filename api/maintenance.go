@@ -0,0 +1,106 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"glouton/logger"
+	"glouton/threshold"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const maxMaintenanceRequestSize = 16 * 1024
+
+type maintenanceRequest struct {
+	Metric  string `json:"metric"`
+	Service string `json:"service"`
+	// Start is a RFC3339 date. The window start now when empty.
+	Start string `json:"start"`
+	// Duration is in seconds.
+	Duration int    `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+func (api *API) maintenanceListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(api.Threshold.MaintenanceWindows()); err != nil {
+		logger.V(1).Printf("Failed to encode maintenance windows: %v", err)
+	}
+}
+
+func (api *API) maintenanceAddHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var request maintenanceRequest
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMaintenanceRequestSize))
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+
+	if request.Start != "" {
+		var err error
+
+		start, err = time.Parse(time.RFC3339, request.Start)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid start: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	window, err := api.Threshold.AddMaintenanceWindow(threshold.MaintenanceWindow{
+		Metric:  request.Metric,
+		Service: request.Service,
+		Start:   start,
+		End:     start.Add(time.Duration(request.Duration) * time.Second),
+		Reason:  request.Reason,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(window); err != nil {
+		logger.V(1).Printf("Failed to encode maintenance window: %v", err)
+	}
+}
+
+func (api *API) maintenanceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if !api.Threshold.RemoveMaintenanceWindow(id) {
+		http.Error(w, fmt.Sprintf("unknown maintenance window %#v", id), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -54,6 +54,7 @@ type Label struct {
 type LabelInput struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
+	Regex *bool  `json:"regex"`
 }
 
 type Metric struct {
@@ -61,12 +62,19 @@ type Metric struct {
 	Labels     []*Label   `json:"labels"`
 	Points     []*Point   `json:"points"`
 	Thresholds *Threshold `json:"thresholds"`
+	Unit       *string    `json:"unit"`
 }
 
 type MetricInput struct {
 	Labels []*LabelInput `json:"labels"`
 }
 
+type MetricPoint struct {
+	Labels []*Label  `json:"labels"`
+	Time   time.Time `json:"time"`
+	Value  float64   `json:"value"`
+}
+
 type Pagination struct {
 	Offset int `json:"offset"`
 	Limit  int `json:"limit"`
@@ -99,10 +107,19 @@ type Service struct {
 	ListenAddresses   []string `json:"listenAddresses"`
 	ExePath           string   `json:"exePath"`
 	Active            bool     `json:"active"`
+	Expired           bool     `json:"expired"`
 	Status            float64  `json:"status"`
 	StatusDescription *string  `json:"statusDescription"`
 }
 
+type StatusChange struct {
+	Labels            []*Label  `json:"labels"`
+	Time              time.Time `json:"time"`
+	PreviousStatus    float64   `json:"previousStatus"`
+	Status            float64   `json:"status"`
+	StatusDescription string    `json:"statusDescription"`
+}
+
 type Tag struct {
 	TagName string `json:"tagName"`
 }
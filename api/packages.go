@@ -0,0 +1,55 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"glouton/facts"
+	"glouton/logger"
+	"net/http"
+	"time"
+)
+
+type packagesResponse struct {
+	Hash      string          `json:"hash"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Packages  []facts.Package `json:"packages"`
+}
+
+func (api *API) packagesHandler(w http.ResponseWriter, r *http.Request) {
+	if api.Packages == nil {
+		http.Error(w, "the package inventory is disabled", http.StatusNotFound)
+		return
+	}
+
+	packages, hash, updatedAt := api.Packages.Packages()
+	if updatedAt.IsZero() {
+		http.Error(w, "the package inventory isn't available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	if packages == nil {
+		packages = []facts.Package{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+hash+`"`)
+
+	if err := json.NewEncoder(w).Encode(packagesResponse{Hash: hash, UpdatedAt: updatedAt, Packages: packages}); err != nil {
+		logger.V(1).Printf("Failed to encode packages: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// maxPassiveResultSize is the maximum size of a passive check result body.
+const maxPassiveResultSize = 64 * 1024
+
+type passiveCheckResult struct {
+	Name    string             `json:"name"`
+	Status  string             `json:"status"`
+	Message string             `json:"message"`
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// parseStatus accept a status name (ok, warning, critical, unknown) or a Nagios code.
+func parseStatus(value string) (types.Status, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	for _, status := range []types.Status{types.StatusOk, types.StatusWarning, types.StatusCritical, types.StatusUnknown} {
+		if value == status.String() {
+			return status, nil
+		}
+	}
+
+	code, err := strconv.ParseInt(value, 10, 0)
+	if err != nil || code < 0 || code > 3 {
+		return types.StatusUnset, fmt.Errorf("invalid status %#v", value)
+	}
+
+	return types.FromNagios(int(code)), nil
+}
+
+// validateMetrics checks that the metrics of a passive check, named "<check>_<metric>",
+// are valid Prometheus metric names.
+func validateMetrics(checkName string, metrics map[string]float64) error {
+	for name := range metrics {
+		if !model.IsValidMetricName(model.LabelValue(checkName + "_" + name)) {
+			return fmt.Errorf("invalid metric name %#v", name)
+		}
+	}
+
+	return nil
+}
+
+func (api *API) passiveCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var result passiveCheckResult
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPassiveResultSize))
+	if err := decoder.Decode(&result); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	check, ok := api.PassiveChecks[result.Name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown passive check %#v", result.Name), http.StatusNotFound)
+		return
+	}
+
+	status, err := parseStatus(result.Status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateMetrics(result.Name, result.Metrics); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.V(2).Printf("Received result for passive check %s: %v", result.Name, status)
+
+	check.Submit(
+		types.StatusDescription{
+			CurrentStatus:     status,
+			StatusDescription: result.Message,
+		},
+		result.Metrics,
+	)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,39 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import "testing"
+
+func TestValidateMetrics(t *testing.T) {
+	cases := []struct {
+		metrics map[string]float64
+		valid   bool
+	}{
+		{metrics: nil, valid: true},
+		{metrics: map[string]float64{"queue_size": 4, "latency:avg": 0.2}, valid: true},
+		{metrics: map[string]float64{"queue size": 4}, valid: false},
+		{metrics: map[string]float64{"queue-size": 4}, valid: false},
+		{metrics: map[string]float64{"latency{le=\"1\"}": 4}, valid: false},
+	}
+
+	for _, c := range cases {
+		err := validateMetrics("backup", c.metrics)
+		if (err == nil) != c.valid {
+			t.Errorf("validateMetrics(%v) = %v, want valid=%v", c.metrics, err, c.valid)
+		}
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"glouton/threshold"
 	"glouton/types"
 
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/vektah/gqlparser/gqlerror"
 )
 
@@ -42,47 +43,170 @@ func (r *Resolver) Query() QueryResolver {
 
 type queryResolver struct{ *Resolver }
 
-// Metrics returns a list of metrics
-// They can be filtered with an array of metrics which contains an array of labels.
-func (r *queryResolver) Metrics(ctx context.Context, metricsFilter []*MetricInput) ([]*Metric, error) {
-	if r.api.DB == nil {
-		return nil, gqlerror.Errorf("Can not retrieve metrics at this moment. Please try later")
+func (r *Resolver) Subscription() SubscriptionResolver {
+	return &subscriptionResolver{r}
+}
+
+type subscriptionResolver struct{ *Resolver }
+
+// Points streams the points of the metrics matching one of the filters.
+func (r *subscriptionResolver) Points(ctx context.Context, metricsFilter []*MetricInput) (<-chan *MetricPoint, error) {
+	if r.api.PointsHub == nil {
+		return nil, gqlerror.Errorf("Subscriptions are not available")
 	}
 
-	metrics := []types.Metric{}
+	filters, err := filtersFromInput(metricsFilter)
+	if err != nil {
+		return nil, err
+	}
 
-	if len(metricsFilter) > 0 {
-		for _, mf := range metricsFilter {
-			if len(mf.Labels) > 0 {
-				metricFilters := map[string]string{}
+	return r.api.PointsHub.SubscribePoints(ctx, filters), nil
+}
 
-				for _, label := range mf.Labels {
-					metricFilters[label.Key] = label.Value
-				}
+// StatusChanges streams the status transitions of the metrics matching one of the filters.
+func (r *subscriptionResolver) StatusChanges(ctx context.Context, metricsFilter []*MetricInput) (<-chan *StatusChange, error) {
+	if r.api.PointsHub == nil {
+		return nil, gqlerror.Errorf("Subscriptions are not available")
+	}
 
-				newMetrics, err := r.api.DB.Metrics(metricFilters)
-				if err != nil {
-					logger.V(2).Printf("Can not retrieve metrics: %v", err)
-					return nil, gqlerror.Errorf("Can not retrieve metrics")
-				}
+	filters, err := filtersFromInput(metricsFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.api.PointsHub.SubscribeStatusChanges(ctx, filters), nil
+}
+
+// filtersFromInput converts the GraphQL filters to label matchers. A label is matched as a
+// regular expression when its regex flag is set, by equality otherwise.
+func filtersFromInput(metricsFilter []*MetricInput) ([][]*labels.Matcher, error) {
+	filters := make([][]*labels.Matcher, 0, len(metricsFilter))
+
+	for _, mf := range metricsFilter {
+		filter := make([]*labels.Matcher, 0, len(mf.Labels))
+
+		for _, label := range mf.Labels {
+			matchType := labels.MatchEqual
+			if label.Regex != nil && *label.Regex {
+				matchType = labels.MatchRegexp
+			}
 
-				metrics = append(metrics, newMetrics...)
+			matcher, err := labels.NewMatcher(matchType, label.Key, label.Value)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid regular expression for label %s: %v", label.Key, err)
 			}
+
+			filter = append(filter, matcher)
 		}
-	} else {
-		var err error
 
-		metrics, err = r.api.DB.Metrics(map[string]string{})
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// matchingMetrics returns the metrics matching one of the filters, sorted by labels.
+// Filters without labels are ignored.
+func (r *queryResolver) matchingMetrics(metricsFilter []*MetricInput) ([]types.Metric, error) {
+	filters, err := filtersFromInput(metricsFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	type keyedMetric struct {
+		key    string
+		metric types.Metric
+	}
+
+	seen := make(map[string]bool)
+	matching := make([]keyedMetric, 0)
+
+	for _, filter := range filters {
+		if len(filter) == 0 {
+			continue
+		}
+
+		for _, m := range r.api.DB.MetricsMatching(filter) {
+			key := types.LabelsToText(m.Labels())
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+
+			matching = append(matching, keyedMetric{key: key, metric: m})
+		}
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].key < matching[j].key
+	})
+
+	metrics := make([]types.Metric, len(matching))
+
+	for i, m := range matching {
+		metrics[i] = m.metric
+	}
+
+	return metrics, nil
+}
+
+// metricUnit returns the unit annotated on the metric, nil when it's unknown.
+func metricUnit(metric types.Metric) *string {
+	unit := metric.Annotations().Unit
+	if unit == "" {
+		return nil
+	}
+
+	return &unit
+}
+
+// paginateMetrics returns the page of metrics selected by pagination, all metrics when pagination is nil.
+func paginateMetrics(metrics []types.Metric, pagination *Pagination) []types.Metric {
+	if pagination == nil {
+		return metrics
+	}
+
+	if pagination.Offset < 0 || pagination.Offset >= len(metrics) {
+		return nil
+	}
+
+	to := len(metrics)
+	if pagination.Limit >= 0 && pagination.Offset+pagination.Limit < to {
+		to = pagination.Offset + pagination.Limit
+	}
+
+	return metrics[pagination.Offset:to]
+}
+
+// Metrics returns a list of metrics sorted by labels
+// They can be filtered with an array of metrics which contains an array of labels, matched by
+// equality or as regular expressions, and paginated.
+func (r *queryResolver) Metrics(ctx context.Context, metricsFilter []*MetricInput, pagination *Pagination) ([]*Metric, error) {
+	if r.api.DB == nil {
+		return nil, gqlerror.Errorf("Can not retrieve metrics at this moment. Please try later")
+	}
+
+	var (
+		metrics []types.Metric
+		err     error
+	)
+
+	if len(metricsFilter) > 0 {
+		metrics, err = r.matchingMetrics(metricsFilter)
 		if err != nil {
-			logger.V(2).Printf("Can not retrieve metrics: %v", err)
-			return nil, gqlerror.Errorf("Can not retrieve metrics")
+			return nil, err
 		}
+	} else {
+		metrics = r.api.DB.MetricsMatching(nil)
 	}
 
+	metrics = paginateMetrics(metrics, pagination)
+
 	metricsRes := []*Metric{}
 
 	for _, metric := range metrics {
-		metricRes := &Metric{}
+		metricRes := &Metric{Unit: metricUnit(metric)}
 
 		labels := metric.Labels()
 		for key, value := range labels {
@@ -98,36 +222,23 @@ func (r *queryResolver) Metrics(ctx context.Context, metricsFilter []*MetricInpu
 
 // Points returns metrics's points between a time interval
 // This interval could be between a start and end dates or X minutes from now
-// Metrics can also be filtered.
-func (r *queryResolver) Points(ctx context.Context, metricsFilter []*MetricInput, start string, end string, minutes int) ([]*Metric, error) {
+// Metrics can also be filtered and paginated, limit keeps only the most recent points of each metric.
+func (r *queryResolver) Points(ctx context.Context, metricsFilter []*MetricInput, start string, end string, minutes int, pagination *Pagination, limit *int) ([]*Metric, error) {
 	if r.api.DB == nil || r.api.Threshold == nil {
 		return nil, gqlerror.Errorf("Can not retrieve points at this moment. Please try later")
 	}
 
-	metrics := []types.Metric{}
-
-	if len(metricsFilter) > 0 {
-		for _, mf := range metricsFilter {
-			if len(mf.Labels) > 0 {
-				metricFilters := map[string]string{}
-
-				for _, label := range mf.Labels {
-					metricFilters[label.Key] = label.Value
-				}
-
-				newMetrics, err := r.api.DB.Metrics(metricFilters)
-				if err != nil {
-					logger.V(2).Printf("Can not retrieve metrics: %v", err)
-					return nil, gqlerror.Errorf("Can not retrieve metrics")
-				}
-
-				metrics = append(metrics, newMetrics...)
-			}
-		}
-	} else {
+	if len(metricsFilter) == 0 {
 		return nil, gqlerror.Errorf("Can not retrieve points for every metrics")
 	}
 
+	metrics, err := r.matchingMetrics(metricsFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics = paginateMetrics(metrics, pagination)
+
 	finalStart := ""
 	finalEnd := ""
 
@@ -144,7 +255,7 @@ func (r *queryResolver) Points(ctx context.Context, metricsFilter []*MetricInput
 	metricsRes := []*Metric{}
 
 	for _, metric := range metrics {
-		metricRes := &Metric{}
+		metricRes := &Metric{Unit: metricUnit(metric)}
 
 		labels := metric.Labels()
 		annotations := metric.Annotations()
@@ -160,6 +271,10 @@ func (r *queryResolver) Points(ctx context.Context, metricsFilter []*MetricInput
 			return nil, gqlerror.Errorf("Can not retrieve points")
 		}
 
+		if limit != nil && *limit >= 0 && len(points) > *limit {
+			points = points[len(points)-*limit:]
+		}
+
 		for _, point := range points {
 			pointRes := &Point{Time: point.Time.UTC(), Value: point.Value}
 			metricRes.Points = append(metricRes.Points, pointRes)
@@ -406,6 +521,7 @@ func (r *queryResolver) Services(ctx context.Context, isActive bool) ([]*Service
 				ListenAddresses: netAddrs,
 				ExePath:         service.ExePath,
 				Active:          service.Active,
+				Expired:         service.Expired,
 			}
 
 			metrics, err := r.api.DB.Metrics(map[string]string{types.LabelName: service.Name + "_status"})
@@ -0,0 +1,86 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"glouton/store"
+	"glouton/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mountpoints(metrics []*Metric) []string {
+	result := make([]string, 0, len(metrics))
+
+	for _, m := range metrics {
+		for _, l := range m.Labels {
+			if l.Key == "mountpoint" {
+				result = append(result, l.Value)
+			}
+		}
+	}
+
+	return result
+}
+
+func TestMetricsFilterAndPagination(t *testing.T) {
+	db := store.New()
+
+	for _, mountpoint := range []string{"/srv", "/", "/home", "/var"} {
+		db.PushPoints([]types.MetricPoint{{
+			Labels: map[string]string{types.LabelName: "disk_used", "mountpoint": mountpoint},
+			Point:  types.Point{Time: time.Now(), Value: 42},
+		}})
+	}
+
+	regex := true
+	r := &queryResolver{&Resolver{api: &API{DB: db}}}
+	filter := []*MetricInput{{Labels: []*LabelInput{
+		{Key: types.LabelName, Value: "disk_used"},
+		{Key: "mountpoint", Value: "/(home|srv|var)", Regex: &regex},
+	}}}
+
+	cases := []struct {
+		name       string
+		pagination *Pagination
+		want       []string
+	}{
+		{name: "all", want: []string{"/home", "/srv", "/var"}},
+		{name: "first-page", pagination: &Pagination{Offset: 0, Limit: 2}, want: []string{"/home", "/srv"}},
+		{name: "last-page", pagination: &Pagination{Offset: 2, Limit: 2}, want: []string{"/var"}},
+		{name: "after-end", pagination: &Pagination{Offset: 3, Limit: 2}, want: []string{}},
+	}
+
+	for _, c := range cases {
+		metrics, err := r.Metrics(context.Background(), filter, c.pagination)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if got := mountpoints(metrics); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: mountpoints = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	invalid := []*MetricInput{{Labels: []*LabelInput{{Key: "mountpoint", Value: "(", Regex: &regex}}}}
+
+	if _, err := r.Metrics(context.Background(), invalid, nil); err == nil {
+		t.Errorf("Metrics() with an invalid regex succeeded, want an error")
+	}
+}
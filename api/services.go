@@ -0,0 +1,105 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"glouton/discovery"
+	"glouton/logger"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+const maxManualServiceRequestSize = 16 * 1024
+
+func (api *API) manualServicesListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(api.Disccovery.ManualServices()); err != nil {
+		logger.V(1).Printf("Failed to encode manual services: %v", err)
+	}
+}
+
+// manualServiceSetHandler creates a service with a POST on /services/manual or replaces
+// it with a PUT on /services/manual/<name>.
+func (api *API) manualServiceSetHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var service discovery.ManualService
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxManualServiceRequestSize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&service); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusCreated
+
+	if name := chi.URLParam(r, "name"); name != "" {
+		if service.Name != "" && service.Name != name {
+			http.Error(w, fmt.Sprintf("the name %#v doesn't match the URL", service.Name), http.StatusBadRequest)
+			return
+		}
+
+		service.Name = name
+		status = http.StatusOK
+	}
+
+	if err := api.Disccovery.SetManualService(service); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	api.manualServicesChanged(r, "updated", service.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(service); err != nil {
+		logger.V(1).Printf("Failed to encode manual service: %v", err)
+	}
+}
+
+func (api *API) manualServiceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	if !api.Disccovery.RemoveManualService(name) {
+		http.Error(w, fmt.Sprintf("unknown manual service %#v", name), http.StatusNotFound)
+		return
+	}
+
+	api.manualServicesChanged(r, "removed", name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// manualServicesChanged runs a discovery to send the change to the Bleemeo Cloud platform.
+func (api *API) manualServicesChanged(r *http.Request, action string, name string) {
+	logger.V(1).Printf("Manual service %s %s from the API by %s", name, action, r.RemoteAddr)
+
+	if api.AgentInfo != nil {
+		api.AgentInfo.FireTrigger(true, false, false, false)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"glouton/logger"
+	"net/http"
+)
+
+type taskResponse struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (api *API) tasksHandler(w http.ResponseWriter, r *http.Request) {
+	if api.Tasks == nil {
+		http.Error(w, "tasks are not available", http.StatusNotFound)
+		return
+	}
+
+	statuses := api.Tasks.Statuses()
+	response := make([]taskResponse, 0, len(statuses))
+
+	for _, t := range statuses {
+		task := taskResponse{
+			ID:       t.ID,
+			Name:     t.Name,
+			State:    "running",
+			Restarts: t.Restarts,
+		}
+
+		switch {
+		case t.Restarting:
+			task.State = "restarting"
+		case !t.Running && t.ExitError != nil:
+			task.State = "failed"
+		case !t.Running:
+			task.State = "stopped"
+		}
+
+		if t.ExitError != nil {
+			task.LastError = t.ExitError.Error()
+		}
+
+		response = append(response, task)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.V(1).Printf("Failed to encode tasks: %v", err)
+	}
+}
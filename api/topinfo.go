@@ -0,0 +1,206 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"glouton/facts"
+	"glouton/logger"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultTopInfoInterval = 3 * time.Second
+	topInfoWriteTimeout    = 10 * time.Second
+)
+
+// topInfoMessage is sent on the TopInfo websocket. The first message is a "snapshot" with
+// all processes, the following ones are "diff" with only the processes changes.
+type topInfoMessage struct {
+	Type string         `json:"type"`
+	Top  *facts.TopInfo `json:"top,omitempty"`
+	Diff *topInfoDiff   `json:"diff,omitempty"`
+}
+
+// topInfoDiff contains the changes since the previous message. The system wide values are small
+// and always sent.
+type topInfoDiff struct {
+	Time       int64             `json:"time"`
+	Uptime     int               `json:"uptime"`
+	Loads      []float64         `json:"loads"`
+	Users      int               `json:"users"`
+	CPU        facts.CPUUsage    `json:"cpu"`
+	Memory     facts.MemoryUsage `json:"memory"`
+	Swap       facts.SwapUsage   `json:"swap"`
+	Created    []facts.Process   `json:"created,omitempty"`
+	Updated    []processUpdate   `json:"updated,omitempty"`
+	Terminated []int             `json:"terminated,omitempty"`
+}
+
+// processUpdate contains the values of a process which change over time.
+type processUpdate struct {
+	PID        int     `json:"pid"`
+	MemoryRSS  uint64  `json:"memory_rss"`
+	CPUPercent float64 `json:"cpu_percent"`
+	CPUTime    float64 `json:"cpu_times"`
+	Status     string  `json:"status"`
+	NumThreads int     `json:"num_threads"`
+}
+
+// processKey identifies a process, a PID could be reused by a new process.
+type processKey struct {
+	pid        int
+	createTime int64
+}
+
+func updateOf(p facts.Process) processUpdate {
+	return processUpdate{
+		PID:        p.PID,
+		MemoryRSS:  p.MemoryRSS,
+		CPUPercent: p.CPUPercent,
+		CPUTime:    p.CPUTime,
+		Status:     p.Status,
+		NumThreads: p.NumThreads,
+	}
+}
+
+// diffTopInfo returns the processes created, updated and terminated between previous and current.
+func diffTopInfo(previous facts.TopInfo, current facts.TopInfo) topInfoDiff {
+	diff := topInfoDiff{
+		Time:   current.Time,
+		Uptime: current.Uptime,
+		Loads:  current.Loads,
+		Users:  current.Users,
+		CPU:    current.CPU,
+		Memory: current.Memory,
+		Swap:   current.Swap,
+	}
+
+	previousProcesses := make(map[processKey]facts.Process, len(previous.Processes))
+
+	for _, p := range previous.Processes {
+		previousProcesses[processKey{pid: p.PID, createTime: p.CreateTimestamp}] = p
+	}
+
+	for _, p := range current.Processes {
+		key := processKey{pid: p.PID, createTime: p.CreateTimestamp}
+
+		old, ok := previousProcesses[key]
+		if !ok {
+			diff.Created = append(diff.Created, p)
+
+			continue
+		}
+
+		delete(previousProcesses, key)
+
+		if update := updateOf(p); update != updateOf(old) {
+			diff.Updated = append(diff.Updated, update)
+		}
+	}
+
+	for key := range previousProcesses {
+		diff.Terminated = append(diff.Terminated, key.pid)
+	}
+
+	sort.Ints(diff.Terminated)
+
+	return diff
+}
+
+// topInfoStreamHandler streams the TopInfo on a websocket. The query parameter "interval"
+// is the delay in seconds between two messages.
+func (api *API) topInfoStreamHandler(w http.ResponseWriter, r *http.Request) {
+	interval := defaultTopInfoInterval
+
+	if value := r.URL.Query().Get("interval"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			http.Error(w, fmt.Sprintf("invalid interval %#v", value), http.StatusBadRequest)
+			return
+		}
+
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	upgrader := websocket.Upgrader{}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with an error.
+		return
+	}
+
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Messages from the client are ignored, but they must be read to process the close messages.
+	go func() {
+		defer cancel()
+
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous *facts.TopInfo
+
+	for {
+		top, err := api.PsFact.TopInfo(ctx, interval)
+		if err != nil {
+			logger.V(1).Printf("Unable to get the TopInfo: %v", err)
+
+			return
+		}
+
+		message := topInfoMessage{Type: "snapshot", Top: &top}
+
+		if previous != nil {
+			diff := diffTopInfo(*previous, top)
+			message = topInfoMessage{Type: "diff", Diff: &diff}
+		}
+
+		previous = &top
+
+		_ = conn.SetWriteDeadline(time.Now().Add(topInfoWriteTimeout))
+
+		if err := conn.WriteJSON(message); err != nil {
+			logger.V(2).Printf("TopInfo websocket closed: %v", err)
+
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"glouton/facts"
+	"reflect"
+	"testing"
+)
+
+func TestDiffTopInfo(t *testing.T) {
+	previous := facts.TopInfo{
+		Time: 1000,
+		Processes: []facts.Process{
+			{PID: 1, CreateTimestamp: 10, Name: "init", CPUPercent: 0.1},
+			{PID: 42, CreateTimestamp: 20, Name: "nginx", CPUPercent: 2, MemoryRSS: 1000},
+			{PID: 43, CreateTimestamp: 30, Name: "bash"},
+			{PID: 44, CreateTimestamp: 40, Name: "sleep"},
+		},
+	}
+	current := facts.TopInfo{
+		Time:  1003,
+		Users: 2,
+		Processes: []facts.Process{
+			{PID: 1, CreateTimestamp: 10, Name: "init", CPUPercent: 0.1},
+			{PID: 42, CreateTimestamp: 20, Name: "nginx", CPUPercent: 5, MemoryRSS: 2000},
+			// The PID was reused by a new process.
+			{PID: 44, CreateTimestamp: 50, Name: "curl"},
+			{PID: 45, CreateTimestamp: 50, Name: "grep"},
+		},
+	}
+
+	want := topInfoDiff{
+		Time:  1003,
+		Users: 2,
+		Created: []facts.Process{
+			{PID: 44, CreateTimestamp: 50, Name: "curl"},
+			{PID: 45, CreateTimestamp: 50, Name: "grep"},
+		},
+		Updated:    []processUpdate{{PID: 42, CPUPercent: 5, MemoryRSS: 2000}},
+		Terminated: []int{43, 44},
+	}
+
+	if got := diffTopInfo(previous, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffTopInfo() = %+v, want %+v", got, want)
+	}
+}
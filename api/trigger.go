@@ -0,0 +1,99 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"glouton/logger"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Triggers which could be fired with a POST on /trigger/<name>. Configuration management
+// tools use them to apply a change now instead of waiting for the next periodic run.
+const (
+	triggerDiscovery    = "discovery"
+	triggerFacts        = "facts"
+	triggerSystemUpdate = "system_update"
+	triggerBleemeoSync  = "bleemeo_sync"
+)
+
+// minBleemeoSyncInterval is the minimum delay between two bleemeo_sync triggers, a full
+// synchronization sends many requests to the Bleemeo API.
+const minBleemeoSyncInterval = time.Minute
+
+func (api *API) triggerHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	// A form can't be sent with a JSON content type, web pages can't fire triggers.
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if api.AgentInfo == nil {
+		http.Error(w, "triggers are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch name {
+	case triggerDiscovery:
+		api.AgentInfo.FireTrigger(true, false, false, false)
+	case triggerFacts:
+		api.AgentInfo.FireTrigger(false, true, false, false)
+	case triggerSystemUpdate:
+		api.AgentInfo.FireTrigger(false, false, true, false)
+	case triggerBleemeoSync:
+		if wait := api.reserveBleemeoSync(time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+			http.Error(w, "a Bleemeo synchronization was already triggered recently", http.StatusTooManyRequests)
+
+			return
+		}
+
+		if !api.AgentInfo.BleemeoScheduleFullSync() {
+			http.Error(w, "the Bleemeo connector is disabled", http.StatusConflict)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unknown trigger %#v", name), http.StatusNotFound)
+		return
+	}
+
+	logger.V(1).Printf("Trigger %s fired from the API by %s", name, r.RemoteAddr)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// reserveBleemeoSync returns how long to wait before the next bleemeo_sync trigger, or
+// zero when the trigger is allowed now.
+func (api *API) reserveBleemeoSync(now time.Time) time.Duration {
+	api.triggerLock.Lock()
+	defer api.triggerLock.Unlock()
+
+	if wait := api.lastBleemeoSync.Add(minBleemeoSyncInterval).Sub(now); wait > 0 {
+		return wait
+	}
+
+	api.lastBleemeoSync = now
+
+	return 0
+}
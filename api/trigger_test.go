@@ -0,0 +1,129 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+type mockAgent struct {
+	bleemeoEnabled bool
+	triggers       [][4]bool
+	fullSyncs      int
+}
+
+func (a *mockAgent) BleemeoRegistrationAt() time.Time { return time.Time{} }
+func (a *mockAgent) BleemeoLastReport() time.Time     { return time.Time{} }
+func (a *mockAgent) BleemeoConnected() bool           { return a.bleemeoEnabled }
+func (a *mockAgent) Tags() []string                   { return nil }
+
+func (a *mockAgent) BleemeoScheduleFullSync() bool {
+	if a.bleemeoEnabled {
+		a.fullSyncs++
+	}
+
+	return a.bleemeoEnabled
+}
+
+func (a *mockAgent) FireTrigger(discovery bool, sendFacts bool, systemUpdateMetric bool, secondDiscovery bool) {
+	a.triggers = append(a.triggers, [4]bool{discovery, sendFacts, systemUpdateMetric, secondDiscovery})
+}
+
+func TestTriggerHandler(t *testing.T) {
+	agent := &mockAgent{}
+	api := &API{AgentInfo: agent}
+
+	router := chi.NewRouter()
+	router.Post("/trigger/{name}", api.triggerHandler)
+
+	cases := []struct {
+		path     string
+		wantCode int
+	}{
+		{"/trigger/discovery", http.StatusAccepted},
+		{"/trigger/facts", http.StatusAccepted},
+		{"/trigger/system_update", http.StatusAccepted},
+		{"/trigger/bleemeo_sync", http.StatusConflict},
+		{"/trigger/unknown", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, triggerRequest(c.path))
+
+		if rec.Code != c.wantCode {
+			t.Errorf("POST %s = %d, want %d", c.path, rec.Code, c.wantCode)
+		}
+	}
+
+	want := [][4]bool{
+		{true, false, false, false},
+		{false, true, false, false},
+		{false, false, true, false},
+	}
+
+	if len(agent.triggers) != len(want) {
+		t.Fatalf("FireTrigger called %d times, want %d", len(agent.triggers), len(want))
+	}
+
+	for i, w := range want {
+		if agent.triggers[i] != w {
+			t.Errorf("FireTrigger call #%d = %v, want %v", i, agent.triggers[i], w)
+		}
+	}
+
+	agent.bleemeoEnabled = true
+	api.lastBleemeoSync = time.Time{}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, triggerRequest("/trigger/bleemeo_sync"))
+
+	if rec.Code != http.StatusAccepted || agent.fullSyncs != 1 {
+		t.Errorf("POST /trigger/bleemeo_sync = %d with %d full sync, want %d with 1", rec.Code, agent.fullSyncs, http.StatusAccepted)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, triggerRequest("/trigger/bleemeo_sync"))
+
+	if rec.Code != http.StatusTooManyRequests || agent.fullSyncs != 1 {
+		t.Errorf("second POST /trigger/bleemeo_sync = %d with %d full sync, want %d with 1", rec.Code, agent.fullSyncs, http.StatusTooManyRequests)
+	}
+
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("second POST /trigger/bleemeo_sync has no Retry-After header")
+	}
+
+	// A request without a JSON content type, like a form sent by a web page, is refused.
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trigger/discovery", nil))
+
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("POST /trigger/discovery without content type = %d, want %d", rec.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func triggerRequest(path string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.Header.Set("Content-Type", "application/json")
+
+	return req
+}
@@ -0,0 +1,134 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit records the significant actions of the agent, like a service added by
+// the discovery or a MQTT reconnection. The log is persisted in the state, so it allows
+// to know why some metrics disappeared after a restart.
+package audit
+
+import (
+	"fmt"
+	"glouton/logger"
+	"sync"
+	"time"
+)
+
+// StateKey is the key of the events in the state.
+const StateKey = "AuditLog"
+
+// DefaultSize is the number of events kept by default, older events are dropped.
+const DefaultSize = 1000
+
+// Kinds of events.
+const (
+	KindDiscovery = "discovery"
+	KindInput     = "input"
+	KindThreshold = "threshold"
+	KindConfig    = "config"
+	KindMQTT      = "mqtt"
+	KindCommand   = "command"
+)
+
+// Event is an action of the agent.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+}
+
+// State is the interface used to persist the events.
+type State interface {
+	Set(key string, object interface{}) error
+	Get(key string, result interface{}) error
+}
+
+// Log is a bounded log of events. The methods of a nil Log do nothing.
+type Log struct {
+	l      sync.Mutex
+	state  State
+	size   int
+	events []Event
+}
+
+// New returns a Log which keeps the last size events. The events saved in the state are reloaded.
+func New(state State, size int) *Log {
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	log := &Log{
+		state: state,
+		size:  size,
+	}
+
+	if state != nil {
+		if err := state.Get(StateKey, &log.events); err != nil {
+			logger.V(1).Printf("Unable to load the audit log from the state: %v", err)
+		}
+	}
+
+	if len(log.events) > size {
+		log.events = log.events[len(log.events)-size:]
+	}
+
+	return log
+}
+
+// Record adds an event. The message is formatted like fmt.Sprintf.
+func (l *Log) Record(kind string, fmtArg string, a ...interface{}) {
+	if l == nil {
+		return
+	}
+
+	event := Event{
+		Time:    time.Now(),
+		Kind:    kind,
+		Message: fmt.Sprintf(fmtArg, a...),
+	}
+
+	logger.V(2).Printf("Audit %s: %s", event.Kind, event.Message)
+
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	if len(l.events) >= l.size {
+		// Copy the events to release the oldest ones instead of growing the backing array.
+		l.events = append(l.events[:0:0], l.events[len(l.events)-l.size+1:]...)
+	}
+
+	l.events = append(l.events, event)
+
+	if l.state != nil {
+		if err := l.state.Set(StateKey, l.events); err != nil {
+			logger.V(1).Printf("Unable to save the audit log in the state: %v", err)
+		}
+	}
+}
+
+// Events returns the events, oldest first.
+func (l *Log) Events() []Event {
+	if l == nil {
+		return nil
+	}
+
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	result := make([]Event, len(l.events))
+	copy(result, l.events)
+
+	return result
+}
@@ -0,0 +1,60 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"glouton/agent/state"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	st := state.NewMock()
+	log := New(st, 3)
+
+	for i := 0; i < 5; i++ {
+		log.Record(KindDiscovery, "service %d added", i)
+	}
+
+	events := log.Events()
+	want := []string{"service 2 added", "service 3 added", "service 4 added"}
+
+	if len(events) != len(want) {
+		t.Fatalf("len(Events()) = %d, want %d", len(events), len(want))
+	}
+
+	for i, w := range want {
+		if events[i].Message != w || events[i].Kind != KindDiscovery {
+			t.Errorf("Events()[%d] = %v, want %s event %#v", i, events[i], KindDiscovery, w)
+		}
+	}
+
+	// The events are reloaded from the state on restart.
+	reloaded := New(st, 2).Events()
+	if len(reloaded) != 2 || reloaded[1].Message != "service 4 added" {
+		t.Errorf("Events() after reload = %v, want the last 2 events", reloaded)
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var log *Log
+
+	log.Record(KindMQTT, "connection lost")
+
+	if events := log.Events(); len(events) != 0 {
+		t.Errorf("Events() = %v, want empty", events)
+	}
+}
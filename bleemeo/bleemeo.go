@@ -25,6 +25,7 @@ import (
 	"sync"
 	"time"
 
+	"glouton/audit"
 	"glouton/bleemeo/internal/cache"
 	"glouton/bleemeo/internal/mqtt"
 	"glouton/bleemeo/internal/synchronizer"
@@ -127,6 +128,9 @@ func (c *Connector) initMQTT(previousPoint []gloutonTypes.MetricPoint, first boo
 			UpdateMetrics:        c.sync.UpdateMetrics,
 			UpdateMaintenance:    c.sync.UpdateMaintenance,
 			UpdateMonitor:        c.sync.UpdateMonitor,
+			TriggerDiscovery:     c.option.TriggerDiscovery,
+			DiagnosticZip:        c.option.DiagnosticZip,
+			RunAction:            c.option.RunAction,
 			InitialPoints:        previousPoint,
 		},
 		first,
@@ -341,6 +345,11 @@ func (c *Connector) UpdateContainers() {
 	c.sync.UpdateContainers()
 }
 
+// ScheduleFullSync requests a full synchronization with the Bleemeo API as soon as possible.
+func (c *Connector) ScheduleFullSync() {
+	c.sync.ScheduleFullSync()
+}
+
 // UpdateMonitors trigger a reload of the monitors.
 func (c *Connector) UpdateMonitors() {
 	c.sync.UpdateMonitors()
@@ -398,6 +407,14 @@ func (c *Connector) DiagnosticPage() string {
 		fmt.Fprintln(builder, "The Bleemeo connector is currently in read-only/maintenance mode, not syncing nor sending any metric")
 	}
 
+	if notRegistered, quotaErr := c.sync.MetricsQuotaStatus(); quotaErr != nil {
+		fmt.Fprintf(builder, "The metrics quota of the account is exceeded, %d metrics are not registered: %v\n", notRegistered, quotaErr)
+	}
+
+	if denied := c.sync.MetricsDenied(); denied > 0 {
+		fmt.Fprintf(builder, "%d metrics are not sent because they are not in the metrics whitelist of the account\n", denied)
+	}
+
 	mqtt := c.mqtt
 	c.l.Unlock()
 
@@ -513,6 +530,18 @@ func (c *Connector) LastReport() time.Time {
 	return c.lastKnownReport
 }
 
+// PendingPointsCount return the number of points waiting to be sent to Bleemeo Cloud platform.
+func (c *Connector) PendingPointsCount() int {
+	c.l.RLock()
+	defer c.l.RUnlock()
+
+	if c.mqtt == nil {
+		return 0
+	}
+
+	return c.mqtt.PendingPointsCount()
+}
+
 // HealthCheck perform some health check and logger any issue found.
 func (c *Connector) HealthCheck() bool {
 	ok := true
@@ -574,12 +603,18 @@ func (c *Connector) emitInternalMetric() {
 	if c.mqtt != nil && c.mqtt.Connected() {
 		c.option.Acc.AddFields("", map[string]interface{}{"agent_status": 1.0}, nil)
 	}
+
+	if c.sync != nil {
+		notRegistered, _ := c.sync.MetricsQuotaStatus()
+		c.option.Acc.AddFields("", map[string]interface{}{"metrics_not_registered": float64(notRegistered)}, nil)
+	}
 }
 
 func (c *Connector) updateConfig() {
 	currentConfig := c.cache.CurrentAccountConfig()
 
 	logger.Printf("Changed to configuration %s", currentConfig.Name)
+	c.option.AuditLog.Record(audit.KindConfig, "Changed to account configuration %s", currentConfig.Name)
 
 	if c.option.UpdateMetricResolution != nil {
 		c.option.UpdateMetricResolution(time.Duration(currentConfig.MetricAgentResolution) * time.Second)
@@ -22,10 +22,12 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"glouton/proxy"
 	"glouton/version"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -39,6 +41,10 @@ type HTTPClient struct {
 	ctx      context.Context
 
 	cl *http.Client
+	// headers are added to all requests, see SetHeader.
+	headers http.Header
+	// rebaseNext is true when the next pages must be reached through the base URL, see RebaseNextPages.
+	rebaseNext bool
 
 	l        sync.Mutex
 	jwtToken string
@@ -51,6 +57,8 @@ type APIError struct {
 	Content      string
 	UnmarshalErr error
 	IsAuthError  bool
+	// RetryAfter is the delay requested by the Retry-After header, zero when absent.
+	RetryAfter time.Duration
 }
 
 // IsAuthError return true if the error is an APIError due to authentication failure.
@@ -80,6 +88,41 @@ func IsServerError(err error) bool {
 	return false
 }
 
+// IsThrottleError return true if the error is an APIError due to too many requests (429).
+func IsThrottleError(err error) bool {
+	if apiError, ok := err.(APIError); ok {
+		return apiError.StatusCode == http.StatusTooManyRequests
+	}
+
+	return false
+}
+
+// ThrottleDelay return the delay requested by the API before retrying, zero when unknown.
+func ThrottleDelay(err error) time.Duration {
+	if apiError, ok := err.(APIError); ok {
+		return apiError.RetryAfter
+	}
+
+	return 0
+}
+
+// IsQuotaExceeded return true if the error is an APIError due to the account reaching one of its quota,
+// e.g. its maximum number of metrics.
+func IsQuotaExceeded(err error) bool {
+	apiError, ok := err.(APIError)
+	if !ok || apiError.StatusCode < 400 || apiError.StatusCode >= 500 || apiError.StatusCode == http.StatusTooManyRequests {
+		return false
+	}
+
+	if apiError.StatusCode == http.StatusPaymentRequired {
+		return true
+	}
+
+	content := strings.ToLower(apiError.Content)
+
+	return strings.Contains(content, "quota") || strings.Contains(content, "too many")
+}
+
 func (ae APIError) Error() string {
 	if ae.Content == "" && ae.UnmarshalErr != nil {
 		return fmt.Sprintf("unable to decode JSON: %v", ae.UnmarshalErr)
@@ -92,7 +135,8 @@ func (ae APIError) Error() string {
 //
 // It does the authentication (using JWT currently) and may do rate-limiting/throtteling, so
 // most function may return a ThrottleError.
-func NewClient(ctx context.Context, baseURL string, username string, password string, insecureTLS bool) (*HTTPClient, error) {
+// tlsConfig may be nil to use the default TLS configuration.
+func NewClient(ctx context.Context, baseURL string, username string, password string, tlsConfig *tls.Config) (*HTTPClient, error) {
 	u, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, err
@@ -100,10 +144,8 @@ func NewClient(ctx context.Context, baseURL string, username string, password st
 
 	cl := &http.Client{
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: insecureTLS, //nolint: gosec
-			},
+			Proxy:           proxy.FromRequest,
+			TLSClientConfig: tlsConfig,
 		},
 	}
 
@@ -116,15 +158,27 @@ func NewClient(ctx context.Context, baseURL string, username string, password st
 	}, nil
 }
 
+// SetHeader adds a header to all requests. It must be called before the client is used.
+func (c *HTTPClient) SetHeader(name string, value string) {
+	if c.headers == nil {
+		c.headers = make(http.Header)
+	}
+
+	c.headers.Set(name, value)
+}
+
+// RebaseNextPages makes the next pages of Iter relative to the base URL when the API returns
+// them on another host. It must be called before the client is used.
+func (c *HTTPClient) RebaseNextPages() {
+	c.rebaseNext = true
+}
+
 // Do perform the specified request.
 //
 // Response is assumed to be JSON and will be decoded into result. If result is nil, response is not decoded
 //
 // If submittedData is not-nil, it's the body content of the request.
 func (c *HTTPClient) Do(method string, path string, params map[string]string, data interface{}, result interface{}) (statusCode int, err error) {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	req, err := c.prepareRequest(method, path, params, data)
 	if err != nil {
 		return 0, err
@@ -135,9 +189,6 @@ func (c *HTTPClient) Do(method string, path string, params map[string]string, da
 
 // DoUnauthenticated perform the specified request, but without the JWT token used in `Do`. It is otherwise exactly similar to `Do.
 func (c *HTTPClient) DoUnauthenticated(method string, path string, params map[string]string, data interface{}, result interface{}) (statusCode int, err error) {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	req, err := c.prepareRequest(method, path, params, data)
 	if err != nil {
 		return 0, err
@@ -183,9 +234,6 @@ func (c *HTTPClient) prepareRequest(method string, path string, params map[strin
 
 // PostAuth perform the post on specified path. baseURL will be always be added.
 func (c *HTTPClient) PostAuth(path string, data interface{}, username string, password string, result interface{}) (statusCode int, err error) {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	req, err := c.prepareRequest("POST", path, nil, data)
 	if err != nil {
 		return 0, err
@@ -228,6 +276,10 @@ func (c *HTTPClient) Iter(resource string, params map[string]string) ([]json.Raw
 
 		result = append(result, page.Results...)
 		next = page.Next
+		if c.rebaseNext {
+			next = c.rebaseURL(next)
+		}
+
 		params = nil // params are now included in next url.
 
 		if next == "" {
@@ -238,18 +290,35 @@ func (c *HTTPClient) Iter(resource string, params map[string]string) ([]json.Raw
 	return result, nil
 }
 
+// rebaseURL returns the URL relative to the base URL when it's on another host. It's used
+// when the API is reached through a relay: the API returns URLs of its own host, which the
+// agent can't reach. The API is assumed to be at the root of its host.
+func (c *HTTPClient) rebaseURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || !u.IsAbs() || u.Host == c.baseURL.Host {
+		return value
+	}
+
+	relative := strings.TrimPrefix(u.Path, "/")
+	if u.RawQuery != "" {
+		relative += "?" + u.RawQuery
+	}
+
+	return relative
+}
+
 func (c *HTTPClient) do(req *http.Request, result interface{}, firstCall bool, withAuth bool) (int, error) {
+	var token string
+
 	if withAuth {
-		if c.jwtToken == "" {
-			newToken, err := c.GetJWT()
-			if err != nil {
-				return 0, err
-			}
+		var err error
 
-			c.jwtToken = newToken
+		token, err = c.token()
+		if err != nil {
+			return 0, err
 		}
 
-		req.Header.Set("Authorization", fmt.Sprintf("JWT %s", c.jwtToken))
+		req.Header.Set("Authorization", fmt.Sprintf("JWT %s", token))
 	}
 
 	statusCode, err := c.sendRequest(req, result)
@@ -258,7 +327,12 @@ func (c *HTTPClient) do(req *http.Request, result interface{}, firstCall bool, w
 	if withAuth && firstCall && err != nil {
 		if apiError, ok := err.(APIError); ok {
 			if apiError.StatusCode == 401 {
-				c.jwtToken = ""
+				c.l.Lock()
+				if c.jwtToken == token {
+					c.jwtToken = ""
+				}
+				c.l.Unlock()
+
 				return c.do(req, result, false, withAuth)
 			}
 		}
@@ -267,6 +341,24 @@ func (c *HTTPClient) do(req *http.Request, result interface{}, firstCall bool, w
 	return statusCode, err
 }
 
+// token return the current JWT token, requesting a new one if needed.
+// The lock is only held while obtaining the token, so requests may run concurrently.
+func (c *HTTPClient) token() (string, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.jwtToken == "" {
+		newToken, err := c.GetJWT()
+		if err != nil {
+			return "", err
+		}
+
+		c.jwtToken = newToken
+	}
+
+	return c.jwtToken, nil
+}
+
 // GetJWT return a new JWT token for authentication with Bleemeo API.
 func (c *HTTPClient) GetJWT() (string, error) {
 	u, _ := c.baseURL.Parse("v1/jwt-auth/")
@@ -321,6 +413,10 @@ func (c *HTTPClient) sendRequest(req *http.Request, result interface{}) (int, er
 	req.Header.Add("X-Requested-With", "XMLHttpRequest")
 	req.Header.Add("User-Agent", version.UserAgent())
 
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+
 	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
 	defer cancel()
 
@@ -334,7 +430,10 @@ func (c *HTTPClient) sendRequest(req *http.Request, result interface{}) (int, er
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return 0, decodeError(resp)
+		apiError := decodeError(resp)
+		apiError.RetryAfter = retryAfter(resp.Header.Get("Retry-After"))
+
+		return 0, apiError
 	}
 
 	if result != nil {
@@ -351,6 +450,23 @@ func (c *HTTPClient) sendRequest(req *http.Request, result interface{}) (int, er
 	return resp.StatusCode, nil
 }
 
+// retryAfter parses the value of a Retry-After header, either a number of seconds or an HTTP date.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
 func decodeError(resp *http.Response) APIError {
 	if resp.Header.Get("Content-Type") != "application/json" {
 		partialBody := make([]byte, 250)
@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_decodeError(t *testing.T) {
@@ -101,3 +102,23 @@ Content-Length: 68
 		})
 	}
 }
+
+func Test_retryAfter(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{value: "", wantMin: 0, wantMax: 0},
+		{value: "120", wantMin: 2 * time.Minute, wantMax: 2 * time.Minute},
+		{value: "invalid", wantMin: 0, wantMax: 0},
+		{value: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), wantMin: 59 * time.Minute, wantMax: time.Hour},
+		{value: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), wantMin: 0, wantMax: 0},
+	}
+
+	for _, tt := range tests {
+		if got := retryAfter(tt.value); got < tt.wantMin || got > tt.wantMax {
+			t.Errorf("retryAfter(%#v) = %v, want between %v and %v", tt.value, got, tt.wantMin, tt.wantMax)
+		}
+	}
+}
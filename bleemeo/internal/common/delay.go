@@ -19,6 +19,7 @@ package common
 import (
 	"context"
 	"glouton/bleemeo/types"
+	"glouton/clock"
 	"glouton/logger"
 	"math/rand"
 	"time"
@@ -45,6 +46,7 @@ func JitterDelay(valueSecond float64, factor float64, maxSecond float64) time.Du
 // what is used for log message, to tell what is waiting the deadline.
 func WaitDeadline(ctx context.Context, minimalDelay time.Duration, getDeadline func() (time.Time, types.DisableReason), what string) {
 	deadline, reason := getDeadline()
+	deadline = clock.Monotonic(deadline)
 	sleepUntil := deadline
 
 	minimalDeadline := time.Now().Add(minimalDelay)
@@ -74,6 +76,7 @@ func WaitDeadline(ctx context.Context, minimalDelay time.Duration, getDeadline f
 		}
 
 		deadline, reason = getDeadline()
+		deadline = clock.Monotonic(deadline)
 		sleepUntil = deadline
 
 		if sleepUntil.Before(minimalDeadline) {
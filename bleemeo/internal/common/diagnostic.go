@@ -5,11 +5,14 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"glouton/proxy"
 	"glouton/version"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -19,11 +22,30 @@ import (
 func DiagnosticTCP(host string, port int, tlsConfig *tls.Config) string {
 	builder := &strings.Builder{}
 
-	hostPort := fmt.Sprintf("%s:%d", host, port)
+	hostPort := net.JoinHostPort(host, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", hostPort, 5*time.Second)
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	proxyURL, err := proxy.ForURL(&url.URL{Scheme: scheme, Host: hostPort})
+	if err != nil {
+		fmt.Fprintf(builder, "Invalid proxy configuration: %v\n", err)
+
+		return builder.String()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := proxy.DialContext(ctx, scheme, hostPort)
 	if err != nil {
-		fmt.Fprintf(builder, "Unable to open TCP connection to %s: %v\n", hostPort, err)
+		fmt.Fprintf(builder, "Unable to open TCP connection to %s%s: %v\n", hostPort, proxyDescription(proxyURL), err)
+
+		if proxyURL != nil {
+			return builder.String()
+		}
 
 		hostIP, err := net.ResolveTCPAddr("tcp", hostPort)
 		if err != nil {
@@ -50,9 +72,10 @@ func DiagnosticTCP(host string, port int, tlsConfig *tls.Config) string {
 
 	fmt.Fprintf(
 		builder,
-		"Glouton is able to establish TCP connection to %s (%s). TLS is disabled in configuration\n",
+		"Glouton is able to establish TCP connection to %s (%s)%s. TLS is disabled in configuration\n",
 		hostPort,
 		conn.RemoteAddr().String(),
+		proxyDescription(proxyURL),
 	)
 
 	return builder.String()
@@ -96,11 +119,20 @@ func diagnosticTLS(builder io.Writer, tlsConfig *tls.Config, host string, hostPo
 	}
 }
 
+// proxyDescription returns a text describing the proxy used, without its credentials.
+func proxyDescription(proxyURL *url.URL) string {
+	if proxyURL == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(" through proxy %s", proxyURL.Redacted())
+}
+
 // DiagnosticHTTP return information about the ability to do a HTTP request.
 func DiagnosticHTTP(u string, tlsConfig *tls.Config) string {
 	cl := &http.Client{
 		Transport: &http.Transport{
-			Proxy:           http.ProxyFromEnvironment,
+			Proxy:           proxy.FromRequest,
 			TLSClientConfig: tlsConfig,
 		},
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
@@ -113,6 +145,11 @@ func DiagnosticHTTP(u string, tlsConfig *tls.Config) string {
 		return fmt.Sprintf("Bad URL %#v: %v\n", u, err)
 	}
 
+	proxyURL, err := proxy.FromRequest(req)
+	if err != nil {
+		return fmt.Sprintf("Invalid proxy configuration: %v\n", err)
+	}
+
 	req.Header.Add("X-Requested-With", "XMLHttpRequest")
 	req.Header.Add("User-Agent", version.UserAgent())
 
@@ -123,11 +160,11 @@ func DiagnosticHTTP(u string, tlsConfig *tls.Config) string {
 	resp, err := cl.Do(req)
 
 	if err != nil {
-		return fmt.Sprintf("Glouton is NOT able to perform HTTP request to %#v: %v\n", u, err)
+		return fmt.Sprintf("Glouton is NOT able to perform HTTP request to %#v%s: %v\n", u, proxyDescription(proxyURL), err)
 	}
 
 	_, _ = io.Copy(ioutil.Discard, resp.Body)
 	defer resp.Body.Close()
 
-	return fmt.Sprintf("Glouton is able to perform HTTP request to %#v\n", u)
+	return fmt.Sprintf("Glouton is able to perform HTTP request to %#v%s\n", u, proxyDescription(proxyURL))
 }
@@ -0,0 +1,73 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var errRelayNotHTTPS = errors.New("the relay URL must use https")
+
+// RelayTokenHeader is the header with the token of the agents using a relay. The Authorization
+// header can't be used, it holds the credentials of the agent on the Bleemeo API.
+const RelayTokenHeader = "X-Relay-Token"
+
+// RelayPath is the path of the relay on the listener of the relay agent.
+const RelayPath = "/relay"
+
+// RelayMessage is a MQTT message sent to a relay.
+type RelayMessage struct {
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+}
+
+// RelayBatch is the body of the requests an agent sends to the MQTT endpoint of a relay.
+// The relay publishes the messages on the Bleemeo MQTT with the agent credentials.
+type RelayBatch struct {
+	AgentID  string         `json:"agent_id"`
+	Password string         `json:"password"`
+	Messages []RelayMessage `json:"messages"`
+}
+
+// RelayAPIBase returns the base of the Bleemeo API reached through the relay at relayURL.
+func RelayAPIBase(relayURL string) string {
+	return strings.TrimSuffix(relayURL, "/") + RelayPath + "/api/"
+}
+
+// RelayMQTTURL returns the URL where the MQTT messages are sent to the relay at relayURL.
+func RelayMQTTURL(relayURL string) string {
+	return strings.TrimSuffix(relayURL, "/") + RelayPath + "/mqtt"
+}
+
+// RelayTLSConfig returns the TLS configuration used to connect to the relay at relayURL.
+// The relay must use HTTPS, the agents send it their credentials.
+func RelayTLSConfig(relayURL string, caFile string, fingerprints []string, insecure bool) (*tls.Config, error) {
+	u, err := url.Parse(relayURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay URL: %w", err)
+	}
+
+	if u.Scheme != "https" {
+		return nil, errRelayNotHTTPS
+	}
+
+	return TLSConfig(u.Hostname(), caFile, fingerprints, insecure)
+}
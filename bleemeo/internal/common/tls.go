@@ -0,0 +1,156 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	errNotPEM              = errors.New("not a PEM file")
+	errInvalidFingerprint  = errors.New("invalid certificate fingerprint")
+	errFingerprintMismatch = errors.New("no certificate matches the pinned fingerprints")
+)
+
+// TLSConfig returns the TLS configuration used to connect to serverName, a Bleemeo endpoint.
+//
+// caFile is a PEM file with the trusted CAs, empty to use the system ones.
+// fingerprints are SHA-256 fingerprints of pinned certificates (hex encoded, colons
+// are optional). When fingerprints are given, the certificate of the server must be
+// pinned, in which case it could be self-signed, or the chain verified with the CAs
+// must contain a pinned certificate. The validity and the name of the server
+// certificate are always checked.
+func TLSConfig(serverName string, caFile string, fingerprints []string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecure, //nolint:gosec
+	}
+
+	if caFile != "" {
+		rootCAs, err := LoadRootCAs(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load CAs from %#v: %w", caFile, err)
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if len(fingerprints) == 0 || insecure {
+		return tlsConfig, nil
+	}
+
+	pinned := make(map[string]bool, len(fingerprints))
+
+	for _, f := range fingerprints {
+		f = strings.ToLower(strings.ReplaceAll(f, ":", ""))
+
+		if decoded, err := hex.DecodeString(f); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%w %#v", errInvalidFingerprint, f)
+		}
+
+		pinned[f] = true
+	}
+
+	// The default verification would refuse self-signed certificates, the chain is
+	// verified by verifyPinned instead.
+	tlsConfig.InsecureSkipVerify = true //nolint:gosec
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return verifyPinned(rawCerts, serverName, tlsConfig.RootCAs, pinned)
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyPinned checks the certificates sent by the server. The server certificate must be
+// valid for serverName and either be pinned or have a chain up to roots with a pinned
+// certificate. The handshake already checked the server owns the key of its certificate.
+func verifyPinned(rawCerts [][]byte, serverName string, roots *x509.CertPool, pinned map[string]bool) error {
+	if len(rawCerts) == 0 {
+		return errFingerprintMismatch
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	// A pinned server certificate is trusted by itself, whoever signed it.
+	if pinned[fingerprint(certs[0])] {
+		opts.Roots = x509.NewCertPool()
+		opts.Roots.AddCert(certs[0])
+	}
+
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return err
+	}
+
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if pinned[fingerprint(cert)] {
+				return nil
+			}
+		}
+	}
+
+	return errFingerprintMismatch
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadRootCAs returns a pool with the certificates of the PEM file caFile.
+func LoadRootCAs(caFile string) (*x509.CertPool, error) {
+	rootCAs := x509.NewCertPool()
+
+	certs, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	ok := rootCAs.AppendCertsFromPEM(certs)
+	if !ok {
+		return nil, errNotPEM
+	}
+
+	return rootCAs, nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCertificate returns a certificate for 127.0.0.1 signed by parent, or self-signed
+// when parent is nil.
+func newCertificate(t *testing.T, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "glouton test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// newTLSServer returns a server sending the chain of certificates, the key is the one of the first.
+func newTLSServer(key *ecdsa.PrivateKey, chain ...*x509.Certificate) *httptest.Server {
+	certificate := tls.Certificate{PrivateKey: key}
+
+	for _, cert := range chain {
+		certificate.Certificate = append(certificate.Certificate, cert.Raw)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	srv.StartTLS()
+
+	return srv
+}
+
+func get(tlsConfig *tls.Config, url string) error {
+	cl := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	resp, err := cl.Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	return err
+}
+
+func TestTLSConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	var colonFingerprint []string
+
+	for i := 0; i < len(fingerprint); i += 2 {
+		colonFingerprint = append(colonFingerprint, strings.ToUpper(fingerprint[i:i+2]))
+	}
+
+	cases := []struct {
+		name         string
+		serverName   string
+		fingerprints []string
+		insecure     bool
+		wantErr      bool
+	}{
+		{name: "system-CAs", serverName: "127.0.0.1", wantErr: true},
+		{name: "insecure", serverName: "127.0.0.1", insecure: true},
+		{name: "pinned", serverName: "127.0.0.1", fingerprints: []string{fingerprint}},
+		{name: "pinned-with-colons", serverName: "127.0.0.1", fingerprints: []string{strings.Join(colonFingerprint, ":")}},
+		{name: "pinned-other-name", serverName: "api.bleemeo.com", fingerprints: []string{fingerprint}, wantErr: true},
+		{name: "other-fingerprint", serverName: "127.0.0.1", fingerprints: []string{strings.Repeat("00", sha256.Size)}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			tlsConfig, err := TLSConfig(c.serverName, "", c.fingerprints, c.insecure)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = get(tlsConfig, srv.URL)
+			if gotErr := err != nil; gotErr != c.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+// TestTLSConfigPinnedInChain checks that adding the pinned certificate to the chain
+// of another certificate isn't accepted.
+func TestTLSConfigPinnedInChain(t *testing.T) {
+	pinnedCert, _ := newCertificate(t, false, nil, nil)
+	attackerCert, attackerKey := newCertificate(t, false, nil, nil)
+
+	srv := newTLSServer(attackerKey, attackerCert, pinnedCert)
+	defer srv.Close()
+
+	tlsConfig, err := TLSConfig("127.0.0.1", "", []string{fingerprint(pinnedCert)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := get(tlsConfig, srv.URL); err == nil {
+		t.Error("Get() succeeded with the pinned certificate appended to another chain")
+	}
+}
+
+func TestTLSConfigPinnedCA(t *testing.T) {
+	caCert, caKey := newCertificate(t, true, nil, nil)
+	otherCA, otherKey := newCertificate(t, true, nil, nil)
+	leafCert, leafKey := newCertificate(t, false, caCert, caKey)
+
+	srv := newTLSServer(leafKey, leafCert)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "glouton-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCA.Raw})...,
+	)
+
+	if err := ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := TLSConfig("127.0.0.1", caFile, []string{fingerprint(caCert)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := get(tlsConfig, srv.URL); err != nil {
+		t.Errorf("Get() with pinned CA failed: %v", err)
+	}
+
+	// A server whose chain doesn't contain the pinned CA is refused, even if trusted.
+	otherLeaf, otherLeafKey := newCertificate(t, false, otherCA, otherKey)
+
+	otherSrv := newTLSServer(otherLeafKey, otherLeaf)
+	defer otherSrv.Close()
+
+	if err := get(tlsConfig, otherSrv.URL); err == nil {
+		t.Error("Get() succeeded with a CA which isn't pinned")
+	}
+}
+
+func TestTLSConfigInvalid(t *testing.T) {
+	if _, err := TLSConfig("127.0.0.1", "", []string{"not-hex"}, false); !errors.Is(err, errInvalidFingerprint) {
+		t.Errorf("TLSConfig() error = %v, want %v", err, errInvalidFingerprint)
+	}
+
+	if _, err := TLSConfig("127.0.0.1", "/does/not/exist.pem", nil, false); err == nil {
+		t.Error("TLSConfig() with missing CA file succeeded")
+	}
+}
+
+func TestRelayTLSConfig(t *testing.T) {
+	if _, err := RelayTLSConfig("http://relay.example.com:8016", "", nil, false); !errors.Is(err, errRelayNotHTTPS) {
+		t.Errorf("RelayTLSConfig() error = %v, want %v", err, errRelayNotHTTPS)
+	}
+
+	tlsConfig, err := RelayTLSConfig("https://relay.example.com:8016/", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tlsConfig.InsecureSkipVerify {
+		t.Error("InsecureSkipVerify is enabled")
+	}
+}
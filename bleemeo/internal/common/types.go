@@ -23,8 +23,8 @@ import (
 
 // Maximal length of fields on Bleemeo API.
 const (
-	APIMetricItemLength          int = 100
-	APIMetricItemLengthIfService int = 50
+	APIMetricItemLength          int = types.ItemMaxLength
+	APIMetricItemLengthIfService int = types.ItemMaxLengthService
 )
 
 const (
@@ -53,7 +53,14 @@ func LabelsToText(labels map[string]string, annotations types.MetricAnnotations,
 }
 
 // TruncateItem truncate the item to match maximal length allowed by Bleemeo API.
+// See types.SanitizeItem.
 func TruncateItem(item string, isService bool) string {
+	return types.SanitizeItem(item, isService)
+}
+
+// LegacyTruncateItem returns the item as truncated by older versions, which cut long
+// items without the hash suffix. It allows to find the metrics they registered.
+func LegacyTruncateItem(item string, isService bool) string {
 	if len(item) > APIMetricItemLength {
 		item = item[:APIMetricItemLength]
 	}
@@ -18,18 +18,36 @@ package common
 
 import (
 	"glouton/types"
+	"path"
 	"strings"
 )
 
 // AllowMetric return True if current configuration allow this metrics.
+// Whitelist entries could be glob patterns like "mysql_*" (see path.Match).
 func AllowMetric(labels map[string]string, annotations types.MetricAnnotations, whitelist map[string]bool) bool {
 	if len(whitelist) == 0 {
 		return true
 	}
 
-	if annotations.ServiceName != "" && strings.HasSuffix(labels[types.LabelName], "_status") {
+	name := labels[types.LabelName]
+
+	if annotations.ServiceName != "" && strings.HasSuffix(name, "_status") {
+		return true
+	}
+
+	if whitelist[name] {
 		return true
 	}
 
-	return whitelist[labels[types.LabelName]]
+	for pattern := range whitelist {
+		if !strings.ContainsAny(pattern, "*?[") {
+			continue
+		}
+
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
 }
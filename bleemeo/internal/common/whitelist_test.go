@@ -0,0 +1,56 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"glouton/types"
+	"testing"
+)
+
+func TestAllowMetric(t *testing.T) {
+	whitelist := map[string]bool{
+		"cpu_used":     true,
+		"mysql_*":      true,
+		"disk_?_bytes": true,
+	}
+
+	cases := []struct {
+		name        string
+		annotations types.MetricAnnotations
+		want        bool
+	}{
+		{name: "cpu_used", want: true},
+		{name: "cpu_system", want: false},
+		{name: "mysql_queries", want: true},
+		{name: "redis_queries", want: false},
+		{name: "disk_r_bytes", want: true},
+		{name: "disk_read_bytes", want: false},
+		{name: "redis_status", annotations: types.MetricAnnotations{ServiceName: "redis"}, want: true},
+	}
+
+	for _, c := range cases {
+		labels := map[string]string{types.LabelName: c.name}
+
+		if got := AllowMetric(labels, c.annotations, whitelist); got != c.want {
+			t.Errorf("AllowMetric(%s) = %v, want %v", c.name, got, c.want)
+		}
+
+		if !AllowMetric(labels, c.annotations, nil) {
+			t.Errorf("AllowMetric(%s) without whitelist = false, want true", c.name)
+		}
+	}
+}
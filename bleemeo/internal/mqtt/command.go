@@ -0,0 +1,268 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"glouton/audit"
+	"glouton/logger"
+	"strconv"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Remote commands sent by Bleemeo on the "command" topic of the agent.
+const (
+	commandSetLogLevel      = "set-log-level"
+	commandTriggerDiscovery = "trigger-discovery"
+	commandSendDiagnostic   = "send-diagnostic"
+	commandRunAction        = "run-action"
+)
+
+const (
+	defaultLogLevelDuration = 30 * time.Minute
+	maxLogLevelDuration     = 24 * time.Hour
+	maxDiagnosticSize       = 4 << 20
+	// maxCommandValidity is the maximum delay between the reception of a command and its
+	// expiration. The IDs of received commands are kept until they expire to refuse replays.
+	maxCommandValidity = time.Hour
+	// maxReceivedCommands limits the number of non-expired commands kept to refuse replays.
+	maxReceivedCommands = 1000
+)
+
+var (
+	errCommandExpired   = errors.New("command is expired")
+	errCommandReplayed  = errors.New("command was already received")
+	errCommandRetained  = errors.New("retained command are refused")
+	errCommandTooLong   = errors.New("command expiration is too far in the future")
+	errTooManyCommands  = errors.New("too many commands received")
+	errUnknownCommand   = errors.New("unknown command")
+	errInvalidLevel     = errors.New("invalid log level")
+	errDiagnosticTooBig = errors.New("diagnostic archive is too big")
+)
+
+type commandPayload struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	// ExpiresAt is an Unix timestamp after which the command is refused.
+	ExpiresAt int64 `json:"expires_at"`
+	// Level and Duration (in seconds) are used by set-log-level.
+	Level    string `json:"level,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+	// Action is the name of the locally defined action run by run-action.
+	Action string `json:"action,omitempty"`
+}
+
+// String returns a description of the command for logs.
+func (p commandPayload) String() string {
+	if p.Command == commandRunAction {
+		return fmt.Sprintf("%s %s (id %s)", p.Command, p.Action, p.ID)
+	}
+
+	return fmt.Sprintf("%s (id %s)", p.Command, p.ID)
+}
+
+type commandResult struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Output  string `json:"output,omitempty"`
+}
+
+// parseLogLevel accept a level name (info, verbose, debug) or a number.
+func parseLogLevel(value string) (int, error) {
+	switch strings.ToLower(value) {
+	case "info":
+		return 0, nil
+	case "verbose":
+		return 1, nil
+	case "debug":
+		return 2, nil
+	}
+
+	level, err := strconv.Atoi(value)
+	if err != nil || level < 0 || level > 3 {
+		return 0, fmt.Errorf("%w %#v", errInvalidLevel, value)
+	}
+
+	return level, nil
+}
+
+// checkCommand refuse retained, expired and already received commands.
+// Commands expiring after maxCommandValidity are refused, so the received commands are
+// forgotten after this delay, and at most maxReceivedCommands are kept.
+func (c *Client) checkCommand(payload commandPayload, retained bool, now time.Time) error {
+	if retained {
+		return errCommandRetained
+	}
+
+	expiresAt := time.Unix(payload.ExpiresAt, 0)
+	if payload.ExpiresAt == 0 || now.After(expiresAt) {
+		return errCommandExpired
+	}
+
+	if expiresAt.After(now.Add(maxCommandValidity)) {
+		return errCommandTooLong
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.receivedCommands == nil {
+		c.receivedCommands = make(map[string]time.Time)
+	}
+
+	for id, expiration := range c.receivedCommands {
+		if now.After(expiration) {
+			delete(c.receivedCommands, id)
+		}
+	}
+
+	if _, ok := c.receivedCommands[payload.ID]; ok {
+		return errCommandReplayed
+	}
+
+	// Forgetting a command would allow its replay, new commands are refused instead.
+	if len(c.receivedCommands) >= maxReceivedCommands {
+		return errTooManyCommands
+	}
+
+	c.receivedCommands[payload.ID] = expiresAt
+
+	return nil
+}
+
+func (c *Client) onCommand(_ paho.Client, msg paho.Message) {
+	if len(msg.Payload()) > 1024*60 {
+		logger.V(1).Printf("Ignoring abnormally big MQTT command")
+		return
+	}
+
+	var payload commandPayload
+
+	if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
+		logger.V(1).Printf("Failed to decode MQTT command: %v", err)
+		return
+	}
+
+	if err := c.checkCommand(payload, msg.Retained(), time.Now()); err != nil {
+		logger.Printf("Refusing remote command %s: %v", payload.Command, err)
+		c.sendCommandResult(payload, "", err)
+
+		return
+	}
+
+	logger.Printf("Running remote command %s", payload)
+	c.option.AuditLog.Record(audit.KindCommand, "Running remote command %s", payload)
+
+	// Commands may be slow, don't block the MQTT client.
+	go func() {
+		output, err := c.runCommand(payload)
+		c.sendCommandResult(payload, output, err)
+	}()
+}
+
+// runCommand runs the command and returns its output, only actions have one.
+func (c *Client) runCommand(payload commandPayload) (string, error) {
+	switch payload.Command {
+	case commandSetLogLevel:
+		level, err := parseLogLevel(payload.Level)
+		if err != nil {
+			return "", err
+		}
+
+		duration := time.Duration(payload.Duration) * time.Second
+
+		if duration <= 0 {
+			duration = defaultLogLevelDuration
+		}
+
+		if duration > maxLogLevelDuration {
+			duration = maxLogLevelDuration
+		}
+
+		logger.SetLevelFor(level, duration)
+		logger.Printf("Log level set to %d for %v by a remote command", level, duration)
+
+		return "", nil
+	case commandTriggerDiscovery:
+		if c.option.TriggerDiscovery == nil {
+			return "", fmt.Errorf("%w %s", errUnknownCommand, payload.Command)
+		}
+
+		c.option.TriggerDiscovery()
+
+		return "", nil
+	case commandSendDiagnostic:
+		if c.option.DiagnosticZip == nil {
+			return "", fmt.Errorf("%w %s", errUnknownCommand, payload.Command)
+		}
+
+		var buffer bytes.Buffer
+
+		if err := c.option.DiagnosticZip(&buffer); err != nil {
+			return "", err
+		}
+
+		if buffer.Len() > maxDiagnosticSize {
+			return "", fmt.Errorf("%w: %d bytes", errDiagnosticTooBig, buffer.Len())
+		}
+
+		c.publish(fmt.Sprintf("v1/agent/%s/diagnostic", c.option.AgentID), buffer.Bytes(), true)
+
+		return "", nil
+	case commandRunAction:
+		if c.option.RunAction == nil {
+			return "", fmt.Errorf("%w %s: no action is enabled", errUnknownCommand, payload.Command)
+		}
+
+		return c.option.RunAction(c.ctx, payload.Action)
+	default:
+		return "", fmt.Errorf("%w %#v", errUnknownCommand, payload.Command)
+	}
+}
+
+func (c *Client) sendCommandResult(payload commandPayload, output string, err error) {
+	result := commandResult{
+		ID:      payload.ID,
+		Command: payload.Command,
+		Success: err == nil,
+		Output:  output,
+	}
+
+	if err != nil {
+		result.Message = err.Error()
+		logger.V(1).Printf("Remote command %s failed: %v", payload.Command, err)
+		c.option.AuditLog.Record(audit.KindCommand, "Remote command %s failed: %v", payload, err)
+	} else {
+		c.option.AuditLog.Record(audit.KindCommand, "Remote command %s succeeded", payload)
+	}
+
+	buffer, err := json.Marshal(result)
+	if err != nil {
+		logger.V(2).Printf("Unable to encode command result: %v", err)
+		return
+	}
+
+	c.publish(fmt.Sprintf("v1/agent/%s/command_result", c.option.AgentID), buffer, true)
+}
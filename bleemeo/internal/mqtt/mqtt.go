@@ -20,19 +20,23 @@ import (
 	"archive/zip"
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"glouton/audit"
 	"glouton/bleemeo/internal/cache"
 	"glouton/bleemeo/internal/common"
 	bleemeoTypes "glouton/bleemeo/types"
+	"glouton/clock"
 	"glouton/logger"
+	"glouton/proxy"
 	"glouton/types"
-	"io/ioutil"
+	"io"
 	"math"
 	"math/rand"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -46,6 +50,15 @@ const minimalDelayBetweenConnect = 5 * time.Second
 const maximalDelayBetweenConnect = 10 * time.Minute
 const stableConnection = 5 * time.Minute
 
+// Transports used to connect to the MQTT broker. With transportAuto, the client
+// switches between TCP and WebSocket after fallbackAfterErrors failed connections.
+const (
+	transportAuto       = "auto"
+	transportTCP        = "tcp"
+	transportWebSocket  = "websocket"
+	fallbackAfterErrors = 2
+)
+
 // Option are parameter for the MQTT client.
 type Option struct {
 	bleemeoTypes.GlobalOption
@@ -61,6 +74,12 @@ type Option struct {
 	UpdateMonitor func(op string, uuid string)
 	// UpdateMaintenance requests to check for the maintenance mode again
 	UpdateMaintenance func()
+	// TriggerDiscovery requests an immediate service discovery
+	TriggerDiscovery func()
+	// DiagnosticZip writes the agent diagnostic archive
+	DiagnosticZip func(w io.Writer) error
+	// RunAction runs a locally defined action and returns its output
+	RunAction func(ctx context.Context, name string) (string, error)
 
 	InitialPoints []types.MetricPoint
 }
@@ -87,6 +106,9 @@ type Client struct {
 	disableReason     bleemeoTypes.DisableReason
 	connectionLost    chan interface{}
 	disableNotify     chan interface{}
+	receivedCommands  map[string]time.Time
+	transport         string
+	transportErrors   int
 }
 
 type message struct {
@@ -165,6 +187,8 @@ func (c *Client) Disable(until time.Time, reason bleemeoTypes.DisableReason) {
 	c.l.Lock()
 	defer c.l.Unlock()
 
+	until = clock.Monotonic(until)
+
 	if c.disabledUntil.Before(until) {
 		c.disabledUntil = until
 		c.disableReason = reason
@@ -192,6 +216,15 @@ func (c *Client) Run(ctx context.Context) error {
 	c.connectionLost = make(chan interface{})
 	c.pendingPoints = c.option.InitialPoints
 	c.option.InitialPoints = nil
+	c.transport = transportTCP
+
+	switch transport := c.option.Config.String("bleemeo.mqtt.transport"); transport {
+	case transportWebSocket:
+		c.transport = transportWebSocket
+	case transportTCP, transportAuto:
+	default:
+		logger.Printf("Unknown MQTT transport %#v, using %s", transport, transportTCP)
+	}
 	c.l.Unlock()
 
 	for !c.ready() {
@@ -211,14 +244,65 @@ func (c *Client) Run(ctx context.Context) error {
 func (c *Client) DiagnosticPage() string {
 	builder := &strings.Builder{}
 
+	if relayURL := c.option.Config.String("bleemeo.relay.url"); relayURL != "" {
+		fmt.Fprintf(builder, "MQTT messages are sent to the relay %s\n", common.RelayMQTTURL(relayURL))
+
+		return builder.String()
+	}
+
 	host := c.option.Config.String("bleemeo.mqtt.host")
 	port := c.option.Config.Int("bleemeo.mqtt.port")
+	wsPort := c.option.Config.Int("bleemeo.mqtt.websocket_port")
+	transport := c.option.Config.String("bleemeo.mqtt.transport")
+
+	fmt.Fprintf(builder, "MQTT transport is %s (configured %s)\n", c.currentTransport(), transport)
 
-	builder.WriteString(common.DiagnosticTCP(host, port, c.tlsConfig()))
+	if transport != transportWebSocket {
+		builder.WriteString(common.DiagnosticTCP(host, port, c.tlsConfig()))
+	}
+
+	if transport != transportTCP {
+		builder.WriteString(common.DiagnosticTCP(host, wsPort, c.tlsConfig()))
+	}
 
 	return builder.String()
 }
 
+// currentTransport returns the transport used for the current or next connection.
+func (c *Client) currentTransport() string {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.transport == "" {
+		return transportTCP
+	}
+
+	return c.transport
+}
+
+// connectFailed records a failed connection. In auto mode, the transport is
+// switched between TCP and WebSocket after fallbackAfterErrors failures.
+func (c *Client) connectFailed() {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.transportErrors++
+
+	if c.option.Config.String("bleemeo.mqtt.transport") != transportAuto || c.transportErrors < fallbackAfterErrors {
+		return
+	}
+
+	c.transportErrors = 0
+
+	if c.transport == transportWebSocket {
+		c.transport = transportTCP
+	} else {
+		c.transport = transportWebSocket
+	}
+
+	logger.V(1).Printf("Unable to connect to MQTT, trying the %s transport", c.transport)
+}
+
 // DiagnosticZip add to a zipfile useful diagnostic information.
 func (c *Client) DiagnosticZip(zipFile *zip.Writer) error {
 	c.l.Lock()
@@ -262,14 +346,27 @@ func (c *Client) LastReport() time.Time {
 	return c.lastReport
 }
 
+// PendingPointsCount return the number of points waiting to be sent.
+func (c *Client) PendingPointsCount() int {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return len(c.pendingPoints) + c.failedPointsCount
+}
+
 // HealthCheck perform some health check and logger any issue found.
 func (c *Client) HealthCheck() bool {
 	ok := true
 
 	if !c.Connected() {
-		logger.Printf("Bleemeo connection (MQTT) is currently not established")
+		logger.Printf("Bleemeo connection (MQTT over %s) is currently not established", c.currentTransport())
 
 		ok = false
+	} else if c.currentTransport() == transportWebSocket && c.option.Config.String("bleemeo.mqtt.transport") == transportAuto {
+		logger.V(1).Printf(
+			"Bleemeo connection (MQTT) uses the WebSocket fallback, TCP port %d may be blocked",
+			c.option.Config.Int("bleemeo.mqtt.port"),
+		)
 	}
 
 	c.l.Lock()
@@ -285,6 +382,10 @@ func (c *Client) HealthCheck() bool {
 }
 
 func (c *Client) setupMQTT() paho.Client {
+	if relayURL := c.option.Config.String("bleemeo.relay.url"); relayURL != "" {
+		return c.setupRelay(relayURL)
+	}
+
 	pahoOptions := paho.NewClientOptions()
 
 	willPayload, _ := json.Marshal(map[string]string{"disconnect-cause": "disconnect-will"})
@@ -296,16 +397,32 @@ func (c *Client) setupMQTT() paho.Client {
 		false,
 	)
 
-	brokerURL := fmt.Sprintf("%s:%d", c.option.Config.String("bleemeo.mqtt.host"), c.option.Config.Int("bleemeo.mqtt.port"))
+	host := c.option.Config.String("bleemeo.mqtt.host")
+	ssl := c.option.Config.Bool("bleemeo.mqtt.ssl")
 
-	if c.option.Config.Bool("bleemeo.mqtt.ssl") {
-		tlsConfig := c.tlsConfig()
+	var brokerURL string
 
-		pahoOptions.SetTLSConfig(tlsConfig)
+	switch {
+	case c.currentTransport() == transportWebSocket:
+		u := url.URL{
+			Scheme: "ws",
+			Host:   net.JoinHostPort(host, strconv.Itoa(c.option.Config.Int("bleemeo.mqtt.websocket_port"))),
+			Path:   c.option.Config.String("bleemeo.mqtt.websocket_path"),
+		}
 
-		brokerURL = "ssl://" + brokerURL
-	} else {
-		brokerURL = "tcp://" + brokerURL
+		if ssl {
+			u.Scheme = "wss"
+		}
+
+		brokerURL = u.String()
+	case ssl:
+		brokerURL = "ssl://" + net.JoinHostPort(host, strconv.Itoa(c.option.Config.Int("bleemeo.mqtt.port")))
+	default:
+		brokerURL = "tcp://" + net.JoinHostPort(host, strconv.Itoa(c.option.Config.Int("bleemeo.mqtt.port")))
+	}
+
+	if ssl {
+		pahoOptions.SetTLSConfig(c.tlsConfig())
 	}
 
 	pahoOptions.SetUsername(fmt.Sprintf("%s@bleemeo.com", c.option.AgentID))
@@ -314,30 +431,77 @@ func (c *Client) setupMQTT() paho.Client {
 	pahoOptions.SetAutoReconnect(false)
 	pahoOptions.SetConnectionLostHandler(c.onConnectionLost)
 	pahoOptions.SetOnConnectHandler(c.onConnect)
+	pahoOptions.SetCustomOpenConnectionFn(OpenConnection)
 
 	return paho.NewClient(pahoOptions)
 }
 
+// OpenConnection opens the connection to the broker, through the configured proxy if any.
+// It is also used by the relay to publish the messages of its child agents.
+func OpenConnection(uri *url.URL, options paho.ClientOptions) (net.Conn, error) {
+	switch uri.Scheme {
+	case "ws":
+		return paho.NewWebsocket(uri.String(), nil, options.ConnectTimeout, options.HTTPHeaders, &paho.WebsocketOptions{Proxy: proxy.FromRequest})
+	case "wss":
+		return paho.NewWebsocket(uri.String(), options.TLSConfig, options.ConnectTimeout, options.HTTPHeaders, &paho.WebsocketOptions{Proxy: proxy.FromRequest})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.ConnectTimeout)
+	defer cancel()
+
+	scheme := "http"
+	if uri.Scheme == "ssl" {
+		scheme = "https"
+	}
+
+	conn, err := proxy.DialContext(ctx, scheme, uri.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if uri.Scheme != "ssl" {
+		return conn, nil
+	}
+
+	tlsConfig := options.TLSConfig.Clone()
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{} //nolint:gosec
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = uri.Hostname()
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
 func (c *Client) tlsConfig() *tls.Config {
 	if !c.option.Config.Bool("bleemeo.mqtt.ssl") {
 		return nil
 	}
 
-	tlsConfig := &tls.Config{}
+	tlsConfig, err := common.TLSConfig(
+		c.option.Config.String("bleemeo.mqtt.host"),
+		c.option.Config.String("bleemeo.mqtt.cafile"),
+		c.option.Config.StringList("bleemeo.mqtt.fingerprints"),
+		c.option.Config.Bool("bleemeo.mqtt.ssl_insecure"),
+	)
+	if err != nil {
+		logger.Printf("Invalid TLS configuration for MQTT, using the default one: %v", err)
 
-	caFile := c.option.Config.String("bleemeo.mqtt.cafile")
-	if caFile != "" {
-		if rootCAs, err := loadRootCAs(caFile); err != nil {
-			logger.Printf("Unable to load CAs from %#v", caFile)
-		} else {
-			tlsConfig.RootCAs = rootCAs
+		return &tls.Config{
+			InsecureSkipVerify: c.option.Config.Bool("bleemeo.mqtt.ssl_insecure"), //nolint:gosec
 		}
 	}
 
-	if c.option.Config.Bool("bleemeo.mqtt.ssl_insecure") {
-		tlsConfig.InsecureSkipVerify = true
-	}
-
 	return tlsConfig
 }
 
@@ -428,7 +592,12 @@ func (c *Client) run(ctx context.Context) error {
 
 		c.sendPoints()
 
-		if !c.IsSendingSuspended() && time.Since(topinfoSendAt) >= time.Duration(cfg.LiveProcessResolution)*time.Second {
+		topinfoPeriod := cfg.LiveProcessResolution
+		if minPeriod := c.option.Config.Int("bleemeo.topinfo_min_period"); minPeriod > topinfoPeriod {
+			topinfoPeriod = minPeriod
+		}
+
+		if !c.IsSendingSuspended() && time.Since(topinfoSendAt) >= time.Duration(topinfoPeriod)*time.Second {
 			topinfoSendAt = time.Now()
 
 			c.sendTopinfo(ctx, cfg)
@@ -626,6 +795,7 @@ func (c *Client) getDisableUntil() (time.Time, bleemeoTypes.DisableReason) {
 
 func (c *Client) onConnect(mqttClient paho.Client) {
 	logger.Printf("MQTT connection established")
+	c.option.AuditLog.Record(audit.KindMQTT, "MQTT connection established")
 
 	// refresh 'info' to check the maintenance mode (for which we normally are notified by MQTT message)
 	c.option.UpdateMaintenance()
@@ -640,6 +810,14 @@ func (c *Client) onConnect(mqttClient paho.Client) {
 		0,
 		c.onNotification,
 	)
+
+	if c.option.Config.Bool("bleemeo.remote_commands.enabled") {
+		mqttClient.Subscribe(
+			fmt.Sprintf("v1/agent/%s/command", c.option.AgentID),
+			1,
+			c.onCommand,
+		)
+	}
 }
 
 func (c *Client) sendConnectMessage() {
@@ -697,6 +875,7 @@ func (c *Client) onNotification(_ paho.Client, msg paho.Message) {
 
 func (c *Client) onConnectionLost(_ paho.Client, err error) {
 	logger.Printf("MQTT connection lost: %v", err)
+	c.option.AuditLog.Record(audit.KindMQTT, "MQTT connection lost: %v", err)
 	c.connectionLost <- nil
 }
 
@@ -783,22 +962,6 @@ func (c *Client) waitPublishAndResend(mqttClient paho.Client, deadline time.Time
 	return len(c.pendingMessage)
 }
 
-func loadRootCAs(caFile string) (*x509.CertPool, error) {
-	rootCAs := x509.NewCertPool()
-
-	certs, err := ioutil.ReadFile(caFile)
-	if err != nil {
-		return nil, err
-	}
-
-	ok := rootCAs.AppendCertsFromPEM(certs)
-	if !ok {
-		return nil, errors.New("not a PEM file")
-	}
-
-	return rootCAs, nil
-}
-
 func (c *Client) filterPoints(input []types.MetricPoint) []types.MetricPoint {
 	result := make([]types.MetricPoint, 0, len(input))
 
@@ -928,8 +1091,10 @@ mainLoop:
 					// we must disconnect to stop paho gorouting that otherwise will be
 					// started multiple time for each Connect()
 					mqttClient.Disconnect(0)
+					c.connectFailed()
 				} else {
 					c.l.Lock()
+					c.transportErrors = 0
 					c.waitPublishAndResend(mqttClient, time.Now().Add(10*time.Second), true)
 					c.mqttClient = mqttClient
 					c.l.Unlock()
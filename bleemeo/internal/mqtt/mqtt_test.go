@@ -17,8 +17,15 @@
 package mqtt
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"glouton/bleemeo/types"
+	"glouton/config"
+	"io"
 	"testing"
+	"time"
 )
 
 func TestForceDecimalFloat(t *testing.T) {
@@ -52,3 +59,162 @@ func TestForceDecimalFloat(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckCommand(t *testing.T) {
+	c := &Client{}
+	now := time.Now()
+	payload := commandPayload{ID: "1", Command: commandTriggerDiscovery, ExpiresAt: now.Add(time.Minute).Unix()}
+
+	if err := c.checkCommand(payload, true, now); !errors.Is(err, errCommandRetained) {
+		t.Errorf("checkCommand(retained) = %v, want %v", err, errCommandRetained)
+	}
+
+	if err := c.checkCommand(payload, false, now.Add(2*time.Minute)); !errors.Is(err, errCommandExpired) {
+		t.Errorf("checkCommand(expired) = %v, want %v", err, errCommandExpired)
+	}
+
+	if err := c.checkCommand(payload, false, now); err != nil {
+		t.Errorf("checkCommand() = %v, want nil", err)
+	}
+
+	if err := c.checkCommand(payload, false, now); !errors.Is(err, errCommandReplayed) {
+		t.Errorf("checkCommand(replayed) = %v, want %v", err, errCommandReplayed)
+	}
+
+	payload.ID = "2"
+	payload.ExpiresAt = now.Add(2 * maxCommandValidity).Unix()
+
+	if err := c.checkCommand(payload, false, now); !errors.Is(err, errCommandTooLong) {
+		t.Errorf("checkCommand(too long) = %v, want %v", err, errCommandTooLong)
+	}
+
+	payload.ExpiresAt = now.Add(time.Minute).Unix()
+
+	for i := len(c.receivedCommands); i < maxReceivedCommands; i++ {
+		payload.ID = fmt.Sprintf("fill-%d", i)
+
+		if err := c.checkCommand(payload, false, now); err != nil {
+			t.Fatalf("checkCommand(%s) = %v, want nil", payload.ID, err)
+		}
+	}
+
+	payload.ID = "one-too-many"
+
+	if err := c.checkCommand(payload, false, now); !errors.Is(err, errTooManyCommands) {
+		t.Errorf("checkCommand(full) = %v, want %v", err, errTooManyCommands)
+	}
+
+	// Once expired, the received commands are forgotten.
+	payload.ExpiresAt = now.Add(3 * time.Minute).Unix()
+
+	if err := c.checkCommand(payload, false, now.Add(2*time.Minute)); err != nil {
+		t.Errorf("checkCommand() after expiration = %v, want nil", err)
+	}
+
+	if len(c.receivedCommands) != 1 {
+		t.Errorf("len(receivedCommands) = %d, want 1", len(c.receivedCommands))
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	triggered := false
+	c := &Client{
+		option: Option{
+			TriggerDiscovery: func() { triggered = true },
+			DiagnosticZip: func(w io.Writer) error {
+				_, err := w.Write([]byte("zip"))
+
+				return err
+			},
+		},
+	}
+
+	if _, err := c.runCommand(commandPayload{Command: commandTriggerDiscovery}); err != nil || !triggered {
+		t.Errorf("trigger-discovery: err = %v, triggered = %v", err, triggered)
+	}
+
+	if _, err := c.runCommand(commandPayload{Command: commandSendDiagnostic}); err != nil {
+		t.Errorf("send-diagnostic: err = %v", err)
+	}
+
+	if len(c.pendingMessage) != 1 || string(c.pendingMessage[0].payload) != "zip" {
+		t.Errorf("pendingMessage = %v, want the diagnostic archive", c.pendingMessage)
+	}
+
+	if _, err := c.runCommand(commandPayload{Command: commandSetLogLevel, Level: "trace"}); !errors.Is(err, errInvalidLevel) {
+		t.Errorf("set-log-level: err = %v, want %v", err, errInvalidLevel)
+	}
+
+	if _, err := c.runCommand(commandPayload{Command: "rm-rf"}); !errors.Is(err, errUnknownCommand) {
+		t.Errorf("unknown command: err = %v, want %v", err, errUnknownCommand)
+	}
+
+	if _, err := c.runCommand(commandPayload{Command: commandRunAction, Action: "restart"}); !errors.Is(err, errUnknownCommand) {
+		t.Errorf("run-action without actions: err = %v, want %v", err, errUnknownCommand)
+	}
+
+	c.option.RunAction = func(_ context.Context, name string) (string, error) {
+		return "ran " + name, nil
+	}
+
+	if output, err := c.runCommand(commandPayload{Command: commandRunAction, Action: "restart"}); err != nil || output != "ran restart" {
+		t.Errorf("run-action: output = %#v, err = %v", output, err)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	cases := map[string]int{"info": 0, "VERBOSE": 1, "debug": 2, "3": 3}
+
+	for input, want := range cases {
+		if got, err := parseLogLevel(input); err != nil || got != want {
+			t.Errorf("parseLogLevel(%#v) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+
+	if _, err := parseLogLevel("4"); err == nil {
+		t.Error("parseLogLevel(4) succeeded, want an error")
+	}
+}
+
+func TestTransportFallback(t *testing.T) {
+	cfg := &config.Configuration{}
+	cfg.Set("bleemeo.mqtt.host", "mqtt.example.com")
+	cfg.Set("bleemeo.mqtt.port", 8883)
+	cfg.Set("bleemeo.mqtt.ssl", true)
+	cfg.Set("bleemeo.mqtt.transport", transportAuto)
+	cfg.Set("bleemeo.mqtt.websocket_port", 443)
+	cfg.Set("bleemeo.mqtt.websocket_path", "/mqtt")
+
+	c := &Client{
+		option:    Option{GlobalOption: types.GlobalOption{Config: cfg}},
+		transport: transportTCP,
+	}
+
+	wantServers := []string{
+		"ssl://mqtt.example.com:8883",
+		"ssl://mqtt.example.com:8883",
+		"wss://mqtt.example.com:443/mqtt",
+		"wss://mqtt.example.com:443/mqtt",
+		"ssl://mqtt.example.com:8883",
+	}
+
+	for i, want := range wantServers {
+		optionReader := c.setupMQTT().OptionsReader()
+		servers := optionReader.Servers()
+		if len(servers) != 1 || servers[0].String() != want {
+			t.Errorf("attempt %d: servers = %v, want %s", i, servers, want)
+		}
+
+		c.connectFailed()
+	}
+
+	cfg.Set("bleemeo.mqtt.transport", transportTCP)
+
+	c.transport = transportTCP
+	c.connectFailed()
+	c.connectFailed()
+
+	if c.currentTransport() != transportTCP {
+		t.Errorf("transport = %s, want %s", c.currentTransport(), transportTCP)
+	}
+}
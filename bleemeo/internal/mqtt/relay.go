@@ -0,0 +1,409 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"glouton/bleemeo/internal/common"
+	"glouton/logger"
+	"glouton/proxy"
+	"glouton/version"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// relayBatchSize is the maximum number of messages sent to the relay in one request.
+	relayBatchSize = 100
+	// relayDefaultRetryDelay is the delay before sending again messages rejected by the
+	// relay when it doesn't give a Retry-After.
+	relayDefaultRetryDelay = 10 * time.Second
+	// relayMaxQueueSize is the maximum number of messages waiting to be sent to the relay.
+	// Above it, the messages are refused like when the MQTT connection is down.
+	relayMaxQueueSize = 1000
+)
+
+var (
+	errRelayDisconnected = errors.New("disconnected from the relay")
+	errRelayPayload      = errors.New("unsupported payload type")
+	errRelayQueueFull    = errors.New("too many messages waiting to be sent to the relay")
+)
+
+// relayClient is a paho.Client which sends the messages to a relay agent over HTTP instead of
+// publishing them on the Bleemeo MQTT. The relay publishes them with the agent credentials.
+// The notifications of Bleemeo aren't relayed, subscriptions do nothing.
+type relayClient struct {
+	url      string
+	token    string
+	batch    common.RelayBatch
+	options  *paho.ClientOptions
+	client   *http.Client
+	onLost   paho.ConnectionLostHandler
+	onAccept paho.OnConnectHandler
+	// setupErr is returned by Connect when the relay settings are invalid.
+	setupErr error
+
+	l         sync.Mutex
+	connected bool
+	queue     []*relayToken
+	wake      chan struct{}
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func (c *Client) setupRelay(relayURL string) paho.Client {
+	url := common.RelayMQTTURL(relayURL)
+
+	options := paho.NewClientOptions()
+	options.AddBroker(url)
+
+	tlsConfig, err := common.RelayTLSConfig(
+		relayURL,
+		c.option.Config.String("bleemeo.relay.cafile"),
+		c.option.Config.StringList("bleemeo.relay.fingerprints"),
+		c.option.Config.Bool("bleemeo.relay.ssl_insecure"),
+	)
+	if err != nil {
+		err = fmt.Errorf("invalid TLS configuration for the relay: %w", err)
+	}
+
+	return &relayClient{
+		url:   url,
+		token: c.option.Config.String("bleemeo.relay.token"),
+		batch: common.RelayBatch{
+			AgentID:  string(c.option.AgentID),
+			Password: c.option.AgentPassword,
+		},
+		options: options,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           proxy.FromRequest,
+				TLSClientConfig: tlsConfig,
+			},
+			Timeout: 30 * time.Second,
+		},
+		onLost:   c.onConnectionLost,
+		onAccept: c.onConnect,
+		setupErr: err,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// relayToken is the token of a message sent to the relay.
+type relayToken struct {
+	message common.RelayMessage
+	done    chan struct{}
+	err     error
+}
+
+func newRelayToken(message common.RelayMessage) *relayToken {
+	return &relayToken{
+		message: message,
+		done:    make(chan struct{}),
+	}
+}
+
+func doneRelayToken(err error) *relayToken {
+	t := newRelayToken(common.RelayMessage{})
+	t.complete(err)
+
+	return t
+}
+
+func (t *relayToken) complete(err error) {
+	t.err = err
+	close(t.done)
+}
+
+func (t *relayToken) Wait() bool {
+	<-t.done
+
+	return true
+}
+
+func (t *relayToken) WaitTimeout(timeout time.Duration) bool {
+	if timeout <= 0 {
+		select {
+		case <-t.done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (t *relayToken) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *relayToken) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+func (rc *relayClient) IsConnected() bool {
+	return rc.IsConnectionOpen()
+}
+
+func (rc *relayClient) IsConnectionOpen() bool {
+	rc.l.Lock()
+	defer rc.l.Unlock()
+
+	return rc.connected
+}
+
+// Connect checks the relay accepts the agent by sending it an empty batch.
+func (rc *relayClient) Connect() paho.Token {
+	if rc.setupErr != nil {
+		return doneRelayToken(rc.setupErr)
+	}
+
+	if _, err := rc.send(context.Background(), nil); err != nil {
+		return doneRelayToken(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	rc.l.Lock()
+	rc.connected = true
+	rc.cancel = cancel
+	rc.l.Unlock()
+
+	rc.wg.Add(1)
+
+	go func() {
+		defer rc.wg.Done()
+
+		rc.run(ctx)
+	}()
+
+	go rc.onAccept(rc)
+
+	return doneRelayToken(nil)
+}
+
+func (rc *relayClient) Disconnect(quiesce uint) {
+	rc.l.Lock()
+
+	rc.connected = false
+	cancel := rc.cancel
+	rc.cancel = nil
+
+	rc.l.Unlock()
+
+	if cancel != nil {
+		cancel()
+		rc.wg.Wait()
+	}
+
+	rc.l.Lock()
+	defer rc.l.Unlock()
+
+	for _, t := range rc.queue {
+		t.complete(errRelayDisconnected)
+	}
+
+	rc.queue = nil
+}
+
+func (rc *relayClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	var buffer []byte
+
+	switch value := payload.(type) {
+	case []byte:
+		buffer = value
+	case string:
+		buffer = []byte(value)
+	default:
+		return doneRelayToken(fmt.Errorf("%w: %T", errRelayPayload, payload))
+	}
+
+	t := newRelayToken(common.RelayMessage{Topic: topic, Payload: buffer})
+
+	rc.l.Lock()
+	defer rc.l.Unlock()
+
+	if !rc.connected {
+		t.complete(errRelayDisconnected)
+
+		return t
+	}
+
+	if len(rc.queue) >= relayMaxQueueSize {
+		t.complete(errRelayQueueFull)
+
+		return t
+	}
+
+	rc.queue = append(rc.queue, t)
+
+	select {
+	case rc.wake <- struct{}{}:
+	default:
+	}
+
+	return t
+}
+
+func (rc *relayClient) Subscribe(topic string, qos byte, callback paho.MessageHandler) paho.Token {
+	return doneRelayToken(nil)
+}
+
+func (rc *relayClient) SubscribeMultiple(filters map[string]byte, callback paho.MessageHandler) paho.Token {
+	return doneRelayToken(nil)
+}
+
+func (rc *relayClient) Unsubscribe(topics ...string) paho.Token {
+	return doneRelayToken(nil)
+}
+
+func (rc *relayClient) AddRoute(topic string, callback paho.MessageHandler) {
+}
+
+func (rc *relayClient) OptionsReader() paho.ClientOptionsReader {
+	// A paho client is only created to read the options, it never connects.
+	return paho.NewClient(rc.options).OptionsReader()
+}
+
+// run sends the queued messages to the relay. While the relay is full, the messages stay
+// queued. The connection is lost when the relay can't be reached.
+func (rc *relayClient) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		rc.l.Lock()
+
+		tokens := rc.queue
+		if len(tokens) > relayBatchSize {
+			tokens = tokens[:relayBatchSize]
+		}
+
+		rc.l.Unlock()
+
+		if len(tokens) == 0 {
+			select {
+			case <-rc.wake:
+			case <-ctx.Done():
+			}
+
+			continue
+		}
+
+		retryAfter, err := rc.send(ctx, tokens)
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil && retryAfter == 0:
+			rc.l.Lock()
+			rc.connected = false
+			rc.l.Unlock()
+
+			go rc.onLost(rc, err)
+
+			return
+		case err != nil:
+			logger.V(2).Printf("The relay rejected %d messages, retry in %v: %v", len(tokens), retryAfter, err)
+
+			select {
+			case <-time.After(retryAfter):
+			case <-ctx.Done():
+			}
+
+			continue
+		}
+
+		rc.l.Lock()
+		rc.queue = rc.queue[len(tokens):]
+		rc.l.Unlock()
+
+		for _, t := range tokens {
+			t.complete(nil)
+		}
+	}
+}
+
+// send posts the messages of the tokens to the relay. When the relay is full, it returns
+// the delay after which the messages could be sent again.
+func (rc *relayClient) send(ctx context.Context, tokens []*relayToken) (retryAfter time.Duration, err error) {
+	batch := rc.batch
+	batch.Messages = make([]common.RelayMessage, 0, len(tokens))
+
+	for _, t := range tokens {
+		batch.Messages = append(batch.Messages, t.message)
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rc.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	req.Header.Set(common.RelayTokenHeader, rc.token)
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return 0, nil
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		retryAfter = relayDefaultRetryDelay
+
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+
+		return retryAfter, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(content))
+	default:
+		return 0, fmt.Errorf("%s returned %s: %s", rc.url, resp.Status, bytes.TrimSpace(content))
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"glouton/bleemeo/internal/common"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestRelayClient(t *testing.T) {
+	var (
+		l        sync.Mutex
+		requests int
+		received []common.RelayMessage
+	)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(common.RelayTokenHeader) != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		var batch common.RelayBatch
+
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil || batch.AgentID != "agent-1" {
+			http.Error(w, "invalid batch", http.StatusBadRequest)
+
+			return
+		}
+
+		l.Lock()
+		defer l.Unlock()
+
+		requests++
+
+		// The relay is full on the first batch with messages.
+		if len(batch.Messages) > 0 && requests == 2 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many pending messages", http.StatusTooManyRequests)
+
+			return
+		}
+
+		received = append(received, batch.Messages...)
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	connected := make(chan struct{})
+
+	rc := &relayClient{
+		url:      common.RelayMQTTURL(server.URL),
+		token:    "secret",
+		batch:    common.RelayBatch{AgentID: "agent-1", Password: "password"},
+		options:  paho.NewClientOptions(),
+		client:   server.Client(),
+		onLost:   func(paho.Client, error) { t.Error("connection lost") },
+		onAccept: func(paho.Client) { close(connected) },
+		wake:     make(chan struct{}, 1),
+	}
+
+	if token := rc.Connect(); token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	defer rc.Disconnect(0)
+
+	<-connected
+
+	if !rc.IsConnectionOpen() {
+		t.Fatal("relay client isn't connected")
+	}
+
+	token := rc.Publish("v1/agent/agent-1/data", 1, false, []byte("points"))
+
+	if !token.WaitTimeout(10 * time.Second) {
+		t.Fatal("message wasn't sent")
+	}
+
+	if token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if len(received) != 1 || received[0].Topic != "v1/agent/agent-1/data" || string(received[0].Payload) != "points" {
+		t.Errorf("received = %v, want the published message", received)
+	}
+}
+
+func TestRelayClientUnauthorized(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	rc := &relayClient{
+		url:     common.RelayMQTTURL(server.URL),
+		options: paho.NewClientOptions(),
+		client:  server.Client(),
+		wake:    make(chan struct{}, 1),
+	}
+
+	if token := rc.Connect(); token.Error() == nil {
+		t.Error("Connect() succeeded with an invalid token")
+	}
+
+	if rc.IsConnectionOpen() {
+		t.Error("relay client is connected with an invalid token")
+	}
+
+	if token := rc.Publish("v1/agent/agent-1/data", 1, false, []byte("points")); token.Error() == nil {
+		t.Error("Publish() succeeded while disconnected")
+	}
+}
+
+func TestRelayClientQueueFull(t *testing.T) {
+	rc := &relayClient{
+		options:   paho.NewClientOptions(),
+		connected: true,
+		wake:      make(chan struct{}, 1),
+	}
+
+	for i := 0; i < relayMaxQueueSize; i++ {
+		if token := rc.Publish("v1/agent/agent-1/data", 1, false, []byte("points")); token.Error() != nil {
+			t.Fatal(token.Error())
+		}
+	}
+
+	token := rc.Publish("v1/agent/agent-1/data", 1, false, []byte("points"))
+	if !errors.Is(token.Error(), errRelayQueueFull) {
+		t.Errorf("Publish() on a full queue = %v, want %v", token.Error(), errRelayQueueFull)
+	}
+
+	if len(rc.queue) != relayMaxQueueSize {
+		t.Errorf("queue size = %d, want %d", len(rc.queue), relayMaxQueueSize)
+	}
+}
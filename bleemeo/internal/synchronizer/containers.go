@@ -19,6 +19,7 @@ package synchronizer
 import (
 	"encoding/json"
 	"fmt"
+	"glouton/bleemeo/client"
 	"glouton/bleemeo/types"
 	"glouton/facts"
 	"glouton/logger"
@@ -27,6 +28,10 @@ import (
 
 const apiContainerNameLength = 100
 
+// containerMaxErrors is the number of successive failures after which a container is only
+// synchronized on full synchronizations.
+const containerMaxErrors = 3
+
 type containerPayload struct {
 	types.Container
 	Host             string    `json:"host"`
@@ -66,15 +71,17 @@ func (s *Synchronizer) syncContainers(fullSync bool) error {
 	}
 
 	// s.containerDeleteFromRemote(): API don't delete containers
-	if err := s.containerRegisterAndUpdate(localContainers); err != nil {
-		return err
+	// Containers that disappeared are deleted even if some containers failed to synchronize.
+	registerErr := s.containerRegisterAndUpdate(localContainers, fullSync)
+	if client.IsServerError(registerErr) {
+		return registerErr
 	}
 
 	if err := s.containerDeleteFromLocal(localContainers); err != nil {
 		return err
 	}
 
-	return nil
+	return registerErr
 }
 
 func (s *Synchronizer) containerUpdateList() error {
@@ -107,7 +114,10 @@ func (s *Synchronizer) containerUpdateList() error {
 	return nil
 }
 
-func (s *Synchronizer) containerRegisterAndUpdate(localContainers []facts.Container) error {
+// containerRegisterAndUpdate registers new containers and updates the containers whose inspect hash changed.
+// A container failing to synchronize doesn't block the others. After containerMaxErrors successive
+// failures, it's only retried on full synchronizations.
+func (s *Synchronizer) containerRegisterAndUpdate(localContainers []facts.Container, fullSync bool) error {
 	facts, err := s.option.Facts.Facts(s.ctx, 24*time.Hour)
 	if err != nil {
 		return nil
@@ -124,7 +134,17 @@ func (s *Synchronizer) containerRegisterAndUpdate(localContainers []facts.Contai
 		"fields": "id,name,docker_id,docker_inspect,host,command,docker_status,docker_created_at,docker_started_at,docker_finished_at,docker_api_version,docker_image_id,docker_image_name",
 	}
 
+	var lastErr error
+
+	seenIDs := make(map[string]bool, len(localContainers))
+
 	for _, container := range localContainers {
+		seenIDs[container.ID()] = true
+
+		if !fullSync && s.containerSyncErrors[container.ID()] >= containerMaxErrors {
+			continue
+		}
+
 		name := container.Name()
 		if len(name) > apiContainerNameLength {
 			name = name[:apiContainerNameLength]
@@ -150,6 +170,7 @@ func (s *Synchronizer) containerRegisterAndUpdate(localContainers []facts.Contai
 			continue
 		}
 
+		inspectHash := payloadContainer.DockerInspectHash
 		payloadContainer.DockerInspectHash = "" // we don't send inspect hash to API
 		payload := containerPayload{
 			Container:        payloadContainer,
@@ -167,27 +188,54 @@ func (s *Synchronizer) containerRegisterAndUpdate(localContainers []facts.Contai
 		var result types.Container
 
 		if remoteFound {
-			_, err := s.client.Do("PUT", fmt.Sprintf("v1/container/%s/", remoteContainer.ID), params, payload, &result)
-			if err != nil {
+			_, err = s.client.Do("PUT", fmt.Sprintf("v1/container/%s/", remoteContainer.ID), params, payload, &result)
+		} else {
+			_, err = s.client.Do("POST", "v1/container/", params, payload, &result)
+		}
+
+		if err != nil {
+			if client.IsServerError(err) {
+				s.option.Cache.SetContainers(remoteContainers)
+
 				return err
 			}
 
+			s.containerSyncErrors[container.ID()]++
+			logger.V(1).Printf(
+				"Unable to synchronize container %v (%d successive failures): %v",
+				name, s.containerSyncErrors[container.ID()], err,
+			)
+
+			lastErr = err
+
+			continue
+		}
+
+		delete(s.containerSyncErrors, container.ID())
+
+		// Like in containerUpdateList, only the hash of the inspect is kept. The hash is the one of
+		// the inspect sent, so the container isn't sent again until its inspect changes.
+		result.DockerInspectHash = inspectHash
+		result.DockerInspect = ""
+
+		if remoteFound {
 			logger.V(2).Printf("Container %v updated with UUID %s", result.Name, result.ID)
 			remoteContainers[remoteIndex] = result
 		} else {
-			_, err := s.client.Do("POST", "v1/container/", params, payload, &result)
-			if err != nil {
-				return err
-			}
-
 			logger.V(2).Printf("Container %v registered with UUID %s", result.Name, result.ID)
 			remoteContainers = append(remoteContainers, result)
 		}
 	}
 
+	for id := range s.containerSyncErrors {
+		if !seenIDs[id] {
+			delete(s.containerSyncErrors, id)
+		}
+	}
+
 	s.option.Cache.SetContainers(remoteContainers)
 
-	return nil
+	return lastErr
 }
 
 func (s *Synchronizer) containerDeleteFromLocal(localContainers []facts.Container) error {
@@ -27,6 +27,9 @@ import (
 	"glouton/threshold"
 	"glouton/types"
 	"math/rand"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -34,6 +37,18 @@ var (
 	errRetryLater = errors.New("metric registration should be retried laster")
 )
 
+const (
+	// metricBatchSize is the maximum number of metrics registered in one request.
+	metricBatchSize = 100
+	// metricBatchConcurrency is the maximum number of registration requests running concurrently.
+	metricBatchConcurrency = 4
+	// metricThrottleRetries is the number of times a registration throttled by the API is retried.
+	metricThrottleRetries = 5
+	// metricCheckpointSize is the number of registrations after which the cache is saved, so a
+	// restart doesn't register again the metrics already registered.
+	metricCheckpointSize = 1000
+)
+
 type errNeedRegister struct {
 	remoteMetric bleemeoTypes.Metric
 	key          string
@@ -88,6 +103,12 @@ func (m fakeMetric) Labels() map[string]string {
 	}
 }
 
+// metricRegistration is a metric waiting to be registered by a bulk request.
+type metricRegistration struct {
+	key     string
+	payload metricPayload
+}
+
 type metricPayload struct {
 	bleemeoTypes.Metric
 	Name  string `json:"label,omitempty"`
@@ -111,27 +132,62 @@ func (mp metricPayload) metricFromAPI() bleemeoTypes.Metric {
 	return mp.Metric
 }
 
+// metricPriority returns the registration priority of a metric, lower is registered first.
+// When the metrics quota of the account is reached, metrics with the lowest priority are the
+// ones left unregistered.
+func metricPriority(m types.Metric) int {
+	labels := m.Labels()
+	annotations := m.Annotations()
+
+	switch labels[types.LabelName] {
+	case "cpu_idle", "cpu_wait", "cpu_nice", "cpu_user", "cpu_system", "cpu_interrupt", "cpu_softirq", "cpu_steal",
+		"mem_free", "mem_cached", "mem_buffered", "mem_used",
+		"io_utilization", "io_read_bytes", "io_write_bytes", "io_reads",
+		"io_writes", "net_bits_recv", "net_bits_sent", "net_packets_recv",
+		"net_packets_sent", "net_err_in", "net_err_out", "disk_used_perc",
+		"swap_used_perc", "cpu_used", "mem_used_perc",
+		"agent_status", "metrics_not_registered":
+		return 0
+	}
+
+	switch {
+	case annotations.StatusOf != "" || strings.HasSuffix(labels[types.LabelName], "_status"):
+		return 1
+	case annotations.ContainerID != "" || annotations.BleemeoAgentID != "":
+		// Containers and monitors may be numerous, their metrics are registered last.
+		return 3
+	default:
+		return 2
+	}
+}
+
+// prioritizeMetrics sorts the metrics by priority: system metrics first, then status metrics,
+// other metrics and finally the high-cardinality ones. The order within a priority is kept.
 func prioritizeMetrics(metrics []types.Metric) {
-	swapIdx := 0
+	type prioritized struct {
+		metric   types.Metric
+		priority int
+	}
+
+	list := make([]prioritized, len(metrics))
 
 	for i, m := range metrics {
-		switch m.Labels()[types.LabelName] {
-		case "cpu_idle", "cpu_wait", "cpu_nice", "cpu_user", "cpu_system", "cpu_interrupt", "cpu_softirq", "cpu_steal",
-			"mem_free", "mem_cached", "mem_buffered", "mem_used",
-			"io_utilization", "io_read_bytes", "io_write_bytes", "io_reads",
-			"io_writes", "net_bits_recv", "net_bits_sent", "net_packets_recv",
-			"net_packets_sent", "net_err_in", "net_err_out", "disk_used_perc",
-			"swap_used_perc", "cpu_used", "mem_used_perc",
-			"agent_status":
-			metrics[i], metrics[swapIdx] = metrics[swapIdx], metrics[i]
-			swapIdx++
-		}
+		list[i] = prioritized{metric: m, priority: metricPriority(m)}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].priority < list[j].priority
+	})
+
+	for i, p := range list {
+		metrics[i] = p.metric
 	}
 }
 
 // nearly a duplicate of mqtt.filterPoint, but not quite. Alas we cannot easily generalize this as go doesn't have generics (yet).
 func (s *Synchronizer) filterMetrics(input []types.Metric) []types.Metric {
 	result := make([]types.Metric, 0)
+	denied := 0
 
 	currentAccountConfig := s.option.Cache.CurrentAccountConfig()
 	accountConfigs := s.option.Cache.AccountConfigs()
@@ -159,12 +215,27 @@ func (s *Synchronizer) filterMetrics(input []types.Metric) []types.Metric {
 
 		if common.AllowMetric(m.Labels(), m.Annotations(), whitelist) {
 			result = append(result, m)
+		} else {
+			denied++
 		}
 	}
 
+	s.l.Lock()
+	s.metricsDenied = denied
+	s.l.Unlock()
+
 	return result
 }
 
+// MetricsDenied returns the number of metrics neither registered nor sent because they are
+// not in the metrics whitelist of the account.
+func (s *Synchronizer) MetricsDenied() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.metricsDenied
+}
+
 func (s *Synchronizer) findUnregisteredMetrics(metrics []types.Metric) []types.Metric {
 	registeredMetricsByKey := s.option.Cache.MetricLookupFromList()
 
@@ -529,7 +600,45 @@ func (s *Synchronizer) metricRegisterAndUpdate(localMetrics []types.Metric, full
 	regCountBeforeUpdate := 30
 	errorCount := 0
 
-	var lastErr error
+	// Once the metrics quota is reached, no new metrics are registered, they are only counted.
+	var (
+		lastErr             error
+		quotaErr            error
+		notRegistered       int
+		registeredSinceSave int
+	)
+
+	registerBulk := func(pendingRegistrations []metricRegistration) error {
+		if quotaErr != nil {
+			notRegistered += len(pendingRegistrations)
+
+			return nil
+		}
+
+		err := s.metricRegisterBulk(pendingRegistrations, registeredMetricsByUUID, registeredMetricsByKey, params)
+
+		registeredSinceSave += len(pendingRegistrations)
+		if registeredSinceSave >= metricCheckpointSize {
+			registeredSinceSave = 0
+
+			s.metricCheckpoint(registeredMetricsByUUID)
+		}
+
+		if !client.IsQuotaExceeded(err) {
+			return err
+		}
+
+		// The quota isn't a synchronization failure, other objects could still be synchronized.
+		quotaErr = err
+
+		for _, r := range pendingRegistrations {
+			if _, ok := registeredMetricsByKey[r.key]; !ok {
+				notRegistered++
+			}
+		}
+
+		return nil
+	}
 
 	retryMetrics := make([]types.Metric, 0)
 	registerMetrics := make([]types.Metric, 0)
@@ -569,13 +678,27 @@ func (s *Synchronizer) metricRegisterAndUpdate(localMetrics []types.Metric, full
 
 		logger.V(3).Printf("Metric registration phase %v start with %d metrics to process", state, len(currentList))
 
+		pendingRegistrations := make([]metricRegistration, 0)
+
 	metricLoop:
 		for _, metric := range currentList {
 			if s.ctx.Err() != nil {
 				break
 			}
 
-			err := s.metricRegisterAndUpdateOne(metric, registeredMetricsByUUID, registeredMetricsByKey, containersByContainerID, servicesByKey, params, monitors)
+			if len(pendingRegistrations) >= metricBatchSize*metricBatchConcurrency {
+				if err := registerBulk(pendingRegistrations); err != nil {
+					if client.IsServerError(err) {
+						return err
+					}
+
+					lastErr = err
+				}
+
+				pendingRegistrations = pendingRegistrations[:0]
+			}
+
+			registration, err := s.metricRegisterAndUpdateOne(metric, registeredMetricsByUUID, registeredMetricsByKey, containersByContainerID, servicesByKey, monitors)
 			if err != nil && err == errRetryLater && state < metricPassRetry {
 				retryMetrics = append(retryMetrics, metric)
 				continue metricLoop
@@ -607,6 +730,10 @@ func (s *Synchronizer) metricRegisterAndUpdate(localMetrics []types.Metric, full
 				continue metricLoop
 			}
 
+			if registration != nil {
+				pendingRegistrations = append(pendingRegistrations, *registration)
+			}
+
 			regCountBeforeUpdate--
 			if regCountBeforeUpdate == 0 {
 				regCountBeforeUpdate = 60
@@ -620,6 +747,16 @@ func (s *Synchronizer) metricRegisterAndUpdate(localMetrics []types.Metric, full
 				s.option.Cache.SetMetrics(metrics)
 			}
 		}
+
+		if len(pendingRegistrations) > 0 && s.ctx.Err() == nil {
+			if err := registerBulk(pendingRegistrations); err != nil {
+				if client.IsServerError(err) {
+					return err
+				}
+
+				lastErr = err
+			}
+		}
 	}
 
 	metrics := make([]bleemeoTypes.Metric, 0, len(registeredMetricsByUUID))
@@ -630,27 +767,79 @@ func (s *Synchronizer) metricRegisterAndUpdate(localMetrics []types.Metric, full
 
 	s.option.Cache.SetMetrics(metrics)
 
+	if s.ctx.Err() == nil {
+		s.setMetricsQuotaStatus(notRegistered, quotaErr)
+	}
+
 	return lastErr
 }
 
+// metricCheckpoint saves the metrics registered so far in the persistent cache.
+func (s *Synchronizer) metricCheckpoint(registeredMetricsByUUID map[string]bleemeoTypes.Metric) {
+	metrics := make([]bleemeoTypes.Metric, 0, len(registeredMetricsByUUID))
+
+	for _, v := range registeredMetricsByUUID {
+		metrics = append(metrics, v)
+	}
+
+	s.option.Cache.SetMetrics(metrics)
+	s.option.Cache.Save()
+
+	logger.V(2).Printf("Metric registration in progress, %d metrics saved in the cache", len(metrics))
+}
+
+// setMetricsQuotaStatus records the number of metrics not registered due to the metrics quota.
+func (s *Synchronizer) setMetricsQuotaStatus(notRegistered int, quotaErr error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	switch {
+	case quotaErr != nil && s.metricsQuotaErr == nil:
+		logger.Printf(
+			"The metrics quota of the account is reached, %d metrics are not registered. System and status metrics are registered first (%v)",
+			notRegistered, quotaErr,
+		)
+	case quotaErr != nil:
+		logger.V(1).Printf("The metrics quota of the account is still reached, %d metrics are not registered", notRegistered)
+	case s.metricsQuotaErr != nil:
+		logger.Printf("The metrics quota of the account is no longer reached, all metrics are registered")
+	}
+
+	s.metricsNotRegistered = notRegistered
+	s.metricsQuotaErr = quotaErr
+}
+
+// MetricsQuotaStatus returns the number of metrics not registered because the metrics quota of the
+// account is reached and the error returned by the API, nil if the quota isn't reached.
+func (s *Synchronizer) MetricsQuotaStatus() (notRegistered int, quotaErr error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.metricsNotRegistered, s.metricsQuotaErr
+}
+
 func (s *Synchronizer) metricRegisterAndUpdateOne(metric types.Metric, registeredMetricsByUUID map[string]bleemeoTypes.Metric,
 	registeredMetricsByKey map[string]bleemeoTypes.Metric, containersByContainerID map[string]bleemeoTypes.Container,
-	servicesByKey map[serviceNameInstance]bleemeoTypes.Service, params map[string]string, monitors []bleemeoTypes.Monitor) error {
+	servicesByKey map[serviceNameInstance]bleemeoTypes.Service, monitors []bleemeoTypes.Monitor) (*metricRegistration, error) {
 	labels := metric.Labels()
 	annotations := metric.Annotations()
 	key := common.LabelsToText(labels, annotations, s.option.MetricFormat == types.MetricFormatBleemeo)
 	remoteMetric, remoteFound := registeredMetricsByKey[key]
 
+	if !remoteFound && s.option.MetricFormat == types.MetricFormatBleemeo {
+		remoteMetric, remoteFound = s.metricMigrateItem(key, labels, annotations, registeredMetricsByKey)
+	}
+
 	if remoteFound {
 		result, err := s.metricUpdateOne(key, metric, remoteMetric)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		registeredMetricsByKey[key] = result
 		registeredMetricsByUUID[result.ID] = result
 
-		return nil
+		return nil, nil
 	}
 
 	payload := metricPayload{
@@ -666,10 +855,7 @@ func (s *Synchronizer) metricRegisterAndUpdateOne(metric types.Metric, registere
 		payload.LabelsText = ""
 	}
 
-	var (
-		containerName string
-		result        metricPayload
-	)
+	var containerName string
 
 	if annotations.StatusOf != "" {
 		subLabels := make(map[string]string, len(labels))
@@ -684,7 +870,7 @@ func (s *Synchronizer) metricRegisterAndUpdateOne(metric types.Metric, registere
 		metricStatusOf, ok := registeredMetricsByKey[subKey]
 
 		if !ok {
-			return errRetryLater
+			return nil, errRetryLater
 		}
 
 		payload.StatusOf = metricStatusOf.ID
@@ -694,7 +880,7 @@ func (s *Synchronizer) metricRegisterAndUpdateOne(metric types.Metric, registere
 		container, ok := containersByContainerID[annotations.ContainerID]
 		if !ok {
 			// No error. When container get registered we trigger a metric synchronization
-			return nil
+			return nil, nil
 		}
 
 		containerName = container.Name
@@ -711,7 +897,7 @@ func (s *Synchronizer) metricRegisterAndUpdateOne(metric types.Metric, registere
 		service, ok := servicesByKey[srvKey]
 		if !ok {
 			// No error. When service get registered we trigger a metric synchronization
-			return nil
+			return nil, nil
 		}
 
 		payload.ServiceID = service.ID
@@ -722,7 +908,7 @@ func (s *Synchronizer) metricRegisterAndUpdateOne(metric types.Metric, registere
 		found := false
 
 		if metric.Labels()[types.LabelScraperUUID] != s.agentID {
-			return fmt.Errorf("attempt to spoof another agent (or missing label): %s, want %s", metric.Labels()[types.LabelScraperUUID], s.agentID)
+			return nil, fmt.Errorf("attempt to spoof another agent (or missing label): %s, want %s", metric.Labels()[types.LabelScraperUUID], s.agentID)
 		}
 
 		for _, monitor := range monitors {
@@ -740,20 +926,192 @@ func (s *Synchronizer) metricRegisterAndUpdateOne(metric types.Metric, registere
 			// never be registred, or this is from a monitor that is not yet loaded, and it is
 			// not an issue per se as it will get registered when monitors are loaded, as it will
 			// trigger a metric synchronization.
-			return nil
+			return nil, nil
+		}
+	}
+
+	return &metricRegistration{key: key, payload: payload}, nil
+}
+
+// metricRegisterBulk register metrics using bulk requests of up to metricBatchSize metrics.
+// At most metricBatchConcurrency requests are running at the same time.
+func (s *Synchronizer) metricRegisterBulk(registrations []metricRegistration, registeredMetricsByUUID map[string]bleemeoTypes.Metric,
+	registeredMetricsByKey map[string]bleemeoTypes.Metric, params map[string]string) error {
+	batchCount := (len(registrations) + metricBatchSize - 1) / metricBatchSize
+	results := make([][]metricPayload, batchCount)
+	errs := make([]error, batchCount)
+	semaphore := make(chan struct{}, metricBatchConcurrency)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < batchCount; i++ {
+		end := (i + 1) * metricBatchSize
+		if end > len(registrations) {
+			end = len(registrations)
+		}
+
+		wg.Add(1)
+
+		semaphore <- struct{}{}
+
+		go func(i int, batch []metricRegistration) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			results[i], errs[i] = s.metricRegisterBatch(batch, params)
+		}(i, registrations[i*metricBatchSize:end])
+	}
+
+	wg.Wait()
+
+	var lastErr error
+
+	for i, batchResult := range results {
+		for j, result := range batchResult {
+			if result.ID == "" {
+				continue
+			}
+
+			key := registrations[i*metricBatchSize+j].key
+
+			logger.V(2).Printf("Metric %v registered with UUID %s", key, result.ID)
+			registeredMetricsByKey[key] = result.metricFromAPI()
+			registeredMetricsByUUID[result.ID] = result.metricFromAPI()
+		}
+
+		if errs[i] != nil {
+			lastErr = errs[i]
+		}
+	}
+
+	return lastErr
+}
+
+// metricRegisterBatch register one batch of metrics. Results are in the same order as the batch.
+//
+// When the bulk request fails for any reason (rejected metric, server or network error, API
+// without the bulk endpoint), metrics of this batch are registered one by one, so a single
+// invalid metric doesn't prevent other metrics from being registered.
+// Metrics that failed to register have an empty ID.
+func (s *Synchronizer) metricRegisterBatch(batch []metricRegistration, params map[string]string) ([]metricPayload, error) {
+	if len(batch) > 1 {
+		payloads := make([]metricPayload, len(batch))
+
+		for i, r := range batch {
+			payloads[i] = r.payload
+		}
+
+		var result []metricPayload
+
+		err := s.metricPost(params, payloads, &result)
+		if err == nil && len(result) == len(batch) {
+			return result, nil
+		}
+
+		if err == nil {
+			err = fmt.Errorf("bulk registration returned %d metrics, want %d", len(result), len(batch))
+		}
+
+		if s.ctx.Err() != nil {
+			return nil, err
 		}
+
+		logger.V(2).Printf("Bulk registration of %d metrics failed, registering them one by one: %v", len(batch), err)
 	}
 
-	_, err := s.client.Do("POST", "v1/metric/", params, payload, &result)
+	var lastErr error
+
+	result := make([]metricPayload, len(batch))
+
+	for i, r := range batch {
+		if s.ctx.Err() != nil {
+			break
+		}
+
+		err := s.metricPost(params, r.payload, &result[i])
+		if err != nil {
+			if client.IsServerError(err) || client.IsQuotaExceeded(err) || client.IsThrottleError(err) {
+				return result, err
+			}
+
+			logger.V(2).Printf("Unable to register metric %v: %v", r.key, err)
+
+			lastErr = err
+		}
+	}
+
+	return result, lastErr
+}
+
+// metricPost registers metrics. Requests are spaced by the metric limiter and requests throttled
+// by the API are retried.
+func (s *Synchronizer) metricPost(params map[string]string, data interface{}, result interface{}) error {
+	for attempt := 0; ; attempt++ {
+		if err := s.metricLimiter.Wait(s.ctx); err != nil {
+			return err
+		}
+
+		_, err := s.client.Do("POST", "v1/metric/", params, data, result)
+		if !client.IsThrottleError(err) {
+			if err == nil {
+				s.metricLimiter.Succeeded()
+			}
+
+			return err
+		}
+
+		s.metricLimiter.Throttled(client.ThrottleDelay(err))
+
+		if attempt >= metricThrottleRetries {
+			return err
+		}
+
+		logger.V(2).Printf("Metric registration throttled by the API, retrying in %v", s.metricLimiter.Delay())
+	}
+}
+
+// metricMigrateItem updates the item of a metric registered by an older version, which
+// truncated long items differently. It returns the migrated metric, or false when there
+// is no such metric or the migration failed, in which case the metric is registered again.
+func (s *Synchronizer) metricMigrateItem(key string, labels map[string]string, annotations types.MetricAnnotations,
+	registeredMetricsByKey map[string]bleemeoTypes.Metric) (bleemeoTypes.Metric, bool) {
+	isService := annotations.ServiceName != ""
+	legacyAnnotations := annotations
+	legacyAnnotations.BleemeoItem = common.LegacyTruncateItem(annotations.BleemeoItem, isService)
+
+	legacyKey := common.LabelsToText(labels, legacyAnnotations, true)
+	if legacyKey == key {
+		return bleemeoTypes.Metric{}, false
+	}
+
+	remoteMetric, ok := registeredMetricsByKey[legacyKey]
+	if !ok {
+		return bleemeoTypes.Metric{}, false
+	}
+
+	item := common.TruncateItem(annotations.BleemeoItem, isService)
+
+	_, err := s.client.Do(
+		"PATCH",
+		fmt.Sprintf("v1/metric/%s/", remoteMetric.ID),
+		map[string]string{"fields": "item"},
+		map[string]string{"item": item},
+		nil,
+	)
 	if err != nil {
-		return err
+		logger.V(1).Printf("Unable to migrate the item of the metric %v (uuid %s): %v", legacyKey, remoteMetric.ID, err)
+
+		return bleemeoTypes.Metric{}, false
 	}
 
-	logger.V(2).Printf("Metric %v registered with UUID %s", key, result.ID)
-	registeredMetricsByKey[key] = result.metricFromAPI()
-	registeredMetricsByUUID[result.ID] = result.metricFromAPI()
+	logger.V(2).Printf("Migrated the item of the metric %v (uuid %s) to %v", legacyKey, remoteMetric.ID, key)
 
-	return nil
+	delete(registeredMetricsByKey, legacyKey)
+
+	remoteMetric.Item = item
+	remoteMetric.LabelsText = key
+
+	return remoteMetric, true
 }
 
 func (s *Synchronizer) metricUpdateOne(key string, metric types.Metric, remoteMetric bleemeoTypes.Metric) (bleemeoTypes.Metric, error) {
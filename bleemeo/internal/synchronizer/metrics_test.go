@@ -17,21 +17,32 @@
 package synchronizer
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"glouton/bleemeo/client"
+	"glouton/bleemeo/internal/common"
+	bleemeoTypes "glouton/bleemeo/types"
 	"glouton/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 type mockMetric struct {
-	Name string
+	Name        string
+	annotations types.MetricAnnotations
 }
 
 func (m mockMetric) Labels() map[string]string {
 	return map[string]string{types.LabelName: m.Name}
 }
 func (m mockMetric) Annotations() types.MetricAnnotations {
-	return types.MetricAnnotations{}
+	return m.annotations
 }
 func (m mockMetric) Points(start, end time.Time) ([]types.Point, error) {
 	return nil, errors.New("not implemented")
@@ -75,3 +86,502 @@ func TestPrioritizeMetrics(t *testing.T) {
 		}
 	}
 }
+
+func TestPrioritizeMetricsOrder(t *testing.T) {
+	metrics := []types.Metric{
+		mockMetric{Name: "redis_keys", annotations: types.MetricAnnotations{ContainerID: "1234"}},
+		mockMetric{Name: "nginx_requests"},
+		mockMetric{Name: "nginx_status", annotations: types.MetricAnnotations{StatusOf: "nginx_requests"}},
+		mockMetric{Name: "apache_requests"},
+		mockMetric{Name: "agent_status"},
+	}
+	want := []string{"agent_status", "nginx_status", "nginx_requests", "apache_requests", "redis_keys"}
+
+	prioritizeMetrics(metrics)
+
+	for i, m := range metrics {
+		if got := m.Labels()[types.LabelName]; got != want[i] {
+			t.Errorf("metrics[%d] = %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+// TestMetricRegisterBulk check that metrics are registered in batch and that a
+// rejected batch is retried metric by metric.
+func TestMetricRegisterBulk(t *testing.T) {
+	var (
+		l             sync.Mutex
+		bulkRequests  int
+		singleRequest int
+		created       int
+	)
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/v1/jwt-auth/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, jwtToken)
+	})
+	serveMux.HandleFunc("/v1/metric/", func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		l.Lock()
+		defer l.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		var payloads []metricPayload
+
+		if err := json.Unmarshal(raw, &payloads); err == nil {
+			bulkRequests++
+
+			for i, p := range payloads {
+				if p.Name == "invalid" {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `["invalid metric"]`)
+
+					return
+				}
+
+				created++
+				payloads[i].ID = fmt.Sprintf("id-%d", created)
+			}
+
+			_ = json.NewEncoder(w).Encode(payloads)
+
+			return
+		}
+
+		var payload metricPayload
+
+		_ = json.Unmarshal(raw, &payload)
+
+		singleRequest++
+
+		if payload.Name == "invalid" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `["invalid metric"]`)
+
+			return
+		}
+
+		created++
+		payload.ID = fmt.Sprintf("id-%d", created)
+
+		_ = json.NewEncoder(w).Encode(payload)
+	})
+
+	httpServer := httptest.NewServer(serveMux)
+	defer httpServer.Close()
+
+	cl, err := client.NewClient(context.Background(), httpServer.URL, "user", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Synchronizer{ctx: context.Background(), client: cl}
+
+	registrations := make([]metricRegistration, 0, metricBatchSize+10)
+
+	for i := 0; i < metricBatchSize+10; i++ {
+		name := fmt.Sprintf("metric_%d", i)
+		if i == metricBatchSize+5 {
+			name = "invalid"
+		}
+
+		registrations = append(registrations, metricRegistration{
+			key:     name,
+			payload: metricPayload{Name: name, Metric: bleemeoTypes.Metric{LabelsText: name}},
+		})
+	}
+
+	byUUID := make(map[string]bleemeoTypes.Metric)
+	byKey := make(map[string]bleemeoTypes.Metric)
+
+	err = s.metricRegisterBulk(registrations, byUUID, byKey, nil)
+	if err == nil {
+		t.Error("metricRegisterBulk() succeeded, want an error for the invalid metric")
+	}
+
+	if bulkRequests != 2 {
+		t.Errorf("bulkRequests = %d, want 2", bulkRequests)
+	}
+
+	if singleRequest != 10 {
+		t.Errorf("singleRequest = %d, want 10", singleRequest)
+	}
+
+	if len(byKey) != metricBatchSize+9 {
+		t.Errorf("len(registered metrics) = %d, want %d", len(byKey), metricBatchSize+9)
+	}
+
+	if _, ok := byKey["invalid"]; ok {
+		t.Error("invalid metric is registered")
+	}
+}
+
+// TestMetricRegisterBulkUnsupported check that metrics are registered one by one when
+// the API fails on bulk requests.
+func TestMetricRegisterBulkUnsupported(t *testing.T) {
+	var (
+		l             sync.Mutex
+		bulkRequests  int
+		singleRequest int
+	)
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/v1/jwt-auth/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, jwtToken)
+	})
+	serveMux.HandleFunc("/v1/metric/", func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+
+		_ = json.NewDecoder(r.Body).Decode(&raw)
+
+		l.Lock()
+		defer l.Unlock()
+
+		var payload metricPayload
+
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			bulkRequests++
+
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		singleRequest++
+		payload.ID = fmt.Sprintf("id-%d", singleRequest)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(payload)
+	})
+
+	httpServer := httptest.NewServer(serveMux)
+	defer httpServer.Close()
+
+	cl, err := client.NewClient(context.Background(), httpServer.URL, "user", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Synchronizer{ctx: context.Background(), client: cl}
+
+	registrations := make([]metricRegistration, 0, 5)
+
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("metric_%d", i)
+
+		registrations = append(registrations, metricRegistration{
+			key:     name,
+			payload: metricPayload{Name: name, Metric: bleemeoTypes.Metric{LabelsText: name}},
+		})
+	}
+
+	byUUID := make(map[string]bleemeoTypes.Metric)
+	byKey := make(map[string]bleemeoTypes.Metric)
+
+	if err := s.metricRegisterBulk(registrations, byUUID, byKey, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if bulkRequests != 1 || singleRequest != 5 {
+		t.Errorf("requests = %d bulk and %d single, want 1 and 5", bulkRequests, singleRequest)
+	}
+
+	if len(byKey) != 5 {
+		t.Errorf("len(registered metrics) = %d, want 5", len(byKey))
+	}
+}
+
+// TestMetricMigrateItem checks that a metric registered with an item truncated by an older
+// version gets the new item instead of being registered again.
+func TestMetricMigrateItem(t *testing.T) {
+	var (
+		l       sync.Mutex
+		patched map[string]string
+	)
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/v1/jwt-auth/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, jwtToken)
+	})
+	serveMux.HandleFunc("/v1/metric/id-1/", func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+
+		if r.Method != http.MethodPatch {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		_ = json.NewDecoder(r.Body).Decode(&patched)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+	})
+
+	httpServer := httptest.NewServer(serveMux)
+	defer httpServer.Close()
+
+	cl, err := client.NewClient(context.Background(), httpServer.URL, "user", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Synchronizer{ctx: context.Background(), client: cl}
+
+	labels := map[string]string{types.LabelName: "disk_used"}
+	annotations := types.MetricAnnotations{BleemeoItem: "/" + strings.Repeat("a", 150)}
+	legacy := metricPayload{
+		Metric: bleemeoTypes.Metric{
+			ID:   "id-1",
+			Item: common.LegacyTruncateItem(annotations.BleemeoItem, false),
+		},
+		Name: "disk_used",
+	}.metricFromAPI()
+
+	byKey := map[string]bleemeoTypes.Metric{legacy.LabelsText: legacy}
+	key := common.LabelsToText(labels, annotations, true)
+
+	if key == legacy.LabelsText {
+		t.Fatal("the legacy and the new items are the same")
+	}
+
+	migrated, ok := s.metricMigrateItem(key, labels, annotations, byKey)
+	if !ok {
+		t.Fatal("metricMigrateItem() didn't migrate the metric")
+	}
+
+	wantItem := common.TruncateItem(annotations.BleemeoItem, false)
+
+	if migrated.ID != "id-1" || migrated.Item != wantItem || migrated.LabelsText != key {
+		t.Errorf("migrated = %+v, want id-1 with item %s", migrated, wantItem)
+	}
+
+	if patched["item"] != wantItem {
+		t.Errorf("PATCH item = %#v, want %#v", patched["item"], wantItem)
+	}
+
+	if _, ok := byKey[legacy.LabelsText]; ok {
+		t.Error("the legacy key is still registered")
+	}
+
+	// A short item isn't migrated.
+	short := types.MetricAnnotations{BleemeoItem: "/home"}
+	if _, ok := s.metricMigrateItem(common.LabelsToText(labels, short, true), labels, short, byKey); ok {
+		t.Error("metricMigrateItem() migrated a short item")
+	}
+}
+
+// TestMetricRegisterQuota check that registrations stop once the metrics quota is reached.
+func TestMetricRegisterQuota(t *testing.T) {
+	const quota = 5
+
+	var (
+		l       sync.Mutex
+		created int
+		refused int
+	)
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/v1/jwt-auth/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, jwtToken)
+	})
+	serveMux.HandleFunc("/v1/metric/", func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+
+		_ = json.NewDecoder(r.Body).Decode(&raw)
+
+		l.Lock()
+		defer l.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		var payload metricPayload
+
+		if err := json.Unmarshal(raw, &payload); err != nil || created >= quota {
+			refused++
+
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `["Too many metrics for this account"]`)
+
+			return
+		}
+
+		created++
+		payload.ID = fmt.Sprintf("id-%d", created)
+
+		_ = json.NewEncoder(w).Encode(payload)
+	})
+
+	httpServer := httptest.NewServer(serveMux)
+	defer httpServer.Close()
+
+	cl, err := client.NewClient(context.Background(), httpServer.URL, "user", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Synchronizer{ctx: context.Background(), client: cl}
+
+	registrations := make([]metricRegistration, 0, 20)
+
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("metric_%d", i)
+
+		registrations = append(registrations, metricRegistration{
+			key:     name,
+			payload: metricPayload{Name: name, Metric: bleemeoTypes.Metric{LabelsText: name}},
+		})
+	}
+
+	byUUID := make(map[string]bleemeoTypes.Metric)
+	byKey := make(map[string]bleemeoTypes.Metric)
+
+	err = s.metricRegisterBulk(registrations, byUUID, byKey, nil)
+	if !client.IsQuotaExceeded(err) {
+		t.Errorf("metricRegisterBulk() = %v, want a quota error", err)
+	}
+
+	if len(byKey) != quota {
+		t.Errorf("len(registered metrics) = %d, want %d", len(byKey), quota)
+	}
+
+	// The bulk request and the first registration over the quota.
+	if refused != 2 {
+		t.Errorf("refused = %d, want 2", refused)
+	}
+}
+
+func TestMetricRegisterThrottled(t *testing.T) {
+	var (
+		l         sync.Mutex
+		requests  int
+		throttled int
+	)
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/v1/jwt-auth/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, jwtToken)
+	})
+	serveMux.HandleFunc("/v1/metric/", func(w http.ResponseWriter, r *http.Request) {
+		var payloads []metricPayload
+
+		_ = json.NewDecoder(r.Body).Decode(&payloads)
+
+		l.Lock()
+		defer l.Unlock()
+
+		requests++
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if requests == 1 {
+			throttled++
+
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"detail": "Request was throttled."}`)
+
+			return
+		}
+
+		for i := range payloads {
+			payloads[i].ID = fmt.Sprintf("id-%d", i)
+		}
+
+		_ = json.NewEncoder(w).Encode(payloads)
+	})
+
+	httpServer := httptest.NewServer(serveMux)
+	defer httpServer.Close()
+
+	cl, err := client.NewClient(context.Background(), httpServer.URL, "user", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Synchronizer{ctx: context.Background(), client: cl}
+
+	registrations := make([]metricRegistration, 0, 3)
+
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("metric_%d", i)
+
+		registrations = append(registrations, metricRegistration{
+			key:     name,
+			payload: metricPayload{Name: name, Metric: bleemeoTypes.Metric{LabelsText: name}},
+		})
+	}
+
+	byUUID := make(map[string]bleemeoTypes.Metric)
+	byKey := make(map[string]bleemeoTypes.Metric)
+
+	if err := s.metricRegisterBulk(registrations, byUUID, byKey, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(byKey) != 3 {
+		t.Errorf("len(registered metrics) = %d, want 3", len(byKey))
+	}
+
+	// The throttled bulk request is retried, metrics aren't registered one by one.
+	if requests != 2 || throttled != 1 {
+		t.Errorf("requests = %d (%d throttled), want 2 (1 throttled)", requests, throttled)
+	}
+
+	if delay := s.metricLimiter.Delay(); delay != minThrottleDelay/2 {
+		t.Errorf("limiter delay = %v, want %v", delay, minThrottleDelay/2)
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	var limiter adaptiveLimiter
+
+	steps := []struct {
+		throttled  bool
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{throttled: true, want: minThrottleDelay},
+		{throttled: true, want: 2 * minThrottleDelay},
+		{throttled: true, retryAfter: 30 * time.Second, want: 30 * time.Second},
+		{throttled: true, retryAfter: time.Hour, want: maxThrottleDelay},
+		{want: maxThrottleDelay / 2},
+		{want: maxThrottleDelay / 4},
+	}
+
+	for i, step := range steps {
+		if step.throttled {
+			limiter.Throttled(step.retryAfter)
+		} else {
+			limiter.Succeeded()
+		}
+
+		if got := limiter.Delay(); got != step.want {
+			t.Errorf("step %d: Delay() = %v, want %v", i, got, step.want)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		limiter.Succeeded()
+	}
+
+	if got := limiter.Delay(); got != 0 {
+		t.Errorf("Delay() = %v after successes, want 0", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	limiter.Throttled(0)
+
+	if err := limiter.Wait(ctx); err == nil {
+		t.Errorf("Wait() with a cancelled context succeeded, want an error")
+	}
+}
@@ -33,7 +33,7 @@ const (
 	Delete
 )
 
-const fieldList string = "id,account_config,agent,created_at,monitor_url,monitor_expected_content,monitor_expected_response_code,monitor_unexpected_content"
+const fieldList string = "id,account_config,agent,created_at,monitor_url,monitor_expected_content,monitor_expected_response_code,monitor_unexpected_content,monitor_headers"
 
 type MonitorUpdate struct {
 	op   MonitorOperation
@@ -147,6 +147,7 @@ func (s *Synchronizer) ApplyMonitorUpdate(forceAccountConfigsReload bool) error
 			ExpectedContent:         monitor.ExpectedContent,
 			ExpectedResponseCode:    monitor.ExpectedResponseCode,
 			ForbiddenContent:        monitor.ForbiddenContent,
+			Headers:                 monitor.Headers,
 		})
 	}
 
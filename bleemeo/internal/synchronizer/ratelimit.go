@@ -0,0 +1,110 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synchronizer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	minThrottleDelay = time.Second
+	maxThrottleDelay = 2 * time.Minute
+)
+
+// adaptiveLimiter spaces the requests made to the API. The delay between requests doubles each
+// time the API answers with a 429 and is halved after each request accepted.
+//
+// The zero value is a limiter that doesn't delay requests.
+type adaptiveLimiter struct {
+	l     sync.Mutex
+	delay time.Duration
+	next  time.Time
+}
+
+// Wait blocks until the next request could be sent, or ctx is cancelled.
+func (a *adaptiveLimiter) Wait(ctx context.Context) error {
+	a.l.Lock()
+
+	now := time.Now()
+	wait := a.next.Sub(now)
+
+	if a.next.Before(now) {
+		a.next = now
+	}
+
+	a.next = a.next.Add(a.delay)
+
+	a.l.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Throttled increases the delay after a 429. retryAfter is the delay requested by the API, if any.
+func (a *adaptiveLimiter) Throttled(retryAfter time.Duration) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.delay *= 2
+
+	if a.delay < minThrottleDelay {
+		a.delay = minThrottleDelay
+	}
+
+	if retryAfter > a.delay {
+		a.delay = retryAfter
+	}
+
+	if a.delay > maxThrottleDelay {
+		a.delay = maxThrottleDelay
+	}
+
+	a.next = time.Now().Add(a.delay)
+}
+
+// Succeeded decreases the delay after a request accepted by the API.
+func (a *adaptiveLimiter) Succeeded() {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.delay /= 2
+
+	if a.delay < minThrottleDelay/10 {
+		a.delay = 0
+	}
+}
+
+// Delay returns the current delay between two requests.
+func (a *adaptiveLimiter) Delay() time.Duration {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	return a.delay
+}
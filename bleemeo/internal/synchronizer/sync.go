@@ -26,6 +26,7 @@ import (
 	"glouton/bleemeo/internal/cache"
 	"glouton/bleemeo/internal/common"
 	bleemeoTypes "glouton/bleemeo/types"
+	"glouton/clock"
 	"glouton/logger"
 	"glouton/types"
 	"math"
@@ -67,6 +68,14 @@ type Synchronizer struct {
 	forceSync             map[string]bool
 	pendingMetricsUpdate  []string
 	pendingMonitorsUpdate []MonitorUpdate
+	metricsNotRegistered  int
+	metricsQuotaErr       error
+	metricsDenied         int
+
+	metricLimiter adaptiveLimiter
+
+	// containerSyncErrors is the number of successive synchronization failures of each container ID.
+	containerSyncErrors map[string]int
 }
 
 // Option are parameters for the synchronizer.
@@ -98,8 +107,9 @@ func New(option Option) *Synchronizer {
 	return &Synchronizer{
 		option: option,
 
-		forceSync:    make(map[string]bool),
-		nextFullSync: time.Now(),
+		forceSync:           make(map[string]bool),
+		containerSyncErrors: make(map[string]int),
+		nextFullSync:        time.Now(),
 	}
 }
 
@@ -252,9 +262,7 @@ func (s *Synchronizer) DiagnosticPage() string {
 	port := 80
 
 	if u.Scheme == "https" {
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: s.option.Config.Bool("bleemeo.api_ssl_insecure"), // nolint: gosec
-		}
+		tlsConfig = s.tlsConfig()
 		port = 443
 	}
 
@@ -282,6 +290,10 @@ func (s *Synchronizer) DiagnosticPage() string {
 	builder.WriteString(<-tcpMessage)
 	builder.WriteString(<-httpMessage)
 
+	if delay := s.metricLimiter.Delay(); delay > 0 {
+		fmt.Fprintf(builder, "The API is throttling the metric registration, requests are spaced by %v\n", delay)
+	}
+
 	return builder.String()
 }
 
@@ -303,6 +315,14 @@ func (s *Synchronizer) NotifyConfigUpdate(immediate bool) {
 	s.forceSync["monitors"] = true
 }
 
+// ScheduleFullSync requests a full synchronization of all objects on the next run.
+func (s *Synchronizer) ScheduleFullSync() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.nextFullSync = time.Now()
+}
+
 // UpdateMetrics request to update a specific metrics.
 func (s *Synchronizer) UpdateMetrics(metricUUID ...string) {
 	s.l.Lock()
@@ -402,6 +422,8 @@ func (s *Synchronizer) Disable(until time.Time, reason bleemeoTypes.DisableReaso
 	s.l.Lock()
 	defer s.l.Unlock()
 
+	until = clock.Monotonic(until)
+
 	if s.disabledUntil.Before(until) {
 		s.disabledUntil = until
 		s.disableReason = reason
@@ -417,16 +439,73 @@ func (s *Synchronizer) setClient() error {
 		return err
 	}
 
-	client, err := client.NewClient(s.ctx, s.option.Config.String("bleemeo.api_base"), username, password, s.option.Config.Bool("bleemeo.api_ssl_insecure"))
+	tlsConfig := s.tlsConfig()
+	relayURL := s.option.Config.String("bleemeo.relay.url")
+
+	// The relay receives the credentials of the agent, it must be reached over HTTPS.
+	if relayURL != "" {
+		var err error
+
+		tlsConfig, err = common.RelayTLSConfig(
+			relayURL,
+			s.option.Config.String("bleemeo.relay.cafile"),
+			s.option.Config.StringList("bleemeo.relay.fingerprints"),
+			s.option.Config.Bool("bleemeo.relay.ssl_insecure"),
+		)
+		if err != nil {
+			return fmt.Errorf("invalid TLS configuration for the relay: %w", err)
+		}
+	}
+
+	client, err := client.NewClient(s.ctx, s.apiBase(), username, password, tlsConfig)
 	if err != nil {
 		return err
 	}
 
+	if relayURL != "" {
+		client.SetHeader(common.RelayTokenHeader, s.option.Config.String("bleemeo.relay.token"))
+		client.RebaseNextPages()
+	}
+
 	s.client = client
 
 	return nil
 }
 
+// apiBase returns the base URL of the Bleemeo API, which is reached through the relay
+// when one is configured.
+func (s *Synchronizer) apiBase() string {
+	if relayURL := s.option.Config.String("bleemeo.relay.url"); relayURL != "" {
+		return common.RelayAPIBase(relayURL)
+	}
+
+	return s.option.Config.String("bleemeo.api_base")
+}
+
+func (s *Synchronizer) tlsConfig() *tls.Config {
+	var serverName string
+
+	if u, err := url.Parse(s.apiBase()); err == nil {
+		serverName = u.Hostname()
+	}
+
+	tlsConfig, err := common.TLSConfig(
+		serverName,
+		s.option.Config.String("bleemeo.api_cafile"),
+		s.option.Config.StringList("bleemeo.api_fingerprints"),
+		s.option.Config.Bool("bleemeo.api_ssl_insecure"),
+	)
+	if err != nil {
+		logger.Printf("Invalid TLS configuration for Bleemeo API, using the default one: %v", err)
+
+		return &tls.Config{
+			InsecureSkipVerify: s.option.Config.Bool("bleemeo.api_ssl_insecure"), //nolint:gosec
+		}
+	}
+
+	return tlsConfig
+}
+
 func (s *Synchronizer) runOnce() error {
 	if s.agentID == "" {
 		if err := s.register(); err != nil {
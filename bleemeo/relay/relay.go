@@ -0,0 +1,581 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package relay allows agents in isolated networks to reach Bleemeo through this agent.
+//
+// The child agents use the Bleemeo API through a reverse proxy and send their MQTT
+// messages over HTTP. The relay publishes these messages on the Bleemeo MQTT with the
+// credentials of each child agent, so they are processed as if the agent sent them.
+package relay
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"glouton/bleemeo/internal/common"
+	"glouton/bleemeo/internal/mqtt"
+	bleemeoTypes "glouton/bleemeo/types"
+	"glouton/logger"
+	"glouton/proxy"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/go-chi/chi"
+)
+
+const (
+	// DefaultMaxPendingMessages is the default number of messages waiting to be published,
+	// for all child agents. Above it, the child agents are asked to retry later.
+	DefaultMaxPendingMessages = 10000
+
+	maxBatchBytes  = 10 << 20
+	retryDelay     = 10 * time.Second
+	publishTimeout = 30 * time.Second
+	// idleTimeout is the delay after which the MQTT connection of a child agent which
+	// sent nothing is closed.
+	idleTimeout = 10 * time.Minute
+)
+
+var (
+	errNoToken         = errors.New("at least one token is required")
+	errNoCertificate   = errors.New("a certificate and its key are required")
+	errRelayFull       = errors.New("too many pending messages")
+	errUnauthenticated = errors.New("agent isn't authenticated")
+)
+
+// Option are parameters of the relay.
+type Option struct {
+	Config bleemeoTypes.Config
+	// Tokens are the tokens accepted from the child agents.
+	Tokens []string
+	// MaxPendingMessages is the maximum number of messages waiting to be published.
+	MaxPendingMessages int
+	// ListenAddress is the address of the HTTPS listener of the relay.
+	ListenAddress string
+	// CertFile and KeyFile are the PEM certificate and key of the listener. The relay
+	// only accepts HTTPS, the child agents send it their credentials.
+	CertFile string
+	KeyFile  string
+}
+
+// Relay forwards the Bleemeo API requests and the MQTT messages of child agents.
+type Relay struct {
+	option    Option
+	apiProxy  *httputil.ReverseProxy
+	tlsConfig *tls.Config
+	server    *http.Server
+	wake      chan struct{}
+
+	// verifyPassword checks the credentials of a child agent on the Bleemeo MQTT.
+	verifyPassword func(agentID string, password string) error
+
+	l       sync.Mutex
+	agents  map[string]*agentQueue
+	pending int
+}
+
+// agentQueue contains the messages of a child agent waiting to be published.
+type agentQueue struct {
+	password       string
+	messages       []common.RelayMessage
+	client         paho.Client
+	clientPassword string
+	lastSeen       time.Time
+	retryAt        time.Time
+	published      int
+	lastError      error
+}
+
+// New returns a relay using the Bleemeo API and MQTT of the configuration.
+func New(option Option) (*Relay, error) {
+	if len(option.Tokens) == 0 {
+		return nil, errNoToken
+	}
+
+	if option.CertFile == "" || option.KeyFile == "" {
+		return nil, errNoCertificate
+	}
+
+	certificate, err := tls.LoadX509KeyPair(option.CertFile, option.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the relay certificate: %w", err)
+	}
+
+	if option.MaxPendingMessages <= 0 {
+		option.MaxPendingMessages = DefaultMaxPendingMessages
+	}
+
+	target, err := url.Parse(option.Config.String("bleemeo.api_base"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Bleemeo API URL: %w", err)
+	}
+
+	apiTLSConfig, err := common.TLSConfig(
+		target.Hostname(),
+		option.Config.String("bleemeo.api_cafile"),
+		option.Config.StringList("bleemeo.api_fingerprints"),
+		option.Config.Bool("bleemeo.api_ssl_insecure"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration for Bleemeo API: %w", err)
+	}
+
+	mqttTLSConfig, err := common.TLSConfig(
+		option.Config.String("bleemeo.mqtt.host"),
+		option.Config.String("bleemeo.mqtt.cafile"),
+		option.Config.StringList("bleemeo.mqtt.fingerprints"),
+		option.Config.Bool("bleemeo.mqtt.ssl_insecure"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration for MQTT: %w", err)
+	}
+
+	r := &Relay{
+		option:    option,
+		tlsConfig: mqttTLSConfig,
+		wake:      make(chan struct{}, 1),
+		agents:    make(map[string]*agentQueue),
+	}
+
+	r.verifyPassword = r.checkPassword
+
+	r.apiProxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = strings.TrimSuffix(target.Path, "/") + "/" + chi.URLParam(req, "*")
+			req.URL.RawPath = ""
+			req.Host = target.Host
+
+			req.Header.Del(common.RelayTokenHeader)
+		},
+		Transport: &http.Transport{
+			Proxy:           proxy.FromRequest,
+			TLSClientConfig: apiTLSConfig,
+		},
+	}
+
+	router := chi.NewRouter()
+	router.Mount(common.RelayPath, r.Handler())
+
+	r.server = &http.Server{
+		Addr:    option.ListenAddress,
+		Handler: router,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	return r, nil
+}
+
+// Handler returns the handler of the relay, it is served on /relay of the relay listener.
+func (r *Relay) Handler() http.Handler {
+	router := chi.NewRouter()
+	router.Use(r.authMiddleware)
+	router.Post("/mqtt", r.mqttHandler)
+	router.Handle("/api/*", r.apiProxy)
+
+	return router
+}
+
+func (r *Relay) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := []byte(req.Header.Get(common.RelayTokenHeader))
+
+		for _, t := range r.option.Tokens {
+			if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				next.ServeHTTP(w, req)
+
+				return
+			}
+		}
+
+		logger.V(2).Printf("Rejected relay request on %s from %s: invalid token", req.URL.Path, req.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func (r *Relay) mqttHandler(w http.ResponseWriter, req *http.Request) {
+	var batch common.RelayBatch
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxBatchBytes)
+
+	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+		http.Error(w, fmt.Sprintf("invalid batch: %v", err), http.StatusBadRequest)
+
+		return
+	}
+
+	if batch.AgentID == "" || batch.Password == "" {
+		http.Error(w, "agent_id and password are required", http.StatusBadRequest)
+
+		return
+	}
+
+	for _, m := range batch.Messages {
+		if !strings.HasPrefix(m.Topic, "v1/agent/") {
+			http.Error(w, fmt.Sprintf("topic %#v is not allowed", m.Topic), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	if err := r.authenticate(batch.AgentID, batch.Password, time.Now()); err != nil {
+		logger.V(1).Printf("Relay: rejected the credentials of agent %s from %s: %v", batch.AgentID, req.RemoteAddr, err)
+		http.Error(w, "invalid agent credentials", http.StatusUnauthorized)
+
+		return
+	}
+
+	switch err := r.enqueue(batch, time.Now()); {
+	case errors.Is(err, errRelayFull):
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryDelay.Seconds())))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// authenticate checks the password of a child agent. A password is only bound to the
+// agent once the Bleemeo MQTT accepted it, so a child can't replace the credentials
+// used for another agent.
+func (r *Relay) authenticate(agentID string, password string, now time.Time) error {
+	r.l.Lock()
+
+	q, ok := r.agents[agentID]
+	if ok && subtle.ConstantTimeCompare([]byte(q.password), []byte(password)) == 1 {
+		r.l.Unlock()
+
+		return nil
+	}
+
+	r.l.Unlock()
+
+	if err := r.verifyPassword(agentID, password); err != nil {
+		return err
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	q, ok = r.agents[agentID]
+	if !ok {
+		q = &agentQueue{lastSeen: now}
+		r.agents[agentID] = q
+	}
+
+	q.password = password
+
+	return nil
+}
+
+// checkPassword connects to the Bleemeo MQTT with the credentials of an agent.
+func (r *Relay) checkPassword(agentID string, password string) error {
+	client, err := r.connect(agentID, password)
+	if err != nil {
+		return err
+	}
+
+	client.Disconnect(0)
+
+	return nil
+}
+
+// enqueue adds the messages of the batch to the queue of the agent. The agent must have
+// been authenticated with the password of the batch.
+func (r *Relay) enqueue(batch common.RelayBatch, now time.Time) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	q, ok := r.agents[batch.AgentID]
+	if !ok || subtle.ConstantTimeCompare([]byte(q.password), []byte(batch.Password)) != 1 {
+		return errUnauthenticated
+	}
+
+	if r.pending+len(batch.Messages) > r.option.MaxPendingMessages {
+		return errRelayFull
+	}
+
+	q.lastSeen = now
+	q.messages = append(q.messages, batch.Messages...)
+	r.pending += len(batch.Messages)
+
+	if len(batch.Messages) > 0 {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Run serves the relay and publishes the messages of the child agents until the context
+// is cancelled.
+func (r *Relay) Run(ctx context.Context) error {
+	serverErr := make(chan error, 1)
+
+	go func() {
+		logger.Printf("Starting the relay on https://%s%s", r.server.Addr, common.RelayPath)
+
+		err := r.server.ListenAndServeTLS("", "")
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+
+		serverErr <- err
+	}()
+
+	ticker := time.NewTicker(retryDelay)
+	defer ticker.Stop()
+
+	var err error
+
+	for ctx.Err() == nil && err == nil {
+		r.forward(time.Now())
+
+		select {
+		case <-ctx.Done():
+		case <-r.wake:
+		case <-ticker.C:
+		case err = <-serverErr:
+			if err == nil {
+				err = http.ErrServerClosed
+			}
+		}
+	}
+
+	if err == nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if shutdownErr := r.server.Shutdown(shutdownCtx); shutdownErr != nil {
+			logger.V(1).Printf("Relay: failed to shutdown the listener: %v", shutdownErr)
+		}
+
+		err = <-serverErr
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	for _, q := range r.agents {
+		if q.client != nil {
+			q.client.Disconnect(0)
+			q.client = nil
+		}
+	}
+
+	return err
+}
+
+func (r *Relay) forward(now time.Time) {
+	r.l.Lock()
+
+	agentIDs := make([]string, 0, len(r.agents))
+
+	for agentID, q := range r.agents {
+		if len(q.messages) == 0 && now.Sub(q.lastSeen) > idleTimeout {
+			if q.client != nil {
+				q.client.Disconnect(0)
+			}
+
+			delete(r.agents, agentID)
+
+			continue
+		}
+
+		agentIDs = append(agentIDs, agentID)
+	}
+
+	r.l.Unlock()
+
+	for _, agentID := range agentIDs {
+		r.forwardAgent(agentID, now)
+	}
+}
+
+// forwardAgent publishes the pending messages of an agent. The messages which failed are
+// kept in the queue and retried after retryDelay.
+func (r *Relay) forwardAgent(agentID string, now time.Time) {
+	r.l.Lock()
+
+	q := r.agents[agentID]
+	if q == nil || len(q.messages) == 0 || now.Before(q.retryAt) {
+		r.l.Unlock()
+
+		return
+	}
+
+	if q.client != nil && q.clientPassword != q.password {
+		q.client.Disconnect(0)
+		q.client = nil
+	}
+
+	messages := q.messages
+	client := q.client
+	password := q.password
+
+	r.l.Unlock()
+
+	var err error
+
+	if client == nil || !client.IsConnectionOpen() {
+		client, err = r.connect(agentID, password)
+	}
+
+	failed := messages[:0:0]
+
+	if err == nil {
+		tokens := make([]paho.Token, len(messages))
+
+		for i, m := range messages {
+			tokens[i] = client.Publish(m.Topic, 1, false, m.Payload)
+		}
+
+		for i, token := range tokens {
+			if !token.WaitTimeout(publishTimeout) || token.Error() != nil {
+				failed = append(failed, messages[i])
+
+				if err == nil {
+					err = token.Error()
+				}
+			}
+		}
+
+		if len(failed) > 0 && err == nil {
+			err = fmt.Errorf("timeout publishing %d messages", len(failed))
+		}
+	} else {
+		failed = messages
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	// Only this goroutine removes messages, the ones added meanwhile are after the
+	// messages being published.
+	q.messages = append(failed, q.messages[len(messages):]...)
+	r.pending -= len(messages) - len(failed)
+	q.published += len(messages) - len(failed)
+	q.client = client
+	q.clientPassword = password
+	q.lastError = err
+
+	if err != nil {
+		logger.V(1).Printf("Relay: unable to publish %d messages of agent %s: %v", len(failed), agentID, err)
+
+		q.retryAt = now.Add(retryDelay)
+
+		if client != nil {
+			client.Disconnect(0)
+			q.client = nil
+		}
+	}
+}
+
+// connect opens the MQTT connection of an agent, it use the same settings as the MQTT
+// connector of this agent.
+func (r *Relay) connect(agentID string, password string) (paho.Client, error) {
+	pahoOptions := paho.NewClientOptions()
+
+	willPayload, _ := json.Marshal(map[string]string{"disconnect-cause": "disconnect-will"})
+
+	pahoOptions.SetBinaryWill(fmt.Sprintf("v1/agent/%s/disconnect", agentID), willPayload, 1, false)
+
+	host := r.option.Config.String("bleemeo.mqtt.host")
+	port := strconv.Itoa(r.option.Config.Int("bleemeo.mqtt.port"))
+
+	if r.option.Config.Bool("bleemeo.mqtt.ssl") {
+		pahoOptions.AddBroker("ssl://" + net.JoinHostPort(host, port))
+		pahoOptions.SetTLSConfig(r.tlsConfig)
+	} else {
+		pahoOptions.AddBroker("tcp://" + net.JoinHostPort(host, port))
+	}
+
+	pahoOptions.SetUsername(fmt.Sprintf("%s@bleemeo.com", agentID))
+	pahoOptions.SetPassword(password)
+	pahoOptions.SetAutoReconnect(false)
+	pahoOptions.SetCustomOpenConnectionFn(mqtt.OpenConnection)
+
+	client := paho.NewClient(pahoOptions)
+	token := client.Connect()
+
+	if !token.WaitTimeout(publishTimeout) {
+		client.Disconnect(0)
+
+		return nil, fmt.Errorf("timeout connecting to MQTT for agent %s", agentID)
+	}
+
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// DiagnosticPage returns the state of the relay.
+func (r *Relay) DiagnosticPage() string {
+	builder := &strings.Builder{}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	fmt.Fprintf(
+		builder,
+		"Relay has %d child agents with %d pending messages (max %d)\n",
+		len(r.agents), r.pending, r.option.MaxPendingMessages,
+	)
+
+	agentIDs := make([]string, 0, len(r.agents))
+
+	for agentID := range r.agents {
+		agentIDs = append(agentIDs, agentID)
+	}
+
+	sort.Strings(agentIDs)
+
+	for _, agentID := range agentIDs {
+		q := r.agents[agentID]
+
+		fmt.Fprintf(
+			builder,
+			"  agent %s: %d pending, %d published, connected=%v, last seen %v ago\n",
+			agentID, len(q.messages), q.published, q.client != nil, time.Since(q.lastSeen).Truncate(time.Second),
+		)
+
+		if q.lastError != nil {
+			fmt.Fprintf(builder, "    last error: %v\n", q.lastError)
+		}
+	}
+
+	return builder.String()
+}
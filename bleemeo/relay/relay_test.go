@@ -0,0 +1,255 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"glouton/bleemeo/internal/common"
+	"glouton/config"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var errInvalidPassword = errors.New("invalid password")
+
+// writeTestCertificate writes a self-signed certificate and its key in dir.
+func writeTestCertificate(t *testing.T, dir string) (certFile string, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "relay.example.com"},
+		DNSNames:     []string{"relay.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "relay.crt")
+	keyFile = filepath.Join(dir, "relay.key")
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+// newTestRelay returns a relay which only accepts "password" as the password of the agents.
+func newTestRelay(t *testing.T, apiBase string, maxPending int) *Relay {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "glouton-relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	cfg := &config.Configuration{}
+
+	if err := cfg.LoadByte([]byte("")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Set("bleemeo.api_base", apiBase)
+
+	r, err := New(Option{
+		Config:             cfg,
+		Tokens:             []string{"secret"},
+		MaxPendingMessages: maxPending,
+		ListenAddress:      "127.0.0.1:0",
+		CertFile:           certFile,
+		KeyFile:            keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.verifyPassword = func(agentID string, password string) error {
+		if password != "password" {
+			return errInvalidPassword
+		}
+
+		return nil
+	}
+
+	return r
+}
+
+func postBatch(t *testing.T, handler http.Handler, token string, batch common.RelayBatch) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/mqtt", bytes.NewReader(body))
+	req.Header.Set(common.RelayTokenHeader, token)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestNewWithoutToken(t *testing.T) {
+	if _, err := New(Option{}); err == nil {
+		t.Error("New() without tokens succeeded, want an error")
+	}
+}
+
+func TestNewWithoutCertificate(t *testing.T) {
+	if _, err := New(Option{Tokens: []string{"secret"}}); !errors.Is(err, errNoCertificate) {
+		t.Errorf("New() without certificate = %v, want %v", err, errNoCertificate)
+	}
+}
+
+func TestMQTTHandler(t *testing.T) {
+	r := newTestRelay(t, "https://api.bleemeo.com/", 3)
+	handler := r.Handler()
+
+	batch := common.RelayBatch{
+		AgentID:  "agent-1",
+		Password: "password",
+		Messages: []common.RelayMessage{
+			{Topic: "v1/agent/agent-1/data", Payload: []byte("1")},
+			{Topic: "v1/agent/agent-1/data", Payload: []byte("2")},
+		},
+	}
+
+	if w := postBatch(t, handler, "invalid", batch); w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := postBatch(t, handler, "secret", batch); w.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+
+	// The relay is full, the child agent must retry later.
+	w := postBatch(t, handler, "secret", batch)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("full relay: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("full relay: Retry-After header is missing")
+	}
+
+	forbidden := common.RelayBatch{
+		AgentID:  "agent-1",
+		Password: "password",
+		Messages: []common.RelayMessage{{Topic: "other/topic"}},
+	}
+
+	if w := postBatch(t, handler, "secret", forbidden); w.Code != http.StatusBadRequest {
+		t.Errorf("forbidden topic: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// A batch with another password must not replace the credentials of the agent.
+	stolen := common.RelayBatch{
+		AgentID:  "agent-1",
+		Password: "other",
+	}
+
+	if w := postBatch(t, handler, "secret", stolen); w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid password: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if got := r.agents["agent-1"].password; got != "password" {
+		t.Errorf("password = %s, want password", got)
+	}
+
+	if r.pending != 2 || len(r.agents["agent-1"].messages) != 2 {
+		t.Errorf("pending = %d, want 2", r.pending)
+	}
+}
+
+func TestAPIProxy(t *testing.T) {
+	var (
+		gotPath  string
+		gotAuth  string
+		gotToken string
+	)
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotPath = req.URL.RequestURI()
+		gotAuth = req.Header.Get("Authorization")
+		gotToken = req.Header.Get(common.RelayTokenHeader)
+	}))
+	defer api.Close()
+
+	r := newTestRelay(t, api.URL+"/", 0)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/metric/?agent=42", nil)
+	req.Header.Set(common.RelayTokenHeader, "secret")
+	req.SetBasicAuth("agent-1@bleemeo.com", "password")
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if want := "/v1/metric/?agent=42"; gotPath != want {
+		t.Errorf("path = %s, want %s", gotPath, want)
+	}
+
+	if gotAuth == "" {
+		t.Error("the credentials of the agent weren't forwarded")
+	}
+
+	if gotToken != "" {
+		t.Error("the relay token was forwarded to the Bleemeo API")
+	}
+}
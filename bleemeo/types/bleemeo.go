@@ -18,6 +18,7 @@ package types
 
 import (
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"glouton/threshold"
 	"math"
@@ -102,9 +103,10 @@ type Monitor struct {
 
 // MonitorHTTPOptions groups all the possible options when the probe is targeting an HTTP or HTTPS service.
 type MonitorHTTPOptions struct {
-	ExpectedContent      string `json:"monitor_expected_content,omitempty"`
-	ExpectedResponseCode int    `json:"monitor_expected_response_code,omitempty"`
-	ForbiddenContent     string `json:"monitor_unexpected_content,omitempty"`
+	ExpectedContent      string            `json:"monitor_expected_content,omitempty"`
+	ExpectedResponseCode int               `json:"monitor_expected_response_code,omitempty"`
+	ForbiddenContent     string            `json:"monitor_unexpected_content,omitempty"`
+	Headers              map[string]string `json:"monitor_headers,omitempty"`
 }
 
 // Metric is a Metric object on Bleemeo API.
@@ -121,12 +123,61 @@ type Metric struct {
 	DeactivatedAt time.Time `json:"deactivated_at,omitempty"`
 }
 
+// volatileInspectFields are fields of the Docker inspect which change without
+// meaningful change of the container. They are ignored by FillInspectHash.
+var volatileInspectFields = [][]string{ //nolint:gochecknoglobals
+	{"State", "Pid"},
+	{"State", "StartedAt"},
+	{"State", "FinishedAt"},
+	{"State", "Health", "FailingStreak"},
+	{"State", "Health", "Log"},
+	{"SizeRw"},
+	{"SizeRootFs"},
+}
+
 // FillInspectHash fill the DockerInspectHash.
+//
+// Volatile fields (timestamps, health-check logs, disk usage) are removed and
+// the JSON is normalized before hashing, so only meaningful changes modify the hash.
 func (c *Container) FillInspectHash() {
-	bin := sha256.Sum256([]byte(c.DockerInspect))
+	bin := sha256.Sum256([]byte(normalizeInspect(c.DockerInspect)))
 	c.DockerInspectHash = fmt.Sprintf("%x", bin)
 }
 
+func normalizeInspect(inspect string) string {
+	var decoded map[string]interface{}
+
+	if err := json.Unmarshal([]byte(inspect), &decoded); err != nil {
+		return inspect
+	}
+
+	for _, path := range volatileInspectFields {
+		deleteField(decoded, path)
+	}
+
+	// json.Marshal sort map keys, the result don't depend on the original formatting.
+	result, err := json.Marshal(decoded)
+	if err != nil {
+		return inspect
+	}
+
+	return string(result)
+}
+
+func deleteField(object map[string]interface{}, path []string) {
+	for len(path) > 1 {
+		child, ok := object[path[0]].(map[string]interface{})
+		if !ok {
+			return
+		}
+
+		object = child
+		path = path[1:]
+	}
+
+	delete(object, path[0])
+}
+
 // MetricsAgentWhitelistMap return a map with all whitelisted agent metrics.
 func (ac AccountConfig) MetricsAgentWhitelistMap() map[string]bool {
 	result := make(map[string]bool)
@@ -64,3 +64,50 @@ func TestMetricsAgentWhitelistMap(t *testing.T) {
 		}
 	}
 }
+
+func TestFillInspectHash(t *testing.T) {
+	base := Container{
+		DockerInspect: `{"Id": "1234", "State": {"Status": "running", "Pid": 42, "StartedAt": "2020-10-01T12:00:00Z", "Health": {"Status": "healthy", "FailingStreak": 0, "Log": []}}}`,
+	}
+	cases := []struct {
+		name      string
+		inspect   string
+		wantEqual bool
+	}{
+		{
+			name:      "formatting",
+			inspect:   `{"State":{"Health":{"Status":"healthy","FailingStreak":0,"Log":[]},"StartedAt":"2020-10-01T12:00:00Z","Pid":42,"Status":"running"},"Id":"1234"}`,
+			wantEqual: true,
+		},
+		{
+			name:      "volatile-fields",
+			inspect:   `{"Id": "1234", "SizeRw": 4096, "State": {"Status": "running", "Pid": 51, "StartedAt": "2020-10-01T13:00:00Z", "Health": {"Status": "healthy", "FailingStreak": 1, "Log": [{"ExitCode": 0}]}}}`,
+			wantEqual: true,
+		},
+		{
+			name:      "status-change",
+			inspect:   `{"Id": "1234", "State": {"Status": "exited", "Pid": 42, "StartedAt": "2020-10-01T12:00:00Z", "Health": {"Status": "healthy", "FailingStreak": 0, "Log": []}}}`,
+			wantEqual: false,
+		},
+		{
+			name:      "health-change",
+			inspect:   `{"Id": "1234", "State": {"Status": "running", "Pid": 42, "StartedAt": "2020-10-01T12:00:00Z", "Health": {"Status": "unhealthy", "FailingStreak": 3, "Log": []}}}`,
+			wantEqual: false,
+		},
+	}
+
+	base.FillInspectHash()
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			container := Container{DockerInspect: c.inspect}
+			container.FillInspectHash()
+
+			if got := container.DockerInspectHash == base.DockerInspectHash; got != c.wantEqual {
+				t.Errorf("hash equal = %v, want %v", got, c.wantEqual)
+			}
+		})
+	}
+}
@@ -18,10 +18,12 @@ package types
 
 import (
 	"context"
+	"glouton/audit"
 	"glouton/discovery"
 	"glouton/facts"
 	"glouton/threshold"
 	"glouton/types"
+	"io"
 	"time"
 
 	"github.com/influxdata/telegraf"
@@ -44,6 +46,13 @@ type GlobalOption struct {
 	UpdateMetricResolution func(resolution time.Duration)
 	UpdateThresholds       func(thresholds map[threshold.MetricNameItem]threshold.Threshold, firstUpdate bool)
 	UpdateUnits            func(units map[threshold.MetricNameItem]threshold.Unit)
+	// TriggerDiscovery, DiagnosticZip and RunAction are used by remote commands.
+	// RunAction is nil when no action is enabled.
+	TriggerDiscovery func()
+	DiagnosticZip    func(w io.Writer) error
+	RunAction        func(ctx context.Context, name string) (string, error)
+	// AuditLog records the MQTT connections and configuration changes.
+	AuditLog *audit.Log
 }
 
 type MonitorManager interface {
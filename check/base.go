@@ -48,6 +48,7 @@ type baseCheck struct {
 	tcpAddresses   []string
 	mainCheck      func(ctx context.Context) types.StatusDescription
 	acc            inputs.AnnotationAccumulator
+	pool           *Pool
 
 	timer    *time.Timer
 	dialer   *net.Dialer
@@ -147,7 +148,13 @@ func (bc *baseCheck) check(ctx context.Context, callFromSchedule bool) types.Sta
 	bc.l.Lock()
 	defer bc.l.Unlock()
 
-	result := bc.doCheck(ctx)
+	var result types.StatusDescription
+
+	if bc.pool != nil {
+		bc.pool.run(ctx, func() { result = bc.doCheck(ctx) })
+	} else {
+		result = bc.doCheck(ctx)
+	}
 
 	if ctx.Err() != nil {
 		return result
@@ -197,6 +204,11 @@ func (bc *baseCheck) check(ctx context.Context, callFromSchedule bool) types.Sta
 	return result
 }
 
+// SetPool sets the worker pool used to run the check. It must be called before Run.
+func (bc *baseCheck) SetPool(pool *Pool) {
+	bc.pool = pool
+}
+
 // ChechNow runs the check now without waiting the timer.
 func (bc *baseCheck) CheckNow(ctx context.Context) types.StatusDescription {
 	replyChan := make(chan types.StatusDescription)
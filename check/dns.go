@@ -0,0 +1,190 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"glouton/inputs"
+	"glouton/types"
+
+	"github.com/miekg/dns"
+)
+
+// DNSOptions are the settings of a DNS check.
+type DNSOptions struct {
+	// QueryName is the name queried. When empty, the check only verifies the server answers,
+	// whatever the response code is.
+	QueryName string
+	// QueryType is the type of the record queried, it defaults to A.
+	QueryType string
+	// Expected values must all be found in the answer, or result will be critical.
+	Expected []string
+	// The TTL of the answers must be between MinTTL and MaxTTL. Zero disables the bound.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// The result is warning or critical when the response time reaches these values. Zero disables them.
+	ResponseTimeWarning  time.Duration
+	ResponseTimeCritical time.Duration
+}
+
+// DNSCheck perform a DNS check.
+type DNSCheck struct {
+	*baseCheck
+	mainAddress string
+	options     DNSOptions
+}
+
+// NewDNS create a new DNS check.
+//
+// All addresses use the format "IP:port".
+//
+// For each persitentAddresses this checker will maintain a TCP connection open, if broken (and unable to re-open), the check will
+// be immediately run.
+func NewDNS(address string, persitentAddresses []string, persistentConnection bool, options DNSOptions, labels map[string]string, annotations types.MetricAnnotations, acc inputs.AnnotationAccumulator) *DNSCheck {
+	dc := &DNSCheck{
+		mainAddress: address,
+		options:     options,
+	}
+
+	dc.baseCheck = newBase("", persitentAddresses, persistentConnection, dc.doCheck, labels, annotations, acc)
+
+	return dc
+}
+
+func (dc *DNSCheck) doCheck(ctx context.Context) types.StatusDescription {
+	if dc.mainAddress == "" {
+		return types.StatusDescription{
+			CurrentStatus: types.StatusOk,
+		}
+	}
+
+	queryName := dc.options.QueryName
+	queryType := dns.TypeSOA
+
+	if queryName == "" {
+		queryName = "."
+	} else {
+		queryType = dns.TypeA
+
+		if dc.options.QueryType != "" {
+			var ok bool
+
+			queryType, ok = dns.StringToType[strings.ToUpper(dc.options.QueryType)]
+			if !ok {
+				return types.StatusDescription{
+					CurrentStatus:     types.StatusUnknown,
+					StatusDescription: fmt.Sprintf("Invalid DNS query type %#v", dc.options.QueryType),
+				}
+			}
+		}
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(queryName), queryType)
+
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client := &dns.Client{Timeout: 10 * time.Second}
+
+	resp, responseTime, err := client.ExchangeContext(ctx2, msg, dc.mainAddress)
+	if err != nil {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: "DNS query failed: " + err.Error(),
+		}
+	}
+
+	if dc.options.QueryName != "" {
+		if status, ok := dc.validateAnswer(resp); !ok {
+			return status
+		}
+	}
+
+	if dc.options.ResponseTimeCritical > 0 && responseTime >= dc.options.ResponseTimeCritical {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("DNS CRITICAL - response time %.3fs", responseTime.Seconds()),
+		}
+	}
+
+	if dc.options.ResponseTimeWarning > 0 && responseTime >= dc.options.ResponseTimeWarning {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusWarning,
+			StatusDescription: fmt.Sprintf("DNS WARNING - response time %.3fs", responseTime.Seconds()),
+		}
+	}
+
+	return types.StatusDescription{
+		CurrentStatus:     types.StatusOk,
+		StatusDescription: fmt.Sprintf("DNS OK - %d answers in %.3fs", len(resp.Answer), responseTime.Seconds()),
+	}
+}
+
+// validateAnswer checks the response code, the expected values and the TTL of the answer.
+func (dc *DNSCheck) validateAnswer(resp *dns.Msg) (types.StatusDescription, bool) {
+	if resp.Rcode != dns.RcodeSuccess {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("DNS CRITICAL - %s returned %s", dc.options.QueryName, dns.RcodeToString[resp.Rcode]),
+		}, false
+	}
+
+	if len(resp.Answer) == 0 {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("DNS CRITICAL - no answer for %s", dc.options.QueryName),
+		}, false
+	}
+
+	values := make(map[string]bool, len(resp.Answer))
+
+	for _, rr := range resp.Answer {
+		values[rrValue(rr)] = true
+
+		ttl := time.Duration(rr.Header().Ttl) * time.Second
+
+		if (dc.options.MinTTL > 0 && ttl < dc.options.MinTTL) || (dc.options.MaxTTL > 0 && ttl > dc.options.MaxTTL) {
+			return types.StatusDescription{
+				CurrentStatus:     types.StatusCritical,
+				StatusDescription: fmt.Sprintf("DNS CRITICAL - TTL of %s is %s", rrValue(rr), ttl),
+			}, false
+		}
+	}
+
+	for _, expected := range dc.options.Expected {
+		if !values[strings.TrimSuffix(expected, ".")] {
+			return types.StatusDescription{
+				CurrentStatus:     types.StatusCritical,
+				StatusDescription: fmt.Sprintf("DNS CRITICAL - %s not found in the answer for %s", expected, dc.options.QueryName),
+			}, false
+		}
+	}
+
+	return types.StatusDescription{}, true
+}
+
+// rrValue returns the data of a record, without its header and the trailing dot of names.
+func rrValue(rr dns.RR) string {
+	value := strings.TrimPrefix(rr.String(), rr.Header().String())
+
+	return strings.TrimSuffix(value, ".")
+}
@@ -0,0 +1,92 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"glouton/types"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSCheck(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		switch r.Question[0].Name {
+		case "example.com.":
+			rr, _ := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+			m.Answer = append(m.Answer, rr)
+			rr, _ = dns.NewRR("example.com. 300 IN A 192.0.2.2")
+			m.Answer = append(m.Answer, rr)
+		case "www.example.com.":
+			rr, _ := dns.NewRR("www.example.com. 60 IN CNAME example.com.")
+			m.Answer = append(m.Answer, rr)
+		default:
+			m.Rcode = dns.RcodeNameError
+		}
+
+		_ = w.WriteMsg(m)
+	})
+
+	srv := &dns.Server{PacketConn: pc, Handler: handler}
+
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+
+	defer srv.Shutdown() //nolint:errcheck
+
+	cases := []struct {
+		name    string
+		options DNSOptions
+		want    types.Status
+	}{
+		{name: "any-answer", want: types.StatusOk},
+		{name: "resolve", options: DNSOptions{QueryName: "example.com"}, want: types.StatusOk},
+		{name: "nxdomain", options: DNSOptions{QueryName: "missing.example.com"}, want: types.StatusCritical},
+		{name: "expected", options: DNSOptions{QueryName: "example.com", Expected: []string{"192.0.2.2", "192.0.2.1"}}, want: types.StatusOk},
+		{name: "unexpected", options: DNSOptions{QueryName: "example.com", Expected: []string{"192.0.2.3"}}, want: types.StatusCritical},
+		{
+			name:    "cname",
+			options: DNSOptions{QueryName: "www.example.com", QueryType: "cname", Expected: []string{"example.com."}},
+			want:    types.StatusOk,
+		},
+		{name: "ttl-ok", options: DNSOptions{QueryName: "example.com", MinTTL: time.Minute, MaxTTL: time.Hour}, want: types.StatusOk},
+		{name: "ttl-too-low", options: DNSOptions{QueryName: "www.example.com", MinTTL: 5 * time.Minute}, want: types.StatusCritical},
+		{name: "bad-type", options: DNSOptions{QueryName: "example.com", QueryType: "nope"}, want: types.StatusUnknown},
+	}
+
+	for _, c := range cases {
+		dc := NewDNS(pc.LocalAddr().String(), nil, false, c.options, map[string]string{types.LabelName: "bind_status"}, types.MetricAnnotations{}, nil)
+
+		got := dc.doCheck(context.Background())
+		if got.CurrentStatus != c.want {
+			t.Errorf("%s: status = %v (%s), want %v", c.name, got.CurrentStatus, got.StatusDescription, c.want)
+		}
+	}
+}
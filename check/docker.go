@@ -0,0 +1,181 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"glouton/facts"
+	"glouton/types"
+)
+
+// defaultStartPeriod is the delay during which a starting container is considered ok when its
+// healthcheck has no start period.
+const defaultStartPeriod = time.Minute
+
+// DockerHealthCheck reports the result of the Docker HEALTHCHECK of containers as the
+// docker_container_health_status metric.
+//
+// No probe is run by Glouton, the status comes from the container inspect. A status worse
+// than the reported one is only reported once it lasted for the soft period, like thresholds do.
+type DockerHealthCheck struct {
+	softPeriod time.Duration
+	pusher     types.PointPusher
+
+	l      sync.Mutex
+	states map[string]*healthState
+}
+
+type healthState struct {
+	reported     types.StatusDescription
+	pending      types.Status
+	pendingSince time.Time
+}
+
+// NewDockerHealth create a new check of the Docker healthcheck. Statuses are sent to pusher.
+func NewDockerHealth(softPeriod time.Duration, pusher types.PointPusher) *DockerHealthCheck {
+	return &DockerHealthCheck{
+		softPeriod: softPeriod,
+		pusher:     pusher,
+		states:     make(map[string]*healthState),
+	}
+}
+
+// Update emits the status of the container healthcheck. Containers without healthcheck are ignored.
+func (dh *DockerHealthCheck) Update(container facts.Container) {
+	inspect := container.Inspect()
+	if inspect.State == nil || inspect.State.Health == nil {
+		return
+	}
+
+	var (
+		output      string
+		startPeriod time.Duration
+	)
+
+	if index := len(inspect.State.Health.Log) - 1; index >= 0 && inspect.State.Health.Log[index] != nil {
+		output = inspect.State.Health.Log[index].Output
+	}
+
+	if inspect.Config != nil && inspect.Config.Healthcheck != nil {
+		startPeriod = inspect.Config.Healthcheck.StartPeriod
+	}
+
+	now := time.Now()
+	status := containerHealthStatus(container.State(), inspect.State.Health.Status, output, container.StartedAt(), startPeriod, now)
+
+	dh.l.Lock()
+
+	state, ok := dh.states[container.ID()]
+	if !ok {
+		state = &healthState{}
+		dh.states[container.ID()] = state
+	}
+
+	status = state.soft(status, dh.softPeriod, now)
+
+	dh.l.Unlock()
+
+	dh.pusher.PushPoints([]types.MetricPoint{
+		{
+			Labels: map[string]string{
+				types.LabelName:              "docker_container_health_status",
+				types.LabelMetaContainerName: container.Name(),
+			},
+			Annotations: types.MetricAnnotations{
+				Status:      status,
+				ContainerID: container.ID(),
+				BleemeoItem: container.Name(),
+			},
+			Point: types.Point{
+				Time:  now,
+				Value: float64(status.CurrentStatus.NagiosCode()),
+			},
+		},
+	})
+}
+
+// Forget drops the soft period state of a deleted container.
+func (dh *DockerHealthCheck) Forget(containerID string) {
+	dh.l.Lock()
+	defer dh.l.Unlock()
+
+	delete(dh.states, containerID)
+}
+
+// soft returns the status to report. A worse status is only reported once it lasted for softPeriod,
+// a better one is reported immediately.
+func (s *healthState) soft(status types.StatusDescription, softPeriod time.Duration, now time.Time) types.StatusDescription {
+	if softPeriod <= 0 || !s.reported.CurrentStatus.IsSet() || status.CurrentStatus.NagiosCode() <= s.reported.CurrentStatus.NagiosCode() {
+		s.reported = status
+		s.pending = types.StatusUnset
+
+		return status
+	}
+
+	if s.pending != status.CurrentStatus {
+		s.pending = status.CurrentStatus
+		s.pendingSince = now
+	}
+
+	if now.Sub(s.pendingSince) < softPeriod {
+		return s.reported
+	}
+
+	s.reported = status
+	s.pending = types.StatusUnset
+
+	return status
+}
+
+// containerHealthStatus converts the container state and the healthcheck result to a status.
+func containerHealthStatus(state string, health string, output string, startedAt time.Time, startPeriod time.Duration, now time.Time) types.StatusDescription {
+	status := types.StatusDescription{
+		StatusDescription: output,
+	}
+
+	if startPeriod <= 0 {
+		startPeriod = defaultStartPeriod
+	}
+
+	switch {
+	case state == "paused":
+		status.CurrentStatus = types.StatusCritical
+		status.StatusDescription = "Container paused"
+	case state != "running":
+		status.CurrentStatus = types.StatusCritical
+		status.StatusDescription = "Container stopped"
+	case health == "healthy":
+		status.CurrentStatus = types.StatusOk
+	case health == "starting":
+		if startedAt.IsZero() || now.Sub(startedAt) < startPeriod {
+			status.CurrentStatus = types.StatusOk
+		} else {
+			status.CurrentStatus = types.StatusWarning
+			status.StatusDescription = "Container is still starting"
+		}
+	case health == "unhealthy":
+		status.CurrentStatus = types.StatusCritical
+	default:
+		status.CurrentStatus = types.StatusUnknown
+		status.StatusDescription = fmt.Sprintf("Unknown health status %#v", health)
+	}
+
+	return status
+}
@@ -0,0 +1,88 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"glouton/types"
+	"testing"
+	"time"
+)
+
+func TestContainerHealthStatus(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name        string
+		state       string
+		health      string
+		startedAt   time.Time
+		startPeriod time.Duration
+		want        types.Status
+	}{
+		{name: "healthy", state: "running", health: "healthy", want: types.StatusOk},
+		{name: "unhealthy", state: "running", health: "unhealthy", want: types.StatusCritical},
+		{name: "paused", state: "paused", health: "healthy", want: types.StatusCritical},
+		{name: "stopped", state: "exited", health: "unhealthy", want: types.StatusCritical},
+		{name: "starting", state: "running", health: "starting", startedAt: now.Add(-30 * time.Second), want: types.StatusOk},
+		{name: "starting-too-long", state: "running", health: "starting", startedAt: now.Add(-2 * time.Minute), want: types.StatusWarning},
+		{
+			name:        "starting-start-period",
+			state:       "running",
+			health:      "starting",
+			startedAt:   now.Add(-2 * time.Minute),
+			startPeriod: 5 * time.Minute,
+			want:        types.StatusOk,
+		},
+		{name: "unknown", state: "running", health: "other", want: types.StatusUnknown},
+	}
+
+	for _, c := range cases {
+		got := containerHealthStatus(c.state, c.health, "output", c.startedAt, c.startPeriod, now)
+		if got.CurrentStatus != c.want {
+			t.Errorf("%s: status = %v, want %v", c.name, got.CurrentStatus, c.want)
+		}
+	}
+}
+
+func TestHealthSoftPeriod(t *testing.T) {
+	var state healthState
+
+	now := time.Now()
+	ok := types.StatusDescription{CurrentStatus: types.StatusOk}
+	critical := types.StatusDescription{CurrentStatus: types.StatusCritical, StatusDescription: "probe failed"}
+
+	steps := []struct {
+		delay  time.Duration
+		status types.StatusDescription
+		want   types.Status
+	}{
+		{delay: 0, status: ok, want: types.StatusOk},
+		{delay: time.Minute, status: critical, want: types.StatusOk},
+		{delay: 2 * time.Minute, status: critical, want: types.StatusOk},
+		{delay: 7 * time.Minute, status: critical, want: types.StatusCritical},
+		{delay: 8 * time.Minute, status: ok, want: types.StatusOk},
+		// The soft period restart after a recovery.
+		{delay: 9 * time.Minute, status: critical, want: types.StatusOk},
+	}
+
+	for i, s := range steps {
+		got := state.soft(s.status, 5*time.Minute, now.Add(s.delay))
+		if got.CurrentStatus != s.want {
+			t.Errorf("step %d: status = %v, want %v", i, got.CurrentStatus, s.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"glouton/inputs"
+	"glouton/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileCheckConfig is the configuration of a file or directory check.
+type FileCheckConfig struct {
+	// ID is the item of the status metric, it defaults to the path.
+	ID string `yaml:"id"`
+	// Path is the file or directory to check. It could be a glob pattern,
+	// the most recently modified match is checked.
+	Path string `yaml:"path"`
+	// MaxAge is the maximum age in seconds of the file, or of the most recent
+	// file in the directory.
+	MaxAge int `yaml:"max_age"`
+	// MinSize and MaxSize are the bounds in bytes of the file size, or of the
+	// total size of the directory.
+	MinSize int64 `yaml:"min_size"`
+	MaxSize int64 `yaml:"max_size"`
+	// SHA256 is the expected checksum of the file.
+	SHA256 string `yaml:"sha256"`
+}
+
+// ParseFileChecks converts the file_checks configuration.
+func ParseFileChecks(config interface{}) ([]FileCheckConfig, error) {
+	var checks []FileCheckConfig
+
+	marshalled, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(marshalled, &checks); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(checks))
+
+	for i := range checks {
+		c := &checks[i]
+
+		if c.Path == "" {
+			return nil, errors.New("a file check has no path")
+		}
+
+		if _, err := filepath.Match(c.Path, ""); err != nil {
+			return nil, fmt.Errorf("file check %s: %w", c.Path, err)
+		}
+
+		if c.ID == "" {
+			c.ID = c.Path
+		}
+
+		if ids[c.ID] {
+			return nil, fmt.Errorf("the file check %s is defined twice", c.ID)
+		}
+
+		ids[c.ID] = true
+
+		if c.MaxSize > 0 && c.MaxSize < c.MinSize {
+			return nil, fmt.Errorf("file check %s: max_size is lower than min_size", c.ID)
+		}
+
+		c.SHA256 = strings.ToLower(c.SHA256)
+	}
+
+	return checks, nil
+}
+
+// FileCheck checks the existence, age, size and checksum of a file or directory.
+type FileCheck struct {
+	*baseCheck
+
+	config FileCheckConfig
+}
+
+// NewFile create a new file check.
+func NewFile(config FileCheckConfig, labels map[string]string, annotations types.MetricAnnotations, acc inputs.AnnotationAccumulator) *FileCheck {
+	fc := &FileCheck{
+		config: config,
+	}
+
+	fc.baseCheck = newBase("", nil, false, fc.doCheck, labels, annotations, acc)
+
+	return fc
+}
+
+func (fc *FileCheck) doCheck(ctx context.Context) types.StatusDescription {
+	return checkFile(ctx, fc.config, time.Now())
+}
+
+// fileInfo is the result of the inspection of a file or directory.
+type fileInfo struct {
+	path    string
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+func checkFile(ctx context.Context, config FileCheckConfig, now time.Time) types.StatusDescription {
+	info, err := newestMatch(config.Path)
+	if err != nil {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: err.Error(),
+		}
+	}
+
+	if info.isDir {
+		info, err = inspectDirectory(ctx, info)
+		if err != nil {
+			return types.StatusDescription{
+				CurrentStatus:     types.StatusUnknown,
+				StatusDescription: fmt.Sprintf("Unable to read directory %s: %v", info.path, err),
+			}
+		}
+	}
+
+	age := now.Sub(info.modTime).Truncate(time.Second)
+
+	if config.MaxAge > 0 && age > time.Duration(config.MaxAge)*time.Second {
+		return types.StatusDescription{
+			CurrentStatus: types.StatusCritical,
+			StatusDescription: fmt.Sprintf(
+				"%s was modified %v ago, more than %v ago",
+				info.path, age, time.Duration(config.MaxAge)*time.Second,
+			),
+		}
+	}
+
+	if config.MinSize > 0 && info.size < config.MinSize {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("%s size is %d bytes, less than %d bytes", info.path, info.size, config.MinSize),
+		}
+	}
+
+	if config.MaxSize > 0 && info.size > config.MaxSize {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("%s size is %d bytes, more than %d bytes", info.path, info.size, config.MaxSize),
+		}
+	}
+
+	if config.SHA256 != "" {
+		if info.isDir {
+			return types.StatusDescription{
+				CurrentStatus:     types.StatusUnknown,
+				StatusDescription: fmt.Sprintf("%s is a directory, its checksum can't be verified", info.path),
+			}
+		}
+
+		sum, err := fileSHA256(info.path)
+		if err != nil {
+			return types.StatusDescription{
+				CurrentStatus:     types.StatusUnknown,
+				StatusDescription: fmt.Sprintf("Unable to read %s: %v", info.path, err),
+			}
+		}
+
+		if sum != config.SHA256 {
+			return types.StatusDescription{
+				CurrentStatus:     types.StatusCritical,
+				StatusDescription: fmt.Sprintf("%s checksum is %s, expected %s", info.path, sum, config.SHA256),
+			}
+		}
+	}
+
+	return types.StatusDescription{
+		CurrentStatus:     types.StatusOk,
+		StatusDescription: fmt.Sprintf("%s has %d bytes and was modified %v ago", info.path, info.size, age),
+	}
+}
+
+// newestMatch returns the most recently modified file or directory matching pattern.
+func newestMatch(pattern string) (fileInfo, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fileInfo{}, err
+	}
+
+	var newest fileInfo
+
+	for _, path := range matches {
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if newest.path == "" || stat.ModTime().After(newest.modTime) {
+			newest = fileInfo{
+				path:    path,
+				isDir:   stat.IsDir(),
+				size:    stat.Size(),
+				modTime: stat.ModTime(),
+			}
+		}
+	}
+
+	if newest.path == "" {
+		return newest, fmt.Errorf("%s doesn't exist", pattern)
+	}
+
+	return newest, nil
+}
+
+// inspectDirectory returns the directory with its total size and the modification
+// time of its most recent file.
+func inspectDirectory(ctx context.Context, dir fileInfo) (fileInfo, error) {
+	result := fileInfo{
+		path:    dir.path,
+		isDir:   true,
+		modTime: dir.modTime,
+	}
+
+	err := filepath.Walk(dir.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		result.size += info.Size()
+
+		if info.ModTime().After(result.modTime) {
+			result.modTime = info.ModTime()
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"glouton/types"
+)
+
+func TestCheckFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filecheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	for name, modTime := range map[string]time.Time{"backup-1.tar": old, "backup-2.tar": now} {
+		path := filepath.Join(dir, "backups", name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte("test"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		config FileCheckConfig
+		want   types.Status
+	}{
+		{name: "missing", config: FileCheckConfig{Path: filepath.Join(dir, "missing")}, want: types.StatusCritical},
+		{name: "newest", config: FileCheckConfig{Path: filepath.Join(dir, "backups", "*.tar"), MaxAge: 3600}, want: types.StatusOk},
+		{name: "too-old", config: FileCheckConfig{Path: filepath.Join(dir, "backups", "backup-1.tar"), MaxAge: 3600}, want: types.StatusCritical},
+		{name: "dir-size", config: FileCheckConfig{Path: filepath.Join(dir, "backups"), MaxSize: 7}, want: types.StatusCritical},
+		{name: "dir-ok", config: FileCheckConfig{Path: filepath.Join(dir, "backups"), MinSize: 8, MaxAge: 3600}, want: types.StatusOk},
+		{
+			name: "checksum",
+			config: FileCheckConfig{
+				Path:   filepath.Join(dir, "backups", "backup-2.tar"),
+				SHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			},
+			want: types.StatusOk,
+		},
+		{
+			name:   "bad-checksum",
+			config: FileCheckConfig{Path: filepath.Join(dir, "backups", "backup-2.tar"), SHA256: "00"},
+			want:   types.StatusCritical,
+		},
+	}
+
+	for _, c := range cases {
+		got := checkFile(context.Background(), c.config, now)
+		if got.CurrentStatus != c.want {
+			t.Errorf("%s: status = %v (%s), want %v", c.name, got.CurrentStatus, got.StatusDescription, c.want)
+		}
+	}
+}
@@ -20,8 +20,12 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	"glouton/inputs"
@@ -30,12 +34,35 @@ import (
 	"glouton/version"
 )
 
+// maxBodySize is the maximum number of bytes of the response read to search the expected content.
+const maxBodySize = 1024 * 1024
+
+// HTTPOptions are the optional settings of an HTTP check.
+type HTTPOptions struct {
+	// Method is the HTTP method used. It defaults to GET, or POST when Body is set.
+	Method  string
+	Body    string
+	Headers map[string]string
+	// Username and Password enable the basic authentication.
+	Username string
+	Password string
+	// BearerToken is sent in the Authorization header.
+	BearerToken string
+	// ExpectedContent and ExpectedRegex must be found in the response, or result will be critical.
+	ExpectedContent string
+	ExpectedRegex   *regexp.Regexp
+	// The result is warning or critical when the response time reaches these values. Zero disables them.
+	ResponseTimeWarning  time.Duration
+	ResponseTimeCritical time.Duration
+}
+
 // HTTPCheck perform a HTTP check.
 type HTTPCheck struct {
 	*baseCheck
 
 	url                string
 	expectedStatusCode int
+	options            HTTPOptions
 	client             *http.Client
 }
 
@@ -46,7 +73,9 @@ type HTTPCheck struct {
 //
 // If expectedStatusCode is 0, StatusCode below 400 will generate Ok, between 400 and 499 => warning and above 500 => critical
 // If expectedStatusCode is not 0, StatusCode must match the value or result will be critical.
-func NewHTTP(urlValue string, persitentAddresses []string, persistentConnection bool, expectedStatusCode int, labels map[string]string, annotations types.MetricAnnotations, acc inputs.AnnotationAccumulator) *HTTPCheck {
+//
+// Each run also emits the metric service_http_response_time, in seconds.
+func NewHTTP(urlValue string, persitentAddresses []string, persistentConnection bool, expectedStatusCode int, options HTTPOptions, labels map[string]string, annotations types.MetricAnnotations, acc inputs.AnnotationAccumulator) *HTTPCheck {
 	myTransport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		TLSClientConfig: &tls.Config{
@@ -69,6 +98,7 @@ func NewHTTP(urlValue string, persitentAddresses []string, persistentConnection
 	hc := &HTTPCheck{
 		url:                urlValue,
 		expectedStatusCode: expectedStatusCode,
+		options:            options,
 		client: &http.Client{
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
@@ -83,9 +113,20 @@ func NewHTTP(urlValue string, persitentAddresses []string, persistentConnection
 }
 
 func (hc *HTTPCheck) doCheck(ctx context.Context) types.StatusDescription {
-	req, err := http.NewRequest("GET", hc.url, nil)
-	req.Header.Add("User-Agent", version.UserAgent())
+	method := hc.options.Method
+	if method == "" && hc.options.Body != "" {
+		method = http.MethodPost
+	} else if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
 
+	if hc.options.Body != "" {
+		body = strings.NewReader(hc.options.Body)
+	}
+
+	req, err := http.NewRequest(method, hc.url, body)
 	if err != nil {
 		logger.V(2).Printf("Unable to create HTTP Request: %v", err)
 
@@ -95,9 +136,25 @@ func (hc *HTTPCheck) doCheck(ctx context.Context) types.StatusDescription {
 		}
 	}
 
+	req.Header.Add("User-Agent", version.UserAgent())
+
+	for name, value := range hc.options.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if hc.options.Username != "" || hc.options.Password != "" {
+		req.SetBasicAuth(hc.options.Username, hc.options.Password)
+	}
+
+	if hc.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+hc.options.BearerToken)
+	}
+
 	ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
+	start := time.Now()
+
 	resp, err := hc.client.Do(req.WithContext(ctx2))
 	if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
 		return types.StatusDescription{
@@ -115,6 +172,21 @@ func (hc *HTTPCheck) doCheck(ctx context.Context) types.StatusDescription {
 
 	defer resp.Body.Close()
 
+	var content []byte
+
+	if hc.options.ExpectedContent != "" || hc.options.ExpectedRegex != nil {
+		content, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+		if err != nil {
+			return types.StatusDescription{
+				CurrentStatus:     types.StatusCritical,
+				StatusDescription: "HTTP CRITICAL - unable to read the response: " + err.Error(),
+			}
+		}
+	}
+
+	responseTime := time.Since(start)
+	hc.addResponseTime(responseTime)
+
 	if hc.expectedStatusCode != 0 && resp.StatusCode != hc.expectedStatusCode {
 		return types.StatusDescription{
 			CurrentStatus:     types.StatusCritical,
@@ -129,6 +201,27 @@ func (hc *HTTPCheck) doCheck(ctx context.Context) types.StatusDescription {
 		}
 	}
 
+	if hc.options.ExpectedContent != "" && !strings.Contains(string(content), hc.options.ExpectedContent) {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("HTTP CRITICAL - http_code=%d, string %#v not found in response", resp.StatusCode, hc.options.ExpectedContent),
+		}
+	}
+
+	if hc.options.ExpectedRegex != nil && !hc.options.ExpectedRegex.Match(content) {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("HTTP CRITICAL - http_code=%d, pattern %#v not found in response", resp.StatusCode, hc.options.ExpectedRegex.String()),
+		}
+	}
+
+	if hc.options.ResponseTimeCritical > 0 && responseTime >= hc.options.ResponseTimeCritical {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("HTTP CRITICAL - http_code=%d, response time %.3fs", resp.StatusCode, responseTime.Seconds()),
+		}
+	}
+
 	if hc.expectedStatusCode == 0 && resp.StatusCode >= 400 {
 		return types.StatusDescription{
 			CurrentStatus:     types.StatusWarning,
@@ -136,8 +229,40 @@ func (hc *HTTPCheck) doCheck(ctx context.Context) types.StatusDescription {
 		}
 	}
 
+	if hc.options.ResponseTimeWarning > 0 && responseTime >= hc.options.ResponseTimeWarning {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusWarning,
+			StatusDescription: fmt.Sprintf("HTTP WARN - http_code=%d, response time %.3fs", resp.StatusCode, responseTime.Seconds()),
+		}
+	}
+
 	return types.StatusDescription{
 		CurrentStatus:     types.StatusOk,
-		StatusDescription: fmt.Sprintf("HTTP OK - http_code=%d", resp.StatusCode),
+		StatusDescription: fmt.Sprintf("HTTP OK - http_code=%d, response time %.3fs", resp.StatusCode, responseTime.Seconds()),
 	}
 }
+
+// addResponseTime emits the response time of the check, labeled with the service name.
+func (hc *HTTPCheck) addResponseTime(responseTime time.Duration) {
+	labels := make(map[string]string, len(hc.labels)+1)
+
+	for k, v := range hc.labels {
+		labels[k] = v
+	}
+
+	if hc.annotations.ServiceName != "" {
+		labels[types.LabelService] = hc.annotations.ServiceName
+	}
+
+	annotations := hc.annotations
+	annotations.Unit = types.UnitSeconds
+
+	hc.acc.AddFieldsWithAnnotations(
+		"",
+		map[string]interface{}{
+			"service_http_response_time": responseTime.Seconds(),
+		},
+		labels,
+		annotations,
+	)
+}
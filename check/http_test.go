@@ -0,0 +1,117 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"glouton/inputs"
+	"glouton/types"
+)
+
+func TestHTTPCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); ok && (user != "admin" || password != "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		if r.Header.Get("Authorization") == "Bearer bad-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		_, _ = w.Write([]byte("method=" + r.Method + " body=" + string(body) + " header=" + r.Header.Get("X-Test")))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name    string
+		path    string
+		options HTTPOptions
+		want    types.Status
+	}{
+		{name: "default", want: types.StatusOk},
+		{name: "basic-auth", options: HTTPOptions{Username: "admin", Password: "secret"}, want: types.StatusOk},
+		{name: "bad-basic-auth", options: HTTPOptions{Username: "admin", Password: "wrong"}, want: types.StatusWarning},
+		{name: "bad-bearer", options: HTTPOptions{BearerToken: "bad-token"}, want: types.StatusWarning},
+		{name: "content", options: HTTPOptions{ExpectedContent: "method=GET"}, want: types.StatusOk},
+		{name: "content-missing", options: HTTPOptions{ExpectedContent: "not-here"}, want: types.StatusCritical},
+		{
+			name:    "post-body",
+			options: HTTPOptions{Body: `{"ping": 1}`, ExpectedContent: `method=POST body={"ping": 1}`},
+			want:    types.StatusOk,
+		},
+		{
+			name:    "header-regex",
+			options: HTTPOptions{Headers: map[string]string{"X-Test": "value-42"}, ExpectedRegex: regexp.MustCompile(`header=value-\d+`)},
+			want:    types.StatusOk,
+		},
+		{name: "regex-missing", options: HTTPOptions{ExpectedRegex: regexp.MustCompile(`^body`)}, want: types.StatusCritical},
+		{name: "slow-warning", path: "/slow", options: HTTPOptions{ResponseTimeWarning: 100 * time.Millisecond}, want: types.StatusWarning},
+		{
+			name:    "slow-critical",
+			path:    "/slow",
+			options: HTTPOptions{ResponseTimeWarning: 50 * time.Millisecond, ResponseTimeCritical: 100 * time.Millisecond},
+			want:    types.StatusCritical,
+		},
+	}
+
+	for _, c := range cases {
+		pusher := &mockPusher{points: make(map[string]types.MetricPoint)}
+		hc := NewHTTP(
+			srv.URL+c.path,
+			nil,
+			false,
+			0,
+			c.options,
+			map[string]string{types.LabelName: "web_status"},
+			types.MetricAnnotations{ServiceName: "web"},
+			&inputs.Accumulator{Pusher: pusher},
+		)
+
+		got := hc.doCheck(context.Background())
+		if got.CurrentStatus != c.want {
+			t.Errorf("%s: status = %v (%s), want %v", c.name, got.CurrentStatus, got.StatusDescription, c.want)
+		}
+
+		point, ok := pusher.points["service_http_response_time"]
+		if !ok {
+			t.Errorf("%s: service_http_response_time wasn't emitted", c.name)
+
+			continue
+		}
+
+		if point.Labels[types.LabelService] != "web" {
+			t.Errorf("%s: service label = %#v, want %#v", c.name, point.Labels[types.LabelService], "web")
+		}
+	}
+}
@@ -0,0 +1,446 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"glouton/inputs"
+	"glouton/logger"
+	"glouton/types"
+)
+
+// Protocols supported by the mail checks.
+const (
+	MailSMTP = "smtp"
+	MailIMAP = "imap"
+	MailPOP3 = "pop3"
+)
+
+// MailOptions are the optional settings of a mail check.
+type MailOptions struct {
+	// Username and Password are used to login when set.
+	Username string
+	Password string
+	// STARTTLS is done when the server supports it. If RequireStartTLS is set, the result
+	// will be critical when the server doesn't support it.
+	RequireStartTLS bool
+}
+
+// MailCheck perform a SMTP, IMAP or POP3 check.
+//
+// The check does the full handshake: greeting, capabilities (EHLO for SMTP),
+// STARTTLS and login. The duration of each step is emitted in the metric
+// service_mail_step_time, in seconds, with the step as label.
+type MailCheck struct {
+	*baseCheck
+	protocol    string
+	mainAddress string
+	options     MailOptions
+}
+
+// NewMail create a new mail check for the protocol MailSMTP, MailIMAP or MailPOP3.
+//
+// All addresses use the format "IP:port".
+//
+// For each persitentAddresses this checker will maintain a TCP connection open, if broken (and unable to re-open), the check will
+// be immediately run.
+func NewMail(protocol string, address string, persitentAddresses []string, persistentConnection bool, options MailOptions, labels map[string]string, annotations types.MetricAnnotations, acc inputs.AnnotationAccumulator) *MailCheck {
+	mc := &MailCheck{
+		protocol:    protocol,
+		mainAddress: address,
+		options:     options,
+	}
+
+	mc.baseCheck = newBase("", persitentAddresses, persistentConnection, mc.doCheck, labels, annotations, acc)
+
+	return mc
+}
+
+// mailSession is the state of one run of a mail check.
+type mailSession struct {
+	options MailOptions
+	host    string
+	conn    net.Conn
+	reader  *bufio.Reader
+	start   time.Time
+	steps   []mailStep
+}
+
+type mailStep struct {
+	name     string
+	duration time.Duration
+}
+
+// step records the duration since the previous step.
+func (s *mailSession) step(name string) {
+	now := time.Now()
+	s.steps = append(s.steps, mailStep{name: name, duration: now.Sub(s.start)})
+	s.start = now
+}
+
+func (mc *MailCheck) doCheck(ctx context.Context) types.StatusDescription {
+	if mc.mainAddress == "" {
+		return types.StatusDescription{
+			CurrentStatus: types.StatusOk,
+		}
+	}
+
+	host, _, err := net.SplitHostPort(mc.mainAddress)
+	if err != nil {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusUnknown,
+			StatusDescription: fmt.Sprintf("Invalid TCP address %#v", mc.mainAddress),
+		}
+	}
+
+	protocolName := strings.ToUpper(mc.protocol)
+	start := time.Now()
+
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx2, "tcp", mc.mainAddress)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: "Connection timed out after 10 seconds",
+		}
+	} else if err != nil {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("Unable to connect to %s server: connection refused", protocolName),
+		}
+	}
+
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err != nil {
+		logger.V(1).Printf("Unable to set Deadline: %v", err)
+
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusUnknown,
+			StatusDescription: "Checker error. Unable to set Deadline",
+		}
+	}
+
+	session := &mailSession{
+		options: mc.options,
+		host:    host,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		start:   start,
+	}
+
+	session.step("connect")
+
+	switch mc.protocol {
+	case MailSMTP:
+		err = session.smtp()
+	case MailIMAP:
+		err = session.imap()
+	case MailPOP3:
+		err = session.pop3()
+	default:
+		err = fmt.Errorf("unknown protocol %#v", mc.protocol)
+	}
+
+	mc.addStepTimes(session.steps)
+
+	if err != nil {
+		return types.StatusDescription{
+			CurrentStatus:     types.StatusCritical,
+			StatusDescription: fmt.Sprintf("%s error: %v", protocolName, err),
+		}
+	}
+
+	return types.StatusDescription{
+		CurrentStatus:     types.StatusOk,
+		StatusDescription: fmt.Sprintf("%s OK - %v response time", protocolName, time.Since(start)),
+	}
+}
+
+// addStepTimes emits the duration of each step, labeled with the service name and the step.
+func (mc *MailCheck) addStepTimes(steps []mailStep) {
+	for _, s := range steps {
+		labels := make(map[string]string, len(mc.labels)+2)
+
+		for k, v := range mc.labels {
+			labels[k] = v
+		}
+
+		if mc.annotations.ServiceName != "" {
+			labels[types.LabelService] = mc.annotations.ServiceName
+		}
+
+		labels["step"] = s.name
+
+		annotations := mc.annotations
+		annotations.Unit = types.UnitSeconds
+
+		mc.acc.AddFieldsWithAnnotations(
+			"",
+			map[string]interface{}{
+				"service_mail_step_time": s.duration.Seconds(),
+			},
+			labels,
+			annotations,
+		)
+	}
+}
+
+func (s *mailSession) tlsConfig() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec // the check is about the service, not its certificate.
+		ServerName:         s.host,
+	}
+}
+
+// errNoStartTLS is returned when STARTTLS is required but not supported by the server.
+var errNoStartTLS = errors.New("STARTTLS isn't supported by the server")
+
+func (s *mailSession) smtp() error {
+	cl, err := smtp.NewClient(s.conn, s.host)
+	if err != nil {
+		return err
+	}
+
+	s.step("greeting")
+
+	if err := cl.Hello("localhost"); err != nil {
+		return err
+	}
+
+	s.step("ehlo")
+
+	if ok, _ := cl.Extension("STARTTLS"); ok {
+		if err := cl.StartTLS(s.tlsConfig()); err != nil {
+			return err
+		}
+
+		s.step("starttls")
+	} else if s.options.RequireStartTLS {
+		return errNoStartTLS
+	}
+
+	if s.options.Username != "" {
+		if err := cl.Auth(smtp.PlainAuth("", s.options.Username, s.options.Password, s.host)); err != nil {
+			return err
+		}
+
+		s.step("login")
+	}
+
+	return cl.Quit()
+}
+
+// readLine returns the next line without the trailing CRLF.
+func (s *mailSession) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (s *mailSession) writeLine(line string) error {
+	_, err := fmt.Fprintf(s.conn, "%s\r\n", line)
+
+	return err
+}
+
+// startTLS switches the connection to TLS after the server accepted STARTTLS.
+func (s *mailSession) startTLS() error {
+	tlsConn := tls.Client(s.conn, s.tlsConfig())
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	s.conn = tlsConn
+	s.reader = bufio.NewReader(tlsConn)
+
+	return nil
+}
+
+// imapCommand sends a tagged command and returns the untagged responses.
+func (s *mailSession) imapCommand(tag string, command string) ([]string, error) {
+	if err := s.writeLine(tag + " " + command); err != nil {
+		return nil, err
+	}
+
+	var untagged []string
+
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(line, tag+" ") {
+			untagged = append(untagged, line)
+
+			continue
+		}
+
+		if !strings.HasPrefix(line, tag+" OK") {
+			return nil, fmt.Errorf("unexpected response to %s: %s", strings.Fields(command)[0], strings.TrimPrefix(line, tag+" "))
+		}
+
+		return untagged, nil
+	}
+}
+
+// imapQuote returns the value as an IMAP quoted string.
+func imapQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func (s *mailSession) imap() error {
+	greeting, err := s.readLine()
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("unexpected greeting: %s", greeting)
+	}
+
+	s.step("greeting")
+
+	untagged, err := s.imapCommand("a1", "CAPABILITY")
+	if err != nil {
+		return err
+	}
+
+	s.step("capability")
+
+	if strings.Contains(strings.Join(untagged, " "), "STARTTLS") {
+		if _, err := s.imapCommand("a2", "STARTTLS"); err != nil {
+			return err
+		}
+
+		if err := s.startTLS(); err != nil {
+			return err
+		}
+
+		s.step("starttls")
+	} else if s.options.RequireStartTLS {
+		return errNoStartTLS
+	}
+
+	if s.options.Username != "" {
+		if _, err := s.imapCommand("a3", "LOGIN "+imapQuote(s.options.Username)+" "+imapQuote(s.options.Password)); err != nil {
+			return errors.New("login failed")
+		}
+
+		s.step("login")
+	}
+
+	_, err = s.imapCommand("a4", "LOGOUT")
+
+	return err
+}
+
+// pop3Command sends a command and returns the error of a negative response.
+func (s *mailSession) pop3Command(command string) (string, error) {
+	if err := s.writeLine(command); err != nil {
+		return "", err
+	}
+
+	line, err := s.readLine()
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(line, "+OK") {
+		return "", fmt.Errorf("unexpected response to %s: %s", strings.Fields(command)[0], line)
+	}
+
+	return line, nil
+}
+
+func (s *mailSession) pop3() error {
+	greeting, err := s.readLine()
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(greeting, "+OK") {
+		return fmt.Errorf("unexpected greeting: %s", greeting)
+	}
+
+	s.step("greeting")
+
+	var capabilities []string
+
+	// CAPA is optional, servers which don't support it reply with -ERR.
+	if _, err := s.pop3Command("CAPA"); err == nil {
+		for {
+			line, err := s.readLine()
+			if err != nil {
+				return err
+			}
+
+			if line == "." {
+				break
+			}
+
+			capabilities = append(capabilities, line)
+		}
+	}
+
+	s.step("capability")
+
+	if strings.Contains(strings.Join(capabilities, " "), "STLS") {
+		if _, err := s.pop3Command("STLS"); err != nil {
+			return err
+		}
+
+		if err := s.startTLS(); err != nil {
+			return err
+		}
+
+		s.step("starttls")
+	} else if s.options.RequireStartTLS {
+		return errNoStartTLS
+	}
+
+	if s.options.Username != "" {
+		if _, err := s.pop3Command("USER " + s.options.Username); err != nil {
+			return errors.New("login failed")
+		}
+
+		if _, err := s.pop3Command("PASS " + s.options.Password); err != nil {
+			return errors.New("login failed")
+		}
+
+		s.step("login")
+	}
+
+	_, err = s.pop3Command("QUIT")
+
+	return err
+}
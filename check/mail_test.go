@@ -0,0 +1,146 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"glouton/inputs"
+	"glouton/types"
+)
+
+// mailServer serves a scripted mail protocol: after the greeting, each command
+// line receives the response of its first matching prefix.
+func mailServer(t *testing.T, greeting string, responses map[string]string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				_, _ = conn.Write([]byte(greeting + "\r\n"))
+
+				reader := bufio.NewReader(conn)
+
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+
+					line = strings.TrimRight(line, "\r\n")
+					response := "-ERR unknown command\r\n"
+
+					for prefix, r := range responses {
+						if strings.HasPrefix(line, prefix) {
+							response = r
+						}
+					}
+
+					_, _ = conn.Write([]byte(response))
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestMailCheck(t *testing.T) {
+	imapAddress := mailServer(t, "* OK IMAP4rev1 ready", map[string]string{
+		"a1 CAPABILITY":            "* CAPABILITY IMAP4rev1 AUTH=PLAIN\r\na1 OK done\r\n",
+		`a3 LOGIN "user" "secret"`: "a3 OK logged in\r\n",
+		`a3 LOGIN "user" "bad"`:    "a3 NO authentication failed\r\n",
+		"a4 LOGOUT":                "* BYE\r\na4 OK bye\r\n",
+	})
+	pop3Address := mailServer(t, "+OK POP3 ready", map[string]string{
+		"CAPA":        "+OK\r\nUSER\r\n.\r\n",
+		"USER user":   "+OK\r\n",
+		"PASS secret": "+OK logged in\r\n",
+		"QUIT":        "+OK bye\r\n",
+	})
+	smtpAddress := mailServer(t, "220 mail.example.com ESMTP", map[string]string{
+		"EHLO": "250-mail.example.com\r\n250 8BITMIME\r\n",
+		"QUIT": "221 bye\r\n",
+	})
+
+	cases := []struct {
+		name      string
+		protocol  string
+		address   string
+		options   MailOptions
+		want      types.Status
+		wantLogin bool
+	}{
+		{name: "imap", protocol: MailIMAP, address: imapAddress, want: types.StatusOk},
+		{name: "imap-login", protocol: MailIMAP, address: imapAddress, options: MailOptions{Username: "user", Password: "secret"}, want: types.StatusOk, wantLogin: true},
+		{name: "imap-bad-login", protocol: MailIMAP, address: imapAddress, options: MailOptions{Username: "user", Password: "bad"}, want: types.StatusCritical},
+		{name: "imap-starttls", protocol: MailIMAP, address: imapAddress, options: MailOptions{RequireStartTLS: true}, want: types.StatusCritical},
+		{name: "pop3-login", protocol: MailPOP3, address: pop3Address, options: MailOptions{Username: "user", Password: "secret"}, want: types.StatusOk, wantLogin: true},
+		{name: "pop3-bad-login", protocol: MailPOP3, address: pop3Address, options: MailOptions{Username: "user", Password: "bad"}, want: types.StatusCritical},
+		{name: "smtp", protocol: MailSMTP, address: smtpAddress, want: types.StatusOk},
+		{name: "smtp-starttls", protocol: MailSMTP, address: smtpAddress, options: MailOptions{RequireStartTLS: true}, want: types.StatusCritical},
+		{name: "wrong-protocol", protocol: MailPOP3, address: imapAddress, want: types.StatusCritical},
+	}
+
+	for _, c := range cases {
+		steps := make(map[string]bool)
+		acc := &inputs.Accumulator{Pusher: pusherFunc(func(points []types.MetricPoint) {
+			for _, p := range points {
+				steps[p.Labels["step"]] = true
+			}
+		})}
+
+		mc := NewMail(c.protocol, c.address, nil, false, c.options, map[string]string{types.LabelName: "mail_status"}, types.MetricAnnotations{ServiceName: "mail"}, acc)
+
+		got := mc.doCheck(context.Background())
+		if got.CurrentStatus != c.want {
+			t.Errorf("%s: status = %v (%s), want %v", c.name, got.CurrentStatus, got.StatusDescription, c.want)
+		}
+
+		if !steps["connect"] {
+			t.Errorf("%s: the connect step wasn't emitted", c.name)
+		}
+
+		if steps["login"] != c.wantLogin {
+			t.Errorf("%s: login step emitted = %v, want %v", c.name, steps["login"], c.wantLogin)
+		}
+	}
+}
+
+type pusherFunc func(points []types.MetricPoint)
+
+func (f pusherFunc) PushPoints(points []types.MetricPoint) {
+	f(points)
+}
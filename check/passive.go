@@ -0,0 +1,120 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"glouton/inputs"
+	"glouton/types"
+)
+
+// PassiveCheck is a check whose results are submitted by an external program.
+//
+// The last submitted status is emitted again every minute. If a freshness is
+// set and no result is submitted during this delay, the check become unknown.
+type PassiveCheck struct {
+	name      string
+	freshness time.Duration
+	acc       inputs.AnnotationAccumulator
+
+	l          sync.Mutex
+	lastSubmit time.Time
+	lastStatus types.StatusDescription
+}
+
+// NewPassive create a new passive check.
+//
+// The status is emitted as "<name>_status" and submitted metrics as "<name>_<metric>".
+func NewPassive(name string, freshness time.Duration, acc inputs.AnnotationAccumulator) *PassiveCheck {
+	return &PassiveCheck{
+		name:       name,
+		freshness:  freshness,
+		acc:        acc,
+		lastSubmit: time.Now(),
+		lastStatus: types.StatusDescription{
+			CurrentStatus:     types.StatusUnknown,
+			StatusDescription: "No result received yet",
+		},
+	}
+}
+
+// Submit emit a result for this check.
+//
+// Metrics don't have a status, so the thresholds apply to them.
+func (pc *PassiveCheck) Submit(status types.StatusDescription, metrics map[string]float64) {
+	pc.l.Lock()
+	pc.lastSubmit = time.Now()
+	pc.lastStatus = status
+	pc.l.Unlock()
+
+	pc.emitStatus(status)
+
+	if len(metrics) > 0 {
+		fields := make(map[string]interface{}, len(metrics))
+
+		for k, v := range metrics {
+			fields[k] = v
+		}
+
+		pc.acc.AddFieldsWithAnnotations(pc.name, fields, nil, types.MetricAnnotations{})
+	}
+}
+
+// Run emit the status every minute until ctx is cancelled.
+func (pc *PassiveCheck) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+
+		pc.l.Lock()
+
+		since := time.Since(pc.lastSubmit)
+		if pc.freshness > 0 && since > pc.freshness {
+			pc.lastStatus = types.StatusDescription{
+				CurrentStatus:     types.StatusUnknown,
+				StatusDescription: fmt.Sprintf("No result received for the last %v", since.Truncate(time.Second)),
+			}
+		}
+
+		status := pc.lastStatus
+
+		pc.l.Unlock()
+
+		pc.emitStatus(status)
+	}
+}
+
+func (pc *PassiveCheck) emitStatus(status types.StatusDescription) {
+	pc.acc.AddFieldsWithAnnotations(
+		"",
+		map[string]interface{}{
+			pc.name + "_status": status.CurrentStatus.NagiosCode(),
+		},
+		nil,
+		types.MetricAnnotations{Status: status},
+	)
+}
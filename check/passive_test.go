@@ -0,0 +1,69 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"glouton/inputs"
+	"glouton/types"
+	"testing"
+)
+
+type mockPusher struct {
+	points map[string]types.MetricPoint
+}
+
+func (m *mockPusher) PushPoints(points []types.MetricPoint) {
+	for _, p := range points {
+		m.points[p.Labels[types.LabelName]] = p
+	}
+}
+
+func TestPassiveSubmit(t *testing.T) {
+	pusher := &mockPusher{points: make(map[string]types.MetricPoint)}
+	pc := NewPassive("backup", 0, &inputs.Accumulator{Pusher: pusher})
+
+	pc.Submit(
+		types.StatusDescription{CurrentStatus: types.StatusWarning, StatusDescription: "backup is slow"},
+		map[string]float64{"duration_seconds": 4242},
+	)
+
+	status, ok := pusher.points["backup_status"]
+	if !ok {
+		t.Fatal("backup_status wasn't emitted")
+	}
+
+	if status.Value != 1 {
+		t.Errorf("backup_status = %v, want 1", status.Value)
+	}
+
+	if status.Annotations.Status.StatusDescription != "backup is slow" {
+		t.Errorf("StatusDescription = %#v, want %#v", status.Annotations.Status.StatusDescription, "backup is slow")
+	}
+
+	metric, ok := pusher.points["backup_duration_seconds"]
+	if !ok {
+		t.Fatal("backup_duration_seconds wasn't emitted")
+	}
+
+	if metric.Value != 4242 {
+		t.Errorf("backup_duration_seconds = %v, want 4242", metric.Value)
+	}
+
+	if metric.Annotations.Status.CurrentStatus.IsSet() {
+		t.Errorf("backup_duration_seconds has a status, thresholds won't apply")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Names of the pools used when no pool is explicitly configured for a check.
+const (
+	PoolLocal  = "local"
+	PoolRemote = "remote"
+)
+
+// defaultPoolSize is the concurrency of pools which aren't configured.
+const defaultPoolSize = 5
+
+// defaultPoolSizes allows many local checks, they are fast.
+//
+//nolint:gochecknoglobals
+var defaultPoolSizes = map[string]int{
+	PoolLocal:  20,
+	PoolRemote: defaultPoolSize,
+}
+
+// Pools routes the checks to worker pools, each with its own concurrency.
+//
+// Without pools, a slow check (e.g. NTP over WAN) could delay the others because
+// too many checks are running at the same time.
+type Pools struct {
+	l     sync.Mutex
+	sizes map[string]int
+	pools map[string]*Pool
+}
+
+// PoolStats are the statistics of one worker pool.
+type PoolStats struct {
+	Size      int
+	Running   int
+	Waiting   int
+	Checks    int
+	WaitTotal time.Duration
+}
+
+// NewPools returns pools with the given concurrency per pool name. The "local" pool
+// defaults to 20 concurrent checks and other pools to 5.
+func NewPools(sizes map[string]int) *Pools {
+	allSizes := make(map[string]int, len(defaultPoolSizes)+len(sizes))
+
+	for name, size := range defaultPoolSizes {
+		allSizes[name] = size
+	}
+
+	for name, size := range sizes {
+		allSizes[name] = size
+	}
+
+	return &Pools{
+		sizes: allSizes,
+		pools: make(map[string]*Pool),
+	}
+}
+
+// Get returns the pool with given name, creating it if needed.
+func (p *Pools) Get(name string) *Pool {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if pool, ok := p.pools[name]; ok {
+		return pool
+	}
+
+	size, ok := p.sizes[name]
+	if !ok || size <= 0 {
+		size = defaultPoolSize
+	}
+
+	pool := &Pool{
+		size:      size,
+		semaphore: make(chan struct{}, size),
+	}
+	p.pools[name] = pool
+
+	return pool
+}
+
+// Stats returns the statistics of each pool.
+func (p *Pools) Stats() map[string]PoolStats {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	result := make(map[string]PoolStats, len(p.pools))
+
+	for name, pool := range p.pools {
+		result[name] = pool.stats()
+	}
+
+	return result
+}
+
+// Pool limits the number of checks running concurrently.
+type Pool struct {
+	size      int
+	semaphore chan struct{}
+
+	l         sync.Mutex
+	running   int
+	waiting   int
+	checks    int
+	waitTotal time.Duration
+}
+
+// run calls fn once a worker is available. It returns false without calling fn
+// if the context is cancelled first.
+func (p *Pool) run(ctx context.Context, fn func()) bool {
+	start := time.Now()
+
+	p.l.Lock()
+	p.waiting++
+	p.l.Unlock()
+
+	select {
+	case p.semaphore <- struct{}{}:
+	case <-ctx.Done():
+		p.l.Lock()
+		p.waiting--
+		p.l.Unlock()
+
+		return false
+	}
+
+	p.l.Lock()
+	p.waiting--
+	p.running++
+	p.checks++
+	p.waitTotal += time.Since(start)
+	p.l.Unlock()
+
+	defer func() {
+		<-p.semaphore
+
+		p.l.Lock()
+		p.running--
+		p.l.Unlock()
+	}()
+
+	fn()
+
+	return true
+}
+
+func (p *Pool) stats() PoolStats {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	return PoolStats{
+		Size:      p.size,
+		Running:   p.running,
+		Waiting:   p.waiting,
+		Checks:    p.checks,
+		WaitTotal: p.waitTotal,
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolConcurrency(t *testing.T) {
+	pools := NewPools(map[string]int{PoolRemote: 2})
+
+	if got := pools.Get(PoolLocal).size; got != 20 {
+		t.Errorf("local pool size = %d, want 20", got)
+	}
+
+	if got := pools.Get("custom").size; got != defaultPoolSize {
+		t.Errorf("custom pool size = %d, want %d", got, defaultPoolSize)
+	}
+
+	pool := pools.Get(PoolRemote)
+	if pools.Get(PoolRemote) != pool {
+		t.Fatal("Get() returned two pools for the same name")
+	}
+
+	var (
+		l          sync.Mutex
+		wg         sync.WaitGroup
+		running    int
+		maxRunning int
+	)
+
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			pool.run(context.Background(), func() {
+				l.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				l.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				l.Lock()
+				running--
+				l.Unlock()
+			})
+		}()
+	}
+
+	wg.Wait()
+
+	if maxRunning != 2 {
+		t.Errorf("maxRunning = %d, want 2", maxRunning)
+	}
+
+	stats := pools.Stats()[PoolRemote]
+	if stats.Checks != 6 || stats.Running != 0 || stats.Waiting != 0 {
+		t.Errorf("Stats() = %+v, want 6 checks and none running or waiting", stats)
+	}
+
+	// 6 checks of 20ms with 2 workers: the last ones waited at least 40ms
+	if stats.WaitTotal < 40*time.Millisecond {
+		t.Errorf("WaitTotal = %v, want at least 40ms", stats.WaitTotal)
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	pool := NewPools(map[string]int{"one": 1}).Get("one")
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	go pool.run(context.Background(), func() {
+		close(started)
+		<-release
+	})
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if pool.run(ctx, func() { t.Error("check ran despite the cancelled context") }) {
+		t.Error("run() = true, want false")
+	}
+
+	close(release)
+
+	if stats := pool.stats(); stats.Waiting != 0 {
+		t.Errorf("Waiting = %d, want 0", stats.Waiting)
+	}
+}
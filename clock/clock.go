@@ -0,0 +1,55 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clock measures time with the monotonic clock of the process.
+//
+// The wall clock could jump (NTP step, manual change, VM resume). Go's time.Now() carry
+// a monotonic clock reading used by Sub, Since, Before or After, but it's lost by times
+// restored from a file or decoded from JSON, or stored as Unix timestamp. Durations
+// computed with such times are wrong after a jump, which could cause alert storms
+// (a soft period which seems elapsed) or stalls (a deadline which seems far away).
+package clock
+
+import "time"
+
+//nolint:gochecknoglobals
+var processStart = time.Now()
+
+// Monotonic returns t with a monotonic clock reading.
+//
+// A time without monotonic reading (e.g. restored from the state file) is rebased on
+// the current time: the duration between now and t is kept, later jumps of the wall
+// clock no longer affect it. A time which already has a monotonic reading is returned unchanged.
+func Monotonic(t time.Time) time.Time {
+	if t.IsZero() || t != t.Round(0) {
+		return t
+	}
+
+	now := time.Now()
+
+	return now.Add(t.Sub(now))
+}
+
+// Nanotime returns the monotonic time in nanoseconds since an arbitrary origin.
+// Unlike Unix timestamps, it's suitable to store a time in an int64 (e.g. with sync/atomic).
+func Nanotime() int64 {
+	return int64(time.Since(processStart))
+}
+
+// SinceNanotime returns the duration elapsed since a Nanotime value.
+func SinceNanotime(nanotime int64) time.Duration {
+	return time.Duration(Nanotime() - nanotime)
+}
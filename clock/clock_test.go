@@ -0,0 +1,56 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonotonic(t *testing.T) {
+	now := time.Now()
+
+	if got := Monotonic(now); got != now {
+		t.Errorf("Monotonic(now) = %v, want unchanged %v", got, now)
+	}
+
+	if got := Monotonic(time.Time{}); !got.IsZero() {
+		t.Errorf("Monotonic(zero) = %v, want zero", got)
+	}
+
+	// A time restored from a file has no monotonic reading.
+	restored := now.Add(-time.Hour).Round(0)
+
+	got := Monotonic(restored)
+	if got == got.Round(0) {
+		t.Errorf("Monotonic(restored) has no monotonic reading")
+	}
+
+	if d := time.Since(got); d < time.Hour || d > time.Hour+time.Minute {
+		t.Errorf("time.Since(Monotonic(restored)) = %v, want 1h", d)
+	}
+}
+
+func TestNanotime(t *testing.T) {
+	start := Nanotime()
+
+	time.Sleep(10 * time.Millisecond)
+
+	if d := SinceNanotime(start); d < 10*time.Millisecond || d > time.Second {
+		t.Errorf("SinceNanotime() = %v, want ~10ms", d)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"glouton/inputs"
+	"glouton/types"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/prometheus/prometheus/pkg/value"
+)
+
+// series is a metric emitted by an input, identified by its measurement, field and tags.
+type series struct {
+	measurement     string
+	field           string
+	tags            map[string]string
+	annotations     types.MetricAnnotations
+	withAnnotations bool
+}
+
+func (s series) key() string {
+	tags := make([]string, 0, len(s.tags))
+
+	for k, v := range s.tags {
+		tags = append(tags, k+"="+v)
+	}
+
+	sort.Strings(tags)
+
+	return s.measurement + "\x00" + s.field + "\x00" + strings.Join(tags, ",")
+}
+
+// gatherAccumulator is the accumulator given to one input for one gather. It records
+// the errors and the series emitted, then forwards everything to the collector accumulator.
+type gatherAccumulator struct {
+	telegraf.Accumulator
+
+	l      sync.Mutex
+	closed bool
+	failed bool
+	// err is the first error added during the gather.
+	err    error
+	series map[string]series
+}
+
+func newGatherAccumulator(acc telegraf.Accumulator) *gatherAccumulator {
+	return &gatherAccumulator{
+		Accumulator: acc,
+		series:      make(map[string]series),
+	}
+}
+
+// close stops forwarding points and errors, for gathers which are abandoned.
+func (a *gatherAccumulator) close() {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.closed = true
+}
+
+// record records the series of the fields and returns whether they should be forwarded.
+func (a *gatherAccumulator) record(measurement string, fields map[string]interface{}, tags map[string]string, annotations types.MetricAnnotations, withAnnotations bool) bool {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if a.closed {
+		return false
+	}
+
+	for field := range fields {
+		s := series{
+			measurement:     measurement,
+			field:           field,
+			tags:            tags,
+			annotations:     annotations,
+			withAnnotations: withAnnotations,
+		}
+
+		a.series[s.key()] = s
+	}
+
+	return true
+}
+
+// AddFields implements telegraf.Accumulator.
+func (a *gatherAccumulator) AddFields(measurement string, fields map[string]interface{}, tags map[string]string, t ...time.Time) {
+	if a.record(measurement, fields, tags, types.MetricAnnotations{}, false) {
+		a.Accumulator.AddFields(measurement, fields, tags, t...)
+	}
+}
+
+// AddGauge implements telegraf.Accumulator.
+func (a *gatherAccumulator) AddGauge(measurement string, fields map[string]interface{}, tags map[string]string, t ...time.Time) {
+	if a.record(measurement, fields, tags, types.MetricAnnotations{}, false) {
+		a.Accumulator.AddGauge(measurement, fields, tags, t...)
+	}
+}
+
+// AddCounter implements telegraf.Accumulator.
+func (a *gatherAccumulator) AddCounter(measurement string, fields map[string]interface{}, tags map[string]string, t ...time.Time) {
+	if a.record(measurement, fields, tags, types.MetricAnnotations{}, false) {
+		a.Accumulator.AddCounter(measurement, fields, tags, t...)
+	}
+}
+
+// AddSummary implements telegraf.Accumulator.
+func (a *gatherAccumulator) AddSummary(measurement string, fields map[string]interface{}, tags map[string]string, t ...time.Time) {
+	if a.record(measurement, fields, tags, types.MetricAnnotations{}, false) {
+		a.Accumulator.AddSummary(measurement, fields, tags, t...)
+	}
+}
+
+// AddHistogram implements telegraf.Accumulator.
+func (a *gatherAccumulator) AddHistogram(measurement string, fields map[string]interface{}, tags map[string]string, t ...time.Time) {
+	if a.record(measurement, fields, tags, types.MetricAnnotations{}, false) {
+		a.Accumulator.AddHistogram(measurement, fields, tags, t...)
+	}
+}
+
+// AddFieldsWithAnnotations implements inputs.AnnotationAccumulator.
+func (a *gatherAccumulator) AddFieldsWithAnnotations(measurement string, fields map[string]interface{}, tags map[string]string, annotations types.MetricAnnotations, t ...time.Time) {
+	if !a.record(measurement, fields, tags, annotations, true) {
+		return
+	}
+
+	if annotationAcc, ok := a.Accumulator.(inputs.AnnotationAccumulator); ok {
+		annotationAcc.AddFieldsWithAnnotations(measurement, fields, tags, annotations, t...)
+	} else {
+		a.Accumulator.AddFields(measurement, fields, tags, t...)
+	}
+}
+
+// AddError implements telegraf.Accumulator. The gather is then considered as failed.
+func (a *gatherAccumulator) AddError(err error) {
+	if err == nil {
+		return
+	}
+
+	a.l.Lock()
+	closed := a.closed
+	a.failed = true
+
+	if a.err == nil {
+		a.err = err
+	}
+
+	a.l.Unlock()
+
+	if !closed {
+		a.Accumulator.AddError(err)
+	}
+}
+
+// markStale emits a staleness marker for each series, so they expire instead of
+// keeping their last value until their TTL.
+func markStale(acc telegraf.Accumulator, stale []series) {
+	now := time.Now()
+
+	for _, s := range stale {
+		fields := map[string]interface{}{s.field: math.Float64frombits(value.StaleNaN)}
+
+		if annotationAcc, ok := acc.(inputs.AnnotationAccumulator); ok && s.withAnnotations {
+			annotationAcc.AddFieldsWithAnnotations(s.measurement, fields, s.tags, s.annotations, now)
+		} else {
+			acc.AddFields(s.measurement, fields, s.tags, now)
+		}
+	}
+}
@@ -19,6 +19,7 @@ package collector
 
 import (
 	"errors"
+	"fmt"
 	"glouton/logger"
 	"sync"
 	"time"
@@ -26,11 +27,36 @@ import (
 	"github.com/influxdata/telegraf"
 )
 
+const (
+	defaultWorkers = 8
+	defaultTimeout = 9 * time.Second
+
+	// An input is quarantined after quarantineAfter consecutive timeouts. The quarantine
+	// starts at minQuarantine and doubles on each new timeout, up to maxQuarantine.
+	quarantineAfter = 3
+	minQuarantine   = time.Minute
+	maxQuarantine   = time.Hour
+)
+
 // Collector implement running Gather on inputs every fixed time interval.
+//
+// Inputs are gathered concurrently by a bounded number of workers. A gather which
+// doesn't finish within the timeout is abandoned: its points are dropped and the input
+// isn't gathered again until it returns. Inputs which repeatedly time out are quarantined.
+//
+// The series an input stops to emit (because its gather failed or it was removed)
+// receive a staleness marker.
 type Collector struct {
 	acc          telegraf.Accumulator
 	inputs       map[int]telegraf.Input
 	inputNames   map[int]string
+	inputItems   map[int]string
+	inputStates  map[int]*inputState
+	durations    map[int]time.Duration
+	results      map[int]GatherResult
+	series       map[int]map[string]series
+	workers      int
+	timeout      time.Duration
 	currentDelay time.Duration
 	updateDelayC chan interface{}
 	l            sync.Mutex
@@ -45,6 +71,13 @@ func New(acc telegraf.Accumulator) *Collector {
 		acc:          acc,
 		inputs:       make(map[int]telegraf.Input),
 		inputNames:   make(map[int]string),
+		inputItems:   make(map[int]string),
+		inputStates:  make(map[int]*inputState),
+		durations:    make(map[int]time.Duration),
+		results:      make(map[int]GatherResult),
+		series:       make(map[int]map[string]series),
+		workers:      defaultWorkers,
+		timeout:      defaultTimeout,
 		currentDelay: 10 * time.Second,
 		updateDelayC: make(chan interface{}),
 	}
@@ -52,8 +85,51 @@ func New(acc telegraf.Accumulator) *Collector {
 	return c
 }
 
+// inputState tracks the running gather and the timeouts of an input.
+type inputState struct {
+	running          bool
+	timeouts         int
+	quarantinedUntil time.Time
+}
+
+// SetGatherLimits sets the number of inputs gathered concurrently and the timeout of
+// each gather. Non-positive values reset the default.
+func (c *Collector) SetGatherLimits(workers int, timeout time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	c.workers = workers
+	c.timeout = timeout
+}
+
+// GatherResult is the outcome of the last gather of an input.
+type GatherResult struct {
+	Input    string
+	Item     string
+	Success  bool
+	Duration time.Duration
+	// LastGather is the start time of the gather.
+	LastGather time.Time
+	// Error is the reason of a failed gather.
+	Error string
+}
+
 // AddInput add an input to this collector and return an ID.
 func (c *Collector) AddInput(input telegraf.Input, shortName string) (int, error) {
+	return c.AddInputWithItem(input, shortName, "")
+}
+
+// AddInputWithItem add an input to this collector and return an ID.
+// The item distinguishes inputs with the same name, like the services running in different containers.
+func (c *Collector) AddInputWithItem(input telegraf.Input, shortName string, item string) (int, error) {
 	c.l.Lock()
 	defer c.l.Unlock()
 
@@ -71,6 +147,8 @@ func (c *Collector) AddInput(input telegraf.Input, shortName string) (int, error
 
 	c.inputs[id] = input
 	c.inputNames[id] = shortName
+	c.inputItems[id] = item
+	c.inputStates[id] = &inputState{}
 
 	if si, ok := input.(telegraf.ServiceInput); ok {
 		if err := si.Start(nil); err != nil {
@@ -84,7 +162,6 @@ func (c *Collector) AddInput(input telegraf.Input, shortName string) (int, error
 // RemoveInput removes an input by its ID.
 func (c *Collector) RemoveInput(id int) {
 	c.l.Lock()
-	defer c.l.Unlock()
 
 	if input, ok := c.inputs[id]; ok {
 		if si, ok := input.(telegraf.ServiceInput); ok {
@@ -94,8 +171,85 @@ func (c *Collector) RemoveInput(id int) {
 		logger.V(2).Printf("called RemoveInput with unexisting ID %d", id)
 	}
 
+	stale := make([]series, 0, len(c.series[id]))
+
+	for _, s := range c.series[id] {
+		stale = append(stale, s)
+	}
+
+	delete(c.durations, id)
 	delete(c.inputs, id)
 	delete(c.inputNames, id)
+	delete(c.inputItems, id)
+	delete(c.inputStates, id)
+	delete(c.results, id)
+	delete(c.series, id)
+
+	c.l.Unlock()
+
+	if c.acc != nil {
+		markStale(c.acc, stale)
+	}
+}
+
+// GatherDurations return the duration of the last gather of each input, by input name.
+// When multiple inputs have the same name, the longest duration is used.
+func (c *Collector) GatherDurations() map[string]time.Duration {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	result := make(map[string]time.Duration, len(c.durations))
+
+	for id, duration := range c.durations {
+		name := c.inputNames[id]
+
+		if duration > result[name] {
+			result[name] = duration
+		}
+	}
+
+	return result
+}
+
+// GatherResults return the result of the last gather of each input.
+// Inputs with the same name and item are merged, they are successful only if all of them are.
+func (c *Collector) GatherResults() []GatherResult {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	type key struct{ input, item string }
+
+	merged := make(map[key]GatherResult, len(c.results))
+
+	for _, r := range c.results {
+		k := key{input: r.Input, item: r.Item}
+
+		if previous, ok := merged[k]; ok {
+			r.Success = r.Success && previous.Success
+
+			if previous.Duration > r.Duration {
+				r.Duration = previous.Duration
+			}
+
+			if previous.LastGather.After(r.LastGather) {
+				r.LastGather = previous.LastGather
+			}
+
+			if r.Error == "" {
+				r.Error = previous.Error
+			}
+		}
+
+		merged[k] = r
+	}
+
+	result := make([]GatherResult, 0, len(merged))
+
+	for _, r := range merged {
+		result = append(result, r)
+	}
+
+	return result
 }
 
 // RunGather run one gather and send metric through the accumulator.
@@ -103,28 +257,37 @@ func (c *Collector) RunGather() {
 	c.runOnce()
 }
 
-func (c *Collector) inputsForCollection() ([]telegraf.Input, []string) {
+// inputsForCollection returns the inputs to gather, which are the inputs neither running
+// nor quarantined. They are marked as running.
+func (c *Collector) inputsForCollection(now time.Time) (map[int]telegraf.Input, int, time.Duration) {
 	c.l.Lock()
 	defer c.l.Unlock()
 
-	inputsCopy := make([]telegraf.Input, 0)
-	inputsNameCopy := make([]string, 0)
+	inputsCopy := make(map[int]telegraf.Input, len(c.inputs))
 
 	for id, v := range c.inputs {
-		inputsCopy = append(inputsCopy, v)
-		inputsNameCopy = append(inputsNameCopy, c.inputNames[id])
+		state := c.inputStates[id]
+
+		if state.running || now.Before(state.quarantinedUntil) {
+			continue
+		}
+
+		state.running = true
+		inputsCopy[id] = v
 	}
 
-	return inputsCopy, inputsNameCopy
+	return inputsCopy, c.workers, c.timeout
 }
 
 func (c *Collector) runOnce() {
-	inputsCopy, inputsNameCopy := c.inputsForCollection()
+	inputsCopy, workers, timeout := c.inputsForCollection(time.Now())
 
 	var wg sync.WaitGroup
 
-	for i, input := range inputsCopy {
-		i := i
+	slots := make(chan struct{}, workers)
+
+	for id, input := range inputsCopy {
+		id := id
 		input := input
 
 		wg.Add(1)
@@ -132,12 +295,146 @@ func (c *Collector) runOnce() {
 		go func() {
 			defer wg.Done()
 
-			err := input.Gather(c.acc)
-			if err != nil {
-				logger.Printf("Input %s failed: %v", inputsNameCopy[i], err)
-			}
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			c.gather(id, input, timeout)
 		}()
 	}
 
 	wg.Wait()
 }
+
+// gather runs the Gather of one input and marks as stale the series it no longer emits.
+func (c *Collector) gather(id int, input telegraf.Input, timeout time.Duration) {
+	c.l.Lock()
+	name := c.inputNames[id]
+	c.l.Unlock()
+
+	var (
+		acc       *gatherAccumulator
+		gatherAcc telegraf.Accumulator
+	)
+
+	if c.acc != nil {
+		acc = newGatherAccumulator(c.acc)
+		gatherAcc = acc
+	}
+
+	t0 := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		err := input.Gather(gatherAcc)
+
+		c.l.Lock()
+		if state, ok := c.inputStates[id]; ok {
+			state.running = false
+		}
+		c.l.Unlock()
+
+		done <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var (
+		err      error
+		timedOut bool
+	)
+
+	select {
+	case err = <-done:
+	case <-timer.C:
+		timedOut = true
+		err = fmt.Errorf("gather timed out after %v", timeout)
+
+		// The points sent after the timeout are dropped.
+		if acc != nil {
+			acc.close()
+		}
+	}
+
+	if err != nil {
+		logger.ErrorfLimited("Input %s failed: %v", name, err)
+	}
+
+	duration := time.Since(t0)
+
+	var stale []series
+
+	success := err == nil
+
+	c.l.Lock()
+
+	// The input could be removed during its gather.
+	state, ok := c.inputStates[id]
+	if !ok {
+		c.l.Unlock()
+
+		return
+	}
+
+	if timedOut {
+		state.timeouts++
+
+		if state.timeouts >= quarantineAfter {
+			backoff := minQuarantine << (state.timeouts - quarantineAfter)
+			if backoff > maxQuarantine || backoff <= 0 {
+				backoff = maxQuarantine
+			}
+
+			state.quarantinedUntil = time.Now().Add(backoff)
+
+			logger.Printf("Input %s timed out %d times in a row, it's disabled for %v", name, state.timeouts, backoff)
+		}
+	} else {
+		state.timeouts = 0
+	}
+
+	if acc != nil {
+		acc.l.Lock()
+
+		success = success && !acc.failed
+
+		if err == nil {
+			err = acc.err
+		}
+
+		for key, s := range c.series[id] {
+			if _, ok := acc.series[key]; !ok || timedOut {
+				stale = append(stale, s)
+			}
+		}
+
+		if timedOut {
+			delete(c.series, id)
+		} else {
+			c.series[id] = acc.series
+		}
+
+		acc.l.Unlock()
+	}
+
+	c.durations[id] = duration
+	result := GatherResult{
+		Input:      name,
+		Item:       c.inputItems[id],
+		Success:    success,
+		Duration:   duration,
+		LastGather: t0,
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	c.results[id] = result
+
+	c.l.Unlock()
+
+	if len(stale) > 0 {
+		markStale(c.acc, stale)
+	}
+}
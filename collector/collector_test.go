@@ -17,9 +17,15 @@
 package collector
 
 import (
+	"errors"
+	"glouton/inputs"
+	"glouton/types"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/prometheus/prometheus/pkg/value"
 )
 
 type mockInput struct {
@@ -62,6 +68,31 @@ func TestAddRemove(t *testing.T) {
 	}
 }
 
+func TestGatherDurations(t *testing.T) {
+	c := New(nil)
+	id1, _ := c.AddInput(&mockInput{Name: "mysql"}, "mysql")
+	id2, _ := c.AddInput(&mockInput{Name: "mysql"}, "mysql")
+
+	c.durations[id1] = time.Second
+	c.durations[id2] = 2 * time.Second
+
+	if got := c.GatherDurations()["mysql"]; got != 2*time.Second {
+		t.Errorf("GatherDurations()[mysql] = %v, want 2s", got)
+	}
+
+	c.RemoveInput(id2)
+
+	if got := c.GatherDurations()["mysql"]; got != time.Second {
+		t.Errorf("GatherDurations()[mysql] = %v after removing one input, want 1s", got)
+	}
+
+	c.RemoveInput(id1)
+
+	if _, ok := c.GatherDurations()["mysql"]; ok {
+		t.Errorf("GatherDurations() still contains mysql after removing all inputs")
+	}
+}
+
 func TestRun(t *testing.T) {
 	c := New(nil)
 	c.runOnce()
@@ -85,3 +116,143 @@ func TestRun(t *testing.T) {
 		t.Errorf("input.GatherCallCount == %v, want %v", input.GatherCallCount, 2)
 	}
 }
+
+type fieldsInput struct {
+	fields []string
+	err    error
+}
+
+func (i *fieldsInput) Description() string {
+	return "fields"
+}
+
+func (i *fieldsInput) SampleConfig() string {
+	return ""
+}
+
+func (i *fieldsInput) Gather(acc telegraf.Accumulator) error {
+	for _, f := range i.fields {
+		acc.AddFields("redis", map[string]interface{}{f: 1.0}, map[string]string{"item": "redis-1"})
+	}
+
+	acc.AddError(i.err)
+
+	return nil
+}
+
+type pointsRecorder map[string]float64
+
+func (r pointsRecorder) PushPoints(points []types.MetricPoint) {
+	for _, p := range points {
+		r[p.Labels[types.LabelName]] = p.Value
+	}
+}
+
+func TestStaleness(t *testing.T) {
+	points := make(pointsRecorder)
+	c := New(&inputs.Accumulator{Pusher: points})
+	input := &fieldsInput{fields: []string{"uptime", "keys"}}
+
+	id, err := c.AddInputWithItem(input, "redis", "redis-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.runOnce()
+
+	if results := c.GatherResults(); len(results) != 1 || !results[0].Success || results[0].Item != "redis-1" {
+		t.Errorf("GatherResults() = %v, want one successful result for redis-1", results)
+	}
+
+	input.fields = []string{"uptime"}
+	input.err = errors.New("connection refused")
+
+	c.runOnce()
+
+	if !value.IsStaleNaN(points["redis_keys"]) {
+		t.Errorf("redis_keys = %v, want a staleness marker", points["redis_keys"])
+	}
+
+	if points["redis_uptime"] != 1 {
+		t.Errorf("redis_uptime = %v, want 1", points["redis_uptime"])
+	}
+
+	if results := c.GatherResults(); len(results) != 1 || results[0].Success {
+		t.Errorf("GatherResults() = %v, want one failed result", results)
+	}
+
+	c.RemoveInput(id)
+
+	if !value.IsStaleNaN(points["redis_uptime"]) {
+		t.Errorf("redis_uptime = %v, want a staleness marker after RemoveInput", points["redis_uptime"])
+	}
+}
+
+type slowInput struct {
+	delay time.Duration
+	calls int32
+}
+
+func (i *slowInput) Description() string {
+	return "slow"
+}
+
+func (i *slowInput) SampleConfig() string {
+	return ""
+}
+
+func (i *slowInput) Gather(acc telegraf.Accumulator) error {
+	atomic.AddInt32(&i.calls, 1)
+	time.Sleep(i.delay)
+
+	acc.AddFields("slow", map[string]interface{}{"value": 1.0}, nil)
+
+	return nil
+}
+
+func TestTimeoutAndQuarantine(t *testing.T) {
+	points := make(pointsRecorder)
+	c := New(&inputs.Accumulator{Pusher: points})
+	c.SetGatherLimits(2, 20*time.Millisecond)
+
+	slow := &slowInput{delay: 50 * time.Millisecond}
+	fast := &fieldsInput{fields: []string{"uptime"}}
+
+	slowID, _ := c.AddInput(slow, "slow")
+	_, _ = c.AddInput(fast, "redis")
+
+	for i := 0; i < quarantineAfter; i++ {
+		t0 := time.Now()
+
+		c.runOnce()
+
+		if elapsed := time.Since(t0); elapsed >= slow.delay {
+			t.Errorf("runOnce() took %v, want less than the slow input delay", elapsed)
+		}
+
+		if points["redis_uptime"] != 1 {
+			t.Errorf("redis_uptime = %v, want 1", points["redis_uptime"])
+		}
+
+		// Let the abandoned gather finish.
+		time.Sleep(2 * slow.delay)
+	}
+
+	if _, ok := points["slow_value"]; ok {
+		t.Error("points of timed out gathers were emitted")
+	}
+
+	c.runOnce()
+
+	if calls := atomic.LoadInt32(&slow.calls); calls != quarantineAfter {
+		t.Errorf("slow input gathered %d times, want %d", calls, quarantineAfter)
+	}
+
+	c.l.Lock()
+	quarantinedUntil := c.inputStates[slowID].quarantinedUntil
+	c.l.Unlock()
+
+	if remaining := time.Until(quarantinedUntil); remaining <= 0 || remaining > minQuarantine {
+		t.Errorf("quarantine remaining = %v, want between 0 and %v", remaining, minQuarantine)
+	}
+}
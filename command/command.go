@@ -0,0 +1,99 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command parses and runs the local commands defined in the configuration.
+//
+// It's shared by the actions and the remediation hooks, which add their own guards
+// (cooldown, concurrency) around the execution.
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/google/shlex"
+)
+
+var (
+	// ErrMissingCommand is returned by Split for an empty command.
+	ErrMissingCommand = errors.New("missing command")
+	// ErrTimeout is returned by Run when the command is killed after its timeout.
+	ErrTimeout = errors.New("killed after timeout")
+
+	errInvalidSeconds = errors.New("must be a positive number of seconds")
+)
+
+// Split splits the command like a shell would do.
+func Split(command string) ([]string, error) {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, ErrMissingCommand
+	}
+
+	return args, nil
+}
+
+// Durations returns the cooldown and the timeout of a configuration fragment. They are
+// given in seconds, a missing value is zero.
+func Durations(fragment map[string]string) (cooldown time.Duration, timeout time.Duration, err error) {
+	durations := make(map[string]time.Duration, 2)
+
+	for _, key := range []string{"cooldown", "timeout"} {
+		value, ok := fragment[key]
+		if !ok {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(value, 10, 0)
+		if err != nil || seconds <= 0 {
+			return 0, 0, fmt.Errorf("invalid %s %#v: %w", key, value, errInvalidSeconds)
+		}
+
+		durations[key] = time.Duration(seconds) * time.Second
+	}
+
+	return durations["cooldown"], durations["timeout"], nil
+}
+
+// Run runs the command and kills it after timeout. It returns the combined output,
+// truncated to maxOutputLength.
+func Run(ctx context.Context, args []string, timeout time.Duration, maxOutputLength int) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...) //nolint:gosec
+	output, err := cmd.CombinedOutput()
+
+	output = bytes.TrimSpace(output)
+	if len(output) > maxOutputLength {
+		output = append(output[:maxOutputLength], []byte("...")...)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return string(output), fmt.Errorf("%w of %v", ErrTimeout, timeout)
+	}
+
+	return string(output), err
+}
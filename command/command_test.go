@@ -0,0 +1,82 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurations(t *testing.T) {
+	cases := []struct {
+		fragment     map[string]string
+		wantCooldown time.Duration
+		wantTimeout  time.Duration
+		wantErr      bool
+	}{
+		{fragment: map[string]string{}},
+		{
+			fragment:     map[string]string{"cooldown": "600", "timeout": "30"},
+			wantCooldown: 10 * time.Minute,
+			wantTimeout:  30 * time.Second,
+		},
+		{fragment: map[string]string{"timeout": "0"}, wantErr: true},
+		{fragment: map[string]string{"cooldown": "1m"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		cooldown, timeout, err := Durations(c.fragment)
+		if (err != nil) != c.wantErr {
+			t.Errorf("Durations(%v) error = %v, want error %v", c.fragment, err, c.wantErr)
+		}
+
+		if cooldown != c.wantCooldown || timeout != c.wantTimeout {
+			t.Errorf("Durations(%v) = %v, %v, want %v, %v", c.fragment, cooldown, timeout, c.wantCooldown, c.wantTimeout)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses sh")
+	}
+
+	output, err := Run(context.Background(), []string{"sh", "-c", "echo 0123456789"}, time.Second, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != "0123..." {
+		t.Errorf("output = %#v, want %#v", output, "0123...")
+	}
+
+	if _, err := Run(context.Background(), []string{"sleep", "10"}, 100*time.Millisecond, 10); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Run(sleep) = %v, want %v", err, ErrTimeout)
+	}
+
+	if _, err := Split(" "); !errors.Is(err, ErrMissingCommand) {
+		t.Errorf("Split(empty) = %v, want %v", err, ErrMissingCommand)
+	}
+
+	if args, err := Split("echo 'hello world'"); err != nil || strings.Join(args, "|") != "echo|hello world" {
+		t.Errorf("Split() = %q, %v", args, err)
+	}
+}
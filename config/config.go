@@ -21,21 +21,47 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+// SourceDefault is the source of values set with Set.
+const SourceDefault = "default"
+
 // Configuration hold the agent configuration are set of key/value
 //
 // value could be typed and a default could be provided.
+//
+// When loading multiple files, maps are merged recursively, lists are concatenated and
+// other values are replaced by the last file loaded. The source of each value is kept, see Sources.
 type Configuration struct {
 	rawValues map[string]interface{}
+	sources   map[string][]string
 
 	lookupEnv func(key string) (string, bool)
 }
 
+// KeySource is a key of the configuration and where its value comes from.
+//
+// Sources has multiple entries for lists concatenated from multiple files.
+type KeySource struct {
+	Key     string   `json:"key"`
+	Sources []string `json:"sources"`
+}
+
+// LoadFile will load given YAML file.
+func (c *Configuration) LoadFile(filePath string) error {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	return c.loadByte(data, filePath)
+}
+
 // LoadDirectory will read all *.conf file within given directory.
 //
 // File are read in lexicographic order (e.g. 00-initial.conf is read before 99-override.conf)
@@ -54,11 +80,13 @@ func (c *Configuration) LoadDirectory(dirPath string) error {
 			continue
 		}
 
-		data, err := ioutil.ReadFile(filepath.Join(dirPath, f.Name()))
+		filePath := filepath.Join(dirPath, f.Name())
+
+		data, err := ioutil.ReadFile(filePath)
 		if err != nil && firstError == nil {
 			firstError = fmt.Errorf("%#v: %v", f, err)
 		} else if err == nil {
-			err = c.LoadByte(data)
+			err = c.loadByte(data, filePath)
 			if err != nil && firstError == nil {
 				firstError = fmt.Errorf("%#v: %v", f, err)
 			}
@@ -69,7 +97,14 @@ func (c *Configuration) LoadDirectory(dirPath string) error {
 }
 
 // LoadByte will load given YAML data.
+//
+// Environment variables (${VAR}) are replaced in string values and keys like
+// "password_file" are replaced by the content of the file (see SecretFileKey).
 func (c *Configuration) LoadByte(data []byte) error {
+	return c.loadByte(data, "")
+}
+
+func (c *Configuration) loadByte(data []byte, source string) error {
 	var newValue map[string]interface{}
 
 	err := yaml.Unmarshal(data, &newValue)
@@ -78,7 +113,14 @@ func (c *Configuration) LoadByte(data []byte) error {
 		c.rawValues = make(map[string]interface{})
 	}
 
-	merge(c.rawValues, newValue)
+	if newValue != nil {
+		_, interpolateErr := c.interpolate(newValue, "")
+		if err == nil {
+			err = interpolateErr
+		}
+	}
+
+	c.merge(c.rawValues, newValue, "", source)
 
 	return err
 }
@@ -97,32 +139,46 @@ func (c *Configuration) LoadEnv(key string, varType ValueType, envName string) (
 		return
 	}
 
+	source := "environment variable " + envName
+
 	switch varType {
 	case TypeString:
-		c.Set(key, value)
+		c.SetFrom(key, value, source)
 	case TypeStringList:
-		c.Set(key, strings.Split(value, ","))
+		c.SetFrom(key, strings.Split(value, ","), source)
 	case TypeBoolean:
 		value, err := convertBoolean(value)
 		if err != nil {
 			return false, err
 		}
 
-		c.Set(key, value)
+		c.SetFrom(key, value, source)
 	case TypeInteger:
 		value, err := strconv.ParseInt(value, 10, 0)
 		if err != nil {
 			return false, err
 		}
 
-		c.Set(key, int(value))
+		c.SetFrom(key, int(value), source)
 	case TypeMap:
 		mapValue, err := convertMap(value)
 		if err != nil {
 			return false, err
 		}
 
-		c.Set(key, mapValue)
+		c.SetFrom(key, mapValue, source)
+	case TypeDuration:
+		if _, err := ParseDuration(value); err != nil {
+			return false, err
+		}
+
+		c.SetFrom(key, value, source)
+	case TypeSize:
+		if _, err := ParseSize(value); err != nil {
+			return false, err
+		}
+
+		c.SetFrom(key, value, source)
 	default:
 		return false, fmt.Errorf("unknown variable type %v", varType)
 	}
@@ -132,6 +188,11 @@ func (c *Configuration) LoadEnv(key string, varType ValueType, envName string) (
 
 // Set define the default for given key.
 func (c *Configuration) Set(key string, value interface{}) {
+	c.SetFrom(key, value, SourceDefault)
+}
+
+// SetFrom define the value for given key and record where it comes from.
+func (c *Configuration) SetFrom(key string, value interface{}, source string) {
 	if c.rawValues == nil {
 		c.rawValues = make(map[string]interface{})
 	}
@@ -139,6 +200,62 @@ func (c *Configuration) Set(key string, value interface{}) {
 	keyPart := strings.Split(key, ".")
 
 	setValue(c.rawValues, keyPart, value)
+	c.setSource(key, source, false)
+}
+
+// Sources return where each value of the configuration comes from, sorted by key.
+//
+// Keys are the leaves of the configuration (e.g. "bleemeo.mqtt.host"), values
+// set as a whole map (e.g. with Set) are reported with the key of the map.
+func (c *Configuration) Sources() []KeySource {
+	result := make([]KeySource, 0, len(c.sources))
+
+	for key, sources := range c.sources {
+		result = append(result, KeySource{Key: key, Sources: append([]string(nil), sources...)})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
+
+// setSource record the source of key. Sources of sub-keys are removed, the value replaced them.
+func (c *Configuration) setSource(key string, source string, appendSource bool) {
+	if c.sources == nil {
+		c.sources = make(map[string][]string)
+	}
+
+	if source == "" {
+		source = "unknown"
+	}
+
+	if appendSource {
+		c.sources[key] = append(c.sources[key], source)
+
+		return
+	}
+
+	c.deleteSources(key)
+	c.sources[key] = []string{source}
+}
+
+// deleteSources removes the source of key and of its sub-keys.
+func (c *Configuration) deleteSources(key string) {
+	delete(c.sources, key)
+
+	for k := range c.sources {
+		if strings.HasPrefix(k, key+".") {
+			delete(c.sources, k)
+		}
+	}
+
+	// A parent key set as a whole map is partially replaced.
+	for parent := key; strings.Contains(parent, "."); {
+		parent = parent[:strings.LastIndex(parent, ".")]
+		delete(c.sources, parent)
+	}
 }
 
 // String return the given key as string.
@@ -278,6 +395,11 @@ func (c *Configuration) Get(key string) (result interface{}, found bool) {
 	return get(c.rawValues, keyPart)
 }
 
+// Dump return all values of the configuration. The result must not be modified.
+func (c *Configuration) Dump() map[string]interface{} {
+	return c.rawValues
+}
+
 func get(root interface{}, keyPart []string) (result interface{}, found bool) {
 	if len(keyPart) == 0 {
 		return root, true
@@ -292,8 +414,10 @@ func get(root interface{}, keyPart []string) (result interface{}, found bool) {
 	return nil, false
 }
 
-func merge(root map[string]interface{}, newValue map[string]interface{}) {
+func (c *Configuration) merge(root map[string]interface{}, newValue map[string]interface{}, prefix string, source string) {
 	for k, v := range newValue {
+		key := prefix + k
+
 		if newMap, ok := v.(map[interface{}]interface{}); ok {
 			v = convertToStringMap(newMap)
 		}
@@ -301,7 +425,7 @@ func merge(root map[string]interface{}, newValue map[string]interface{}) {
 		if newMap, ok := v.(map[string]interface{}); ok {
 			if oldV, ok := root[k]; ok {
 				if oldMap, ok := oldV.(map[string]interface{}); ok {
-					merge(oldMap, newMap)
+					c.merge(oldMap, newMap, key+".", source)
 					continue
 				}
 			}
@@ -309,7 +433,13 @@ func merge(root map[string]interface{}, newValue map[string]interface{}) {
 			oldMap := make(map[string]interface{})
 			root[k] = oldMap
 
-			merge(oldMap, newMap)
+			c.deleteSources(key)
+
+			if len(newMap) == 0 {
+				c.setSource(key, source, false)
+			}
+
+			c.merge(oldMap, newMap, key+".", source)
 
 			continue
 		}
@@ -320,12 +450,16 @@ func merge(root map[string]interface{}, newValue map[string]interface{}) {
 					oldList = append(oldList, newList...)
 					root[k] = oldList
 
+					c.setSource(key, source, true)
+
 					continue
 				}
 			}
 		}
 
 		root[k] = v
+
+		c.setSource(key, source, false)
 	}
 }
 
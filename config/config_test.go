@@ -423,3 +423,58 @@ func TestLoadEnv(t *testing.T) {
 		}
 	}
 }
+
+func TestSources(t *testing.T) {
+	cfg := Configuration{}
+
+	cfg.Set("default_value", 1)
+	cfg.Set("overridden_value", "default")
+
+	if err := cfg.LoadFile("testdata/main.conf"); err != nil {
+		t.Error(err)
+	}
+
+	if err := cfg.LoadDirectory("testdata/conf.d"); err != nil {
+		t.Error(err)
+	}
+
+	cfg.lookupEnv = func(key string) (string, bool) {
+		if key == "GLOUTON_FROM_ENV" {
+			return "yes", true
+		}
+
+		return "", false
+	}
+
+	if _, err := cfg.LoadEnv("telegraf.statsd_enabled", TypeString, "GLOUTON_FROM_ENV"); err != nil {
+		t.Error(err)
+	}
+
+	sources := make(map[string][]string)
+	for _, ks := range cfg.Sources() {
+		sources[ks.Key] = ks.Sources
+	}
+
+	want := map[string][]string{
+		"default_value":           {SourceDefault},
+		"overridden_value":        {"testdata/conf.d/second.conf"},
+		"main_conf_loaded":        {"testdata/main.conf"},
+		"merged_dict.main":        {"testdata/main.conf"},
+		"merged_dict.first":       {"testdata/conf.d/first.conf"},
+		"merged_list":             {"testdata/main.conf", "testdata/conf.d/first.conf", "testdata/conf.d/second.conf"},
+		"sub_section.nested":      {"testdata/conf.d/first.conf"},
+		"telegraf.statsd.enabled": {"testdata/conf.d/first.conf"},
+		"telegraf.statsd_enabled": {"environment variable GLOUTON_FROM_ENV"},
+	}
+
+	for key, w := range want {
+		if got := sources[key]; !reflect.DeepEqual(got, w) {
+			t.Errorf("Sources()[%s] = %v, want %v", key, got, w)
+		}
+	}
+
+	// The sub-keys of a replaced map are removed.
+	if _, ok := sources["sub_section.nested.key1"]; ok {
+		t.Errorf("Sources() contains sub_section.nested.key1, want removed")
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Size is a size in bytes. In the configuration it's either a number of bytes
+// or a string with a unit, see ParseSize.
+type Size int64
+
+//nolint:gochecknoglobals
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	sizeType     = reflect.TypeOf(Size(0))
+	sizeRegexp   = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([a-zA-Z]*)$`)
+	sizeUnits    = map[string]float64{
+		"":    1,
+		"b":   1,
+		"k":   1 << 10,
+		"kb":  1e3,
+		"kib": 1 << 10,
+		"m":   1 << 20,
+		"mb":  1e6,
+		"mib": 1 << 20,
+		"g":   1 << 30,
+		"gb":  1e9,
+		"gib": 1 << 30,
+		"t":   1 << 40,
+		"tb":  1e12,
+		"tib": 1 << 40,
+	}
+)
+
+// Errors returned when parsing values with a unit.
+var (
+	ErrInvalidDuration = errors.New("invalid duration")
+	ErrInvalidSize     = errors.New("invalid size")
+)
+
+// ParseDuration converts a value decoded from YAML to a duration.
+//
+// Numbers are seconds, which is how durations were always written in the configuration.
+// Strings are either a number of seconds or a duration with units like "1m30s".
+func ParseDuration(value interface{}) (time.Duration, error) {
+	switch value := value.(type) {
+	case time.Duration:
+		return value, nil
+	case int:
+		return time.Duration(value) * time.Second, nil
+	case int64:
+		return time.Duration(value) * time.Second, nil
+	case float64:
+		return time.Duration(value * float64(time.Second)), nil
+	case string:
+		value = strings.TrimSpace(value)
+
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return time.Duration(seconds * float64(time.Second)), nil
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("%w %#v, expected seconds or a value like \"5m\"", ErrInvalidDuration, value)
+		}
+
+		return d, nil
+	default:
+		return 0, fmt.Errorf("%w %#v, expected seconds or a value like \"5m\"", ErrInvalidDuration, value)
+	}
+}
+
+// ParseSize converts a value decoded from YAML to a size in bytes.
+//
+// Numbers are bytes. Strings have an optional unit: "kB", "MB", "GB" and "TB" are powers
+// of 1000, "KiB", "MiB", "GiB", "TiB" and their single letter forms "K", "M", "G", "T" are
+// powers of 1024. Units are case insensitive.
+func ParseSize(value interface{}) (Size, error) {
+	switch value := value.(type) {
+	case Size:
+		return value, nil
+	case int:
+		return Size(value), nil
+	case int64:
+		return Size(value), nil
+	case string:
+		m := sizeRegexp.FindStringSubmatch(strings.TrimSpace(value))
+		if m == nil {
+			break
+		}
+
+		unit, ok := sizeUnits[strings.ToLower(m[2])]
+		if !ok {
+			break
+		}
+
+		number, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			break
+		}
+
+		return Size(number * unit), nil
+	}
+
+	return 0, fmt.Errorf("%w %#v, expected bytes or a value like \"10MB\"", ErrInvalidSize, value)
+}
+
+// Duration return the given key as a duration, see ParseDuration.
+//
+// Return 0 if the key does not exist or could not be converted to a duration.
+func (c *Configuration) Duration(key string) time.Duration {
+	rawValue, ok := c.Get(key)
+	if !ok {
+		return 0
+	}
+
+	d, err := ParseDuration(rawValue)
+	if err != nil {
+		return 0
+	}
+
+	return d
+}
+
+// Decode copies the configuration into output, which must be a pointer to a struct.
+//
+// Fields are matched using their yaml tag and nested structs are sections of the configuration.
+// Values are converted like the other accessors do (e.g. "yes" is a boolean), time.Duration
+// fields use ParseDuration and Size fields use ParseSize. Keys without a field are ignored.
+//
+// Decode continues on errors: fields with an invalid value are left unchanged and one error
+// is returned for each of them.
+func (c *Configuration) Decode(output interface{}) (errs []error) {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       decodeHook,
+		WeaklyTypedInput: true,
+		ZeroFields:       true,
+		Result:           output,
+		TagName:          "yaml",
+	})
+	if err != nil {
+		return []error{err}
+	}
+
+	err = decoder.Decode(c.rawValues)
+
+	var decodeErr *mapstructure.Error
+
+	switch {
+	case errors.As(err, &decodeErr):
+		for _, msg := range decodeErr.Errors {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrWrongType, msg))
+		}
+	case err != nil:
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+func decodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	switch {
+	case to == durationType:
+		return ParseDuration(data)
+	case to == sizeType:
+		return ParseSize(data)
+	case to.Kind() == reflect.Bool && from.Kind() == reflect.String:
+		return convertBoolean(data.(string))
+	default:
+		return data, nil
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		value   interface{}
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: 60, want: time.Minute},
+		{value: "60", want: time.Minute},
+		{value: 1.5, want: 1500 * time.Millisecond},
+		{value: "1m30s", want: 90 * time.Second},
+		{value: " 2h ", want: 2 * time.Hour},
+		{value: "500ms", want: 500 * time.Millisecond},
+		{value: "soon", wantErr: true},
+		{value: "10 minutes", wantErr: true},
+		{value: true, wantErr: true},
+	}
+
+	for _, c := range cases {
+		got, err := ParseDuration(c.value)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseDuration(%#v) error = %v, wantErr %v", c.value, err, c.wantErr)
+
+			continue
+		}
+
+		if err != nil && !errors.Is(err, ErrInvalidDuration) {
+			t.Errorf("ParseDuration(%#v) error = %v, want ErrInvalidDuration", c.value, err)
+		}
+
+		if got != c.want {
+			t.Errorf("ParseDuration(%#v) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		value   interface{}
+		want    Size
+		wantErr bool
+	}{
+		{value: 4096, want: 4096},
+		{value: "4096", want: 4096},
+		{value: "10MB", want: 10000000},
+		{value: "10 MiB", want: 10 << 20},
+		{value: "512k", want: 512 << 10},
+		{value: "1.5G", want: 3 << 29},
+		{value: "2tb", want: 2e12},
+		{value: "10 bananas", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: 1.5, wantErr: true},
+	}
+
+	for _, c := range cases {
+		got, err := ParseSize(c.value)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseSize(%#v) error = %v, wantErr %v", c.value, err, c.wantErr)
+
+			continue
+		}
+
+		if got != c.want {
+			t.Errorf("ParseSize(%#v) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type section struct {
+		Enabled bool              `yaml:"enabled"`
+		Port    int               `yaml:"port"`
+		Timeout time.Duration     `yaml:"timeout"`
+		MaxSize Size              `yaml:"max_size"`
+		Tags    map[string]string `yaml:"tags"`
+	}
+
+	type decoded struct {
+		Name    string      `yaml:"name"`
+		List    []string    `yaml:"list"`
+		Section section     `yaml:"section"`
+		Raw     interface{} `yaml:"raw"`
+	}
+
+	cfg := Configuration{}
+
+	err := cfg.LoadByte([]byte(`
+name: 42
+list: [a, 1]
+unknown: ignored
+section:
+  enabled: "yes"
+  port: "8015"
+  timeout: 5m
+  max_size: 1KiB
+  tags:
+    env: prod
+raw:
+  - a: b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got decoded
+
+	if errs := cfg.Decode(&got); len(errs) > 0 {
+		t.Fatalf("Decode() = %v", errs)
+	}
+
+	want := decoded{
+		Name: "42",
+		List: []string{"a", "1"},
+		Section: section{
+			Enabled: true,
+			Port:    8015,
+			Timeout: 5 * time.Minute,
+			MaxSize: 1024,
+			Tags:    map[string]string{"env": "prod"},
+		},
+		Raw: []interface{}{map[string]interface{}{"a": "b"}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeInvalidValue(t *testing.T) {
+	type decoded struct {
+		Port     int           `yaml:"port"`
+		Interval time.Duration `yaml:"interval"`
+		List     []string      `yaml:"list"`
+	}
+
+	cfg := Configuration{}
+
+	if err := cfg.LoadByte([]byte("port: http\ninterval: soon\nlist: [c]\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := decoded{Port: 8015, Interval: time.Minute, List: []string{"a", "b"}}
+
+	errs := cfg.Decode(&got)
+	if len(errs) != 2 {
+		t.Errorf("Decode() = %v, want 2 errors", errs)
+	}
+
+	// Invalid values are unchanged and lists are replaced, not merged.
+	want := decoded{Port: 8015, Interval: time.Minute, List: []string{"c"}}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %#v, want %#v", got, want)
+	}
+}
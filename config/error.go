@@ -16,6 +16,8 @@
 
 package config
 
+import "errors"
+
 type notFoundError error
 
 // IsNotFound tells if the error is due to non-existing key.
@@ -23,3 +25,9 @@ func IsNotFound(err error) bool {
 	_, ok := err.(notFoundError)
 	return ok
 }
+
+// Errors returned by the configuration.
+var (
+	ErrWrongType     = errors.New("wrong type")
+	ErrUnsetVariable = errors.New("environment variable referenced by the configuration is not set")
+)
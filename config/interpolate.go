@@ -0,0 +1,186 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretFileSuffix is the suffix of keys whose value is read from a file, e.g. the
+// content of the file "registration_key_file" is used as "registration_key".
+const secretFileSuffix = "_file"
+
+//nolint:gochecknoglobals
+var (
+	// interpolationRegexp matches ${VAR}, ${VAR:-default} and the escaped form $${VAR}.
+	interpolationRegexp = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+	// secretFileKeys are the keys which could be read from a file, in addition to the
+	// "password" keys. Other keys ending with "_file" (e.g. agent.state_file or
+	// zabbix.tls.key_file) are paths used as-is.
+	secretFileKeys = map[string]bool{
+		"agent.state_encryption.key": true,
+		"bleemeo.anonymize.salt":     true,
+		"bleemeo.registration_key":   true,
+		"bleemeo.relay.token":        true,
+		"influxdb.token":             true,
+	}
+)
+
+// SecretFileKey returns the key set from the file given in key, if key is a secret file
+// indirection (e.g. "bleemeo.registration_key" for "bleemeo.registration_key_file").
+// key is the full key, the items of lists have the key of the list.
+func SecretFileKey(key string) (string, bool) {
+	if !strings.HasSuffix(key, secretFileSuffix) {
+		return "", false
+	}
+
+	base := strings.TrimSuffix(key, secretFileSuffix)
+
+	if secretFileKeys[base] || base == "password" || strings.HasSuffix(base, ".password") {
+		return base, true
+	}
+
+	return "", false
+}
+
+// HasInterpolation returns whether value contains an environment variable reference.
+func HasInterpolation(value string) bool {
+	for _, m := range interpolationRegexp.FindAllString(value, -1) {
+		if !strings.HasPrefix(m, "$$") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// interpolate replaces ${VAR} in strings of value by the environment variable VAR
+// and reads secret files. "${VAR:-default}" uses default when VAR is unset or empty and
+// "$${VAR}" is kept as the literal "${VAR}".
+//
+// path is the key of value, it's used to find the secret files.
+// Processing continues after an error, the first error is returned.
+func (c *Configuration) interpolate(value interface{}, path string) (interface{}, error) {
+	var firstErr error
+
+	setErr := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		result, err := c.interpolateString(value)
+
+		return result, err
+	case []interface{}:
+		for i, v := range value {
+			var err error
+
+			value[i], err = c.interpolate(v, path)
+			setErr(err)
+		}
+
+		return value, firstErr
+	case map[interface{}]interface{}:
+		return c.interpolate(convertToStringMap(value), path)
+	case map[string]interface{}:
+		for k, v := range value {
+			var err error
+
+			value[k], err = c.interpolate(v, joinKey(path, k))
+			setErr(err)
+		}
+
+		for k, v := range value {
+			fullBase, ok := SecretFileKey(joinKey(path, k))
+			if !ok {
+				continue
+			}
+
+			base := fullBase[strings.LastIndex(fullBase, ".")+1:]
+
+			path, ok := v.(string)
+			if !ok || path == "" {
+				continue
+			}
+
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				setErr(fmt.Errorf("%s: %w", k, err))
+
+				continue
+			}
+
+			value[base] = strings.TrimRight(string(content), "\r\n")
+			delete(value, k)
+		}
+
+		return value, firstErr
+	default:
+		return value, nil
+	}
+}
+
+func joinKey(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func (c *Configuration) interpolateString(value string) (string, error) {
+	var err error
+
+	result := interpolationRegexp.ReplaceAllStringFunc(value, func(m string) string {
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+
+		sub := interpolationRegexp.FindStringSubmatch(m)
+		name := sub[1]
+
+		var (
+			env   string
+			found bool
+		)
+
+		if c.lookupEnv == nil {
+			env, found = os.LookupEnv(name)
+		} else {
+			env, found = c.lookupEnv(name)
+		}
+
+		if sub[2] != "" && env == "" {
+			return strings.TrimPrefix(sub[2], ":-")
+		}
+
+		if !found && err == nil {
+			err = fmt.Errorf("%w: %s", ErrUnsetVariable, name)
+		}
+
+		return env
+	})
+
+	return result, err
+}
@@ -33,6 +33,8 @@ const (
 	TypeInteger
 	TypeBoolean
 	TypeMap
+	TypeDuration
+	TypeSize
 )
 
 func convertBoolean(value string) (bool, error) {
@@ -73,3 +75,84 @@ func convertMap(value string) (map[string]string, error) {
 
 	return finalMap, nil
 }
+
+// CheckType returns an error if value, as decoded from YAML, could not be used as varType.
+func CheckType(value interface{}, varType ValueType) error {
+	var ok bool
+
+	switch varType {
+	case TypeString:
+		switch value.(type) {
+		case []interface{}, []string, map[string]interface{}, map[interface{}]interface{}:
+		default:
+			ok = true
+		}
+	case TypeStringList:
+		switch value.(type) {
+		case []interface{}, []string:
+			ok = true
+		}
+	case TypeInteger:
+		switch value := value.(type) {
+		case int:
+			ok = true
+		case string:
+			_, err := strconv.ParseInt(value, 10, 0)
+			ok = err == nil
+		}
+	case TypeBoolean:
+		switch value := value.(type) {
+		case bool, int:
+			ok = true
+		case string:
+			_, err := convertBoolean(value)
+			ok = err == nil
+		}
+	case TypeMap:
+		switch value.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, map[string]string:
+			ok = true
+		}
+	case TypeDuration:
+		if _, err := ParseDuration(value); err != nil {
+			return err
+		}
+
+		ok = true
+	case TypeSize:
+		if _, err := ParseSize(value); err != nil {
+			return err
+		}
+
+		ok = true
+	default:
+		ok = true
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: expected %s, got %#v", ErrWrongType, typeName(varType), value)
+	}
+
+	return nil
+}
+
+func typeName(t ValueType) string {
+	switch t {
+	case TypeString:
+		return "a string"
+	case TypeStringList:
+		return "a list"
+	case TypeInteger:
+		return "an integer"
+	case TypeBoolean:
+		return "a boolean"
+	case TypeMap:
+		return "a map"
+	case TypeDuration:
+		return "a duration"
+	case TypeSize:
+		return "a size"
+	default:
+		return "an unknown type"
+	}
+}
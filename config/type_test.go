@@ -101,3 +101,38 @@ func TestConvertMap(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckType(t *testing.T) {
+	cases := []struct {
+		value   interface{}
+		varType ValueType
+		wantErr bool
+	}{
+		{value: "INFO", varType: TypeString},
+		{value: 42, varType: TypeString},
+		{value: []interface{}{"a"}, varType: TypeString, wantErr: true},
+		{value: []interface{}{"a"}, varType: TypeStringList},
+		{value: "a,b", varType: TypeStringList, wantErr: true},
+		{value: 8015, varType: TypeInteger},
+		{value: "8015", varType: TypeInteger},
+		{value: "eight", varType: TypeInteger, wantErr: true},
+		{value: 1.5, varType: TypeInteger, wantErr: true},
+		{value: true, varType: TypeBoolean},
+		{value: "yes", varType: TypeBoolean},
+		{value: "maybe", varType: TypeBoolean, wantErr: true},
+		{value: map[string]interface{}{"a": 1}, varType: TypeMap},
+		{value: "a=1", varType: TypeMap, wantErr: true},
+		{value: "5m", varType: TypeDuration},
+		{value: 300, varType: TypeDuration},
+		{value: "soon", varType: TypeDuration, wantErr: true},
+		{value: "10MB", varType: TypeSize},
+		{value: "10 bananas", varType: TypeSize, wantErr: true},
+	}
+
+	for _, c := range cases {
+		err := CheckType(c.value, c.varType)
+		if (err != nil) != c.wantErr {
+			t.Errorf("CheckType(%#v, %v) = %v, wantErr %v", c.value, c.varType, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package derive computes the rate or the delta of counters between two gathers.
+package derive
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Mode is the kind of value derived from a counter.
+type Mode int
+
+// Possible values for Mode.
+const (
+	// Rate is the increase of the counter per second.
+	Rate Mode = iota
+	// Delta is the increase of the counter since its previous value.
+	Delta
+)
+
+// ParseMode returns the mode from its name, "rate" or "delta". An empty name is Rate.
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "", "rate":
+		return Rate, nil
+	case "delta":
+		return Delta, nil
+	default:
+		return Rate, fmt.Errorf("unknown derivation mode %#v", name)
+	}
+}
+
+// Config describes how a counter is derived. The zero value computes a rate,
+// skipping the values where the counter is reset.
+type Config struct {
+	Mode Mode
+	// MinInterval is the minimum duration between the two values used. A value
+	// received sooner doesn't produce a result and is ignored.
+	MinInterval time.Duration
+	// WrapAt is the value at which the counter wraps to zero, for example 2^32 for
+	// a 32 bits counter. When it's zero, a counter going down is a reset.
+	WrapAt float64
+}
+
+// ErrUnsupportedValue is returned when the value isn't a number.
+var ErrUnsupportedValue = errors.New("value type not supported")
+
+type sample struct {
+	value interface{}
+	time  time.Time
+}
+
+// Deriver keeps the previous value of counters to compute their rate or delta.
+//
+// The counters are identified by a key, usually built from their name and labels.
+// The counters not seen between two calls to Prepare are forgotten.
+type Deriver struct {
+	l       sync.Mutex
+	current map[string]sample
+	past    map[string]sample
+}
+
+// New returns a Deriver.
+func New() *Deriver {
+	return &Deriver{
+		current: make(map[string]sample),
+		past:    make(map[string]sample),
+	}
+}
+
+// Prepare should be called before each gather.
+func (d *Deriver) Prepare() {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	d.past = d.current
+	d.current = make(map[string]sample)
+}
+
+// Derive returns the derived value of the counter identified by key.
+//
+// ok is false when there is no result: for the first value of a counter, when
+// the counter was reset or when the value came before the MinInterval elapsed.
+// The value could be an uint64, int, int64, float64 or float32. Integers don't lose
+// precision even on large values.
+func (d *Deriver) Derive(key string, cfg Config, value interface{}, t time.Time) (result float64, ok bool, err error) {
+	if _, err := toFloat(value); err != nil {
+		return 0, false, err
+	}
+
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	previous, found := d.past[key]
+	if !found {
+		d.current[key] = sample{value: value, time: t}
+
+		return 0, false, nil
+	}
+
+	elapsed := t.Sub(previous.time)
+
+	if elapsed <= 0 || elapsed < cfg.MinInterval {
+		// Keep the previous value as reference for the next gather.
+		d.current[key] = previous
+
+		return 0, false, nil
+	}
+
+	d.current[key] = sample{value: value, time: t}
+
+	delta, err := difference(previous.value, value)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if delta < 0 {
+		if cfg.WrapAt <= 0 {
+			return 0, false, nil
+		}
+
+		delta += cfg.WrapAt
+	}
+
+	if cfg.Mode == Delta {
+		return delta, true, nil
+	}
+
+	return delta / elapsed.Seconds(), true, nil
+}
+
+// difference returns current - past. Integers of the same type are subtracted before
+// the conversion to float64 to keep their precision.
+func difference(past interface{}, current interface{}) (float64, error) {
+	switch pastValue := past.(type) {
+	case uint64:
+		if currentValue, ok := current.(uint64); ok {
+			if pastValue > currentValue {
+				return -float64(pastValue - currentValue), nil
+			}
+
+			return float64(currentValue - pastValue), nil
+		}
+	case int:
+		if currentValue, ok := current.(int); ok {
+			return float64(currentValue - pastValue), nil
+		}
+	case int64:
+		if currentValue, ok := current.(int64); ok {
+			return float64(currentValue - pastValue), nil
+		}
+	}
+
+	pastFloat, err := toFloat(past)
+	if err != nil {
+		return 0, err
+	}
+
+	currentFloat, err := toFloat(current)
+	if err != nil {
+		return 0, err
+	}
+
+	return currentFloat - pastFloat, nil
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch value := value.(type) {
+	case uint64:
+		return float64(value), nil
+	case float64:
+		return value, nil
+	case float32:
+		return float64(value), nil
+	case int:
+		return float64(value), nil
+	case int64:
+		return float64(value), nil
+	default:
+		return 0, fmt.Errorf("%w: %v", ErrUnsupportedValue, reflect.TypeOf(value))
+	}
+}
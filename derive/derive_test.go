@@ -0,0 +1,128 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package derive
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDerive(t *testing.T) {
+	t0 := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	type point struct {
+		value  interface{}
+		offset time.Duration
+		want   float64
+		wantOk bool
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		points []point
+	}{
+		{
+			name: "rate",
+			points: []point{
+				{value: uint64(100), offset: 0},
+				{value: uint64(150), offset: 10 * time.Second, want: 5, wantOk: true},
+				{value: uint64(150), offset: 20 * time.Second, want: 0, wantOk: true},
+			},
+		},
+		{
+			name:   "delta",
+			config: Config{Mode: Delta},
+			points: []point{
+				{value: 10.5, offset: 0},
+				{value: 12.0, offset: 10 * time.Second, want: 1.5, wantOk: true},
+			},
+		},
+		{
+			name: "reset",
+			points: []point{
+				{value: int64(100), offset: 0},
+				{value: int64(20), offset: 10 * time.Second},
+				{value: int64(40), offset: 20 * time.Second, want: 2, wantOk: true},
+			},
+		},
+		{
+			name:   "wrap",
+			config: Config{Mode: Delta, WrapAt: math.Pow(2, 32)},
+			points: []point{
+				{value: uint64(math.Pow(2, 32)) - 10, offset: 0},
+				{value: uint64(5), offset: 10 * time.Second, want: 15, wantOk: true},
+			},
+		},
+		{
+			name:   "min-interval",
+			config: Config{MinInterval: 30 * time.Second},
+			points: []point{
+				{value: 0, offset: 0},
+				{value: 100, offset: 10 * time.Second},
+				{value: 200, offset: 20 * time.Second},
+				{value: 300, offset: 30 * time.Second, want: 10, wantOk: true},
+			},
+		},
+		{
+			name: "large-uint64",
+			points: []point{
+				{value: uint64(1) << 62, offset: 0},
+				{value: uint64(1)<<62 + 1, offset: time.Second, want: 1, wantOk: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		d := New()
+
+		for i, p := range tt.points {
+			d.Prepare()
+
+			got, ok, err := d.Derive("metric", tt.config, p.value, t0.Add(p.offset))
+			if err != nil {
+				t.Fatalf("%s: point %d: %v", tt.name, i, err)
+			}
+
+			if ok != p.wantOk || got != p.want {
+				t.Errorf("%s: point %d: Derive() = %v, %v, want %v, %v", tt.name, i, got, ok, p.want, p.wantOk)
+			}
+		}
+	}
+}
+
+func TestDeriveForget(t *testing.T) {
+	t0 := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	d := New()
+
+	d.Prepare()
+	_, _, _ = d.Derive("metric", Config{}, 1.0, t0)
+
+	// The metric isn't gathered during one cycle, its previous value is forgotten.
+	d.Prepare()
+	d.Prepare()
+
+	if _, ok, _ := d.Derive("metric", Config{}, 2.0, t0.Add(20*time.Second)); ok {
+		t.Errorf("Derive() returned a value for a metric absent from the previous gather")
+	}
+
+	if _, _, err := d.Derive("other", Config{}, "text", t0); !errors.Is(err, ErrUnsupportedValue) {
+		t.Errorf("Derive(string) error = %v, want %v", err, ErrUnsupportedValue)
+	}
+}
@@ -18,18 +18,29 @@ package discovery
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"glouton/check"
 	"glouton/logger"
 	"glouton/types"
 	"net"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	customCheckTCP    = "tcp"
 	customCheckHTTP   = "http"
 	customCheckNagios = "nagios"
+	customCheckDNS    = "dns"
+	customCheckSMTP   = "smtp"
+	customCheckIMAP   = "imap"
+	customCheckPOP3   = "pop3"
+
+	// checkPoolLabel is the container label which select the worker pool of the checks.
+	checkPoolLabel = "glouton.check.pool"
 )
 
 // Check is an interface which specify a check.
@@ -80,7 +91,9 @@ func (d *Discovery) removeCheck(key NameContainer) {
 }
 
 func (d *Discovery) createCheck(service Service) {
-	if !service.Active {
+	// The check of an expired service is still run, its results are dropped
+	// by the checkAccumulator but a success ends the expiration.
+	if !service.Active && !service.Expired {
 		return
 	}
 
@@ -118,10 +131,16 @@ func (d *Discovery) createCheck(service Service) {
 	annotations := service.AnnotationsOfStatus()
 
 	switch service.ServiceType {
-	case DovecoteService, MemcachedService, RabbitMQService, RedisService, ZookeeperService:
+	case DovecoteService:
+		d.createMailCheck(service, di, check.MailIMAP, primaryAddress, tcpAddresses, labels, annotations)
+	case EximService, PostfixService:
+		d.createMailCheck(service, di, check.MailSMTP, primaryAddress, tcpAddresses, labels, annotations)
+	case MemcachedService, RabbitMQService, RedisService, ZookeeperService:
 		d.createTCPCheck(service, di, primaryAddress, tcpAddresses, labels, annotations)
 	case ApacheService, InfluxDBService, NginxService, SquidService:
 		d.createHTTPCheck(service, di, primaryAddress, tcpAddresses, labels, annotations)
+	case BindService:
+		d.createDNSCheck(service, di, primaryAddress, tcpAddresses, labels, annotations)
 	case NTPService:
 		if primaryAddress != "" {
 			check := check.NewNTP(
@@ -130,7 +149,7 @@ func (d *Discovery) createCheck(service Service) {
 				!di.DisablePersistentConnection,
 				labels,
 				annotations,
-				d.acc,
+				d.checkAcc(service),
 			)
 			d.addCheck(check, service)
 		} else {
@@ -144,6 +163,10 @@ func (d *Discovery) createCheck(service Service) {
 			d.createHTTPCheck(service, di, primaryAddress, tcpAddresses, labels, annotations)
 		case customCheckNagios:
 			d.createNagiosCheck(service, primaryAddress, labels, annotations)
+		case customCheckDNS:
+			d.createDNSCheck(service, di, primaryAddress, tcpAddresses, labels, annotations)
+		case customCheckSMTP, customCheckIMAP, customCheckPOP3:
+			d.createMailCheck(service, di, service.ExtraAttributes["check_type"], primaryAddress, tcpAddresses, labels, annotations)
 		default:
 			logger.V(1).Printf("Unknown check type %#v on custom service %#v", service.ExtraAttributes["check_type"], service.Name)
 		}
@@ -156,10 +179,6 @@ func (d *Discovery) createTCPCheck(service Service, di discoveryInfo, primaryAdd
 	var tcpSend, tcpExpect, tcpClose []byte
 
 	switch service.ServiceType {
-	case DovecoteService:
-		tcpSend = []byte("001 NOOP\n")
-		tcpExpect = []byte("001 OK")
-		tcpClose = []byte("002 LOGOUT\n")
 	case MemcachedService:
 		tcpSend = []byte("version\r\n")
 		tcpExpect = []byte("VERSION")
@@ -183,7 +202,7 @@ func (d *Discovery) createTCPCheck(service Service, di discoveryInfo, primaryAdd
 		tcpClose,
 		labels,
 		annotations,
-		d.acc,
+		d.checkAcc(service),
 	)
 
 	d.addCheck(tcpCheck, service)
@@ -221,19 +240,149 @@ func (d *Discovery) createHTTPCheck(service Service, di discoveryInfo, primaryAd
 		}
 	}
 
+	var options check.HTTPOptions
+
+	if service.ServiceType == CustomService {
+		options = httpOptions(service)
+	}
+
 	httpCheck := check.NewHTTP(
 		url,
 		tcpAddresses,
 		!di.DisablePersistentConnection,
 		expectedStatusCode,
+		options,
 		labels,
 		annotations,
-		d.acc,
+		d.checkAcc(service),
 	)
 
 	d.addCheck(httpCheck, service)
 }
 
+// httpOptions returns the HTTP check options from the extra attributes of a custom service.
+func httpOptions(service Service) check.HTTPOptions {
+	options := check.HTTPOptions{
+		Method:          strings.ToUpper(service.ExtraAttributes["http_method"]),
+		Body:            service.ExtraAttributes["http_body"],
+		Username:        service.ExtraAttributes["http_username"],
+		Password:        service.ExtraAttributes["http_password"],
+		BearerToken:     service.ExtraAttributes["http_bearer_token"],
+		ExpectedContent: service.ExtraAttributes["http_expected_content"],
+	}
+
+	if value := service.ExtraAttributes["http_headers"]; value != "" {
+		if err := json.Unmarshal([]byte(value), &options.Headers); err != nil {
+			logger.V(1).Printf("Invalid http_headers %#v on service %s. Ignoring this option", value, service.Name)
+		}
+	}
+
+	if value := service.ExtraAttributes["http_expected_regex"]; value != "" {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			logger.V(1).Printf("Invalid http_expected_regex %#v on service %s: %v. Ignoring this option", value, service.Name, err)
+		} else {
+			options.ExpectedRegex = re
+		}
+	}
+
+	options.ResponseTimeWarning = durationAttribute(service, "http_response_time_warning")
+	options.ResponseTimeCritical = durationAttribute(service, "http_response_time_critical")
+
+	return options
+}
+
+// durationAttribute returns the duration of an attribute given in seconds.
+func durationAttribute(service Service, name string) time.Duration {
+	value := service.ExtraAttributes[name]
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		logger.V(1).Printf("Invalid %s %#v on service %s. Ignoring this option", name, value, service.Name)
+
+		return 0
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func (d *Discovery) createDNSCheck(service Service, di discoveryInfo, primaryAddress string, tcpAddresses []string, labels map[string]string, annotations types.MetricAnnotations) {
+	if primaryAddress == "" {
+		d.createTCPCheck(service, di, primaryAddress, tcpAddresses, labels, annotations)
+		return
+	}
+
+	options := check.DNSOptions{
+		QueryName: service.ExtraAttributes["dns_query_name"],
+		QueryType: service.ExtraAttributes["dns_query_type"],
+		MinTTL:    durationAttribute(service, "dns_min_ttl"),
+		MaxTTL:    durationAttribute(service, "dns_max_ttl"),
+	}
+
+	if value := service.ExtraAttributes["dns_expected"]; value != "" {
+		// The expected values could be a list in the configuration, which is converted to JSON.
+		if err := json.Unmarshal([]byte(value), &options.Expected); err != nil {
+			options.Expected = strings.Split(value, ",")
+		}
+
+		for i, v := range options.Expected {
+			options.Expected[i] = strings.TrimSpace(v)
+		}
+	}
+
+	options.ResponseTimeWarning = durationAttribute(service, "dns_response_time_warning")
+	options.ResponseTimeCritical = durationAttribute(service, "dns_response_time_critical")
+
+	dnsCheck := check.NewDNS(
+		primaryAddress,
+		tcpAddresses,
+		!di.DisablePersistentConnection,
+		options,
+		labels,
+		annotations,
+		d.checkAcc(service),
+	)
+
+	d.addCheck(dnsCheck, service)
+}
+
+func (d *Discovery) createMailCheck(service Service, di discoveryInfo, protocol string, primaryAddress string, tcpAddresses []string, labels map[string]string, annotations types.MetricAnnotations) {
+	if primaryAddress == "" {
+		d.createTCPCheck(service, di, primaryAddress, tcpAddresses, labels, annotations)
+		return
+	}
+
+	options := check.MailOptions{
+		Username: service.ExtraAttributes["mail_username"],
+		Password: service.ExtraAttributes["mail_password"],
+	}
+
+	if value := service.ExtraAttributes["mail_starttls"]; value != "" {
+		required, err := strconv.ParseBool(value)
+		if err != nil {
+			logger.V(1).Printf("Invalid mail_starttls %#v on service %s. Ignoring this option", value, service.Name)
+		}
+
+		options.RequireStartTLS = required
+	}
+
+	mailCheck := check.NewMail(
+		protocol,
+		primaryAddress,
+		tcpAddresses,
+		!di.DisablePersistentConnection,
+		options,
+		labels,
+		annotations,
+		d.checkAcc(service),
+	)
+
+	d.addCheck(mailCheck, service)
+}
+
 func (d *Discovery) createNagiosCheck(service Service, primaryAddress string, labels map[string]string, annotations types.MetricAnnotations) {
 	var tcpAddress []string
 
@@ -247,7 +396,7 @@ func (d *Discovery) createNagiosCheck(service Service, primaryAddress string, la
 		true,
 		labels,
 		annotations,
-		d.acc,
+		d.checkAcc(service),
 	)
 
 	d.addCheck(httpCheck, service)
@@ -258,6 +407,10 @@ func (d *Discovery) addCheck(check Check, service Service) {
 		return
 	}
 
+	if pooled, ok := check.(pooledCheck); ok && d.checkPools != nil {
+		pooled.SetPool(d.checkPools.Get(d.checkPoolName(service)))
+	}
+
 	key := NameContainer{
 		Name:          service.Name,
 		ContainerName: service.ContainerName,
@@ -274,3 +427,44 @@ func (d *Discovery) addCheck(check Check, service Service) {
 	}
 	d.activeCheck[key] = savedCheck
 }
+
+type pooledCheck interface {
+	SetPool(pool *check.Pool)
+}
+
+// checkPoolName returns the worker pool of the service check. It's the check_pool of the
+// service configuration, the glouton.check.pool label of its container or else
+// "local" for checks of local addresses and "remote" for the others.
+func (d *Discovery) checkPoolName(service Service) string {
+	if name := service.ExtraAttributes[checkPool]; name != "" {
+		return name
+	}
+
+	if service.ContainerID != "" {
+		if container, found := d.containerInfo.Container(service.ContainerID); found {
+			if name := container.Labels()[checkPoolLabel]; name != "" {
+				return name
+			}
+		}
+	}
+
+	// Nagios commands may query anything, assume they are slow.
+	if service.ServiceType == CustomService && service.ExtraAttributes["check_type"] == customCheckNagios {
+		return check.PoolRemote
+	}
+
+	if service.ContainerID != "" {
+		return check.PoolLocal
+	}
+
+	address, _ := service.AddressPort()
+	if ip := net.ParseIP(address); ip != nil && (ip.IsLoopback() || ip.IsPrivate()) {
+		return check.PoolLocal
+	}
+
+	if address == "" || address == "localhost" {
+		return check.PoolLocal
+	}
+
+	return check.PoolRemote
+}
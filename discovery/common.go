@@ -35,6 +35,11 @@ type Discoverer interface {
 	LastUpdate() time.Time
 }
 
+// containerDiscoverer is implemented by Discoverer able to discover the services of a single container.
+type containerDiscoverer interface {
+	ContainerDiscovery(ctx context.Context, containerID string) (services []Service, err error)
+}
+
 // PersistentDiscoverer also allow to remove a non-running service.
 type PersistentDiscoverer interface {
 	Discoverer
@@ -61,6 +66,7 @@ const (
 	DovecoteService      ServiceName = "dovecot"
 	EjabberService       ServiceName = "ejabberd"
 	ElasticSearchService ServiceName = "elasticsearch"
+	EtcdService          ServiceName = "etcd"
 	EximService          ServiceName = "exim"
 	FreeradiusService    ServiceName = "freeradius"
 	HAProxyService       ServiceName = "haproxy"
@@ -68,6 +74,7 @@ const (
 	JIRAService          ServiceName = "jira"
 	LibvirtService       ServiceName = "libvirt"
 	MemcachedService     ServiceName = "memcached"
+	MinIOService         ServiceName = "minio"
 	MongoDBService       ServiceName = "mongodb"
 	MosquittoService     ServiceName = "mosquitto" //nolint:misspell
 	MySQLService         ServiceName = "mysql"
@@ -82,6 +89,7 @@ const (
 	RedisService         ServiceName = "redis"
 	SaltMasterService    ServiceName = "salt-master"
 	SquidService         ServiceName = "squid"
+	TraefikService       ServiceName = "traefik"
 	UWSGIService         ServiceName = "uwsgi"
 	VarnishService       ServiceName = "varnish"
 	ZookeeperService     ServiceName = "zookeeper"
@@ -99,6 +107,8 @@ type Service struct {
 	ListenAddresses []facts.ListenAddress
 	ExePath         string
 	Stack           string
+	// ComposeProject is the Docker Compose project or the Swarm stack of the container.
+	ComposeProject string
 	// ExtraAttributes contains additional service-dependant attribute. It may be password for MySQL, URL for HAProxy, ...
 	// Both configuration and dynamic discovery may set value here.
 	ExtraAttributes map[string]string
@@ -106,6 +116,23 @@ type Service struct {
 	Active          bool
 	CheckIgnored    bool
 	MetricsIgnored  bool
+	// Manual is true for services created with the API, see ManualService.
+	Manual bool
+	// LastSeen is the last time the dynamic discovery found this service.
+	LastSeen time.Time
+	// Stale is true when the service was restored from state.json and no
+	// discovery confirmed it since the agent started.
+	Stale bool
+	// TTL is the duration after which a service which isn't seen is expired.
+	// Zero means the service never expires.
+	TTL time.Duration
+	// Expired is true when the service wasn't seen during its TTL. An expired
+	// service is also inactive, but its check keeps running so a successful check
+	// brings it back.
+	Expired bool
+	// DependsOn are the names of the services this service depends on. While one of
+	// them is critical, the critical status of this service check is degraded to a warning.
+	DependsOn []string
 
 	HasNetstatInfo bool
 	container      container
@@ -119,6 +146,11 @@ func (s Service) String() string {
 	return s.Name
 }
 
+// serviceName returns the name of the service with the key, like Service.String.
+func serviceName(key NameContainer) string {
+	return Service{Name: key.Name, ContainerName: key.ContainerName}.String()
+}
+
 // AddressForPort return the IP address for given port & network (tcp, udp).
 func (s Service) AddressForPort(port int, network string, force bool) string {
 	if s.ExtraAttributes["address"] != "" {
@@ -189,6 +221,14 @@ func (s Service) LabelsOfStatus() map[string]string {
 		labels[types.LabelMetaContainerName] = s.ContainerName
 	}
 
+	if s.ComposeProject != "" {
+		labels[types.LabelComposeProject] = s.ComposeProject
+	}
+
+	if s.Stack != "" {
+		labels[types.LabelStack] = s.Stack
+	}
+
 	return labels
 }
 
@@ -224,9 +264,12 @@ var (
 			},
 		},
 		BindService: {
-			ServicePort:         53,
-			ServiceProtocol:     "tcp",
-			ExtraAttributeNames: []string{"address", "port"},
+			ServicePort:     53,
+			ServiceProtocol: "tcp",
+			ExtraAttributeNames: []string{
+				"address", "port", "dns_query_name", "dns_query_type", "dns_expected",
+				"dns_min_ttl", "dns_max_ttl", "dns_response_time_warning", "dns_response_time_critical",
+			},
 		},
 		CassandraService: {
 			ServicePort:         9042,
@@ -243,13 +286,20 @@ var (
 		DovecoteService: {
 			ServicePort:         143,
 			ServiceProtocol:     "tcp",
-			ExtraAttributeNames: []string{"address", "port"},
+			ExtraAttributeNames: []string{"address", "port", "mail_username", "mail_password", "mail_starttls"},
 		},
 		ElasticSearchService: {
 			ServicePort:         9200,
 			ServiceProtocol:     "tcp",
 			ExtraAttributeNames: []string{"address", "port"},
 		},
+		EtcdService: {
+			ServicePort:         2379,
+			ServiceProtocol:     "tcp",
+			ExtraAttributeNames: []string{"address", "port"},
+			MetricsPort:         2379,
+			MetricsPath:         "/metrics",
+		},
 		EjabberService: {
 			ServicePort:         5222,
 			ServiceProtocol:     "tcp",
@@ -259,17 +309,21 @@ var (
 		EximService: {
 			ServicePort:         25,
 			ServiceProtocol:     "tcp",
-			ExtraAttributeNames: []string{"address", "port"},
+			ExtraAttributeNames: []string{"address", "port", "mail_username", "mail_password", "mail_starttls"},
 		},
 		HAProxyService: {
 			IgnoreHighPort:      true, // HAProxy use a random high-port when Syslog over-UDP is enabled.
 			ServiceProtocol:     "tcp",
 			ExtraAttributeNames: []string{"address", "port", "stats_url"},
+			MetricsPort:         8405,
+			MetricsPath:         "/metrics",
 		},
 		InfluxDBService: {
 			ServicePort:         8086,
 			ServiceProtocol:     "tcp",
 			ExtraAttributeNames: []string{"address", "port"},
+			MetricsPort:         8086,
+			MetricsPath:         "/metrics",
 		},
 		JIRAService: {
 			ServicePort:         8080,
@@ -282,6 +336,13 @@ var (
 			ServiceProtocol:     "tcp",
 			ExtraAttributeNames: []string{"address", "port"},
 		},
+		MinIOService: {
+			ServicePort:         9000,
+			ServiceProtocol:     "tcp",
+			ExtraAttributeNames: []string{"address", "port"},
+			MetricsPort:         9000,
+			MetricsPath:         "/minio/v2/metrics/cluster",
+		},
 		MongoDBService: {
 			ServicePort:         27017,
 			ServiceProtocol:     "tcp",
@@ -322,7 +383,7 @@ var (
 		PostfixService: {
 			ServicePort:         25,
 			ServiceProtocol:     "tcp",
-			ExtraAttributeNames: []string{"address", "port"},
+			ExtraAttributeNames: []string{"address", "port", "mail_username", "mail_password", "mail_starttls"},
 		},
 		PostgreSQLService: {
 			ServicePort:         5432,
@@ -334,6 +395,8 @@ var (
 			ServiceProtocol:     "tcp",
 			IgnoreHighPort:      true,
 			ExtraAttributeNames: []string{"address", "port", "username", "password", "mgmt_port"},
+			MetricsPort:         15692,
+			MetricsPath:         "/metrics",
 		},
 		RedisService: {
 			ServicePort:         6379,
@@ -350,6 +413,13 @@ var (
 			ServiceProtocol:     "tcp",
 			ExtraAttributeNames: []string{"address", "port"},
 		},
+		TraefikService: {
+			ServicePort:         80,
+			ServiceProtocol:     "tcp",
+			ExtraAttributeNames: []string{"address", "port"},
+			MetricsPort:         8080,
+			MetricsPath:         "/metrics",
+		},
 		VarnishService: {
 			ServicePort:         6082,
 			ServiceProtocol:     "tcp",
@@ -363,7 +433,14 @@ var (
 		},
 
 		CustomService: {
-			ExtraAttributeNames: []string{"address", "port", "check_type", "check_command", "http_path", "http_status_code"},
+			ExtraAttributeNames: []string{
+				"address", "port", "check_type", "check_command", "http_path", "http_status_code",
+				"http_method", "http_body", "http_headers", "http_username", "http_password", "http_bearer_token",
+				"http_expected_content", "http_expected_regex", "http_response_time_warning", "http_response_time_critical",
+				"dns_query_name", "dns_query_type", "dns_expected",
+				"dns_min_ttl", "dns_max_ttl", "dns_response_time_warning", "dns_response_time_critical",
+				"mail_username", "mail_password", "mail_starttls",
+			},
 		},
 	}
 )
@@ -375,4 +452,7 @@ type discoveryInfo struct {
 	DisablePersistentConnection bool
 	ExtraAttributeNames         []string
 	DefaultIgnoredPorts         map[int]bool
+	// MetricsPort and MetricsPath locate the Prometheus exporter shipped with the service.
+	MetricsPort int
+	MetricsPath string
 }
@@ -0,0 +1,49 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+// Labels set by Docker Compose and Docker Swarm on containers.
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+	swarmStackLabel     = "com.docker.stack.namespace"
+	swarmServiceLabel   = "com.docker.swarm.service.name"
+)
+
+// composeProject returns the Docker Compose project or the Swarm stack of a container.
+func composeProject(labels map[string]string) string {
+	if project := labels[composeProjectLabel]; project != "" {
+		return project
+	}
+
+	return labels[swarmStackLabel]
+}
+
+// composeService returns the name shared by all replicas of a Docker Compose or Swarm
+// service, empty if the container isn't part of one.
+func composeService(labels map[string]string) string {
+	if service := labels[composeServiceLabel]; service != "" {
+		if project := labels[composeProjectLabel]; project != "" {
+			return project + "_" + service
+		}
+
+		return service
+	}
+
+	// Swarm service names are already prefixed by the stack.
+	return labels[swarmServiceLabel]
+}
@@ -0,0 +1,119 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"glouton/facts"
+	"testing"
+)
+
+func TestComposeLabels(t *testing.T) {
+	cases := []struct {
+		labels      map[string]string
+		wantProject string
+		wantService string
+	}{
+		{
+			labels: nil,
+		},
+		{
+			labels: map[string]string{
+				composeProjectLabel: "myproject",
+				composeServiceLabel: "web",
+			},
+			wantProject: "myproject",
+			wantService: "myproject_web",
+		},
+		{
+			labels: map[string]string{
+				swarmStackLabel:   "mystack",
+				swarmServiceLabel: "mystack_web",
+			},
+			wantProject: "mystack",
+			wantService: "mystack_web",
+		},
+	}
+
+	for i, c := range cases {
+		if got := composeProject(c.labels); got != c.wantProject {
+			t.Errorf("composeProject(<case #%d>) == %#v, want %#v", i, got, c.wantProject)
+		}
+
+		if got := composeService(c.labels); got != c.wantService {
+			t.Errorf("composeService(<case #%d>) == %#v, want %#v", i, got, c.wantService)
+		}
+	}
+}
+
+func TestGroupReplicas(t *testing.T) {
+	labels := map[string]string{
+		composeProjectLabel: "myproject",
+		composeServiceLabel: "cache",
+	}
+
+	newDiscovery := func(groupReplicas bool) *DynamicDiscovery {
+		return &DynamicDiscovery{
+			ps: mockProcess{
+				[]facts.Process{
+					{PID: 42, CmdLineList: []string{"redis-server *:6379"}, ContainerID: "bbb", ContainerName: "myproject_cache_2"},
+					{PID: 43, CmdLineList: []string{"redis-server *:6379"}, ContainerID: "aaa", ContainerName: "myproject_cache_1"},
+				},
+			},
+			netstat: mockNetstat{},
+			containerInfo: mockContainerInfo{
+				containers: map[string]mockContainer{
+					"aaa": {ipAddress: "172.17.0.2", labels: labels},
+					"bbb": {ipAddress: "172.17.0.3", labels: labels},
+				},
+			},
+			groupReplicas: groupReplicas,
+		}
+	}
+
+	srv, err := newDiscovery(false).Discovery(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv) != 2 {
+		t.Errorf("len(srv) == %d, want 2", len(srv))
+	}
+
+	for _, s := range srv {
+		if s.ComposeProject != "myproject" {
+			t.Errorf("ComposeProject == %#v, want %#v", s.ComposeProject, "myproject")
+		}
+	}
+
+	srv, err = newDiscovery(true).Discovery(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv) != 1 {
+		t.Fatalf("len(srv) == %d, want 1", len(srv))
+	}
+
+	if srv[0].ContainerName != "myproject_cache" {
+		t.Errorf("ContainerName == %#v, want %#v", srv[0].ContainerName, "myproject_cache")
+	}
+
+	if srv[0].ContainerID != "aaa" {
+		t.Errorf("ContainerID == %#v, want %#v", srv[0].ContainerID, "aaa")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"sort"
+	"strings"
+)
+
+// parseDependencies returns the service names of a comma separated depends_on value.
+func parseDependencies(value string) []string {
+	var result []string
+
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+// setDependencies updates the dependencies used by the check of each service and
+// forgets the check status of the services which no longer exist.
+//
+// Services in a dependency cycle could hide each other criticals, their dependencies are ignored.
+func (d *Discovery) setDependencies(services map[NameContainer]Service) {
+	cycles := dependencyCycles(services)
+	if len(cycles) > 0 {
+		logger.V(1).PrintfLimited("The dependencies of services %v are ignored, they depend on themselves", cycles)
+	}
+
+	inCycle := make(map[string]bool, len(cycles))
+
+	for _, name := range cycles {
+		inCycle[name] = true
+	}
+
+	d.checkStatusLock.Lock()
+	defer d.checkStatusLock.Unlock()
+
+	d.dependencies = make(map[NameContainer][]string)
+
+	for key, service := range services {
+		if len(service.DependsOn) > 0 && !inCycle[service.Name] {
+			d.dependencies[key] = service.DependsOn
+		}
+	}
+
+	for key := range d.lastCheckStatus {
+		if _, ok := services[key]; !ok {
+			delete(d.lastCheckStatus, key)
+		}
+	}
+}
+
+// dependencyStatus records the status of the check of a service. When the check is critical
+// and a service it depends on is critical too, it returns the status to use instead: the
+// service is degraded due to its dependency and doesn't fire its own critical.
+func (d *Discovery) dependencyStatus(key NameContainer, status types.StatusDescription) (types.StatusDescription, bool) {
+	d.checkStatusLock.Lock()
+	defer d.checkStatusLock.Unlock()
+
+	d.lastCheckStatus[key] = status.CurrentStatus
+
+	if status.CurrentStatus != types.StatusCritical {
+		return status, false
+	}
+
+	for _, name := range d.dependencies[key] {
+		if !d.isCritical(name) {
+			continue
+		}
+
+		degraded := types.StatusDescription{
+			CurrentStatus:     types.StatusWarning,
+			StatusDescription: fmt.Sprintf("Degraded due to dependency %s", name),
+		}
+
+		if status.StatusDescription != "" {
+			degraded.StatusDescription += ": " + status.StatusDescription
+		}
+
+		return degraded, true
+	}
+
+	return status, false
+}
+
+// isCritical returns whether the check of a service with this name is critical.
+// The check status lock must be held.
+func (d *Discovery) isCritical(name string) bool {
+	for key, status := range d.lastCheckStatus {
+		if key.Name == name && status == types.StatusCritical {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dependencyCycles returns the names of the services which depend on themselves, directly or not.
+func dependencyCycles(services map[NameContainer]Service) []string {
+	dependsOn := make(map[string][]string)
+
+	for _, service := range services {
+		dependsOn[service.Name] = append(dependsOn[service.Name], service.DependsOn...)
+	}
+
+	var result []string
+
+	for name := range dependsOn {
+		visited := map[string]bool{}
+		queue := append([]string(nil), dependsOn[name]...)
+
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+
+			if current == name {
+				result = append(result, name)
+
+				break
+			}
+
+			if visited[current] {
+				continue
+			}
+
+			visited[current] = true
+			queue = append(queue, dependsOn[current]...)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
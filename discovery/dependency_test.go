@@ -0,0 +1,112 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"glouton/types"
+	"reflect"
+	"testing"
+)
+
+func TestApplyOverrideDependsOn(t *testing.T) {
+	discovered := map[NameContainer]Service{
+		{Name: "apache"}: {Name: "apache", ServiceType: ApacheService, Active: true},
+	}
+	overrides := map[NameContainer]map[string]string{
+		{Name: "apache"}: {"depends_on": "mysql, redis,"},
+	}
+
+	got := applyOveride(discovered, overrides)[NameContainer{Name: "apache"}].DependsOn
+	want := []string{"mysql", "redis"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DependsOn = %v, want %v", got, want)
+	}
+}
+
+func TestDependencyStatus(t *testing.T) {
+	web := NameContainer{Name: "web"}
+	mysql := NameContainer{Name: "mysql", ContainerName: "db-1"}
+
+	d := &Discovery{lastCheckStatus: make(map[NameContainer]types.Status)}
+	d.setDependencies(map[NameContainer]Service{
+		web:   {Name: "web", DependsOn: []string{"mysql"}},
+		mysql: {Name: "mysql", ContainerName: "db-1"},
+	})
+
+	critical := types.StatusDescription{CurrentStatus: types.StatusCritical, StatusDescription: "Connection refused"}
+
+	steps := []struct {
+		key          NameContainer
+		status       types.StatusDescription
+		want         types.StatusDescription
+		wantDegraded bool
+	}{
+		// The status of mysql is unknown, web fires its own critical.
+		{key: web, status: critical, want: critical},
+		{key: mysql, status: critical, want: critical},
+		{
+			key:    web,
+			status: critical,
+			want: types.StatusDescription{
+				CurrentStatus:     types.StatusWarning,
+				StatusDescription: "Degraded due to dependency mysql: Connection refused",
+			},
+			wantDegraded: true,
+		},
+		{key: web, status: types.StatusDescription{CurrentStatus: types.StatusOk}, want: types.StatusDescription{CurrentStatus: types.StatusOk}},
+		{key: mysql, status: types.StatusDescription{CurrentStatus: types.StatusOk}, want: types.StatusDescription{CurrentStatus: types.StatusOk}},
+		{key: web, status: critical, want: critical},
+	}
+
+	for i, step := range steps {
+		got, degraded := d.dependencyStatus(step.key, step.status)
+		if got != step.want || degraded != step.wantDegraded {
+			t.Errorf("step %d: dependencyStatus() = %v, %v, want %v, %v", i, got, degraded, step.want, step.wantDegraded)
+		}
+	}
+
+	// Removed services are forgotten.
+	d.setDependencies(map[NameContainer]Service{web: {Name: "web", DependsOn: []string{"mysql"}}})
+
+	if _, ok := d.lastCheckStatus[mysql]; ok {
+		t.Errorf("the status of the removed service mysql is still known")
+	}
+}
+
+func TestDependencyCycles(t *testing.T) {
+	services := map[NameContainer]Service{
+		{Name: "a"}: {Name: "a", DependsOn: []string{"b"}},
+		{Name: "b"}: {Name: "b", DependsOn: []string{"a"}},
+		{Name: "c"}: {Name: "c", DependsOn: []string{"a"}},
+		{Name: "d"}: {Name: "d", DependsOn: []string{"d"}},
+		{Name: "e"}: {Name: "e"},
+	}
+
+	want := []string{"a", "b", "d"}
+
+	if got := dependencyCycles(services); !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencyCycles() = %v, want %v", got, want)
+	}
+
+	d := &Discovery{lastCheckStatus: make(map[NameContainer]types.Status)}
+	d.setDependencies(services)
+
+	if got := d.dependencies; len(got) != 1 || !reflect.DeepEqual(got[NameContainer{Name: "c"}], []string{"a"}) {
+		t.Errorf("dependencies = %v, want only the one of c", got)
+	}
+}
@@ -19,6 +19,8 @@ package discovery
 import (
 	"context"
 	"fmt"
+	"glouton/audit"
+	"glouton/check"
 	"glouton/facts"
 	"glouton/inputs"
 	"glouton/logger"
@@ -41,6 +43,13 @@ const localhostIP = "127.0.0.1"
 const (
 	nrpeExposedName = "nagios_nrpe_name"
 	ignoredPorts    = "ignore_ports"
+	serviceTTL      = "ttl"
+	serviceStack    = "stack"
+	dependsOn       = "depends_on"
+	checkPool       = "check_pool"
+	scrapeMetrics   = "scrape_metrics"
+	metricsPort     = "metrics_port"
+	metricsPath     = "metrics_path"
 )
 
 // Discovery implement the full discovery mecanisme. It will take informations
@@ -55,25 +64,40 @@ type Discovery struct {
 	discoveredServicesMap map[NameContainer]Service
 	servicesMap           map[NameContainer]Service
 	lastDiscoveryUpdate   time.Time
+	lastDiscoveryDuration time.Duration
 
 	acc                   inputs.AnnotationAccumulator
 	lastConfigservicesMap map[NameContainer]Service
 	activeCollector       map[NameContainer]collectorDetails
 	activeCheck           map[NameContainer]CheckDetails
+	activeScrapper        map[NameContainer]int
 	coll                  Collector
 	taskRegistry          Registry
 	metricRegistry        GathererRegistry
 	containerInfo         containerInfoProvider
 	state                 State
 	servicesOverride      map[NameContainer]map[string]string
+	manualServices        map[string]ManualService
+	isServiceIgnored      func(NameContainer) bool
 	isCheckIgnored        func(NameContainer) bool
 	isInputIgnored        func(NameContainer) bool
 	metricFormat          types.MetricFormat
+	checkPools            *check.Pools
+	auditLog              *audit.Log
+
+	lastCheckOkLock sync.Mutex
+	lastCheckOk     map[NameContainer]time.Time
+	expiredServices map[NameContainer]bool
+
+	checkStatusLock sync.Mutex
+	lastCheckStatus map[NameContainer]types.Status
+	dependencies    map[NameContainer][]string
 }
 
 // Collector will gather metrics for added inputs.
 type Collector interface {
 	AddInput(input telegraf.Input, shortName string) (int, error)
+	AddInputWithItem(input telegraf.Input, shortName string, item string) (int, error)
 	RemoveInput(int)
 }
 
@@ -90,7 +114,7 @@ type GathererRegistry interface {
 }
 
 // New returns a new Discovery.
-func New(dynamicDiscovery Discoverer, coll Collector, metricRegistry GathererRegistry, taskRegistry Registry, state State, acc inputs.AnnotationAccumulator, containerInfo *facts.DockerProvider, servicesOverride []map[string]string, isCheckIgnored func(NameContainer) bool, isInputIgnored func(NameContainer) bool, metricFormat types.MetricFormat) *Discovery {
+func New(dynamicDiscovery Discoverer, coll Collector, metricRegistry GathererRegistry, taskRegistry Registry, state State, acc inputs.AnnotationAccumulator, containerInfo *facts.DockerProvider, servicesOverride []map[string]string, isCheckIgnored func(NameContainer) bool, isInputIgnored func(NameContainer) bool, metricFormat types.MetricFormat, checkPools *check.Pools) *Discovery {
 	initialServices := servicesFromState(state)
 	discoveredServicesMap := make(map[NameContainer]Service, len(initialServices))
 
@@ -99,6 +123,7 @@ func New(dynamicDiscovery Discoverer, coll Collector, metricRegistry GathererReg
 			Name:          v.Name,
 			ContainerName: v.ContainerName,
 		}
+		v.Stale = true
 		discoveredServicesMap[key] = v
 	}
 
@@ -132,14 +157,37 @@ func New(dynamicDiscovery Discoverer, coll Collector, metricRegistry GathererReg
 		acc:                   acc,
 		activeCollector:       make(map[NameContainer]collectorDetails),
 		activeCheck:           make(map[NameContainer]CheckDetails),
+		activeScrapper:        make(map[NameContainer]int),
 		state:                 state,
 		servicesOverride:      servicesOverrideMap,
+		manualServices:        manualServicesFromState(state),
 		isCheckIgnored:        isCheckIgnored,
 		isInputIgnored:        isInputIgnored,
 		metricFormat:          metricFormat,
+		checkPools:            checkPools,
+		lastCheckOk:           make(map[NameContainer]time.Time),
+		expiredServices:       make(map[NameContainer]bool),
+		lastCheckStatus:       make(map[NameContainer]types.Status),
 	}
 }
 
+// SetServiceIgnored sets the function telling which services are ignored. Ignored services
+// are still discovered but they are not returned and have no metric input nor check.
+func (d *Discovery) SetServiceIgnored(isServiceIgnored func(NameContainer) bool) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	d.isServiceIgnored = isServiceIgnored
+}
+
+// SetAuditLog sets the log where the services and inputs changes are recorded.
+func (d *Discovery) SetAuditLog(auditLog *audit.Log) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	d.auditLog = auditLog
+}
+
 // Close stop & cleanup inputs & check created by the discovery.
 func (d *Discovery) Close() {
 	d.l.Lock()
@@ -160,6 +208,27 @@ func (d *Discovery) Discovery(ctx context.Context, maxAge time.Duration) (servic
 	return d.discovery(ctx, maxAge)
 }
 
+// WarmStart configures metric inputs and checks for services restored from
+// state.json, without waiting for the first discovery.
+//
+// Restored services are marked Stale until a discovery confirms them. It does
+// nothing if a discovery already ran.
+func (d *Discovery) WarmStart() {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	if d.servicesMap != nil || len(d.discoveredServicesMap) == 0 {
+		return
+	}
+
+	d.servicesMap = d.applyOverrides(d.discoveredServicesMap)
+	d.ignoreServicesAndPorts()
+
+	logger.V(2).Printf("Warm start of discovery with %d services restored from state", len(d.servicesMap))
+
+	d.reconfigure()
+}
+
 // LastUpdate return when the last update occurred.
 func (d *Discovery) LastUpdate() time.Time {
 	d.l.Lock()
@@ -168,8 +237,18 @@ func (d *Discovery) LastUpdate() time.Time {
 	return d.lastDiscoveryUpdate
 }
 
+// LastDuration return how long the last update took.
+func (d *Discovery) LastDuration() time.Duration {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	return d.lastDiscoveryDuration
+}
+
 func (d *Discovery) discovery(ctx context.Context, maxAge time.Duration) (services []Service, err error) {
 	if time.Since(d.lastDiscoveryUpdate) >= maxAge {
+		t0 := time.Now()
+
 		err := d.updateDiscovery(ctx, maxAge)
 		if err != nil {
 			return nil, err
@@ -180,6 +259,7 @@ func (d *Discovery) discovery(ctx context.Context, maxAge time.Duration) (servic
 			d.reconfigure()
 
 			d.lastDiscoveryUpdate = time.Now()
+			d.lastDiscoveryDuration = time.Since(t0)
 		}
 	}
 
@@ -219,16 +299,41 @@ func (d *Discovery) RemoveIfNonRunning(ctx context.Context, services []Service)
 }
 
 func (d *Discovery) reconfigure() {
+	d.auditServicesChange(d.lastConfigservicesMap, d.servicesMap)
+
 	err := d.configureMetricInputs(d.lastConfigservicesMap, d.servicesMap)
 	if err != nil {
 		logger.Printf("Unable to update metric inputs: %v", err)
 	}
 
 	d.configureChecks(d.lastConfigservicesMap, d.servicesMap)
+	d.setDependencies(d.servicesMap)
 
 	d.lastConfigservicesMap = d.servicesMap
 }
 
+// auditServicesChange records the services added and removed since the last configuration.
+// On the first configuration, only the number of services is recorded.
+func (d *Discovery) auditServicesChange(oldServices, services map[NameContainer]Service) {
+	if oldServices == nil {
+		d.auditLog.Record(audit.KindDiscovery, "Initial discovery found %d services", len(services))
+
+		return
+	}
+
+	for key, service := range services {
+		if _, ok := oldServices[key]; !ok {
+			d.auditLog.Record(audit.KindDiscovery, "Service %s added", service)
+		}
+	}
+
+	for key, service := range oldServices {
+		if _, ok := services[key]; !ok {
+			d.auditLog.Record(audit.KindDiscovery, "Service %s removed", service)
+		}
+	}
+}
+
 func (d *Discovery) updateDiscovery(ctx context.Context, maxAge time.Duration) error {
 	r, err := d.dynamicDiscovery.Discovery(ctx, maxAge)
 	if err != nil {
@@ -236,24 +341,93 @@ func (d *Discovery) updateDiscovery(ctx context.Context, maxAge time.Duration) e
 	}
 
 	servicesMap := make(map[NameContainer]Service)
+	now := time.Now()
 
 	for key, service := range d.discoveredServicesMap {
-		if service.ContainerID != "" {
-			if container, found := d.containerInfo.Container(service.ContainerID); !found {
-				service.Active = false
-			} else if container.StoppedAndReplaced() {
-				service.Active = false
-			}
-		} else if service.ExePath != "" {
-			if _, err := os.Stat(service.ExePath); os.IsNotExist(err) {
-				service.Active = false
-			}
+		servicesMap[key] = d.refreshActive(service)
+	}
+
+	mergeServices(servicesMap, r, now)
+
+	d.discoveredServicesMap = servicesMap
+	d.servicesMap = d.applyOverrides(servicesMap)
+
+	d.ignoreServicesAndPorts()
+	d.expireServices(now)
+
+	return nil
+}
+
+// UpdateContainer re-discovers only the services of a container, e.g. after a Docker event.
+// A full discovery is done if the discovery doesn't support it or never ran.
+func (d *Discovery) UpdateContainer(ctx context.Context, containerID string) (services []Service, err error) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	cd, ok := d.dynamicDiscovery.(containerDiscoverer)
+	if !ok || d.servicesMap == nil || d.lastDiscoveryUpdate.IsZero() {
+		return d.discovery(ctx, 0)
+	}
+
+	r, err := cd.ContainerDiscovery(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	servicesMap := make(map[NameContainer]Service, len(d.discoveredServicesMap))
+	now := time.Now()
+
+	for key, service := range d.discoveredServicesMap {
+		if service.ContainerID == containerID {
+			service = d.refreshActive(service)
 		}
 
 		servicesMap[key] = service
 	}
 
-	for _, service := range r {
+	mergeServices(servicesMap, r, now)
+
+	d.discoveredServicesMap = servicesMap
+	d.servicesMap = d.applyOverrides(servicesMap)
+
+	d.ignoreServicesAndPorts()
+	d.expireServices(now)
+
+	if ctx.Err() == nil {
+		saveState(d.state, d.discoveredServicesMap)
+		d.reconfigure()
+	}
+
+	services = make([]Service, 0, len(d.servicesMap))
+
+	for _, v := range d.servicesMap {
+		services = append(services, v)
+	}
+
+	return services, ctx.Err()
+}
+
+// refreshActive marks the service inactive if its container or executable is gone.
+// The service stays Stale, only a discovery finding it again confirms it.
+func (d *Discovery) refreshActive(service Service) Service {
+	if service.ContainerID != "" {
+		if container, found := d.containerInfo.Container(service.ContainerID); !found {
+			service.Active = false
+		} else if container.StoppedAndReplaced() {
+			service.Active = false
+		}
+	} else if service.ExePath != "" {
+		if _, err := os.Stat(service.ExePath); os.IsNotExist(err) {
+			service.Active = false
+		}
+	}
+
+	return service
+}
+
+// mergeServices adds the discovered services to servicesMap.
+func mergeServices(servicesMap map[NameContainer]Service, discovered []Service, now time.Time) {
+	for _, service := range discovered {
 		key := NameContainer{
 			Name:          service.Name,
 			ContainerName: service.ContainerName,
@@ -267,15 +441,9 @@ func (d *Discovery) updateDiscovery(ctx context.Context, maxAge time.Duration) e
 			}
 		}
 
+		service.LastSeen = now
 		servicesMap[key] = service
 	}
-
-	d.discoveredServicesMap = servicesMap
-	d.servicesMap = applyOveride(servicesMap, d.servicesOverride)
-
-	d.ignoreServicesAndPorts()
-
-	return nil
 }
 
 func applyOveride(discoveredServicesMap map[NameContainer]Service, servicesOverride map[NameContainer]map[string]string) map[NameContainer]Service {
@@ -336,6 +504,37 @@ func applyOveride(discoveredServicesMap map[NameContainer]Service, servicesOverr
 			delete(overrideCopy, ignoredPorts)
 		}
 
+		if value, ok := overrideCopy[serviceTTL]; ok {
+			ttl, err := strconv.ParseInt(strings.TrimSpace(value), 10, 0)
+			if err != nil || ttl < 0 {
+				logger.V(1).Printf("In %s for service %s: invalid TTL %#v", serviceTTL, serviceKey, value)
+			} else {
+				service.TTL = time.Duration(ttl) * time.Second
+			}
+
+			delete(overrideCopy, serviceTTL)
+		}
+
+		if value, ok := overrideCopy[serviceStack]; ok {
+			service.Stack = value
+
+			delete(overrideCopy, serviceStack)
+		}
+
+		if value, ok := overrideCopy[dependsOn]; ok {
+			service.DependsOn = parseDependencies(value)
+
+			delete(overrideCopy, dependsOn)
+		}
+
+		for _, name := range []string{checkPool, scrapeMetrics, metricsPort, metricsPath} {
+			if value, ok := overrideCopy[name]; ok {
+				service.ExtraAttributes[name] = value
+
+				delete(overrideCopy, name)
+			}
+		}
+
 		di := servicesDiscoveryInfo[service.ServiceType]
 		for _, name := range di.ExtraAttributeNames {
 			if value, ok := overrideCopy[name]; ok {
@@ -396,6 +595,13 @@ func applyOveride(discoveredServicesMap map[NameContainer]Service, servicesOverr
 func (d *Discovery) ignoreServicesAndPorts() {
 	servicesMap := d.servicesMap
 	for nameContainer, service := range servicesMap {
+		if d.isServiceIgnored != nil && d.isServiceIgnored(nameContainer) {
+			logger.V(2).Printf("The service '%s' on container '%s' is ignored by the configuration", service.Name, service.ContainerID)
+			delete(d.servicesMap, nameContainer)
+
+			continue
+		}
+
 		if d.isCheckIgnored != nil {
 			service.CheckIgnored = d.isCheckIgnored(nameContainer)
 		}
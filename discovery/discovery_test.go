@@ -24,6 +24,7 @@ import (
 	"glouton/types"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/influxdata/telegraf"
 )
@@ -63,6 +64,10 @@ func (m *mockCollector) AddInput(_ telegraf.Input, name string) (int, error) {
 	return m.NewID, nil
 }
 
+func (m *mockCollector) AddInputWithItem(input telegraf.Input, name string, _ string) (int, error) {
+	return m.AddInput(input, name)
+}
+
 func (m *mockCollector) RemoveInput(id int) {
 	if id != m.ExpectedRemoveID {
 		m.err = fmt.Errorf("RemoveInput(%d), want name=%d", id, m.ExpectedRemoveID)
@@ -206,7 +211,7 @@ func TestDiscoverySingle(t *testing.T) {
 		state := mockState{
 			DiscoveredService: previousService,
 		}
-		disc := New(MockDiscoverer{result: []Service{c.dynamicResult}}, nil, nil, nil, state, nil, nil, nil, nil, nil, types.MetricFormatBleemeo)
+		disc := New(MockDiscoverer{result: []Service{c.dynamicResult}}, nil, nil, nil, state, nil, nil, nil, nil, nil, types.MetricFormatBleemeo, nil)
 
 		srv, err := disc.Discovery(ctx, 0)
 		if err != nil {
@@ -309,6 +314,31 @@ func Test_applyOveride(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "stack override",
+			args: args{
+				discoveredServicesMap: map[NameContainer]Service{
+					{Name: "apache"}: {
+						Name:        "apache",
+						ServiceType: ApacheService,
+						Stack:       "default",
+					},
+				},
+				servicesOverride: map[NameContainer]map[string]string{
+					{Name: "apache"}: {
+						"stack": "website",
+					},
+				},
+			},
+			want: map[NameContainer]Service{
+				{Name: "apache"}: {
+					Name:            "apache",
+					ServiceType:     ApacheService,
+					Stack:           "website",
+					ExtraAttributes: map[string]string{},
+				},
+			},
+		},
 		{
 			name: "address override & ignore unknown override",
 			args: args{
@@ -487,7 +517,7 @@ func TestUpdateMetricsAndCheck(t *testing.T) {
 		},
 	}
 	state := mockState{}
-	disc := New(mockDynamic, fakeCollector, nil, nil, state, nil, nil, nil, nil, nil, types.MetricFormatBleemeo)
+	disc := New(mockDynamic, fakeCollector, nil, nil, state, nil, nil, nil, nil, nil, types.MetricFormatBleemeo, nil)
 	disc.containerInfo = docker
 
 	mockDynamic.result = []Service{
@@ -572,3 +602,308 @@ func TestUpdateMetricsAndCheck(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestUpdateContainer(t *testing.T) {
+	fakeCollector := &mockCollector{
+		ExpectedAddedName: "nginx",
+		NewID:             42,
+	}
+	mockDynamic := NewMockDiscoverer()
+	docker := mockContainerInfo{
+		containers: map[string]mockContainer{
+			"1234": {},
+		},
+	}
+	disc := New(mockDynamic, fakeCollector, nil, nil, mockState{}, nil, nil, nil, nil, nil, types.MetricFormatBleemeo, nil)
+	disc.containerInfo = docker
+
+	nginx := Service{
+		Name:            "nginx",
+		ServiceType:     NginxService,
+		Active:          true,
+		ContainerID:     "1234",
+		ContainerName:   "web",
+		IPAddress:       "172.16.0.2",
+		ListenAddresses: []facts.ListenAddress{{NetworkFamily: "tcp", Address: "172.16.0.2", Port: 80}},
+	}
+	memcached := Service{
+		Name:            "memcached",
+		ServiceType:     MemcachedService,
+		Active:          true,
+		ContainerID:     "1234",
+		ContainerName:   "web",
+		IPAddress:       "172.16.0.2",
+		ListenAddresses: []facts.ListenAddress{{NetworkFamily: "tcp", Address: "172.16.0.2", Port: 11211}},
+	}
+	hostService := Service{
+		Name:            "redis",
+		ServiceType:     RedisService,
+		Active:          true,
+		IPAddress:       "127.0.0.1",
+		ListenAddresses: []facts.ListenAddress{{NetworkFamily: "tcp", Address: "127.0.0.1", Port: 6379}},
+	}
+
+	mockDynamic.result = []Service{nginx}
+
+	if _, err := disc.Discovery(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fakeCollector.ExpectationFullified(); err != nil {
+		t.Error(err)
+	}
+
+	// Only the services of the container are updated, the host service isn't discovered yet.
+	mockDynamic.result = []Service{nginx, memcached, hostService}
+	fakeCollector.ExpectedAddedName = "memcached"
+	fakeCollector.NewID = 43
+
+	services, err := disc.UpdateContainer(context.Background(), "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fakeCollector.ExpectationFullified(); err != nil {
+		t.Error(err)
+	}
+
+	names := make(map[string]bool)
+
+	for _, s := range services {
+		names[s.Name] = true
+	}
+
+	if !names["nginx"] || !names["memcached"] || names["redis"] {
+		t.Errorf("UpdateContainer() returned %v, want nginx and memcached only", services)
+	}
+}
+
+func TestWarmStart(t *testing.T) {
+	memcached := Service{
+		Name:            "memcached",
+		ServiceType:     MemcachedService,
+		Active:          true,
+		IPAddress:       "127.0.0.1",
+		ListenAddresses: []facts.ListenAddress{{NetworkFamily: "tcp", Address: "127.0.0.1", Port: 11211}},
+	}
+	fakeCollector := &mockCollector{
+		ExpectedAddedName: "memcached",
+		NewID:             42,
+	}
+	// redis is no longer running, the discovery won't find it again.
+	redis := Service{
+		Name:        "redis",
+		ServiceType: RedisService,
+		Active:      false,
+	}
+	mockDynamic := NewMockDiscoverer()
+	state := mockState{
+		DiscoveredService: []Service{memcached, redis},
+	}
+	disc := New(mockDynamic, fakeCollector, nil, nil, state, nil, nil, nil, nil, nil, types.MetricFormatBleemeo, nil)
+
+	disc.WarmStart()
+
+	if err := fakeCollector.ExpectationFullified(); err != nil {
+		t.Error(err)
+	}
+
+	key := NameContainer{Name: "memcached"}
+	if !disc.servicesMap[key].Stale {
+		t.Errorf("servicesMap[%v].Stale = false, want true", key)
+	}
+
+	mockDynamic.result = []Service{memcached}
+
+	srv, err := disc.Discovery(context.Background(), 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The input must not be re-created, mockCollector would fail on AddInput
+	if err := fakeCollector.ExpectationFullified(); err != nil {
+		t.Error(err)
+	}
+
+	if len(srv) != 2 {
+		t.Fatalf("len(srv) == %v, want 2", len(srv))
+	}
+
+	for _, service := range srv {
+		switch service.Name {
+		case "memcached":
+			if service.Stale {
+				t.Errorf("memcached.Stale = true, want false")
+			}
+
+			if service.LastSeen.IsZero() {
+				t.Errorf("memcached.LastSeen is zero, want it set")
+			}
+		case "redis":
+			if !service.Stale {
+				t.Errorf("redis.Stale = false, want true, the discovery didn't find it")
+			}
+		}
+	}
+}
+
+func TestExpireServices(t *testing.T) {
+	disc := New(NewMockDiscoverer(), nil, nil, nil, mockState{}, nil, nil, nil, nil, nil, types.MetricFormatBleemeo, nil)
+	t0 := time.Now()
+
+	customKey := NameContainer{Name: "custom"}
+	discoveredKey := NameContainer{Name: "nginx"}
+
+	disc.servicesMap = map[NameContainer]Service{
+		customKey: {
+			Name:        "custom",
+			ServiceType: CustomService,
+			Active:      true,
+			TTL:         time.Hour,
+		},
+		discoveredKey: {
+			Name:        "nginx",
+			ServiceType: NginxService,
+			Active:      true,
+			TTL:         time.Hour,
+			LastSeen:    t0.Add(-2 * time.Hour),
+		},
+	}
+
+	disc.expireServices(t0)
+
+	if srv := disc.servicesMap[customKey]; srv.Expired || !srv.Active {
+		t.Errorf("custom service is expired on first run")
+	}
+
+	if srv := disc.servicesMap[discoveredKey]; !srv.Expired || srv.Active {
+		t.Errorf("nginx service isn't expired, LastSeen is older than TTL")
+	}
+
+	disc.markCheckOk(customKey, t0.Add(30*time.Minute))
+	disc.expireServices(t0.Add(80 * time.Minute))
+
+	if srv := disc.servicesMap[customKey]; srv.Expired {
+		t.Errorf("custom service is expired, but its check succeeded during the TTL")
+	}
+
+	disc.expireServices(t0.Add(100 * time.Minute))
+
+	if srv := disc.servicesMap[customKey]; !srv.Expired || srv.Active {
+		t.Errorf("custom service isn't expired")
+	}
+}
+
+func TestExpireServicesSeenAgain(t *testing.T) {
+	disc := New(NewMockDiscoverer(), nil, nil, nil, mockState{}, nil, nil, nil, nil, nil, types.MetricFormatBleemeo, nil)
+	t0 := time.Now()
+
+	customKey := NameContainer{Name: "custom"}
+	discoveredKey := NameContainer{Name: "nginx"}
+
+	disc.servicesMap = map[NameContainer]Service{
+		customKey: {
+			Name:        "custom",
+			ServiceType: CustomService,
+			Active:      true,
+			TTL:         time.Hour,
+		},
+		discoveredKey: {
+			Name:        "nginx",
+			ServiceType: NginxService,
+			Active:      true,
+			TTL:         time.Hour,
+			LastSeen:    t0.Add(-2 * time.Hour),
+		},
+	}
+
+	disc.expireServices(t0)
+	disc.expireServices(t0.Add(2 * time.Hour))
+
+	for _, key := range []NameContainer{customKey, discoveredKey} {
+		if srv := disc.servicesMap[key]; !srv.Expired || srv.Active {
+			t.Errorf("service %v isn't expired", key)
+		}
+
+		if !disc.isExpired(key) {
+			t.Errorf("isExpired(%v) = false, want true", key)
+		}
+	}
+
+	// The check of the custom service succeed again and nginx is found again by the discovery.
+	disc.markCheckOk(customKey, t0.Add(130*time.Minute))
+
+	nginx := disc.servicesMap[discoveredKey]
+	nginx.LastSeen = t0.Add(130 * time.Minute)
+	disc.servicesMap[discoveredKey] = nginx
+
+	disc.expireServices(t0.Add(140 * time.Minute))
+
+	for _, key := range []NameContainer{customKey, discoveredKey} {
+		if srv := disc.servicesMap[key]; srv.Expired || !srv.Active {
+			t.Errorf("service %v = {Expired: %v, Active: %v}, want it active again", key, srv.Expired, srv.Active)
+		}
+
+		if disc.isExpired(key) {
+			t.Errorf("isExpired(%v) = true, want false", key)
+		}
+	}
+
+	// Removed services are forgotten.
+	delete(disc.servicesMap, customKey)
+	disc.expireServices(t0.Add(150 * time.Minute))
+
+	if _, ok := disc.lastCheckOk[customKey]; ok {
+		t.Errorf("lastCheckOk still contains the removed service %v", customKey)
+	}
+}
+
+func TestServiceIgnored(t *testing.T) {
+	fakeCollector := &mockCollector{
+		ExpectedAddedName: "memcached",
+		NewID:             42,
+	}
+	mockDynamic := NewMockDiscoverer()
+	docker := mockContainerInfo{
+		containers: map[string]mockContainer{
+			"1234": {},
+		},
+	}
+	disc := New(mockDynamic, fakeCollector, nil, nil, mockState{}, nil, nil, nil, nil, nil, types.MetricFormatBleemeo, nil)
+	disc.containerInfo = docker
+	disc.SetServiceIgnored(NewIgnoredService([]map[string]string{
+		{"name": "*", "instance": "container:ci-*"},
+	}).IsServiceIgnored)
+
+	mockDynamic.result = []Service{
+		{
+			Name:            "nginx",
+			ServiceType:     NginxService,
+			Active:          true,
+			ContainerID:     "1234",
+			ContainerName:   "ci-1234",
+			IPAddress:       "172.16.0.2",
+			ListenAddresses: []facts.ListenAddress{{NetworkFamily: "tcp", Address: "172.16.0.2", Port: 80}},
+		},
+		{
+			Name:            "memcached",
+			ServiceType:     MemcachedService,
+			Active:          true,
+			IPAddress:       "127.0.0.1",
+			ListenAddresses: []facts.ListenAddress{{NetworkFamily: "tcp", Address: "127.0.0.1", Port: 11211}},
+		},
+	}
+
+	services, err := disc.Discovery(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(services) != 1 || services[0].Name != "memcached" {
+		t.Errorf("services = %v, want only memcached", services)
+	}
+
+	if err := fakeCollector.ExpectationFullified(); err != nil {
+		t.Error(err)
+	}
+}
@@ -43,6 +43,7 @@ type DynamicDiscovery struct {
 	containerInfo containerInfoProvider
 	fileReader    fileReader
 	defaultStack  string
+	groupReplicas bool
 
 	lastDiscoveryUpdate time.Time
 	services            []Service
@@ -78,6 +79,15 @@ func NewDynamic(ps processFact, netstat netstatProvider, containerInfo *facts.Do
 	}
 }
 
+// SetGroupReplicas enables the grouping of the replicas of a Docker Compose or Swarm service
+// under a single service, named after the Compose or Swarm service instead of the container.
+func (dd *DynamicDiscovery) SetGroupReplicas(enabled bool) {
+	dd.l.Lock()
+	defer dd.l.Unlock()
+
+	dd.groupReplicas = enabled
+}
+
 // Discovery detect service running on the system and return a list of Service object.
 func (dd *DynamicDiscovery) Discovery(ctx context.Context, maxAge time.Duration) (services []Service, err error) {
 	dd.l.Lock()
@@ -142,6 +152,7 @@ var (
 		"apache2":      ApacheService,
 		"asterisk":     AsteriskService,
 		"dovecot":      DovecoteService,
+		"etcd":         EtcdService,
 		"exim4":        EximService,
 		"exim":         EximService,
 		"freeradius":   FreeradiusService,
@@ -151,6 +162,7 @@ var (
 		"libvirtd":     LibvirtService,
 		"master":       PostfixService,
 		"memcached":    MemcachedService,
+		"minio":        MinIOService,
 		"mongod":       MongoDBService,
 		"mosquitto":    MosquittoService, //nolint:misspell
 		"mysqld":       MySQLService,
@@ -164,6 +176,7 @@ var (
 		"slapd":        OpenLDAPService,
 		"squid3":       SquidService,
 		"squid":        SquidService,
+		"traefik":      TraefikService,
 		"varnishd":     VarnishService,
 		"uwsgi":        UWSGIService,
 		"uWSGI":        UWSGIService,
@@ -242,14 +255,66 @@ func (dw *dockerWrapper) Container(containerID string) (c container, found bool)
 }
 
 func (dd *DynamicDiscovery) updateDiscovery(ctx context.Context, maxAge time.Duration) error {
-	processes, err := dd.ps.Processes(ctx, maxAge)
+	servicesMap, err := dd.discoverServices(ctx, maxAge, nil)
 	if err != nil {
 		return err
 	}
 
+	dd.lastDiscoveryUpdate = time.Now()
+	services := make([]Service, 0, len(servicesMap))
+
+	for _, v := range servicesMap {
+		services = append(services, v)
+	}
+
+	dd.services = services
+
+	return nil
+}
+
+// ContainerDiscovery detects the services running in one container. The services of the
+// host and of other containers are kept from the previous discovery.
+func (dd *DynamicDiscovery) ContainerDiscovery(ctx context.Context, containerID string) (services []Service, err error) {
+	dd.l.Lock()
+	defer dd.l.Unlock()
+
+	servicesMap, err := dd.discoverServices(ctx, 0, func(p facts.Process) bool {
+		return p.ContainerID == containerID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	services = make([]Service, 0, len(servicesMap))
+	allServices := make([]Service, 0, len(dd.services)+len(servicesMap))
+
+	for _, v := range dd.services {
+		if v.ContainerID != containerID {
+			allServices = append(allServices, v)
+		}
+	}
+
+	for _, v := range servicesMap {
+		services = append(services, v)
+		allServices = append(allServices, v)
+	}
+
+	dd.services = allServices
+
+	return services, ctx.Err()
+}
+
+// discoverServices returns the services of the processes matching the filter, of all processes
+// if the filter is nil.
+func (dd *DynamicDiscovery) discoverServices(ctx context.Context, maxAge time.Duration, filter func(facts.Process) bool) (map[NameContainer]Service, error) {
+	processes, err := dd.ps.Processes(ctx, maxAge)
+	if err != nil {
+		return nil, err
+	}
+
 	netstat, err := dd.netstat.Netstat(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Process PID present in netstat output before other PID, because
@@ -269,7 +334,7 @@ func (dd *DynamicDiscovery) updateDiscovery(ctx context.Context, maxAge time.Dur
 
 	for _, pid := range allPids {
 		process, ok := processes[pid]
-		if !ok {
+		if !ok || filter != nil && !filter(process) {
 			continue
 		}
 
@@ -288,14 +353,6 @@ func (dd *DynamicDiscovery) updateDiscovery(ctx context.Context, maxAge time.Dur
 			Stack:         dd.defaultStack,
 		}
 
-		key := NameContainer{
-			Name:          service.Name,
-			ContainerName: service.ContainerName,
-		}
-		if _, ok := servicesMap[key]; ok {
-			continue
-		}
-
 		if service.ContainerID != "" {
 			service.container, ok = dd.containerInfo.Container(service.ContainerID)
 			if !ok {
@@ -306,13 +363,35 @@ func (dd *DynamicDiscovery) updateDiscovery(ctx context.Context, maxAge time.Dur
 				continue
 			}
 
-			if stack, ok := service.container.Labels()["bleemeo.stack"]; ok {
+			labels := service.container.Labels()
+			service.ComposeProject = composeProject(labels)
+
+			if service.ComposeProject != "" {
+				service.Stack = service.ComposeProject
+			}
+
+			if stack, ok := labels["bleemeo.stack"]; ok {
 				service.Stack = stack
 			}
 
-			if stack, ok := service.container.Labels()["glouton.stack"]; ok {
+			if stack, ok := labels["glouton.stack"]; ok {
 				service.Stack = stack
 			}
+
+			if name := composeService(labels); name != "" && dd.groupReplicas {
+				service.ContainerName = name
+			}
+		}
+
+		key := NameContainer{
+			Name:          service.Name,
+			ContainerName: service.ContainerName,
+		}
+
+		// When replicas are grouped, the container with the lowest ID is used for the
+		// whole group, so the same one is used on each discovery.
+		if previous, ok := servicesMap[key]; ok && (service.ContainerID == "" || service.ContainerID >= previous.ContainerID) {
+			continue
 		}
 
 		if service.ContainerID == "" {
@@ -358,16 +437,7 @@ func (dd *DynamicDiscovery) updateDiscovery(ctx context.Context, maxAge time.Dur
 		servicesMap[key] = service
 	}
 
-	dd.lastDiscoveryUpdate = time.Now()
-	services := make([]Service, 0, len(servicesMap))
-
-	for _, v := range servicesMap {
-		services = append(services, v)
-	}
-
-	dd.services = services
-
-	return nil
+	return servicesMap, nil
 }
 
 func (dd *DynamicDiscovery) updateListenAddresses(service *Service, di discoveryInfo) {
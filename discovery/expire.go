@@ -0,0 +1,155 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"glouton/inputs"
+	"glouton/logger"
+	"glouton/types"
+	"time"
+)
+
+// checkAccumulator forward points to an AnnotationAccumulator and record when
+// the check of a service last succeeded.
+type checkAccumulator struct {
+	inputs.AnnotationAccumulator
+	discovery *Discovery
+	key       NameContainer
+}
+
+func (a checkAccumulator) AddFieldsWithAnnotations(measurement string, fields map[string]interface{}, tags map[string]string, annotations types.MetricAnnotations, t ...time.Time) {
+	if annotations.Status.CurrentStatus == types.StatusOk {
+		a.discovery.markCheckOk(a.key, time.Now())
+	}
+
+	if a.discovery.isExpired(a.key) {
+		return
+	}
+
+	if status, degraded := a.discovery.dependencyStatus(a.key, annotations.Status); degraded {
+		annotations.Status = status
+		fields = statusFields(fields, status.CurrentStatus)
+	}
+
+	a.AnnotationAccumulator.AddFieldsWithAnnotations(measurement, fields, tags, annotations, t...)
+}
+
+// statusFields returns a copy of the fields of a check with the value of the new status.
+func statusFields(fields map[string]interface{}, status types.Status) map[string]interface{} {
+	result := make(map[string]interface{}, len(fields))
+
+	for k := range fields {
+		result[k] = status.NagiosCode()
+	}
+
+	return result
+}
+
+// checkAcc return the accumulator used by the check of given service.
+func (d *Discovery) checkAcc(service Service) inputs.AnnotationAccumulator {
+	if d.acc == nil {
+		return nil
+	}
+
+	return checkAccumulator{
+		AnnotationAccumulator: d.acc,
+		discovery:             d,
+		key:                   NameContainer{Name: service.Name, ContainerName: service.ContainerName},
+	}
+}
+
+func (d *Discovery) markCheckOk(key NameContainer, now time.Time) {
+	d.lastCheckOkLock.Lock()
+	defer d.lastCheckOkLock.Unlock()
+
+	d.lastCheckOk[key] = now
+}
+
+func (d *Discovery) isExpired(key NameContainer) bool {
+	d.lastCheckOkLock.Lock()
+	defer d.lastCheckOkLock.Unlock()
+
+	return d.expiredServices[key]
+}
+
+// expireServices marks as expired services with a TTL which were neither found
+// by the dynamic discovery nor had a successful check during the TTL. A service
+// seen again is no longer expired and gets back its active state.
+//
+// An expired service is inactive, so its metrics are stopped.
+func (d *Discovery) expireServices(now time.Time) {
+	d.lastCheckOkLock.Lock()
+	defer d.lastCheckOkLock.Unlock()
+
+	// Forget about services which were removed or no longer have a TTL.
+	for key := range d.lastCheckOk {
+		if service, ok := d.servicesMap[key]; !ok || service.TTL == 0 {
+			delete(d.lastCheckOk, key)
+		}
+	}
+
+	for key := range d.expiredServices {
+		if service, ok := d.servicesMap[key]; !ok || service.TTL == 0 {
+			delete(d.expiredServices, key)
+		}
+	}
+
+	for key, service := range d.servicesMap {
+		if service.TTL == 0 {
+			continue
+		}
+
+		lastSeen := service.LastSeen
+
+		if t := d.lastCheckOk[key]; t.After(lastSeen) {
+			lastSeen = t
+		}
+
+		if lastSeen.IsZero() {
+			// The TTL start when the service is first known.
+			d.lastCheckOk[key] = now
+
+			continue
+		}
+
+		if now.Sub(lastSeen) <= service.TTL {
+			if d.expiredServices[key] {
+				logger.V(1).Printf("Service %s was seen again at %v, it's no longer expired", service, lastSeen.Format(time.RFC3339))
+				delete(d.expiredServices, key)
+			}
+
+			if service.Expired {
+				refreshed := service
+				refreshed.Expired = false
+				refreshed.Active = true
+				refreshed = d.refreshActive(refreshed)
+				d.servicesMap[key] = refreshed
+			}
+
+			continue
+		}
+
+		if !d.expiredServices[key] {
+			logger.V(1).Printf("Service %s wasn't seen since %v, it's now expired", service, lastSeen.Format(time.RFC3339))
+			d.expiredServices[key] = true
+		}
+
+		service.Expired = true
+		service.Active = false
+		d.servicesMap[key] = service
+	}
+}
@@ -34,9 +34,13 @@ func NewIgnoredService(ignoredChecks []map[string]string) IgnoredService {
 }
 
 // IsServiceIgnored returns if the check or the metrics are ignored or not.
+//
+// The name of the service could be a glob pattern (e.g. "*" matches all services).
+// The instances are "host:*" for services running on the host and "container:<glob>"
+// for services running in a container whose name matches the glob.
 func (ic IgnoredService) IsServiceIgnored(nameContainer NameContainer) bool {
 	for _, ignoredCheck := range ic.ignoredChecks {
-		if ignoredCheck["name"] == nameContainer.Name {
+		if matchName(ignoredCheck["name"], nameContainer.Name) {
 			instances := strings.Split(ignoredCheck["instance"], " ")
 			if len(instances) == 1 && instances[0] == "" {
 				return true
@@ -54,6 +58,16 @@ func (ic IgnoredService) IsServiceIgnored(nameContainer NameContainer) bool {
 	return false
 }
 
+func matchName(pattern string, name string) bool {
+	if pattern == name {
+		return true
+	}
+
+	matched, err := filepath.Match(pattern, name)
+
+	return err == nil && matched
+}
+
 func matchInstance(instance, containerName string) bool {
 	instanceDetails := strings.Split(instance, ":")
 	if len(instanceDetails) != 2 {
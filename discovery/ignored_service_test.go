@@ -63,6 +63,13 @@ func TestIsCheckIgnored(t *testing.T) {
 			"name":     "fixed-hostname",
 			"instance": "container:web.example.com",
 		},
+		{
+			"name":     "*",
+			"instance": "container:ci-*",
+		},
+		{
+			"name": "memcache?",
+		},
 	}
 
 	ignoredChecks := NewIgnoredService(checksIgnored)
@@ -71,6 +78,27 @@ func TestIsCheckIgnored(t *testing.T) {
 		nameContainer  NameContainer
 		expectedResult bool
 	}{
+		{
+			nameContainer: NameContainer{
+				Name:          "rabbitmq",
+				ContainerName: "ci-1234",
+			},
+			expectedResult: true,
+		},
+		{
+			nameContainer: NameContainer{
+				Name:          "memcached",
+				ContainerName: "",
+			},
+			expectedResult: true,
+		},
+		{
+			nameContainer: NameContainer{
+				Name:          "memcached",
+				ContainerName: "cache",
+			},
+			expectedResult: true,
+		},
 		{
 			nameContainer: NameContainer{
 				Name:          "rabbitmq",
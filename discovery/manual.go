@@ -0,0 +1,251 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"glouton/logger"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const manualStateKey = "ManualServices"
+
+var (
+	errInvalidManualService = errors.New("invalid manual service")
+	errServiceFromConfig    = errors.New("the service is defined in the configuration")
+)
+
+// ManualService is a service created with the API. Like custom services from the
+// configuration, it's checked using its address and port.
+type ManualService struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	// CheckType is "tcp" (the default), "http", "dns", "smtp", "imap" or "pop3".
+	// Nagios checks run a command, they can only be defined in the configuration.
+	CheckType string `json:"check_type,omitempty"`
+	// ExtraAttributes are the other options of the check, e.g. "http_path".
+	ExtraAttributes map[string]string `json:"extra_attributes,omitempty"`
+	// DependsOn are the names of the services this service depends on, see Service.DependsOn.
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// Validate checks the service could be checked.
+func (s ManualService) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("%w: the name is required", errInvalidManualService)
+	}
+
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("%w: invalid port %d", errInvalidManualService, s.Port)
+	}
+
+	switch s.CheckType {
+	case "", customCheckTCP, customCheckHTTP, customCheckDNS, customCheckSMTP, customCheckIMAP, customCheckPOP3:
+		if s.Port == 0 {
+			return fmt.Errorf("%w: the port is required", errInvalidManualService)
+		}
+	case customCheckNagios:
+		return fmt.Errorf("%w: nagios checks can only be defined in the configuration", errInvalidManualService)
+	default:
+		return fmt.Errorf("%w: unknown check type %#v", errInvalidManualService, s.CheckType)
+	}
+
+	for _, name := range []string{"check_type", "check_command"} {
+		if _, ok := s.ExtraAttributes[name]; ok {
+			return fmt.Errorf("%w: %s isn't allowed in extra_attributes", errInvalidManualService, name)
+		}
+	}
+
+	return nil
+}
+
+// override returns the service as a service override, like the ones from the configuration.
+func (s ManualService) override() map[string]string {
+	result := make(map[string]string, len(s.ExtraAttributes)+5)
+
+	for k, v := range s.ExtraAttributes {
+		result[k] = v
+	}
+
+	if s.Address != "" {
+		result["address"] = s.Address
+	}
+
+	if s.Port != 0 {
+		result["port"] = strconv.FormatInt(int64(s.Port), 10)
+	}
+
+	if s.CheckType != "" {
+		result["check_type"] = s.CheckType
+	}
+
+	if len(s.DependsOn) > 0 {
+		result[dependsOn] = strings.Join(s.DependsOn, ",")
+	}
+
+	return result
+}
+
+func manualServicesFromState(state State) map[string]ManualService {
+	var services []ManualService
+
+	result := make(map[string]ManualService)
+
+	if err := state.Get(manualStateKey, &services); err != nil {
+		return result
+	}
+
+	for _, s := range services {
+		// Services saved by older versions could use a check no longer allowed from the API.
+		if err := s.Validate(); err != nil {
+			logger.Printf("Ignoring the manual service %s: %v", s.Name, err)
+
+			continue
+		}
+
+		result[s.Name] = s
+	}
+
+	return result
+}
+
+// ManualServices returns the services created with SetManualService.
+func (d *Discovery) ManualServices() []ManualService {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	return d.sortedManualServices()
+}
+
+// SetManualService creates a service or replaces the manual service with the same name.
+// The service is persisted and its check is started at once.
+func (d *Discovery) SetManualService(service ManualService) error {
+	if err := service.Validate(); err != nil {
+		return err
+	}
+
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	if _, ok := d.servicesOverride[NameContainer{Name: service.Name}]; ok {
+		return fmt.Errorf("%w: %s", errServiceFromConfig, service.Name)
+	}
+
+	d.manualServices[service.Name] = service
+
+	logger.V(2).Printf("Manual service %s updated", service.Name)
+
+	d.manualServicesChanged()
+
+	return nil
+}
+
+// RemoveManualService removes a service created with SetManualService.
+// It returns false if the service doesn't exist.
+func (d *Discovery) RemoveManualService(name string) bool {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	if _, ok := d.manualServices[name]; !ok {
+		return false
+	}
+
+	delete(d.manualServices, name)
+
+	logger.V(2).Printf("Manual service %s removed", name)
+
+	d.manualServicesChanged()
+
+	return true
+}
+
+func (d *Discovery) sortedManualServices() []ManualService {
+	result := make([]ManualService, 0, len(d.manualServices))
+
+	for _, s := range d.manualServices {
+		result = append(result, s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// manualServicesChanged persists the manual services and updates the checks.
+// The discovery lock must be held.
+func (d *Discovery) manualServicesChanged() {
+	if err := d.state.Set(manualStateKey, d.sortedManualServices()); err != nil {
+		logger.V(1).Printf("Unable to persist manual services: %v", err)
+	}
+
+	if d.servicesMap == nil {
+		// The first discovery will apply the manual services.
+		return
+	}
+
+	d.servicesMap = d.applyOverrides(d.discoveredServicesMap)
+	d.ignoreServicesAndPorts()
+	d.reconfigure()
+}
+
+// applyOverrides returns the discovered services with the overrides from the configuration
+// and the manual services applied. The discovery lock must be held.
+func (d *Discovery) applyOverrides(discoveredServicesMap map[NameContainer]Service) map[NameContainer]Service {
+	if len(d.manualServices) == 0 {
+		return applyOveride(discoveredServicesMap, d.servicesOverride)
+	}
+
+	overrides := make(map[NameContainer]map[string]string, len(d.servicesOverride)+len(d.manualServices))
+
+	for k, v := range d.servicesOverride {
+		overrides[k] = v
+	}
+
+	for name, s := range d.manualServices {
+		key := NameContainer{Name: name}
+
+		// The configuration wins over manual services created before it defined the same service.
+		if _, ok := overrides[key]; ok {
+			continue
+		}
+
+		overrides[key] = s.override()
+	}
+
+	servicesMap := applyOveride(discoveredServicesMap, overrides)
+
+	for name := range d.manualServices {
+		key := NameContainer{Name: name}
+
+		if _, ok := d.servicesOverride[key]; ok {
+			continue
+		}
+
+		if service, ok := servicesMap[key]; ok {
+			service.Manual = true
+			servicesMap[key] = service
+		}
+	}
+
+	return servicesMap
+}
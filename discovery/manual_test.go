@@ -0,0 +1,128 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"glouton/types"
+	"testing"
+)
+
+type memoryState map[string][]ManualService
+
+func (s memoryState) Get(key string, result interface{}) error {
+	services, ok := result.(*[]ManualService)
+	if !ok {
+		return errors.New("not implemented")
+	}
+
+	*services = s[key]
+
+	return nil
+}
+
+func (s memoryState) Set(key string, object interface{}) error {
+	services, ok := object.([]ManualService)
+	if !ok {
+		return errors.New("not implemented")
+	}
+
+	s[key] = services
+
+	return nil
+}
+
+func TestManualServiceValidate(t *testing.T) {
+	cases := []struct {
+		service ManualService
+		wantErr bool
+	}{
+		{service: ManualService{Name: "app", Port: 8080}},
+		{service: ManualService{Name: "app", Port: 8080, CheckType: "http"}},
+		{service: ManualService{Port: 8080}, wantErr: true},
+		{service: ManualService{Name: "app"}, wantErr: true},
+		{service: ManualService{Name: "app", Port: 70000}, wantErr: true},
+		{service: ManualService{Name: "app", CheckType: "nagios"}, wantErr: true},
+		{service: ManualService{Name: "app", Port: 8080, ExtraAttributes: map[string]string{"check_type": "nagios"}}, wantErr: true},
+		{service: ManualService{Name: "app", Port: 8080, ExtraAttributes: map[string]string{"check_command": "/bin/true"}}, wantErr: true},
+		{service: ManualService{Name: "app", Port: 8080, CheckType: "ping"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		if err := c.service.Validate(); (err != nil) != c.wantErr {
+			t.Errorf("%+v.Validate() = %v, want error %v", c.service, err, c.wantErr)
+		}
+	}
+}
+
+func TestManualServices(t *testing.T) {
+	state := memoryState{}
+	overrides := []map[string]string{{"id": "from-config", "port": "9000"}}
+
+	disc := New(NewMockDiscoverer(), nil, nil, nil, state, nil, nil, overrides, nil, nil, types.MetricFormatBleemeo, nil)
+
+	if err := disc.SetManualService(ManualService{Name: "app", Port: 8080, CheckType: "http"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := disc.SetManualService(ManualService{Name: "from-config", Port: 8080}); !errors.Is(err, errServiceFromConfig) {
+		t.Errorf("SetManualService(from-config) = %v, want %v", err, errServiceFromConfig)
+	}
+
+	if len(state[manualStateKey]) != 1 {
+		t.Errorf("persisted services = %v, want 1 service", state[manualStateKey])
+	}
+
+	// A new discovery restores the manual services from the state.
+	disc = New(NewMockDiscoverer(), nil, nil, nil, state, nil, nil, overrides, nil, nil, types.MetricFormatBleemeo, nil)
+
+	services, err := disc.Discovery(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+
+	for _, s := range services {
+		if s.Name != "app" {
+			continue
+		}
+
+		found = true
+
+		if !s.Manual || s.ServiceType != CustomService || s.ExtraAttributes["check_type"] != "http" {
+			t.Errorf("service = %+v, want a manual custom service with an http check", s)
+		}
+	}
+
+	if !found {
+		t.Errorf("services = %v, want the manual service app", services)
+	}
+
+	if !disc.RemoveManualService("app") {
+		t.Errorf("RemoveManualService(app) = false, want true")
+	}
+
+	if disc.RemoveManualService("app") {
+		t.Errorf("RemoveManualService(app) = true, want false")
+	}
+
+	if len(disc.ManualServices()) != 0 {
+		t.Errorf("ManualServices() = %v, want none", disc.ManualServices())
+	}
+}
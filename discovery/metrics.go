@@ -19,6 +19,7 @@ package discovery
 import (
 	"errors"
 	"fmt"
+	"glouton/audit"
 	"glouton/collector"
 	"glouton/inputs"
 	"glouton/inputs/apache"
@@ -84,7 +85,14 @@ func AddDefaultInputs(coll *collector.Collector, inputsConfig inputs.CollectorCo
 	}
 
 	if inputsConfig.DFRootPath != "" {
-		input, err = disk.New(inputsConfig.DFRootPath, inputsConfig.DFPathBlacklist)
+		input, err = disk.New(
+			inputsConfig.DFRootPath,
+			inputsConfig.DFPathBlacklist,
+			inputsConfig.DFFSTypeBlacklist,
+			inputsConfig.DFDeviceBlacklist,
+			inputsConfig.DFTimeout,
+			inputsConfig.DFCooldown,
+		)
 		if err != nil {
 			return err
 		}
@@ -142,6 +150,7 @@ func (d *Discovery) configureMetricInputs(oldServices, services map[NameContaine
 	for key := range oldServices {
 		if _, ok := services[key]; !ok {
 			d.removeInput(key)
+			d.removeScrapper(key)
 		}
 	}
 
@@ -149,11 +158,17 @@ func (d *Discovery) configureMetricInputs(oldServices, services map[NameContaine
 		oldService, ok := oldServices[key]
 		if !ok || serviceNeedUpdate(oldService, service) {
 			d.removeInput(key)
+			d.removeScrapper(key)
 
 			err = d.createInput(service)
 			if err != nil {
 				return
 			}
+
+			err = d.createScrapper(service)
+			if err != nil {
+				return
+			}
 		}
 	}
 
@@ -204,6 +219,7 @@ func (d *Discovery) removeInput(key NameContainer) {
 
 	if collector, ok := d.activeCollector[key]; ok {
 		logger.V(2).Printf("Remove input for service %v on container %s", key.Name, key.ContainerName)
+		d.auditLog.Record(audit.KindInput, "Input of service %s removed", serviceName(key))
 		delete(d.activeCollector, key)
 
 		if collector.gathererID == 0 {
@@ -339,7 +355,8 @@ func (d *Discovery) createInput(service Service) error {
 		if ip, port := service.AddressPort(); ip != "" {
 			input, err = zookeeper.New(fmt.Sprintf("%s:%d", ip, port))
 		}
-	case CustomService:
+	case CustomService, EtcdService, MinIOService, TraefikService:
+		// Metrics of these services are only available from their exporter, see createScrapper.
 		return nil
 	default:
 		logger.V(1).Printf("service type %s don't support metrics", service.ServiceType)
@@ -367,6 +384,14 @@ func (d *Discovery) createInput(service Service) error {
 				}
 			}
 
+			if service.ComposeProject != "" {
+				labels[types.LabelComposeProject] = service.ComposeProject
+			}
+
+			if service.Stack != "" {
+				labels[types.LabelStack] = service.Stack
+			}
+
 			if service.ContainerName != "" {
 				if annotations.BleemeoItem != "" {
 					annotations.BleemeoItem = service.ContainerName + "_" + annotations.BleemeoItem
@@ -389,7 +414,7 @@ func (d *Discovery) addInput(input telegraf.Input, service Service) error {
 		return nil
 	}
 
-	inputID, err := d.coll.AddInput(input, service.Name)
+	inputID, err := d.coll.AddInputWithItem(input, service.Name, service.ContainerName)
 	if err != nil {
 		return err
 	}
@@ -402,6 +427,8 @@ func (d *Discovery) addInput(input telegraf.Input, service Service) error {
 		inputID: inputID,
 	}
 
+	d.auditLog.Record(audit.KindInput, "Input of service %s registered", service)
+
 	return nil
 }
 
@@ -38,6 +38,17 @@ func (md MockDiscoverer) Discovery(ctx context.Context, maxAge time.Duration) (s
 	return md.result, nil
 }
 
+// ContainerDiscovery implements containerDiscoverer.
+func (md MockDiscoverer) ContainerDiscovery(ctx context.Context, containerID string) (services []Service, err error) {
+	for _, s := range md.result {
+		if s.ContainerID == containerID {
+			services = append(services, s)
+		}
+	}
+
+	return services, nil
+}
+
 // LastUpdate implements Discoverer.
 func (md MockDiscoverer) LastUpdate() time.Time {
 	return time.Now()
@@ -2,6 +2,7 @@ package discovery
 
 import (
 	"fmt"
+	"glouton/audit"
 	"glouton/prometheus/exporter/memcached"
 	"glouton/types"
 	"runtime"
@@ -58,5 +59,7 @@ func (d *Discovery) createPrometheusMemcached(service Service) error {
 		gathererID: id,
 	}
 
+	d.auditLog.Record(audit.KindInput, "Input of service %s registered", service)
+
 	return nil
 }
@@ -0,0 +1,136 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"fmt"
+	"glouton/audit"
+	"glouton/logger"
+	"glouton/prometheus/scrapper"
+	"glouton/types"
+	"net/url"
+	"strconv"
+)
+
+const defaultMetricsPath = "/metrics"
+
+// metricsURL returns the URL of the Prometheus exporter shipped with the service. It returns nil
+// unless the scrape_metrics option is enabled on the service.
+func metricsURL(service Service) *url.URL {
+	if enabled, _ := strconv.ParseBool(service.ExtraAttributes[scrapeMetrics]); !enabled {
+		return nil
+	}
+
+	di := servicesDiscoveryInfo[service.ServiceType]
+	port := di.MetricsPort
+	path := di.MetricsPath
+
+	if service.ExtraAttributes[metricsPort] != "" {
+		tmp, err := strconv.ParseInt(service.ExtraAttributes[metricsPort], 10, 0)
+		if err != nil {
+			logger.V(1).Printf("Invalid %s %#v on service %s: %v", metricsPort, service.ExtraAttributes[metricsPort], service, err)
+
+			return nil
+		}
+
+		port = int(tmp)
+	}
+
+	if service.ExtraAttributes[metricsPath] != "" {
+		path = service.ExtraAttributes[metricsPath]
+	}
+
+	if path == "" {
+		path = defaultMetricsPath
+	}
+
+	if port == 0 {
+		logger.V(1).Printf("The exporter port of service %s is unknown, set %s to scrape its metrics", service, metricsPort)
+
+		return nil
+	}
+
+	ip := service.AddressForPort(port, "tcp", true)
+	if ip == "" {
+		return nil
+	}
+
+	return &url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", ip, port),
+		Path:   path,
+	}
+}
+
+// createScrapper registers a scrapper for the exporter of the service when scrape_metrics is enabled.
+func (d *Discovery) createScrapper(service Service) error {
+	if d.metricRegistry == nil || !service.Active || service.MetricsIgnored {
+		return nil
+	}
+
+	u := metricsURL(service)
+	if u == nil {
+		return nil
+	}
+
+	target := (*scrapper.Target)(u)
+	labels := map[string]string{
+		types.LabelMetaScrapeJob:      service.Name,
+		types.LabelMetaScrapeInstance: target.HostPort(),
+		types.LabelService:            service.Name,
+	}
+
+	if service.ContainerName != "" {
+		labels[types.LabelContainerName] = service.ContainerName
+	}
+
+	if service.Stack != "" {
+		labels[types.LabelStack] = service.Stack
+	}
+
+	id, err := d.metricRegistry.RegisterGatherer(target, nil, labels)
+	if err != nil {
+		return err
+	}
+
+	logger.V(2).Printf("Add scrapper %s for service %s", u.String(), service)
+
+	key := NameContainer{
+		Name:          service.Name,
+		ContainerName: service.ContainerName,
+	}
+	d.activeScrapper[key] = id
+
+	d.auditLog.Record(audit.KindInput, "Scrapper %s of service %s registered", u.String(), service)
+
+	return nil
+}
+
+func (d *Discovery) removeScrapper(key NameContainer) {
+	id, ok := d.activeScrapper[key]
+	if !ok {
+		return
+	}
+
+	logger.V(2).Printf("Remove scrapper for service %v on container %s", key.Name, key.ContainerName)
+	delete(d.activeScrapper, key)
+	d.auditLog.Record(audit.KindInput, "Scrapper of service %s removed", serviceName(key))
+
+	if !d.metricRegistry.UnregisterGatherer(id) {
+		logger.V(2).Printf("The gatherer wasn't present")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"glouton/facts"
+	"glouton/types"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type mockGathererRegistry struct {
+	labels map[int]map[string]string
+	nextID int
+}
+
+func (r *mockGathererRegistry) RegisterGatherer(_ prometheus.Gatherer, _ func(), extraLabels map[string]string) (int, error) {
+	r.nextID++
+	r.labels[r.nextID] = extraLabels
+
+	return r.nextID, nil
+}
+
+func (r *mockGathererRegistry) UnregisterGatherer(id int) bool {
+	_, ok := r.labels[id]
+	delete(r.labels, id)
+
+	return ok
+}
+
+func TestMetricsURL(t *testing.T) {
+	cases := []struct {
+		name    string
+		service Service
+		want    string
+	}{
+		{
+			name: "disabled",
+			service: Service{
+				ServiceType: TraefikService,
+				IPAddress:   "127.0.0.1",
+			},
+			want: "",
+		},
+		{
+			name: "traefik",
+			service: Service{
+				ServiceType:     TraefikService,
+				IPAddress:       "172.16.0.2",
+				ListenAddresses: []facts.ListenAddress{{NetworkFamily: "tcp", Address: "0.0.0.0", Port: 8080}},
+				ExtraAttributes: map[string]string{scrapeMetrics: "true"},
+			},
+			want: "http://172.16.0.2:8080/metrics",
+		},
+		{
+			name: "minio",
+			service: Service{
+				ServiceType:     MinIOService,
+				IPAddress:       "127.0.0.1",
+				ExtraAttributes: map[string]string{scrapeMetrics: "true"},
+			},
+			want: "http://127.0.0.1:9000/minio/v2/metrics/cluster",
+		},
+		{
+			name: "override",
+			service: Service{
+				ServiceType:     HAProxyService,
+				IPAddress:       "127.0.0.1",
+				ExtraAttributes: map[string]string{scrapeMetrics: "true", metricsPort: "9101", metricsPath: "/stats"},
+			},
+			want: "http://127.0.0.1:9101/stats",
+		},
+		{
+			name: "no-exporter",
+			service: Service{
+				ServiceType:     NginxService,
+				IPAddress:       "127.0.0.1",
+				ExtraAttributes: map[string]string{scrapeMetrics: "true"},
+			},
+			want: "",
+		},
+	}
+
+	for _, c := range cases {
+		got := ""
+		if u := metricsURL(c.service); u != nil {
+			got = u.String()
+		}
+
+		if got != c.want {
+			t.Errorf("%s: metricsURL() = %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCreateScrapper(t *testing.T) {
+	registry := &mockGathererRegistry{labels: make(map[int]map[string]string)}
+	disc := New(NewMockDiscoverer(), nil, registry, nil, mockState{}, nil, nil, nil, nil, nil, types.MetricFormatBleemeo, nil)
+
+	service := Service{
+		Name:            "etcd",
+		ServiceType:     EtcdService,
+		Active:          true,
+		ContainerName:   "etcd1",
+		IPAddress:       "172.16.0.3",
+		ExtraAttributes: map[string]string{scrapeMetrics: "true"},
+	}
+
+	if err := disc.createScrapper(service); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := registry.labels[1]
+	if labels[types.LabelService] != "etcd" || labels[types.LabelContainerName] != "etcd1" || labels[types.LabelMetaScrapeInstance] != "172.16.0.3:2379" {
+		t.Errorf("scrapper labels = %v, want service etcd on container etcd1", labels)
+	}
+
+	disc.removeScrapper(NameContainer{Name: "etcd", ContainerName: "etcd1"})
+
+	if len(registry.labels) != 0 {
+		t.Errorf("scrapper still registered after removeScrapper")
+	}
+}
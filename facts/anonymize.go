@@ -0,0 +1,148 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+//nolint:gochecknoglobals
+var anonymizedFacts = []string{"fqdn", "hostname", "domain"}
+
+// Anonymizer replace hostnames, usernames and command lines by a salted hash.
+//
+// The same value always give the same hash for a given salt, so anonymized values
+// could still be compared.
+type Anonymizer struct {
+	salt []byte
+}
+
+// NewAnonymizer return an Anonymizer using given salt.
+func NewAnonymizer(salt string) Anonymizer {
+	return Anonymizer{salt: []byte(salt)}
+}
+
+// Hash return the anonymized value. Empty value are kept empty.
+func (a Anonymizer) Hash(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, a.salt)
+	_, _ = mac.Write([]byte(value))
+
+	return "anon-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Facts return a copy of facts with hostname related facts anonymized.
+func (a Anonymizer) Facts(facts map[string]string) map[string]string {
+	result := make(map[string]string, len(facts))
+
+	for k, v := range facts {
+		result[k] = v
+	}
+
+	for _, k := range anonymizedFacts {
+		if v, ok := result[k]; ok {
+			result[k] = a.Hash(v)
+		}
+	}
+
+	return result
+}
+
+// Process return the process with its username and command line anonymized.
+func (a Anonymizer) Process(process Process) Process {
+	process.Username = a.Hash(process.Username)
+	process.CmdLine = a.Hash(process.CmdLine)
+
+	if process.CmdLineList != nil {
+		process.CmdLineList = []string{process.CmdLine}
+	}
+
+	return process
+}
+
+// TopInfo return a copy of topinfo with processes anonymized.
+func (a Anonymizer) TopInfo(topinfo TopInfo) TopInfo {
+	processes := make([]Process, len(topinfo.Processes))
+
+	for i, p := range topinfo.Processes {
+		processes[i] = a.Process(p)
+	}
+
+	topinfo.Processes = processes
+
+	return topinfo
+}
+
+// AnonymizedFactProvider wrap a FactProvider and anonymize its facts.
+type AnonymizedFactProvider struct {
+	Provider interface {
+		Facts(ctx context.Context, maxAge time.Duration) (facts map[string]string, err error)
+	}
+	Anonymizer Anonymizer
+}
+
+// Facts returns the anonymized facts.
+func (p AnonymizedFactProvider) Facts(ctx context.Context, maxAge time.Duration) (facts map[string]string, err error) {
+	facts, err = p.Provider.Facts(ctx, maxAge)
+	if err != nil {
+		return facts, err
+	}
+
+	return p.Anonymizer.Facts(facts), nil
+}
+
+// AnonymizedProcessProvider wrap a ProcessProvider and anonymize its processes.
+type AnonymizedProcessProvider struct {
+	Provider interface {
+		Processes(ctx context.Context, maxAge time.Duration) (processes map[int]Process, err error)
+		TopInfo(ctx context.Context, maxAge time.Duration) (topinfo TopInfo, err error)
+	}
+	Anonymizer Anonymizer
+}
+
+// Processes returns the anonymized processes.
+func (p AnonymizedProcessProvider) Processes(ctx context.Context, maxAge time.Duration) (processes map[int]Process, err error) {
+	processes, err = p.Provider.Processes(ctx, maxAge)
+	if err != nil {
+		return processes, err
+	}
+
+	result := make(map[int]Process, len(processes))
+
+	for pid, process := range processes {
+		result[pid] = p.Anonymizer.Process(process)
+	}
+
+	return result, nil
+}
+
+// TopInfo returns the anonymized topinfo.
+func (p AnonymizedProcessProvider) TopInfo(ctx context.Context, maxAge time.Duration) (topinfo TopInfo, err error) {
+	topinfo, err = p.Provider.TopInfo(ctx, maxAge)
+	if err != nil {
+		return topinfo, err
+	}
+
+	return p.Anonymizer.TopInfo(topinfo), nil
+}
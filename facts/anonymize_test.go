@@ -0,0 +1,69 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnonymizer(t *testing.T) {
+	anonymizer := NewAnonymizer("salt")
+
+	if anonymizer.Hash("server01") != anonymizer.Hash("server01") {
+		t.Error("Hash() isn't stable")
+	}
+
+	if anonymizer.Hash("server01") == NewAnonymizer("other").Hash("server01") {
+		t.Error("Hash() doesn't depend on the salt")
+	}
+
+	facts := map[string]string{
+		"fqdn":       "server01.example.com",
+		"hostname":   "server01",
+		"os_name":    "Ubuntu",
+		"public_ip":  "203.0.113.1",
+		"kernel_arc": "x86_64",
+	}
+
+	got := anonymizer.Facts(facts)
+
+	for _, k := range []string{"fqdn", "hostname"} {
+		if !strings.HasPrefix(got[k], "anon-") {
+			t.Errorf("fact %s = %#v, want an anonymized value", k, got[k])
+		}
+	}
+
+	if got["os_name"] != "Ubuntu" {
+		t.Errorf("os_name = %#v, want Ubuntu", got["os_name"])
+	}
+
+	if facts["hostname"] != "server01" {
+		t.Error("Facts() modified its input")
+	}
+
+	topinfo := anonymizer.TopInfo(TopInfo{
+		Processes: []Process{
+			{PID: 1, Name: "backup", Username: "alice", CmdLine: "backup --password secret"},
+		},
+	})
+
+	p := topinfo.Processes[0]
+	if p.Name != "backup" || strings.Contains(p.CmdLine, "secret") || p.Username == "alice" {
+		t.Errorf("process = %+v, want username and command line anonymized", p)
+	}
+}
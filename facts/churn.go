@@ -0,0 +1,89 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"time"
+)
+
+const churnWindow = time.Hour
+
+// churnDetector tracks container creations to detect hosts which create and destroy
+// containers at a high rate (e.g. CI runners).
+//
+// While the creation rate is above the threshold, recently created containers are
+// considered ephemeral: they are not discovered nor synchronized individually and only
+// accounted in aggregate.
+type churnDetector struct {
+	// threshold is the number of containers created per hour above which the host is
+	// churning. Zero disables the detection.
+	threshold int
+	// maxAge is the age after which a container is no longer ephemeral.
+	maxAge   time.Duration
+	creation map[string]time.Time
+}
+
+// observe records the creation of a container. Containers already known are ignored.
+func (c *churnDetector) observe(containerID string, createdAt time.Time, now time.Time) {
+	if c.threshold <= 0 {
+		return
+	}
+
+	if c.creation == nil {
+		c.creation = make(map[string]time.Time)
+	}
+
+	if _, ok := c.creation[containerID]; ok || now.Sub(createdAt) > churnWindow {
+		return
+	}
+
+	c.creation[containerID] = createdAt
+}
+
+// rate returns the number of containers created during the last hour.
+func (c *churnDetector) rate(now time.Time) int {
+	for id, createdAt := range c.creation {
+		if now.Sub(createdAt) > churnWindow {
+			delete(c.creation, id)
+		}
+	}
+
+	return len(c.creation)
+}
+
+// churning returns whether the creation rate exceeds the threshold.
+func (c *churnDetector) churning(now time.Time) bool {
+	return c.threshold > 0 && c.rate(now) > c.threshold
+}
+
+// isEphemeral returns whether the container must only be accounted in aggregate.
+// Containers explicitly enabled with a glouton.enable label are never ephemeral.
+func (c *churnDetector) isEphemeral(container Container, now time.Time) bool {
+	if !c.churning(now) {
+		return false
+	}
+
+	if _, ok := container.Labels()[EnableLabel]; ok {
+		return false
+	}
+
+	if _, ok := container.Labels()[EnableLegacyLabel]; ok {
+		return false
+	}
+
+	return now.Sub(container.CreatedAt()) < c.maxAge
+}
@@ -0,0 +1,105 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	containerTypes "github.com/docker/docker/api/types/container"
+)
+
+func newTestContainer(id string, createdAt time.Time, labels map[string]string) Container {
+	return Container{
+		inspect: types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:      id,
+				Created: createdAt.Format(time.RFC3339Nano),
+			},
+			Config: &containerTypes.Config{Labels: labels},
+		},
+	}
+}
+
+func TestChurnDetector(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := churnDetector{threshold: 10, maxAge: 30 * time.Minute}
+
+	old := newTestContainer("old", now.Add(-48*time.Hour), nil)
+	c.observe(old.ID(), old.CreatedAt(), now)
+
+	for i := 0; i < 10; i++ {
+		c.observe(fmt.Sprintf("ci-%d", i), now.Add(-time.Duration(i)*time.Minute), now)
+	}
+
+	// observing twice the same container must not count it twice
+	c.observe("ci-0", now, now)
+
+	if got := c.rate(now); got != 10 {
+		t.Errorf("rate() = %d, want 10", got)
+	}
+
+	young := newTestContainer("young", now.Add(-time.Minute), nil)
+
+	if c.isEphemeral(young, now) {
+		t.Error("container is ephemeral while the threshold isn't exceeded")
+	}
+
+	c.observe(young.ID(), young.CreatedAt(), now)
+
+	if !c.churning(now) {
+		t.Fatal("churning() = false, want true")
+	}
+
+	if !c.isEphemeral(young, now) {
+		t.Error("young container isn't ephemeral")
+	}
+
+	if c.isEphemeral(old, now) {
+		t.Error("old container is ephemeral")
+	}
+
+	enabled := newTestContainer("enabled", now, map[string]string{EnableLabel: "true"})
+	if c.isEphemeral(enabled, now) {
+		t.Error("explicitly enabled container is ephemeral")
+	}
+
+	later := now.Add(2 * time.Hour)
+
+	if c.churning(later) {
+		t.Error("churning() = true after the creations left the window")
+	}
+
+	if got := c.rate(later); got != 0 {
+		t.Errorf("rate() = %d, want 0", got)
+	}
+}
+
+func TestChurnDetectorDisabled(t *testing.T) {
+	now := time.Now()
+	c := churnDetector{}
+
+	for i := 0; i < 1000; i++ {
+		c.observe(fmt.Sprintf("ci-%d", i), now, now)
+	}
+
+	if c.churning(now) {
+		t.Error("churning() = true with churn protection disabled")
+	}
+}
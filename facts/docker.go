@@ -23,6 +23,7 @@ import (
 	"errors"
 	"fmt"
 	"glouton/logger"
+	"io"
 	"math"
 	"sort"
 	"strconv"
@@ -51,6 +52,7 @@ type dockerClient interface {
 	ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error)
 	ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error)
 	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ContainerStats(ctx context.Context, container string, stream bool) (types.ContainerStats, error)
 	ContainerTop(ctx context.Context, container string, arguments []string) (container.ContainerTopOKBody, error)
 	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
 	NetworkInspect(ctx context.Context, network string, options types.NetworkInspectOptions) (types.NetworkResource, error)
@@ -86,6 +88,13 @@ type DockerProvider struct {
 	kubernetesUpdated              bool
 	bridgeNetworks                 map[string]interface{}
 	containerAddressOnDockerBridge map[string]string
+	churn                          churnDetector
+	restarts                       restartDetector
+
+	topL         sync.Mutex
+	topCache     map[string]topResult
+	topSemaphore chan struct{}
+	topBreaker   circuitBreaker
 }
 
 // DockerEvent is a simplified version of Docker Event.Message
@@ -101,6 +110,7 @@ type Container struct {
 	primaryAddress string
 	inspect        types.ContainerJSON
 	pod            corev1.Pod
+	ephemeral      bool
 }
 
 // NewDocker creates a new Docker provider which must be started with Run() method.
@@ -125,12 +135,61 @@ func NewDocker(deletedContainersCallback func(containerIDs []string), kubeImpl *
 	}
 }
 
+// SetChurnProtection enables the container churn protection. When more than threshold containers
+// are created per hour, containers younger than maxAge are ephemeral: they are ignored like
+// containers with glouton.enable=false and only accounted in aggregate. A zero threshold disables it.
+func (d *DockerProvider) SetChurnProtection(threshold int, maxAge time.Duration) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	d.churn.threshold = threshold
+	d.churn.maxAge = maxAge
+}
+
+// ContainerChurn returns the number of containers created during the last hour and
+// whether this exceeds the churn protection threshold.
+func (d *DockerProvider) ContainerChurn() (createdLastHour int, churning bool) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	now := time.Now()
+
+	return d.churn.rate(now), d.churn.churning(now)
+}
+
+// SetRestartLoopDetection enables the detection of containers restarting in loop: a container
+// started count times during period is looping. A zero count disables it.
+func (d *DockerProvider) SetRestartLoopDetection(count int, period time.Duration) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	d.restarts.count = count
+	d.restarts.period = period
+}
+
+// ContainerRestarts returns the containers started during the restart loop period and whether
+// they are looping.
+func (d *DockerProvider) ContainerRestarts() []ContainerRestarts {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	return d.restarts.restarts(time.Now())
+}
+
+// ContainerEphemeral returns whether the container is ephemeral, using only the cache.
+func (d *DockerProvider) ContainerEphemeral(containerID string) bool {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	return d.containers[containerID].ephemeral
+}
+
 // Containers returns the list of container present on this system.
 //
 // It may use a cached value as old as maxAge
 //
 // If includeIgnored is false, Containers that has glouton.enable=false (or bleemeo.enable=false) labels
-// are not listed.
+// and ephemeral containers are not listed.
 func (d *DockerProvider) Containers(ctx context.Context, maxAge time.Duration, includeIgnored bool) (containers []Container, err error) {
 	d.l.Lock()
 	defer d.l.Unlock()
@@ -247,6 +306,25 @@ func (d *DockerProvider) Exec(ctx context.Context, containerID string, cmd []str
 	return output.Bytes(), nil
 }
 
+// ContainerStats returns a stream of the resource usage statistics of a container. A new
+// statistics JSON object is written about every second until ctx is cancelled or the container stops.
+func (d *DockerProvider) ContainerStats(ctx context.Context, containerID string) (io.ReadCloser, error) {
+	d.l.Lock()
+	cl, err := d.getClient(ctx)
+	d.l.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := cl.ContainerStats(ctx, containerID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats.Body, nil
+}
+
 // HasConnection returns whether or not a connection is currently established with Docker.
 //
 // It use the cached connection, no new connection are established. Use Containers() to establish new connection if needed.
@@ -351,9 +429,14 @@ func (c Container) ID() string {
 	return c.inspect.ID
 }
 
+// Ephemeral returns true if this container was created while the host was churning containers.
+func (c Container) Ephemeral() bool {
+	return c.ephemeral
+}
+
 // Ignored returns true if this container should be ignored by Glouton.
 func (c Container) Ignored() bool {
-	ignore := ignoreContainer(c.inspect)
+	ignore := c.ephemeral || ignoreContainer(c.inspect)
 
 	if !ignore {
 		ignore = !string2Boolean(c.pod.Annotations[EnableLabel], true)
@@ -771,25 +854,6 @@ func (d *DockerProvider) updatePods(ctx context.Context) {
 	}
 }
 
-func (d *DockerProvider) top(ctx context.Context, containerID string) (top container.ContainerTopOKBody, topWaux container.ContainerTopOKBody, err error) {
-	d.l.Lock()
-	defer d.l.Unlock()
-
-	cl, err := d.getClient(ctx)
-	if err != nil {
-		return
-	}
-
-	top, err = cl.ContainerTop(ctx, containerID, nil)
-	if err != nil {
-		return
-	}
-
-	topWaux, err = cl.ContainerTop(ctx, containerID, []string{"waux"})
-
-	return
-}
-
 func (d *DockerProvider) updateContainer(ctx context.Context, cl dockerClient, containerID string) (Container, error) {
 	var result Container
 
@@ -822,6 +886,10 @@ func (d *DockerProvider) updateContainer(ctx context.Context, cl dockerClient, c
 		container.pod, _ = d.getPod(ctx, containerID, container.Labels())
 	}
 
+	now := time.Now()
+	d.churn.observe(containerID, container.CreatedAt(), now)
+	container.ephemeral = d.churn.isEphemeral(container, now)
+
 	d.containers[containerID] = container
 
 	if container.Ignored() {
@@ -893,9 +961,20 @@ func (d *DockerProvider) updateContainers(ctx context.Context) error {
 		}
 
 		containers[c.ID] = container
+	}
+
+	now := time.Now()
+
+	for id, container := range containers {
+		d.churn.observe(id, container.CreatedAt(), now)
+	}
+
+	for id, container := range containers {
+		container.ephemeral = d.churn.isEphemeral(container, now)
+		containers[id] = container
 
 		if container.Ignored() {
-			ignoredID[c.ID] = nil
+			ignoredID[id] = nil
 		}
 	}
 
@@ -974,13 +1053,38 @@ func (d *DockerProvider) run(ctx context.Context) (err error) {
 					continue
 				}
 
-				if se.Action == "kill" {
+				if se.Action == "create" && d.churnProtectionEnabled() {
+					// The container must be inspected to know whether it's ephemeral, in
+					// which case its events are ignored.
+					container, err := d.updateContainer(ctx, cl, se.ActorID)
+					if err != nil {
+						logger.V(1).Printf("Update of container %v failed (will assume container is removed): %v", se.ActorID, err)
+						continue
+					}
+
+					if container.Ignored() {
+						continue
+					}
+
+					se.Container = &container
+				}
+
+				switch se.Action {
+				case "kill":
 					d.l.Lock()
 					d.lastKill[se.ActorID] = time.Now()
 					d.l.Unlock()
+				case "start":
+					d.l.Lock()
+					d.restarts.observe(se.ActorID, time.Now())
+					d.l.Unlock()
+				case "destroy":
+					d.l.Lock()
+					d.restarts.forget(se.ActorID)
+					d.l.Unlock()
 				}
 
-				if strings.HasPrefix(se.Action, "health_status:") {
+				if strings.HasPrefix(se.Action, "health_status:") || se.Action == "pause" || se.Action == "unpause" {
 					container, err := d.updateContainer(ctx, cl, se.ActorID)
 					if err != nil {
 						logger.V(1).Printf("Update of container %v failed (will assume container is removed): %v", se.ActorID, err)
@@ -1003,6 +1107,13 @@ func (d *DockerProvider) run(ctx context.Context) (err error) {
 	}
 }
 
+func (d *DockerProvider) churnProtectionEnabled() bool {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	return d.churn.threshold > 0
+}
+
 func (d *DockerProvider) isIgnored(containerID string) bool {
 	d.l.Lock()
 	defer d.l.Unlock()
@@ -25,6 +25,7 @@ import (
 	"io/ioutil"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -98,6 +99,9 @@ func (cl mockDockerClient) ContainerList(ctx context.Context, options types.Cont
 
 	return result, nil
 }
+func (cl mockDockerClient) ContainerStats(ctx context.Context, container string, stream bool) (types.ContainerStats, error) {
+	return types.ContainerStats{}, errors.New("ContainerStats not implemented")
+}
 func (cl mockDockerClient) ContainerTop(ctx context.Context, container string, arguments []string) (containerTypes.ContainerTopOKBody, error) {
 	return containerTypes.ContainerTopOKBody{}, errors.New("ContainerTop not implemented")
 }
@@ -667,3 +671,76 @@ func TestContainer_IgnoredPorts(t *testing.T) {
 		})
 	}
 }
+
+type mockTopClient struct {
+	mockDockerClient
+	topCount *int32
+	err      error
+}
+
+func (cl mockTopClient) ContainerTop(ctx context.Context, container string, arguments []string) (containerTypes.ContainerTopOKBody, error) {
+	atomic.AddInt32(cl.topCount, 1)
+
+	return containerTypes.ContainerTopOKBody{}, cl.err
+}
+
+func TestDockerTopCache(t *testing.T) {
+	var count int32
+
+	dockerProvider := DockerProvider{
+		client: mockTopClient{topCount: &count},
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := dockerProvider.top(context.Background(), "1234", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// top and top waux for the first call only
+	if count != 2 {
+		t.Errorf("ContainerTop called %d times, want 2", count)
+	}
+
+	if _, _, err := dockerProvider.top(context.Background(), "1234", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 4 {
+		t.Errorf("ContainerTop called %d times, want 4", count)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var cb circuitBreaker
+
+	now := time.Now()
+
+	for i := 0; i < breakerThreshold; i++ {
+		if !cb.allow(now) {
+			t.Fatalf("call %d refused, want allowed", i)
+		}
+
+		cb.record(false, now)
+	}
+
+	if cb.allow(now.Add(time.Second)) {
+		t.Error("call allowed while circuit is open")
+	}
+
+	trialTime := now.Add(breakerOpenDuration + time.Second)
+
+	if !cb.allow(trialTime) {
+		t.Error("trial call refused after the open duration")
+	}
+
+	if cb.allow(trialTime) {
+		t.Error("second call allowed before the trial call result")
+	}
+
+	cb.record(true, trialTime)
+
+	if !cb.allow(trialTime) {
+		t.Error("call refused after a successful trial")
+	}
+}
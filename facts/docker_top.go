@@ -0,0 +1,166 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"context"
+	"errors"
+	"glouton/logger"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	// maxConcurrentTop is the maximum number of "docker top" running at the same time.
+	maxConcurrentTop = 4
+	// topTimeout is the delay after which a "docker top" is considered as slow.
+	topTimeout = 5 * time.Second
+	// breakerThreshold is the number of successive slow or failed "docker top" which open the circuit.
+	breakerThreshold = 3
+	// breakerOpenDuration is the delay during which "docker top" aren't done once the circuit is open.
+	breakerOpenDuration = time.Minute
+)
+
+var errCircuitOpen = errors.New("docker daemon is slow to respond, container processes are temporary not listed")
+
+type topResult struct {
+	top     container.ContainerTopOKBody
+	topWaux container.ContainerTopOKBody
+	at      time.Time
+}
+
+// circuitBreaker stop calls to a slow dependency. After breakerThreshold successive
+// failures, calls are refused during breakerOpenDuration. Then one call is
+// allowed, its result decide whether the circuit is closed or open again.
+type circuitBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+func (cb *circuitBreaker) allow(now time.Time) bool {
+	if cb.failures < breakerThreshold {
+		return true
+	}
+
+	if now.After(cb.openUntil) {
+		// Allow a single trial call until its result is recorded.
+		cb.openUntil = now.Add(breakerOpenDuration)
+
+		return true
+	}
+
+	return false
+}
+
+func (cb *circuitBreaker) record(success bool, now time.Time) {
+	if success {
+		if cb.failures >= breakerThreshold {
+			logger.V(1).Printf("Docker daemon respond again, container processes are listed")
+		}
+
+		cb.failures = 0
+
+		return
+	}
+
+	cb.failures++
+
+	if cb.failures >= breakerThreshold {
+		if cb.failures == breakerThreshold {
+			logger.V(1).Printf("Docker daemon is slow to respond, stop listing container processes for %v", breakerOpenDuration)
+		}
+
+		cb.openUntil = now.Add(breakerOpenDuration)
+	}
+}
+
+// top returns the processes of a container.
+//
+// Results are cached for maxAge and at most maxConcurrentTop calls are done concurrently.
+// When the Docker daemon is slow, calls are stopped for a while and errCircuitOpen is returned.
+func (d *DockerProvider) top(ctx context.Context, containerID string, maxAge time.Duration) (top container.ContainerTopOKBody, topWaux container.ContainerTopOKBody, err error) {
+	d.topL.Lock()
+
+	if d.topSemaphore == nil {
+		d.topSemaphore = make(chan struct{}, maxConcurrentTop)
+		d.topCache = make(map[string]topResult)
+	}
+
+	if cached, ok := d.topCache[containerID]; ok && time.Since(cached.at) < maxAge {
+		d.topL.Unlock()
+
+		return cached.top, cached.topWaux, nil
+	}
+
+	allowed := d.topBreaker.allow(time.Now())
+
+	d.topL.Unlock()
+
+	if !allowed {
+		return top, topWaux, errCircuitOpen
+	}
+
+	select {
+	case d.topSemaphore <- struct{}{}:
+	case <-ctx.Done():
+		return top, topWaux, ctx.Err()
+	}
+
+	defer func() { <-d.topSemaphore }()
+
+	d.l.Lock()
+	cl, err := d.getClient(ctx)
+	d.l.Unlock()
+
+	if err != nil {
+		return top, topWaux, err
+	}
+
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, 2*topTimeout)
+	defer cancel()
+
+	top, err = cl.ContainerTop(ctx, containerID, nil)
+	if err == nil {
+		topWaux, err = cl.ContainerTop(ctx, containerID, []string{"waux"})
+	}
+
+	duration := time.Since(start)
+	slow := duration > topTimeout || errors.Is(err, context.DeadlineExceeded)
+
+	d.topL.Lock()
+	defer d.topL.Unlock()
+
+	d.topBreaker.record(!slow, time.Now())
+
+	if err != nil {
+		return top, topWaux, err
+	}
+
+	d.topCache[containerID] = topResult{top: top, topWaux: topWaux, at: time.Now()}
+
+	// Purge old entries, they belong to containers no longer running.
+	for id, cached := range d.topCache {
+		if time.Since(cached.at) > 10*time.Minute {
+			delete(d.topCache, id)
+		}
+	}
+
+	return top, topWaux, nil
+}
@@ -19,6 +19,7 @@ package facts
 import (
 	"context"
 	"glouton/logger"
+	"glouton/proxy"
 	"glouton/version"
 	"io"
 	"io/ioutil"
@@ -161,7 +162,7 @@ func (f *FactProvider) updateFacts(ctx context.Context) {
 		subctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 		defer cancel()
 
-		newFacts["public_ip"] = urlContent(subctx, f.ipIndicatorURL)
+		newFacts["public_ip"] = httpQueryWithClient(subctx, internetClient, f.ipIndicatorURL, nil)
 	}
 
 	newFacts["architecture"] = runtime.GOARCH
@@ -337,7 +338,21 @@ func urlContent(ctx context.Context, url string) string {
 	return httpQuery(ctx, url, []string{})
 }
 
+// internetClient is used for requests to Internet services (e.g. the public IP lookup)
+// which go through the configured proxy. Cloud metadata services use the default client.
+var internetClient = &http.Client{ //nolint:gochecknoglobals
+	Transport: &http.Transport{
+		Proxy:               proxy.FromRequest,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
 func httpQuery(ctx context.Context, url string, headers []string) string {
+	return httpQueryWithClient(ctx, http.DefaultClient, url, headers)
+}
+
+func httpQueryWithClient(ctx context.Context, client *http.Client, url string, headers []string) string {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return ""
@@ -352,7 +367,7 @@ func httpQuery(ctx context.Context, url string, headers []string) string {
 		req.Header.Add(splits[0], splits[1])
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return ""
 	}
@@ -0,0 +1,127 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"context"
+	"glouton/logger"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+//nolint:gochecknoglobals
+var kenvFacts = map[string]string{
+	"bios_released_at": "smbios.bios.reldate",
+	"bios_vendor":      "smbios.bios.vendor",
+	"bios_version":     "smbios.bios.version",
+	"product_name":     "smbios.system.product",
+	"system_vendor":    "smbios.system.maker",
+}
+
+func (f *FactProvider) platformFacts() map[string]string {
+	facts := make(map[string]string)
+
+	// os-release exists since FreeBSD 13
+	if f.hostRootPath != "" {
+		osReleasePath := filepath.Join(f.hostRootPath, "etc/os-release")
+		if osReleaseData, err := ioutil.ReadFile(osReleasePath); err != nil {
+			logger.V(1).Printf("unable to read os-release file: %v", err)
+		} else {
+			osRelease, err := decodeOsRelease(string(osReleaseData))
+			if err != nil {
+				logger.V(1).Printf("os-release file is invalid: %v", err)
+			}
+
+			facts["os_name"] = osRelease["NAME"]
+			facts["os_pretty_name"] = osRelease["PRETTY_NAME"]
+			facts["os_version"] = osRelease["VERSION_ID"]
+			facts["os_version_long"] = osRelease["VERSION"]
+		}
+	}
+
+	facts["os_family"] = "FreeBSD"
+
+	if facts["os_version_long"] == "" {
+		out, err := exec.Command("freebsd-version", "-u").Output()
+		if err != nil {
+			logger.V(1).Printf("unable to run freebsd-version: %v", err)
+		} else {
+			facts["os_name"] = "FreeBSD"
+			facts["os_version_long"] = strings.TrimSpace(string(out))
+			facts["os_version"] = strings.SplitN(facts["os_version_long"], "-", 2)[0]
+			facts["os_pretty_name"] = "FreeBSD " + facts["os_version_long"]
+		}
+	}
+
+	// Appliances based on FreeBSD (pfSense, FreeNAS/TrueNAS) store their own version there
+	if f.hostRootPath != "" {
+		v, err := ioutil.ReadFile(filepath.Join(f.hostRootPath, "etc/version"))
+		if err == nil && len(v) > 0 {
+			facts["os_pretty_name"] = strings.TrimSpace(string(v))
+		}
+	}
+
+	var utsName unix.Utsname
+
+	err := unix.Uname(&utsName)
+	if err == nil {
+		facts["kernel"] = bytesToString(utsName.Sysname[:])
+		facts["kernel_release"] = bytesToString(utsName.Release[:])
+		l := strings.SplitN(facts["kernel_release"], "-", 2)
+		facts["kernel_version"] = l[0]
+		l = strings.SplitN(facts["kernel_release"], ".", 3)
+		facts["kernel_major_version"] = l[0]
+	}
+
+	for fact, name := range kenvFacts {
+		out, err := exec.Command("kenv", "-q", name).Output()
+		if err == nil && len(strings.TrimSpace(string(out))) > 0 {
+			facts[fact] = strings.TrimSpace(string(out))
+		}
+	}
+
+	return facts
+}
+
+// primaryAddresses returns the primary IPv4
+//
+// This should be the IP address that this server use to communicate
+// on internet. It may be the private IP if the box is NATed.
+func (f *FactProvider) primaryAddress(ctx context.Context) (ipAddress string, macAddress string) {
+	// Connecting an UDP socket don't send any packet but let the kernel choose the source address.
+	conn, err := net.Dial("udp", "8.8.8.8:53")
+	if err != nil {
+		logger.V(1).Printf("unable to find the primary address: %v", err)
+		return
+	}
+
+	defer conn.Close()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	ipAddress = addr.IP.String()
+
+	return ipAddress, macAddressByAddress(ctx, ipAddress)
+}
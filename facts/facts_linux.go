@@ -17,7 +17,6 @@
 package facts
 
 import (
-	"bytes"
 	"context"
 	"glouton/logger"
 	"io/ioutil"
@@ -26,8 +25,6 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/shirou/gopsutil/load"
-	psutilNet "github.com/shirou/gopsutil/net"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
@@ -126,34 +123,3 @@ func (f *FactProvider) primaryAddress(ctx context.Context) (ipAddress string, ma
 
 	return routes[0].Src.String(), macAddressByAddress(ctx, routes[0].Src.String())
 }
-
-func bytesToString(buffer []byte) string {
-	n := bytes.IndexByte(buffer, 0)
-	return string(buffer[:n])
-}
-
-func macAddressByAddress(ctx context.Context, ipAddress string) string {
-	ifs, err := psutilNet.InterfacesWithContext(ctx)
-	if err != nil {
-		return ""
-	}
-
-	for _, i := range ifs {
-		for _, a := range i.Addrs {
-			if a.Addr == ipAddress {
-				return i.HardwareAddr
-			}
-		}
-	}
-
-	return ""
-}
-
-func getCPULoads() ([]float64, error) {
-	loads, err := load.Avg()
-	if err != nil {
-		return nil, err
-	}
-
-	return []float64{loads.Load1, loads.Load5, loads.Load15}, nil
-}
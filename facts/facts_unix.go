@@ -0,0 +1,58 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux freebsd
+
+package facts
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/shirou/gopsutil/load"
+	psutilNet "github.com/shirou/gopsutil/net"
+)
+
+func bytesToString(buffer []byte) string {
+	n := bytes.IndexByte(buffer, 0)
+	return string(buffer[:n])
+}
+
+func macAddressByAddress(ctx context.Context, ipAddress string) string {
+	ifs, err := psutilNet.InterfacesWithContext(ctx)
+	if err != nil {
+		return ""
+	}
+
+	for _, i := range ifs {
+		for _, a := range i.Addrs {
+			if a.Addr == ipAddress {
+				return i.HardwareAddr
+			}
+		}
+	}
+
+	return ""
+}
+
+func getCPULoads() ([]float64, error) {
+	loads, err := load.Avg()
+	if err != nil {
+		return nil, err
+	}
+
+	return []float64{loads.Load1, loads.Load5, loads.Load15}, nil
+}
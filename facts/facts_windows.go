@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"glouton/logger"
 	"net"
+	"time"
 
 	"github.com/StackExchange/wmi"
 	"golang.org/x/sys/windows/registry"
@@ -30,6 +31,21 @@ import (
 type Win32_ComputerSystem struct {
 	Model        string
 	Manufacturer string
+	Domain       string
+	PartOfDomain bool
+}
+
+//nolint
+type Win32_QuickFixEngineering struct {
+	HotFixID    string
+	InstalledOn string
+}
+
+//nolint
+type Win32_Service struct {
+	Name      string
+	StartMode string
+	State     string
 }
 
 //nolint
@@ -169,6 +185,16 @@ func (f *FactProvider) platformFacts() map[string]string {
 	default:
 		facts["system_vendor"] = system[0].Manufacturer
 		facts["product_name"] = system[0].Model
+
+		if system[0].PartOfDomain {
+			facts["domain"] = system[0].Domain
+		} else {
+			facts["workgroup"] = system[0].Domain
+		}
+	}
+
+	for k, v := range windowsUpdateFacts(wmiClient) {
+		facts[k] = v
 	}
 
 	var bios []Win32_BIOS
@@ -190,6 +216,54 @@ func (f *FactProvider) platformFacts() map[string]string {
 	return facts
 }
 
+// windowsUpdateFacts returns the state of the Windows Update service and the date of the
+// last installed update.
+func windowsUpdateFacts(wmiClient *wmi.Client) map[string]string {
+	facts := make(map[string]string)
+
+	var services []Win32_Service
+
+	err := wmiClient.Query(wmi.CreateQuery(&services, "WHERE Name = 'wuauserv'"), &services)
+
+	switch {
+	case err != nil:
+		logger.V(1).Printf("unable to read wmi informations: %v", err)
+	case len(services) == 0:
+		logger.V(1).Printf("the Windows Update service wasn't found")
+	default:
+		facts["windows_update_service"] = fmt.Sprintf("%s (%s)", services[0].State, services[0].StartMode)
+	}
+
+	var hotfixes []Win32_QuickFixEngineering
+
+	err = wmiClient.Query(wmi.CreateQuery(&hotfixes, ""), &hotfixes)
+	if err != nil {
+		logger.V(1).Printf("unable to read wmi informations: %v", err)
+
+		return facts
+	}
+
+	var lastInstall time.Time
+
+	for _, hotfix := range hotfixes {
+		// InstalledOn uses the US date format, without time.
+		installedOn, err := time.Parse("1/2/2006", hotfix.InstalledOn)
+		if err != nil {
+			continue
+		}
+
+		if installedOn.After(lastInstall) {
+			lastInstall = installedOn
+		}
+	}
+
+	if !lastInstall.IsZero() {
+		facts["windows_update_last_installed_at"] = lastInstall.Format("2006-01-02")
+	}
+
+	return facts
+}
+
 // primaryAddresses returns the primary IPv4
 //
 // This should be the IP address that this server use to communicate
@@ -18,11 +18,13 @@ package facts
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"glouton/logger"
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -31,24 +33,52 @@ import (
 	psutilNet "github.com/shirou/gopsutil/net"
 )
 
-// NetstatProvider provide netstat information from both a file (output of netstat command) and using gopsutil
+var errNetstatNotSupported = errors.New("reading sockets from /proc is not supported on this system")
+
+// NetstatProvider provide netstat information from /proc, a file (output of netstat command) and using gopsutil
 //
-// The file is useful since gopsutil will be run with current privilege which are unlikely to be root.
-// The file should be the output of netstat run as root.
+// On Linux the sockets are read from /proc, which requires root or the CAP_SYS_PTRACE capability
+// to see the sockets of processes of other users. Without it, the file is used. It should be the
+// output of netstat run as root.
 type NetstatProvider struct {
 	FilePath string
+	// HostRootPath is the path where the host filesystem is mounted, "/" when not running in a container.
+	HostRootPath string
 }
 
 // Netstat return a mapping from PID to listening addresses
 //
 // Supported addresses network is currently "tcp", "udp" or "unix".
 func (np NetstatProvider) Netstat(ctx context.Context) (netstat map[int][]ListenAddress, err error) {
-	netstatData, err := ioutil.ReadFile(np.FilePath)
-	if err != nil && !os.IsNotExist(err) {
-		logger.V(1).Printf("Unable to read netstat file: %v", err)
+	procPath := filepath.Join(np.HostRootPath, "proc")
+	if np.HostRootPath == "" {
+		procPath = "/proc"
+	}
+
+	netstat, complete, procErr := procNetstat(procPath)
+	if procErr != nil && procErr != errNetstatNotSupported {
+		logger.V(1).Printf("Unable to read the sockets from %s: %v", procPath, procErr)
 	}
 
-	netstat = decodeNetstatFile(string(netstatData))
+	if netstat == nil {
+		netstat = make(map[int][]ListenAddress)
+	}
+
+	if !complete {
+		netstatData, err := ioutil.ReadFile(np.FilePath)
+		if err != nil && !os.IsNotExist(err) {
+			logger.V(1).Printf("Unable to read netstat file: %v", err)
+		}
+
+		mergeNetstat(netstat, decodeNetstatFile(string(netstatData)))
+	}
+
+	mergeNetstat(netstat, decodeNetstatFile(sockstatOutput(ctx)))
+
+	if procErr == nil {
+		// gopsutil reads the same sockets from /proc.
+		return netstat, nil
+	}
 
 	dynamicNetstat, err := psutilNet.Connections("inet")
 	if err == nil {
@@ -88,6 +118,15 @@ func (np NetstatProvider) Netstat(ctx context.Context) (netstat map[int][]Listen
 	return netstat, nil
 }
 
+// mergeNetstat adds the addresses of other to netstat.
+func mergeNetstat(netstat map[int][]ListenAddress, other map[int][]ListenAddress) {
+	for pid, addresses := range other {
+		for _, addr := range addresses {
+			netstat[pid] = addAddress(netstat[pid], addr)
+		}
+	}
+}
+
 //nolint:gochecknoglobals
 var (
 	netstatRE = regexp.MustCompile(
@@ -96,6 +135,13 @@ var (
 	netstatUnixRE = regexp.MustCompile(
 		`^(?P<protocol>unix)\s+\d+\s+\[\s+(ACC |W |N )+\s*\]\s+(DGRAM|STREAM)\s+LISTENING\s+(\d+\s+)?(?P<pid>\d+)/(?P<program>.*)\s+(?P<address>.+)$`,
 	)
+	// sockstatRE match the output of FreeBSD "sockstat -46lu".
+	sockstatRE = regexp.MustCompile(
+		`^\S+\s+(?P<program>\S+)\s+(?P<pid>\d+)\s+\d+\s+(?P<protocol>udp[46]?|tcp[46]?)\s+(?P<address>[0-9a-f.:*]+):(?P<port>\d+)\s+\S+`,
+	)
+	sockstatUnixRE = regexp.MustCompile(
+		`^\S+\s+(?P<program>\S+)\s+(?P<pid>\d+)\s+\d+\s+(stream|seqpac)\s+(?P<address>/\S+)`,
+	)
 )
 
 // ListenAddress is net.Addr implmentation.
@@ -143,21 +189,40 @@ func decodeNetstatFile(data string) map[int][]ListenAddress {
 			if err != nil {
 				continue
 			}
-		} else {
-			r = netstatUnixRE.FindStringSubmatch(line)
-			if r == nil {
+		} else if r = netstatUnixRE.FindStringSubmatch(line); r != nil {
+			protocol = "unix"
+			address = r[7]
+
+			pid, err = strconv.ParseInt(r[5], 10, 0)
+			if err != nil {
 				continue
 			}
 
-			protocol = r[1]
-			address = r[7]
+			port = 0
+		} else if r = sockstatRE.FindStringSubmatch(line); r != nil {
+			protocol, address = sockstatAddress(r[3], r[4])
 
-			pid, err = strconv.ParseInt(r[5], 10, 0)
+			port, err = strconv.ParseInt(r[5], 10, 0)
+			if err != nil {
+				continue
+			}
+
+			pid, err = strconv.ParseInt(r[2], 10, 0)
+			if err != nil {
+				continue
+			}
+		} else if r = sockstatUnixRE.FindStringSubmatch(line); r != nil {
+			protocol = "unix"
+			address = r[4]
+
+			pid, err = strconv.ParseInt(r[2], 10, 0)
 			if err != nil {
 				continue
 			}
 
 			port = 0
+		} else {
+			continue
 		}
 
 		addresses := result[int(pid)]
@@ -175,6 +240,23 @@ func decodeNetstatFile(data string) map[int][]ListenAddress {
 	return result
 }
 
+// sockstatAddress convert sockstat protocol & address to the ones used by netstat.
+//
+// sockstat use "tcp4"/"tcp6" and "*" for wildcard address.
+func sockstatAddress(protocol string, address string) (string, string) {
+	protocol = strings.TrimSuffix(protocol, "4")
+
+	if address == "*" {
+		if strings.HasSuffix(protocol, "6") {
+			address = "::"
+		} else {
+			address = "0.0.0.0"
+		}
+	}
+
+	return protocol, address
+}
+
 func addAddress(addresses []ListenAddress, newAddr ListenAddress) []ListenAddress {
 	duplicate := false
 
@@ -0,0 +1,38 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"context"
+	"glouton/logger"
+	"os/exec"
+)
+
+// sockstatOutput return the output of "sockstat -46lu".
+//
+// On FreeBSD gopsutil rely on lsof which is usually not installed. sockstat is part
+// of the base system and list sockets of all users unless security.bsd.see_other_uids is disabled.
+func sockstatOutput(ctx context.Context) string {
+	out, err := exec.CommandContext(ctx, "sockstat", "-46lu").Output()
+	if err != nil {
+		logger.V(1).Printf("Unable to run sockstat: %v", err)
+
+		return ""
+	}
+
+	return string(out)
+}
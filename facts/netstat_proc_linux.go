@@ -0,0 +1,248 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// capSysPtrace is the capability needed to read the file descriptors of processes of other users.
+	capSysPtrace = 19
+
+	// tcpListen is the TCP_LISTEN state in /proc/net/tcp.
+	tcpListen = 0x0a
+	// udpUnconnected is the TCP_CLOSE state, used by bound UDP sockets in /proc/net/udp.
+	udpUnconnected = 0x07
+	// unixAcceptConnections is the __SO_ACCEPTCON flag of listening sockets in /proc/net/unix.
+	unixAcceptConnections = 0x10000
+)
+
+// procNetstat returns the listening sockets of processes like "netstat -lnp" does: sockets
+// are read from /proc/net and matched by inode to the file descriptors of processes.
+//
+// complete is false when Glouton can't read the file descriptors of processes of other
+// users, i.e. it's neither root nor has the CAP_SYS_PTRACE capability.
+func procNetstat(procPath string) (netstat map[int][]ListenAddress, complete bool, err error) {
+	sockets := make(map[uint64]ListenAddress)
+
+	for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6", "unix"} {
+		f, err := os.Open(filepath.Join(procPath, "net", protocol))
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if protocol == "unix" {
+			err = decodeProcNetUnix(f, sockets)
+		} else {
+			err = decodeProcNetSockets(f, protocol, sockets)
+		}
+
+		f.Close()
+
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", protocol, err)
+		}
+	}
+
+	pids, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	netstat = make(map[int][]ListenAddress)
+
+	for _, entry := range pids {
+		pid, err := strconv.ParseInt(entry.Name(), 10, 0)
+		if err != nil {
+			continue
+		}
+
+		fdPath := filepath.Join(procPath, entry.Name(), "fd")
+
+		// The process may exit at any time and the file descriptors of processes of
+		// other users are only readable with CAP_SYS_PTRACE.
+		fds, err := ioutil.ReadDir(fdPath)
+		if err != nil {
+			continue
+		}
+
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdPath, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			if address, ok := sockets[inode]; ok {
+				netstat[int(pid)] = addAddress(netstat[int(pid)], address)
+			}
+		}
+	}
+
+	return netstat, hasCapability(capSysPtrace), nil
+}
+
+// decodeProcNetSockets adds the listening sockets of /proc/net/{tcp,tcp6,udp,udp6} to sockets, by inode.
+func decodeProcNetSockets(r io.Reader, protocol string, sockets map[uint64]ListenAddress) error {
+	scanner := bufio.NewScanner(r)
+
+	// Skip the header.
+	scanner.Scan()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid state %#v: %w", fields[3], err)
+		}
+
+		if (strings.HasPrefix(protocol, "tcp") && state != tcpListen) || (strings.HasPrefix(protocol, "udp") && state != udpUnconnected) {
+			continue
+		}
+
+		parts := strings.Split(fields[1], ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid local address %#v", fields[1])
+		}
+
+		ip, err := decodeProcNetIP(parts[0])
+		if err != nil {
+			return fmt.Errorf("invalid local address %#v: %w", fields[1], err)
+		}
+
+		port, err := strconv.ParseUint(parts[1], 16, 16)
+		if err != nil {
+			return fmt.Errorf("invalid local address %#v: %w", fields[1], err)
+		}
+
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid inode %#v: %w", fields[9], err)
+		}
+
+		sockets[inode] = ListenAddress{
+			NetworkFamily: protocol,
+			Address:       ip.String(),
+			Port:          int(port),
+		}
+	}
+
+	return scanner.Err()
+}
+
+// decodeProcNetIP decodes an address of /proc/net/tcp. It's written as 32 bits words in
+// host byte order, which is little endian on all architectures supported by Glouton.
+func decodeProcNetIP(value string) (net.IP, error) {
+	raw, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) != net.IPv4len && len(raw) != net.IPv6len {
+		return nil, fmt.Errorf("unexpected length %d", len(raw))
+	}
+
+	ip := make(net.IP, len(raw))
+
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+
+	return ip, nil
+}
+
+// decodeProcNetUnix adds the listening sockets of /proc/net/unix to sockets, by inode.
+// Sockets without path are ignored.
+func decodeProcNetUnix(r io.Reader, sockets map[uint64]ListenAddress) error {
+	scanner := bufio.NewScanner(r)
+
+	// Skip the header.
+	scanner.Scan()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid flags %#v: %w", fields[3], err)
+		}
+
+		if flags&unixAcceptConnections == 0 {
+			continue
+		}
+
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid inode %#v: %w", fields[6], err)
+		}
+
+		sockets[inode] = ListenAddress{
+			NetworkFamily: "unix",
+			Address:       fields[7],
+		}
+	}
+
+	return scanner.Err()
+}
+
+// hasCapability returns whether Glouton has the given capability in its effective set.
+func hasCapability(capability uint) bool {
+	data, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+
+		capabilities, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		if err != nil {
+			return false
+		}
+
+		return capabilities&(1<<capability) != 0
+	}
+
+	return false
+}
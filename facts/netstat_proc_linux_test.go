@@ -0,0 +1,66 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeProcNetSockets(t *testing.T) {
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   112        0 21813 1 0000000000000000 100 0 0 10 0
+   1: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 19472 1 0000000000000000 100 0 0 10 0
+   2: 0F02000A:0016 0202000A:D2E4 01 00000000:00000000 02:0004F4B3 00000000     0        0 31245 4 0000000000000000 20 4 29 10 -1
+`
+	tcp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 22012 1 0000000000000000 100 0 0 10 0
+`
+	udp := `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  412: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 18893 2 0000000000000000 0
+`
+	unix := `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 20761 /run/mysqld/mysqld.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 23562
+0000000000000000: 00000002 00000000 00000000 0002 01 20999 /run/systemd/notify
+`
+
+	sockets := make(map[uint64]ListenAddress)
+
+	for protocol, content := range map[string]string{"tcp": tcp, "tcp6": tcp6, "udp": udp} {
+		if err := decodeProcNetSockets(strings.NewReader(content), protocol, sockets); err != nil {
+			t.Fatalf("decodeProcNetSockets(%s) failed: %v", protocol, err)
+		}
+	}
+
+	if err := decodeProcNetUnix(strings.NewReader(unix), sockets); err != nil {
+		t.Fatalf("decodeProcNetUnix() failed: %v", err)
+	}
+
+	want := map[uint64]ListenAddress{
+		21813: {NetworkFamily: "tcp", Address: "127.0.0.1", Port: 3306},
+		19472: {NetworkFamily: "tcp", Address: "0.0.0.0", Port: 22},
+		22012: {NetworkFamily: "tcp6", Address: "::1", Port: 80},
+		18893: {NetworkFamily: "udp", Address: "127.0.0.53", Port: 53},
+		20761: {NetworkFamily: "unix", Address: "/run/mysqld/mysqld.sock"},
+	}
+
+	if !reflect.DeepEqual(sockets, want) {
+		t.Errorf("sockets = %v, want %v", sockets, want)
+	}
+}
@@ -124,3 +124,41 @@ unix  2      [ ACC ]     SEQPACKET  LISTENING     18666    1/init
 		}
 	}
 }
+
+func TestDecodeSockstat(t *testing.T) {
+	// (partial) output of sockstat -46lu on FreeBSD
+	fileContent := `USER     COMMAND    PID   FD PROTO  LOCAL ADDRESS         FOREIGN ADDRESS      
+root     nginx      1234  6  tcp4   *:443                 *:*
+root     nginx      1234  7  tcp6   *:443                 *:*
+root     nginx      1234  8  tcp4   *:80                  *:*
+unbound  unbound    987   3  udp6   ::1:53                *:*
+unbound  unbound    987   4  udp4   127.0.0.1:53          *:*
+root     sshd       765   4  tcp6   fe80::1%lo0:22        *:*
+root     syslogd    543   4  dgram  /var/run/log
+root     php-fpm    321   5  stream /var/run/php-fpm.socket
+?        ?          ?     ?  tcp4   *:111                 *:*
+`
+
+	want := map[int][]ListenAddress{
+		1234: {
+			{NetworkFamily: "tcp", Address: "0.0.0.0", Port: 443},
+			{NetworkFamily: "tcp", Address: "0.0.0.0", Port: 80},
+		},
+		987: {
+			{NetworkFamily: "udp", Address: "127.0.0.1", Port: 53},
+		},
+		321: {
+			{NetworkFamily: "unix", Address: "/var/run/php-fpm.socket"},
+		},
+	}
+
+	got := decodeNetstatFile(fileContent)
+	if len(got) != len(want) {
+		t.Errorf("decodeNetstatFile(...) == %v, want %v", got, want)
+	} else {
+		for pid, g := range got {
+			w := want[pid]
+			cmpAddresses(t, "decodeNetstatFile(...)[%v]", g, w)
+		}
+	}
+}
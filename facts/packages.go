@@ -0,0 +1,133 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errPackagesNotSupported = errors.New("package inventory is not supported on this system")
+
+// Package is an installed package.
+type Package struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture,omitempty"`
+	Manager      string `json:"manager"`
+}
+
+// PackageInventory lists the installed packages (dpkg and rpm).
+//
+// The list is only refreshed by Update, which is slow. Use it when the pending system
+// updates are refreshed, the packages change at the same time.
+type PackageInventory struct {
+	HostRootPath string
+	InContainer  bool
+	// SendList adds the full list of packages to the facts. Otherwise only the count
+	// and the hash are added.
+	SendList bool
+
+	l         sync.Mutex
+	packages  []Package
+	hash      string
+	updatedAt time.Time
+}
+
+// Update refreshes the list of packages. It returns whether the list changed.
+func (pi *PackageInventory) Update(ctx context.Context) (changed bool, err error) {
+	if pi.HostRootPath == "" && pi.InContainer {
+		return false, errPackagesNotSupported
+	}
+
+	packages, err := listPackages(ctx, pi.HostRootPath)
+	if err != nil {
+		return false, err
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+
+		return packages[i].Architecture < packages[j].Architecture
+	})
+
+	hash := packagesHash(packages)
+
+	pi.l.Lock()
+	defer pi.l.Unlock()
+
+	changed = hash != pi.hash
+	pi.packages = packages
+	pi.hash = hash
+	pi.updatedAt = time.Now()
+
+	return changed, nil
+}
+
+// Packages returns the packages found by the last Update, with the hash of the list.
+func (pi *PackageInventory) Packages() (packages []Package, hash string, updatedAt time.Time) {
+	pi.l.Lock()
+	defer pi.l.Unlock()
+
+	return pi.packages, pi.hash, pi.updatedAt
+}
+
+// PackageFacts is a FactCallback which adds the inventory to the facts. The facts only
+// change with the list, so it's only sent again to Bleemeo when a package changes.
+func (pi *PackageInventory) PackageFacts(ctx context.Context, currentFact map[string]string) map[string]string {
+	packages, hash, updatedAt := pi.Packages()
+	if updatedAt.IsZero() {
+		return nil
+	}
+
+	facts := map[string]string{
+		"installed_packages_count": strconv.Itoa(len(packages)),
+		"installed_packages_hash":  hash,
+	}
+
+	if pi.SendList {
+		list := make([]string, len(packages))
+
+		for i, p := range packages {
+			list[i] = p.Name + "=" + p.Version
+		}
+
+		facts["installed_packages"] = strings.Join(list, ",")
+	}
+
+	return facts
+}
+
+func packagesHash(packages []Package) string {
+	h := sha256.New()
+
+	for _, p := range packages {
+		fmt.Fprintf(h, "%s\t%s\t%s\t%s\n", p.Manager, p.Name, p.Architecture, p.Version)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
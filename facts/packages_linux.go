@@ -0,0 +1,164 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"glouton/logger"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func listPackages(ctx context.Context, hostRootPath string) ([]Package, error) {
+	var (
+		packages []Package
+		found    bool
+	)
+
+	statusFile := filepath.Join(hostRootPath, "var/lib/dpkg/status")
+
+	if f, err := os.Open(statusFile); err == nil {
+		dpkgPackages, err := decodeDpkgStatus(f)
+
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		packages = append(packages, dpkgPackages...)
+		found = true
+	}
+
+	if _, err := os.Stat(filepath.Join(hostRootPath, "var/lib/rpm")); err == nil {
+		rpmPackages, err := listRPM(ctx, hostRootPath)
+		if err != nil {
+			logger.V(2).Printf("Unable to list RPM packages: %v", err)
+		} else {
+			packages = append(packages, rpmPackages...)
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, errors.New("no supported package manager found")
+	}
+
+	return packages, nil
+}
+
+// decodeDpkgStatus returns the installed packages from /var/lib/dpkg/status.
+func decodeDpkgStatus(r io.Reader) ([]Package, error) {
+	var (
+		packages  []Package
+		current   Package
+		installed bool
+	)
+
+	flush := func() {
+		if installed && current.Name != "" {
+			current.Manager = "dpkg"
+			packages = append(packages, current)
+		}
+
+		current = Package{}
+		installed = false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			flush()
+
+			continue
+		}
+
+		// Continuation lines of multi-line fields (e.g. Description)
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		part := strings.SplitN(line, ":", 2)
+		if len(part) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(part[1])
+
+		switch part[0] {
+		case "Package":
+			current.Name = value
+		case "Version":
+			current.Version = value
+		case "Architecture":
+			current.Architecture = value
+		case "Status":
+			installed = strings.HasSuffix(value, " installed")
+		}
+	}
+
+	flush()
+
+	return packages, scanner.Err()
+}
+
+func listRPM(ctx context.Context, hostRootPath string) ([]Package, error) {
+	args := []string{"-qa", "--queryformat", `%{NAME}\t%{EPOCH}:%{VERSION}-%{RELEASE}\t%{ARCH}\n`}
+	if hostRootPath != "" && hostRootPath != "/" {
+		args = append([]string{"--root", hostRootPath}, args...)
+	}
+
+	content, err := exec.CommandContext(ctx, "rpm", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRPMQuery(content), nil
+}
+
+func decodeRPMQuery(content []byte) []Package {
+	var packages []Package
+
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		part := strings.Split(string(line), "\t")
+		if len(part) != 3 {
+			continue
+		}
+
+		// Packages without epoch have "(none)" as epoch.
+		version := strings.TrimPrefix(part[1], "(none):")
+
+		packages = append(packages, Package{
+			Name:         part[0],
+			Version:      version,
+			Architecture: part[2],
+			Manager:      "rpm",
+		})
+	}
+
+	return packages
+}
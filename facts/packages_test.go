@@ -0,0 +1,138 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const dpkgStatus = `Package: bash
+Status: install ok installed
+Priority: required
+Architecture: amd64
+Version: 5.0-4
+Description: GNU Bourne Again SHell
+ Bash is an sh-compatible command language interpreter.
+ .
+ It also includes features from the Korn and C shells.
+
+Package: removed-package
+Status: deinstall ok config-files
+Architecture: all
+Version: 1.0
+
+Package: adduser
+Status: install ok installed
+Architecture: all
+Version: 3.118
+`
+
+func TestDecodeDpkgStatus(t *testing.T) {
+	got, err := decodeDpkgStatus(strings.NewReader(dpkgStatus))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Package{
+		{Name: "bash", Version: "5.0-4", Architecture: "amd64", Manager: "dpkg"},
+		{Name: "adduser", Version: "3.118", Architecture: "all", Manager: "dpkg"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeDpkgStatus() = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeRPMQuery(t *testing.T) {
+	content := "bash\t(none):5.0.17-2.fc33\tx86_64\nopenssl\t1:1.1.1g-1.fc33\tx86_64\ngpg-pubkey\t(none):1-2\t(none)\n\n"
+
+	got := decodeRPMQuery([]byte(content))
+	want := []Package{
+		{Name: "bash", Version: "5.0.17-2.fc33", Architecture: "x86_64", Manager: "rpm"},
+		{Name: "openssl", Version: "1:1.1.1g-1.fc33", Architecture: "x86_64", Manager: "rpm"},
+		{Name: "gpg-pubkey", Version: "1-2", Architecture: "(none)", Manager: "rpm"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeRPMQuery() = %v, want %v", got, want)
+	}
+}
+
+func TestPackageInventory(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("package inventory is only supported on Linux")
+	}
+
+	root, err := ioutil.TempDir("", "glouton-packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(root)
+
+	statusFile := filepath.Join(root, "var/lib/dpkg/status")
+
+	if err := os.MkdirAll(filepath.Dir(statusFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(statusFile, []byte(dpkgStatus), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pi := &PackageInventory{HostRootPath: root, SendList: true}
+
+	if facts := pi.PackageFacts(context.Background(), nil); facts != nil {
+		t.Errorf("PackageFacts() = %v before the first update", facts)
+	}
+
+	for i, wantChanged := range []bool{true, false} {
+		changed, err := pi.Update(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if changed != wantChanged {
+			t.Errorf("Update() #%d = %v, want %v", i, changed, wantChanged)
+		}
+	}
+
+	facts := pi.PackageFacts(context.Background(), nil)
+	if facts["installed_packages_count"] != "2" || facts["installed_packages"] != "adduser=3.118,bash=5.0-4" {
+		t.Errorf("PackageFacts() = %v", facts)
+	}
+
+	if err := ioutil.WriteFile(statusFile, []byte(strings.Replace(dpkgStatus, "5.0-4", "5.0-5", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := pi.Update(context.Background())
+	if err != nil || !changed {
+		t.Errorf("Update() = %v, %v, want a change after an upgrade", changed, err)
+	}
+
+	if newFacts := pi.PackageFacts(context.Background(), nil); newFacts["installed_packages_hash"] == facts["installed_packages_hash"] {
+		t.Error("the hash didn't change after an upgrade")
+	}
+}
@@ -18,6 +18,7 @@ package facts
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"glouton/logger"
 	"glouton/version"
@@ -39,9 +40,13 @@ import (
 )
 
 var (
+	// dockerCGroupRE match the container ID in /proc/<pid>/cgroup.
+	// The controllers list is empty for cgroup v2 ("0::/path"). The path could be:
+	// * /docker/<id> or /kubepods/<qos>/pod<uid>/<id> with the cgroupfs driver
+	// * /system.slice/docker-<id>.scope or /kubepods.slice/.../cri-containerd-<id>.scope with the systemd driver
 	//nolint:gochecknoglobals
 	dockerCGroupRE = regexp.MustCompile(
-		`(?m:^\d+:[^:]+:(/kubepods/.*pod[0-9a-fA-F-]+/|.*/docker[-/])([0-9a-fA-F]+)(\.scope)?$)`,
+		`(?m:^\d+:[^:]*:(/kubepods/.*pod[0-9a-fA-F-]+/|.*/docker[-/]|.*/(?:cri-containerd|crio)-)([0-9a-fA-F]+)(\.scope)?$)`,
 	)
 )
 
@@ -457,6 +462,12 @@ func PsStat2Status(psStat string) string {
 		return "zombie"
 	case 'I':
 		return "idle"
+	case 'L':
+		// FreeBSD: waiting to acquire a lock
+		return "disk-sleep"
+	case 'W':
+		// FreeBSD: idle interrupt thread
+		return "sleeping"
 	default:
 		return "?"
 	}
@@ -856,7 +867,7 @@ func (d *dockerProcessImpl) processesContainer(ctx context.Context, containerID
 		return
 	}
 
-	processesMap, err := d.processesContainerMap(ctx, nil, containerID, containerName)
+	processesMap, err := d.processesContainerMap(ctx, nil, containerID, containerName, 0)
 	processes = make([]Process, 0, len(processesMap))
 
 	for _, p := range processesMap {
@@ -866,7 +877,7 @@ func (d *dockerProcessImpl) processesContainer(ctx context.Context, containerID
 	return processes, err
 }
 
-func (d *dockerProcessImpl) processesContainerMap(ctx context.Context, processesMap map[int]Process, containerID string, containerName string) (processes map[int]Process, err error) {
+func (d *dockerProcessImpl) processesContainerMap(ctx context.Context, processesMap map[int]Process, containerID string, containerName string, maxAge time.Duration) (processes map[int]Process, err error) {
 	if d.dockerProvider == nil {
 		return
 	}
@@ -877,9 +888,11 @@ func (d *dockerProcessImpl) processesContainerMap(ctx context.Context, processes
 
 	var top, topWaux container.ContainerTopOKBody
 
-	top, topWaux, err = d.dockerProvider.top(ctx, containerID)
+	top, topWaux, err = d.dockerProvider.top(ctx, containerID, maxAge)
 
 	switch {
+	case errors.Is(err, errCircuitOpen):
+		return processesMap, nil
 	case err != nil && errdefs.IsNotFound(err):
 		return processesMap, nil
 	case err != nil && strings.Contains(fmt.Sprintf("%v", err), "is not running"):
@@ -924,15 +937,42 @@ func (d *dockerProcessImpl) Processes(ctx context.Context, maxAge time.Duration)
 		return
 	}
 
+	var (
+		wg       sync.WaitGroup
+		l        sync.Mutex
+		firstErr error
+	)
+
+	// The concurrency is limited by DockerProvider.top
 	for _, c := range containers {
 		if !c.IsRunning() {
 			continue
 		}
 
-		processesMap, err = d.processesContainerMap(ctx, processesMap, c.ID(), c.Name())
-		if err != nil {
-			return nil, err
-		}
+		wg.Add(1)
+
+		go func(c Container) {
+			defer wg.Done()
+
+			containerProcesses, err := d.processesContainerMap(ctx, nil, c.ID(), c.Name(), maxAge)
+
+			l.Lock()
+			defer l.Unlock()
+
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+
+			for pid, p := range containerProcesses {
+				processesMap[pid] = p
+			}
+		}(c)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
 	processes = make([]Process, 0, len(processesMap))
@@ -135,6 +135,8 @@ func TestPsStat2Status(t *testing.T) {
 		{"Z+", "zombie"},
 		{"T", "stopped"},
 		{"t", "tracing-stop"},
+		{"L", "disk-sleep"},
+		{"W", "sleeping"},
 	}
 	for _, c := range cases {
 		got := PsStat2Status(c.in)
@@ -175,6 +177,44 @@ func TestContainerIDFromCGroupData(t *testing.T) {
 1:name=systemd:/system.slice/docker-bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6.scope`,
 			"bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6",
 		},
+		{
+			"Docker on cgroup v2",
+			`0::/system.slice/docker-bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6.scope`,
+			"bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6",
+		},
+		{
+			"Hybrid hierarchy",
+			`12:pids:/system.slice/docker-bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6.scope
+[...]
+1:name=systemd:/system.slice/docker-bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6.scope
+0::/system.slice/docker-bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6.scope`,
+			"bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6",
+		},
+		{
+			"Kubernetes with systemd driver and Docker",
+			`0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod8f469a2e_bcd6_11e8_abe9_080027ae1159.slice/docker-bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6.scope`,
+			"bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6",
+		},
+		{
+			"Kubernetes with systemd driver and containerd",
+			`0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod8f469a2e_bcd6_11e8_abe9_080027ae1159.slice/cri-containerd-bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6.scope`,
+			"bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6",
+		},
+		{
+			"Kubernetes with cgroupfs driver on cgroup v2",
+			`0::/kubepods/besteffort/pod8f469a2e-bcd6-11e8-abe9-080027ae1159/bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6`,
+			"bc4dd7f3f935c6798df001b908b05544fdadb29bc55e12635ea3558e0a4b87f6",
+		},
+		{
+			"Host process on cgroup v2",
+			`0::/user.slice/user-1000.slice/session-2.scope`,
+			"",
+		},
+		{
+			"Private cgroup namespace",
+			`0::/`,
+			"",
+		},
 	}
 
 	for _, c := range cases {
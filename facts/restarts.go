@@ -0,0 +1,91 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"sort"
+	"time"
+)
+
+// ContainerRestarts is the number of starts of a container during the restart loop period.
+type ContainerRestarts struct {
+	ID      string
+	Starts  int
+	Looping bool
+}
+
+// restartDetector tracks container starts to detect containers restarting in loop, e.g.
+// an application crashing on startup with a restart policy.
+type restartDetector struct {
+	// count is the number of starts during period above which a container is looping.
+	// Zero disables the detection.
+	count  int
+	period time.Duration
+	starts map[string][]time.Time
+}
+
+// observe records the start of a container.
+func (r *restartDetector) observe(containerID string, now time.Time) {
+	if r.count <= 0 {
+		return
+	}
+
+	if r.starts == nil {
+		r.starts = make(map[string][]time.Time)
+	}
+
+	r.starts[containerID] = append(r.starts[containerID], now)
+}
+
+// forget removes a destroyed container.
+func (r *restartDetector) forget(containerID string) {
+	delete(r.starts, containerID)
+}
+
+// restarts returns the containers started during the period, sorted by ID.
+func (r *restartDetector) restarts(now time.Time) []ContainerRestarts {
+	result := make([]ContainerRestarts, 0, len(r.starts))
+
+	for id, starts := range r.starts {
+		i := 0
+
+		for i < len(starts) && now.Sub(starts[i]) > r.period {
+			i++
+		}
+
+		starts = starts[i:]
+
+		if len(starts) == 0 {
+			delete(r.starts, id)
+			continue
+		}
+
+		r.starts[id] = starts
+
+		result = append(result, ContainerRestarts{
+			ID:      id,
+			Starts:  len(starts),
+			Looping: len(starts) >= r.count,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
@@ -0,0 +1,61 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRestartDetector(t *testing.T) {
+	r := restartDetector{count: 3, period: 10 * time.Minute}
+	t0 := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		r.observe("looping", t0.Add(time.Duration(i)*time.Minute))
+	}
+
+	r.observe("stable", t0)
+	r.observe("destroyed", t0)
+	r.forget("destroyed")
+
+	want := []ContainerRestarts{
+		{ID: "looping", Starts: 3, Looping: true},
+		{ID: "stable", Starts: 1},
+	}
+
+	if got := r.restarts(t0.Add(5 * time.Minute)); !reflect.DeepEqual(got, want) {
+		t.Errorf("restarts() = %v, want %v", got, want)
+	}
+
+	// Older starts are forgotten.
+	want = []ContainerRestarts{
+		{ID: "looping", Starts: 2},
+	}
+
+	if got := r.restarts(t0.Add(11 * time.Minute)); !reflect.DeepEqual(got, want) {
+		t.Errorf("restarts() = %v, want %v", got, want)
+	}
+
+	disabled := restartDetector{}
+	disabled.observe("looping", t0)
+
+	if got := disabled.restarts(t0); len(got) != 0 {
+		t.Errorf("restarts() = %v, want none when disabled", got)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"bytes"
+	"context"
+	"glouton/logger"
+	"os/exec"
+)
+
+// decodePkgOutput decode the output of "pkg version -vRL=" or "pkg audit -q".
+// Each line is a package which need an update (or is vulnerable).
+func decodePkgOutput(content []byte) int {
+	pendingUpdates := 0
+
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			pendingUpdates++
+		}
+	}
+
+	return pendingUpdates
+}
+
+func (uf updateFacter) pendingUpdates(ctx context.Context) (pendingUpdates int, pendingSecurityUpdates int) {
+	if uf.InContainer {
+		return -1, -1
+	}
+
+	cmd := exec.CommandContext(ctx, "pkg", "version", "-vRL=")
+	cmd.Env = uf.Environ
+
+	content, err := cmd.Output()
+	if err != nil {
+		logger.V(2).Printf("Unable to execute pkg version: %v", err)
+		return -1, -1
+	}
+
+	pendingUpdates = decodePkgOutput(content)
+
+	cmd = exec.CommandContext(ctx, "pkg", "audit", "-q")
+	cmd.Env = uf.Environ
+
+	// pkg audit exits with status 1 when vulnerable packages are found.
+	content, err = cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+
+	if err != nil {
+		logger.V(2).Printf("Unable to execute pkg audit: %v", err)
+		return pendingUpdates, -1
+	}
+
+	return pendingUpdates, decodePkgOutput(content)
+}
@@ -17,6 +17,7 @@
 package facts
 
 import (
+	"bytes"
 	"context"
 	"glouton/logger"
 	"io/ioutil"
@@ -132,6 +133,70 @@ func decodeYUM(content []byte, contentSecurity []byte) (pendingUpdates int, pend
 	return
 }
 
+func decodeZypperUpdates(content []byte) int {
+	result := 0
+
+	for _, line := range strings.Split(string(content), "\n") {
+		// The first column is the status, "v" means a newer version is available.
+		if strings.HasPrefix(line, "v ") {
+			result++
+		}
+	}
+
+	return result
+}
+
+// decodeZypperPatches counts the rows of the table output of zypper list-patches.
+func decodeZypperPatches(content []byte) int {
+	re := regexp.MustCompile(`^-+(\+-+)+$`)
+	result := 0
+	inTable := false
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if re.MatchString(line) {
+			inTable = true
+			continue
+		}
+
+		if inTable && strings.Contains(line, "|") {
+			result++
+		}
+	}
+
+	return result
+}
+
+func decodeZypper(content []byte, contentSecurity []byte) (pendingUpdates int, pendingSecurityUpdates int) {
+	pendingUpdates = decodeZypperUpdates(content)
+	pendingSecurityUpdates = decodeZypperPatches(contentSecurity)
+
+	return
+}
+
+// decodeAPK decodes the output of "apk version -l <". Alpine doesn't tell which updates fix a
+// security issue, the pending security updates are unknown.
+func decodeAPK(content []byte) (pendingUpdates int, pendingSecurityUpdates int) {
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.Contains(line, " < ") {
+			pendingUpdates++
+		}
+	}
+
+	return pendingUpdates, -1
+}
+
+// decodePacman decodes the output of "pacman -Qu". Arch Linux doesn't tell which updates fix a
+// security issue, the pending security updates are unknown.
+func decodePacman(content []byte) (pendingUpdates int, pendingSecurityUpdates int) {
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.Contains(line, " -> ") {
+			pendingUpdates++
+		}
+	}
+
+	return pendingUpdates, -1
+}
+
 func (uf updateFacter) fromUpdateNotifierFile(context.Context) (pendingUpdates int, pendingSecurityUpdates int) {
 	updateFile := filepath.Join(uf.HostRootPath, "var/lib/update-notifier/updates-available")
 
@@ -205,6 +270,113 @@ func (uf updateFacter) fromYUM(ctx context.Context) (pendingUpdates int, pending
 	return decodeYUM(content, contentSecurity)
 }
 
+func (uf updateFacter) fromZypper(ctx context.Context) (pendingUpdates int, pendingSecurityUpdates int) {
+	cmd := exec.CommandContext(ctx, "zypper", "--non-interactive", "--no-refresh", "--quiet", "list-updates")
+	cmd.Env = uf.Environ
+
+	content, err := cmd.CombinedOutput()
+	if err != nil {
+		logger.V(2).Printf("Unable to execute zypper: %v", err)
+		return -1, -1
+	}
+
+	cmd = exec.CommandContext(ctx, "zypper", "--non-interactive", "--no-refresh", "--quiet", "list-patches", "--category", "security")
+	cmd.Env = uf.Environ
+
+	contentSecurity, err := cmd.CombinedOutput()
+	if err != nil {
+		logger.V(2).Printf("Unable to execute zypper: %v", err)
+		return -1, -1
+	}
+
+	return decodeZypper(content, contentSecurity)
+}
+
+func (uf updateFacter) fromAPK(ctx context.Context) (pendingUpdates int, pendingSecurityUpdates int) {
+	cmd := exec.CommandContext(ctx, "apk", "--no-network", "version", "-l", "<")
+	cmd.Env = uf.Environ
+
+	content, err := cmd.CombinedOutput()
+	if err != nil {
+		logger.V(2).Printf("Unable to execute apk: %v", err)
+		return -1, -1
+	}
+
+	return decodeAPK(content)
+}
+
+func (uf updateFacter) fromPacman(ctx context.Context) (pendingUpdates int, pendingSecurityUpdates int) {
+	cmd := exec.CommandContext(ctx, "pacman", "--query", "--upgrades")
+	cmd.Env = uf.Environ
+
+	content, err := cmd.CombinedOutput()
+
+	// pacman exits with status 1 when there is no update.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 && len(bytes.TrimSpace(content)) == 0 {
+		err = nil
+	}
+
+	if err != nil {
+		logger.V(2).Printf("Unable to execute pacman: %v", err)
+		return -1, -1
+	}
+
+	return decodePacman(content)
+}
+
+// distributionMethods returns the methods able to count updates on the distribution described
+// by the os-release file. All methods are returned if the distribution is unknown.
+func (uf updateFacter) distributionMethods() []func(context.Context) (int, int) {
+	apt := []func(context.Context) (int, int){uf.fromAPTCheck, uf.fromAPTGet}
+	rpm := []func(context.Context) (int, int){uf.fromDNF, uf.fromYUM}
+	zypper := []func(context.Context) (int, int){uf.fromZypper}
+	apk := []func(context.Context) (int, int){uf.fromAPK}
+	pacman := []func(context.Context) (int, int){uf.fromPacman}
+
+	osReleasePath := filepath.Join(uf.HostRootPath, "etc/os-release")
+
+	osReleaseData, err := ioutil.ReadFile(osReleasePath)
+	if err != nil {
+		logger.V(2).Printf("Unable to read file %#v: %v", osReleasePath, err)
+	}
+
+	osRelease, _ := decodeOsRelease(string(osReleaseData))
+
+	for _, id := range osReleaseIDs(osRelease) {
+		switch id {
+		case "debian", "ubuntu":
+			return apt
+		case "fedora", "rhel", "centos":
+			return rpm
+		case "suse", "opensuse", "sles":
+			return zypper
+		case "alpine":
+			return apk
+		case "arch":
+			return pacman
+		}
+	}
+
+	methods := make([]func(context.Context) (int, int), 0)
+
+	for _, m := range [][]func(context.Context) (int, int){apt, rpm, zypper, apk, pacman} {
+		methods = append(methods, m...)
+	}
+
+	return methods
+}
+
+// osReleaseIDs returns the ID of the distribution followed by the distributions it's derived from.
+func osReleaseIDs(osRelease map[string]string) []string {
+	ids := make([]string, 0)
+
+	if osRelease["ID"] != "" {
+		ids = append(ids, osRelease["ID"])
+	}
+
+	return append(ids, strings.Fields(osRelease["ID_LIKE"])...)
+}
+
 func (uf updateFacter) pendingUpdates(ctx context.Context) (pendingUpdates int, pendingSecurityUpdates int) {
 	pendingUpdates = -1
 	pendingSecurityUpdates = -1
@@ -225,13 +397,7 @@ func (uf updateFacter) pendingUpdates(ctx context.Context) (pendingUpdates int,
 		}
 
 		uf.Environ = environ
-		methods = append(
-			methods,
-			uf.fromAPTCheck,
-			uf.fromAPTGet,
-			uf.fromDNF,
-			uf.fromYUM,
-		)
+		methods = append(methods, uf.distributionMethods()...)
 	}
 
 	for i, m := range methods {
@@ -0,0 +1,103 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package facts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeZypper(t *testing.T) {
+	updates := `S | Repository       | Name       | Current Version | Available Version | Arch
+--+------------------+------------+-----------------+-------------------+-------
+v | Update Channel   | curl       | 7.66.0-4.3.1    | 7.66.0-4.6.1      | x86_64
+v | Update Channel   | libcurl4   | 7.66.0-4.3.1    | 7.66.0-4.6.1      | x86_64
+v | Update Channel   | vim        | 8.0.1568-5.3.1  | 8.0.1568-5.6.1    | x86_64
+`
+	patches := `Repository     | Name                 | Category | Severity  | Interactive | Status | Summary
+---------------+----------------------+----------+-----------+-------------+--------+-----------------------------
+Update Channel | openSUSE-2020-1060   | security | important | ---         | needed | Security update for curl
+`
+
+	gotUpdate, gotSecurity := decodeZypper([]byte(updates), []byte(patches))
+	if gotUpdate != 3 || gotSecurity != 1 {
+		t.Errorf("decodeZypper() == %d, %d want 3, 1", gotUpdate, gotSecurity)
+	}
+
+	gotUpdate, gotSecurity = decodeZypper([]byte("No updates found.\n"), []byte("No updates found.\n"))
+	if gotUpdate != 0 || gotSecurity != 0 {
+		t.Errorf("decodeZypper() == %d, %d want 0, 0", gotUpdate, gotSecurity)
+	}
+}
+
+func TestDecodeAPK(t *testing.T) {
+	content := `Installed:                                Available:
+busybox-1.31.1-r16                      < 1.31.1-r19
+ssl_client-1.31.1-r16                   < 1.31.1-r19
+`
+
+	gotUpdate, gotSecurity := decodeAPK([]byte(content))
+	if gotUpdate != 2 || gotSecurity != -1 {
+		t.Errorf("decodeAPK() == %d, %d want 2, -1", gotUpdate, gotSecurity)
+	}
+}
+
+func TestDecodePacman(t *testing.T) {
+	content := `linux 5.8.1.arch1-1 -> 5.8.3.arch1-1
+openssl 1.1.1.g-1 -> 1.1.1.g-2
+`
+
+	gotUpdate, gotSecurity := decodePacman([]byte(content))
+	if gotUpdate != 2 || gotSecurity != -1 {
+		t.Errorf("decodePacman() == %d, %d want 2, -1", gotUpdate, gotSecurity)
+	}
+
+	gotUpdate, _ = decodePacman(nil)
+	if gotUpdate != 0 {
+		t.Errorf("decodePacman(nil) == %d want 0", gotUpdate)
+	}
+}
+
+func TestOsReleaseIDs(t *testing.T) {
+	cases := []struct {
+		osRelease map[string]string
+		want      []string
+	}{
+		{
+			osRelease: map[string]string{"ID": "ubuntu", "ID_LIKE": "debian"},
+			want:      []string{"ubuntu", "debian"},
+		},
+		{
+			osRelease: map[string]string{"ID": "rocky", "ID_LIKE": "rhel centos fedora"},
+			want:      []string{"rocky", "rhel", "centos", "fedora"},
+		},
+		{
+			osRelease: map[string]string{"ID": "alpine"},
+			want:      []string{"alpine"},
+		},
+		{
+			osRelease: nil,
+			want:      []string{},
+		},
+	}
+
+	for i, c := range cases {
+		if got := osReleaseIDs(c.osRelease); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("osReleaseIDs([case %d]) == %v, want %v", i, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"glouton/logger"
+	"glouton/store"
+	"glouton/types"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Outputs supported by the Writer.
+const (
+	OutputV2   = "v2"
+	OutputHTTP = "http"
+	OutputUDP  = "udp"
+)
+
+const (
+	writeTimeout  = 10 * time.Second
+	minRetryDelay = 10 * time.Second
+	maxRetryDelay = 5 * time.Minute
+	maxUDPPayload = 1400
+)
+
+var errUnknownOutput = errors.New("unknown InfluxDB output")
+
+// WriterOptions configure a Writer.
+type WriterOptions struct {
+	// Output is one of OutputV2, OutputHTTP or OutputUDP.
+	Output string
+	// URL is the base URL of the InfluxDB 2.x server, the write URL for OutputHTTP
+	// or the "host:port" address for OutputUDP.
+	URL    string
+	Token  string
+	Org    string
+	Bucket string
+
+	AdditionalTags map[string]string
+	Store          *store.Store
+}
+
+// Writer send points using the InfluxDB line protocol, either to an InfluxDB 2.x
+// server or to any HTTP or UDP endpoint accepting the line protocol.
+type Writer struct {
+	opts             WriterOptions
+	writeURL         string
+	httpClient       *http.Client
+	maxPendingPoints int
+	maxBatchSize     int
+
+	lock          sync.Mutex
+	pendingPoints []types.MetricPoint
+	lastErr       error
+	lastSuccess   time.Time
+}
+
+// NewWriter create a new line protocol writer.
+func NewWriter(opts WriterOptions) (*Writer, error) {
+	w := &Writer{
+		opts:             opts,
+		httpClient:       &http.Client{Timeout: writeTimeout},
+		maxPendingPoints: defaultMaxPendingPoints,
+		maxBatchSize:     defaultBatchSize,
+	}
+
+	switch opts.Output {
+	case OutputV2:
+		u, err := url.Parse(opts.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		u, _ = u.Parse("api/v2/write")
+
+		params := u.Query()
+		params.Set("org", opts.Org)
+		params.Set("bucket", opts.Bucket)
+		params.Set("precision", "s")
+		u.RawQuery = params.Encode()
+
+		w.writeURL = u.String()
+	case OutputHTTP:
+		if _, err := url.Parse(opts.URL); err != nil {
+			return nil, err
+		}
+
+		w.writeURL = opts.URL
+	case OutputUDP:
+		if _, _, err := net.SplitHostPort(opts.URL); err != nil {
+			return nil, err
+		}
+
+		w.writeURL = opts.URL
+	default:
+		return nil, fmt.Errorf("%w: %#v", errUnknownOutput, opts.Output)
+	}
+
+	return w, nil
+}
+
+// addPoints adds points to the pending points, dropping the older points when the buffer is full.
+func (w *Writer) addPoints(points []types.MetricPoint) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.pendingPoints = append(w.pendingPoints, points...)
+
+	if len(w.pendingPoints) > w.maxPendingPoints {
+		w.pendingPoints = append(w.pendingPoints[:0], w.pendingPoints[len(w.pendingPoints)-w.maxPendingPoints:]...)
+	}
+}
+
+// encodeLines convert points to the line protocol with a precision of one second.
+func encodeLines(points []types.MetricPoint, additionalTags map[string]string) [][]byte {
+	lines := make([][]byte, 0, len(points))
+
+	for _, metricPoint := range points {
+		pt, err := convertMetricPoint(metricPoint, additionalTags)
+		if err != nil {
+			logger.V(2).Printf("Unable to convert the point of %s to line protocol: %v", metricPoint.Labels[types.LabelName], err)
+			continue
+		}
+
+		lines = append(lines, []byte(pt.PrecisionString("s")+"\n"))
+	}
+
+	return lines
+}
+
+// writeBatch send one batch of points.
+func (w *Writer) writeBatch(ctx context.Context, points []types.MetricPoint) error {
+	lines := encodeLines(points, w.opts.AdditionalTags)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if w.opts.Output == OutputUDP {
+		return w.writeUDP(ctx, lines)
+	}
+
+	return w.writeHTTP(ctx, bytes.Join(lines, nil))
+}
+
+func (w *Writer) writeHTTP(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if w.opts.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.opts.Token)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 250))
+
+		return fmt.Errorf("response code %d: %s", resp.StatusCode, bytes.TrimSpace(content))
+	}
+
+	// Read the body to allow connection reuse.
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+// writeUDP send lines grouped in datagrams of at most maxUDPPayload bytes.
+// A single line larger than this limit is sent alone.
+func (w *Writer) writeUDP(ctx context.Context, lines [][]byte) error {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "udp", w.writeURL)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	var buffer bytes.Buffer
+
+	for _, line := range lines {
+		if buffer.Len() > 0 && buffer.Len()+len(line) > maxUDPPayload {
+			if _, err := conn.Write(buffer.Bytes()); err != nil {
+				return err
+			}
+
+			buffer.Reset()
+		}
+
+		buffer.Write(line)
+	}
+
+	if buffer.Len() > 0 {
+		if _, err := conn.Write(buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flush send all pending points by batch of maxBatchSize. It stops on the first error,
+// points which failed to be sent are kept for the next attempt.
+func (w *Writer) flush(ctx context.Context) error {
+	for ctx.Err() == nil {
+		w.lock.Lock()
+
+		batchSize := len(w.pendingPoints)
+		if batchSize > w.maxBatchSize {
+			batchSize = w.maxBatchSize
+		}
+
+		batch := make([]types.MetricPoint, batchSize)
+		copy(batch, w.pendingPoints)
+		w.pendingPoints = append(w.pendingPoints[:0], w.pendingPoints[batchSize:]...)
+
+		w.lock.Unlock()
+
+		if batchSize == 0 {
+			return nil
+		}
+
+		if err := w.writeBatch(ctx, batch); err != nil {
+			// Put back the batch before points received meanwhile.
+			w.lock.Lock()
+			w.pendingPoints = append(batch, w.pendingPoints...)
+
+			if len(w.pendingPoints) > w.maxPendingPoints {
+				w.pendingPoints = w.pendingPoints[len(w.pendingPoints)-w.maxPendingPoints:]
+			}
+
+			w.lock.Unlock()
+
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// LastWriteSucceeded returns whether the last write to InfluxDB succeeded.
+// It's true until the first write is done.
+func (w *Writer) LastWriteSucceeded() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.lastErr == nil
+}
+
+// PendingPointsCount returns the number of points waiting to be sent to InfluxDB.
+func (w *Writer) PendingPointsCount() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return len(w.pendingPoints)
+}
+
+// HealthCheck perform some health check and logger any issue found.
+func (w *Writer) HealthCheck() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.lastErr != nil {
+		logger.Printf("Unable to send points to InfluxDB since %v: %v", w.lastSuccess.Format(time.RFC3339), w.lastErr)
+	}
+
+	if len(w.pendingPoints) > w.maxBatchSize {
+		logger.Printf("%d points are waiting to be sent to InfluxDB", len(w.pendingPoints))
+	}
+
+	return w.lastErr == nil
+}
+
+// Run send points from the store until ctx is cancelled. Failed writes are retried
+// with an exponential backoff.
+func (w *Writer) Run(ctx context.Context) error {
+	id := w.opts.Store.AddNotifiee(w.addPoints)
+	defer w.opts.Store.RemoveNotifiee(id)
+
+	w.lock.Lock()
+	w.lastSuccess = time.Now()
+	w.lock.Unlock()
+
+	delay := minRetryDelay
+
+	for ctx.Err() == nil {
+		err := w.flush(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+
+		w.lock.Lock()
+
+		if err != nil && w.lastErr == nil {
+			logger.Printf("Unable to send points to InfluxDB (%s): %v", w.writeURL, err)
+		} else if err == nil && w.lastErr != nil {
+			logger.Printf("All waiting points have been sent to InfluxDB")
+		}
+
+		w.lastErr = err
+
+		if err == nil {
+			w.lastSuccess = time.Now()
+			delay = minRetryDelay
+		}
+
+		w.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+
+		if err != nil {
+			delay = time.Duration(math.Min(delay.Seconds()*2, maxRetryDelay.Seconds())) * time.Second
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"context"
+	"glouton/types"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPoints(count int) []types.MetricPoint {
+	points := make([]types.MetricPoint, count)
+
+	for i := range points {
+		points[i] = types.MetricPoint{
+			Labels: map[string]string{
+				types.LabelName: "cpu_used",
+				"item":          "cpu 0",
+			},
+			Point: types.Point{
+				Time:  time.Date(2020, 10, 1, 12, 0, i, 0, time.UTC),
+				Value: 4.2,
+			},
+		}
+	}
+
+	return points
+}
+
+func TestWriterV2(t *testing.T) {
+	var (
+		requests int
+		body     string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.URL.Path != "/api/v2/write" {
+			t.Errorf("path = %s, want /api/v2/write", r.URL.Path)
+		}
+
+		if got := r.URL.Query().Get("bucket"); got != "glouton" {
+			t.Errorf("bucket = %s, want glouton", got)
+		}
+
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization = %s, want Token secret", got)
+		}
+
+		content, _ := ioutil.ReadAll(r.Body)
+		body = string(content)
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer, err := NewWriter(WriterOptions{
+		Output:         OutputV2,
+		URL:            server.URL,
+		Token:          "secret",
+		Org:            "bleemeo",
+		Bucket:         "glouton",
+		AdditionalTags: map[string]string{"hostname": "server01"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer.maxBatchSize = 2
+	writer.addPoints(testPoints(3))
+
+	if err := writer.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+
+	want := "cpu_used,hostname=server01,item=cpu\\ 0 value=4.2 1601553602\n"
+	if body != want {
+		t.Errorf("body = %#v, want %#v", body, want)
+	}
+}
+
+func TestWriterRetry(t *testing.T) {
+	fail := true
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer, err := NewWriter(WriterOptions{Output: OutputHTTP, URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer.addPoints(testPoints(3))
+
+	if err := writer.flush(context.Background()); err == nil {
+		t.Error("flush() succeeded, want an error")
+	}
+
+	if len(writer.pendingPoints) != 3 {
+		t.Errorf("len(pendingPoints) = %d, want 3", len(writer.pendingPoints))
+	}
+
+	fail = false
+
+	if err := writer.flush(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	if len(writer.pendingPoints) != 0 {
+		t.Errorf("len(pendingPoints) = %d, want 0", len(writer.pendingPoints))
+	}
+}
+
+func TestWriterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	writer, err := NewWriter(WriterOptions{Output: OutputUDP, URL: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer.addPoints(testPoints(100))
+
+	if err := writer.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	lineCount := 0
+	buffer := make([]byte, 65536)
+
+	for lineCount < 100 {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n > maxUDPPayload {
+			t.Errorf("datagram size = %d, want at most %d", n, maxUDPPayload)
+		}
+
+		lineCount += strings.Count(string(buffer[:n]), "\n")
+	}
+}
+
+func TestNewWriterUnknownOutput(t *testing.T) {
+	if _, err := NewWriter(WriterOptions{Output: "graphite"}); err == nil {
+		t.Error("NewWriter() succeeded, want an error")
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"strings"
 
 	"glouton/inputs/internal"
+	"glouton/types"
 
 	"github.com/influxdata/telegraf"
 	telegraf_inputs "github.com/influxdata/telegraf/plugins/inputs"
@@ -42,6 +43,20 @@ func New() (i telegraf.Input, err error) {
 			Accumulator: internal.Accumulator{
 				RenameGlobal:     renameGlobal,
 				TransformMetrics: transformMetrics,
+				Units: map[string]string{
+					"cpu_used":       types.UnitPercent,
+					"cpu_other":      types.UnitPercent,
+					"cpu_system":     types.UnitPercent,
+					"cpu_user":       types.UnitPercent,
+					"cpu_idle":       types.UnitPercent,
+					"cpu_nice":       types.UnitPercent,
+					"cpu_wait":       types.UnitPercent,
+					"cpu_interrupt":  types.UnitPercent,
+					"cpu_softirq":    types.UnitPercent,
+					"cpu_steal":      types.UnitPercent,
+					"cpu_guest":      types.UnitPercent,
+					"cpu_guest_nice": types.UnitPercent,
+				},
 			},
 		}
 	} else {
@@ -17,17 +17,23 @@
 package disk
 
 import (
-	"errors"
+	"fmt"
 	"glouton/inputs/internal"
+	"glouton/types"
 	"glouton/version"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/influxdata/telegraf"
-	telegraf_inputs "github.com/influxdata/telegraf/plugins/inputs"
-	"github.com/influxdata/telegraf/plugins/inputs/disk"
+	psDisk "github.com/shirou/gopsutil/disk"
 )
 
+const statusDescriptionTag = "status_description"
+
 type diskTransformer struct {
 	mountPoint string
 	blacklist  []string
@@ -39,30 +45,236 @@ type diskTransformer struct {
 //
 // blacklist is a list of path-prefix to ignore. Path prefix means that "/mnt" and "/mnt/disk" both have "/mnt"
 // as prefix, but "/mnt-disk" does not.
-func New(mountPoint string, blacklist []string) (i telegraf.Input, err error) {
-	input, ok := telegraf_inputs.Inputs["disk"]
+//
+// fsTypeBlacklist and deviceBlacklist are lists of glob patterns (e.g. "fuse.*" or "mapper/*") matching
+// the filesystem type and the device (without "/dev/") to ignore. Ignored filesystems are never queried.
+//
+// timeout is the maximum duration of the query of a filesystem, 0 means no limit. A filesystem which
+// doesn't answer in time (e.g. on a hung NFS server) is reported by the disk_unreachable_status metric
+// and isn't queried again during cooldown.
+func New(mountPoint string, blacklist []string, fsTypeBlacklist []string, deviceBlacklist []string, timeout time.Duration, cooldown time.Duration) (i telegraf.Input, err error) {
+	dt := diskTransformer{
+		strings.TrimRight(mountPoint, "/"),
+		blacklist,
+	}
+	i = &internal.Input{
+		Input: &diskStats{
+			ignoreFS: map[string]bool{
+				"tmpfs": true, "devtmpfs": true, "devfs": true, "overlay": true, "aufs": true, "squashfs": true,
+				// Autofs mounts indicate a potential mount, querying them would trigger the mount.
+				"autofs": true,
+				// FreeBSD pseudo filesystems. nullfs are bind mounts (e.g. for jails) which duplicate another filesystem.
+				"fdescfs": true, "procfs": true, "linprocfs": true, "linsysfs": true, "nullfs": true,
+			},
+			fsTypeBlacklist: fsTypeBlacklist,
+			deviceBlacklist: deviceBlacklist,
+			timeout:         timeout,
+			cooldown:        cooldown,
+			unreachable:     make(map[string]time.Time),
+			pending:         make(map[string]bool),
+		},
+		Accumulator: internal.Accumulator{
+			RenameGlobal:     dt.renameGlobal,
+			TransformMetrics: dt.transformMetrics,
+			Units: map[string]string{
+				"disk_used":      types.UnitBytes,
+				"disk_free":      types.UnitBytes,
+				"disk_total":     types.UnitBytes,
+				"disk_used_perc": types.UnitPercent,
+			},
+		},
+	}
 
-	if ok {
-		diskInput := input().(*disk.DiskStats)
-		diskInput.IgnoreFS = []string{
-			"tmpfs", "devtmpfs", "devfs", "overlay", "aufs", "squashfs",
+	return
+}
+
+// diskStats gathers the usage of filesystems. It's similar to the Telegraf disk input, but it
+// queries each filesystem in its own goroutine, so an unresponsive filesystem doesn't block the
+// collection.
+type diskStats struct {
+	ignoreFS        map[string]bool
+	fsTypeBlacklist []string
+	deviceBlacklist []string
+	timeout         time.Duration
+	cooldown        time.Duration
+
+	l sync.Mutex
+	// unreachable contains the time until which a mount point isn't queried.
+	unreachable map[string]time.Time
+	// pending contains the mount points whose query is still running.
+	pending map[string]bool
+}
+
+type usageResult struct {
+	partition psDisk.PartitionStat
+	usage     *psDisk.UsageStat
+	err       error
+}
+
+// Description returns a one-sentence description of the input.
+func (d *diskStats) Description() string {
+	return "Read metrics about disk usage by mount point"
+}
+
+// SampleConfig returns the default configuration of the input.
+func (d *diskStats) SampleConfig() string {
+	return ""
+}
+
+// Gather queries the filesystems and sends their usage and reachability.
+func (d *diskStats) Gather(acc telegraf.Accumulator) error {
+	partitions, err := psDisk.Partitions(true)
+	if err != nil {
+		return fmt.Errorf("error getting disk partitions: %v", err)
+	}
+
+	now := time.Now()
+	results := make(chan usageResult, len(partitions))
+	queried := make(map[string]psDisk.PartitionStat)
+
+	d.l.Lock()
+
+	for _, p := range partitions {
+		if d.ignored(p) {
+			continue
 		}
-		dt := diskTransformer{
-			strings.TrimRight(mountPoint, "/"),
-			blacklist,
+
+		if _, ok := queried[p.Mountpoint]; ok {
+			continue
 		}
-		i = &internal.Input{
-			Input: diskInput,
-			Accumulator: internal.Accumulator{
-				RenameGlobal:     dt.renameGlobal,
-				TransformMetrics: dt.transformMetrics,
-			},
+
+		if until, ok := d.unreachable[p.Mountpoint]; d.pending[p.Mountpoint] || ok && now.Before(until) {
+			addStatus(acc, p, types.StatusCritical, "The filesystem isn't queried since it didn't answer in time")
+			continue
 		}
-	} else {
-		err = errors.New("input disk not enabled in Telegraf")
+
+		delete(d.unreachable, p.Mountpoint)
+
+		d.pending[p.Mountpoint] = true
+		queried[p.Mountpoint] = p
+
+		go func(p psDisk.PartitionStat) {
+			usage, err := psDisk.Usage(p.Mountpoint)
+
+			d.l.Lock()
+			delete(d.pending, p.Mountpoint)
+			d.l.Unlock()
+
+			results <- usageResult{partition: p, usage: usage, err: err}
+		}(p)
 	}
 
-	return
+	d.l.Unlock()
+
+	var deadline <-chan time.Time
+
+	if d.timeout > 0 {
+		timer := time.NewTimer(d.timeout)
+		defer timer.Stop()
+
+		deadline = timer.C
+	}
+
+	for len(queried) > 0 {
+		select {
+		case r := <-results:
+			delete(queried, r.partition.Mountpoint)
+
+			if r.err != nil || r.usage.Total == 0 {
+				// Skip dummy filesystem (procfs, cgroupfs, ...)
+				continue
+			}
+
+			addUsage(acc, r.partition, r.usage)
+			addStatus(acc, r.partition, types.StatusOk, "")
+		case <-deadline:
+			d.l.Lock()
+
+			for mountPoint, p := range queried {
+				d.unreachable[mountPoint] = now.Add(d.cooldown)
+
+				addStatus(acc, p, types.StatusCritical, fmt.Sprintf("The filesystem didn't answer within %v", d.timeout))
+			}
+
+			d.l.Unlock()
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (d *diskStats) ignored(p psDisk.PartitionStat) bool {
+	return d.ignoreFS[p.Fstype] || matchAny(d.fsTypeBlacklist, p.Fstype) || matchAny(d.deviceBlacklist, deviceName(p))
+}
+
+func deviceName(p psDisk.PartitionStat) string {
+	return strings.Replace(p.Device, "/dev/", "", -1)
+}
+
+func partitionTags(p psDisk.PartitionStat) map[string]string {
+	return map[string]string{
+		"path":   filepath.Join("/", p.Mountpoint),
+		"device": deviceName(p),
+		"fstype": p.Fstype,
+		"mode":   mountMode(p.Opts),
+	}
+}
+
+func addUsage(acc telegraf.Accumulator, p psDisk.PartitionStat, du *psDisk.UsageStat) {
+	var usedPercent float64
+
+	if du.Used+du.Free > 0 {
+		usedPercent = float64(du.Used) / (float64(du.Used) + float64(du.Free)) * 100
+	}
+
+	fields := map[string]interface{}{
+		"total":        du.Total,
+		"free":         du.Free,
+		"used":         du.Used,
+		"used_percent": usedPercent,
+		"inodes_total": du.InodesTotal,
+		"inodes_free":  du.InodesFree,
+		"inodes_used":  du.InodesUsed,
+	}
+
+	acc.AddGauge("disk", fields, partitionTags(p))
+}
+
+// addStatus sends the disk_unreachable_status metric, the renameGlobal of the transformer converts
+// the description tag to an annotation.
+func addStatus(acc telegraf.Accumulator, p psDisk.PartitionStat, status types.Status, description string) {
+	tags := partitionTags(p)
+	tags[statusDescriptionTag] = description
+
+	acc.AddGauge("disk", map[string]interface{}{"unreachable_status": status.NagiosCode()}, tags)
+}
+
+func mountMode(opts string) string {
+	mode := "unknown"
+
+	for _, o := range strings.Split(opts, ",") {
+		switch o {
+		case "rw":
+			return "rw"
+		case "ro":
+			mode = "ro"
+		}
+	}
+
+	return mode
+}
+
+// matchAny returns whether value matches one of the glob patterns.
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (dt diskTransformer) renameGlobal(originalContext internal.GatherContext) (newContext internal.GatherContext, drop bool) {
@@ -104,6 +316,13 @@ func (dt diskTransformer) renameGlobal(originalContext internal.GatherContext) (
 	newContext.Annotations.BleemeoItem = item
 	newContext.Tags["mountpoint"] = item
 
+	if status, ok := originalContext.OriginalFields["unreachable_status"].(int); ok {
+		newContext.Annotations.Status = types.StatusDescription{
+			CurrentStatus:     types.FromNagios(status),
+			StatusDescription: originalContext.Tags[statusDescriptionTag],
+		}
+	}
+
 	return newContext, drop
 }
 
@@ -0,0 +1,86 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"glouton/inputs/internal"
+	"glouton/types"
+	"testing"
+)
+
+func TestMatchAny(t *testing.T) {
+	patterns := []string{"nfs", "nfs4", "fuse.*", "mapper/*"}
+
+	cases := []struct {
+		value string
+		want  bool
+	}{
+		{"nfs", true},
+		{"nfs4", true},
+		{"nfsd", false},
+		{"fuse.sshfs", true},
+		{"fuseblk", false},
+		{"mapper/vg-root", true},
+		{"sda1", false},
+		{"ext4", false},
+	}
+
+	for _, c := range cases {
+		if got := matchAny(patterns, c.value); got != c.want {
+			t.Errorf("matchAny(%#v) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestMountMode(t *testing.T) {
+	cases := map[string]string{
+		"rw,relatime":        "rw",
+		"ro,nosuid,nodev":    "ro",
+		"relatime,errors=rw": "unknown",
+		"":                   "unknown",
+	}
+
+	for opts, want := range cases {
+		if got := mountMode(opts); got != want {
+			t.Errorf("mountMode(%#v) = %#v, want %#v", opts, got, want)
+		}
+	}
+}
+
+func TestRenameGlobalStatus(t *testing.T) {
+	dt := diskTransformer{mountPoint: "/hostroot"}
+
+	ctx, drop := dt.renameGlobal(internal.GatherContext{
+		Measurement: "disk",
+		Tags: map[string]string{
+			"path":               "/hostroot/mnt/nfs",
+			statusDescriptionTag: "The filesystem didn't answer within 10s",
+		},
+		OriginalFields: map[string]interface{}{"unreachable_status": types.StatusCritical.NagiosCode()},
+	})
+	if drop {
+		t.Fatal("renameGlobal() dropped the status")
+	}
+
+	if ctx.Annotations.Status.CurrentStatus != types.StatusCritical {
+		t.Errorf("CurrentStatus = %v, want %v", ctx.Annotations.Status.CurrentStatus, types.StatusCritical)
+	}
+
+	if ctx.Tags["mountpoint"] != "/mnt/nfs" || len(ctx.Tags) != 1 {
+		t.Errorf("Tags = %v, want only mountpoint=/mnt/nfs", ctx.Tags)
+	}
+}
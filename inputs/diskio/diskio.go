@@ -19,6 +19,7 @@ package diskio
 import (
 	"errors"
 	"glouton/inputs/internal"
+	"glouton/types"
 	"glouton/version"
 	"regexp"
 
@@ -52,8 +53,13 @@ func New(whitelist []*regexp.Regexp, blacklist []*regexp.Regexp) (i telegraf.Inp
 			Input: diskioInput,
 			Accumulator: internal.Accumulator{
 				RenameGlobal:     dt.renameGlobal,
-				DerivatedMetrics: []string{"read_bytes", "read_time", "reads", "write_bytes", "writes", "write_time", "io_time"},
+				DerivatedMetrics: []string{"read_bytes", "read_time", "reads", "write_bytes", "writes", "write_time", "io_time", "weighted_io_time"},
 				TransformMetrics: dt.transformMetrics,
+				Units: map[string]string{
+					"io_read_bytes":  types.UnitBytes,
+					"io_write_bytes": types.UnitBytes,
+					"io_utilization": types.UnitPercent,
+				},
 			},
 		}
 	} else {
@@ -111,10 +117,13 @@ func (dt diskIOTransformer) transformMetrics(originalContext internal.GatherCont
 		fields["utilization"] = ioTime / 1000. * 100.
 	}
 
+	addLatency(fields)
+
 	// win_perf_counters will report io_time and io_utilization on windows
 	if version.IsWindows() {
 		delete(fields, "time")
 		delete(fields, "utilization")
+		delete(fields, "queue_depth")
 	}
 
 	delete(fields, "weighted_io_time")
@@ -122,3 +131,40 @@ func (dt diskIOTransformer) transformMetrics(originalContext internal.GatherCont
 
 	return fields
 }
+
+// addLatency adds the average latency of the IOs completed during the last interval,
+// in milliseconds, and the average queue depth.
+// The time fields are the milliseconds spent doing IO per second and the count fields
+// are the number of IO per second, so their ratio is the time per IO.
+func addLatency(fields map[string]float64) {
+	readTime, hasReadTime := fields["read_time"]
+	reads, hasReads := fields["reads"]
+	writeTime, hasWriteTime := fields["write_time"]
+	writes, hasWrites := fields["writes"]
+
+	if hasReadTime && hasReads {
+		fields["read_latency"] = latency(readTime, reads)
+	}
+
+	if hasWriteTime && hasWrites {
+		fields["write_latency"] = latency(writeTime, writes)
+	}
+
+	if hasReadTime && hasReads && hasWriteTime && hasWrites {
+		fields["latency"] = latency(readTime+writeTime, reads+writes)
+	}
+
+	// weighted_io_time is the sum of the time each in-flight IO was waiting or
+	// being served. Per second of wall time, it's the average number of IO in the queue.
+	if weightedTime, ok := fields["weighted_io_time"]; ok {
+		fields["queue_depth"] = weightedTime / 1000.
+	}
+}
+
+func latency(timeSpent float64, count float64) float64 {
+	if count <= 0 {
+		return 0
+	}
+
+	return timeSpent / count
+}
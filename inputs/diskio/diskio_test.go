@@ -0,0 +1,50 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddLatency(t *testing.T) {
+	fields := map[string]float64{
+		"reads":            100,
+		"read_time":        200,
+		"writes":           0,
+		"write_time":       0,
+		"weighted_io_time": 1500,
+	}
+
+	addLatency(fields)
+
+	want := map[string]float64{
+		"reads":            100,
+		"read_time":        200,
+		"writes":           0,
+		"write_time":       0,
+		"weighted_io_time": 1500,
+		"read_latency":     2,
+		"write_latency":    0,
+		"latency":          2,
+		"queue_depth":      1.5,
+	}
+
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("addLatency() = %v, want %v", fields, want)
+	}
+}
@@ -29,7 +29,9 @@ import (
 )
 
 // New initialise docker.Input.
-func New() (i telegraf.Input, err error) {
+//
+// Metrics of ephemeral containers are summed in containers_ephemeral_* metrics.
+func New(churn ContainerChurn) (i telegraf.Input, err error) {
 	var input, ok = telegraf_inputs.Inputs["docker"]
 	if ok {
 		dockerInput, ok := input().(*docker.Docker)
@@ -37,13 +39,16 @@ func New() (i telegraf.Input, err error) {
 			dockerInput.PerDevice = false
 			dockerInput.Total = true
 			dockerInput.Log = internal.Logger{}
-			i = &internal.Input{
-				Input: dockerInput,
-				Accumulator: internal.Accumulator{
-					RenameGlobal:     renameGlobal,
-					DerivatedMetrics: []string{"usage_total", "rx_bytes", "tx_bytes", "io_service_bytes_recursive_read", "io_service_bytes_recursive_write"},
-					TransformMetrics: transformMetrics,
+			i = churnInput{
+				Input: &internal.Input{
+					Input: dockerInput,
+					Accumulator: internal.Accumulator{
+						RenameGlobal:     renameGlobal,
+						DerivatedMetrics: []string{"usage_total", "rx_bytes", "tx_bytes", "io_service_bytes_recursive_read", "io_service_bytes_recursive_write"},
+						TransformMetrics: transformMetrics,
+					},
 				},
+				churn: churn,
 			}
 		} else {
 			err = errors.New("input Docker is not the expected type")
@@ -0,0 +1,119 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"glouton/inputs"
+	"glouton/inputs/internal"
+	"glouton/types"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+const ephemeralMeasurement = "containers_ephemeral"
+
+// ephemeralAggregated are the metrics of ephemeral containers which are summed. Percentages
+// can't be summed and are dropped.
+var ephemeralAggregated = map[string]bool{
+	"cpu_used":       true,
+	"mem_used":       true,
+	"net_bits_recv":  true,
+	"net_bits_sent":  true,
+	"io_read_bytes":  true,
+	"io_write_bytes": true,
+}
+
+// ContainerChurn provides the churn state of the containers, see facts.DockerProvider.
+type ContainerChurn interface {
+	ContainerChurn() (createdLastHour int, churning bool)
+	ContainerEphemeral(containerID string) bool
+}
+
+// churnInput sums the metrics of ephemeral containers instead of emitting them per container.
+type churnInput struct {
+	*internal.Input
+	churn ContainerChurn
+}
+
+// Gather implements telegraf.Input.
+func (i churnInput) Gather(acc telegraf.Accumulator) error {
+	ephemeralAcc := &ephemeralAccumulator{
+		Accumulator: acc,
+		churn:       i.churn,
+		containers:  make(map[string]bool),
+		sums:        make(map[string]float64),
+	}
+
+	err := i.Input.Gather(ephemeralAcc)
+
+	ephemeralAcc.flush()
+
+	return err
+}
+
+// ephemeralAccumulator forwards points of non-ephemeral containers and sums the others.
+type ephemeralAccumulator struct {
+	telegraf.Accumulator
+	churn ContainerChurn
+
+	containers map[string]bool
+	sums       map[string]float64
+}
+
+// AddFieldsWithAnnotations implements inputs.AnnotationAccumulator.
+func (a *ephemeralAccumulator) AddFieldsWithAnnotations(measurement string, fields map[string]interface{}, tags map[string]string, annotations types.MetricAnnotations, t ...time.Time) {
+	if annotations.ContainerID == "" || !a.churn.ContainerEphemeral(annotations.ContainerID) {
+		if annotationAcc, ok := a.Accumulator.(inputs.AnnotationAccumulator); ok {
+			annotationAcc.AddFieldsWithAnnotations(measurement, fields, tags, annotations, t...)
+		} else {
+			a.Accumulator.AddFields(measurement, fields, tags, t...)
+		}
+
+		return
+	}
+
+	a.containers[annotations.ContainerID] = true
+
+	for name, value := range fields {
+		name = strings.TrimPrefix(measurement, "docker_container_") + "_" + name
+
+		if v, ok := value.(float64); ok && ephemeralAggregated[name] {
+			a.sums[name] += v
+		}
+	}
+}
+
+// flush emits the aggregated metrics, only while the host is churning or ephemeral containers are still running.
+func (a *ephemeralAccumulator) flush() {
+	createdLastHour, churning := a.churn.ContainerChurn()
+	if !churning && len(a.containers) == 0 {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"count":             float64(len(a.containers)),
+		"created_last_hour": float64(createdLastHour),
+	}
+
+	for name, value := range a.sums {
+		fields[name] = value
+	}
+
+	a.Accumulator.AddFields(ephemeralMeasurement, fields, nil)
+}
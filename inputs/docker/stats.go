@@ -0,0 +1,344 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"glouton/facts"
+	"glouton/inputs/internal"
+	"glouton/logger"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/influxdata/telegraf"
+)
+
+// StatsProvider provides the containers and their statistics stream, see facts.DockerProvider.
+type StatsProvider interface {
+	ContainerChurn
+	Containers(ctx context.Context, maxAge time.Duration, includeIgnored bool) (containers []facts.Container, err error)
+	ContainerStats(ctx context.Context, containerID string) (io.ReadCloser, error)
+}
+
+// NewNative initialise an input using the Docker stats API instead of the Telegraf Docker input.
+//
+// A statistics stream is kept open for each running container, a gather only use the last
+// statistics received. It produces the same metrics as the input returned by New.
+func NewNative(provider StatsProvider) telegraf.Input {
+	return churnInput{
+		Input: &internal.Input{
+			Input: &statsInput{
+				provider: provider,
+				streams:  make(map[string]*statsStream),
+			},
+			Accumulator: internal.Accumulator{
+				RenameGlobal:     renameGlobal,
+				DerivatedMetrics: []string{"usage_total", "rx_bytes", "tx_bytes", "io_service_bytes_recursive_read", "io_service_bytes_recursive_write"},
+				TransformMetrics: transformMetrics,
+			},
+		},
+		churn: provider,
+	}
+}
+
+type statsInput struct {
+	provider StatsProvider
+
+	l       sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	streams map[string]*statsStream
+}
+
+// statsStream is the statistics stream of one container.
+type statsStream struct {
+	name   string
+	cancel context.CancelFunc
+
+	l    sync.Mutex
+	last *types.StatsJSON
+	done bool
+
+	// emitted is the read time of the last statistics emitted. Statistics are emitted once,
+	// the rate of counters can't be computed from two points with the same time.
+	emitted time.Time
+}
+
+// SampleConfig returns the default configuration of the Input.
+func (s *statsInput) SampleConfig() string {
+	return ""
+}
+
+// Description returns a one-sentence description on the Input.
+func (s *statsInput) Description() string {
+	return "Read metrics about containers from the Docker stats API"
+}
+
+// Start implements telegraf.ServiceInput.
+func (s *statsInput) Start(telegraf.Accumulator) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	return nil
+}
+
+// Stop closes all statistics streams.
+func (s *statsInput) Stop() {
+	s.l.Lock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	s.streams = make(map[string]*statsStream)
+
+	s.l.Unlock()
+
+	s.wg.Wait()
+}
+
+// Gather opens the streams of new containers, closes the ones of deleted containers and
+// emits the last statistics of each container.
+func (s *statsInput) Gather(acc telegraf.Accumulator) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+
+	// Streams keep the containers up-to-date, the list is only used to find new containers.
+	containers, err := s.provider.Containers(s.ctx, time.Minute, false)
+	if err != nil {
+		return err
+	}
+
+	running := make(map[string]bool, len(containers))
+
+	for _, c := range containers {
+		if !c.IsRunning() {
+			continue
+		}
+
+		running[c.ID()] = true
+
+		if stream, ok := s.streams[c.ID()]; ok && !stream.isDone() {
+			continue
+		}
+
+		s.open(c.ID(), c.Name())
+	}
+
+	for id, stream := range s.streams {
+		if !running[id] {
+			stream.cancel()
+			delete(s.streams, id)
+		}
+	}
+
+	acc.AddFields("docker", map[string]interface{}{"n_containers": len(running)}, nil)
+
+	for id, stream := range s.streams {
+		stream.l.Lock()
+
+		stats := stream.last
+		if stats != nil && stats.Read.Unix() > stream.emitted.Unix() {
+			stream.emitted = stats.Read
+		} else {
+			stats = nil
+		}
+
+		stream.l.Unlock()
+
+		if stats != nil {
+			addStats(acc, id, stream.name, stats)
+		}
+	}
+
+	return nil
+}
+
+func (s *statsInput) open(containerID string, name string) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	stream := &statsStream{
+		name:   name,
+		cancel: cancel,
+	}
+
+	s.streams[containerID] = stream
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+
+		err := stream.run(ctx, s.provider, containerID)
+		if err != nil && ctx.Err() == nil {
+			logger.V(2).Printf("Statistics stream of container %s stopped: %v", name, err)
+		}
+	}()
+}
+
+func (st *statsStream) isDone() bool {
+	st.l.Lock()
+	defer st.l.Unlock()
+
+	return st.done
+}
+
+// run reads the statistics until the stream is closed.
+func (st *statsStream) run(ctx context.Context, provider StatsProvider, containerID string) error {
+	defer func() {
+		st.l.Lock()
+		st.done = true
+		st.l.Unlock()
+	}()
+
+	body, err := provider.ContainerStats(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	decoder := json.NewDecoder(body)
+
+	for ctx.Err() == nil {
+		var stats types.StatsJSON
+
+		if err := decoder.Decode(&stats); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		st.l.Lock()
+		st.last = &stats
+		st.l.Unlock()
+	}
+
+	return nil
+}
+
+// addStats emits the statistics with the same measurements, fields and tags as the Telegraf Docker input.
+func addStats(acc telegraf.Accumulator, containerID string, name string, stats *types.StatsJSON) {
+	tags := map[string]string{
+		"container_name": name,
+	}
+	withTags := func(extra map[string]string) map[string]string {
+		result := make(map[string]string, len(tags)+len(extra))
+
+		for k, v := range tags {
+			result[k] = v
+		}
+
+		for k, v := range extra {
+			result[k] = v
+		}
+
+		return result
+	}
+
+	acc.AddFields(
+		"docker_container_cpu",
+		map[string]interface{}{
+			"usage_total":  stats.CPUStats.CPUUsage.TotalUsage,
+			"container_id": containerID,
+		},
+		withTags(map[string]string{"cpu": "cpu-total"}),
+		stats.Read,
+	)
+
+	memFields := map[string]interface{}{
+		"usage":        memoryUsed(stats.MemoryStats),
+		"container_id": containerID,
+	}
+
+	if stats.MemoryStats.Limit > 0 {
+		memFields["usage_percent"] = float64(memoryUsed(stats.MemoryStats)) / float64(stats.MemoryStats.Limit) * 100
+	}
+
+	acc.AddFields("docker_container_mem", memFields, withTags(nil), stats.Read)
+
+	if len(stats.Networks) > 0 {
+		var rx, tx uint64
+
+		for _, network := range stats.Networks {
+			rx += network.RxBytes
+			tx += network.TxBytes
+		}
+
+		acc.AddFields(
+			"docker_container_net",
+			map[string]interface{}{
+				"rx_bytes":     rx,
+				"tx_bytes":     tx,
+				"container_id": containerID,
+			},
+			withTags(map[string]string{"network": "total"}),
+			stats.Read,
+		)
+	}
+
+	if len(stats.BlkioStats.IoServiceBytesRecursive) > 0 {
+		var read, write uint64
+
+		for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+			switch strings.ToLower(entry.Op) {
+			case "read":
+				read += entry.Value
+			case "write":
+				write += entry.Value
+			}
+		}
+
+		acc.AddFields(
+			"docker_container_blkio",
+			map[string]interface{}{
+				"io_service_bytes_recursive_read":  read,
+				"io_service_bytes_recursive_write": write,
+				"container_id":                     containerID,
+			},
+			withTags(map[string]string{"device": "total"}),
+			stats.Read,
+		)
+	}
+}
+
+// memoryUsed returns the memory used by the container without the page cache, like "docker stats" does.
+func memoryUsed(mem types.MemoryStats) uint64 {
+	// cgroup v1 uses total_inactive_file, cgroup v2 uses inactive_file.
+	cache, ok := mem.Stats["total_inactive_file"]
+	if !ok {
+		cache = mem.Stats["inactive_file"]
+	}
+
+	if cache > mem.Usage {
+		return 0
+	}
+
+	return mem.Usage - cache
+}
@@ -0,0 +1,106 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"glouton/inputs"
+	"glouton/inputs/internal"
+	"glouton/types"
+	"testing"
+	"time"
+
+	dockerTypes "github.com/docker/docker/api/types"
+)
+
+type pointsRecorder struct {
+	points map[string]types.MetricPoint
+}
+
+func (r *pointsRecorder) PushPoints(points []types.MetricPoint) {
+	for _, p := range points {
+		r.points[p.Labels[types.LabelName]] = p
+	}
+}
+
+func statsAt(t0 time.Time, delay time.Duration) *dockerTypes.StatsJSON {
+	seconds := uint64(delay / time.Second)
+
+	stats := &dockerTypes.StatsJSON{
+		Networks: map[string]dockerTypes.NetworkStats{
+			"eth0": {RxBytes: 1000 * seconds, TxBytes: 100 * seconds},
+			"eth1": {RxBytes: 1000 * seconds},
+		},
+	}
+
+	stats.Read = t0.Add(delay)
+	stats.CPUStats.CPUUsage.TotalUsage = uint64(delay / 2)
+	stats.MemoryStats = dockerTypes.MemoryStats{
+		Usage: 600,
+		Limit: 1000,
+		Stats: map[string]uint64{"total_inactive_file": 100},
+	}
+	stats.BlkioStats.IoServiceBytesRecursive = []dockerTypes.BlkioStatEntry{
+		{Major: 8, Op: "Read", Value: 10 * seconds},
+		{Major: 8, Op: "Write", Value: 20 * seconds},
+		{Major: 8, Op: "Total", Value: 30 * seconds},
+	}
+
+	return stats
+}
+
+func TestAddStats(t *testing.T) {
+	recorder := &pointsRecorder{points: make(map[string]types.MetricPoint)}
+	acc := &internal.Accumulator{
+		Accumulator:      &inputs.Accumulator{Pusher: recorder},
+		RenameGlobal:     renameGlobal,
+		DerivatedMetrics: []string{"usage_total", "rx_bytes", "tx_bytes", "io_service_bytes_recursive_read", "io_service_bytes_recursive_write"},
+		TransformMetrics: transformMetrics,
+	}
+	t0 := time.Now().Truncate(time.Second)
+
+	acc.PrepareGather()
+	addStats(acc, "1234", "web", statsAt(t0, 0))
+	acc.PrepareGather()
+	addStats(acc, "1234", "web", statsAt(t0, 10*time.Second))
+
+	want := map[string]float64{
+		"docker_container_cpu_used":       50,
+		"docker_container_mem_used":       500,
+		"docker_container_mem_used_perc":  50,
+		"docker_container_net_bits_recv":  16000,
+		"docker_container_net_bits_sent":  800,
+		"docker_container_io_read_bytes":  10,
+		"docker_container_io_write_bytes": 20,
+	}
+
+	for name, value := range want {
+		p, ok := recorder.points[name]
+		if !ok {
+			t.Errorf("metric %s is missing, got %v", name, recorder.points)
+
+			continue
+		}
+
+		if p.Value != value {
+			t.Errorf("%s = %v, want %v", name, p.Value, value)
+		}
+
+		if p.Labels[types.LabelMetaContainerName] != "web" || p.Annotations.ContainerID != "1234" {
+			t.Errorf("%s labels = %v, annotations = %v, want the container", name, p.Labels, p.Annotations)
+		}
+	}
+}
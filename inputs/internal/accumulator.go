@@ -18,6 +18,7 @@ package internal
 
 import (
 	"fmt"
+	"glouton/derive"
 	"glouton/inputs"
 	"glouton/logger"
 	"glouton/types"
@@ -30,11 +31,6 @@ import (
 	"github.com/influxdata/telegraf"
 )
 
-type metricPoint struct {
-	Value interface{} // could be uint64 or int64
-	Time  time.Time
-}
-
 // GatherContext is the couple Measurement and tags.
 type GatherContext struct {
 	Measurement    string
@@ -56,7 +52,8 @@ type RenameCallback func(labels map[string]string, annotations types.MetricAnnot
 // * If TransformGlobal is set, it's applied. RenameTransform allow to rename measurement and alter tags. It could also completly drop
 //   a batch of metrics
 // * Any metrics matching DerivatedMetrics are derivated. Metric seen for the first time are dropped.
-//   Derivation is only applied to Counter values, that is something that only go upward. If value does downward, it's skipped.
+//   Derivation is only applied to Counter values, that is something that only go upward. If value does downward, it's skipped
+//   unless Derivation.WrapAt is set.
 // * Then TransformMetrics is called on a float64 version of fields. It may apply per-metric transformation.
 type Accumulator struct {
 	Accumulator telegraf.Accumulator
@@ -75,6 +72,10 @@ type Accumulator struct {
 	// If both ShouldDerivateMetrics and DerivatedMetrics are set, only metrics not found in DerivatedMetrics are passed to ShouldDerivateMetrics
 	ShouldDerivateMetrics func(originalContext GatherContext, currentContext GatherContext, metricName string) bool
 
+	// Derivation configures how derivated metrics are computed. The zero value computes a rate
+	// and skips the points where the counter went down.
+	Derivation derive.Config
+
 	// TransformMetrics take a list of metrics and could change the name/value or even add/delete some points.
 	// tags & measurement are given as indication and should not be mutated.
 	TransformMetrics func(originalContext GatherContext, currentContext GatherContext, fields map[string]float64, originalFields map[string]interface{}) map[string]float64
@@ -84,16 +85,24 @@ type Accumulator struct {
 
 	RenameCallbacks []RenameCallback
 
-	// map a flattened tags to a map[fieldName]value
-	currentValues map[string]map[string]metricPoint
-	pastValues    map[string]map[string]metricPoint
-	l             sync.Mutex
+	// Units maps the final metric name ("<measurement>_<field>") to its unit (one of types.Unit*).
+	// The unit is added to the annotations of the metric.
+	Units map[string]string
+
+	deriver *derive.Deriver
+	l       sync.Mutex
 }
 
 // PrepareGather should be called before each gather. It's mainly useful for delta computation.
 func (a *Accumulator) PrepareGather() {
-	a.pastValues = a.currentValues
-	a.currentValues = make(map[string]map[string]metricPoint)
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if a.deriver == nil {
+		a.deriver = derive.New()
+	}
+
+	a.deriver.Prepare()
 }
 
 // convertToFloat convert the interface type in float64.
@@ -123,40 +132,6 @@ func convertToFloat(value interface{}) (valueFloat float64, err error) {
 	return
 }
 
-// rateAsFloat compute the delta/duration between two points.
-func rateAsFloat(pastPoint, currentPoint metricPoint) (value float64, err error) {
-	switch pastValue := pastPoint.Value.(type) {
-	case uint64:
-		// Special case here. If pastPoint if bigger that currentPoint, the unsigned int will overflow.
-		currentValue := currentPoint.Value.(uint64)
-		if pastValue > currentValue {
-			value = -float64(pastValue - currentValue)
-		} else {
-			value = float64(currentValue - pastValue)
-		}
-	case int:
-		value = float64(currentPoint.Value.(int) - pastValue)
-	case int64:
-		value = float64(currentPoint.Value.(int64) - pastValue)
-	default:
-		pastValueFloat, err := convertToFloat(pastPoint.Value)
-		if err != nil {
-			return 0.0, err
-		}
-
-		currentValue, err := convertToFloat(currentPoint.Value)
-		if err != nil {
-			return 0.0, err
-		}
-
-		value = currentValue - pastValueFloat
-	}
-
-	value /= float64(currentPoint.Time.Unix() - pastPoint.Time.Unix())
-
-	return value, err
-}
-
 func flattenTag(tags map[string]string) string {
 	tagsList := make([]string, 0, len(tags))
 
@@ -181,12 +156,12 @@ func (a *Accumulator) applyDerivate(originalContext GatherContext, currentContex
 		searchMetrics[m] = true
 	}
 
-	flatTag := flattenTag(currentContext.Tags)
-
-	if _, ok := a.currentValues[flatTag]; !ok {
-		a.currentValues[flatTag] = make(map[string]metricPoint)
+	if a.deriver == nil {
+		a.deriver = derive.New()
 	}
 
+	flatTag := flattenTag(currentContext.Tags)
+
 	for metricName, value := range fields {
 		if _, ok := value.(string); ok {
 			// we ignore string without error
@@ -214,24 +189,16 @@ func (a *Accumulator) applyDerivate(originalContext GatherContext, currentContex
 			continue
 		}
 
-		pastMetricPoint, ok := a.pastValues[flatTag][metricName]
-		currentPoint := metricPoint{Time: metricTime, Value: value}
-		a.currentValues[flatTag][metricName] = currentPoint
-
-		if ok {
-			valueFloat, err := rateAsFloat(pastMetricPoint, currentPoint)
+		valueFloat, ok, err := a.deriver.Derive(flatTag+"|"+metricName, a.Derivation, value, metricTime)
+		if err != nil {
+			a.AddError(err)
 
-			switch {
-			case err == nil && valueFloat >= 0:
-				result[metricName] = valueFloat
-			case err == nil:
-				continue
-			default:
-				a.AddError(err)
-			}
-		} else {
 			continue
 		}
+
+		if ok {
+			result[metricName] = valueFloat
+		}
 	}
 
 	return result
@@ -299,8 +266,42 @@ func (a *Accumulator) processMetrics(finalFunc accumulatorFunc, measurement stri
 	}
 
 	for measurementName, fields := range fieldsPerMeasurements {
-		finalFunc(measurementName, fields, currentContext.Tags, currentContext.Annotations, metricTime)
+		if len(a.Units) == 0 {
+			finalFunc(measurementName, fields, currentContext.Tags, currentContext.Annotations, metricTime)
+
+			continue
+		}
+
+		for unit, unitFields := range a.splitByUnit(measurementName, fields) {
+			annotations := currentContext.Annotations
+			annotations.Unit = unit
+
+			finalFunc(measurementName, unitFields, currentContext.Tags, annotations, metricTime)
+		}
+	}
+}
+
+// splitByUnit groups the fields of a measurement by the unit of the resulting metric.
+// Annotations apply to a whole batch, so fields with different units must be added separately.
+func (a *Accumulator) splitByUnit(measurement string, fields map[string]interface{}) map[string]map[string]interface{} {
+	result := make(map[string]map[string]interface{})
+
+	for name, value := range fields {
+		metricName := name
+		if measurement != "" {
+			metricName = measurement + "_" + name
+		}
+
+		unit := a.Units[metricName]
+
+		if _, ok := result[unit]; !ok {
+			result[unit] = make(map[string]interface{})
+		}
+
+		result[unit][name] = value
 	}
+
+	return result
 }
 
 // wrapAdd return an Add* method that support annotation. If the backend accumulator does not support annotation, discard them and use fallbackMethod.
@@ -666,3 +666,39 @@ func TestLabelsMutation(t *testing.T) {
 		t.Errorf("called == %v, want 1", called)
 	}
 }
+
+func TestUnits(t *testing.T) {
+	got := make(map[string]string)
+	finalFunc := func(measurement string, fields map[string]interface{}, tags map[string]string, annotations types.MetricAnnotations, t_ ...time.Time) {
+		for name := range fields {
+			got[measurement+"_"+name] = annotations.Unit
+		}
+	}
+	acc := Accumulator{
+		Units: map[string]string{
+			"mem_used":      types.UnitBytes,
+			"mem_used_perc": types.UnitPercent,
+		},
+	}
+	acc.PrepareGather()
+	acc.processMetrics(
+		finalFunc,
+		"mem",
+		map[string]interface{}{
+			"used":      uint64(1024),
+			"used_perc": 12.5,
+			"other":     1,
+		},
+		map[string]string{},
+	)
+
+	want := map[string]string{
+		"mem_used":      types.UnitBytes,
+		"mem_used_perc": types.UnitPercent,
+		"mem_other":     "",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("units = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipmi reports the sensors readings and the system event log (SEL) of the BMC.
+//
+// Values are read with ipmitool, run with "sudo -n" when Glouton isn't running as root.
+package ipmi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sensorClasses maps the unit of threshold-based sensors to their class. Discrete sensors aren't reported.
+//
+//nolint:gochecknoglobals
+var sensorClasses = map[string]string{
+	"degrees C": "temperature",
+	"RPM":       "fan_speed",
+	"Volts":     "voltage",
+	"Watts":     "power",
+	"Amps":      "current",
+}
+
+// Sensor is a threshold-based sensor of the BMC.
+type Sensor struct {
+	Name   string
+	Class  string
+	Unit   string
+	Value  float64
+	Status types.Status
+}
+
+// SELInfo is the usage of the system event log.
+type SELInfo struct {
+	Entries     int
+	UsedPercent float64
+}
+
+// minInterval is the minimal delay between two queries of the BMC, which are slow.
+const minInterval = time.Minute
+
+// Input gathers the sensors and the SEL usage.
+type Input struct {
+	timeout time.Duration
+	pusher  types.PointPusher
+	run     func(ctx context.Context, args ...string) ([]byte, error)
+
+	l           sync.Mutex
+	unavailable bool
+	lastGather  time.Time
+}
+
+// New initialise ipmi.Input. Each ipmitool command is stopped after timeout.
+func New(timeout time.Duration, pusher types.PointPusher) *Input {
+	return &Input{
+		timeout: timeout,
+		pusher:  pusher,
+		run:     runIPMITool,
+	}
+}
+
+func runIPMITool(ctx context.Context, args ...string) ([]byte, error) {
+	if os.Getuid() == 0 {
+		return exec.CommandContext(ctx, "ipmitool", args...).Output()
+	}
+
+	return exec.CommandContext(ctx, "sudo", append([]string{"-n", "ipmitool"}, args...)...).Output()
+}
+
+// Gather send metrics to the PointPusher.
+func (i *Input) Gather() {
+	i.l.Lock()
+	defer i.l.Unlock()
+
+	now := time.Now()
+
+	if i.unavailable || now.Sub(i.lastGather) < minInterval {
+		return
+	}
+
+	i.lastGather = now
+
+	ctx, cancel := context.WithTimeout(context.Background(), i.timeout)
+	defer cancel()
+
+	output, err := i.run(ctx, "sensor")
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			logger.Printf("ipmitool is not installed, the IPMI sensors are not reported")
+
+			i.unavailable = true
+		} else {
+			logger.V(1).Printf("Unable to read the IPMI sensors: %v", err)
+		}
+
+		return
+	}
+
+	sensors := decodeSensors(output)
+	points := sensorsPoints(sensors, now)
+
+	output, err = i.run(ctx, "sel", "info")
+	if err != nil {
+		logger.V(1).Printf("Unable to read the IPMI system event log: %v", err)
+	} else if sel, ok := decodeSELInfo(output); ok {
+		points = append(points,
+			point("ipmi_sel_entries", nil, float64(sel.Entries), now),
+			point("ipmi_sel_used_perc", nil, sel.UsedPercent, now),
+		)
+	}
+
+	if len(points) > 0 {
+		i.pusher.PushPoints(points)
+	}
+}
+
+func point(name string, sensor *Sensor, value float64, now time.Time) types.MetricPoint {
+	p := types.MetricPoint{
+		Labels: map[string]string{
+			types.LabelName: name,
+		},
+		Point: types.Point{
+			Time:  now,
+			Value: value,
+		},
+	}
+
+	if sensor != nil {
+		p.Labels["sensor"] = sensor.Name
+		p.Annotations.BleemeoItem = sensor.Name
+	}
+
+	return p
+}
+
+// sensorsPoints returns the reading of each sensor and the status of each class of sensors.
+// The status of a class is the worst status of its sensors.
+func sensorsPoints(sensors []Sensor, now time.Time) []types.MetricPoint {
+	points := make([]types.MetricPoint, 0, len(sensors))
+	statuses := make(map[string]types.StatusDescription)
+	failing := make(map[string][]string)
+
+	for idx := range sensors {
+		sensor := &sensors[idx]
+
+		points = append(points, point("ipmi_"+sensor.Class, sensor, sensor.Value, now))
+
+		status := statuses[sensor.Class]
+		if !status.CurrentStatus.IsSet() || sensor.Status.NagiosCode() > status.CurrentStatus.NagiosCode() {
+			status.CurrentStatus = sensor.Status
+			statuses[sensor.Class] = status
+		}
+
+		if sensor.Status != types.StatusOk {
+			failing[sensor.Class] = append(failing[sensor.Class], fmt.Sprintf(
+				"%s is %s (%s %s)", sensor.Name, sensor.Status, strconv.FormatFloat(sensor.Value, 'f', -1, 64), sensor.Unit,
+			))
+		}
+	}
+
+	classes := make([]string, 0, len(statuses))
+
+	for class := range statuses {
+		classes = append(classes, class)
+	}
+
+	sort.Strings(classes)
+
+	for _, class := range classes {
+		status := statuses[class]
+		status.StatusDescription = strings.Join(failing[class], ", ")
+
+		p := point("ipmi_"+class+"_status", nil, float64(status.CurrentStatus.NagiosCode()), now)
+		p.Annotations.Status = status
+
+		points = append(points, p)
+	}
+
+	return points
+}
+
+// decodeSensors parses the output of "ipmitool sensor". Each line contains the sensor name, its
+// reading, its unit, its status and its thresholds separated by "|".
+func decodeSensors(output []byte) []Sensor {
+	var sensors []Sensor
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) < 4 {
+			continue
+		}
+
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		class, ok := sensorClasses[fields[2]]
+		if !ok {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			// The sensor has no reading, e.g. "na" for an absent CPU.
+			continue
+		}
+
+		var status types.Status
+
+		switch fields[3] {
+		case "ok":
+			status = types.StatusOk
+		case "nc":
+			status = types.StatusWarning
+		case "cr", "nr":
+			status = types.StatusCritical
+		default:
+			continue
+		}
+
+		sensors = append(sensors, Sensor{
+			Name:   fields[0],
+			Class:  class,
+			Unit:   fields[2],
+			Value:  value,
+			Status: status,
+		})
+	}
+
+	return sensors
+}
+
+// decodeSELInfo parses the output of "ipmitool sel info".
+func decodeSELInfo(output []byte) (SELInfo, bool) {
+	var (
+		info  SELInfo
+		found bool
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(parts[1])
+
+		switch strings.TrimSpace(parts[0]) {
+		case "Entries":
+			entries, err := strconv.Atoi(value)
+			if err != nil {
+				return info, false
+			}
+
+			info.Entries = entries
+			found = true
+		case "Percent Used":
+			if used, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64); err == nil {
+				info.UsedPercent = used
+			}
+		}
+	}
+
+	return info, found
+}
@@ -0,0 +1,125 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipmi
+
+import (
+	"context"
+	"glouton/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const sensorOutput = `CPU Temp         | 45.000     | degrees C  | ok    | 0.000     | 0.000     | 0.000     | 93.000    | 103.000   | 103.000
+System Temp      | 88.000     | degrees C  | nc    | -9.000    | -7.000    | -5.000    | 80.000    | 85.000    | 90.000
+FAN1             | 3400.000   | RPM        | ok    | 300.000   | 500.000   | 700.000   | 25300.000 | 25400.000 | 25500.000
+FAN2             | na         | RPM        | na    | na        | na        | na        | na        | na        | na
+12V              | 10.112     | Volts      | cr    | 10.173    | 10.299    | 10.740    | 12.945    | 13.260    | 13.386
+PS1 Status       | 0x1        | discrete   | 0x0100| na        | na        | na        | na        | na        | na
+`
+
+const selOutput = `SEL Information
+Version          : 1.5 (v1.5, v2 compliant)
+Entries          : 42
+Free Space       : 15680 bytes
+Percent Used     : 4%
+`
+
+func TestDecodeSensors(t *testing.T) {
+	want := []Sensor{
+		{Name: "CPU Temp", Class: "temperature", Unit: "degrees C", Value: 45, Status: types.StatusOk},
+		{Name: "System Temp", Class: "temperature", Unit: "degrees C", Value: 88, Status: types.StatusWarning},
+		{Name: "FAN1", Class: "fan_speed", Unit: "RPM", Value: 3400, Status: types.StatusOk},
+		{Name: "12V", Class: "voltage", Unit: "Volts", Value: 10.112, Status: types.StatusCritical},
+	}
+
+	if got := decodeSensors([]byte(sensorOutput)); !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeSensors() = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeSELInfo(t *testing.T) {
+	got, ok := decodeSELInfo([]byte(selOutput))
+	if !ok {
+		t.Fatal("decodeSELInfo() failed")
+	}
+
+	if want := (SELInfo{Entries: 42, UsedPercent: 4}); got != want {
+		t.Errorf("decodeSELInfo() = %v, want %v", got, want)
+	}
+
+	if _, ok := decodeSELInfo([]byte("Could not open device\n")); ok {
+		t.Error("decodeSELInfo() succeeded on an error message")
+	}
+}
+
+type pointsRecorder struct {
+	points []types.MetricPoint
+}
+
+func (r *pointsRecorder) PushPoints(points []types.MetricPoint) {
+	r.points = append(r.points, points...)
+}
+
+func TestGather(t *testing.T) {
+	recorder := &pointsRecorder{}
+	input := New(time.Second, recorder)
+	calls := 0
+	input.run = func(ctx context.Context, args ...string) ([]byte, error) {
+		calls++
+
+		if args[0] == "sensor" {
+			return []byte(sensorOutput), nil
+		}
+
+		return []byte(selOutput), nil
+	}
+
+	input.Gather()
+	input.Gather()
+
+	if calls != 2 {
+		t.Errorf("ipmitool called %d times, want 2", calls)
+	}
+
+	statuses := make(map[string]types.StatusDescription)
+	values := make(map[string]float64)
+
+	for _, p := range recorder.points {
+		if p.Annotations.Status.CurrentStatus.IsSet() {
+			statuses[p.Labels[types.LabelName]] = p.Annotations.Status
+		} else if p.Labels["sensor"] == "" {
+			values[p.Labels[types.LabelName]] = p.Value
+		}
+	}
+
+	wantStatuses := map[string]types.StatusDescription{
+		"ipmi_temperature_status": {CurrentStatus: types.StatusWarning, StatusDescription: "System Temp is warning (88 degrees C)"},
+		"ipmi_fan_speed_status":   {CurrentStatus: types.StatusOk},
+		"ipmi_voltage_status":     {CurrentStatus: types.StatusCritical, StatusDescription: "12V is critical (10.112 Volts)"},
+	}
+
+	if !reflect.DeepEqual(statuses, wantStatuses) {
+		t.Errorf("statuses = %v, want %v", statuses, wantStatuses)
+	}
+
+	wantValues := map[string]float64{"ipmi_sel_entries": 42, "ipmi_sel_used_perc": 4}
+
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("values = %v, want %v", values, wantValues)
+	}
+}
@@ -19,6 +19,7 @@ package mem
 import (
 	"errors"
 	"glouton/inputs/internal"
+	"glouton/types"
 
 	"github.com/influxdata/telegraf"
 	telegraf_inputs "github.com/influxdata/telegraf/plugins/inputs"
@@ -34,6 +35,16 @@ func New() (i telegraf.Input, err error) {
 			Input: memInput,
 			Accumulator: internal.Accumulator{
 				TransformMetrics: transformMetrics,
+				Units: map[string]string{
+					"mem_used":           types.UnitBytes,
+					"mem_free":           types.UnitBytes,
+					"mem_available":      types.UnitBytes,
+					"mem_buffered":       types.UnitBytes,
+					"mem_cached":         types.UnitBytes,
+					"mem_total":          types.UnitBytes,
+					"mem_used_perc":      types.UnitPercent,
+					"mem_available_perc": types.UnitPercent,
+				},
 			},
 		}
 	} else {
@@ -19,6 +19,7 @@ package net
 import (
 	"errors"
 	"glouton/inputs/internal"
+	"glouton/types"
 	"strings"
 
 	"github.com/influxdata/telegraf"
@@ -47,6 +48,10 @@ func New(blacklist []string) (i telegraf.Input, err error) {
 				RenameGlobal:     nt.renameGlobal,
 				DerivatedMetrics: []string{"bytes_sent", "bytes_recv", "drop_in", "drop_out", "packets_recv", "packets_sent", "err_out", "err_in"},
 				TransformMetrics: nt.transformMetrics,
+				Units: map[string]string{
+					"net_bits_sent": types.UnitBits,
+					"net_bits_recv": types.UnitBits,
+				},
 			},
 		}
 	} else {
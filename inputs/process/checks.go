@@ -0,0 +1,205 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"glouton/facts"
+	"glouton/logger"
+	"glouton/threshold"
+	"glouton/types"
+	"math"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkMetricName is the metric emitted for each process check. Its value is the
+// number of matching processes and its status is "process_check_status".
+const checkMetricName = "process_check"
+
+// Check asserts the number of running processes matching a name or a regular expression.
+type Check struct {
+	// ID is the item of the metric, it defaults to the name or the regex.
+	ID string `yaml:"id"`
+	// Name matches the process name exactly.
+	Name string `yaml:"name"`
+	// Regex matches the process command line.
+	Regex string `yaml:"regex"`
+	// User restricts the check to the processes of this user.
+	User string `yaml:"user"`
+	// MinCount is the minimum number of processes, it defaults to 1.
+	MinCount *int `yaml:"min_count"`
+	// MaxCount is the maximum number of processes, 0 means no maximum.
+	MaxCount int `yaml:"max_count"`
+
+	regex *regexp.Regexp
+}
+
+// ParseChecks converts the process_checks configuration to checks.
+func ParseChecks(config interface{}) ([]Check, error) {
+	var checks []Check
+
+	marshalled, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(marshalled, &checks); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(checks))
+
+	for i := range checks {
+		c := &checks[i]
+
+		switch {
+		case c.Name != "" && c.Regex != "":
+			return nil, fmt.Errorf("process check %s: only one of name and regex could be set", c.Name)
+		case c.Name == "" && c.Regex == "":
+			return nil, errors.New("a process check has neither name nor regex")
+		case c.Regex != "":
+			c.regex, err = regexp.Compile(c.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("process check %s: %w", c.Regex, err)
+			}
+		}
+
+		if c.ID == "" {
+			c.ID = c.Name + c.Regex
+		}
+
+		if ids[c.ID] {
+			return nil, fmt.Errorf("the process check %s is defined twice", c.ID)
+		}
+
+		ids[c.ID] = true
+
+		if c.MinCount == nil {
+			minCount := 1
+			c.MinCount = &minCount
+		}
+
+		if c.MaxCount > 0 && c.MaxCount < *c.MinCount {
+			return nil, fmt.Errorf("process check %s: max_count is lower than min_count", c.ID)
+		}
+	}
+
+	return checks, nil
+}
+
+// ChecksThresholds returns the thresholds of the process_check metric for each check.
+// The status is critical when the count is outside [min_count, max_count].
+func ChecksThresholds(checks []Check) map[threshold.MetricNameItem]threshold.Threshold {
+	result := make(map[threshold.MetricNameItem]threshold.Threshold, len(checks))
+
+	for _, c := range checks {
+		t := threshold.Threshold{
+			LowCritical:  math.NaN(),
+			LowWarning:   math.NaN(),
+			HighWarning:  math.NaN(),
+			HighCritical: math.NaN(),
+		}
+
+		if *c.MinCount > 0 {
+			t.LowCritical = float64(*c.MinCount)
+		}
+
+		if c.MaxCount > 0 {
+			t.HighCritical = float64(c.MaxCount)
+		}
+
+		result[threshold.MetricNameItem{Name: checkMetricName, Item: c.ID}] = t
+	}
+
+	return result
+}
+
+func (c Check) match(p facts.Process) bool {
+	if c.User != "" && p.Username != c.User {
+		return false
+	}
+
+	if c.regex != nil {
+		return c.regex.MatchString(p.CmdLine)
+	}
+
+	return p.Name == c.Name
+}
+
+// ChecksInput counts the processes matching each check.
+type ChecksInput struct {
+	ps     processProvider
+	checks []Check
+	pusher types.PointPusher
+}
+
+// NewChecks initialise process.ChecksInput.
+//
+// The pusher should apply the thresholds returned by ChecksThresholds.
+func NewChecks(ps processProvider, checks []Check, pusher types.PointPusher) ChecksInput {
+	return ChecksInput{
+		ps:     ps,
+		checks: checks,
+		pusher: pusher,
+	}
+}
+
+// Gather send metrics to the PointPusher.
+func (i ChecksInput) Gather() {
+	proc, err := i.ps.Processes(context.Background(), maxAge)
+	if err != nil {
+		logger.V(1).Printf("unable to gather process checks: %v", err)
+		return
+	}
+
+	i.pusher.PushPoints(checksPoints(i.checks, proc, time.Now()))
+}
+
+func checksPoints(checks []Check, proc map[int]facts.Process, now time.Time) []types.MetricPoint {
+	points := make([]types.MetricPoint, 0, len(checks))
+
+	for _, c := range checks {
+		count := 0
+
+		for _, p := range proc {
+			if c.match(p) {
+				count++
+			}
+		}
+
+		points = append(points, types.MetricPoint{
+			Labels: map[string]string{
+				types.LabelName: checkMetricName,
+				"check":         c.ID,
+			},
+			Annotations: types.MetricAnnotations{
+				BleemeoItem: c.ID,
+			},
+			Point: types.Point{
+				Time:  now,
+				Value: float64(count),
+			},
+		})
+	}
+
+	return points
+}
@@ -0,0 +1,95 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"glouton/facts"
+	"glouton/threshold"
+	"glouton/types"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestChecks(t *testing.T) {
+	config := []interface{}{
+		map[string]interface{}{"name": "cron"},
+		map[string]interface{}{"id": "nginx_workers", "regex": "nginx: worker", "min_count": 2, "max_count": 4},
+		map[string]interface{}{"name": "backup", "user": "backup", "min_count": 0, "max_count": 1},
+	}
+
+	checks, err := ParseChecks(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proc := map[int]facts.Process{
+		1: {PID: 1, Name: "cron", CmdLine: "/usr/sbin/cron -f", Username: "root"},
+		2: {PID: 2, Name: "nginx", CmdLine: "nginx: master process", Username: "root"},
+		3: {PID: 3, Name: "nginx", CmdLine: "nginx: worker process", Username: "www-data"},
+		4: {PID: 4, Name: "backup", CmdLine: "backup --daemon", Username: "root"},
+	}
+
+	want := map[string]float64{
+		"cron":          1,
+		"nginx_workers": 1,
+		"backup":        0,
+	}
+
+	for _, p := range checksPoints(checks, proc, time.Now()) {
+		item := p.Labels["check"]
+		if p.Labels[types.LabelName] != checkMetricName || p.Annotations.BleemeoItem != item {
+			t.Errorf("unexpected point %v", p)
+		}
+
+		if p.Value != want[item] {
+			t.Errorf("count for %s = %v, want %v", item, p.Value, want[item])
+		}
+	}
+
+	thresholds := ChecksThresholds(checks)
+
+	nginx := thresholds[threshold.MetricNameItem{Name: checkMetricName, Item: "nginx_workers"}]
+	if status, _ := nginx.CurrentStatus(1); status != types.StatusCritical {
+		t.Errorf("status with 1 nginx worker = %v, want critical", status)
+	}
+
+	if status, _ := nginx.CurrentStatus(3); status != types.StatusOk {
+		t.Errorf("status with 3 nginx workers = %v, want ok", status)
+	}
+
+	backup := thresholds[threshold.MetricNameItem{Name: checkMetricName, Item: "backup"}]
+	if !math.IsNaN(backup.LowCritical) || backup.HighCritical != 1 {
+		t.Errorf("backup threshold = %v, want only high_critical = 1", backup)
+	}
+}
+
+func TestParseChecksInvalid(t *testing.T) {
+	cases := [][]interface{}{
+		{map[string]interface{}{"min_count": 1}},
+		{map[string]interface{}{"name": "cron", "regex": "cron"}},
+		{map[string]interface{}{"regex": "("}},
+		{map[string]interface{}{"name": "cron"}, map[string]interface{}{"name": "cron"}},
+		{map[string]interface{}{"name": "cron", "min_count": 2, "max_count": 1}},
+	}
+
+	for _, c := range cases {
+		if _, err := ParseChecks(c); err == nil {
+			t.Errorf("ParseChecks(%v) succeeded, want an error", c)
+		}
+	}
+}
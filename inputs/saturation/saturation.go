@@ -0,0 +1,242 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package saturation reports the Linux pressure stall information (PSI) and,
+// optionally, the usage of each CPU core.
+//
+// PSI is read from /proc/pressure (Linux 4.20 and later), it's silently skipped
+// on older kernels. The per-core usage is computed from /proc/stat.
+package saturation
+
+import (
+	"bufio"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pressureResources are the resources reported by the kernel in /proc/pressure.
+var pressureResources = []string{"cpu", "memory", "io"} //nolint:gochecknoglobals
+
+// Input gathers the pressure stall information and the per-core CPU usage.
+type Input struct {
+	procPath string
+	perCore  bool
+	pusher   types.PointPusher
+
+	l            sync.Mutex
+	lastCPUTimes map[string]cpuTimes
+}
+
+// cpuTimes are the counters of a CPU core from /proc/stat.
+type cpuTimes struct {
+	used  float64
+	total float64
+}
+
+// New initialise saturation.Input.
+//
+// hostRootPath is the path where the host filesystem is mounted, "/" when not running in a container.
+// When perCore is true, the metric cpu_core_used is emitted for each core.
+func New(hostRootPath string, perCore bool, pusher types.PointPusher) *Input {
+	procPath := filepath.Join(hostRootPath, "proc")
+	if hostRootPath == "" {
+		procPath = "/proc"
+	}
+
+	return &Input{
+		procPath: procPath,
+		perCore:  perCore,
+		pusher:   pusher,
+	}
+}
+
+// Gather send metrics to the PointPusher.
+func (i *Input) Gather() {
+	now := time.Now()
+	points := make([]types.MetricPoint, 0, 12)
+
+	for _, resource := range pressureResources {
+		path := filepath.Join(i.procPath, "pressure", resource)
+
+		values, err := readPressure(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logger.V(1).Printf("Unable to read the pressure stall information from %s: %v", path, err)
+			}
+
+			continue
+		}
+
+		for _, v := range values {
+			points = append(points, point(fmt.Sprintf("pressure_%s_%s", resource, v.name), "", v.value, now))
+		}
+	}
+
+	if i.perCore {
+		points = append(points, i.corePoints(now)...)
+	}
+
+	if len(points) > 0 {
+		i.pusher.PushPoints(points)
+	}
+}
+
+func point(name string, core string, value float64, now time.Time) types.MetricPoint {
+	p := types.MetricPoint{
+		Labels: map[string]string{
+			types.LabelName: name,
+		},
+		Point: types.Point{
+			Time:  now,
+			Value: value,
+		},
+	}
+
+	if core != "" {
+		p.Labels["core"] = core
+		p.Annotations.BleemeoItem = core
+	}
+
+	return p
+}
+
+// corePoints returns the usage of each core since the previous call.
+func (i *Input) corePoints(now time.Time) []types.MetricPoint {
+	path := filepath.Join(i.procPath, "stat")
+
+	f, err := os.Open(path)
+	if err != nil {
+		logger.V(1).Printf("Unable to read the CPU usage from %s: %v", path, err)
+
+		return nil
+	}
+
+	defer f.Close()
+
+	times, err := decodeCPUTimes(f)
+	if err != nil {
+		logger.V(1).Printf("Unable to read the CPU usage from %s: %v", path, err)
+
+		return nil
+	}
+
+	i.l.Lock()
+	defer i.l.Unlock()
+
+	points := make([]types.MetricPoint, 0, len(times))
+
+	for core, current := range times {
+		previous, ok := i.lastCPUTimes[core]
+		if !ok || current.total <= previous.total {
+			continue
+		}
+
+		used := 100 * (current.used - previous.used) / (current.total - previous.total)
+		points = append(points, point("cpu_core_used", core, used, now))
+	}
+
+	i.lastCPUTimes = times
+
+	return points
+}
+
+type pressureValue struct {
+	name  string
+	value float64
+}
+
+// readPressure returns the avg10 and avg60 values of the "some" and "full" lines of a pressure file.
+func readPressure(path string) ([]pressureValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return decodePressure(f)
+}
+
+func decodePressure(r io.Reader) ([]pressureValue, error) {
+	var values []pressureValue
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || (fields[0] != "some" && fields[0] != "full") {
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 || (kv[0] != "avg10" && kv[0] != "avg60") {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %#v: %w", field, err)
+			}
+
+			values = append(values, pressureValue{name: fields[0] + "_" + kv[0], value: value})
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// decodeCPUTimes returns the counters of each core, by core number. The used time
+// has the same definition as the cpu_used metric (user, system, interrupt, softirq and steal).
+func decodeCPUTimes(r io.Reader) (map[string]cpuTimes, error) {
+	result := make(map[string]cpuTimes)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+
+		var values [8]float64
+
+		for n := range values {
+			v, err := strconv.ParseFloat(fields[n+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid counter %#v for %s: %w", fields[n+1], fields[0], err)
+			}
+
+			values[n] = v
+		}
+
+		user, nice, system, idle, iowait, irq, softirq, steal := values[0], values[1], values[2], values[3], values[4], values[5], values[6], values[7]
+
+		result[strings.TrimPrefix(fields[0], "cpu")] = cpuTimes{
+			used:  user + system + irq + softirq + steal,
+			total: user + nice + system + idle + iowait + irq + softirq + steal,
+		}
+	}
+
+	return result, scanner.Err()
+}
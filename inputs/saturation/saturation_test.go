@@ -0,0 +1,67 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saturation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodePressure(t *testing.T) {
+	content := `some avg10=1.50 avg60=0.75 avg300=0.10 total=123456
+full avg10=0.25 avg60=0.00 avg300=0.00 total=2345
+`
+
+	got, err := decodePressure(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []pressureValue{
+		{name: "some_avg10", value: 1.5},
+		{name: "some_avg60", value: 0.75},
+		{name: "full_avg10", value: 0.25},
+		{name: "full_avg60", value: 0},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodePressure() = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeCPUTimes(t *testing.T) {
+	content := `cpu  300 10 100 1000 20 5 5 0 0 0
+cpu0 200 10 50 400 10 5 5 0 0 0
+cpu1 100 0 50 600 10 0 0 0 0 0
+intr 12345 0 0
+`
+
+	got, err := decodeCPUTimes(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]cpuTimes{
+		"0": {used: 260, total: 680},
+		"1": {used: 150, total: 760},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeCPUTimes() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sessions reports the user sessions and the failed logins.
+//
+// Sessions are read from the utmp file and failed logins from the btmp file,
+// both using the glibc record format.
+package sessions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"glouton/logger"
+	"glouton/types"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recordSize  = 384
+	userProcess = 7
+)
+
+// record is an utmp record, as defined by glibc.
+type record struct {
+	Type    int16
+	_       int16
+	Pid     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	Seconds int32
+	Micro   int32
+	Addr    [4]int32
+	_       [20]byte
+}
+
+// Session is a logged-in user.
+type Session struct {
+	User string
+	Line string
+	Host string
+}
+
+// Input gathers the sessions and failed logins.
+type Input struct {
+	utmpPath string
+	btmpPath string
+	pusher   types.PointPusher
+
+	l            sync.Mutex
+	lastBtmpSize int64
+	lastGather   time.Time
+}
+
+// New initialise sessions.Input.
+//
+// hostRootPath is the path where the host filesystem is mounted, "/" when not running in a container.
+func New(hostRootPath string, pusher types.PointPusher) *Input {
+	return &Input{
+		utmpPath: findFile(hostRootPath, "run/utmp", "var/run/utmp"),
+		btmpPath: filepath.Join(hostRootPath, "var/log/btmp"),
+		pusher:   pusher,
+	}
+}
+
+func findFile(hostRootPath string, candidates ...string) string {
+	for _, c := range candidates {
+		path := filepath.Join(hostRootPath, c)
+
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return filepath.Join(hostRootPath, candidates[0])
+}
+
+// Gather send metrics to the PointPusher.
+func (i *Input) Gather() {
+	now := time.Now()
+	points := make([]types.MetricPoint, 0, 3)
+
+	sessions, err := readSessions(i.utmpPath)
+	if err != nil {
+		logger.V(1).Printf("Unable to read the sessions from %s: %v", i.utmpPath, err)
+	} else {
+		ssh, users := countSessions(sessions)
+
+		points = append(points,
+			point("users_ssh_sessions", float64(ssh), now),
+			point("users_unique", float64(users), now),
+		)
+	}
+
+	if rate, ok := i.failedLoginsRate(now); ok {
+		points = append(points, point("users_auth_failures_per_minute", rate, now))
+	}
+
+	if len(points) > 0 {
+		i.pusher.PushPoints(points)
+	}
+}
+
+func point(name string, value float64, now time.Time) types.MetricPoint {
+	return types.MetricPoint{
+		Labels: map[string]string{
+			types.LabelName: name,
+		},
+		Point: types.Point{
+			Time:  now,
+			Value: value,
+		},
+	}
+}
+
+// failedLoginsRate returns the number of failed logins per minute since the previous call.
+// The btmp file only grows between two rotations, the count of new records is deduced
+// from its size.
+func (i *Input) failedLoginsRate(now time.Time) (float64, bool) {
+	i.l.Lock()
+	defer i.l.Unlock()
+
+	stat, err := os.Stat(i.btmpPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.V(1).Printf("Unable to read the failed logins from %s: %v", i.btmpPath, err)
+		}
+
+		return 0, false
+	}
+
+	size := stat.Size()
+	lastSize := i.lastBtmpSize
+	lastGather := i.lastGather
+
+	i.lastBtmpSize = size
+	i.lastGather = now
+
+	if lastGather.IsZero() || now.Sub(lastGather) <= 0 {
+		return 0, false
+	}
+
+	if size < lastSize {
+		// The file was rotated.
+		lastSize = 0
+	}
+
+	records := (size - lastSize) / recordSize
+
+	return float64(records) / now.Sub(lastGather).Minutes(), true
+}
+
+// readSessions returns the sessions of the users logged-in.
+func readSessions(path string) ([]Session, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return decodeSessions(f)
+}
+
+func decodeSessions(r io.Reader) ([]Session, error) {
+	var sessions []Session
+
+	for {
+		var rec record
+
+		err := binary.Read(r, binary.LittleEndian, &rec)
+		if errors.Is(err, io.EOF) {
+			return sessions, nil
+		}
+
+		if err != nil {
+			return sessions, err
+		}
+
+		if rec.Type != userProcess {
+			continue
+		}
+
+		sessions = append(sessions, Session{
+			User: cString(rec.User[:]),
+			Line: cString(rec.Line[:]),
+			Host: cString(rec.Host[:]),
+		})
+	}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+
+	return string(b)
+}
+
+// countSessions returns the number of remote sessions on a pseudo-terminal, which are
+// SSH sessions in practice, and the number of distinct users.
+func countSessions(sessions []Session) (ssh int, users int) {
+	seen := make(map[string]bool)
+
+	for _, s := range sessions {
+		if s.Host != "" && strings.HasPrefix(s.Line, "pts/") {
+			ssh++
+		}
+
+		seen[s.User] = true
+	}
+
+	return ssh, len(seen)
+}
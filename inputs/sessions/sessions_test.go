@@ -0,0 +1,119 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func makeRecord(recordType int16, user string, line string, host string) record {
+	rec := record{Type: recordType}
+
+	copy(rec.User[:], user)
+	copy(rec.Line[:], line)
+	copy(rec.Host[:], host)
+
+	return rec
+}
+
+func TestRecordSize(t *testing.T) {
+	if got := binary.Size(record{}); got != recordSize {
+		t.Errorf("binary.Size(record{}) = %d, want %d", got, recordSize)
+	}
+}
+
+func TestDecodeSessions(t *testing.T) {
+	records := []record{
+		makeRecord(2, "reboot", "~", "5.4.0"),
+		makeRecord(userProcess, "alice", "pts/0", "192.0.2.1"),
+		makeRecord(userProcess, "alice", "pts/1", "192.0.2.1"),
+		makeRecord(userProcess, "bob", "tty1", ""),
+		makeRecord(8, "", "pts/2", ""),
+	}
+
+	var buffer bytes.Buffer
+
+	for _, rec := range records {
+		if err := binary.Write(&buffer, binary.LittleEndian, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessions, err := decodeSessions(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Session{
+		{User: "alice", Line: "pts/0", Host: "192.0.2.1"},
+		{User: "alice", Line: "pts/1", Host: "192.0.2.1"},
+		{User: "bob", Line: "tty1"},
+	}
+
+	if !reflect.DeepEqual(sessions, want) {
+		t.Fatalf("decodeSessions() = %v, want %v", sessions, want)
+	}
+
+	ssh, users := countSessions(sessions)
+	if ssh != 2 || users != 2 {
+		t.Errorf("countSessions() = %d, %d, want 2, 2", ssh, users)
+	}
+}
+
+func TestFailedLoginsRate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	i := &Input{btmpPath: filepath.Join(dir, "btmp")}
+	now := time.Now()
+
+	write := func(records int) {
+		if err := ioutil.WriteFile(i.btmpPath, make([]byte, records*recordSize), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(10)
+
+	if _, ok := i.failedLoginsRate(now); ok {
+		t.Error("failedLoginsRate() returned a rate on the first call")
+	}
+
+	write(16)
+
+	if got, ok := i.failedLoginsRate(now.Add(2 * time.Minute)); !ok || got != 3 {
+		t.Errorf("failedLoginsRate() = %v, %v, want 3, true", got, ok)
+	}
+
+	// After a rotation all records are new.
+	write(1)
+
+	if got, ok := i.failedLoginsRate(now.Add(3 * time.Minute)); !ok || got != 1 {
+		t.Errorf("failedLoginsRate() = %v, %v, want 1, true", got, ok)
+	}
+}
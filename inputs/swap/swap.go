@@ -19,6 +19,9 @@ package swap
 import (
 	"errors"
 	"glouton/inputs/internal"
+	"glouton/types"
+	"runtime"
+	"strings"
 
 	"github.com/influxdata/telegraf"
 	telegraf_inputs "github.com/influxdata/telegraf/plugins/inputs"
@@ -31,9 +34,15 @@ func New() (i telegraf.Input, err error) {
 	if ok {
 		swapInput := input().(*swap.SwapStats)
 		i = &internal.Input{
-			Input: swapInput,
+			Input: swapStats{SwapStats: swapInput},
 			Accumulator: internal.Accumulator{
 				TransformMetrics: transformMetrics,
+				Units: map[string]string{
+					"swap_used":      types.UnitBytes,
+					"swap_free":      types.UnitBytes,
+					"swap_total":     types.UnitBytes,
+					"swap_used_perc": types.UnitPercent,
+				},
 			},
 		}
 	} else {
@@ -43,11 +52,39 @@ func New() (i telegraf.Input, err error) {
 	return
 }
 
+// swapStats wrap the Telegraf swap input to support systems without swap.
+type swapStats struct {
+	*swap.SwapStats
+}
+
+// Gather send zero swap on FreeBSD without swap devices (common on appliances) instead of failing.
+func (s swapStats) Gather(acc telegraf.Accumulator) error {
+	err := s.SwapStats.Gather(acc)
+	if err != nil && runtime.GOOS == "freebsd" && strings.Contains(err.Error(), "no swap devices found") {
+		acc.AddGauge("swap", map[string]interface{}{
+			"total":        uint64(0),
+			"used":         uint64(0),
+			"free":         uint64(0),
+			"used_percent": float64(0),
+		}, nil)
+
+		return nil
+	}
+
+	return err
+}
+
 func transformMetrics(originalContext internal.GatherContext, currentContext internal.GatherContext, fields map[string]float64, originalFields map[string]interface{}) map[string]float64 {
 	if value, ok := fields["used_percent"]; ok {
 		delete(fields, "used_percent")
 		fields["used_perc"] = value
 	}
 
+	// gopsutil doesn't provide swap in/out on FreeBSD, they would always be 0.
+	if runtime.GOOS == "freebsd" {
+		delete(fields, "in")
+		delete(fields, "out")
+	}
+
 	return fields
 }
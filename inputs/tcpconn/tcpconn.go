@@ -0,0 +1,251 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tcpconn reports the established TCP sockets of the discovered services.
+//
+// The sockets are read from /proc/net/tcp and /proc/net/tcp6 of the host network
+// namespace and are matched to a service by their local address and port, so only
+// services listening on the host network are reported.
+package tcpconn
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stateEstablished is the TCP_ESTABLISHED state in /proc/net/tcp.
+const stateEstablished = 1
+
+// Service is a service listening on the host network.
+type Service struct {
+	Name string
+	// ListenAddresses are the TCP addresses the service listens on. An unspecified
+	// IP (0.0.0.0 or ::) matches the sockets of all local addresses.
+	ListenAddresses []net.TCPAddr
+}
+
+// Input gathers the TCP connections of services.
+type Input struct {
+	procPath string
+	services func() []Service
+	pusher   types.PointPusher
+}
+
+// socketKey is the local address of a socket.
+type socketKey struct {
+	ip   string
+	port int
+}
+
+// socketStats are the statistics of the established sockets with a given local address.
+type socketStats struct {
+	established    int
+	retransmitting int
+}
+
+// New initialise tcpconn.Input.
+//
+// hostRootPath is the path where the host filesystem is mounted, "/" when not running in a container.
+// services returns the services to report, it's called on each gather.
+func New(hostRootPath string, services func() []Service, pusher types.PointPusher) *Input {
+	procPath := filepath.Join(hostRootPath, "proc")
+	if hostRootPath == "" {
+		procPath = "/proc"
+	}
+
+	return &Input{
+		procPath: procPath,
+		services: services,
+		pusher:   pusher,
+	}
+}
+
+// Gather send metrics to the PointPusher.
+func (i *Input) Gather() {
+	now := time.Now()
+	stats := make(map[socketKey]socketStats)
+
+	for _, name := range []string{"tcp", "tcp6"} {
+		path := filepath.Join(i.procPath, "net", name)
+
+		if err := readSockets(path, stats); err != nil {
+			if !os.IsNotExist(err) {
+				logger.V(1).Printf("Unable to read the TCP sockets from %s: %v", path, err)
+			}
+		}
+	}
+
+	services := i.services()
+	points := make([]types.MetricPoint, 0, 2*len(services))
+
+	for _, service := range services {
+		total := serviceStats(service, stats)
+
+		points = append(points,
+			point("service_tcp_sockets_established", service.Name, float64(total.established), now),
+			point("service_tcp_sockets_retransmitting", service.Name, float64(total.retransmitting), now),
+		)
+	}
+
+	if len(points) > 0 {
+		i.pusher.PushPoints(points)
+	}
+}
+
+// serviceStats sums the statistics of the sockets whose local address is one of
+// the listen addresses of the service.
+func serviceStats(service Service, stats map[socketKey]socketStats) socketStats {
+	var total socketStats
+
+	for key, s := range stats {
+		for _, address := range service.ListenAddresses {
+			if key.port != address.Port {
+				continue
+			}
+
+			if !address.IP.IsUnspecified() && normalizeIP(address.IP).String() != key.ip {
+				continue
+			}
+
+			total.established += s.established
+			total.retransmitting += s.retransmitting
+
+			break
+		}
+	}
+
+	return total
+}
+
+func point(name string, service string, value float64, now time.Time) types.MetricPoint {
+	return types.MetricPoint{
+		Labels: map[string]string{
+			types.LabelName:    name,
+			types.LabelService: service,
+		},
+		Annotations: types.MetricAnnotations{
+			BleemeoItem: service,
+			ServiceName: service,
+		},
+		Point: types.Point{
+			Time:  now,
+			Value: value,
+		},
+	}
+}
+
+func readSockets(path string, stats map[socketKey]socketStats) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return decodeSockets(f, stats)
+}
+
+// decodeSockets adds the established sockets to stats, by local address. A socket is
+// retransmitting when it has unacknowledged segments that timed out.
+func decodeSockets(r io.Reader, stats map[socketKey]socketStats) error {
+	scanner := bufio.NewScanner(r)
+
+	// Skip the header.
+	scanner.Scan()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid state %#v: %w", fields[3], err)
+		}
+
+		if state != stateEstablished {
+			continue
+		}
+
+		key, err := decodeAddress(fields[1])
+		if err != nil {
+			return err
+		}
+
+		retransmits, err := strconv.ParseUint(fields[6], 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid retransmits %#v: %w", fields[6], err)
+		}
+
+		s := stats[key]
+		s.established++
+
+		if retransmits > 0 {
+			s.retransmitting++
+		}
+
+		stats[key] = s
+	}
+
+	return scanner.Err()
+}
+
+// decodeAddress decodes an address of /proc/net/tcp, e.g. "0100007F:0050" for 127.0.0.1:80.
+// The IP is printed as 32-bit words in host byte order, little-endian on the supported
+// architectures.
+func decodeAddress(address string) (socketKey, error) {
+	colon := strings.LastIndexByte(address, ':')
+	if colon == -1 {
+		return socketKey{}, fmt.Errorf("invalid local address %#v", address)
+	}
+
+	ip, err := hex.DecodeString(address[:colon])
+	if err != nil || (len(ip) != net.IPv4len && len(ip) != net.IPv6len) {
+		return socketKey{}, fmt.Errorf("invalid local address %#v", address)
+	}
+
+	for i := 0; i < len(ip); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = ip[i+3], ip[i+2], ip[i+1], ip[i]
+	}
+
+	port, err := strconv.ParseUint(address[colon+1:], 16, 16)
+	if err != nil {
+		return socketKey{}, fmt.Errorf("invalid local address %#v: %w", address, err)
+	}
+
+	return socketKey{ip: normalizeIP(ip).String(), port: int(port)}, nil
+}
+
+// normalizeIP returns IPv4-mapped IPv6 addresses as IPv4 addresses, the IPv4 sockets
+// of a dual-stack listener are shown in /proc/net/tcp6.
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
@@ -0,0 +1,111 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcpconn
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSockets(t *testing.T) {
+	content := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0050 0100007F:D4C2 01 00000000:00000000 00:00000000 00000000    33        0 1002 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:0050 0100007F:D4C4 01 00000010:00000000 01:00000100 00000002    33        0 1003 1 0000000000000000 20 4 30 10 -1
+   3: 0100007F:D4C2 0100007F:0050 01 00000000:00000000 00:00000000 00000000  1000        0 1004 1 0000000000000000 20 4 30 10 -1
+`
+
+	stats := make(map[socketKey]socketStats)
+
+	if err := decodeSockets(strings.NewReader(content), stats); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[socketKey]socketStats{
+		{ip: "127.0.0.1", port: 80}:    {established: 2, retransmitting: 1},
+		{ip: "127.0.0.1", port: 54466}: {established: 1},
+	}
+
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("decodeSockets() = %v, want %v", stats, want)
+	}
+}
+
+func TestDecodeAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    socketKey
+	}{
+		{address: "0100007F:0050", want: socketKey{ip: "127.0.0.1", port: 80}},
+		{address: "00000000000000000000000001000000:1F90", want: socketKey{ip: "::1", port: 8080}},
+		{address: "0000000000000000FFFF00000A01A8C0:01BB", want: socketKey{ip: "192.168.1.10", port: 443}},
+	}
+
+	for _, tt := range tests {
+		got, err := decodeAddress(tt.address)
+		if err != nil {
+			t.Errorf("decodeAddress(%s) failed: %v", tt.address, err)
+
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("decodeAddress(%s) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestServiceStats(t *testing.T) {
+	stats := map[socketKey]socketStats{
+		{ip: "127.0.0.1", port: 80}:    {established: 2, retransmitting: 1},
+		{ip: "192.168.1.10", port: 80}: {established: 3},
+		{ip: "127.0.0.1", port: 5432}:  {established: 4},
+	}
+
+	tests := []struct {
+		name    string
+		service Service
+		want    socketStats
+	}{
+		{
+			name:    "all addresses",
+			service: Service{ListenAddresses: []net.TCPAddr{{IP: net.IPv4zero, Port: 80}}},
+			want:    socketStats{established: 5, retransmitting: 1},
+		},
+		{
+			name:    "one address",
+			service: Service{ListenAddresses: []net.TCPAddr{{IP: net.ParseIP("192.168.1.10"), Port: 80}}},
+			want:    socketStats{established: 3},
+		},
+		{
+			name: "other port on the same address",
+			service: Service{ListenAddresses: []net.TCPAddr{
+				{IP: net.ParseIP("127.0.0.1"), Port: 5432},
+				{IP: net.ParseIP("::1"), Port: 5432},
+			}},
+			want: socketStats{established: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := serviceStats(tt.service, stats); got != tt.want {
+			t.Errorf("%s: serviceStats() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
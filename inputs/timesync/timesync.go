@@ -0,0 +1,340 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package timesync measure the offset of the local clock.
+//
+// The offset is measured against configured NTP servers. When no server is
+// configured, the status of the local time daemon (chrony or systemd-timesyncd
+// through timedatectl) is used.
+package timesync
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"math"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	queryTimeout  = 2 * time.Second
+	queryInterval = time.Minute
+	// maxMeasureAge is the age after which the last measure is no longer sent.
+	maxMeasureAge = 2 * queryInterval
+	ntpEpochDiff  = 2208988800
+)
+
+var errNotSynchronized = errors.New("clock is not synchronized")
+
+type commandRunner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// Input gather the local clock offset.
+//
+// The offset is measured by Run in its own goroutine, Gather only sends the
+// last measure so it never waits for a NTP server.
+type Input struct {
+	servers []string
+	pusher  types.PointPusher
+	runCmd  commandRunner
+
+	l          sync.Mutex
+	offset     time.Duration
+	err        error
+	measuredAt time.Time
+}
+
+// New initialise timesync.Input.
+//
+// servers are NTP servers using the format "host" or "host:port".
+func New(servers []string, pusher types.PointPusher) *Input {
+	return &Input{
+		servers: servers,
+		pusher:  pusher,
+		runCmd:  runCommand,
+	}
+}
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// Run measures the local clock offset every queryInterval until ctx is cancelled.
+func (i *Input) Run(ctx context.Context) error {
+	ticker := time.NewTicker(queryInterval)
+	defer ticker.Stop()
+
+	for {
+		i.measure(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (i *Input) measure(ctx context.Context) {
+	offset, err := i.measureOffset(ctx)
+	if err != nil && !errors.Is(err, errNotSynchronized) {
+		logger.V(1).PrintfLimited("Unable to measure the local clock offset: %v", err)
+	}
+
+	i.l.Lock()
+	defer i.l.Unlock()
+
+	i.offset = offset
+	i.err = err
+	i.measuredAt = time.Now()
+}
+
+// Gather send metrics to the PointPusher.
+func (i *Input) Gather() {
+	i.l.Lock()
+	offset, err, measuredAt := i.offset, i.err, i.measuredAt
+	i.l.Unlock()
+
+	now := time.Now()
+
+	if measuredAt.IsZero() || now.Sub(measuredAt) > maxMeasureAge {
+		return
+	}
+
+	switch {
+	case err == nil:
+		i.pusher.PushPoints([]types.MetricPoint{
+			{
+				Labels: map[string]string{
+					types.LabelName: "time_drift_seconds",
+				},
+				Point: types.Point{
+					Time:  now,
+					Value: math.Abs(offset.Seconds()),
+				},
+			},
+		})
+	case errors.Is(err, errNotSynchronized):
+		i.pusher.PushPoints([]types.MetricPoint{
+			{
+				Labels: map[string]string{
+					types.LabelName: "time_drift_seconds_status",
+				},
+				Annotations: types.MetricAnnotations{
+					StatusOf: "time_drift_seconds",
+					Status: types.StatusDescription{
+						CurrentStatus:     types.StatusCritical,
+						StatusDescription: "Local clock is not synchronized",
+					},
+				},
+				Point: types.Point{
+					Time:  now,
+					Value: float64(types.StatusCritical.NagiosCode()),
+				},
+			},
+		})
+	}
+}
+
+// measureOffset returns the offset of the local clock. Each NTP query or command
+// is limited to queryTimeout.
+func (i *Input) measureOffset(ctx context.Context) (time.Duration, error) {
+	if len(i.servers) > 0 {
+		var lastErr error
+
+		for _, server := range i.servers {
+			offset, err := queryNTPWithTimeout(ctx, server)
+			if err == nil {
+				return offset, nil
+			}
+
+			logger.V(2).Printf("NTP query to %s failed: %v", server, err)
+
+			lastErr = err
+		}
+
+		return 0, lastErr
+	}
+
+	if out, err := i.runCmdWithTimeout(ctx, "chronyc", "-c", "tracking"); err == nil {
+		return parseChronyTracking(string(out))
+	}
+
+	out, err := i.runCmdWithTimeout(ctx, "timedatectl", "show", "--property=NTPSynchronized", "--value")
+	if err != nil {
+		return 0, fmt.Errorf("no NTP server configured and neither chronyc nor timedatectl are usable: %w", err)
+	}
+
+	if strings.TrimSpace(string(out)) != "yes" {
+		return 0, errNotSynchronized
+	}
+
+	// timedatectl don't give the offset. When synchronized, systemd-timesyncd
+	// keep the clock within few milliseconds.
+	return 0, nil
+}
+
+func (i *Input) runCmdWithTimeout(ctx context.Context, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	return i.runCmd(ctx, name, args...)
+}
+
+func queryNTPWithTimeout(ctx context.Context, server string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	return queryNTP(ctx, server)
+}
+
+// parseChronyTracking parse the output of "chronyc -c tracking".
+//
+// The 5th field is the offset of the system clock in seconds, a positive value
+// means the clock is slow. The last field is the leap status.
+func parseChronyTracking(output string) (time.Duration, error) {
+	fields := strings.Split(strings.TrimSpace(output), ",")
+	if len(fields) < 14 {
+		return 0, fmt.Errorf("unexpected chronyc output: %#v", output)
+	}
+
+	if fields[len(fields)-1] == "Not synchronised" {
+		return 0, errNotSynchronized
+	}
+
+	value, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(value * float64(time.Second)), nil
+}
+
+type ntpTimestamp struct {
+	Second   uint32
+	Fraction uint32
+}
+
+func toNTPTimestamp(t time.Time) ntpTimestamp {
+	nano := uint64(t.Nanosecond())
+
+	return ntpTimestamp{
+		Second:   uint32(t.Unix() + ntpEpochDiff),
+		Fraction: uint32((nano << 32) / uint64(time.Second)),
+	}
+}
+
+func (nt ntpTimestamp) Time() time.Time {
+	nano := (uint64(nt.Fraction) * uint64(time.Second)) >> 32
+
+	return time.Unix(int64(nt.Second)-ntpEpochDiff, int64(nano))
+}
+
+type ntpPacket struct {
+	LeapVersionMode uint8
+	Stratum         uint8
+	Poll            int8
+	Precision       int8
+	RootDelay       int32
+	RootDispersion  int32
+	ReferenceID     [4]byte
+	ReferenceTS     ntpTimestamp
+	OriginateTS     ntpTimestamp
+	ReceiveTS       ntpTimestamp
+	TransmitTS      ntpTimestamp
+}
+
+// clockOffset compute the offset of the server clock relative to the local clock.
+//
+// sent and received are the local times when the request was sent and the response
+// received.
+func clockOffset(packet ntpPacket, sent time.Time, received time.Time) time.Duration {
+	return (packet.ReceiveTS.Time().Sub(sent) + packet.TransmitTS.Time().Sub(received)) / 2
+}
+
+func queryNTP(ctx context.Context, server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+
+	sent := time.Now()
+	request := ntpPacket{
+		// No leap indicator, version 3, mode client
+		LeapVersionMode: 0<<6 | 3<<3 | 3,
+		TransmitTS:      toNTPTimestamp(sent),
+	}
+
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.BigEndian, request); err != nil {
+		return 0, err
+	}
+
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	data := make([]byte, 48)
+
+	n, err := conn.Read(data)
+	if err != nil {
+		return 0, err
+	}
+
+	received := time.Now()
+
+	if n != len(data) {
+		return 0, fmt.Errorf("short response from %s: %d bytes", server, n)
+	}
+
+	var response ntpPacket
+
+	if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &response); err != nil {
+		return 0, err
+	}
+
+	if response.Stratum == 0 || response.Stratum == 16 {
+		return 0, fmt.Errorf("NTP server %s is not synchronized", server)
+	}
+
+	if response.OriginateTS != request.TransmitTS {
+		return 0, fmt.Errorf("NTP server %s replied to another request", server)
+	}
+
+	return clockOffset(response, sent, received), nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timesync
+
+import (
+	"context"
+	"errors"
+	"glouton/types"
+	"testing"
+	"time"
+)
+
+type mockPusher struct {
+	points []types.MetricPoint
+}
+
+func (m *mockPusher) PushPoints(points []types.MetricPoint) {
+	m.points = append(m.points, points...)
+}
+
+func Test_parseChronyTracking(t *testing.T) {
+	cases := []struct {
+		name    string
+		output  string
+		want    time.Duration
+		wantErr error
+	}{
+		{
+			name:   "synchronized",
+			output: "A29FC87B,ntp1.example.com,3,1601546130.553405654,-0.000125000,0.000087931,0.000345312,-12.345,0.001,0.052,0.015442342,0.001049317,1029.3,Normal\n",
+			want:   -125 * time.Microsecond,
+		},
+		{
+			name:    "not-synchronized",
+			output:  "00000000,,0,0.000000000,0.000000000,0.000000000,0.000000000,0.000,0.000,0.000,1.000000000,1.000000000,0.0,Not synchronised\n",
+			wantErr: errNotSynchronized,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseChronyTracking(c.output)
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("parseChronyTracking() error = %v, want %v", err, c.wantErr)
+			}
+
+			if got != c.want {
+				t.Errorf("parseChronyTracking() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func Test_clockOffset(t *testing.T) {
+	sent := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	received := sent.Add(20 * time.Millisecond)
+
+	// The server clock is 1.5 seconds ahead, with 10ms network delay each way
+	packet := ntpPacket{
+		ReceiveTS:  toNTPTimestamp(sent.Add(1500*time.Millisecond + 10*time.Millisecond)),
+		TransmitTS: toNTPTimestamp(received.Add(1500*time.Millisecond - 10*time.Millisecond)),
+	}
+
+	got := clockOffset(packet, sent, received)
+	if diff := got - 1500*time.Millisecond; diff > time.Microsecond || diff < -time.Microsecond {
+		t.Errorf("clockOffset() = %v, want 1.5s", got)
+	}
+}
+
+func TestGatherNotSynchronized(t *testing.T) {
+	pusher := &mockPusher{}
+	input := New(nil, pusher)
+	input.runCmd = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		if name == "timedatectl" {
+			return []byte("no\n"), nil
+		}
+
+		return nil, errors.New("not found")
+	}
+
+	input.Gather()
+
+	if len(pusher.points) != 0 {
+		t.Fatalf("len(points) = %d before the first measure, want 0", len(pusher.points))
+	}
+
+	input.measure(context.Background())
+	input.Gather()
+
+	if len(pusher.points) != 1 {
+		t.Fatalf("len(points) = %d, want 1", len(pusher.points))
+	}
+
+	if got := pusher.points[0].Annotations.Status.CurrentStatus; got != types.StatusCritical {
+		t.Errorf("status = %v, want %v", got, types.StatusCritical)
+	}
+}
+
+func TestGatherOldMeasure(t *testing.T) {
+	pusher := &mockPusher{}
+	input := New(nil, pusher)
+	input.measuredAt = time.Now().Add(-maxMeasureAge - time.Minute)
+
+	input.Gather()
+
+	if len(pusher.points) != 0 {
+		t.Errorf("len(points) = %d, want 0", len(pusher.points))
+	}
+}
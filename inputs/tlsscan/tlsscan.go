@@ -0,0 +1,308 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tlsscan list the TLS protocol versions and weak cipher suites accepted by an endpoint.
+package tlsscan
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"glouton/types"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const handshakeTimeout = 5 * time.Second
+
+// ErrNotTLS is returned when the endpoint doesn't accept a TLS handshake.
+var ErrNotTLS = errors.New("endpoint doesn't speak TLS")
+
+//nolint:gochecknoglobals
+var versions = []struct {
+	id    uint16
+	name  string
+	value float64
+}{
+	{id: tls.VersionTLS10, name: "TLS 1.0", value: 1.0},
+	{id: tls.VersionTLS11, name: "TLS 1.1", value: 1.1},
+	{id: tls.VersionTLS12, name: "TLS 1.2", value: 1.2},
+	{id: tls.VersionTLS13, name: "TLS 1.3", value: 1.3},
+}
+
+// sslv3Value is the value of SSL 3.0 in tls_min_version. It's lower than all TLS versions.
+const sslv3Value = 0.3
+
+// Result is the outcome of the scan of one endpoint.
+type Result struct {
+	// Versions are the name of accepted protocol versions, from oldest to newest.
+	Versions []string
+	// MinVersion is the oldest accepted version, for example 1.1 for TLS 1.1 and 0.3 for SSL 3.0.
+	MinVersion float64
+	// NegotiatedVersion is the version negotiated with a client accepting all TLS versions.
+	NegotiatedVersion float64
+	// WeakCiphers are the name of accepted insecure cipher suites.
+	WeakCiphers []string
+}
+
+// Compliant returns whether only TLS 1.2 or later is accepted without weak cipher suites.
+func (r Result) Compliant() bool {
+	return r.MinVersion >= 1.2 && len(r.WeakCiphers) == 0
+}
+
+func handshake(ctx context.Context, address string, cfg *tls.Config) (tls.ConnectionState, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+
+	rawConn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+
+	defer rawConn.Close()
+
+	deadline, _ := ctx.Deadline()
+	_ = rawConn.SetDeadline(deadline)
+
+	conn := tls.Client(rawConn, cfg)
+	if err := conn.Handshake(); err != nil {
+		return tls.ConnectionState{}, err
+	}
+
+	return conn.ConnectionState(), nil
+}
+
+// Scan does one handshake per protocol version and list accepted weak cipher suites.
+// ErrNotTLS is returned if no handshake succeed.
+func Scan(ctx context.Context, address string) (Result, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return Result{}, err
+	}
+
+	baseConfig := &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec // only the protocol is checked, not the certificate.
+		ServerName:         host,
+	}
+
+	// A first handshake with all versions avoid many connections to non-TLS services.
+	probeConfig := baseConfig.Clone()
+	probeConfig.MinVersion = tls.VersionTLS10
+
+	state, err := handshake(ctx, address, probeConfig)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
+
+		return Result{}, ErrNotTLS
+	}
+
+	var result Result
+
+	if acceptSSLv3(ctx, address) {
+		result.Versions = append(result.Versions, "SSL 3.0")
+		result.MinVersion = sslv3Value
+	}
+
+	maxLegacyVersion := uint16(0)
+
+	for _, v := range versions {
+		cfg := baseConfig.Clone()
+		cfg.MinVersion = v.id
+		cfg.MaxVersion = v.id
+
+		if _, err := handshake(ctx, address, cfg); err != nil {
+			continue
+		}
+
+		result.Versions = append(result.Versions, v.name)
+
+		if result.MinVersion == 0 {
+			result.MinVersion = v.value
+		}
+
+		if v.id == state.Version {
+			result.NegotiatedVersion = v.value
+		}
+
+		if v.id <= tls.VersionTLS12 {
+			maxLegacyVersion = v.id
+		}
+	}
+
+	if len(result.Versions) == 0 {
+		return Result{}, ErrNotTLS
+	}
+
+	if maxLegacyVersion != 0 {
+		result.WeakCiphers = weakCiphers(ctx, address, baseConfig, maxLegacyVersion)
+	}
+
+	return result, ctx.Err()
+}
+
+// acceptSSLv3 returns whether the endpoint answers a SSL 3.0 ClientHello with a SSL 3.0 ServerHello.
+// crypto/tls no longer supports SSL 3.0, so the ClientHello is written by hand.
+func acceptSSLv3(ctx context.Context, address string) bool {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return false
+	}
+
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	// RSA cipher suites of SSL 3.0: AES-256, AES-128, 3DES and RC4.
+	cipherSuites := []byte{0x00, 0x35, 0x00, 0x2f, 0x00, 0x0a, 0x00, 0x05, 0x00, 0x04}
+
+	body := []byte{0x03, 0x00} // client_version
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0x00) // session_id length
+	body = append(body, 0x00, byte(len(cipherSuites)))
+	body = append(body, cipherSuites...)
+	body = append(body, 0x01, 0x00) // null compression
+
+	hello := []byte{0x01, 0x00, byte(len(body) >> 8), byte(len(body))}
+	hello = append(hello, body...)
+
+	record := []byte{0x16, 0x03, 0x00, byte(len(hello) >> 8), byte(len(hello))}
+	record = append(record, hello...)
+
+	if _, err := conn.Write(record); err != nil {
+		return false
+	}
+
+	// Record header followed by the handshake type.
+	reply := make([]byte, 6)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false
+	}
+
+	const (
+		contentTypeHandshake = 0x16
+		typeServerHello      = 0x02
+	)
+
+	return reply[0] == contentTypeHandshake && reply[1] == 0x03 && reply[2] == 0x00 && reply[5] == typeServerHello
+}
+
+// weakCiphers returns the insecure cipher suites accepted by the endpoint. Cipher suites
+// are only negotiable with TLS 1.2 and older. Each accepted suite is removed from
+// the offered list to find the next one.
+func weakCiphers(ctx context.Context, address string, baseConfig *tls.Config, version uint16) []string {
+	var (
+		offered []uint16
+		result  []string
+	)
+
+	for _, suite := range tls.InsecureCipherSuites() {
+		offered = append(offered, suite.ID)
+	}
+
+	for len(offered) > 0 && ctx.Err() == nil {
+		cfg := baseConfig.Clone()
+		cfg.MinVersion = tls.VersionTLS10
+		cfg.MaxVersion = version
+		cfg.CipherSuites = offered
+
+		state, err := handshake(ctx, address, cfg)
+		if err != nil {
+			break
+		}
+
+		result = append(result, tls.CipherSuiteName(state.CipherSuite))
+
+		for i, id := range offered {
+			if id == state.CipherSuite {
+				offered = append(offered[:i], offered[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// Points returns the tls_min_version metric and its status, and the tls_negotiated_version metric for a scan result.
+//
+// The status is warning when a version older than TLS 1.2 (including SSL 3.0) or a weak cipher suite is accepted.
+func Points(result Result, labels map[string]string, annotations types.MetricAnnotations, now time.Time) []types.MetricPoint {
+	status := types.StatusDescription{
+		CurrentStatus:     types.StatusOk,
+		StatusDescription: fmt.Sprintf("Accepted protocols: %s", strings.Join(result.Versions, ", ")),
+	}
+
+	if !result.Compliant() {
+		status.CurrentStatus = types.StatusWarning
+
+		if result.MinVersion < 1.2 {
+			status.StatusDescription = fmt.Sprintf("Protocols older than TLS 1.2 are accepted: %s", strings.Join(result.Versions, ", "))
+		}
+
+		if len(result.WeakCiphers) > 0 {
+			status.StatusDescription += fmt.Sprintf(". Weak cipher suites are accepted: %s", strings.Join(result.WeakCiphers, ", "))
+		}
+	}
+
+	valueLabels := make(map[string]string, len(labels)+1)
+	statusLabels := make(map[string]string, len(labels)+1)
+	negotiatedLabels := make(map[string]string, len(labels)+1)
+
+	for k, v := range labels {
+		valueLabels[k] = v
+		statusLabels[k] = v
+		negotiatedLabels[k] = v
+	}
+
+	valueLabels[types.LabelName] = "tls_min_version"
+	statusLabels[types.LabelName] = "tls_min_version_status"
+	negotiatedLabels[types.LabelName] = "tls_negotiated_version"
+
+	statusAnnotations := annotations
+	statusAnnotations.Status = status
+	statusAnnotations.StatusOf = "tls_min_version"
+
+	return []types.MetricPoint{
+		{
+			Labels:      valueLabels,
+			Annotations: annotations,
+			Point:       types.Point{Time: now, Value: result.MinVersion},
+		},
+		{
+			Labels:      statusLabels,
+			Annotations: statusAnnotations,
+			Point:       types.Point{Time: now, Value: float64(status.CurrentStatus.NagiosCode())},
+		},
+		{
+			Labels:      negotiatedLabels,
+			Annotations: annotations,
+			Point:       types.Point{Time: now, Value: result.NegotiatedVersion},
+		},
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlsscan
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"glouton/types"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tlsServer(cfg *tls.Config) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = cfg
+	server.StartTLS()
+
+	return server
+}
+
+func TestScan(t *testing.T) {
+	cases := []struct {
+		name          string
+		cfg           *tls.Config
+		wantMin       float64
+		wantWeak      bool
+		wantCompliant bool
+	}{
+		{
+			name:          "modern",
+			cfg:           &tls.Config{MinVersion: tls.VersionTLS12},
+			wantMin:       1.2,
+			wantCompliant: true,
+		},
+		{
+			name: "legacy",
+			cfg: &tls.Config{
+				MinVersion:   tls.VersionTLS10,
+				CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA},
+			},
+			wantMin:  1.0,
+			wantWeak: true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			server := tlsServer(c.cfg)
+			defer server.Close()
+
+			result, err := Scan(context.Background(), server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.MinVersion != c.wantMin {
+				t.Errorf("MinVersion = %v, want %v (versions %v)", result.MinVersion, c.wantMin, result.Versions)
+			}
+
+			if (len(result.WeakCiphers) > 0) != c.wantWeak {
+				t.Errorf("WeakCiphers = %v, want weak = %v", result.WeakCiphers, c.wantWeak)
+			}
+
+			if result.NegotiatedVersion != 1.3 {
+				t.Errorf("NegotiatedVersion = %v, want 1.3", result.NegotiatedVersion)
+			}
+
+			if result.Compliant() != c.wantCompliant {
+				t.Errorf("Compliant() = %v, want %v", result.Compliant(), c.wantCompliant)
+			}
+		})
+	}
+}
+
+func TestScanNotTLS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			_, _ = conn.Write([]byte("+OK ready\r\n"))
+			conn.Close()
+		}
+	}()
+
+	if _, err := Scan(context.Background(), listener.Addr().String()); !errors.Is(err, ErrNotTLS) {
+		t.Errorf("Scan() error = %v, want %v", err, ErrNotTLS)
+	}
+}
+
+func TestAcceptSSLv3(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			hello := make([]byte, 11)
+			if _, err := io.ReadFull(conn, hello); err == nil && hello[9] == 0x03 && hello[10] == 0x00 {
+				// Start of a SSL 3.0 ServerHello.
+				_, _ = conn.Write([]byte{0x16, 0x03, 0x00, 0x00, 0x4a, 0x02, 0x00, 0x00, 0x46, 0x03, 0x00})
+			}
+
+			conn.Close()
+		}
+	}()
+
+	if !acceptSSLv3(context.Background(), listener.Addr().String()) {
+		t.Error("acceptSSLv3() = false, want true")
+	}
+
+	server := tlsServer(&tls.Config{MinVersion: tls.VersionTLS10})
+	defer server.Close()
+
+	if acceptSSLv3(context.Background(), server.Listener.Addr().String()) {
+		t.Error("acceptSSLv3() = true on a TLS only server, want false")
+	}
+}
+
+func TestPoints(t *testing.T) {
+	result := Result{
+		Versions:    []string{"TLS 1.1", "TLS 1.2"},
+		MinVersion:  1.1,
+		WeakCiphers: []string{"TLS_RSA_WITH_3DES_EDE_CBC_SHA"},
+	}
+
+	points := Points(result, map[string]string{"item": "nginx:443"}, types.MetricAnnotations{BleemeoItem: "nginx:443"}, time.Now())
+
+	if len(points) != 3 {
+		t.Fatalf("len(points) = %d, want 3", len(points))
+	}
+
+	if points[0].Labels[types.LabelName] != "tls_min_version" || points[0].Value != 1.1 {
+		t.Errorf("points[0] = %v, want tls_min_version = 1.1", points[0])
+	}
+
+	status := points[1].Annotations.Status
+	if points[1].Labels[types.LabelName] != "tls_min_version_status" || status.CurrentStatus != types.StatusWarning {
+		t.Errorf("points[1] = %v, want a warning tls_min_version_status", points[1])
+	}
+
+	if points[2].Labels[types.LabelName] != "tls_negotiated_version" {
+		t.Errorf("points[2] = %v, want tls_negotiated_version", points[2])
+	}
+
+	if !strings.Contains(status.StatusDescription, "TLS_RSA_WITH_3DES_EDE_CBC_SHA") {
+		t.Errorf("StatusDescription = %#v, want the weak cipher listed", status.StatusDescription)
+	}
+}
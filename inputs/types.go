@@ -148,9 +148,13 @@ func (a *Accumulator) addMetrics(measurement string, fields map[string]interface
 }
 
 type CollectorConfig struct {
-	DFRootPath      string
-	DFPathBlacklist []string
-	NetIfBlacklist  []string
-	IODiskWhitelist []*regexp.Regexp
-	IODiskBlacklist []*regexp.Regexp
+	DFRootPath        string
+	DFPathBlacklist   []string
+	DFFSTypeBlacklist []string
+	DFDeviceBlacklist []string
+	DFTimeout         time.Duration
+	DFCooldown        time.Duration
+	NetIfBlacklist    []string
+	IODiskWhitelist   []*regexp.Regexp
+	IODiskBlacklist   []*regexp.Regexp
 }
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"glouton/inputs"
 	"glouton/inputs/internal"
+	"glouton/types"
 	"math"
 	"sort"
 	"strconv"
@@ -42,6 +43,8 @@ const (
 	swapModuleName      string = "win_swap"
 	processorModuleName string = "win_processor"
 	systemModuleName    string = "win_system"
+	tcpModuleName       string = "win_tcp"
+	iisModuleName       string = "win_iis"
 )
 
 const config string = `
@@ -63,6 +66,7 @@ const config string = `
     Instances = ["*"]
     Counters = [
       "% Idle Time",
+      "Avg. Disk Queue Length",
     ]
     IncludeTotal = true
     Measurement = "win_diskio"
@@ -89,7 +93,27 @@ const config string = `
       "% Usage",
     ]
     Instances = ["_Total"]
-    Measurement = "win_swap"`
+    Measurement = "win_swap"
+
+  [[inputs.win_perf_counters.object]]
+    ObjectName = "TCPv4"
+    Counters = [
+      "Connections Established",
+      "Segments Retransmitted/sec",
+    ]
+    Instances = ["------"]
+    Measurement = "win_tcp"
+
+  [[inputs.win_perf_counters.object]]
+    # Only present when IIS is installed, missing objects are ignored.
+    ObjectName = "Web Service"
+    Counters = [
+      "Current Connections",
+      "Total Method Requests/sec",
+      "Bytes Total/sec",
+    ]
+    Instances = ["_Total"]
+    Measurement = "win_iis"`
 
 type winCollector struct {
 	option      inputs.CollectorConfig
@@ -165,6 +189,16 @@ func New(inputsConfig inputs.CollectorConfig) (result telegraf.Input, err error)
 			TransformMetrics: option.transformMetrics,
 			RenameMetrics:    option.renameMetrics,
 			RenameGlobal:     option.renameGlobal,
+			Units: map[string]string{
+				"io_utilization": types.UnitPercent,
+				"mem_available":  types.UnitBytes,
+				"mem_used":       types.UnitBytes,
+				"mem_cached":     types.UnitBytes,
+				"mem_free":       types.UnitBytes,
+				"mem_used_perc":  types.UnitPercent,
+				"swap_used_perc": types.UnitPercent,
+				"iis_bytes":      types.UnitBytes,
+			},
 		},
 	}
 
@@ -241,6 +275,34 @@ func (c *winCollector) transformMetrics(originalContext internal.GatherContext,
 			// utilization is 100% when we spent 1000ms during one second
 			res["time"] = res["utilization"] * 1000. / 100.
 		}
+
+		if val, present := fields["Avg._Disk_Queue_Length"]; present {
+			res["queue_depth"] = val
+		}
+	}
+
+	if currentContext.Measurement == tcpModuleName {
+		if val, present := fields["Connections_Established"]; present {
+			res["established"] = val
+		}
+
+		if val, present := fields["Segments_Retransmitted_persec"]; present {
+			res["retransmitted_segments"] = val
+		}
+	}
+
+	if currentContext.Measurement == iisModuleName {
+		if val, present := fields["Current_Connections"]; present {
+			res["connections"] = val
+		}
+
+		if val, present := fields["Total_Method_Requests_persec"]; present {
+			res["requests"] = val
+		}
+
+		if val, present := fields["Bytes_Total_persec"]; present {
+			res["bytes"] = val
+		}
 	}
 
 	if currentContext.Measurement == memModuleName {
@@ -304,6 +366,10 @@ func (c winCollector) renameMetrics(originalContext internal.GatherContext, curr
 		newMeasurement = "swap"
 	case processorModuleName:
 		newMeasurement = "system"
+	case tcpModuleName:
+		newMeasurement = "tcp"
+	case iisModuleName:
+		newMeasurement = "iis"
 	}
 
 	return newMeasurement, metricName
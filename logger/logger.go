@@ -29,7 +29,10 @@ import (
 )
 
 // Logger allow to print message.
-type Logger bool
+type Logger struct {
+	enabled bool
+	level   int
+}
 
 // V return a Level which will only log (Printf do something) if logger is configured to log this level.
 // 0 is always logger.
@@ -38,7 +41,7 @@ func V(level int) Logger {
 	defer cfg.l.Unlock()
 
 	if level <= cfg.level {
-		return Logger(true)
+		return Logger{enabled: true, level: level}
 	}
 
 	if _, file, _, ok := runtime.Caller(1); ok {
@@ -46,23 +49,23 @@ func V(level int) Logger {
 		// We only want package
 		part := strings.Split(file, "/")
 		if len(part) < 2 {
-			return Logger(false)
+			return Logger{level: level}
 		}
 
 		pkg := part[len(part)-2]
 
 		if level <= cfg.pkgLevels[pkg] {
-			return Logger(true)
+			return Logger{enabled: true, level: level}
 		}
 	}
 
-	return Logger(false)
+	return Logger{level: level}
 }
 
 // Printf behave like fmt.Printf.
 func (l Logger) Printf(fmtArg string, a ...interface{}) {
-	if l {
-		printf(fmtArg, a...)
+	if l.enabled {
+		printf(l.level, fmtArg, a...)
 	} else {
 		fmt.Fprintf(logBuffer, fmtArg+"\n", a...)
 	}
@@ -70,43 +73,50 @@ func (l Logger) Printf(fmtArg string, a ...interface{}) {
 
 // Println behave like fmt.Println.
 func (l Logger) Println(v ...interface{}) {
-	if l {
-		println(v...)
+	if l.enabled {
+		println(l.level, v...)
 	} else {
 		fmt.Fprintln(logBuffer, v...)
 	}
 }
 
-func printf(fmtArg string, a ...interface{}) {
-	cfg.l.Lock()
-	defer cfg.l.Unlock()
-
-	if !cfg.useSyslog {
-		_, _ = fmt.Fprintf(cfg.writer, "%s ", time.Now().Format("2006/01/02 15:04:05"))
-	}
+func printf(level int, fmtArg string, a ...interface{}) {
+	write(level, fmt.Sprintf(fmtArg+"\n", a...))
+}
 
-	_, _ = fmt.Fprintf(cfg.teeWriter, fmtArg+"\n", a...)
+func println(level int, v ...interface{}) {
+	write(level, fmt.Sprintln(v...))
 }
 
-func println(v ...interface{}) {
+func write(level int, msg string) {
+	now := time.Now()
+
 	cfg.l.Lock()
 	defer cfg.l.Unlock()
 
 	if !cfg.useSyslog {
-		_, _ = fmt.Fprintf(cfg.writer, "%s ", time.Now().Format("2006/01/02 15:04:05"))
+		_, _ = fmt.Fprintf(cfg.writer, "%s ", now.Format("2006/01/02 15:04:05"))
 	}
 
-	_, _ = fmt.Fprintln(cfg.teeWriter, v...)
+	_, _ = io.WriteString(cfg.teeWriter, msg)
+
+	logEntries.add(Entry{
+		Time:    now,
+		Level:   level,
+		Message: strings.TrimSuffix(msg, "\n"),
+	})
 }
 
 // Printf behave like fmt.Printf.
 func Printf(fmt string, a ...interface{}) {
-	printf(fmt, a...)
+	printf(0, fmt, a...)
 }
 
 type config struct {
 	l         sync.Mutex
 	level     int
+	baseLevel int
+	revert    *time.Timer
 	pkgLevels map[string]int
 	useSyslog bool
 
@@ -116,8 +126,9 @@ type config struct {
 
 //nolint:gochecknoglobals
 var (
-	logBuffer = &buffer{}
-	cfg       = config{
+	logBuffer  = &buffer{}
+	logEntries = &ring{}
+	cfg        = config{
 		writer:    os.Stderr,
 		teeWriter: io.MultiWriter(logBuffer, os.Stderr),
 	}
@@ -181,12 +192,58 @@ func SetBufferCapacity(headSize int, tailSize int) {
 	logBuffer.SetCapacity(headSize, tailSize)
 }
 
+// SetRingSize define the number of entries kept by the ring buffer returned by Entries.
+// Changing the size drop all entries.
+func SetRingSize(size int) {
+	logEntries.SetSize(size)
+}
+
+// Entries return the last log entries, oldest first.
+func Entries() []Entry {
+	return logEntries.Entries()
+}
+
 // SetLevel configure the log level.
 func SetLevel(level int) {
 	cfg.l.Lock()
 	defer cfg.l.Unlock()
 
+	if cfg.revert != nil {
+		cfg.revert.Stop()
+		cfg.revert = nil
+	}
+
 	cfg.level = level
+	cfg.baseLevel = level
+}
+
+// SetLevelFor configure the log level for the given duration. After it, the level
+// configured by SetLevel is restored.
+func SetLevelFor(level int, duration time.Duration) {
+	cfg.l.Lock()
+	defer cfg.l.Unlock()
+
+	if cfg.revert != nil {
+		cfg.revert.Stop()
+	}
+
+	cfg.level = level
+
+	var timer *time.Timer
+
+	timer = time.AfterFunc(duration, func() {
+		cfg.l.Lock()
+		defer cfg.l.Unlock()
+
+		if cfg.revert != timer {
+			return
+		}
+
+		cfg.level = cfg.baseLevel
+		cfg.revert = nil
+	})
+
+	cfg.revert = timer
 }
 
 // SetPkgLevels configure the log level per package.
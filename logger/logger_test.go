@@ -0,0 +1,22 @@
+package logger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetLevelFor(t *testing.T) {
+	SetLevel(0)
+
+	SetLevelFor(2, 10*time.Millisecond)
+
+	if !V(2).enabled {
+		t.Error("V(2) is disabled, want enabled during SetLevelFor duration")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if V(2).enabled {
+		t.Error("V(2) is enabled, want the level reverted")
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitWindow = time.Minute
+	// maxLimitedMessages is the number of distinct messages above which the expired
+	// messages are forgotten.
+	maxLimitedMessages = 1000
+	// maxRecurringErrors is the number of distinct errors counted by TopErrors.
+	maxRecurringErrors = 100
+)
+
+// ErrorCount is an error message with the number of times it was logged.
+type ErrorCount struct {
+	Message   string    `json:"message"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type limitedMessage struct {
+	logger      Logger
+	windowStart time.Time
+	suppressed  int
+}
+
+// limiter drops identical messages logged within rateLimitWindow and counts recurring errors.
+type limiter struct {
+	l         sync.Mutex
+	messages  map[string]*limitedMessage
+	errors    map[string]*ErrorCount
+	lastSweep time.Time
+}
+
+type limitedLine struct {
+	logger Logger
+	msg    string
+}
+
+// allow returns the lines to write for msg: msg itself unless it was already logged
+// in the current window, and the summary of the messages whose window expired.
+func (lim *limiter) allow(l Logger, msg string, now time.Time) []limitedLine {
+	lim.l.Lock()
+	defer lim.l.Unlock()
+
+	if lim.messages == nil {
+		lim.messages = make(map[string]*limitedMessage)
+	}
+
+	var lines []limitedLine
+
+	if now.Sub(lim.lastSweep) >= rateLimitWindow || len(lim.messages) >= maxLimitedMessages {
+		lines = lim.sweep(now, msg)
+	}
+
+	m, ok := lim.messages[msg]
+
+	switch {
+	case !ok:
+		lim.messages[msg] = &limitedMessage{logger: l, windowStart: now}
+		lines = append(lines, limitedLine{logger: l, msg: msg})
+	case now.Sub(m.windowStart) >= rateLimitWindow:
+		lines = append(lines, limitedLine{logger: l, msg: repeatedMessage(msg, m.suppressed)})
+		m.logger = l
+		m.windowStart = now
+		m.suppressed = 0
+	default:
+		m.suppressed++
+	}
+
+	return lines
+}
+
+// sweep forgets the messages whose window expired and returns the summary of the ones
+// which were suppressed. The message being logged is skipped, allow takes care of it.
+func (lim *limiter) sweep(now time.Time, current string) []limitedLine {
+	var lines []limitedLine
+
+	for msg, m := range lim.messages {
+		if msg == current || now.Sub(m.windowStart) < rateLimitWindow {
+			continue
+		}
+
+		if m.suppressed > 0 {
+			lines = append(lines, limitedLine{logger: m.logger, msg: repeatedMessage(msg, m.suppressed)})
+		}
+
+		delete(lim.messages, msg)
+	}
+
+	lim.lastSweep = now
+
+	return lines
+}
+
+func repeatedMessage(msg string, suppressed int) string {
+	if suppressed == 0 {
+		return msg
+	}
+
+	return fmt.Sprintf("%s (repeated %d times in last minute)", msg, suppressed)
+}
+
+// addError counts an occurrence of the error message. When too many distinct errors
+// are known, the one not seen for the longest time is forgotten.
+func (lim *limiter) addError(msg string, now time.Time) {
+	lim.l.Lock()
+	defer lim.l.Unlock()
+
+	if lim.errors == nil {
+		lim.errors = make(map[string]*ErrorCount)
+	}
+
+	if e, ok := lim.errors[msg]; ok {
+		e.Count++
+		e.LastSeen = now
+
+		return
+	}
+
+	if len(lim.errors) >= maxRecurringErrors {
+		var oldest *ErrorCount
+
+		for _, e := range lim.errors {
+			if oldest == nil || e.LastSeen.Before(oldest.LastSeen) {
+				oldest = e
+			}
+		}
+
+		delete(lim.errors, oldest.Message)
+	}
+
+	lim.errors[msg] = &ErrorCount{
+		Message:   msg,
+		Count:     1,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+}
+
+func (lim *limiter) topErrors(n int) []ErrorCount {
+	lim.l.Lock()
+	defer lim.l.Unlock()
+
+	result := make([]ErrorCount, 0, len(lim.errors))
+
+	for _, e := range lim.errors {
+		result = append(result, *e)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+
+		return result[i].LastSeen.After(result[j].LastSeen)
+	})
+
+	if n >= 0 && len(result) > n {
+		result = result[:n]
+	}
+
+	return result
+}
+
+func (lim *limiter) printf(l Logger, isError bool, fmtArg string, a ...interface{}) {
+	now := time.Now()
+	msg := fmt.Sprintf(fmtArg, a...)
+
+	if isError {
+		lim.addError(msg, now)
+	}
+
+	for _, line := range lim.allow(l, msg, now) {
+		line.logger.Printf("%s", line.msg)
+	}
+}
+
+// PrintfLimited behave like Printf, but an identical message is logged only once per minute.
+// The next time it's logged, the number of times it was dropped is added to the message.
+func (l Logger) PrintfLimited(fmtArg string, a ...interface{}) {
+	limitedLogs.printf(l, false, fmtArg, a...)
+}
+
+// ErrorfLimited behave like PrintfLimited and also counts the message in TopErrors.
+// It should be used for errors which may occur on each gather.
+func (l Logger) ErrorfLimited(fmtArg string, a ...interface{}) {
+	limitedLogs.printf(l, true, fmtArg, a...)
+}
+
+// PrintfLimited behave like Printf, but an identical message is logged only once per minute.
+func PrintfLimited(fmtArg string, a ...interface{}) {
+	limitedLogs.printf(Logger{enabled: true}, false, fmtArg, a...)
+}
+
+// ErrorfLimited behave like PrintfLimited and also counts the message in TopErrors.
+func ErrorfLimited(fmtArg string, a ...interface{}) {
+	limitedLogs.printf(Logger{enabled: true}, true, fmtArg, a...)
+}
+
+// TopErrors returns the n errors logged with ErrorfLimited the most times.
+// A negative n returns all of them.
+func TopErrors(n int) []ErrorCount {
+	return limitedLogs.topErrors(n)
+}
+
+//nolint:gochecknoglobals
+var limitedLogs = &limiter{}
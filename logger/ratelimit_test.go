@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"testing"
+	"time"
+)
+
+func lineMessages(lines []limitedLine) []string {
+	result := make([]string, 0, len(lines))
+
+	for _, l := range lines {
+		result = append(result, l.msg)
+	}
+
+	return result
+}
+
+func TestLimiterAllow(t *testing.T) {
+	lim := &limiter{}
+	t0 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		at   time.Duration
+		msg  string
+		want []string
+	}{
+		{at: 0, msg: "input failed", want: []string{"input failed"}},
+		{at: 10 * time.Second, msg: "input failed", want: nil},
+		{at: 20 * time.Second, msg: "other error", want: []string{"other error"}},
+		{at: 30 * time.Second, msg: "input failed", want: nil},
+		{at: 61 * time.Second, msg: "input failed", want: []string{"input failed (repeated 2 times in last minute)"}},
+		{at: 62 * time.Second, msg: "input failed", want: nil},
+		// "other error" wasn't repeated, its expiration doesn't log anything.
+		{at: 130 * time.Second, msg: "third error", want: []string{"input failed (repeated 1 times in last minute)", "third error"}},
+		{at: 131 * time.Second, msg: "input failed", want: []string{"input failed"}},
+	}
+
+	for i, step := range steps {
+		got := lineMessages(lim.allow(Logger{enabled: true}, step.msg, t0.Add(step.at)))
+
+		if len(got) != len(step.want) {
+			t.Fatalf("step %d: allow() = %v, want %v", i, got, step.want)
+		}
+
+		for j := range got {
+			if got[j] != step.want[j] {
+				t.Errorf("step %d: allow()[%d] = %#v, want %#v", i, j, got[j], step.want[j])
+			}
+		}
+	}
+}
+
+func TestLimiterTopErrors(t *testing.T) {
+	lim := &limiter{}
+	t0 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		lim.addError("error A", t0.Add(time.Duration(i)*time.Second))
+	}
+
+	lim.addError("error B", t0)
+	lim.addError("error C", t0.Add(time.Second))
+
+	got := lim.topErrors(2)
+	if len(got) != 2 {
+		t.Fatalf("len(topErrors(2)) = %d, want 2", len(got))
+	}
+
+	if got[0].Message != "error A" || got[0].Count != 3 {
+		t.Errorf("topErrors(2)[0] = %v, want error A seen 3 times", got[0])
+	}
+
+	if !got[0].FirstSeen.Equal(t0) || !got[0].LastSeen.Equal(t0.Add(2*time.Second)) {
+		t.Errorf("topErrors(2)[0] seen from %v to %v, want %v to %v", got[0].FirstSeen, got[0].LastSeen, t0, t0.Add(2*time.Second))
+	}
+
+	// On equal count, the most recent error comes first.
+	if got[1].Message != "error C" {
+		t.Errorf("topErrors(2)[1].Message = %#v, want \"error C\"", got[1].Message)
+	}
+
+	for i := 0; i < maxRecurringErrors; i++ {
+		lim.addError(string(rune('a'+i%26))+string(rune('a'+i/26)), t0.Add(time.Minute))
+	}
+
+	if got := lim.topErrors(-1); len(got) != maxRecurringErrors {
+		t.Errorf("len(topErrors(-1)) = %d, want %d", len(got), maxRecurringErrors)
+	}
+
+	for _, e := range lim.topErrors(-1) {
+		if e.Message == "error B" {
+			t.Errorf("error B should have been forgotten")
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultRingSize = 1000
+
+// Entry is a log message kept in the ring buffer.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   int       `json:"level"`
+	Message string    `json:"message"`
+}
+
+// ring keeps the last log entries.
+type ring struct {
+	l       sync.Mutex
+	entries []Entry
+	index   int
+	size    int
+}
+
+func (r *ring) SetSize(size int) {
+	if size <= 0 {
+		V(1).Printf("invalid size for log ring buffer: %d", size)
+		return
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.entries = nil
+	r.index = 0
+	r.size = size
+}
+
+func (r *ring) add(entry Entry) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if r.size == 0 {
+		r.size = defaultRingSize
+	}
+
+	if len(r.entries) < r.size {
+		r.entries = append(r.entries, entry)
+		return
+	}
+
+	r.entries[r.index] = entry
+	r.index = (r.index + 1) % r.size
+}
+
+func (r *ring) Entries() []Entry {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	result := make([]Entry, 0, len(r.entries))
+	result = append(result, r.entries[r.index:]...)
+	result = append(result, r.entries[:r.index]...)
+
+	return result
+}
@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_ring(t *testing.T) {
+	r := &ring{}
+	r.SetSize(3)
+
+	for i := 0; i < 5; i++ {
+		r.add(Entry{Level: i % 2, Message: fmt.Sprintf("line %d", i)})
+	}
+
+	got := r.Entries()
+	want := []string{"line 2", "line 3", "line 4"}
+
+	if len(got) != len(want) {
+		t.Fatalf("len(Entries()) = %d, want %d", len(got), len(want))
+	}
+
+	for i, w := range want {
+		if got[i].Message != w {
+			t.Errorf("Entries()[%d].Message = %#v, want %#v", i, got[i].Message, w)
+		}
+	}
+
+	if got[1].Level != 1 {
+		t.Errorf("Entries()[1].Level = %d, want 1", got[1].Level)
+	}
+
+	r.SetSize(10)
+
+	if got := r.Entries(); len(got) != 0 {
+		t.Errorf("Entries() = %v, want empty after SetSize", got)
+	}
+}
+
+func TestLoggerLevelInEntries(t *testing.T) {
+	SetLevel(1)
+	defer SetLevel(0)
+
+	V(1).Printf("verbose %s", "message")
+	V(2).Printf("debug message")
+
+	entries := Entries()
+	last := entries[len(entries)-1]
+
+	if last.Message != "verbose message" || last.Level != 1 {
+		t.Errorf("last entry = %v, want the verbose message with level 1", last)
+	}
+}
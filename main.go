@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"glouton/agent"
 	versionPkg "glouton/version"
+	"os"
 	"strings"
 
 	_ "net/http/pprof" //nolint: gosec
@@ -30,6 +31,8 @@ import (
 var (
 	configFiles = flag.String("config", "", "Configuration files/dirs to load.")
 	showVersion = flag.Bool("version", false, "Show version and exit")
+	diagnostic  = flag.String("diagnostic", "", "Write the diagnostic archive of the running Glouton to this file and exit")
+	validate    = flag.Bool("validate-config", false, "Check the configuration files and exit. Exit code is non-zero if issues are found")
 )
 
 //nolint: gochecknoglobals
@@ -54,6 +57,38 @@ func main() {
 		return
 	}
 
+	if *diagnostic != "" {
+		if err := agent.Diagnostic(strings.Split(*configFiles, ","), *diagnostic); err != nil {
+			fmt.Printf("Unable to create the diagnostic archive: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Diagnostic archive written to %s\n", *diagnostic)
+
+		return
+	}
+
+	if *validate {
+		issues, err := agent.ValidateConfig(strings.Split(*configFiles, ","))
+		if err != nil {
+			fmt.Printf("Unable to validate the configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+
+		if len(issues) > 0 {
+			fmt.Printf("%d issue(s) found in the configuration\n", len(issues))
+			os.Exit(1)
+		}
+
+		fmt.Println("The configuration is valid")
+
+		return
+	}
+
 	// run os-specific initialisation codd
 	OSDependentMain()
 
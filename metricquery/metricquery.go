@@ -0,0 +1,238 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metricquery answers NRPE and Zabbix queries from the metrics in the store.
+package metricquery
+
+import (
+	"errors"
+	"fmt"
+	"glouton/threshold"
+	"glouton/types"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// maxPointAge is the age after which the last point of a metric is considered stale.
+const maxPointAge = 5 * time.Minute
+
+// NRPECommand is the NRPE command answered from the store. Its arguments are the metric
+// name, the warning and critical thresholds and optionally the item, e.g.
+// "check_glouton!cpu_used!80!90" or "check_glouton!disk_used_perc!80!90!/home".
+const NRPECommand = "check_glouton"
+
+// ZabbixKey is the Zabbix item key answered from the store, e.g. "glouton.metric[cpu_used]"
+// or "glouton.metric[disk_used_perc,/home]".
+const ZabbixKey = "glouton.metric"
+
+var (
+	errNoMetric     = errors.New("no metric matches")
+	errManyMetrics  = errors.New("several metrics match, an item is required")
+	errNoPoint      = errors.New("the metric has no recent point")
+	errMissingName  = errors.New("the metric name is required")
+	errInvalidValue = errors.New("invalid threshold")
+)
+
+type metricStore interface {
+	MetricsMatching(matchers []*labels.Matcher) []types.Metric
+}
+
+type thresholdRegistry interface {
+	GetThreshold(key threshold.MetricNameItem) threshold.Threshold
+	FormatValue(key threshold.MetricNameItem, annotatedUnit string, value float64) string
+}
+
+// Querier reads the last value of metrics and evaluates their thresholds.
+type Querier struct {
+	store     metricStore
+	threshold thresholdRegistry
+	now       func() time.Time
+}
+
+// New returns a Querier.
+func New(store metricStore, threshold thresholdRegistry) *Querier {
+	return &Querier{
+		store:     store,
+		threshold: threshold,
+		now:       time.Now,
+	}
+}
+
+// NRPEResponse answers the check_glouton NRPE command. args are the command arguments,
+// without the command name. Empty warning and critical use the thresholds of the metric.
+func (q *Querier) NRPEResponse(args []string) (string, int16, error) {
+	if len(args) == 0 || args[0] == "" {
+		return "", 0, errMissingName
+	}
+
+	name := args[0]
+	item := ""
+
+	if len(args) > 3 {
+		item = args[3]
+	}
+
+	metric, point, err := q.lastPoint(name, item)
+	if err != nil {
+		return fmt.Sprintf("UNKNOWN - %s: %v", name, err), int16(types.StatusUnknown.NagiosCode()), nil
+	}
+
+	key := threshold.MetricNameItem{Name: name, Item: metric.Annotations().BleemeoItem}
+	thresh := q.threshold.GetThreshold(key)
+
+	for i, value := range []*float64{&thresh.HighWarning, &thresh.HighCritical} {
+		if len(args) <= i+1 || args[i+1] == "" {
+			continue
+		}
+
+		parsed, err := strconv.ParseFloat(args[i+1], 64)
+		if err != nil {
+			return "", 0, fmt.Errorf("%w %#v", errInvalidValue, args[i+1])
+		}
+
+		*value = parsed
+	}
+
+	status, limit := thresh.CurrentStatus(point.Value)
+	unit := metric.Annotations().Unit
+	output := fmt.Sprintf("%s - %s: %s", statusText(status), name, q.threshold.FormatValue(key, unit, point.Value))
+
+	if status != types.StatusOk {
+		output += fmt.Sprintf(" (threshold %s exceeded)", q.threshold.FormatValue(key, unit, limit))
+	}
+
+	output += "|" + perfData(name, point.Value, unit, thresh)
+
+	return output, int16(status.NagiosCode()), nil
+}
+
+// ZabbixResponse answers the glouton.metric Zabbix key. args are the key parameters:
+// the metric name and optionally the item.
+func (q *Querier) ZabbixResponse(args []string) (string, error) {
+	if len(args) == 0 || args[0] == "" {
+		return "", errMissingName
+	}
+
+	item := ""
+	if len(args) > 1 {
+		item = args[1]
+	}
+
+	_, point, err := q.lastPoint(args[0], item)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatFloat(point.Value, 'f', -1, 64), nil
+}
+
+// lastPoint returns the metric with the given name and item and its last point.
+// Without item, the metric must be the only one with this name or the one without item.
+func (q *Querier) lastPoint(name string, item string) (types.Metric, types.Point, error) {
+	metrics := q.store.MetricsMatching([]*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, types.LabelName, name),
+	})
+
+	var found types.Metric
+
+	for _, m := range metrics {
+		if m.Annotations().BleemeoItem != item {
+			continue
+		}
+
+		found = m
+
+		break
+	}
+
+	if found == nil && item == "" && len(metrics) > 1 {
+		return nil, types.Point{}, errManyMetrics
+	}
+
+	if found == nil && item == "" && len(metrics) == 1 {
+		found = metrics[0]
+	}
+
+	if found == nil {
+		return nil, types.Point{}, errNoMetric
+	}
+
+	now := q.now()
+
+	points, err := found.Points(now.Add(-maxPointAge), now)
+	if err != nil {
+		return nil, types.Point{}, err
+	}
+
+	if len(points) == 0 {
+		return nil, types.Point{}, errNoPoint
+	}
+
+	return found, points[len(points)-1], nil
+}
+
+func statusText(status types.Status) string {
+	switch status {
+	case types.StatusOk:
+		return "OK"
+	case types.StatusWarning:
+		return "WARNING"
+	case types.StatusCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// perfData returns the Nagios performance data of a value: 'label'=value[UOM];[warn];[crit].
+func perfData(name string, value float64, unit string, thresh threshold.Threshold) string {
+	uom := ""
+
+	switch unit {
+	case types.UnitPercent:
+		uom = "%"
+	case types.UnitBytes:
+		uom = "B"
+	case types.UnitSeconds:
+		uom = "s"
+	}
+
+	parts := []string{
+		fmt.Sprintf("'%s'=%s%s", strings.ReplaceAll(name, "'", "''"), strconv.FormatFloat(value, 'f', -1, 64), uom),
+		perfDataRange(thresh.LowWarning, thresh.HighWarning),
+		perfDataRange(thresh.LowCritical, thresh.HighCritical),
+	}
+
+	return strings.TrimRight(strings.Join(parts, ";"), ";")
+}
+
+// perfDataRange returns a Nagios range: the value is ok between low and high.
+func perfDataRange(low float64, high float64) string {
+	switch {
+	case math.IsNaN(low) && math.IsNaN(high):
+		return ""
+	case math.IsNaN(low):
+		return strconv.FormatFloat(high, 'f', -1, 64)
+	case math.IsNaN(high):
+		return strconv.FormatFloat(low, 'f', -1, 64) + ":"
+	default:
+		return strconv.FormatFloat(low, 'f', -1, 64) + ":" + strconv.FormatFloat(high, 'f', -1, 64)
+	}
+}
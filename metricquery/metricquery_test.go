@@ -0,0 +1,137 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricquery
+
+import (
+	"glouton/store"
+	"glouton/threshold"
+	"glouton/types"
+	"math"
+	"testing"
+	"time"
+)
+
+type fakeState struct{}
+
+func (fakeState) Get(key string, result interface{}) error {
+	return nil
+}
+
+func (fakeState) Set(key string, object interface{}) error {
+	return nil
+}
+
+func newQuerier(t0 time.Time) *Querier {
+	db := store.New()
+	db.PushPoints([]types.MetricPoint{
+		{
+			Labels:      map[string]string{types.LabelName: "cpu_used"},
+			Annotations: types.MetricAnnotations{Unit: types.UnitPercent},
+			Point:       types.Point{Time: t0, Value: 85.5},
+		},
+		{
+			Labels:      map[string]string{types.LabelName: "disk_used_perc", "mountpoint": "/"},
+			Annotations: types.MetricAnnotations{BleemeoItem: "/", Unit: types.UnitPercent},
+			Point:       types.Point{Time: t0, Value: 40},
+		},
+		{
+			Labels:      map[string]string{types.LabelName: "disk_used_perc", "mountpoint": "/home"},
+			Annotations: types.MetricAnnotations{BleemeoItem: "/home", Unit: types.UnitPercent},
+			Point:       types.Point{Time: t0, Value: 95},
+		},
+	})
+
+	registry := threshold.New(fakeState{})
+	registry.SetThresholds(nil, map[string]threshold.Threshold{
+		"disk_used_perc": {LowCritical: math.NaN(), LowWarning: math.NaN(), HighWarning: 80, HighCritical: 90},
+	})
+
+	q := New(db, registry)
+	q.now = func() time.Time { return t0.Add(time.Minute) }
+
+	return q
+}
+
+func TestNRPEResponse(t *testing.T) {
+	t0 := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	q := newQuerier(t0)
+
+	cases := []struct {
+		args       []string
+		wantOutput string
+		wantCode   int16
+	}{
+		{
+			args:       []string{"cpu_used", "80", "90"},
+			wantOutput: "WARNING - cpu_used: 85.50 % (threshold 80.00 % exceeded)|'cpu_used'=85.5%;80;90",
+			wantCode:   1,
+		},
+		{
+			args:       []string{"cpu_used"},
+			wantOutput: "OK - cpu_used: 85.50 %|'cpu_used'=85.5%",
+			wantCode:   0,
+		},
+		{
+			args:       []string{"disk_used_perc", "", "", "/home"},
+			wantOutput: "CRITICAL - disk_used_perc: 95.00 % (threshold 90.00 % exceeded)|'disk_used_perc'=95%;80;90",
+			wantCode:   2,
+		},
+		{
+			args:       []string{"disk_used_perc"},
+			wantOutput: "UNKNOWN - disk_used_perc: several metrics match, an item is required",
+			wantCode:   3,
+		},
+		{
+			args:       []string{"does_not_exist"},
+			wantOutput: "UNKNOWN - does_not_exist: no metric matches",
+			wantCode:   3,
+		},
+	}
+
+	for _, c := range cases {
+		output, code, err := q.NRPEResponse(c.args)
+		if err != nil {
+			t.Errorf("NRPEResponse(%v) failed: %v", c.args, err)
+
+			continue
+		}
+
+		if output != c.wantOutput || code != c.wantCode {
+			t.Errorf("NRPEResponse(%v) = %#v, %d, want %#v, %d", c.args, output, code, c.wantOutput, c.wantCode)
+		}
+	}
+
+	if _, _, err := q.NRPEResponse([]string{"cpu_used", "eighty"}); err == nil {
+		t.Errorf("NRPEResponse() succeeded with an invalid threshold")
+	}
+}
+
+func TestZabbixResponse(t *testing.T) {
+	t0 := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	q := newQuerier(t0)
+
+	got, err := q.ZabbixResponse([]string{"disk_used_perc", "/"})
+	if err != nil || got != "40" {
+		t.Errorf("ZabbixResponse() = %#v, %v, want \"40\"", got, err)
+	}
+
+	q.now = func() time.Time { return t0.Add(time.Hour) }
+
+	if _, err := q.ZabbixResponse([]string{"cpu_used"}); err == nil {
+		t.Errorf("ZabbixResponse() succeeded for a stale metric")
+	}
+}
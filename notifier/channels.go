@@ -0,0 +1,387 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"glouton/types"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+)
+
+// Webhook formats.
+const (
+	FormatGeneric   = "generic"
+	FormatSlack     = "slack"
+	FormatPagerDuty = "pagerduty"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+var errUnknownFormat = errors.New("unknown webhook format")
+
+// Title returns a one line summary of the notification, for example
+// "[CRITICAL] disk_used_perc (/home): Current value: 95.00 %".
+func (n Notification) Title() string {
+	state := strings.ToUpper(n.CurrentStatus.String())
+
+	if n.Resolved {
+		state = "RESOLVED"
+	}
+
+	name := n.Labels[types.LabelName]
+
+	if n.Item != "" {
+		name = fmt.Sprintf("%s (%s)", name, n.Item)
+	}
+
+	if n.StatusDescription == "" {
+		return fmt.Sprintf("[%s] %s", state, name)
+	}
+
+	return fmt.Sprintf("[%s] %s: %s", state, name, n.StatusDescription)
+}
+
+type genericPayload struct {
+	Metric            string            `json:"metric"`
+	Item              string            `json:"item"`
+	Labels            map[string]string `json:"labels"`
+	Status            string            `json:"status"`
+	PreviousStatus    string            `json:"previous_status"`
+	StatusDescription string            `json:"status_description"`
+	Time              time.Time         `json:"time"`
+	Resolved          bool              `json:"resolved"`
+	Renotification    bool              `json:"renotification"`
+}
+
+func (n Notification) genericPayload() genericPayload {
+	return genericPayload{
+		Metric:            n.Labels[types.LabelName],
+		Item:              n.Item,
+		Labels:            n.Labels,
+		Status:            n.CurrentStatus.String(),
+		PreviousStatus:    n.PreviousStatus.String(),
+		StatusDescription: n.StatusDescription,
+		Time:              n.Time,
+		Resolved:          n.Resolved,
+		Renotification:    n.Renotification,
+	}
+}
+
+// Webhook send notifications as JSON in a POST request.
+type Webhook struct {
+	URL    string
+	Format string
+	// RoutingKey is the integration key of the PagerDuty service.
+	RoutingKey string
+
+	client *http.Client
+}
+
+// NewWebhook returns a webhook channel.
+func NewWebhook(url string, format string, routingKey string) (*Webhook, error) {
+	if format == "" {
+		format = FormatGeneric
+	}
+
+	switch format {
+	case FormatGeneric, FormatSlack:
+		if url == "" {
+			return nil, fmt.Errorf("missing url for %s webhook", format)
+		}
+	case FormatPagerDuty:
+		if url == "" {
+			url = pagerDutyEventsURL
+		}
+
+		if routingKey == "" {
+			return nil, fmt.Errorf("missing routing_key for %s webhook", format)
+		}
+	default:
+		return nil, fmt.Errorf("%w %#v", errUnknownFormat, format)
+	}
+
+	return &Webhook{
+		URL:        url,
+		Format:     format,
+		RoutingKey: routingKey,
+		client:     &http.Client{},
+	}, nil
+}
+
+// Name returns the channel name.
+func (w *Webhook) Name() string {
+	return fmt.Sprintf("%s webhook", w.Format)
+}
+
+func (w *Webhook) payload(n Notification) interface{} {
+	switch w.Format {
+	case FormatSlack:
+		return map[string]string{"text": n.Title()}
+	case FormatPagerDuty:
+		action := "trigger"
+		if n.Resolved {
+			action = "resolve"
+		}
+
+		severity := "warning"
+
+		switch n.CurrentStatus {
+		case types.StatusCritical:
+			severity = "critical"
+		case types.StatusOk:
+			severity = "info"
+		case types.StatusUnknown:
+			severity = "error"
+		}
+
+		source, _ := os.Hostname()
+
+		return map[string]interface{}{
+			"routing_key":  w.RoutingKey,
+			"event_action": action,
+			"dedup_key":    types.LabelsToText(n.Labels) + " " + n.Item,
+			"payload": map[string]interface{}{
+				"summary":        n.Title(),
+				"source":         source,
+				"severity":       severity,
+				"timestamp":      n.Time.Format(time.RFC3339),
+				"custom_details": n.Labels,
+			},
+		}
+	default:
+		return n.genericPayload()
+	}
+}
+
+// Send post the notification.
+func (w *Webhook) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(w.payload(n))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 250))
+
+		return fmt.Errorf("response code %d: %s", resp.StatusCode, bytes.TrimSpace(content))
+	}
+
+	return nil
+}
+
+// Email send notifications with SMTP.
+type Email struct {
+	// Address is the "host:port" of the SMTP server.
+	Address  string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+// Name returns the channel name.
+func (e *Email) Name() string {
+	return "email"
+}
+
+func (e *Email) message(n Notification) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.ReplaceAll(n.Title(), "\n", " "))
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&b, "Status: %s (previously %s)\r\n", n.CurrentStatus, n.PreviousStatus)
+	fmt.Fprintf(&b, "Description: %s\r\n", n.StatusDescription)
+	fmt.Fprintf(&b, "Metric: %s\r\n", types.LabelsToText(n.Labels))
+
+	if n.Item != "" {
+		fmt.Fprintf(&b, "Item: %s\r\n", n.Item)
+	}
+
+	return b.Bytes()
+}
+
+// Send mail the notification. The server STARTTLS is used when available.
+func (e *Email) Send(ctx context.Context, n Notification) error {
+	host, _, err := net.SplitHostPort(e.Address)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", e.Address)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// Closing the connection unblocks the SMTP exchange when ctx is cancelled.
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	err = e.send(conn, host, n)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// send is smtp.SendMail on an already opened connection.
+func (e *Email) send(conn net.Conn, host string, n Notification) error {
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+
+		return err
+	}
+
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(e.message(n)); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// Exec run a command for each notification. The notification is given as JSON
+// on its standard input and as GLOUTON_* environment variables.
+type Exec struct {
+	Command []string
+}
+
+// NewExec returns an exec channel. The command is split like a shell would do.
+func NewExec(command string) (*Exec, error) {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("missing command for exec channel")
+	}
+
+	return &Exec{Command: args}, nil
+}
+
+// Name returns the channel name.
+func (e *Exec) Name() string {
+	return fmt.Sprintf("exec %s", e.Command[0])
+}
+
+// Send run the command.
+func (e *Exec) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n.genericPayload())
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...) //nolint:gosec
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(
+		os.Environ(),
+		"GLOUTON_METRIC="+n.Labels[types.LabelName],
+		"GLOUTON_ITEM="+n.Item,
+		"GLOUTON_STATUS="+n.CurrentStatus.String(),
+		"GLOUTON_PREVIOUS_STATUS="+n.PreviousStatus.String(),
+		"GLOUTON_STATUS_DESCRIPTION="+n.StatusDescription,
+		fmt.Sprintf("GLOUTON_RESOLVED=%v", n.Resolved),
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(output))
+	}
+
+	return nil
+}
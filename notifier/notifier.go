@@ -0,0 +1,190 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notifier send local notifications when the status of a metric changes.
+package notifier
+
+import (
+	"context"
+	"glouton/logger"
+	"glouton/threshold"
+	"glouton/types"
+	"sync"
+	"time"
+)
+
+const (
+	queueSize     = 1000
+	sendTimeout   = 30 * time.Second
+	checkInterval = time.Minute
+)
+
+// Notification is sent to channels when a metric status changes.
+type Notification struct {
+	Labels            map[string]string
+	Item              string
+	PreviousStatus    types.Status
+	CurrentStatus     types.Status
+	StatusDescription string
+	Time              time.Time
+	// Resolved is true when the metric is back to ok.
+	Resolved bool
+	// Renotification is true when the notification is a reminder of an unchanged problem.
+	Renotification bool
+}
+
+// Channel send notifications.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, n Notification) error
+}
+
+// Notifier send notifications to channels on status changes.
+//
+// A metric in warning or critical is notified once, then again every RenotifyInterval
+// while its status doesn't change. When it goes back to ok, a resolved notification
+// is sent if SendResolved is true.
+type Notifier struct {
+	Channels         []Channel
+	RenotifyInterval time.Duration
+	SendResolved     bool
+
+	queue chan Notification
+
+	l      sync.Mutex
+	active map[string]*Notification
+	sentAt map[string]time.Time
+}
+
+// New returns a Notifier.
+func New(channels []Channel, renotifyInterval time.Duration, sendResolved bool) *Notifier {
+	return &Notifier{
+		Channels:         channels,
+		RenotifyInterval: renotifyInterval,
+		SendResolved:     sendResolved,
+		queue:            make(chan Notification, queueSize),
+		active:           make(map[string]*Notification),
+		sentAt:           make(map[string]time.Time),
+	}
+}
+
+// OnStatusChanges is the callback to register with the threshold Registry AddStatusNotifiee.
+func (n *Notifier) OnStatusChanges(changes []threshold.StatusChange) {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	for _, change := range changes {
+		key := types.LabelsToText(change.Labels) + "\x00" + change.Annotations.BleemeoItem
+		notification := Notification{
+			Labels:            change.Labels,
+			Item:              change.Annotations.BleemeoItem,
+			PreviousStatus:    change.PreviousStatus,
+			CurrentStatus:     change.CurrentStatus,
+			StatusDescription: change.StatusDescription,
+			Time:              change.Time,
+		}
+
+		switch change.CurrentStatus {
+		case types.StatusWarning, types.StatusCritical, types.StatusUnknown:
+			if previous, ok := n.active[key]; ok && previous.CurrentStatus == change.CurrentStatus {
+				// Duplicate of an already notified problem.
+				continue
+			}
+
+			n.active[key] = &notification
+		default:
+			if _, ok := n.active[key]; !ok {
+				continue
+			}
+
+			delete(n.active, key)
+			delete(n.sentAt, key)
+
+			if !n.SendResolved {
+				continue
+			}
+
+			notification.Resolved = true
+		}
+
+		if notification.CurrentStatus != types.StatusOk {
+			n.sentAt[key] = time.Now()
+		}
+
+		n.enqueue(notification)
+	}
+}
+
+func (n *Notifier) enqueue(notification Notification) {
+	select {
+	case n.queue <- notification:
+	default:
+		logger.V(1).Printf("Too many pending notifications, dropping the notification for %s", notification.Labels[types.LabelName])
+	}
+}
+
+// renotify enqueue a reminder for problems notified more than RenotifyInterval ago.
+func (n *Notifier) renotify(now time.Time) {
+	if n.RenotifyInterval <= 0 {
+		return
+	}
+
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	for key, notification := range n.active {
+		if now.Sub(n.sentAt[key]) < n.RenotifyInterval {
+			continue
+		}
+
+		n.sentAt[key] = now
+
+		reminder := *notification
+		reminder.Renotification = true
+		reminder.Time = now
+
+		n.enqueue(reminder)
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, notification Notification) {
+	for _, c := range n.Channels {
+		ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+
+		if err := c.Send(ctx, notification); err != nil {
+			logger.Printf("Unable to send notification for %s with %s: %v", notification.Labels[types.LabelName], c.Name(), err)
+		}
+
+		cancel()
+	}
+}
+
+// Run send queued notifications until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-n.queue:
+			n.send(ctx, notification)
+		case now := <-ticker.C:
+			n.renotify(now)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"glouton/threshold"
+	"glouton/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func change(previous types.Status, current types.Status) threshold.StatusChange {
+	return threshold.StatusChange{
+		Labels:            map[string]string{types.LabelName: "disk_used_perc_status"},
+		Annotations:       types.MetricAnnotations{BleemeoItem: "/home"},
+		PreviousStatus:    previous,
+		CurrentStatus:     current,
+		StatusDescription: "Current value: 95.00 %",
+		Time:              time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func drain(n *Notifier) []Notification {
+	var result []Notification
+
+	for {
+		select {
+		case notification := <-n.queue:
+			result = append(result, notification)
+		default:
+			return result
+		}
+	}
+}
+
+func TestOnStatusChanges(t *testing.T) {
+	n := New(nil, time.Hour, true)
+
+	n.OnStatusChanges([]threshold.StatusChange{change(types.StatusOk, types.StatusWarning)})
+	n.OnStatusChanges([]threshold.StatusChange{change(types.StatusWarning, types.StatusWarning)})
+
+	if got := drain(n); len(got) != 1 || got[0].CurrentStatus != types.StatusWarning {
+		t.Errorf("notifications = %v, want one warning", got)
+	}
+
+	n.OnStatusChanges([]threshold.StatusChange{change(types.StatusWarning, types.StatusCritical)})
+
+	if got := drain(n); len(got) != 1 || got[0].CurrentStatus != types.StatusCritical {
+		t.Errorf("notifications = %v, want one critical", got)
+	}
+
+	n.renotify(time.Now().Add(30 * time.Minute))
+
+	if got := drain(n); len(got) != 0 {
+		t.Errorf("notifications = %v, want none before the renotify interval", got)
+	}
+
+	n.renotify(time.Now().Add(2 * time.Hour))
+
+	if got := drain(n); len(got) != 1 || !got[0].Renotification {
+		t.Errorf("notifications = %v, want one renotification", got)
+	}
+
+	n.OnStatusChanges([]threshold.StatusChange{change(types.StatusCritical, types.StatusOk)})
+
+	if got := drain(n); len(got) != 1 || !got[0].Resolved {
+		t.Errorf("notifications = %v, want one resolved", got)
+	}
+
+	n.OnStatusChanges([]threshold.StatusChange{change(types.StatusUnset, types.StatusOk)})
+
+	if got := drain(n); len(got) != 0 {
+		t.Errorf("notifications = %v, want none for a metric never in problem", got)
+	}
+}
+
+func TestOnStatusChangesWithoutResolved(t *testing.T) {
+	n := New(nil, 0, false)
+
+	n.OnStatusChanges([]threshold.StatusChange{
+		change(types.StatusOk, types.StatusCritical),
+		change(types.StatusCritical, types.StatusOk),
+	})
+
+	if got := drain(n); len(got) != 1 || got[0].Resolved {
+		t.Errorf("notifications = %v, want only the critical one", got)
+	}
+}
+
+func TestWebhookFormats(t *testing.T) {
+	var body map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	notification := Notification{
+		Labels:            map[string]string{types.LabelName: "disk_used_perc_status"},
+		Item:              "/home",
+		PreviousStatus:    types.StatusCritical,
+		CurrentStatus:     types.StatusOk,
+		StatusDescription: "Current value: 42.00 %",
+		Resolved:          true,
+	}
+
+	cases := []struct {
+		format string
+		key    string
+		want   interface{}
+	}{
+		{format: FormatGeneric, key: "status", want: "ok"},
+		{format: FormatSlack, key: "text", want: "[RESOLVED] disk_used_perc_status (/home): Current value: 42.00 %"},
+		{format: FormatPagerDuty, key: "event_action", want: "resolve"},
+	}
+
+	for _, c := range cases {
+		w, err := NewWebhook(server.URL, c.format, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Send(context.Background(), notification); err != nil {
+			t.Fatal(err)
+		}
+
+		if body[c.key] != c.want {
+			t.Errorf("%s: %s = %#v, want %#v", c.format, c.key, body[c.key], c.want)
+		}
+	}
+
+	if _, err := NewWebhook("", FormatPagerDuty, ""); err == nil {
+		t.Error("NewWebhook() succeeded without routing key, want an error")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"glouton/discovery"
 	"glouton/logger"
+	"glouton/metricquery"
 	"io/ioutil"
 	"os/exec"
 	"regexp"
@@ -35,16 +36,22 @@ type checkRegistry interface {
 	GetCheckNow(discovery.NameContainer) (discovery.CheckNow, error)
 }
 
+type metricQuerier interface {
+	NRPEResponse(args []string) (string, int16, error)
+}
+
 // Responder is used to build the NRPE answer.
 type Responder struct {
 	discovery      checkRegistry
 	customCheck    map[string]discovery.NameContainer
 	nrpeCommands   map[string]string
 	allowArguments bool
+	metrics        metricQuerier
 }
 
 // NewResponse returns a Response.
-func NewResponse(servicesOverride []map[string]string, checkRegistry checkRegistry, nrpeConfPath []string) Responder {
+// When metrics isn't nil, the command check_glouton is answered from the metrics collected by Glouton.
+func NewResponse(servicesOverride []map[string]string, checkRegistry checkRegistry, nrpeConfPath []string, metrics metricQuerier) Responder {
 	customChecks := make(map[string]discovery.NameContainer)
 
 	for _, fragment := range servicesOverride {
@@ -64,6 +71,7 @@ func NewResponse(servicesOverride []map[string]string, checkRegistry checkRegist
 		customCheck:    customChecks,
 		nrpeCommands:   nrpeCommands,
 		allowArguments: allowArguments,
+		metrics:        metrics,
 	}
 }
 
@@ -83,6 +91,10 @@ func (r Responder) Response(ctx context.Context, request string) (string, int16,
 		return r.responseNRPEConf(ctx, requestArgs)
 	}
 
+	if requestArgs[0] == metricquery.NRPECommand && r.metrics != nil {
+		return r.metrics.NRPEResponse(requestArgs[1:])
+	}
+
 	return "", 0, fmt.Errorf("NRPE: Command '%s' not defined", requestArgs[0])
 }
 
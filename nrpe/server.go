@@ -34,10 +34,19 @@ import (
 	"io"
 	"math/big"
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// maxBufferLength is the largest buffer accepted in a v3/v4 query and sent in a v3/v4 response.
+	// Larger outputs are truncated, as the NRPE daemon does.
+	maxBufferLength = 64 * 1024
+	// v2BufferLength is the fixed buffer size of v2 packets. The last byte is always a NUL.
+	v2BufferLength = 1024
+)
+
 type reducedPacket struct {
 	packetVersion int16
 	packetType    int16
@@ -47,19 +56,75 @@ type reducedPacket struct {
 
 // Server is an NRPE server than use Callback for reply to queries.
 type Server struct {
-	bindAddress string
-	enableTLS   bool
-	callback    callback
+	bindAddress     string
+	enableTLS       bool
+	allowedNetworks []*net.IPNet
+	callback        callback
 }
 
 // New returns a NRPE server
 // callback is the function responsible to generate the response for a given query.
-func New(bindAddress string, enableTLS bool, callback callback) Server {
+// When allowedNetworks isn't empty, only clients from these networks are accepted.
+func New(bindAddress string, enableTLS bool, allowedNetworks []*net.IPNet, callback callback) Server {
 	return Server{
-		bindAddress: bindAddress,
-		enableTLS:   enableTLS,
-		callback:    callback,
+		bindAddress:     bindAddress,
+		enableTLS:       enableTLS,
+		allowedNetworks: allowedNetworks,
+		callback:        callback,
+	}
+}
+
+// ParseAllowedHosts converts a list of IP addresses or CIDR ranges to networks.
+func ParseAllowedHosts(hosts []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(hosts))
+
+	for _, host := range hosts {
+		if !strings.Contains(host, "/") {
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid allowed host %#v", host)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed host %#v: %w", host, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// isAllowed returns whether the client address is in the allowed networks.
+func (s Server) isAllowed(addr net.Addr) bool {
+	if len(s.allowedNetworks) == 0 {
+		return true
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
 	}
+
+	for _, network := range s.allowedNetworks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
 }
 
 type callback func(ctx context.Context, command string) (string, int16, error)
@@ -76,7 +141,7 @@ func handleConnection(ctx context.Context, c io.ReadWriteCloser, cb callback, rn
 	var answer reducedPacket
 
 	if decodedRequest.buffer == "_NRPE_CHECK" {
-		answer.buffer = fmt.Sprintf("NRPE v3 (Glouton %v)", version.Version)
+		answer.buffer = fmt.Sprintf("NRPE v4 (Glouton %v)", version.Version)
 	} else {
 		answer.buffer, answer.resultCode, err = cb(ctx, decodedRequest.buffer)
 	}
@@ -90,7 +155,8 @@ func handleConnection(ctx context.Context, c io.ReadWriteCloser, cb callback, rn
 
 	var encodedAnswer []byte
 
-	if answer.packetVersion == 3 {
+	if answer.packetVersion == 3 || answer.packetVersion == 4 {
+		// v4 packets have the same layout as v3, only the version number differs.
 		encodedAnswer, err = encodeV3(answer)
 	} else {
 		encodedAnswer, err = encodeV2(answer, rndBytes)
@@ -114,7 +180,8 @@ func handleConnection(ctx context.Context, c io.ReadWriteCloser, cb callback, rn
 func decode(r io.Reader) (reducedPacket, error) {
 	packetHead := make([]byte, 16)
 
-	_, err := r.Read(packetHead)
+	// A packet may be split in multiple reads, especially large v3/v4 packets over TLS.
+	_, err := io.ReadFull(r, packetHead)
 	if err != nil {
 		return reducedPacket{}, err
 	}
@@ -157,7 +224,10 @@ func decode(r io.Reader) (reducedPacket, error) {
 		decodedPacket.resultCode = 0
 	}
 
-	if decodedPacket.packetVersion == 3 {
+	switch decodedPacket.packetVersion {
+	case 2:
+		bufferlength = v2BufferLength - 7
+	case 3, 4:
 		var uselessvariable int16
 
 		err = binary.Read(buf, binary.BigEndian, &uselessvariable)
@@ -171,15 +241,17 @@ func decode(r io.Reader) (reducedPacket, error) {
 			err = fmt.Errorf("binary.Read failed for buffer_length: %v", err)
 			return decodedPacket, err
 		}
-	}
 
-	if decodedPacket.packetVersion == 2 {
-		bufferlength = 1017
+		if bufferlength < 0 || bufferlength > maxBufferLength {
+			return decodedPacket, fmt.Errorf("buffer_length %d is out of range", bufferlength)
+		}
+	default:
+		return decodedPacket, fmt.Errorf("unsupported packet version %d", decodedPacket.packetVersion)
 	}
 
 	packetBuffer := make([]byte, bufferlength+3)
 
-	_, err = r.Read(packetBuffer)
+	_, err = io.ReadFull(r, packetBuffer)
 	if err != nil {
 		return reducedPacket{}, err
 	}
@@ -198,16 +270,14 @@ func decode(r io.Reader) (reducedPacket, error) {
 		return decodedPacket, errors.New("wrong value for crc32")
 	}
 
-	i := bytes.IndexByte(packetBuffer, 0x0)
-
-	if decodedPacket.packetVersion == 3 {
+	if i := bytes.IndexByte(packetBuffer, 0x0); i >= 0 {
 		packetBuffer = packetBuffer[:i]
-		decodedPacket.buffer = string(packetBuffer)
 	}
 
 	if decodedPacket.packetVersion == 2 {
-		packetBuffer = packetBuffer[:i]
 		decodedPacket.buffer = string(packetHead[10:]) + string(packetBuffer)
+	} else {
+		decodedPacket.buffer = string(packetBuffer)
 	}
 
 	return decodedPacket, nil
@@ -262,8 +332,13 @@ func encodeV2(decodedPacket reducedPacket, randBytes [2]byte) ([]byte, error) {
 	return encodedPacket, nil
 }
 
+// encodeV3 encodes a v3 or v4 packet. The buffer length depends on the output, up to maxBufferLength.
 func encodeV3(decodedPacket reducedPacket) ([]byte, error) {
 	decodedPacket.packetType = 2
+
+	if len(decodedPacket.buffer) > maxBufferLength {
+		decodedPacket.buffer = decodedPacket.buffer[:maxBufferLength]
+	}
 	bufferLength := int32(len(decodedPacket.buffer))
 	encodedPacket := make([]byte, 19+len(decodedPacket.buffer))
 
@@ -455,6 +530,13 @@ func (s Server) Run(ctx context.Context) error {
 			continue
 		}
 
+		if !s.isAllowed(c.RemoteAddr()) {
+			logger.V(1).Printf("NRPE connection from %v refused: the host isn't allowed", c.RemoteAddr())
+			c.Close()
+
+			continue
+		}
+
 		wg.Add(1)
 
 		go func() {
@@ -20,7 +20,10 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"net"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 type packetCapture struct {
@@ -108,6 +111,72 @@ func TestDecodeEncodeV3(t *testing.T) {
 	}
 }
 
+func TestDecodeEncodeV4(t *testing.T) {
+	cases := []reducedPacket{
+		{4, 2, 0, "connection successful"},
+		{4, 2, 1, strings.Repeat("large output ", 1000)},
+	}
+
+	for _, c := range cases {
+		inter, err := encodeV3(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Large packets are received in multiple reads.
+		got, err := decode(iotest.HalfReader(bytes.NewReader(inter)))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if got != c {
+			t.Errorf("decode(encodeV3(%.40v)) == %.40v, want %.40v", c, got, c)
+		}
+	}
+
+	truncated, _ := encodeV3(reducedPacket{4, 2, 0, strings.Repeat("x", 2*maxBufferLength)})
+	if len(truncated) != 19+maxBufferLength {
+		t.Errorf("len(encodeV3(too large)) = %d, want %d", len(truncated), 19+maxBufferLength)
+	}
+}
+
+func TestDecodeInvalidLength(t *testing.T) {
+	packet, _ := encodeV3(reducedPacket{4, 1, 0, "check_load"})
+	// Set a buffer_length larger than the limit.
+	copy(packet[12:16], []byte{0x7f, 0xff, 0xff, 0xff})
+
+	if _, err := decode(bytes.NewReader(packet)); err == nil {
+		t.Errorf("decode() succeeded with a too large buffer_length")
+	}
+}
+
+func TestAllowedHosts(t *testing.T) {
+	networks, err := ParseAllowedHosts([]string{"127.0.0.1", "192.168.0.0/16", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(":5666", false, networks, nil)
+
+	cases := map[string]bool{
+		"127.0.0.1":   true,
+		"127.0.0.2":   false,
+		"192.168.1.3": true,
+		"10.0.0.1":    false,
+		"::1":         true,
+	}
+
+	for ip, want := range cases {
+		if got := s.isAllowed(&net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}); got != want {
+			t.Errorf("isAllowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	if _, err := ParseAllowedHosts([]string{"not-an-ip"}); err == nil {
+		t.Errorf("ParseAllowedHosts(not-an-ip) succeeded")
+	}
+}
+
 func TestDecodeEncode(t *testing.T) {
 	for _, c := range allPackets {
 		packet := reducedPacket{
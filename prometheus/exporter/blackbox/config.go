@@ -17,19 +17,25 @@
 package blackbox
 
 import (
+	"errors"
 	"fmt"
 	"glouton/logger"
 	"glouton/prometheus/registry"
 	gloutonTypes "glouton/types"
 	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	bbConf "github.com/prometheus/blackbox_exporter/config"
+	promConfig "github.com/prometheus/common/config"
 	"gopkg.in/yaml.v3"
 )
 
 const maxTimeout time.Duration = 9500 * time.Millisecond
 
+var errIncompleteClientCert = errors.New("both cert_file and key_file are required to use a client certificate")
+
 // yamlConfig is the subset of glouton config that deals with probes.
 type yamlConfig struct {
 	Targets     []yamlConfigTarget       `yaml:"targets"`
@@ -42,6 +48,105 @@ type yamlConfigTarget struct {
 	Name       string `yaml:"name,omitempty"`
 	URL        string `yaml:"url"`
 	ModuleName string `yaml:"module"`
+	// DNS is a shortcut to probe a DNS server without declaring a module.
+	DNS *yamlConfigDNS `yaml:"dns,omitempty"`
+	// Headers are added to the requests of HTTP probes.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// TLS overrides the TLS settings of the module for this target.
+	TLS *yamlConfigTLS `yaml:"tls,omitempty"`
+	// ProxyURL is the proxy used by HTTP probes of this target.
+	ProxyURL string `yaml:"proxy_url,omitempty"`
+}
+
+// yamlConfigTLS allows to probe mTLS services and to override the server name (SNI).
+type yamlConfigTLS struct {
+	CertFile   string `yaml:"cert_file,omitempty"`
+	KeyFile    string `yaml:"key_file,omitempty"`
+	ServerName string `yaml:"server_name,omitempty"`
+}
+
+// applyOptions returns a copy of the module with the per-target options applied.
+// The module may be shared by other targets, so it must not be modified.
+func (t yamlConfigTarget) applyOptions(mod bbConf.Module) (bbConf.Module, error) {
+	mod.HTTP.Headers = mergeHeaders(mod.HTTP.Headers, t.Headers)
+
+	if t.TLS != nil {
+		if (t.TLS.CertFile == "") != (t.TLS.KeyFile == "") {
+			return mod, errIncompleteClientCert
+		}
+
+		// The TLS settings are used by HTTPS probes and by TCP probes using TLS.
+		for _, tlsConfig := range []*promConfig.TLSConfig{&mod.HTTP.HTTPClientConfig.TLSConfig, &mod.TCP.TLSConfig} {
+			if t.TLS.CertFile != "" {
+				tlsConfig.CertFile = t.TLS.CertFile
+				tlsConfig.KeyFile = t.TLS.KeyFile
+			}
+
+			if t.TLS.ServerName != "" {
+				tlsConfig.ServerName = t.TLS.ServerName
+			}
+		}
+	}
+
+	if t.ProxyURL != "" {
+		proxyURL, err := url.Parse(t.ProxyURL)
+		if err != nil {
+			return mod, fmt.Errorf("invalid proxy_url: %w", err)
+		}
+
+		mod.HTTP.HTTPClientConfig.ProxyURL = promConfig.URL{URL: proxyURL}
+	}
+
+	return mod, nil
+}
+
+// mergeHeaders returns a new map with the headers of the module overridden by the extra ones.
+func mergeHeaders(headers map[string]string, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return headers
+	}
+
+	result := make(map[string]string, len(headers)+len(extra))
+
+	for k, v := range headers {
+		result[k] = v
+	}
+
+	for k, v := range extra {
+		result[k] = v
+	}
+
+	return result
+}
+
+// yamlConfigDNS describes the query of a DNS target and its expected answer.
+type yamlConfigDNS struct {
+	QueryName string   `yaml:"query_name"`
+	QueryType string   `yaml:"query_type,omitempty"`
+	Expected  []string `yaml:"expected,omitempty"`
+}
+
+// dnsModule returns the module of a target using the DNS shortcut.
+func dnsModule(conf yamlConfigDNS) bbConf.Module {
+	mod := defaultModule()
+	mod.Prober = proberNameDNS
+	mod.DNS.QueryName = conf.QueryName
+	mod.DNS.QueryType = strings.ToUpper(conf.QueryType)
+
+	if mod.DNS.QueryType == "" {
+		mod.DNS.QueryType = "A"
+	}
+
+	// Each expected value must match at least one record of the answer, which
+	// the prober formats as "name ttl class type value".
+	for _, expected := range conf.Expected {
+		mod.DNS.ValidateAnswer.FailIfNoneMatchesRegexp = append(
+			mod.DNS.ValidateAnswer.FailIfNoneMatchesRegexp,
+			`\s`+regexp.QuoteMeta(strings.TrimSuffix(expected, "."))+`\.?$`,
+		)
+	}
+
+	return mod
 }
 
 func defaultModule() bbConf.Module {
@@ -96,6 +201,8 @@ func genCollectorFromDynamicTarget(monitor gloutonTypes.Monitor) (*collectorWith
 		if monitor.ExpectedResponseCode != 0 {
 			mod.HTTP.ValidStatusCodes = []int{monitor.ExpectedResponseCode}
 		}
+
+		mod.HTTP.Headers = mergeHeaders(mod.HTTP.Headers, monitor.Headers)
 	case proberNameDNS:
 		mod.Prober = proberNameDNS
 		// TODO: user some better defaults - or even better: use the local resolver
@@ -187,12 +294,26 @@ func New(registry *registry.Registry, externalConf interface{}) (*RegisterManage
 		}
 
 		module, present := conf.Modules[conf.Targets[idx].ModuleName]
+
+		if conf.Targets[idx].DNS != nil {
+			module, present = dnsModule(*conf.Targets[idx].DNS), true
+
+			if conf.Targets[idx].ModuleName == "" {
+				conf.Targets[idx].ModuleName = proberNameDNS
+			}
+		}
+
 		// if the module is unknown, add it to the list
 		if !present {
 			return nil, fmt.Errorf("blackbox_exporter: unknown blackbox module found in your configuration for %s (module '%v'). "+
 				"This is a probably bug, please contact us", conf.Targets[idx].Name, conf.Targets[idx].ModuleName)
 		}
 
+		module, err = conf.Targets[idx].applyOptions(module)
+		if err != nil {
+			return nil, fmt.Errorf("blackbox_exporter: invalid target %s: %w", conf.Targets[idx].Name, err)
+		}
+
 		targets = append(targets, genCollectorFromStaticTarget(configTarget{
 			Name:       conf.Targets[idx].Name,
 			URL:        conf.Targets[idx].URL,
@@ -168,3 +168,79 @@ func TestNoTargetsConfigParsing(t *testing.T) {
 		t.Fatalf("TestConfigParsing() = %+v, want %+v", bbManager.targets, []collectorWithLabels{})
 	}
 }
+
+func TestTargetOptionsParsing(t *testing.T) {
+	cfg := &gloutonConfig.Configuration{}
+
+	conf := `
+    blackbox:
+      targets:
+        - url: "https://internal.example.com"
+          module: "http_2xx"
+          headers:
+            Authorization: "Bearer secret"
+          tls:
+            cert_file: "/etc/glouton/client.crt"
+            key_file: "/etc/glouton/client.key"
+            server_name: "internal"
+          proxy_url: "http://proxy:3128"
+        - url: "https://example.com"
+          module: "http_2xx"
+      modules:
+        http_2xx:
+          prober: http
+          http:
+            headers:
+              User-Agent: "Glouton"`
+
+	if err := cfg.LoadByte([]byte(conf)); err != nil {
+		t.Fatal(err)
+	}
+
+	blackboxConf, present := cfg.Get("blackbox")
+	if !present {
+		t.Fatalf("Couldn't parse the yaml configuration")
+	}
+
+	bbManager, err := New(&registry.Registry{}, blackboxConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bbManager.targets) != 2 {
+		t.Fatalf("len(targets) = %d, want 2", len(bbManager.targets))
+	}
+
+	mod := bbManager.targets[0].collector.Module
+
+	wantHeaders := map[string]string{"User-Agent": "Glouton", "Authorization": "Bearer secret"}
+	if !reflect.DeepEqual(mod.HTTP.Headers, wantHeaders) {
+		t.Errorf("Headers = %v, want %v", mod.HTTP.Headers, wantHeaders)
+	}
+
+	tlsConfig := mod.HTTP.HTTPClientConfig.TLSConfig
+	if tlsConfig.CertFile != "/etc/glouton/client.crt" || tlsConfig.KeyFile != "/etc/glouton/client.key" || tlsConfig.ServerName != "internal" {
+		t.Errorf("TLSConfig = %+v, want the target TLS options", tlsConfig)
+	}
+
+	if mod.HTTP.HTTPClientConfig.ProxyURL.URL == nil || mod.HTTP.HTTPClientConfig.ProxyURL.String() != "http://proxy:3128" {
+		t.Errorf("ProxyURL = %v, want http://proxy:3128", mod.HTTP.HTTPClientConfig.ProxyURL.URL)
+	}
+
+	// The module is shared, the options of a target must not leak to the others.
+	wantHeaders = map[string]string{"User-Agent": "Glouton"}
+	if other := bbManager.targets[1].collector.Module; !reflect.DeepEqual(other.HTTP.Headers, wantHeaders) {
+		t.Errorf("Headers of the other target = %v, want %v", other.HTTP.Headers, wantHeaders)
+	}
+}
+
+func TestTargetOptionsInvalidTLS(t *testing.T) {
+	target := yamlConfigTarget{
+		URL: "https://example.com",
+		TLS: &yamlConfigTLS{CertFile: "/etc/glouton/client.crt"},
+	}
+
+	if _, err := target.applyOptions(defaultModule()); err == nil {
+		t.Error("applyOptions() succeeded with a certificate without key")
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package selfmetrics expose metrics about the health of Glouton itself.
+package selfmetrics
+
+import (
+	"glouton/check"
+	"glouton/collector"
+	"glouton/task"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type storeStats interface {
+	MetricsCount() int
+	PointsCount() int
+	SeriesDropped() int
+}
+
+type queueStats interface {
+	PendingPointsCount() int
+}
+
+type outputStats interface {
+	LastWriteSucceeded() bool
+	PendingPointsCount() int
+}
+
+type gatherStats interface {
+	GatherDurations() map[string]time.Duration
+	GatherResults() []collector.GatherResult
+}
+
+type discoveryStats interface {
+	LastDuration() time.Duration
+}
+
+type taskStats interface {
+	Counts() (running int, failed int)
+	Statuses() []task.Status
+}
+
+type stateStats interface {
+	Sizes() (fileSize int64, rawSize int64, saveDuration time.Duration)
+}
+
+type checkPoolStats interface {
+	Stats() map[string]check.PoolStats
+}
+
+// Collector is a prometheus.Collector for the glouton_* metrics.
+//
+// Any source may be left nil, its metrics are then not exposed.
+type Collector struct {
+	Store     storeStats
+	MQTT      queueStats
+	InfluxDB  outputStats
+	Inputs    gatherStats
+	Discovery discoveryStats
+	Tasks     taskStats
+	State     stateStats
+	Checks    checkPoolStats
+
+	// APIRequests count requests made to the local API. Use it with promhttp.InstrumentHandlerCounter.
+	APIRequests *prometheus.CounterVec
+
+	storeMetrics *prometheus.Desc
+	storePoints  *prometheus.Desc
+	seriesDrop   *prometheus.Desc
+	mqttPending  *prometheus.Desc
+	influxOk     *prometheus.Desc
+	influxQueue  *prometheus.Desc
+	inputGather  *prometheus.Desc
+	inputOk      *prometheus.Desc
+	discovery    *prometheus.Desc
+	tasksRunning *prometheus.Desc
+	tasksFailed  *prometheus.Desc
+	taskUp       *prometheus.Desc
+	taskRestarts *prometheus.Desc
+	stateSize    *prometheus.Desc
+	stateRawSize *prometheus.Desc
+	stateSave    *prometheus.Desc
+	poolSize     *prometheus.Desc
+	poolRunning  *prometheus.Desc
+	poolWaiting  *prometheus.Desc
+	poolChecks   *prometheus.Desc
+	poolWait     *prometheus.Desc
+	goroutines   *prometheus.Desc
+	memoryHeap   *prometheus.Desc
+	memorySys    *prometheus.Desc
+
+	l              sync.Mutex
+	lastMemoryRead time.Time
+	memStats       runtime.MemStats
+}
+
+// New return a Collector. Sources should be set before it's registered.
+func New() *Collector {
+	return &Collector{
+		APIRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "glouton",
+				Subsystem: "api",
+				Name:      "requests_total",
+				Help:      "Total number of requests to the local API",
+			},
+			[]string{"code", "method"},
+		),
+		storeMetrics: prometheus.NewDesc(
+			"glouton_store_metrics",
+			"Number of metrics in the local store",
+			nil, nil,
+		),
+		storePoints: prometheus.NewDesc(
+			"glouton_store_points",
+			"Number of points in the local store",
+			nil, nil,
+		),
+		seriesDrop: prometheus.NewDesc(
+			"glouton_series_dropped_total",
+			"Number of points dropped because they would have created a series above the limits of the local store",
+			nil, nil,
+		),
+		mqttPending: prometheus.NewDesc(
+			"glouton_mqtt_pending_points",
+			"Number of points waiting to be sent to Bleemeo Cloud platform",
+			nil, nil,
+		),
+		influxOk: prometheus.NewDesc(
+			"glouton_influxdb_last_write_success",
+			"Whether the last write to InfluxDB succeeded (1) or failed (0)",
+			nil, nil,
+		),
+		influxQueue: prometheus.NewDesc(
+			"glouton_influxdb_pending_points",
+			"Number of points waiting to be sent to InfluxDB",
+			nil, nil,
+		),
+		inputGather: prometheus.NewDesc(
+			"glouton_input_gather_seconds",
+			"Duration of the last gather of each input",
+			[]string{"input"}, nil,
+		),
+		inputOk: prometheus.NewDesc(
+			"glouton_input_last_gather_success",
+			"Whether the last gather of each input succeeded (1) or failed (0)",
+			[]string{"input", "item"}, nil,
+		),
+		discovery: prometheus.NewDesc(
+			"glouton_discovery_seconds",
+			"Duration of the last service discovery",
+			nil, nil,
+		),
+		tasksRunning: prometheus.NewDesc(
+			"glouton_tasks_running",
+			"Number of running tasks",
+			nil, nil,
+		),
+		tasksFailed: prometheus.NewDesc(
+			"glouton_tasks_failures_total",
+			"Number of tasks which exited with an error",
+			nil, nil,
+		),
+		taskUp: prometheus.NewDesc(
+			"glouton_task_up",
+			"Whether each task is running (1) or stopped or waiting for its restart (0)",
+			[]string{"task"}, nil,
+		),
+		taskRestarts: prometheus.NewDesc(
+			"glouton_task_restarts_total",
+			"Number of restarts of each task",
+			[]string{"task"}, nil,
+		),
+		stateSize: prometheus.NewDesc(
+			"glouton_state_file_bytes",
+			"Size of the state file",
+			nil, nil,
+		),
+		stateRawSize: prometheus.NewDesc(
+			"glouton_state_uncompressed_bytes",
+			"Size of the state file before compression",
+			nil, nil,
+		),
+		stateSave: prometheus.NewDesc(
+			"glouton_state_save_seconds",
+			"Duration of the last save of the state file",
+			nil, nil,
+		),
+		poolSize: prometheus.NewDesc(
+			"glouton_check_pool_size",
+			"Maximum number of concurrent checks of the pool",
+			[]string{"pool"}, nil,
+		),
+		poolRunning: prometheus.NewDesc(
+			"glouton_check_pool_running",
+			"Number of checks running in the pool",
+			[]string{"pool"}, nil,
+		),
+		poolWaiting: prometheus.NewDesc(
+			"glouton_check_pool_waiting",
+			"Number of checks waiting for a worker of the pool",
+			[]string{"pool"}, nil,
+		),
+		poolChecks: prometheus.NewDesc(
+			"glouton_check_pool_checks_total",
+			"Number of checks run by the pool",
+			[]string{"pool"}, nil,
+		),
+		poolWait: prometheus.NewDesc(
+			"glouton_check_pool_wait_seconds_total",
+			"Total time checks waited for a worker of the pool",
+			[]string{"pool"}, nil,
+		),
+		goroutines: prometheus.NewDesc(
+			"glouton_goroutines",
+			"Number of goroutines",
+			nil, nil,
+		),
+		memoryHeap: prometheus.NewDesc(
+			"glouton_memory_heap_bytes",
+			"Bytes of allocated heap objects",
+			nil, nil,
+		),
+		memorySys: prometheus.NewDesc(
+			"glouton_memory_sys_bytes",
+			"Bytes of memory obtained from the OS",
+			nil, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.APIRequests.Describe(ch)
+
+	ch <- c.storeMetrics
+	ch <- c.storePoints
+	ch <- c.seriesDrop
+	ch <- c.mqttPending
+	ch <- c.influxOk
+	ch <- c.influxQueue
+	ch <- c.inputGather
+	ch <- c.inputOk
+	ch <- c.discovery
+	ch <- c.tasksRunning
+	ch <- c.tasksFailed
+	ch <- c.taskUp
+	ch <- c.taskRestarts
+	ch <- c.stateSize
+	ch <- c.stateRawSize
+	ch <- c.stateSave
+	ch <- c.poolSize
+	ch <- c.poolRunning
+	ch <- c.poolWaiting
+	ch <- c.poolChecks
+	ch <- c.poolWait
+	ch <- c.goroutines
+	ch <- c.memoryHeap
+	ch <- c.memorySys
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.APIRequests.Collect(ch)
+
+	if c.Store != nil {
+		ch <- prometheus.MustNewConstMetric(c.storeMetrics, prometheus.GaugeValue, float64(c.Store.MetricsCount()))
+		ch <- prometheus.MustNewConstMetric(c.storePoints, prometheus.GaugeValue, float64(c.Store.PointsCount()))
+		ch <- prometheus.MustNewConstMetric(c.seriesDrop, prometheus.CounterValue, float64(c.Store.SeriesDropped()))
+	}
+
+	if c.MQTT != nil {
+		ch <- prometheus.MustNewConstMetric(c.mqttPending, prometheus.GaugeValue, float64(c.MQTT.PendingPointsCount()))
+	}
+
+	if c.InfluxDB != nil {
+		success := 0.0
+		if c.InfluxDB.LastWriteSucceeded() {
+			success = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.influxOk, prometheus.GaugeValue, success)
+		ch <- prometheus.MustNewConstMetric(c.influxQueue, prometheus.GaugeValue, float64(c.InfluxDB.PendingPointsCount()))
+	}
+
+	if c.Inputs != nil {
+		for name, duration := range c.Inputs.GatherDurations() {
+			ch <- prometheus.MustNewConstMetric(c.inputGather, prometheus.GaugeValue, duration.Seconds(), name)
+		}
+
+		for _, result := range c.Inputs.GatherResults() {
+			success := 0.0
+			if result.Success {
+				success = 1
+			}
+
+			ch <- prometheus.MustNewConstMetric(c.inputOk, prometheus.GaugeValue, success, result.Input, result.Item)
+		}
+	}
+
+	if c.Discovery != nil {
+		ch <- prometheus.MustNewConstMetric(c.discovery, prometheus.GaugeValue, c.Discovery.LastDuration().Seconds())
+	}
+
+	if c.Tasks != nil {
+		running, failed := c.Tasks.Counts()
+
+		ch <- prometheus.MustNewConstMetric(c.tasksRunning, prometheus.GaugeValue, float64(running))
+		ch <- prometheus.MustNewConstMetric(c.tasksFailed, prometheus.CounterValue, float64(failed))
+
+		// Many tasks (e.g. checks) could share the same name, only the first is exposed.
+		seen := make(map[string]bool)
+
+		for _, t := range c.Tasks.Statuses() {
+			if seen[t.Name] {
+				continue
+			}
+
+			seen[t.Name] = true
+
+			up := 0.0
+			if t.Running && !t.Restarting {
+				up = 1
+			}
+
+			ch <- prometheus.MustNewConstMetric(c.taskUp, prometheus.GaugeValue, up, t.Name)
+			ch <- prometheus.MustNewConstMetric(c.taskRestarts, prometheus.CounterValue, float64(t.Restarts), t.Name)
+		}
+	}
+
+	if c.State != nil {
+		fileSize, rawSize, saveDuration := c.State.Sizes()
+
+		ch <- prometheus.MustNewConstMetric(c.stateSize, prometheus.GaugeValue, float64(fileSize))
+		ch <- prometheus.MustNewConstMetric(c.stateRawSize, prometheus.GaugeValue, float64(rawSize))
+		ch <- prometheus.MustNewConstMetric(c.stateSave, prometheus.GaugeValue, saveDuration.Seconds())
+	}
+
+	if c.Checks != nil {
+		for name, stats := range c.Checks.Stats() {
+			ch <- prometheus.MustNewConstMetric(c.poolSize, prometheus.GaugeValue, float64(stats.Size), name)
+			ch <- prometheus.MustNewConstMetric(c.poolRunning, prometheus.GaugeValue, float64(stats.Running), name)
+			ch <- prometheus.MustNewConstMetric(c.poolWaiting, prometheus.GaugeValue, float64(stats.Waiting), name)
+			ch <- prometheus.MustNewConstMetric(c.poolChecks, prometheus.CounterValue, float64(stats.Checks), name)
+			ch <- prometheus.MustNewConstMetric(c.poolWait, prometheus.CounterValue, stats.WaitTotal.Seconds(), name)
+		}
+	}
+
+	c.l.Lock()
+
+	// ReadMemStats stop the world, don't call it more than once per collection interval
+	if time.Since(c.lastMemoryRead) > 10*time.Second {
+		runtime.ReadMemStats(&c.memStats)
+		c.lastMemoryRead = time.Now()
+	}
+
+	heapAlloc := c.memStats.HeapAlloc
+	sys := c.memStats.Sys
+
+	c.l.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(c.goroutines, prometheus.GaugeValue, float64(runtime.NumGoroutine()))
+	ch <- prometheus.MustNewConstMetric(c.memoryHeap, prometheus.GaugeValue, float64(heapAlloc))
+	ch <- prometheus.MustNewConstMetric(c.memorySys, prometheus.GaugeValue, float64(sys))
+}
@@ -5,6 +5,7 @@ import (
 	"glouton/types"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
@@ -89,6 +90,15 @@ type labeledGatherer struct {
 	source      prometheus.Gatherer
 	labels      []*dto.LabelPair
 	annotations types.MetricAnnotations
+	status      *gatherStatus
+}
+
+// gatherStatus is the outcome of the last background gather of a gatherer.
+type gatherStatus struct {
+	l            sync.Mutex
+	lastGather   time.Time
+	lastDuration time.Duration
+	lastError    error
 }
 
 func newLabeledGatherer(g prometheus.Gatherer, extraLabels labels.Labels, annotations types.MetricAnnotations) labeledGatherer {
@@ -108,6 +118,7 @@ func newLabeledGatherer(g prometheus.Gatherer, extraLabels labels.Labels, annota
 		source:      g,
 		labels:      labels,
 		annotations: annotations,
+		status:      &gatherStatus{},
 	}
 }
 
@@ -174,7 +185,17 @@ func mergeLabels(a []*dto.LabelPair, b []*dto.LabelPair) []*dto.LabelPair {
 }
 
 func (g labeledGatherer) GatherPoints(state GatherState) ([]types.MetricPoint, error) {
+	t0 := time.Now()
 	mfs, err := g.GatherWithState(state)
+
+	if g.status != nil {
+		g.status.l.Lock()
+		g.status.lastGather = t0
+		g.status.lastDuration = time.Since(t0)
+		g.status.lastError = err
+		g.status.l.Unlock()
+	}
+
 	points := familiesToMetricPoints(mfs)
 
 	if (g.annotations != types.MetricAnnotations{}) {
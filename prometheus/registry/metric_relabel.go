@@ -0,0 +1,66 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"glouton/types"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseRelabelConfigs reads relabel rules from the configuration. They use the same
+// keys as the metric_relabel_configs of Prometheus.
+func ParseRelabelConfigs(config interface{}) ([]*relabel.Config, error) {
+	var configs []*relabel.Config
+
+	marshalled, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(marshalled, &configs); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+// relabelPoints returns a copy of the points with the MetricRelabelConfigs applied.
+// Points dropped by a rule or without name after the relabeling are removed.
+// The points must not be mutated, they could still be used by the caller.
+func (r *Registry) relabelPoints(points []types.MetricPoint) []types.MetricPoint {
+	if len(r.MetricRelabelConfigs) == 0 {
+		return points
+	}
+
+	result := make([]types.MetricPoint, 0, len(points))
+
+	for _, point := range points {
+		lbls := relabel.Process(labels.FromMap(point.Labels), r.MetricRelabelConfigs...)
+		if lbls == nil || lbls.Get(model.MetricNameLabel) == "" {
+			continue
+		}
+
+		point.Labels = lbls.Map()
+		result = append(result, point)
+	}
+
+	return result
+}
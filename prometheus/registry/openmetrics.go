@@ -0,0 +1,228 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const counterSuffix = "_total"
+
+// createdTracker infers the created timestamp of counters exposed in OpenMetrics.
+//
+// The exporters don't provide it, so it's only known for counters which appeared or
+// were reset between two scrapes: they were created after the previous scrape. Using
+// the previous scrape time is a safe lower bound. Counters seen on the first scrape
+// have no created timestamp.
+type createdTracker struct {
+	l          sync.Mutex
+	lastScrape time.Time
+	series     map[string]counterState
+}
+
+type counterState struct {
+	value    float64
+	created  time.Time
+	lastSeen time.Time
+}
+
+// track updates the counters state and returns the known created timestamps.
+func (t *createdTracker) track(mfs []*dto.MetricFamily, now time.Time) map[*dto.Metric]time.Time {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	if t.series == nil {
+		t.series = make(map[string]counterState)
+	}
+
+	result := make(map[*dto.Metric]time.Time)
+
+	for _, mf := range mfs {
+		if mf.GetType() != dto.MetricType_COUNTER {
+			continue
+		}
+
+		for _, m := range mf.Metric {
+			key := seriesKey(mf.GetName(), m.Label)
+			value := m.GetCounter().GetValue()
+
+			state, found := t.series[key]
+			if !found || value < state.value {
+				state.created = t.lastScrape
+			}
+
+			state.value = value
+			state.lastSeen = now
+			t.series[key] = state
+
+			if !state.created.IsZero() {
+				result[m] = state.created
+			}
+		}
+	}
+
+	// A series which disappears and reappears later is a new counter.
+	for key, state := range t.series {
+		if !state.lastSeen.Equal(now) {
+			delete(t.series, key)
+		}
+	}
+
+	t.lastScrape = now
+
+	return result
+}
+
+func seriesKey(name string, lbls []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(lbls))
+
+	for _, l := range lbls {
+		pairs = append(pairs, l.GetName()+"="+l.GetValue())
+	}
+
+	sort.Strings(pairs)
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// normalizeForOpenMetrics renames counters without the "_total" suffix, which
+// strict OpenMetrics parsers require. Without it they are exposed as "unknown".
+func normalizeForOpenMetrics(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	names := make(map[string]bool, len(mfs))
+
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+
+	result := make([]*dto.MetricFamily, 0, len(mfs))
+
+	for _, mf := range mfs {
+		name := mf.GetName() + counterSuffix
+
+		if mf.GetType() == dto.MetricType_COUNTER && !strings.HasSuffix(mf.GetName(), counterSuffix) && !names[name] {
+			mf = &dto.MetricFamily{
+				Name:   &name,
+				Help:   mf.Help,
+				Type:   mf.Type,
+				Metric: mf.Metric,
+			}
+		}
+
+		result = append(result, mf)
+	}
+
+	return result
+}
+
+// writeOpenMetrics writes families in the OpenMetrics format, with the created
+// timestamps of counters when known.
+func writeOpenMetrics(w io.Writer, mfs []*dto.MetricFamily, created map[*dto.Metric]time.Time) error {
+	for _, mf := range mfs {
+		if mf.GetType() != dto.MetricType_COUNTER || len(created) == 0 {
+			if _, err := expfmt.MetricFamilyToOpenMetrics(w, mf); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := writeOpenMetricsCounter(w, mf, created); err != nil {
+			return err
+		}
+	}
+
+	_, err := expfmt.FinalizeOpenMetrics(w)
+
+	return err
+}
+
+// writeOpenMetricsCounter writes a counter family, adding a "_created" sample after each
+// counter whose created timestamp is known.
+func writeOpenMetricsCounter(w io.Writer, mf *dto.MetricFamily, created map[*dto.Metric]time.Time) error {
+	header := &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+
+	if _, err := expfmt.MetricFamilyToOpenMetrics(w, header); err != nil {
+		return err
+	}
+
+	createdName := strings.TrimSuffix(mf.GetName(), counterSuffix) + "_created"
+	gaugeType := dto.MetricType_GAUGE
+
+	for _, m := range mf.Metric {
+		single := &dto.MetricFamily{Name: mf.Name, Type: mf.Type, Metric: []*dto.Metric{m}}
+
+		if err := writeSamples(w, single); err != nil {
+			return err
+		}
+
+		createdAt, ok := created[m]
+		if !ok || math.IsNaN(m.GetCounter().GetValue()) {
+			continue
+		}
+
+		value := float64(createdAt.UnixNano()) / 1e9
+		createdFamily := &dto.MetricFamily{
+			Name: &createdName,
+			Type: &gaugeType,
+			Metric: []*dto.Metric{{
+				Label: m.Label,
+				Gauge: &dto.Gauge{Value: &value},
+			}},
+		}
+
+		if err := writeSamples(w, createdFamily); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSamples writes the samples of mf without the HELP and TYPE lines.
+func writeSamples(w io.Writer, mf *dto.MetricFamily) error {
+	var buffer bytes.Buffer
+
+	if _, err := expfmt.MetricFamilyToOpenMetrics(&buffer, mf); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(&buffer)
+	scanner.Buffer(make([]byte, 0, 4096), buffer.Len()+1)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
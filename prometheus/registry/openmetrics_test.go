@@ -0,0 +1,108 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterFamily(name string, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String(name),
+		Help: proto.String("help"),
+		Type: dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{{
+			Label:   []*dto.LabelPair{{Name: proto.String("item"), Value: proto.String("eth0")}},
+			Counter: &dto.Counter{Value: proto.Float64(value)},
+		}},
+	}
+}
+
+func TestOpenMetrics(t *testing.T) {
+	gauge := &dto.MetricFamily{
+		Name:   proto.String("service_status"),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(0)}}},
+	}
+	t0 := time.Unix(1600000000, 0)
+	tracker := &createdTracker{}
+
+	steps := []struct {
+		now     time.Time
+		value   float64
+		want    string
+		notWant string
+	}{
+		{
+			// The first scrape doesn't know when counters were created.
+			now:     t0,
+			value:   10,
+			want:    "# TYPE net_packets counter\nnet_packets_total{item=\"eth0\"} 10.0\n",
+			notWant: "_created",
+		},
+		{
+			now:     t0.Add(10 * time.Second),
+			value:   20,
+			notWant: "_created",
+		},
+		{
+			// The counter was reset after the previous scrape.
+			now:   t0.Add(20 * time.Second),
+			value: 2,
+			want:  "net_packets_total{item=\"eth0\"} 2.0\nnet_packets_created{item=\"eth0\"} 1.60000001e+09\n",
+		},
+	}
+
+	for i, step := range steps {
+		mfs := normalizeForOpenMetrics([]*dto.MetricFamily{counterFamily("net_packets", step.value), gauge})
+		created := tracker.track(mfs, step.now)
+
+		var buffer bytes.Buffer
+
+		if err := writeOpenMetrics(&buffer, mfs, created); err != nil {
+			t.Fatal(err)
+		}
+
+		got := buffer.String()
+
+		if !strings.Contains(got, step.want) {
+			t.Errorf("step %d: output = %s, want it to contain %s", i, got, step.want)
+		}
+
+		if step.notWant != "" && strings.Contains(got, step.notWant) {
+			t.Errorf("step %d: output = %s, want it to not contain %s", i, got, step.notWant)
+		}
+
+		if !strings.Contains(got, "# TYPE service_status gauge\n") || !strings.HasSuffix(got, "# EOF\n") {
+			t.Errorf("step %d: output = %s, want a gauge and the EOF marker", i, got)
+		}
+	}
+}
+
+func TestNormalizeForOpenMetricsConflict(t *testing.T) {
+	mfs := normalizeForOpenMetrics([]*dto.MetricFamily{counterFamily("requests", 1), counterFamily("requests_total", 2)})
+
+	if mfs[0].GetName() != "requests" || mfs[1].GetName() != "requests_total" {
+		t.Errorf("names = %s, %s, want requests, requests_total", mfs[0].GetName(), mfs[1].GetName())
+	}
+}
@@ -25,8 +25,11 @@ import (
 	"errors"
 	"glouton/logger"
 	"glouton/types"
+	"math"
 	"net/http"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -38,6 +41,7 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/pkg/value"
 )
 
 const (
@@ -62,6 +66,18 @@ type Registry struct {
 	GloutonPort    string
 	BleemeoAgentID string
 	MetricFormat   types.MetricFormat
+	// AlignTimestamps stamps all points of a collection cycle with the cycle
+	// start time, truncated to a multiple of the collection interval.
+	AlignTimestamps bool
+	// CollectionDone is called after each collection cycle which didn't fail.
+	CollectionDone func(t0 time.Time)
+	// GlobalLabels are added to all points, pushed or gathered. They replace the
+	// labels with the same name. Use UpdateGlobalLabels to change them once the
+	// registry is used.
+	GlobalLabels map[string]string
+	// MetricRelabelConfigs are applied to the points sent to PushPoint, e.g. to rename
+	// metrics before they are stored. A point dropped by a rule isn't sent.
+	MetricRelabelConfigs []*relabel.Config
 
 	l sync.Mutex
 
@@ -84,6 +100,9 @@ type Registry struct {
 	lastPushedPointsCleanup    time.Time
 	currentDelay               time.Duration
 	updateDelayC               chan interface{}
+	cycleStart                 time.Time
+	cycleTimestamp             time.Time
+	openMetricsCreated         createdTracker
 }
 
 type registration struct {
@@ -271,6 +290,31 @@ func (r *Registry) UpdateBleemeoAgentID(ctx context.Context, agentID string) {
 	r.l.Lock()
 	defer r.l.Unlock()
 
+	r.updateLabels(func() {
+		r.BleemeoAgentID = agentID
+	})
+}
+
+// UpdateGlobalLabels change the GlobalLabels and wait for all pending metrics emission.
+// Like UpdateBleemeoAgentID, all call to r.PushPoint will use new labels when this function return.
+func (r *Registry) UpdateGlobalLabels(ctx context.Context, globalLabels map[string]string) {
+	r.init()
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if reflect.DeepEqual(r.GlobalLabels, globalLabels) {
+		return
+	}
+
+	r.updateLabels(func() {
+		r.GlobalLabels = globalLabels
+	})
+}
+
+// updateLabels calls update once no points are being emitted and apply the new labels
+// to all gatherers. The lock must be held.
+func (r *Registry) updateLabels(update func()) {
 	r.blockRunOnce = true
 
 	// Wait for runOnce to finish since it may sent points with old labels.
@@ -288,9 +332,9 @@ func (r *Registry) UpdateBleemeoAgentID(ctx context.Context, agentID string) {
 		r.condition.Wait()
 	}
 
-	r.BleemeoAgentID = agentID
+	update()
 
-	// Since the updated Agent ID may change metrics labels, drop pushed points
+	// Since the update may change metrics labels, drop pushed points
 	r.pushedPoints = make(map[string]types.MetricPoint)
 	r.pushedPointsExpiration = make(map[string]time.Time)
 
@@ -424,6 +468,12 @@ func (r *Registry) Exporter() http.Handler {
 
 		wrapper.SetState(state)
 
+		if expfmt.NegotiateIncludingOpenMetrics(req.Header) == expfmt.FmtOpenMetrics {
+			r.serveOpenMetrics(w, wrapper)
+
+			return
+		}
+
 		promhttp.HandlerFor(wrapper, promhttp.HandlerOpts{
 			ErrorHandling: promhttp.ContinueOnError,
 			ErrorLog:      prefixLogger("/metrics endpoint:"),
@@ -434,6 +484,30 @@ func (r *Registry) Exporter() http.Handler {
 	return handler
 }
 
+// serveOpenMetrics writes the metrics in the OpenMetrics format. Counters get the "_total"
+// suffix and a created timestamp when it's known.
+func (r *Registry) serveOpenMetrics(w http.ResponseWriter, g prometheus.Gatherer) {
+	mfs, err := g.Gather()
+	if err != nil {
+		if len(mfs) == 0 {
+			http.Error(w, "An error has occurred while gathering metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		logger.V(1).Printf("/metrics endpoint: error gathering metrics: %v", err)
+	}
+
+	mfs = normalizeForOpenMetrics(mfs)
+	created := r.openMetricsCreated.track(mfs, time.Now())
+
+	w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+
+	if err := writeOpenMetrics(w, mfs, created); err != nil {
+		logger.V(1).Printf("/metrics endpoint: error encoding metrics: %v", err)
+	}
+}
+
 // WithTTL return a AddMetricPointFunction with TTL on pushed points.
 func (r *Registry) WithTTL(ttl time.Duration) types.PointPusher {
 	r.init()
@@ -533,25 +607,34 @@ func (r *Registry) runOnce() {
 		gatherers = append(gatherers, reg.gatherer)
 	}
 
-	r.l.Unlock()
-
 	t0 := time.Now()
 
+	if r.AlignTimestamps {
+		r.cycleStart = t0
+		r.cycleTimestamp = t0.Truncate(r.currentDelay)
+	}
+
+	r.l.Unlock()
+
 	r.updatePushedPoints()
 
 	var points []types.MetricPoint
 
+	failed := false
+
 	if r.MetricFormat == types.MetricFormatPrometheus {
 		var err error
 
 		points, err = labeledGatherers(gatherers).GatherPoints(GatherState{QueryType: All})
 		if err != nil {
 			if len(points) == 0 {
-				logger.Printf("Gather of metrics failed: %v", err)
+				failed = true
+
+				logger.ErrorfLimited("Gather of metrics failed: %v", err)
 			} else {
 				// When there is points, log at lower level because we known that some gatherer always
 				// fail on some setup. node_exporter may sent "node_rapl_package_joules_total" duplicated.
-				logger.V(1).Printf("Gather of metrics failed, some metrics may be missing: %v", err)
+				logger.V(1).ErrorfLimited("Gather of metrics failed, some metrics may be missing: %v", err)
 			}
 		}
 	} else if r.MetricFormat == types.MetricFormatBleemeo {
@@ -559,7 +642,9 @@ func (r *Registry) runOnce() {
 
 		err := r.metricLegacyGatherTime.Write(&metric)
 		if err != nil {
-			logger.Printf("Gather of metrics failed, some metrics may be missing: %v", err)
+			failed = true
+
+			logger.ErrorfLimited("Gather of metrics failed, some metrics may be missing: %v", err)
 		} else {
 			value := metric.GetGauge().GetValue()
 			points = append(points, types.MetricPoint{
@@ -577,46 +662,151 @@ func (r *Registry) runOnce() {
 		r.metricGatherBackgroundTime.Observe(time.Since(t0).Seconds())
 	}
 
+	r.l.Lock()
+	points = r.alignTimestamps(points)
+	points = r.addGlobalLabels(r.relabelPoints(points))
+	r.cycleStart = time.Time{}
+	r.cycleTimestamp = time.Time{}
+	r.l.Unlock()
+
 	if len(points) > 0 {
 		r.PushPoint.PushPoints(points)
 	}
 
+	if !failed && r.CollectionDone != nil {
+		r.CollectionDone(t0)
+	}
+
 	r.l.Lock()
 	r.countRunOnce--
 	r.condition.Broadcast()
 	r.l.Unlock()
 }
 
+// familiesToMetricPoints convert families to points. Summaries and histograms are
+// expanded like Prometheus does (_sum, _count and quantile or _bucket series).
+// Points without timestamp use the current time.
 func familiesToMetricPoints(families []*dto.MetricFamily) []types.MetricPoint {
-	samples, err := expfmt.ExtractSamples(
-		&expfmt.DecodeOptions{Timestamp: model.Now()},
-		families...,
-	)
-	if err != nil {
-		logger.Printf("Conversion of metrics failed, some metrics may be missing: %v", err)
+	now := model.Now().Time()
+	count := 0
+
+	for _, mf := range families {
+		switch mf.GetType() {
+		case dto.MetricType_SUMMARY:
+			for _, m := range mf.Metric {
+				count += len(m.GetSummary().GetQuantile()) + 2
+			}
+		case dto.MetricType_HISTOGRAM:
+			for _, m := range mf.Metric {
+				count += len(m.GetHistogram().GetBucket()) + 3
+			}
+		default:
+			count += len(mf.Metric)
+		}
 	}
 
-	result := make([]types.MetricPoint, len(samples))
+	result := make([]types.MetricPoint, 0, count)
 
-	for i, sample := range samples {
-		labels := make(map[string]string, len(sample.Metric))
-
-		for k, v := range sample.Metric {
-			labels[string(k)] = string(v)
+	for _, mf := range families {
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER, dto.MetricType_GAUGE, dto.MetricType_UNTYPED, dto.MetricType_SUMMARY, dto.MetricType_HISTOGRAM:
+		default:
+			logger.Printf("Conversion of metrics failed, some metrics may be missing: unknown metric family type %v", mf.GetType())
+			continue
 		}
 
-		result[i] = types.MetricPoint{
-			Labels: labels,
-			Point: types.Point{
-				Time:  sample.Timestamp.Time(),
-				Value: float64(sample.Value),
-			},
+		name := mf.GetName()
+
+		for _, m := range mf.Metric {
+			timestamp := now
+			if m.TimestampMs != nil {
+				timestamp = time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond))
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				if m.Counter != nil {
+					result = appendPoint(result, m.Label, name, "", "", m.Counter.GetValue(), timestamp)
+				}
+			case dto.MetricType_GAUGE:
+				if m.Gauge != nil {
+					result = appendPoint(result, m.Label, name, "", "", m.Gauge.GetValue(), timestamp)
+				}
+			case dto.MetricType_UNTYPED:
+				if m.Untyped != nil {
+					result = appendPoint(result, m.Label, name, "", "", m.Untyped.GetValue(), timestamp)
+				}
+			case dto.MetricType_SUMMARY:
+				if m.Summary == nil {
+					continue
+				}
+
+				for _, q := range m.Summary.Quantile {
+					quantile := strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)
+					result = appendPoint(result, m.Label, name, model.QuantileLabel, quantile, q.GetValue(), timestamp)
+				}
+
+				result = appendPoint(result, m.Label, name+"_sum", "", "", m.Summary.GetSampleSum(), timestamp)
+				result = appendPoint(result, m.Label, name+"_count", "", "", float64(m.Summary.GetSampleCount()), timestamp)
+			case dto.MetricType_HISTOGRAM:
+				if m.Histogram == nil {
+					continue
+				}
+
+				infSeen := false
+
+				for _, b := range m.Histogram.Bucket {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+
+					upperBound := strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)
+					result = appendPoint(result, m.Label, name+"_bucket", model.BucketLabel, upperBound, float64(b.GetCumulativeCount()), timestamp)
+				}
+
+				sampleCount := float64(m.Histogram.GetSampleCount())
+
+				result = appendPoint(result, m.Label, name+"_sum", "", "", m.Histogram.GetSampleSum(), timestamp)
+				result = appendPoint(result, m.Label, name+"_count", "", "", sampleCount, timestamp)
+
+				if !infSeen {
+					result = appendPoint(result, m.Label, name+"_bucket", model.BucketLabel, "+Inf", sampleCount, timestamp)
+				}
+			}
 		}
 	}
 
 	return result
 }
 
+// appendPoint append a point with the labels pairs, the metric name and an optional extra label.
+func appendPoint(points []types.MetricPoint, pairs []*dto.LabelPair, name string, extraName string, extraValue string, value float64, timestamp time.Time) []types.MetricPoint {
+	size := len(pairs) + 1
+	if extraName != "" {
+		size++
+	}
+
+	labels := make(map[string]string, size)
+
+	for _, p := range pairs {
+		labels[p.GetName()] = p.GetValue()
+	}
+
+	if extraName != "" {
+		labels[extraName] = extraValue
+	}
+
+	labels[types.LabelName] = name
+
+	return append(points, types.MetricPoint{
+		Labels: labels,
+		Point: types.Point{
+			Time:  timestamp,
+			Value: value,
+		},
+	})
+}
+
 // sleep such are time.Now() is aligned on a multiple of interval.
 func sleepToAlign(interval time.Duration) {
 	now := time.Now()
@@ -631,6 +821,36 @@ func sleepToAlign(interval time.Duration) {
 	time.Sleep(nextMultiple.Sub(now))
 }
 
+// alignTimestamps returns the points with the timestamp of points produced during the current
+// collection cycle set to the cycle timestamp. points is not modified, a copy is returned when
+// a timestamp changes. The lock must be held.
+func (r *Registry) alignTimestamps(points []types.MetricPoint) []types.MetricPoint {
+	if r.cycleTimestamp.IsZero() {
+		return points
+	}
+
+	var result []types.MetricPoint
+
+	for i, p := range points {
+		if p.Time.Before(r.cycleStart) || p.Time.Equal(r.cycleTimestamp) {
+			continue
+		}
+
+		if result == nil {
+			result = make([]types.MetricPoint, len(points))
+			copy(result, points)
+		}
+
+		result[i].Time = r.cycleTimestamp
+	}
+
+	if result == nil {
+		return points
+	}
+
+	return result
+}
+
 // pushPoint add a new point to the list of pushed point with a specified TTL.
 // As for AddMetricPointFunction, points should not be mutated after the call.
 func (r *Registry) pushPoint(points []types.MetricPoint, ttl time.Duration) {
@@ -645,12 +865,23 @@ func (r *Registry) pushPoint(points []types.MetricPoint, ttl time.Duration) {
 	now := time.Now()
 	deadline := now.Add(ttl)
 
+	points = r.alignTimestamps(points)
+
 	for _, point := range points {
 		extraLabels := r.addMetaLabels(point.Labels)
 		newLabels, _ := r.applyRelabel(extraLabels)
 		newLabelsMap := newLabels.Map()
 		key := types.LabelsToText(newLabelsMap)
 		point.Labels = newLabelsMap
+
+		// A staleness marker expires the series now instead of after its TTL.
+		if value.IsStaleNaN(point.Value) {
+			delete(r.pushedPoints, key)
+			delete(r.pushedPointsExpiration, key)
+
+			continue
+		}
+
 		r.pushedPoints[key] = point
 		r.pushedPointsExpiration[key] = deadline
 	}
@@ -665,6 +896,8 @@ func (r *Registry) pushPoint(points []types.MetricPoint, ttl time.Duration) {
 		}
 	}
 
+	points = r.addGlobalLabels(r.relabelPoints(points))
+
 	r.l.Unlock()
 
 	if r.PushPoint != nil {
@@ -677,12 +910,43 @@ func (r *Registry) pushPoint(points []types.MetricPoint, ttl time.Duration) {
 	r.l.Unlock()
 }
 
+// addGlobalLabels returns a copy of the points with the global labels. The points
+// must not be mutated, they could still be used by the caller. The lock must be held.
+func (r *Registry) addGlobalLabels(points []types.MetricPoint) []types.MetricPoint {
+	if len(r.GlobalLabels) == 0 {
+		return points
+	}
+
+	result := make([]types.MetricPoint, len(points))
+
+	for i, point := range points {
+		labels := make(map[string]string, len(point.Labels)+len(r.GlobalLabels))
+
+		for k, v := range point.Labels {
+			labels[k] = v
+		}
+
+		for k, v := range r.GlobalLabels {
+			labels[k] = v
+		}
+
+		point.Labels = labels
+		result[i] = point
+	}
+
+	return result
+}
+
 func (r *Registry) addMetaLabels(input map[string]string) map[string]string {
 	result := make(map[string]string)
 	for k, v := range input {
 		result[k] = v
 	}
 
+	for k, v := range r.GlobalLabels {
+		result[k] = v
+	}
+
 	result[types.LabelMetaGloutonFQDN] = r.FQDN
 	result[types.LabelMetaGloutonPort] = r.GloutonPort
 
@@ -742,9 +1006,62 @@ func (r *Registry) setupGatherer(reg *registration, source prometheus.Gatherer)
 	extraLabels := r.addMetaLabels(reg.originalExtraLabels)
 	promLabels, annotations := r.applyRelabel(extraLabels)
 	g := newLabeledGatherer(source, promLabels, annotations)
+
+	// Keep the status of the last gather when the labels are updated.
+	if reg.gatherer.status != nil {
+		g.status = reg.gatherer.status
+	}
+
 	reg.gatherer = g
 }
 
+// GathererStatus is the state of a gatherer registered with RegisterGatherer.
+type GathererStatus struct {
+	ID     int
+	Labels map[string]string
+	// LastGather is the start time of the last background gather, zero if it never ran.
+	LastGather   time.Time
+	LastDuration time.Duration
+	LastError    error
+}
+
+// GathererStatuses returns the state of all registered gatherers, sorted by ID.
+func (r *Registry) GathererStatuses() []GathererStatus {
+	r.init()
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	result := make([]GathererStatus, 0, len(r.registrations))
+
+	for id, reg := range r.registrations {
+		status := GathererStatus{
+			ID:     id,
+			Labels: make(map[string]string, len(reg.gatherer.labels)),
+		}
+
+		for _, l := range reg.gatherer.labels {
+			status.Labels[l.GetName()] = l.GetValue()
+		}
+
+		if s := reg.gatherer.status; s != nil {
+			s.l.Lock()
+			status.LastGather = s.lastGather
+			status.LastDuration = s.lastDuration
+			status.LastError = s.lastError
+			s.l.Unlock()
+		}
+
+		result = append(result, status)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
+
 // Describe implement prometheus.Collector.
 func (c *pushCollector) Describe(chan<- *prometheus.Desc) {
 }
@@ -758,6 +1075,7 @@ func (c *pushCollector) Collect(ch chan<- prometheus.Metric) {
 	replacer := strings.NewReplacer(".", "_")
 
 	c.lastPushedPointsCleanup = now
+	helps := pushedPointsHelp(c.pushedPoints)
 
 	for key, p := range c.pushedPoints {
 		expiration := c.pushedPointsExpiration[key]
@@ -787,8 +1105,9 @@ func (c *pushCollector) Collect(ch chan<- prometheus.Metric) {
 		}
 
 		promMetric, err := prometheus.NewConstMetric(
-			prometheus.NewDesc(p.Labels["__name__"], "", labelKeys, nil),
-			prometheus.UntypedValue,
+			prometheus.NewDesc(p.Labels["__name__"], helps[p.Labels["__name__"]], labelKeys, nil),
+			// Pushed points are rates, percentages or statuses, never cumulative counters.
+			prometheus.GaugeValue,
 			p.Value,
 			labelValues...,
 		)
@@ -800,3 +1119,30 @@ func (c *pushCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.NewMetricWithTimestamp(p.Time, promMetric)
 	}
 }
+
+// pushedPointsHelp returns the help text of each metric name, it contains the metric unit.
+// All series of a metric must have the same help, the smallest unit is used if they disagree.
+func pushedPointsHelp(points map[string]types.MetricPoint) map[string]string {
+	units := make(map[string]string)
+
+	for _, p := range points {
+		name := p.Labels[types.LabelName]
+		unit := p.Annotations.Unit
+
+		if unit == "" {
+			continue
+		}
+
+		if current, ok := units[name]; !ok || unit < current {
+			units[name] = unit
+		}
+	}
+
+	helps := make(map[string]string, len(units))
+
+	for name, unit := range units {
+		helps[name] = "Unit: " + unit
+	}
+
+	return helps
+}
@@ -23,13 +23,18 @@ package registry
 
 import (
 	"context"
+	"fmt"
 	"glouton/types"
+	"math"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
 	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/relabel"
 )
@@ -265,14 +270,14 @@ func TestRegistry_pushPoint(t *testing.T) {
 		{
 			Name: &metricName,
 			Help: &helpText,
-			Type: dto.MetricType_UNTYPED.Enum(),
+			Type: dto.MetricType_GAUGE.Enum(),
 			Metric: []*dto.Metric{
 				{
 					Label: []*dto.LabelPair{
 						{Name: &dummyName, Value: &dummyValue},
 						{Name: &jobName, Value: &jobValue},
 					},
-					Untyped: &dto.Untyped{
+					Gauge: &dto.Gauge{
 						Value: &value,
 					},
 					TimestampMs: &t0MS,
@@ -317,7 +322,7 @@ func TestRegistry_pushPoint(t *testing.T) {
 		{
 			Name: &metricName,
 			Help: &helpText,
-			Type: dto.MetricType_UNTYPED.Enum(),
+			Type: dto.MetricType_GAUGE.Enum(),
 			Metric: []*dto.Metric{
 				{
 					Label: []*dto.LabelPair{
@@ -325,7 +330,7 @@ func TestRegistry_pushPoint(t *testing.T) {
 						{Name: &instanceIDName, Value: &instanceIDValue},
 						{Name: &jobName, Value: &jobValue},
 					},
-					Untyped: &dto.Untyped{
+					Gauge: &dto.Gauge{
 						Value: &value,
 					},
 					TimestampMs: &t0MS,
@@ -339,6 +344,313 @@ func TestRegistry_pushPoint(t *testing.T) {
 	}
 }
 
+func TestRegistry_GlobalLabels(t *testing.T) {
+	var pushed []types.MetricPoint
+
+	reg := &Registry{
+		PushPoint: pushFunction(func(points []types.MetricPoint) {
+			pushed = append(pushed, points...)
+		}),
+		GlobalLabels: map[string]string{"datacenter": "dc1"},
+	}
+
+	gatherer := &fakeGatherer{name: "gathered"}
+	gatherer.fillResponse()
+
+	if _, err := reg.RegisterGatherer(gatherer, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reg.WithTTL(time.Hour).PushPoints([]types.MetricPoint{
+		{
+			Point:  types.Point{Value: 1.0, Time: time.Now()},
+			Labels: map[string]string{"__name__": "pushed"},
+		},
+	})
+
+	if len(pushed) != 1 || pushed[0].Labels["datacenter"] != "dc1" {
+		t.Errorf("pushed = %v, want one point with datacenter=dc1", pushed)
+	}
+
+	for _, datacenter := range []string{"dc1", "dc2"} {
+		reg.UpdateGlobalLabels(context.Background(), map[string]string{"datacenter": datacenter})
+
+		reg.WithTTL(time.Hour).PushPoints([]types.MetricPoint{
+			{
+				Point:  types.Point{Value: 1.0, Time: time.Now()},
+				Labels: map[string]string{"__name__": "pushed"},
+			},
+		})
+
+		got, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := make(map[string]bool)
+
+		for _, mf := range got {
+			for _, m := range mf.Metric {
+				var value string
+
+				for _, l := range m.Label {
+					if l.GetName() == "datacenter" {
+						value = l.GetValue()
+					}
+				}
+
+				if value != datacenter {
+					t.Errorf("%s has datacenter=%#v, want %#v", mf.GetName(), value, datacenter)
+				}
+			}
+
+			names[mf.GetName()] = true
+		}
+
+		if !names["gathered"] || !names["pushed"] {
+			t.Errorf("reg.Gather() = %v, want gathered and pushed metrics", got)
+		}
+	}
+}
+
+func TestRegistry_AlignTimestamps(t *testing.T) {
+	var (
+		l      sync.Mutex
+		pushed []types.MetricPoint
+	)
+
+	reg := &Registry{
+		MetricFormat:    types.MetricFormatPrometheus,
+		AlignTimestamps: true,
+		PushPoint: pushFunction(func(points []types.MetricPoint) {
+			l.Lock()
+			defer l.Unlock()
+
+			pushed = append(pushed, points...)
+		}),
+	}
+
+	gather := &fakeGatherer{name: "gathered"}
+	gather.fillResponse()
+
+	if _, err := reg.RegisterGatherer(gather, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pusher := reg.WithTTL(time.Hour)
+
+	var (
+		callerPoints []types.MetricPoint
+		callerTime   time.Time
+	)
+
+	reg.AddPushPointsCallback(func() {
+		time.Sleep(10 * time.Millisecond)
+
+		callerTime = time.Now()
+		callerPoints = []types.MetricPoint{
+			{
+				Point:  types.Point{Value: 1.0, Time: callerTime},
+				Labels: map[string]string{types.LabelName: "pushed"},
+			},
+		}
+
+		pusher.PushPoints(callerPoints)
+	})
+
+	old := time.Now().Add(-time.Hour)
+
+	reg.runOnce()
+
+	pusher.PushPoints([]types.MetricPoint{
+		{
+			Point:  types.Point{Value: 1.0, Time: old},
+			Labels: map[string]string{types.LabelName: "outside_cycle"},
+		},
+	})
+
+	var cycleTime time.Time
+
+	names := make(map[string]bool)
+
+	for _, p := range pushed {
+		names[p.Labels[types.LabelName]] = true
+
+		switch p.Labels[types.LabelName] {
+		case "outside_cycle":
+			if !p.Time.Equal(old) {
+				t.Errorf("outside_cycle time = %v, want %v", p.Time, old)
+			}
+		default:
+			if cycleTime.IsZero() {
+				cycleTime = p.Time
+			}
+
+			if !p.Time.Equal(cycleTime) {
+				t.Errorf("%s time = %v, want %v", p.Labels[types.LabelName], p.Time, cycleTime)
+			}
+		}
+	}
+
+	if !names["gathered"] || !names["pushed"] {
+		t.Errorf("pushed metrics = %v, want gathered and pushed", names)
+	}
+
+	// The points given to PushPoints belong to the caller and must not be modified.
+	if !callerPoints[0].Time.Equal(callerTime) {
+		t.Errorf("caller point time = %v, want unchanged %v", callerPoints[0].Time, callerTime)
+	}
+
+	if cycleTime.IsZero() || !cycleTime.Equal(cycleTime.Truncate(10*time.Second)) {
+		t.Errorf("cycle time = %v, want a multiple of the collection interval", cycleTime)
+	}
+}
+
+// nodeExporterLikeFamilies returns families similar to a large node_exporter output.
+func nodeExporterLikeFamilies(familyCount int, seriesPerFamily int) []*dto.MetricFamily {
+	families := make([]*dto.MetricFamily, 0, familyCount+2)
+
+	for i := 0; i < familyCount; i++ {
+		mf := &dto.MetricFamily{
+			Name: proto.String(fmt.Sprintf("node_metric_%d", i)),
+			Type: dto.MetricType_GAUGE.Enum(),
+		}
+
+		if i%2 == 0 {
+			mf.Type = dto.MetricType_COUNTER.Enum()
+		}
+
+		for j := 0; j < seriesPerFamily; j++ {
+			m := &dto.Metric{
+				Label: []*dto.LabelPair{
+					{Name: proto.String("cpu"), Value: proto.String(fmt.Sprint(j))},
+					{Name: proto.String("device"), Value: proto.String(fmt.Sprintf("sd%d", j))},
+					{Name: proto.String("mode"), Value: proto.String("idle")},
+				},
+			}
+
+			if i%2 == 0 {
+				m.Counter = &dto.Counter{Value: proto.Float64(float64(j))}
+			} else {
+				m.Gauge = &dto.Gauge{Value: proto.Float64(float64(j))}
+			}
+
+			mf.Metric = append(mf.Metric, m)
+		}
+
+		families = append(families, mf)
+	}
+
+	families = append(families,
+		&dto.MetricFamily{
+			Name: proto.String("request_duration_seconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{
+				{
+					Label: []*dto.LabelPair{{Name: proto.String("handler"), Value: proto.String("/metrics")}},
+					Histogram: &dto.Histogram{
+						SampleCount: proto.Uint64(42),
+						SampleSum:   proto.Float64(1.5),
+						Bucket: []*dto.Bucket{
+							{UpperBound: proto.Float64(0.005), CumulativeCount: proto.Uint64(10)},
+							{UpperBound: proto.Float64(1e6), CumulativeCount: proto.Uint64(40)},
+						},
+					},
+				},
+				{
+					TimestampMs: proto.Int64(1583145000000),
+					Histogram: &dto.Histogram{
+						SampleCount: proto.Uint64(3),
+						Bucket: []*dto.Bucket{
+							{UpperBound: proto.Float64(math.Inf(1)), CumulativeCount: proto.Uint64(3)},
+						},
+					},
+				},
+			},
+		},
+		&dto.MetricFamily{
+			Name: proto.String("gc_duration_seconds"),
+			Type: dto.MetricType_SUMMARY.Enum(),
+			Metric: []*dto.Metric{
+				{
+					Summary: &dto.Summary{
+						SampleCount: proto.Uint64(7),
+						SampleSum:   proto.Float64(0.25),
+						Quantile: []*dto.Quantile{
+							{Quantile: proto.Float64(0.5), Value: proto.Float64(0.01)},
+							{Quantile: proto.Float64(0.99), Value: proto.Float64(0.1)},
+						},
+					},
+				},
+			},
+		},
+		&dto.MetricFamily{
+			Name:   proto.String("untyped_metric"),
+			Type:   dto.MetricType_UNTYPED.Enum(),
+			Metric: []*dto.Metric{{Untyped: &dto.Untyped{Value: proto.Float64(3)}}},
+		},
+	)
+
+	return families
+}
+
+func Test_familiesToMetricPoints(t *testing.T) {
+	families := nodeExporterLikeFamilies(5, 3)
+
+	samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: model.Now()}, families...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := familiesToMetricPoints(families)
+
+	if len(got) != len(samples) {
+		t.Fatalf("len(familiesToMetricPoints()) = %d, want %d", len(got), len(samples))
+	}
+
+	for i, sample := range samples {
+		want := make(map[string]string, len(sample.Metric))
+
+		for k, v := range sample.Metric {
+			want[string(k)] = string(v)
+		}
+
+		if !reflect.DeepEqual(got[i].Labels, want) {
+			t.Errorf("points[%d].Labels = %v, want %v", i, got[i].Labels, want)
+		}
+
+		if got[i].Value != float64(sample.Value) {
+			t.Errorf("points[%d].Value = %v, want %v", i, got[i].Value, sample.Value)
+		}
+
+		if diff := got[i].Time.Sub(sample.Timestamp.Time()); diff < -time.Second || diff > time.Second {
+			t.Errorf("points[%d].Time = %v, want %v", i, got[i].Time, sample.Timestamp.Time())
+		}
+	}
+}
+
+func Benchmark_familiesToMetricPoints(b *testing.B) {
+	families := nodeExporterLikeFamilies(300, 20)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		_ = familiesToMetricPoints(families)
+	}
+}
+
+func Benchmark_extractSamples(b *testing.B) {
+	families := nodeExporterLikeFamilies(300, 20)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: model.Now()}, families...)
+	}
+}
+
 func TestRegistry_applyRelabel(t *testing.T) {
 	type fields struct {
 		relabelConfigs []*relabel.Config
@@ -453,3 +765,63 @@ func TestRegistry_applyRelabel(t *testing.T) {
 		})
 	}
 }
+
+func TestRegistry_MetricRelabelConfigs(t *testing.T) {
+	configs, err := ParseRelabelConfigs([]interface{}{
+		map[string]interface{}{
+			"source_labels": []interface{}{"__name__"},
+			"regex":         "cpu_(.*)",
+			"target_label":  "__name__",
+			"replacement":   "node_cpu_${1}",
+		},
+		map[string]interface{}{
+			"source_labels": []interface{}{"__name__"},
+			"regex":         "debug_.*",
+			"action":        "drop",
+		},
+		map[string]interface{}{
+			"regex":  "item",
+			"action": "labeldrop",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pushed []types.MetricPoint
+
+	reg := &Registry{
+		PushPoint: pushFunction(func(points []types.MetricPoint) {
+			pushed = append(pushed, points...)
+		}),
+		MetricRelabelConfigs: configs,
+	}
+
+	input := []types.MetricPoint{
+		{
+			Point:  types.Point{Value: 1.0, Time: time.Now()},
+			Labels: map[string]string{"__name__": "cpu_used", "item": "cpu0"},
+		},
+		{
+			Point:  types.Point{Value: 2.0, Time: time.Now()},
+			Labels: map[string]string{"__name__": "debug_value"},
+		},
+	}
+
+	reg.WithTTL(time.Hour).PushPoints(input)
+
+	want := []map[string]string{{"__name__": "node_cpu_used"}}
+
+	got := make([]map[string]string, 0, len(pushed))
+	for _, p := range pushed {
+		got = append(got, p.Labels)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pushed labels = %v, want %v", got, want)
+	}
+
+	if input[0].Labels["__name__"] != "cpu_used" {
+		t.Errorf("input point was mutated: %v", input[0].Labels)
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scrapper
+
+import (
+	"errors"
+	"fmt"
+	"glouton/derive"
+	"glouton/logger"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	deriveRateSuffix  = "_rate"
+	deriveDeltaSuffix = "_delta"
+)
+
+// JobConfig is a scrape job, it uses the same keys as a Prometheus scrape_config.
+type JobConfig struct {
+	JobName       string         `yaml:"job_name"`
+	Scheme        string         `yaml:"scheme"`
+	MetricsPath   string         `yaml:"metrics_path"`
+	ScrapeTimeout model.Duration `yaml:"scrape_timeout"`
+	// HonorLabels keeps the scraped labels conflicting with the target labels. Otherwise the
+	// scraped label is renamed to "exported_<name>".
+	HonorLabels bool           `yaml:"honor_labels"`
+	BasicAuth   *BasicAuth     `yaml:"basic_auth"`
+	BearerToken string         `yaml:"bearer_token"`
+	Static      []StaticConfig `yaml:"static_configs"`
+	// RelabelConfigs are applied to each scraped series, a series dropped by a rule is not gathered.
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs"`
+	// Derive adds the rate or delta of scraped counters, they are computed after the relabeling.
+	Derive []DeriveConfig `yaml:"derive"`
+}
+
+// DeriveConfig adds a gauge "<metric>_rate" or "<metric>_delta" computed from a scraped counter.
+type DeriveConfig struct {
+	Metric      string         `yaml:"metric"`
+	Mode        string         `yaml:"mode"`
+	MinInterval model.Duration `yaml:"min_interval"`
+	WrapAt      float64        `yaml:"wrap_at"`
+
+	config derive.Config
+}
+
+// BasicAuth is the credentials used for HTTP basic authentication.
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// StaticConfig is a list of targets sharing the same labels.
+type StaticConfig struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// JobTarget is one target of a scrape job.
+type JobTarget struct {
+	URL     *url.URL
+	Labels  map[string]string
+	job     *JobConfig
+	deriver *derive.Deriver
+}
+
+// ParseJobs reads the scrape jobs from the configuration and fills the default values.
+func ParseJobs(config interface{}) ([]*JobConfig, error) {
+	var jobs []*JobConfig
+
+	marshalled, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(marshalled, &jobs); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(jobs))
+
+	for _, job := range jobs {
+		if job.JobName == "" {
+			return nil, errors.New("a scrape job has no job_name")
+		}
+
+		if names[job.JobName] {
+			return nil, fmt.Errorf("the scrape job %s is defined twice", job.JobName)
+		}
+
+		names[job.JobName] = true
+
+		if job.Scheme == "" {
+			job.Scheme = "http"
+		}
+
+		if job.Scheme != "http" && job.Scheme != "https" {
+			return nil, fmt.Errorf("scrape job %s: unsupported scheme %#v", job.JobName, job.Scheme)
+		}
+
+		if job.MetricsPath == "" {
+			job.MetricsPath = defaultUnixHTTPPath
+		}
+
+		if job.ScrapeTimeout <= 0 {
+			job.ScrapeTimeout = model.Duration(defaultTimeout)
+		}
+
+		if job.BasicAuth != nil && job.BearerToken != "" {
+			return nil, fmt.Errorf("scrape job %s: at most one of basic_auth and bearer_token must be configured", job.JobName)
+		}
+
+		for i, d := range job.Derive {
+			if d.Metric == "" {
+				return nil, fmt.Errorf("scrape job %s: a derive entry has no metric", job.JobName)
+			}
+
+			mode, err := derive.ParseMode(d.Mode)
+			if err != nil {
+				return nil, fmt.Errorf("scrape job %s: metric %s: %w", job.JobName, d.Metric, err)
+			}
+
+			job.Derive[i].config = derive.Config{
+				Mode:        mode,
+				MinInterval: time.Duration(d.MinInterval),
+				WrapAt:      d.WrapAt,
+			}
+		}
+	}
+
+	return jobs, nil
+}
+
+// Targets returns the targets of the job.
+func (j *JobConfig) Targets() []*JobTarget {
+	var targets []*JobTarget
+
+	for _, static := range j.Static {
+		for _, address := range static.Targets {
+			u := &url.URL{
+				Scheme: j.Scheme,
+				Host:   address,
+				Path:   path.Join("/", j.MetricsPath),
+			}
+
+			targets = append(targets, &JobTarget{
+				URL:     u,
+				Labels:  static.Labels,
+				job:     j,
+				deriver: derive.New(),
+			})
+		}
+	}
+
+	return targets
+}
+
+// HostPort return host:port.
+func (t *JobTarget) HostPort() string {
+	return t.URL.Host
+}
+
+// Gather implement prometheus.Gatherer.
+func (t *JobTarget) Gather() ([]*dto.MetricFamily, error) {
+	opts := fetchOptions{
+		Timeout:     time.Duration(t.job.ScrapeTimeout),
+		BearerToken: t.job.BearerToken,
+	}
+
+	if t.job.BasicAuth != nil {
+		opts.Username = t.job.BasicAuth.Username
+		opts.Password = t.job.BasicAuth.Password
+	}
+
+	mfs, err := fetch(t.URL, t.URL.String(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.Labels) != 0 || len(t.job.RelabelConfigs) != 0 {
+		mfs = t.relabel(mfs)
+	}
+
+	if len(t.job.Derive) > 0 {
+		mfs = append(mfs, t.derive(mfs, time.Now())...)
+	}
+
+	return mfs, nil
+}
+
+// derive returns the gauges computed from the counters listed in the job derive option.
+func (t *JobTarget) derive(mfs []*dto.MetricFamily, now time.Time) []*dto.MetricFamily {
+	var result []*dto.MetricFamily
+
+	t.deriver.Prepare()
+
+	for _, d := range t.job.Derive {
+		for _, mf := range mfs {
+			if mf.GetName() != d.Metric {
+				continue
+			}
+
+			if mf.GetType() != dto.MetricType_COUNTER && mf.GetType() != dto.MetricType_UNTYPED {
+				logger.V(2).Printf("scrape job %s: metric %s isn't a counter, it can't be derived", t.job.JobName, d.Metric)
+
+				continue
+			}
+
+			name := d.Metric + deriveRateSuffix
+			if d.config.Mode == derive.Delta {
+				name = d.Metric + deriveDeltaSuffix
+			}
+
+			family := &dto.MetricFamily{Name: &name, Type: dto.MetricType_GAUGE.Enum()}
+
+			for _, m := range mf.Metric {
+				value := m.GetCounter().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+
+				ts := now
+				if m.TimestampMs != nil {
+					ts = time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond))
+				}
+
+				key := name + "{" + labelsText(m.Label) + "}"
+
+				derived, ok, err := t.deriver.Derive(key, d.config, value, ts)
+				if err != nil || !ok {
+					continue
+				}
+
+				family.Metric = append(family.Metric, &dto.Metric{
+					Label: m.Label,
+					Gauge: &dto.Gauge{Value: &derived},
+				})
+			}
+
+			if len(family.Metric) > 0 {
+				result = append(result, family)
+			}
+		}
+	}
+
+	return result
+}
+
+// labelsText returns the label pairs as a string usable as a key.
+func labelsText(pairs []*dto.LabelPair) string {
+	parts := make([]string, 0, len(pairs))
+
+	for _, p := range pairs {
+		parts = append(parts, p.GetName()+"="+p.GetValue())
+	}
+
+	sort.Strings(parts)
+
+	return strings.Join(parts, ",")
+}
+
+// relabel adds the target labels and applies the relabel rules to each series. As a rule
+// may rename a series, the families are rebuilt from the resulting names.
+func (t *JobTarget) relabel(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	families := make(map[string]*dto.MetricFamily)
+	result := make([]*dto.MetricFamily, 0, len(mfs))
+
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			lbls := relabel.Process(t.seriesLabels(mf.GetName(), m.Label), t.job.RelabelConfigs...)
+			if lbls == nil {
+				continue
+			}
+
+			name := lbls.Get(model.MetricNameLabel)
+			if name == "" {
+				continue
+			}
+
+			family, ok := families[name]
+			if !ok {
+				family = &dto.MetricFamily{Name: &name, Help: mf.Help, Type: mf.Type}
+				families[name] = family
+				result = append(result, family)
+			}
+
+			if family.GetType() != mf.GetType() {
+				logger.V(2).Printf("scrape job %s: series %s relabeled to a metric of another type, ignoring it", t.job.JobName, mf.GetName())
+
+				continue
+			}
+
+			m.Label = labelPairs(lbls)
+			family.Metric = append(family.Metric, m)
+		}
+	}
+
+	return result
+}
+
+// seriesLabels returns the labels of a scraped series with the target labels.
+func (t *JobTarget) seriesLabels(name string, pairs []*dto.LabelPair) labels.Labels {
+	scraped := make(map[string]string, len(pairs))
+	builder := labels.NewBuilder(nil)
+
+	for _, p := range pairs {
+		scraped[p.GetName()] = p.GetValue()
+		builder.Set(p.GetName(), p.GetValue())
+	}
+
+	for k, v := range t.Labels {
+		if value, ok := scraped[k]; ok {
+			if t.job.HonorLabels {
+				continue
+			}
+
+			builder.Set(model.ExportedLabelPrefix+k, value)
+		}
+
+		builder.Set(k, v)
+	}
+
+	builder.Set(model.MetricNameLabel, name)
+
+	return builder.Labels()
+}
+
+// labelPairs converts the labels to label pairs, the metric name and reserved labels are removed.
+func labelPairs(lbls labels.Labels) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(lbls))
+
+	for _, l := range lbls {
+		l := l
+
+		if strings.HasPrefix(l.Name, model.ReservedLabelPrefix) {
+			continue
+		}
+
+		pairs = append(pairs, &dto.LabelPair{Name: &l.Name, Value: &l.Value})
+	}
+
+	return pairs
+}
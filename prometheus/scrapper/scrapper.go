@@ -21,9 +21,11 @@ import (
 	"context"
 	"fmt"
 	"glouton/logger"
+	"glouton/proxy"
 	"glouton/version"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
@@ -32,12 +34,34 @@ import (
 	"github.com/prometheus/common/expfmt"
 )
 
+// unixScheme is the scheme of exporters listening on a unix socket, for
+// example "unix:///run/haproxy-exporter.sock?path=/metrics". The query parameter
+// path is the HTTP path requested and default to /metrics.
+const unixScheme = "unix"
+
+const defaultUnixHTTPPath = "/metrics"
+
+const defaultTimeout = 10 * time.Second
+
+// httpClient is used for exporters listening on TCP, it honors the configured proxy.
+var httpClient = &http.Client{ //nolint:gochecknoglobals
+	Transport: &http.Transport{
+		Proxy:               proxy.FromRequest,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
 // Target is an URL to scrape.
 type Target url.URL
 
-// HostPort return host:port.
+// HostPort return host:port. For a unix socket it's the socket path.
 func (t *Target) HostPort() string {
 	u := (*url.URL)(t)
+	if u.Scheme == unixScheme {
+		return u.Path
+	}
+
 	hostname := u.Hostname()
 	port := u.Port()
 
@@ -46,11 +70,38 @@ func (t *Target) HostPort() string {
 
 // Gather implement prometheus.Gatherer.
 func (t *Target) Gather() ([]*dto.MetricFamily, error) {
-	u := (*url.URL)(t)
+	return fetch((*url.URL)(t), t.requestURL(), fetchOptions{Timeout: defaultTimeout})
+}
 
+// fetchOptions are the options of the HTTP request made to an exporter.
+type fetchOptions struct {
+	Timeout     time.Duration
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+// fetch scrapes the exporter u using the HTTP request requestURL and parses the result.
+func fetch(u *url.URL, requestURL string, opts fetchOptions) ([]*dto.MetricFamily, error) {
 	logger.V(2).Printf("Scrapping Prometheus exporter %s", u.String())
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+	client := httpClient
+
+	if u.Scheme == unixScheme {
+		socketPath := u.Path
+		client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+				DisableKeepAlives: true,
+			},
+		}
+	}
+
+	req, err := http.NewRequest("GET", requestURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("prepare request to Prometheus exporter %s: %v", u.String(), err)
 	}
@@ -58,10 +109,17 @@ func (t *Target) Gather() ([]*dto.MetricFamily, error) {
 	req.Header.Add("Accept", "text/plain;version=0.0.4")
 	req.Header.Set("User-Agent", version.UserAgent())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	switch {
+	case opts.Username != "":
+		req.SetBasicAuth(opts.Username, opts.Password)
+	case opts.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+opts.BearerToken)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
 	defer cancel()
 
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -97,3 +155,19 @@ func (t *Target) Gather() ([]*dto.MetricFamily, error) {
 
 	return result, nil
 }
+
+// requestURL return the URL of the HTTP request. For a unix socket, the host
+// is ignored by the transport.
+func (t *Target) requestURL() string {
+	u := (*url.URL)(t)
+	if u.Scheme != unixScheme {
+		return u.String()
+	}
+
+	path := u.Query().Get("path")
+	if path == "" {
+		path = defaultUnixHTTPPath
+	}
+
+	return (&url.URL{Scheme: "http", Host: "localhost", Path: path}).String()
+}
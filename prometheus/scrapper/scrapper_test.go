@@ -0,0 +1,265 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scrapper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+func TestUnixSocketTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-scrapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "exporter.sock")
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix socket not supported: %v", err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/custom" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprintln(w, "# TYPE requests_total counter")
+		fmt.Fprintln(w, "requests_total 42")
+	})}
+
+	go server.Serve(listener) //nolint:errcheck
+	defer server.Close()
+
+	u, err := url.Parse("unix://" + socketPath + "?path=/custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := (*Target)(u)
+
+	if got := target.HostPort(); got != socketPath {
+		t.Errorf("HostPort() = %#v, want %#v", got, socketPath)
+	}
+
+	families, err := target.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(families) != 1 || families[0].GetName() != "requests_total" || families[0].Metric[0].GetCounter().GetValue() != 42 {
+		t.Errorf("Gather() = %v, want requests_total = 42", families)
+	}
+}
+
+func seriesText(mfs []*dto.MetricFamily) []string {
+	var result []string
+
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			pairs := make([]string, 0, len(m.Label))
+
+			for _, l := range m.Label {
+				pairs = append(pairs, l.GetName()+"="+l.GetValue())
+			}
+
+			result = append(result, mf.GetName()+"{"+strings.Join(pairs, ",")+"}")
+		}
+	}
+
+	return result
+}
+
+func TestJobTarget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "glouton" || password != "secret" || r.URL.Path != "/probe/metrics" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+
+			return
+		}
+
+		fmt.Fprintln(w, "# TYPE requests_total counter")
+		fmt.Fprintln(w, `requests_total{env="dev",code="200"} 42`)
+		fmt.Fprintln(w, `requests_total{env="dev",code="500"} 1`)
+		fmt.Fprintln(w, "# TYPE go_goroutines gauge")
+		fmt.Fprintln(w, "go_goroutines 12")
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	config := `
+- job_name: app
+  metrics_path: probe/metrics
+  scrape_timeout: 2s
+  basic_auth:
+    username: glouton
+    password: secret
+  static_configs:
+    - targets: ["` + host + `"]
+      labels:
+        env: prod
+  relabel_configs:
+    - source_labels: [__name__]
+      regex: go_.*
+      action: drop
+    - source_labels: [code]
+      target_label: status_code
+    - regex: code
+      action: labeldrop
+`
+
+	tests := []struct {
+		name        string
+		honorLabels bool
+		want        []string
+	}{
+		{
+			name: "default",
+			want: []string{
+				"requests_total{env=prod,exported_env=dev,status_code=200}",
+				"requests_total{env=prod,exported_env=dev,status_code=500}",
+			},
+		},
+		{
+			name:        "honor-labels",
+			honorLabels: true,
+			want: []string{
+				"requests_total{env=dev,status_code=200}",
+				"requests_total{env=dev,status_code=500}",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var raw interface{}
+
+		if err := yaml.Unmarshal([]byte(config), &raw); err != nil {
+			t.Fatal(err)
+		}
+
+		jobs, err := ParseJobs(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if len(jobs) != 1 || jobs[0].Scheme != "http" || time.Duration(jobs[0].ScrapeTimeout) != 2*time.Second {
+			t.Fatalf("%s: ParseJobs() = %v, want one http job with a 2s timeout", tt.name, jobs)
+		}
+
+		jobs[0].HonorLabels = tt.honorLabels
+
+		targets := jobs[0].Targets()
+		if len(targets) != 1 || targets[0].HostPort() != host {
+			t.Fatalf("%s: Targets() = %v, want one target %s", tt.name, targets, host)
+		}
+
+		mfs, err := targets[0].Gather()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if got := seriesText(mfs); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: Gather() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJobDerive(t *testing.T) {
+	counter := 100
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "# TYPE requests_total counter")
+		fmt.Fprintf(w, "requests_total{code=\"200\"} %d\n", counter)
+		fmt.Fprintln(w, "# TYPE go_goroutines gauge")
+		fmt.Fprintln(w, "go_goroutines 12")
+	}))
+	defer server.Close()
+
+	raw := []interface{}{map[string]interface{}{
+		"job_name":       "app",
+		"static_configs": []interface{}{map[string]interface{}{"targets": []interface{}{strings.TrimPrefix(server.URL, "http://")}}},
+		"derive": []interface{}{
+			map[string]interface{}{"metric": "requests_total", "mode": "delta"},
+			map[string]interface{}{"metric": "go_goroutines"},
+		},
+	}}
+
+	jobs, err := ParseJobs(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := jobs[0].Targets()[0]
+
+	mfs, err := target.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mfs) != 2 {
+		t.Errorf("first Gather() returned %d families, want 2", len(mfs))
+	}
+
+	counter = 130
+
+	mfs, err = target.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "requests_total_delta{code=200}"
+	if got := seriesText(mfs); len(got) != 3 || got[2] != want {
+		t.Fatalf("Gather() = %v, want %s added", got, want)
+	}
+
+	if value := mfs[2].Metric[0].GetGauge().GetValue(); value != 30 {
+		t.Errorf("requests_total_delta = %v, want 30", value)
+	}
+}
+
+func TestParseJobsInvalid(t *testing.T) {
+	cases := []interface{}{
+		[]interface{}{map[string]interface{}{"scheme": "http"}},
+		[]interface{}{map[string]interface{}{"job_name": "a"}, map[string]interface{}{"job_name": "a"}},
+		[]interface{}{map[string]interface{}{"job_name": "a", "scheme": "ftp"}},
+		[]interface{}{map[string]interface{}{"job_name": "a", "relabel_configs": []interface{}{map[string]interface{}{"action": "replace"}}}},
+		[]interface{}{map[string]interface{}{"job_name": "a", "derive": []interface{}{map[string]interface{}{"metric": "x", "mode": "average"}}}},
+		[]interface{}{map[string]interface{}{"job_name": "a", "derive": []interface{}{map[string]interface{}{"mode": "rate"}}}},
+	}
+
+	for i, c := range cases {
+		if _, err := ParseJobs(c); err == nil {
+			t.Errorf("case %d: ParseJobs() succeeded, want an error", i)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeProxy accepts one CONNECT request and echoes the tunneled data.
+func fakeProxy(t *testing.T, ln net.Listener, requests chan<- *http.Request) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		t.Error(err)
+
+		return
+	}
+
+	requests <- req
+
+	if req.Header.Get("Proxy-Authorization") == "" {
+		_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+
+		return
+	}
+
+	_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+	line, _ := reader.ReadString('\n')
+	_, _ = conn.Write([]byte(line))
+}
+
+func TestDialContext(t *testing.T) {
+	defer Configure("", "", "")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	requests := make(chan *http.Request, 2)
+
+	go fakeProxy(t, ln, requests)
+
+	Configure("", "http://user:secret@"+ln.Addr().String(), "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := DialContext(ctx, "https", "mqtt.example.com:8883")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	req := <-requests
+	if req.Method != "CONNECT" || req.Host != "mqtt.example.com:8883" {
+		t.Errorf("request = %s %s, want CONNECT mqtt.example.com:8883", req.Method, req.Host)
+	}
+
+	if got := req.Header.Get("Proxy-Authorization"); got != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("Proxy-Authorization = %#v", got)
+	}
+
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("read %#v, %v, want \"hello\\n\"", line, err)
+	}
+
+	go fakeProxy(t, ln, requests)
+
+	Configure("", "http://"+ln.Addr().String(), "")
+
+	if _, err := DialContext(ctx, "https", "mqtt.example.com:8883"); err == nil {
+		t.Errorf("DialContext succeeded without proxy credentials")
+	}
+}
+
+func TestForURL(t *testing.T) {
+	defer Configure("", "", "")
+
+	Configure("http://proxy:3128", "http://secure-proxy:3128", "internal.example.com")
+
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{url: "http://api.example.com/", want: "http://proxy:3128"},
+		{url: "https://api.example.com/", want: "http://secure-proxy:3128"},
+		{url: "https://internal.example.com/", want: ""},
+		{url: "http://localhost:8015/", want: ""},
+	}
+
+	for _, c := range cases {
+		u, _ := url.Parse(c.url)
+
+		got, err := ForURL(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotString := ""
+		if got != nil {
+			gotString = got.String()
+		}
+
+		if gotString != c.want {
+			t.Errorf("ForURL(%s) = %#v, want %#v", c.url, gotString, c.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remediation run local commands when a metric becomes critical.
+//
+// Hooks are opt-in and guarded: a hook only run on a transition to critical, at most
+// once per cooldown, never concurrently with itself and is killed after its timeout.
+// Each execution is logged.
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"glouton/command"
+	"glouton/logger"
+	"glouton/threshold"
+	"glouton/types"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCooldown = 10 * time.Minute
+	defaultTimeout  = time.Minute
+	maxOutputLength = 500
+)
+
+// Hook is a command run when the metric Metric (with the item Item if not empty)
+// becomes critical.
+type Hook struct {
+	Metric   string
+	Item     string
+	Command  []string
+	Cooldown time.Duration
+	Timeout  time.Duration
+}
+
+// NewHook returns a Hook. The command is split like a shell would do. Zero cooldown
+// and timeout use the defaults.
+func NewHook(metric string, item string, cmd string, cooldown time.Duration, timeout time.Duration) (Hook, error) {
+	args, err := command.Split(cmd)
+	if err != nil {
+		return Hook{}, err
+	}
+
+	if cooldown == 0 {
+		cooldown = defaultCooldown
+	}
+
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	return Hook{
+		Metric:   metric,
+		Item:     item,
+		Command:  args,
+		Cooldown: cooldown,
+		Timeout:  timeout,
+	}, nil
+}
+
+func (h Hook) match(change threshold.StatusChange) bool {
+	if change.Labels[types.LabelName] != h.Metric {
+		return false
+	}
+
+	return h.Item == "" || change.Annotations.BleemeoItem == h.Item
+}
+
+// Manager run hooks on status changes.
+type Manager struct {
+	hooks []Hook
+	queue chan int
+
+	l       sync.Mutex
+	lastRun map[int]time.Time
+	running map[int]bool
+}
+
+// New returns a Manager for the given hooks.
+func New(hooks []Hook) *Manager {
+	return &Manager{
+		hooks:   hooks,
+		queue:   make(chan int, len(hooks)),
+		lastRun: make(map[int]time.Time),
+		running: make(map[int]bool),
+	}
+}
+
+// OnStatusChanges is the callback to register with the threshold Registry AddStatusNotifiee.
+func (m *Manager) OnStatusChanges(changes []threshold.StatusChange) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	now := time.Now()
+
+	for _, change := range changes {
+		if change.CurrentStatus != types.StatusCritical {
+			continue
+		}
+
+		for i, h := range m.hooks {
+			if !h.match(change) {
+				continue
+			}
+
+			if m.running[i] {
+				logger.V(1).Printf("Remediation for %s skipped: previous execution is still running", h.Metric)
+				continue
+			}
+
+			if last, ok := m.lastRun[i]; ok && now.Sub(last) < h.Cooldown {
+				logger.Printf(
+					"Remediation for %s skipped: last execution was %v ago, cooldown is %v",
+					h.Metric, now.Sub(last).Truncate(time.Second), h.Cooldown,
+				)
+
+				continue
+			}
+
+			m.running[i] = true
+			m.lastRun[i] = now
+			m.queue <- i
+		}
+	}
+}
+
+// Run execute triggered hooks until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case i := <-m.queue:
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				m.execute(ctx, m.hooks[i])
+
+				m.l.Lock()
+				m.running[i] = false
+				m.l.Unlock()
+			}()
+		}
+	}
+}
+
+func (m *Manager) execute(ctx context.Context, h Hook) {
+	logger.Printf("Remediation for %s: running %q", h.Metric, h.Command)
+
+	start := time.Now()
+	output, err := command.Run(ctx, h.Command, h.Timeout, maxOutputLength)
+	duration := time.Since(start).Truncate(time.Millisecond)
+
+	if err != nil {
+		logger.Printf("Remediation for %s: failed after %v: %v. Output: %s", h.Metric, duration, err, output)
+	} else {
+		logger.Printf("Remediation for %s: succeeded in %v. Output: %s", h.Metric, duration, output)
+	}
+}
+
+// String returns a description of the hook for logs.
+func (h Hook) String() string {
+	if h.Item != "" {
+		return fmt.Sprintf("%s (%s): %q", h.Metric, h.Item, h.Command)
+	}
+
+	return fmt.Sprintf("%s: %q", h.Metric, h.Command)
+}
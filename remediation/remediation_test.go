@@ -0,0 +1,110 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remediation
+
+import (
+	"glouton/threshold"
+	"glouton/types"
+	"testing"
+	"time"
+)
+
+func critical(name string, item string) threshold.StatusChange {
+	return threshold.StatusChange{
+		Labels:         map[string]string{types.LabelName: name},
+		Annotations:    types.MetricAnnotations{BleemeoItem: item},
+		PreviousStatus: types.StatusOk,
+		CurrentStatus:  types.StatusCritical,
+	}
+}
+
+func queued(m *Manager) []int {
+	var result []int
+
+	for {
+		select {
+		case i := <-m.queue:
+			result = append(result, i)
+		default:
+			return result
+		}
+	}
+}
+
+func TestOnStatusChanges(t *testing.T) {
+	apache, err := NewHook("apache_status", "", "systemctl restart apache2", time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err := NewHook("disk_used_perc_status", "/var", "/usr/local/bin/cleanup --older 7d", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if disk.Timeout != defaultTimeout || disk.Cooldown != defaultCooldown {
+		t.Errorf("defaults = %v/%v, want %v/%v", disk.Timeout, disk.Cooldown, defaultTimeout, defaultCooldown)
+	}
+
+	m := New([]Hook{apache, disk})
+
+	m.OnStatusChanges([]threshold.StatusChange{
+		critical("apache_status", ""),
+		critical("disk_used_perc_status", "/home"),
+		{
+			Labels:        map[string]string{types.LabelName: "disk_used_perc_status"},
+			Annotations:   types.MetricAnnotations{BleemeoItem: "/var"},
+			CurrentStatus: types.StatusWarning,
+		},
+	})
+
+	if got := queued(m); len(got) != 1 || got[0] != 0 {
+		t.Errorf("queued = %v, want [0]", got)
+	}
+
+	// The hook is still running.
+	m.OnStatusChanges([]threshold.StatusChange{critical("apache_status", "")})
+
+	if got := queued(m); len(got) != 0 {
+		t.Errorf("queued = %v, want none while running", got)
+	}
+
+	// The hook completed but the cooldown isn't elapsed.
+	m.running[0] = false
+	m.OnStatusChanges([]threshold.StatusChange{critical("apache_status", "")})
+
+	if got := queued(m); len(got) != 0 {
+		t.Errorf("queued = %v, want none during cooldown", got)
+	}
+
+	m.lastRun[0] = time.Now().Add(-2 * time.Hour)
+	m.OnStatusChanges([]threshold.StatusChange{critical("apache_status", ""), critical("disk_used_perc_status", "/var")})
+
+	if got := queued(m); len(got) != 2 {
+		t.Errorf("queued = %v, want [0 1]", got)
+	}
+}
+
+func TestNewHookInvalid(t *testing.T) {
+	if _, err := NewHook("apache_status", "", "", 0, 0); err == nil {
+		t.Error("NewHook() succeeded with an empty command, want an error")
+	}
+
+	if _, err := NewHook("apache_status", "", "echo 'unterminated", 0, 0); err == nil {
+		t.Error("NewHook() succeeded with an invalid command, want an error")
+	}
+}
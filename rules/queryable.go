@@ -0,0 +1,195 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"context"
+	"glouton/types"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+)
+
+type storeInterface interface {
+	Metrics(filters map[string]string) (result []types.Metric, err error)
+}
+
+// queryable expose the glouton store as a Prometheus storage for the PromQL engine.
+type queryable struct {
+	store storeInterface
+}
+
+func (q queryable) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	return querier{store: q.store, mint: mint, maxt: maxt}, nil
+}
+
+type querier struct {
+	store      storeInterface
+	mint, maxt int64
+}
+
+func (q querier) Select(params *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	return q.SelectSorted(params, matchers...)
+}
+
+func (q querier) SelectSorted(params *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	// Equality matchers are used to filter the store, others are checked afterward.
+	filters := make(map[string]string)
+
+	for _, m := range matchers {
+		if m.Type == labels.MatchEqual && m.Value != "" {
+			filters[m.Name] = m.Value
+		}
+	}
+
+	metrics, err := q.store.Metrics(filters)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mint, maxt := q.mint, q.maxt
+	if params != nil {
+		mint, maxt = params.Start, params.End
+	}
+
+	result := &seriesSet{current: -1}
+
+	for _, m := range metrics {
+		lbls := labels.FromMap(m.Labels())
+
+		if !matchAll(lbls, matchers) {
+			continue
+		}
+
+		points, err := m.Points(fromMilliseconds(mint), fromMilliseconds(maxt))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(points) == 0 {
+			continue
+		}
+
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Time.Before(points[j].Time)
+		})
+
+		promPoints := make([]promql.Point, len(points))
+
+		for i, p := range points {
+			promPoints[i] = promql.Point{T: toMilliseconds(p.Time), V: p.Value}
+		}
+
+		result.series = append(result.series, promql.Series{Metric: lbls, Points: promPoints})
+	}
+
+	sort.Slice(result.series, func(i, j int) bool {
+		return labels.Compare(result.series[i].Metric, result.series[j].Metric) < 0
+	})
+
+	return result, nil, nil
+}
+
+func (q querier) LabelValues(name string) ([]string, storage.Warnings, error) {
+	metrics, err := q.store.Metrics(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := make(map[string]bool)
+
+	for _, m := range metrics {
+		if v, ok := m.Labels()[name]; ok {
+			set[v] = true
+		}
+	}
+
+	return sortedKeys(set), nil, nil
+}
+
+func (q querier) LabelNames() ([]string, storage.Warnings, error) {
+	metrics, err := q.store.Metrics(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := make(map[string]bool)
+
+	for _, m := range metrics {
+		for k := range m.Labels() {
+			set[k] = true
+		}
+	}
+
+	return sortedKeys(set), nil, nil
+}
+
+func (q querier) Close() error {
+	return nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+
+	for k := range set {
+		result = append(result, k)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func matchAll(lbls labels.Labels, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(lbls.Get(m.Name)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fromMilliseconds(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+func toMilliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+type seriesSet struct {
+	series  []promql.Series
+	current int
+}
+
+func (s *seriesSet) Next() bool {
+	s.current++
+
+	return s.current < len(s.series)
+}
+
+func (s *seriesSet) At() storage.Series {
+	return promql.NewStorageSeries(s.series[s.current])
+}
+
+func (s *seriesSet) Err() error {
+	return nil
+}
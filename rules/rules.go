@@ -0,0 +1,364 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rules evaluate Prometheus recording and alerting rules on the local store.
+//
+// Recorded series are pushed back like any other metric. Alerts are converted to
+// "<alert name>_status" metrics whose status is warning or critical when the alert
+// is firing, depending on its "severity" label.
+package rules
+
+import (
+	"context"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+	"github.com/prometheus/prometheus/promql"
+)
+
+const (
+	queryTimeout = 10 * time.Second
+	maxSamples   = 50000000
+)
+
+// Manager load rule files and evaluate them periodically.
+type Manager struct {
+	queryable queryable
+	pusher    types.PointPusher
+	engine    *promql.Engine
+	groups    []*group
+}
+
+type group struct {
+	name     string
+	interval time.Duration
+	rules    []*rule
+}
+
+type rule struct {
+	record      string
+	alert       string
+	expr        string
+	forDuration time.Duration
+	labels      map[string]string
+	annotations map[string]string
+
+	l      sync.Mutex
+	active map[uint64]*activeAlert
+}
+
+type activeAlert struct {
+	labels      labels.Labels
+	value       float64
+	activeSince time.Time
+}
+
+// New load the rule files matching the given glob patterns.
+//
+// Groups without interval are evaluated every defaultInterval. Points are read
+// from store and recorded series and alert status are sent to pusher.
+func New(patterns []string, defaultInterval time.Duration, store storeInterface, pusher types.PointPusher) (*Manager, error) {
+	m := &Manager{
+		queryable: queryable{store: store},
+		pusher:    pusher,
+		engine: promql.NewEngine(promql.EngineOpts{
+			Logger:     logger.GoKitLoggerWrapper(logger.V(2)),
+			MaxSamples: maxSamples,
+			Timeout:    queryTimeout,
+		}),
+	}
+
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			ruleGroups, errs := rulefmt.ParseFile(file)
+			if len(errs) > 0 {
+				return nil, fmt.Errorf("rule file %s is invalid: %v", file, errs[0])
+			}
+
+			for _, rg := range ruleGroups.Groups {
+				m.groups = append(m.groups, newGroup(rg, defaultInterval))
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func newGroup(rg rulefmt.RuleGroup, defaultInterval time.Duration) *group {
+	g := &group{
+		name:     rg.Name,
+		interval: time.Duration(rg.Interval),
+	}
+
+	if g.interval == 0 {
+		g.interval = defaultInterval
+	}
+
+	for _, r := range rg.Rules {
+		g.rules = append(g.rules, &rule{
+			record:      r.Record.Value,
+			alert:       r.Alert.Value,
+			expr:        r.Expr.Value,
+			forDuration: time.Duration(r.For),
+			labels:      r.Labels,
+			annotations: r.Annotations,
+			active:      make(map[uint64]*activeAlert),
+		})
+	}
+
+	return g
+}
+
+// RulesCount returns the number of loaded rules.
+func (m *Manager) RulesCount() int {
+	count := 0
+
+	for _, g := range m.groups {
+		count += len(g.rules)
+	}
+
+	return count
+}
+
+// Run evaluate each group at its interval until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	for _, g := range m.groups {
+		g := g
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(g.interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case now := <-ticker.C:
+					m.evalGroup(ctx, g, now)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+func (m *Manager) evalGroup(ctx context.Context, g *group, now time.Time) {
+	for _, r := range g.rules {
+		vector, err := m.query(ctx, r.expr, now)
+		if err != nil {
+			logger.V(1).Printf("Unable to evaluate rule %s of group %s: %v", r.name(), g.name, err)
+			continue
+		}
+
+		var points []types.MetricPoint
+
+		if r.record != "" {
+			points = r.recordPoints(vector, now)
+		} else {
+			points = r.alertPoints(vector, now)
+		}
+
+		if len(points) > 0 {
+			m.pusher.PushPoints(points)
+		}
+	}
+}
+
+func (m *Manager) query(ctx context.Context, expr string, now time.Time) (promql.Vector, error) {
+	query, err := m.engine.NewInstantQuery(m.queryable, expr, now)
+	if err != nil {
+		return nil, err
+	}
+
+	defer query.Close()
+
+	result := query.Exec(ctx)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+
+	switch v := result.Value.(type) {
+	case promql.Vector:
+		return v, nil
+	case promql.Scalar:
+		return promql.Vector{promql.Sample{Point: promql.Point{T: v.T, V: v.V}}}, nil
+	default:
+		return nil, fmt.Errorf("rule result has unsupported type %s", result.Value.Type())
+	}
+}
+
+func (r *rule) name() string {
+	if r.record != "" {
+		return r.record
+	}
+
+	return r.alert
+}
+
+// outputLabels returns the labels of a sample with the rule name and labels.
+func (r *rule) outputLabels(sample labels.Labels, name string) labels.Labels {
+	builder := labels.NewBuilder(sample)
+	builder.Set(types.LabelName, name)
+
+	for k, v := range r.labels {
+		builder.Set(k, v)
+	}
+
+	return builder.Labels()
+}
+
+func (r *rule) recordPoints(vector promql.Vector, now time.Time) []types.MetricPoint {
+	points := make([]types.MetricPoint, 0, len(vector))
+
+	for _, sample := range vector {
+		points = append(points, types.MetricPoint{
+			Labels: r.outputLabels(sample.Metric, r.record).Map(),
+			Point: types.Point{
+				Time:  now,
+				Value: sample.V,
+			},
+		})
+	}
+
+	return points
+}
+
+// alertPoints returns the status points of an alerting rule. Alerts which are
+// no longer active get one last point with an ok status.
+func (r *rule) alertPoints(vector promql.Vector, now time.Time) []types.MetricPoint {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	name := r.alert + "_status"
+	seen := make(map[uint64]bool, len(vector))
+	points := make([]types.MetricPoint, 0, len(vector))
+
+	for _, sample := range vector {
+		lbls := r.outputLabels(sample.Metric, name)
+		hash := lbls.Hash()
+		seen[hash] = true
+
+		alert, ok := r.active[hash]
+		if !ok {
+			alert = &activeAlert{labels: lbls, activeSince: now}
+			r.active[hash] = alert
+		}
+
+		alert.value = sample.V
+
+		status := types.StatusOk
+		description := fmt.Sprintf("Alert %s is pending", r.alert)
+
+		if now.Sub(alert.activeSince) >= r.forDuration {
+			status = severityToStatus(lbls.Get("severity"))
+			description = r.description(alert)
+		}
+
+		points = append(points, statusPoint(lbls, status, description, now))
+	}
+
+	for hash, alert := range r.active {
+		if seen[hash] {
+			continue
+		}
+
+		delete(r.active, hash)
+
+		points = append(points, statusPoint(alert.labels, types.StatusOk, fmt.Sprintf("Alert %s is resolved", r.alert), now))
+	}
+
+	return points
+}
+
+func severityToStatus(severity string) types.Status {
+	switch strings.ToLower(severity) {
+	case "critical", "page", "error":
+		return types.StatusCritical
+	default:
+		return types.StatusWarning
+	}
+}
+
+func statusPoint(lbls labels.Labels, status types.Status, description string, now time.Time) types.MetricPoint {
+	return types.MetricPoint{
+		Labels: lbls.Map(),
+		Annotations: types.MetricAnnotations{
+			Status: types.StatusDescription{
+				CurrentStatus:     status,
+				StatusDescription: description,
+			},
+		},
+		Point: types.Point{
+			Time:  now,
+			Value: float64(status.NagiosCode()),
+		},
+	}
+}
+
+// description expand the summary (or description) annotation of the alert.
+// Like Prometheus, $labels and $value are available in the template.
+func (r *rule) description(alert *activeAlert) string {
+	text := r.annotations["summary"]
+	if text == "" {
+		text = r.annotations["description"]
+	}
+
+	if text == "" {
+		return fmt.Sprintf("Alert %s is firing (value %v)", r.alert, alert.value)
+	}
+
+	tmpl, err := template.New(r.alert).Option("missingkey=zero").Parse("{{$labels := .Labels}}{{$value := .Value}}" + text)
+	if err != nil {
+		return text
+	}
+
+	var b strings.Builder
+
+	err = tmpl.Execute(&b, struct {
+		Labels map[string]string
+		Value  float64
+	}{
+		Labels: alert.labels.Map(),
+		Value:  alert.value,
+	})
+	if err != nil {
+		return text
+	}
+
+	return b.String()
+}
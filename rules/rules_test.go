@@ -0,0 +1,157 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"context"
+	"glouton/store"
+	"glouton/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const ruleFile = `
+groups:
+  - name: test
+    rules:
+      - record: cpu_used_avg
+        expr: avg by (instance) (cpu_used)
+      - alert: HighCPU
+        expr: cpu_used > 90
+        for: 2m
+        labels:
+          severity: critical
+        annotations:
+          summary: "CPU {{ $labels.item }} is at {{ $value }}%"
+`
+
+type capturePusher struct {
+	points []types.MetricPoint
+}
+
+func (p *capturePusher) PushPoints(points []types.MetricPoint) {
+	p.points = append(p.points, points...)
+}
+
+func (p *capturePusher) find(name string) []types.MetricPoint {
+	var result []types.MetricPoint
+
+	for _, pt := range p.points {
+		if pt.Labels[types.LabelName] == name {
+			result = append(result, pt)
+		}
+	}
+
+	return result
+}
+
+func TestManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.yml"), []byte(ruleFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db := store.New()
+	pusher := &capturePusher{}
+
+	m, err := New([]string{filepath.Join(dir, "*.yml")}, time.Minute, db, pusher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.RulesCount() != 2 {
+		t.Fatalf("RulesCount() = %d, want 2", m.RulesCount())
+	}
+
+	t0 := time.Now().Truncate(time.Second)
+	cpu := func(item string, value float64, at time.Time) types.MetricPoint {
+		return types.MetricPoint{
+			Labels: map[string]string{types.LabelName: "cpu_used", "item": item, "instance": "server01"},
+			Point:  types.Point{Time: at, Value: value},
+		}
+	}
+
+	db.PushPoints([]types.MetricPoint{cpu("0", 95, t0), cpu("1", 15, t0)})
+	m.evalGroup(context.Background(), m.groups[0], t0)
+
+	recorded := pusher.find("cpu_used_avg")
+	if len(recorded) != 1 || recorded[0].Value != 55 || recorded[0].Labels["instance"] != "server01" {
+		t.Errorf("recorded = %v, want one point with value 55", recorded)
+	}
+
+	status := pusher.find("HighCPU_status")
+	if len(status) != 1 || status[0].Annotations.Status.CurrentStatus != types.StatusOk {
+		t.Fatalf("status = %v, want one pending (ok) point", status)
+	}
+
+	pusher.points = nil
+	t1 := t0.Add(3 * time.Minute)
+
+	db.PushPoints([]types.MetricPoint{cpu("0", 97, t1), cpu("1", 15, t1)})
+	m.evalGroup(context.Background(), m.groups[0], t1)
+
+	status = pusher.find("HighCPU_status")
+	if len(status) != 1 || status[0].Annotations.Status.CurrentStatus != types.StatusCritical {
+		t.Fatalf("status = %v, want one critical point", status)
+	}
+
+	if got, want := status[0].Annotations.Status.StatusDescription, "CPU 0 is at 97%"; got != want {
+		t.Errorf("description = %#v, want %#v", got, want)
+	}
+
+	if status[0].Labels["item"] != "0" {
+		t.Errorf("item = %#v, want \"0\"", status[0].Labels["item"])
+	}
+
+	pusher.points = nil
+	t2 := t1.Add(time.Minute)
+
+	db.PushPoints([]types.MetricPoint{cpu("0", 20, t2), cpu("1", 15, t2)})
+	m.evalGroup(context.Background(), m.groups[0], t2)
+
+	status = pusher.find("HighCPU_status")
+	if len(status) != 1 || status[0].Annotations.Status.CurrentStatus != types.StatusOk {
+		t.Fatalf("status = %v, want one resolved (ok) point", status)
+	}
+}
+
+func TestNewInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	content := "groups:\n  - name: test\n    rules:\n      - record: x\n        expr: 'sum('\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.yml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New([]string{filepath.Join(dir, "*.yml")}, time.Minute, store.New(), &capturePusher{}); err == nil {
+		t.Error("New() succeeded, want an error")
+	}
+}
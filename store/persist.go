@@ -0,0 +1,190 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// persistVersion is increased when the format of the persisted file changes. A file
+// with another version is ignored.
+const persistVersion = 1
+
+// persistedStore is the content of the file written by Save.
+type persistedStore struct {
+	Version int
+	Metrics []persistedMetric
+}
+
+// persistedMetric is a metric with its points, as written by Save.
+type persistedMetric struct {
+	Labels      map[string]string
+	Annotations types.MetricAnnotations
+	Points      []types.Point
+}
+
+// Save writes the points of the last maxAge to path, so they could be reloaded with Load
+// after a restart. The file is replaced atomically.
+func (s *Store) Save(path string, maxAge time.Duration) error {
+	start := time.Now()
+	content := persistedStore{Version: persistVersion}
+
+	s.lock.Lock()
+
+	for id, m := range s.metrics {
+		var points []types.Point
+
+		for _, p := range s.points[id] {
+			if start.Sub(p.Time) < maxAge {
+				points = append(points, p)
+			}
+		}
+
+		if len(points) == 0 {
+			continue
+		}
+
+		content.Metrics = append(content.Metrics, persistedMetric{
+			Labels:      m.labels,
+			Annotations: m.annotations,
+			Points:      points,
+		})
+	}
+
+	s.lock.Unlock()
+
+	tmpPath := path + ".tmp"
+
+	if err := writePersisted(tmpPath, content); err != nil {
+		os.Remove(tmpPath)
+
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	logger.V(2).Printf("Saved %d metrics of the store to %s in %v", len(content.Metrics), path, time.Since(start))
+
+	return nil
+}
+
+func writePersisted(path string, content persistedStore) error {
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer w.Close()
+
+	buffer := bufio.NewWriter(w)
+
+	zw, err := zstd.NewWriter(buffer, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(zw).Encode(content); err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	if err := buffer.Flush(); err != nil {
+		return err
+	}
+
+	return w.Sync()
+}
+
+// Load reads the points written by Save. Points older than the retention are skipped.
+// The points are not sent to the notifiees, they were already sent before the restart.
+// A missing file isn't an error.
+func (s *Store) Load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	zr, err := zstd.NewReader(bufio.NewReader(f), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+
+	defer zr.Close()
+
+	var content persistedStore
+
+	if err := gob.NewDecoder(zr).Decode(&content); err != nil {
+		return fmt.Errorf("invalid store file %s: %w", path, err)
+	}
+
+	if content.Version != persistVersion {
+		logger.V(1).Printf("Ignoring the store file %s, its version %d isn't supported", path, content.Version)
+
+		return nil
+	}
+
+	now := time.Now()
+	count := 0
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, pm := range content.Metrics {
+		var points []types.Point
+
+		for _, p := range pm.Points {
+			if now.Sub(p.Time) < s.retention {
+				points = append(points, p)
+			}
+		}
+
+		if len(points) == 0 {
+			continue
+		}
+
+		m, ok := s.metricGetOrCreate(pm.Labels, pm.Annotations)
+		if !ok {
+			continue
+		}
+
+		s.points[m.metricID] = append(s.points[m.metricID], points...)
+		count += len(points)
+	}
+
+	logger.V(2).Printf("Loaded %d points of the store from %s", count, path)
+
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"glouton/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.bin")
+	now := time.Now().Truncate(time.Second)
+	cpu := map[string]string{types.LabelName: "cpu_used"}
+	annotations := types.MetricAnnotations{Unit: types.UnitPercent}
+
+	db := New()
+	db.PushPoints([]types.MetricPoint{
+		{Point: types.Point{Time: now.Add(-30 * time.Minute), Value: 10}, Labels: cpu, Annotations: annotations},
+		{Point: types.Point{Time: now.Add(-5 * time.Minute), Value: 20}, Labels: cpu, Annotations: annotations},
+		{Point: types.Point{Time: now, Value: 30}, Labels: cpu, Annotations: annotations},
+	})
+
+	if err := db.Save(path, 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	notified := 0
+	reloaded := New()
+
+	reloaded.AddNotifiee(func(points []types.MetricPoint) {
+		notified += len(points)
+	})
+
+	if err := reloaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, _ := reloaded.Metrics(cpu)
+	if len(metrics) != 1 {
+		t.Fatalf("len(Metrics(cpu_used)) = %d, want 1", len(metrics))
+	}
+
+	if got := metrics[0].Annotations(); got != annotations {
+		t.Errorf("Annotations() = %v, want %v", got, annotations)
+	}
+
+	points, err := metrics[0].Points(now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.Point{
+		{Time: now.Add(-5 * time.Minute), Value: 20},
+		{Time: now, Value: 30},
+	}
+
+	if len(points) != len(want) {
+		t.Fatalf("Points() = %v, want %v", points, want)
+	}
+
+	for i := range want {
+		if !points[i].Time.Equal(want[i].Time) || points[i].Value != want[i].Value {
+			t.Errorf("Points()[%d] = %v, want %v", i, points[i], want[i])
+		}
+	}
+
+	if notified != 0 {
+		t.Errorf("notified points = %d, want 0", notified)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	db := New()
+
+	if err := db.Load(filepath.Join(os.TempDir(), "glouton-store-does-not-exist.bin")); err != nil {
+		t.Errorf("Load() = %v, want nil", err)
+	}
+}
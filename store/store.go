@@ -21,36 +21,87 @@ package store
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 
 	"glouton/logger"
 	"glouton/types"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
 )
 
 // Store implement an interface to retrieve metrics and metric points.
 //
 // See methods GetMetrics and GetMetricPoints.
 type Store struct {
-	metrics         map[int]metric
-	points          map[int][]types.Point
-	notifyCallbacks map[int]func([]types.MetricPoint)
-	lock            sync.Mutex
-	notifeeLock     sync.Mutex
+	metrics            map[int]metric
+	points             map[int][]types.Point
+	notifyCallbacks    map[int]func([]types.MetricPoint)
+	retention          time.Duration
+	maxSeries          int
+	maxSeriesPerMetric int
+	seriesDropped      int
+	droppedSinceLog    int
+	lastDropLog        time.Time
+	lock               sync.Mutex
+	notifeeLock        sync.Mutex
 }
 
+// DefaultRetention is the duration points are kept in the store unless changed by SetRetention.
+const DefaultRetention = time.Hour
+
+// dropLogInterval is the minimum delay between two logs about series dropped by the limits.
+const dropLogInterval = time.Minute
+
 // New create a return a store. Store should be Close()d before leaving.
 func New() *Store {
 	s := &Store{
 		metrics:         make(map[int]metric),
 		points:          make(map[int][]types.Point),
 		notifyCallbacks: make(map[int]func([]types.MetricPoint)),
+		retention:       DefaultRetention,
 	}
 
 	return s
 }
 
+// SetRetention change the duration points are kept in the store.
+// A non-positive duration reset the retention to DefaultRetention.
+func (s *Store) SetRetention(retention time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	s.retention = retention
+}
+
+// SetLimits change the maximum number of series in the store and the maximum number
+// of series of a metric name. Points of new series above the limits are dropped, so
+// an exploding label can't use all the memory. A non-positive value disables the limit.
+func (s *Store) SetLimits(maxSeries int, maxSeriesPerMetric int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxSeries = maxSeries
+	s.maxSeriesPerMetric = maxSeriesPerMetric
+}
+
+// SeriesDropped returns the number of points dropped because they would have created
+// a series above the limits.
+func (s *Store) SeriesDropped() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.seriesDropped
+}
+
 // Run will run the store until context is cancelled.
 func (s *Store) Run(ctx context.Context) error {
 	for {
@@ -138,6 +189,44 @@ func (s *Store) Metrics(filters map[string]string) (result []types.Metric, err e
 	return
 }
 
+// MetricsMatching return the metrics matching all matchers, sorted by labels so the result
+// could be paginated. A metric without a label matches an empty value, like in PromQL.
+func (s *Store) MetricsMatching(matchers []*labels.Matcher) []types.Metric {
+	type keyedMetric struct {
+		key    string
+		metric metric
+	}
+
+	s.lock.Lock()
+
+	matching := make([]keyedMetric, 0)
+
+metricLoop:
+	for _, m := range s.metrics {
+		for _, matcher := range matchers {
+			if !matcher.Matches(m.labels[matcher.Name]) {
+				continue metricLoop
+			}
+		}
+
+		matching = append(matching, keyedMetric{key: types.LabelsToText(m.labels), metric: m})
+	}
+
+	s.lock.Unlock()
+
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].key < matching[j].key
+	})
+
+	result := make([]types.Metric, len(matching))
+
+	for i, m := range matching {
+		result[i] = m.metric
+	}
+
+	return result
+}
+
 // MetricsCount return the count of metrics stored.
 func (s *Store) MetricsCount() int {
 	s.lock.Lock()
@@ -146,6 +235,20 @@ func (s *Store) MetricsCount() int {
 	return len(s.metrics)
 }
 
+// PointsCount return the count of points stored.
+func (s *Store) PointsCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	count := 0
+
+	for _, points := range s.points {
+		count += len(points)
+	}
+
+	return count
+}
+
 // Labels returns all label of the metric.
 func (m metric) Labels() map[string]string {
 	labels := make(map[string]string)
@@ -215,7 +318,7 @@ func (s *Store) run() {
 		newPoints := make([]types.Point, 0)
 
 		for _, p := range points {
-			if time.Since(p.Time) < time.Hour {
+			if time.Since(p.Time) < s.retention {
 				newPoints = append(newPoints, p)
 			}
 		}
@@ -238,21 +341,71 @@ func (s *Store) run() {
 	logger.V(2).Printf("deleted %d points. Total point: %d", deletedPoints, totalPoints)
 }
 
+// acceptNewSeries returns whether a new series with the given labels could be created
+// without exceeding the limits. sameName is the number of series with the same metric name.
+// The store lock is assumed to be held.
+func (s *Store) acceptNewSeries(labels map[string]string, sameName int) bool {
+	if s.maxSeries > 0 && len(s.metrics) >= s.maxSeries {
+		s.seriesDroppedBy(labels, fmt.Sprintf("the store has %d series", len(s.metrics)))
+
+		return false
+	}
+
+	if s.maxSeriesPerMetric > 0 && sameName >= s.maxSeriesPerMetric {
+		s.seriesDroppedBy(labels, fmt.Sprintf("the metric %s has %d series", labels[types.LabelName], sameName))
+
+		return false
+	}
+
+	return true
+}
+
+// seriesDroppedBy counts a dropped point and logs it, at most once per dropLogInterval.
+// The store lock is assumed to be held.
+func (s *Store) seriesDroppedBy(labels map[string]string, reason string) {
+	s.seriesDropped++
+	s.droppedSinceLog++
+
+	if time.Since(s.lastDropLog) < dropLogInterval {
+		return
+	}
+
+	logger.Printf(
+		"Dropped %d points of new series above the limits, e.g. %s because %s",
+		s.droppedSinceLog, types.LabelsToText(labels), reason,
+	)
+
+	s.lastDropLog = time.Now()
+	s.droppedSinceLog = 0
+}
+
 // metricGetOrCreate will return the metric that exactly match given labels.
 //
-// If the metric does not exists, it's created.
+// If the metric does not exists, it's created unless the limits set with SetLimits
+// are reached, in which case false is returned.
 // The store lock is assumed to be held.
 // Annotations is always updated with value provided as argument.
-func (s *Store) metricGetOrCreate(labels map[string]string, annotations types.MetricAnnotations) metric {
+func (s *Store) metricGetOrCreate(labels map[string]string, annotations types.MetricAnnotations) (metric, bool) {
+	name := labels[types.LabelName]
+	sameName := 0
+
 	for id, m := range s.metrics {
 		if labelsMatch(m.labels, labels, true) {
 			m.annotations = annotations
 			s.metrics[id] = m
 
-			return m
+			return m, true
+		}
+
+		if m.labels[types.LabelName] == name {
+			sameName++
 		}
 	}
 
+	if !s.acceptNewSeries(labels, sameName) {
+		return metric{}, false
+	}
+
 	newID := 1
 	_, ok := s.metrics[newID]
 
@@ -274,20 +427,61 @@ func (s *Store) metricGetOrCreate(labels map[string]string, annotations types.Me
 
 	s.metrics[newID] = m
 
-	return m
+	return m, true
 }
 
 // PushPoints append new metric points to the store, creating new metric
 // if needed.
+// A point with a staleness marker as value deletes its metric, it isn't sent to the notifiees.
+// Neither are the points dropped by the limits set with SetLimits.
 // The points must not be mutated after this call.
 func (s *Store) PushPoints(points []types.MetricPoint) {
+	// notified is the points sent to the notifiees. It's only allocated once a point is skipped.
+	var notified []types.MetricPoint
+
 	s.lock.Lock()
-	for _, point := range points {
-		metric := s.metricGetOrCreate(point.Labels, point.Annotations)
-		s.points[metric.metricID] = append(s.points[metric.metricID], point.Point)
+	for i, point := range points {
+		skip := false
+
+		switch {
+		case value.IsStaleNaN(point.Value):
+			skip = true
+
+			for id, m := range s.metrics {
+				if labelsMatch(m.labels, point.Labels, true) {
+					delete(s.metrics, id)
+					delete(s.points, id)
+				}
+			}
+		default:
+			metric, ok := s.metricGetOrCreate(point.Labels, point.Annotations)
+			if !ok {
+				skip = true
+
+				break
+			}
+
+			s.points[metric.metricID] = append(s.points[metric.metricID], point.Point)
+		}
+
+		switch {
+		case skip && notified == nil:
+			notified = make([]types.MetricPoint, 0, len(points))
+			notified = append(notified, points[:i]...)
+		case !skip && notified != nil:
+			notified = append(notified, point)
+		}
 	}
 	s.lock.Unlock()
 
+	if notified != nil {
+		if len(notified) == 0 {
+			return
+		}
+
+		points = notified
+	}
+
 	s.notifeeLock.Lock()
 
 	for _, cb := range s.notifyCallbacks {
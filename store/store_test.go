@@ -18,9 +18,14 @@ package store
 
 import (
 	"glouton/types"
+	"math"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
 )
 
 func TestLabelsMatchNotExact(t *testing.T) {
@@ -172,7 +177,7 @@ func TestMetricsSimple(t *testing.T) {
 		types.LabelName: "measurement_fieldFloat",
 	}
 	db := New()
-	m := db.metricGetOrCreate(labels, types.MetricAnnotations{})
+	m, _ := db.metricGetOrCreate(labels, types.MetricAnnotations{})
 
 	if _, ok := db.metrics[m.metricID]; !ok {
 		t.Errorf("db.metrics[%v] == nil, want it to exists", m.metricID)
@@ -252,12 +257,62 @@ func TestMetricsMultiple(t *testing.T) {
 	}
 }
 
+func TestMetricsMatching(t *testing.T) {
+	db := New()
+	db.metricGetOrCreate(map[string]string{types.LabelName: "disk_used", "mountpoint": "/srv"}, types.MetricAnnotations{})
+	db.metricGetOrCreate(map[string]string{types.LabelName: "cpu_used"}, types.MetricAnnotations{})
+	db.metricGetOrCreate(map[string]string{types.LabelName: "disk_used", "mountpoint": "/home"}, types.MetricAnnotations{})
+	db.metricGetOrCreate(map[string]string{types.LabelName: "disk_free", "mountpoint": "/home"}, types.MetricAnnotations{})
+
+	cases := []struct {
+		name     string
+		matchers []*labels.Matcher
+		want     []string
+	}{
+		{
+			name: "all",
+			want: []string{"", "/home", "/home", "/srv"},
+		},
+		{
+			name:     "equal",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, types.LabelName, "disk_used")},
+			want:     []string{"/home", "/srv"},
+		},
+		{
+			name: "regex",
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchRegexp, types.LabelName, "disk_.*"),
+				labels.MustNewMatcher(labels.MatchNotEqual, "mountpoint", "/srv"),
+			},
+			want: []string{"/home", "/home"},
+		},
+		{
+			name:     "no-match",
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "mountpoint", "/var.*")},
+			want:     []string{},
+		},
+	}
+
+	for _, c := range cases {
+		metrics := db.MetricsMatching(c.matchers)
+		got := make([]string, 0, len(metrics))
+
+		for _, m := range metrics {
+			got = append(got, m.Labels()["mountpoint"])
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: mountpoints = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
 func TestPoints(t *testing.T) {
 	labels := map[string]string{
 		types.LabelName: "cpu_used",
 	}
 	db := New()
-	m := db.metricGetOrCreate(labels, types.MetricAnnotations{})
+	m, _ := db.metricGetOrCreate(labels, types.MetricAnnotations{})
 
 	t0 := time.Now().Add(-60 * time.Second)
 	t1 := t0.Add(10 * time.Second)
@@ -297,6 +352,10 @@ func TestPoints(t *testing.T) {
 		t.Errorf("len(db.points[%v]) == %v, want %v", m.metricID, len(db.points[m.metricID]), 3)
 	}
 
+	if db.PointsCount() != 3 {
+		t.Errorf("db.PointsCount() == %v, want %v", db.PointsCount(), 3)
+	}
+
 	points, err := m.Points(t0, t2)
 	if err != nil {
 		t.Error(err)
@@ -319,3 +378,81 @@ func TestPoints(t *testing.T) {
 		t.Errorf("points[0] == %v, want %v", points[0], p1)
 	}
 }
+
+func TestStalePoint(t *testing.T) {
+	db := New()
+	notified := 0
+
+	db.AddNotifiee(func(points []types.MetricPoint) {
+		notified += len(points)
+	})
+
+	used := map[string]string{types.LabelName: "redis_used_memory", "item": "redis"}
+	keys := map[string]string{types.LabelName: "redis_keys", "item": "redis"}
+
+	db.PushPoints([]types.MetricPoint{
+		{Point: types.Point{Time: time.Now(), Value: 42}, Labels: used},
+		{Point: types.Point{Time: time.Now(), Value: 3}, Labels: keys},
+	})
+	db.PushPoints([]types.MetricPoint{
+		{Point: types.Point{Time: time.Now(), Value: math.Float64frombits(value.StaleNaN)}, Labels: keys},
+	})
+
+	if notified != 2 {
+		t.Errorf("notified points = %d, want 2", notified)
+	}
+
+	if metrics, _ := db.Metrics(keys); len(metrics) != 0 {
+		t.Errorf("len(Metrics(redis_keys)) = %d, want 0", len(metrics))
+	}
+
+	if metrics, _ := db.Metrics(used); len(metrics) != 1 {
+		t.Errorf("len(Metrics(redis_used_memory)) = %d, want 1", len(metrics))
+	}
+}
+
+func TestSeriesLimits(t *testing.T) {
+	db := New()
+	db.SetLimits(4, 2)
+
+	notified := 0
+
+	db.AddNotifiee(func(points []types.MetricPoint) {
+		notified += len(points)
+	})
+
+	var points []types.MetricPoint
+
+	for _, name := range []string{"http_requests", "http_requests", "http_requests", "cpu_used", "mem_used", "disk_used"} {
+		points = append(points, types.MetricPoint{
+			Point:  types.Point{Time: time.Now(), Value: 1},
+			Labels: map[string]string{types.LabelName: name, "item": "item" + strconv.Itoa(len(points))},
+		})
+	}
+
+	db.PushPoints(points)
+
+	// The third http_requests series and disk_used (fifth series) are dropped.
+	if db.MetricsCount() != 4 {
+		t.Errorf("db.MetricsCount() = %d, want 4", db.MetricsCount())
+	}
+
+	if notified != 4 {
+		t.Errorf("notified points = %d, want 4", notified)
+	}
+
+	if db.SeriesDropped() != 2 {
+		t.Errorf("db.SeriesDropped() = %d, want 2", db.SeriesDropped())
+	}
+
+	// Existing series still accept points.
+	db.PushPoints(points[:2])
+
+	if db.PointsCount() != 6 {
+		t.Errorf("db.PointsCount() = %d, want 6", db.PointsCount())
+	}
+
+	if db.SeriesDropped() != 2 {
+		t.Errorf("db.SeriesDropped() = %d, want 2", db.SeriesDropped())
+	}
+}
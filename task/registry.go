@@ -19,15 +19,107 @@ package task
 import (
 	"context"
 	"errors"
+	"fmt"
 	"glouton/logger"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = 5 * time.Minute
 )
 
 // Runner is something that can be Run.
 type Runner func(context.Context) error
 
+// RestartPolicy tells when a task is restarted after it exited.
+type RestartPolicy int
+
+// Possible values for RestartPolicy.
+const (
+	RestartNever RestartPolicy = iota
+	RestartOnFailure
+	RestartAlways
+)
+
+// Policy configures the restarts of a task.
+//
+// The delay before a restart starts at MinBackoff and doubles on each consecutive restart,
+// up to MaxBackoff. A task which ran longer than MaxBackoff is considered stable again.
+// After MaxRetries consecutive restarts (0 means no limit), the task is no longer restarted.
+type Policy struct {
+	Restart    RestartPolicy
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// ParseRestartPolicy convert "never", "on-failure" or "always" to a RestartPolicy.
+func ParseRestartPolicy(value string) (RestartPolicy, error) {
+	switch value {
+	case "", "never":
+		return RestartNever, nil
+	case "on-failure":
+		return RestartOnFailure, nil
+	case "always":
+		return RestartAlways, nil
+	default:
+		return RestartNever, fmt.Errorf("unknown restart policy %#v, supported policies are \"never\", \"on-failure\" and \"always\"", value)
+	}
+}
+
+func (p Policy) shouldRestart(err error, consecutiveRestarts int) bool {
+	switch {
+	case p.Restart == RestartNever:
+		return false
+	case p.Restart == RestartOnFailure && err == nil:
+		return false
+	case p.MaxRetries > 0 && consecutiveRestarts >= p.MaxRetries:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p Policy) backoff(consecutiveRestarts int) time.Duration {
+	minBackoff := p.MinBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+
+	maxBackoff := p.maxBackoff()
+
+	delay := minBackoff
+	for i := 1; i < consecutiveRestarts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay
+}
+
+func (p Policy) maxBackoff() time.Duration {
+	if p.MaxBackoff <= 0 {
+		return defaultMaxBackoff
+	}
+
+	return p.MaxBackoff
+}
+
 // Registry contains running tasks. It allow to add/remove tasks.
 type Registry struct {
+	// runningCount and failedCount are updated atomically, as RemoveTask and Close hold
+	// the lock while waiting for the task to stop. Counts doesn't take the lock.
+	// They are first to be 64-bit aligned on 32-bit platforms.
+	runningCount int64
+	failedCount  int64
+
 	ctx    context.Context
 	cancel func()
 	tasks  map[int]*taskInfo
@@ -38,11 +130,14 @@ type Registry struct {
 type taskInfo struct {
 	Runner     Runner
 	Name       string
+	Policy     Policy
 	CancelFunc func()
 
-	l         sync.Mutex
-	Running   bool
-	ExitError error
+	l          sync.Mutex
+	Running    bool
+	Restarting bool
+	Restarts   int
+	ExitError  error
 }
 
 // NewRegistry create a new registry. All task running in this registry will terminate when ctx is cancelled.
@@ -79,7 +174,16 @@ func (r *Registry) close() {
 }
 
 // AddTask add and start a new task. It return an taskID that could be used in RemoveTask.
+// The task is never restarted.
 func (r *Registry) AddTask(task Runner, shortName string) (int, error) {
+	return r.AddTaskWithPolicy(task, shortName, Policy{})
+}
+
+// AddTaskWithPolicy add and start a new task which is restarted according to policy.
+// It return an taskID that could be used in RemoveTask.
+//
+// A task which is waiting for a restart is still considered as running.
+func (r *Registry) AddTaskWithPolicy(task Runner, shortName string, policy Policy) (int, error) {
 	r.l.Lock()
 	defer r.l.Unlock()
 
@@ -109,27 +213,92 @@ func (r *Registry) AddTask(task Runner, shortName string) (int, error) {
 		CancelFunc: cancelWait,
 		Runner:     task,
 		Name:       shortName,
+		Policy:     policy,
 		Running:    true,
 	}
 
+	atomic.AddInt64(&r.runningCount, 1)
+
 	go func() {
 		defer close(waitC)
 
-		err := task(ctx)
+		r.run(ctx, ti)
+	}()
+
+	r.tasks[id] = ti
+
+	return id, nil
+}
+
+// run runs the task until it exits and isn't restarted.
+func (r *Registry) run(ctx context.Context, ti *taskInfo) {
+	defer atomic.AddInt64(&r.runningCount, -1)
+
+	consecutiveRestarts := 0
+
+	for {
+		startedAt := time.Now()
+
+		err := ti.runOnce(ctx)
 		if err != nil {
-			logger.Printf("Task %#v failed: %v", shortName, err)
+			logger.Printf("Task %#v failed: %v", ti.Name, err)
+
+			atomic.AddInt64(&r.failedCount, 1)
 		}
 
-		ti.l.Lock()
-		defer ti.l.Unlock()
+		if time.Since(startedAt) > ti.Policy.maxBackoff() {
+			consecutiveRestarts = 0
+		}
+
+		if ctx.Err() != nil || !ti.Policy.shouldRestart(err, consecutiveRestarts) {
+			ti.l.Lock()
+			ti.Running = false
+			ti.Restarting = false
+			ti.ExitError = err
+			ti.l.Unlock()
+
+			return
+		}
+
+		consecutiveRestarts++
+		delay := ti.Policy.backoff(consecutiveRestarts)
+
+		logger.V(1).Printf("Task %#v will be restarted in %v", ti.Name, delay)
 
-		ti.Running = false
+		ti.l.Lock()
+		ti.Restarting = true
 		ti.ExitError = err
-	}()
+		ti.l.Unlock()
 
-	r.tasks[id] = ti
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			ti.l.Lock()
+			ti.Running = false
+			ti.Restarting = false
+			ti.l.Unlock()
 
-	return id, nil
+			return
+		}
+
+		ti.l.Lock()
+		ti.Restarting = false
+		ti.Restarts++
+		ti.l.Unlock()
+	}
+}
+
+// runOnce runs the task. When the task could be restarted, a panic is converted to an error.
+func (ti *taskInfo) runOnce(ctx context.Context) (err error) {
+	if ti.Policy.Restart != RestartNever {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+	}
+
+	return ti.Runner(ctx)
 }
 
 // RemoveTask stop (and potentially close) and remove given task.
@@ -161,6 +330,56 @@ func (r *Registry) IsRunning(taskID int) (bool, error) {
 	return task.Running, task.ExitError
 }
 
+// Counts return the number of running tasks and the number of tasks which
+// failed since the registry was created.
+//
+// It doesn't wait for the tasks being started or stopped.
+func (r *Registry) Counts() (running int, failed int) {
+	return int(atomic.LoadInt64(&r.runningCount)), int(atomic.LoadInt64(&r.failedCount))
+}
+
+// Status is the state of a task.
+//
+// Restarting is true while the task waits for its restart. ExitError is the
+// error of the last exit, even if the task was restarted since.
+type Status struct {
+	ID         int
+	Name       string
+	Running    bool
+	Restarting bool
+	Restarts   int
+	ExitError  error
+}
+
+// Statuses return the state of all tasks, sorted by ID.
+func (r *Registry) Statuses() []Status {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	result := make([]Status, 0, len(r.tasks))
+
+	for id, task := range r.tasks {
+		task.l.Lock()
+
+		result = append(result, Status{
+			ID:         id,
+			Name:       task.Name,
+			Running:    task.Running,
+			Restarting: task.Restarting,
+			Restarts:   task.Restarts,
+			ExitError:  task.ExitError,
+		})
+
+		task.l.Unlock()
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
+
 func (r *Registry) removeTask(taskID int, forClosing bool) {
 	if task, ok := r.tasks[taskID]; ok {
 		task.CancelFunc()
@@ -0,0 +1,224 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitStopped(t *testing.T, r *Registry, id int) error {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		running, err := r.IsRunning(id)
+		if !running {
+			return err
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("task %d is still running", id)
+
+	return nil
+}
+
+func TestRestartPolicy(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	cases := []struct {
+		name      string
+		policy    Policy
+		runner    func(calls int64) error
+		wantCalls int64
+		wantErr   error
+	}{
+		{
+			name:      "never",
+			policy:    Policy{},
+			runner:    func(int64) error { return errFailed },
+			wantCalls: 1,
+			wantErr:   errFailed,
+		},
+		{
+			name:      "on-failure-max-retries",
+			policy:    Policy{Restart: RestartOnFailure, MaxRetries: 3, MinBackoff: time.Millisecond},
+			runner:    func(int64) error { return errFailed },
+			wantCalls: 4,
+			wantErr:   errFailed,
+		},
+		{
+			name:   "on-failure-recover",
+			policy: Policy{Restart: RestartOnFailure, MaxRetries: 3, MinBackoff: time.Millisecond},
+			runner: func(calls int64) error {
+				if calls < 2 {
+					return errFailed
+				}
+
+				return nil
+			},
+			wantCalls: 2,
+			wantErr:   nil,
+		},
+		{
+			name:   "on-failure-panic",
+			policy: Policy{Restart: RestartOnFailure, MaxRetries: 1, MinBackoff: time.Millisecond},
+			runner: func(calls int64) error {
+				if calls == 1 {
+					panic("crash")
+				}
+
+				return nil
+			},
+			wantCalls: 2,
+			wantErr:   nil,
+		},
+		{
+			name:      "always",
+			policy:    Policy{Restart: RestartAlways, MaxRetries: 2, MinBackoff: time.Millisecond},
+			runner:    func(int64) error { return nil },
+			wantCalls: 3,
+			wantErr:   nil,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			r := NewRegistry(context.Background())
+			defer r.Close()
+
+			var calls int64
+
+			id, err := r.AddTaskWithPolicy(func(ctx context.Context) error {
+				return c.runner(atomic.AddInt64(&calls, 1))
+			}, c.name, c.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := waitStopped(t, r, id); err != c.wantErr {
+				t.Errorf("err = %v, want %v", err, c.wantErr)
+			}
+
+			if got := atomic.LoadInt64(&calls); got != c.wantCalls {
+				t.Errorf("calls = %d, want %d", got, c.wantCalls)
+			}
+
+			statuses := r.Statuses()
+			if len(statuses) != 1 || statuses[0].Restarts != int(c.wantCalls-1) {
+				t.Errorf("Statuses() = %v, want %d restarts", statuses, c.wantCalls-1)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestRestartCancelled(t *testing.T) {
+	r := NewRegistry(context.Background())
+
+	id, err := r.AddTaskWithPolicy(func(ctx context.Context) error {
+		return errors.New("failed")
+	}, "test", Policy{Restart: RestartAlways, MinBackoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The task is waiting for its restart
+	time.Sleep(10 * time.Millisecond)
+
+	if running, _ := r.IsRunning(id); !running {
+		t.Errorf("IsRunning() = false, want true while waiting for restart")
+	}
+
+	r.RemoveTask(id)
+	r.Close()
+}
+
+// TestCountsWhileStopping checks that Counts doesn't wait for a task being stopped.
+func TestCountsWhileStopping(t *testing.T) {
+	r := NewRegistry(context.Background())
+	release := make(chan struct{})
+
+	id, err := r.AddTask(func(ctx context.Context) error {
+		<-ctx.Done()
+		<-release
+
+		return nil
+	}, "slow-stop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if running, failed := r.Counts(); running != 1 || failed != 0 {
+		t.Errorf("Counts() = %d, %d, want 1, 0", running, failed)
+	}
+
+	removed := make(chan struct{})
+
+	go func() {
+		defer close(removed)
+
+		r.RemoveTask(id)
+	}()
+
+	// Let RemoveTask take the lock and wait for the task.
+	time.Sleep(10 * time.Millisecond)
+
+	counted := make(chan int)
+
+	go func() {
+		running, _ := r.Counts()
+		counted <- running
+	}()
+
+	select {
+	case running := <-counted:
+		if running != 1 {
+			t.Errorf("Counts() running = %d, want 1 while the task is stopping", running)
+		}
+	case <-time.After(time.Second):
+		t.Error("Counts() is blocked by RemoveTask")
+	}
+
+	close(release)
+	<-removed
+
+	if running, _ := r.Counts(); running != 0 {
+		t.Errorf("Counts() running = %d, want 0", running)
+	}
+
+	r.Close()
+}
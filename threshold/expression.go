@@ -0,0 +1,424 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package threshold
+
+import (
+	"errors"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/value"
+)
+
+// Values older than maxValueAge are not used to evaluate expressions.
+const maxValueAge = 5 * time.Minute
+
+// Rules are evaluated at most once per minRuleInterval.
+const minRuleInterval = 10 * time.Second
+
+var (
+	errInvalidExpression = errors.New("invalid expression")
+	errMissingValue      = errors.New("no recent value")
+)
+
+// Rule is a threshold on an expression combining multiple metrics, for example
+// "mem_available_perc < 5 AND swap_used_perc > 80".
+//
+// When the expression is true for SoftPeriod, the metric Name get the status Status.
+type Rule struct {
+	Name       string
+	Expression string
+	Status     types.Status
+	SoftPeriod time.Duration
+
+	expr     expression
+	lastEval time.Time
+}
+
+// NewRule parse the expression and return a Rule.
+//
+// Expression are comparisons between a metric and a number (<, <=, >, >=, ==, !=)
+// combined with AND, OR and parenthesis. AND has precedence over OR.
+func NewRule(name string, expr string, status types.Status, softPeriod time.Duration) (Rule, error) {
+	p := &parser{}
+
+	if err := p.tokenize(expr); err != nil {
+		return Rule{}, err
+	}
+
+	parsed, err := p.parseOr()
+	if err != nil {
+		return Rule{}, err
+	}
+
+	if p.pos != len(p.tokens) {
+		return Rule{}, fmt.Errorf("%w: unexpected %#v", errInvalidExpression, p.tokens[p.pos])
+	}
+
+	return Rule{
+		Name:       name,
+		Expression: expr,
+		Status:     status,
+		SoftPeriod: softPeriod,
+		expr:       parsed,
+	}, nil
+}
+
+// Metrics returns the name of metrics used by the rule.
+func (r Rule) Metrics() []string {
+	set := make(map[string]bool)
+	r.expr.metrics(set)
+
+	result := make([]string, 0, len(set))
+
+	for name := range set {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+type expression interface {
+	eval(values map[string]float64) (bool, error)
+	metrics(set map[string]bool)
+}
+
+type comparison struct {
+	metric string
+	op     string
+	value  float64
+}
+
+func (c comparison) eval(values map[string]float64) (bool, error) {
+	v, ok := values[c.metric]
+	if !ok {
+		return false, fmt.Errorf("%w for %s", errMissingValue, c.metric)
+	}
+
+	switch c.op {
+	case "<":
+		return v < c.value, nil
+	case "<=":
+		return v <= c.value, nil
+	case ">":
+		return v > c.value, nil
+	case ">=":
+		return v >= c.value, nil
+	case "==":
+		return v == c.value, nil
+	default:
+		return v != c.value, nil
+	}
+}
+
+func (c comparison) metrics(set map[string]bool) {
+	set[c.metric] = true
+}
+
+type logical struct {
+	and         bool
+	left, right expression
+}
+
+func (l logical) eval(values map[string]float64) (bool, error) {
+	left, err := l.left.eval(values)
+	if err != nil {
+		return false, err
+	}
+
+	right, err := l.right.eval(values)
+	if err != nil {
+		return false, err
+	}
+
+	if l.and {
+		return left && right, nil
+	}
+
+	return left || right, nil
+}
+
+func (l logical) metrics(set map[string]bool) {
+	l.left.metrics(set)
+	l.right.metrics(set)
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) tokenize(expr string) error {
+	for i := 0; i < len(expr); {
+		c := expr[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			p.tokens = append(p.tokens, string(c))
+			i++
+		case strings.ContainsRune("<>=!", rune(c)):
+			j := i + 1
+			if j < len(expr) && expr[j] == '=' {
+				j++
+			}
+
+			op := expr[i:j]
+			if op == "=" || op == "!" {
+				return fmt.Errorf("%w: unknown operator %#v", errInvalidExpression, op)
+			}
+
+			p.tokens = append(p.tokens, op)
+			i = j
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n()<>=!", rune(expr[j])) {
+				j++
+			}
+
+			p.tokens = append(p.tokens, expr[i:j])
+			i = j
+		}
+	}
+
+	if len(p.tokens) == 0 {
+		return fmt.Errorf("%w: empty expression", errInvalidExpression)
+	}
+
+	return nil
+}
+
+func (p *parser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+
+	return token
+}
+
+func (p *parser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && strings.EqualFold(p.tokens[p.pos], keyword)
+}
+
+func (p *parser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekKeyword("OR") {
+		p.pos++
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = logical{and: false, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expression, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekKeyword("AND") {
+		p.pos++
+
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		left = logical{and: true, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseTerm() (expression, error) {
+	token := p.next()
+
+	if token == "(" {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.next() != ")" {
+			return nil, fmt.Errorf("%w: missing closing parenthesis", errInvalidExpression)
+		}
+
+		return expr, nil
+	}
+
+	if token == "" || token == ")" || strings.EqualFold(token, "AND") || strings.EqualFold(token, "OR") {
+		return nil, fmt.Errorf("%w: expected a metric name, got %#v", errInvalidExpression, token)
+	}
+
+	op := p.next()
+
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("%w: expected a comparison operator after %s, got %#v", errInvalidExpression, token, op)
+	}
+
+	valueText := p.next()
+
+	value, err := strconv.ParseFloat(valueText, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: expected a number after %s %s, got %#v", errInvalidExpression, token, op, valueText)
+	}
+
+	return comparison{metric: token, op: op, value: value}, nil
+}
+
+// SetRules configure the threshold rules.
+func (r *Registry) SetRules(rules []Rule) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.rules = rules
+	r.ruleMetrics = make(map[string]bool)
+
+	for _, rule := range rules {
+		rule.expr.metrics(r.ruleMetrics)
+	}
+
+	logger.V(2).Printf("Threshold rules contains %d definitions", len(rules))
+}
+
+type valueAt struct {
+	value float64
+	time  time.Time
+}
+
+// evaluateRules record values used by rules and emit the status of rules using them.
+// The registry lock must be held.
+func (p *pusher) evaluateRules(points []types.MetricPoint, result []types.MetricPoint) []types.MetricPoint {
+	if len(p.registry.rules) == 0 {
+		return result
+	}
+
+	updated := false
+	lastTime := time.Time{}
+
+	for _, point := range points {
+		name := point.Labels[types.LabelName]
+
+		if !p.registry.ruleMetrics[name] || point.Annotations.BleemeoItem != "" || value.IsStaleNaN(point.Value) {
+			continue
+		}
+
+		p.registry.ruleValues[name] = valueAt{value: point.Value, time: point.Time}
+		updated = true
+
+		if point.Time.After(lastTime) {
+			lastTime = point.Time
+		}
+	}
+
+	if !updated {
+		return result
+	}
+
+	now := time.Now()
+	values := make(map[string]float64, len(p.registry.ruleValues))
+
+	for name, v := range p.registry.ruleValues {
+		if now.Sub(v.time) < maxValueAge {
+			values[name] = v.value
+		}
+	}
+
+	for i := range p.registry.rules {
+		rule := &p.registry.rules[i]
+
+		if now.Sub(rule.lastEval) < minRuleInterval {
+			continue
+		}
+
+		matched, err := rule.expr.eval(values)
+		if err != nil {
+			continue
+		}
+
+		rule.lastEval = now
+		result = append(result, p.ruleStatusPoint(*rule, matched, values, lastTime, now))
+	}
+
+	return result
+}
+
+func (p *pusher) ruleStatusPoint(rule Rule, matched bool, values map[string]float64, pointTime time.Time, now time.Time) types.MetricPoint {
+	softStatus := types.StatusOk
+	if matched {
+		softStatus = rule.Status
+	}
+
+	key := MetricNameItem{Name: rule.Name}
+	newState := p.registry.states[key].Update(softStatus, rule.SoftPeriod, now)
+	p.registry.states[key] = newState
+
+	metricValues := make([]string, 0, len(values))
+
+	for _, name := range rule.Metrics() {
+		metricValues = append(metricValues, fmt.Sprintf("%s=%.2f", name, values[name]))
+	}
+
+	description := fmt.Sprintf("%s is %v (%s)", rule.Expression, matched, strings.Join(metricValues, ", "))
+
+	if newState.CurrentStatus != types.StatusOk && rule.SoftPeriod > 0 {
+		description = fmt.Sprintf("%s is true over last %s (%s)", rule.Expression, formatDuration(rule.SoftPeriod), strings.Join(metricValues, ", "))
+	}
+
+	if pendingStatus, pendingDuration := newState.Pending(rule.SoftPeriod, now); pendingStatus.IsSet() {
+		description += fmt.Sprintf(" (%s pending, will fire in %s)", pendingStatus.String(), formatDuration(pendingDuration))
+	}
+
+	return types.MetricPoint{
+		Labels: map[string]string{
+			types.LabelName: rule.Name,
+		},
+		Annotations: types.MetricAnnotations{
+			Status: types.StatusDescription{
+				CurrentStatus:     newState.CurrentStatus,
+				StatusDescription: description,
+			},
+		},
+		Point: types.Point{
+			Time:  pointTime,
+			Value: float64(newState.CurrentStatus.NagiosCode()),
+		},
+	}
+}
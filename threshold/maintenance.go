@@ -0,0 +1,271 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package threshold
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"glouton/logger"
+	"glouton/types"
+	"path"
+	"time"
+)
+
+const maintenanceStateKey = "MaintenanceWindows"
+
+var errInvalidWindow = errors.New("invalid maintenance window")
+
+// MaintenanceWindow is a period during which the status of matching metrics is forced to ok.
+//
+// A window without Metric and Service match all metrics of the host. Metric is a
+// glob pattern on the metric name (e.g. "disk_*") and Service is a service name.
+type MaintenanceWindow struct {
+	ID      string    `json:"id"`
+	Metric  string    `json:"metric,omitempty"`
+	Service string    `json:"service,omitempty"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Reason  string    `json:"reason,omitempty"`
+	// FromConfig is true for windows defined in the configuration. They aren't persisted.
+	FromConfig bool `json:"from_config"`
+}
+
+// Validate checks the window is usable.
+func (w MaintenanceWindow) Validate() error {
+	if !w.End.After(w.Start) {
+		return fmt.Errorf("%w: end must be after start", errInvalidWindow)
+	}
+
+	if _, err := path.Match(w.Metric, ""); err != nil {
+		return fmt.Errorf("%w: metric pattern %#v: %v", errInvalidWindow, w.Metric, err)
+	}
+
+	return nil
+}
+
+// Active returns whether the window is in progress.
+func (w MaintenanceWindow) Active(now time.Time) bool {
+	return !now.Before(w.Start) && now.Before(w.End)
+}
+
+func (w MaintenanceWindow) match(point types.MetricPoint) bool {
+	if w.Service != "" && point.Annotations.ServiceName != w.Service {
+		return false
+	}
+
+	if w.Metric != "" {
+		name := point.Labels[types.LabelName]
+		if point.Annotations.StatusOf != "" {
+			name = point.Annotations.StatusOf
+		}
+
+		if ok, _ := path.Match(w.Metric, name); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetMaintenanceWindows configure the windows defined in the configuration.
+func (r *Registry) SetMaintenanceWindows(windows []MaintenanceWindow) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	result := make([]MaintenanceWindow, 0, len(windows)+len(r.maintenances))
+
+	for _, w := range r.maintenances {
+		if !w.FromConfig {
+			result = append(result, w)
+		}
+	}
+
+	for i, w := range windows {
+		w.FromConfig = true
+		if w.ID == "" {
+			w.ID = fmt.Sprintf("config-%d", i)
+		}
+
+		result = append(result, w)
+	}
+
+	r.maintenances = result
+}
+
+// AddMaintenanceWindow add a window and persist it. The window is returned with its ID.
+func (r *Registry) AddMaintenanceWindow(w MaintenanceWindow) (MaintenanceWindow, error) {
+	if err := w.Validate(); err != nil {
+		return w, err
+	}
+
+	buffer := make([]byte, 8)
+	if _, err := rand.Read(buffer); err != nil {
+		return w, err
+	}
+
+	w.ID = hex.EncodeToString(buffer)
+	w.FromConfig = false
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.maintenances = append(r.maintenances, w)
+
+	logger.V(1).Printf("Maintenance window %s added until %v", w.ID, w.End.Format(time.RFC3339))
+
+	r.saveMaintenances()
+
+	return w, nil
+}
+
+// RemoveMaintenanceWindow remove a window added with AddMaintenanceWindow.
+// It returns false if the window doesn't exist or is from the configuration.
+func (r *Registry) RemoveMaintenanceWindow(id string) bool {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	for i, w := range r.maintenances {
+		if w.ID != id || w.FromConfig {
+			continue
+		}
+
+		r.maintenances = append(r.maintenances[:i], r.maintenances[i+1:]...)
+
+		r.saveMaintenances()
+
+		return true
+	}
+
+	return false
+}
+
+// MaintenanceWindows returns the windows which are not yet finished.
+func (r *Registry) MaintenanceWindows() []MaintenanceWindow {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	now := time.Now()
+	result := make([]MaintenanceWindow, 0, len(r.maintenances))
+
+	for _, w := range r.maintenances {
+		if now.Before(w.End) {
+			result = append(result, w)
+		}
+	}
+
+	return result
+}
+
+// saveMaintenances persist windows added by API which aren't finished.
+// The registry lock must be held.
+func (r *Registry) saveMaintenances() {
+	now := time.Now()
+	persisted := make([]MaintenanceWindow, 0, len(r.maintenances))
+	kept := r.maintenances[:0]
+
+	for _, w := range r.maintenances {
+		if !now.Before(w.End) {
+			continue
+		}
+
+		kept = append(kept, w)
+
+		if !w.FromConfig {
+			persisted = append(persisted, w)
+		}
+	}
+
+	r.maintenances = kept
+
+	if err := r.state.Set(maintenanceStateKey, persisted); err != nil {
+		logger.V(1).Printf("Unable to save maintenance windows: %v", err)
+	}
+}
+
+// applyMaintenance returns the points with the status of points matching an active window forced
+// to ok. points is not modified, the points are copied only if needed. The registry lock must be held.
+func (r *Registry) applyMaintenance(points []types.MetricPoint, now time.Time) []types.MetricPoint {
+	var active []MaintenanceWindow
+
+	for _, w := range r.maintenances {
+		if w.Active(now) {
+			active = append(active, w)
+		}
+	}
+
+	if len(active) == 0 {
+		return points
+	}
+
+	// Metrics with a threshold are sent with their "_status" metric. Only the
+	// value of the later is a status.
+	statusOf := make(map[string]bool)
+
+	for _, point := range points {
+		if point.Annotations.StatusOf != "" {
+			statusOf[point.Annotations.StatusOf] = true
+		}
+	}
+
+	var result []types.MetricPoint
+
+	for i, point := range points {
+		status := point.Annotations.Status
+		if !status.CurrentStatus.IsSet() || status.CurrentStatus == types.StatusOk {
+			continue
+		}
+
+		for _, w := range active {
+			if !w.match(point) {
+				continue
+			}
+
+			description := fmt.Sprintf("In maintenance until %s", w.End.Format(time.RFC3339))
+			if w.Reason != "" {
+				description += ": " + w.Reason
+			}
+
+			if status.StatusDescription != "" {
+				description += fmt.Sprintf(" (%s: %s)", status.CurrentStatus, status.StatusDescription)
+			}
+
+			if result == nil {
+				result = make([]types.MetricPoint, len(points))
+				copy(result, points)
+			}
+
+			result[i].Annotations.Status = types.StatusDescription{
+				CurrentStatus:     types.StatusOk,
+				StatusDescription: description,
+			}
+
+			if !statusOf[point.Labels[types.LabelName]] {
+				result[i].Value = float64(types.StatusOk.NagiosCode())
+			}
+
+			break
+		}
+	}
+
+	if result == nil {
+		return points
+	}
+
+	return result
+}
@@ -19,15 +19,22 @@ package threshold
 import (
 	"context"
 	"fmt"
+	"glouton/clock"
 	"glouton/logger"
 	"glouton/types"
 	"math"
 	"sync"
 	"time"
+
+	"github.com/prometheus/prometheus/pkg/value"
 )
 
 const statusCacheKey = "CacheStatusState"
 
+// stateExpiration is the delay after which the state of a metric which is no longer
+// updated is forgotten.
+const stateExpiration = 60 * time.Minute
+
 // State store information about current firing threshold.
 type State interface {
 	Get(key string, result interface{}) error
@@ -42,10 +49,38 @@ type Registry struct {
 	l                 sync.Mutex
 	states            map[MetricNameItem]statusState
 	units             map[MetricNameItem]Unit
+	unitsAllItem      map[string]Unit
 	thresholdsAllItem map[string]Threshold
 	thresholds        map[MetricNameItem]Threshold
 	defaultSoftPeriod time.Duration
 	softPeriods       map[string]time.Duration
+	pendingStatus     bool
+	noStatusMetric    bool
+	noStatusMetricFor map[string]bool
+	rules             []Rule
+	ruleMetrics       map[string]bool
+	ruleValues        map[string]valueAt
+	maintenances      []MaintenanceWindow
+	// lastStatus is the last status of each metric, used to find the status changes.
+	lastStatus map[string]lastStatus
+
+	notifeeLock     sync.Mutex
+	notifyCallbacks map[int]func([]StatusChange)
+}
+
+type lastStatus struct {
+	status     types.Status
+	lastUpdate time.Time
+}
+
+// StatusChange is a transition of the status of a metric.
+type StatusChange struct {
+	Labels            map[string]string
+	Annotations       types.MetricAnnotations
+	PreviousStatus    types.Status
+	CurrentStatus     types.Status
+	StatusDescription string
+	Time              time.Time
 }
 
 // New returns a new ThresholdState.
@@ -53,16 +88,30 @@ func New(state State) *Registry {
 	self := &Registry{
 		state:             state,
 		states:            make(map[MetricNameItem]statusState),
+		ruleValues:        make(map[string]valueAt),
+		lastStatus:        make(map[string]lastStatus),
+		notifyCallbacks:   make(map[int]func([]StatusChange)),
 		defaultSoftPeriod: 300 * time.Second,
 	}
 
 	var jsonList []jsonState
 
 	err := state.Get(statusCacheKey, &jsonList)
-	if err != nil {
+	if err == nil {
 		for _, v := range jsonList {
+			// Times restored from the state have no monotonic clock reading, a jump of the wall
+			// clock (e.g. NTP step after boot) would make the soft period elapse immediately.
+			v.CriticalSince = clock.Monotonic(v.CriticalSince)
+			v.WarningSince = clock.Monotonic(v.WarningSince)
+			v.LastUpdate = clock.Monotonic(v.LastUpdate)
 			self.states[v.MetricNameItem] = v.statusState
 		}
+	} else {
+		logger.V(1).Printf("Unable to load threshold states from state: %v", err)
+	}
+
+	if err := state.Get(maintenanceStateKey, &self.maintenances); err != nil {
+		logger.V(1).Printf("Unable to load maintenance windows from state: %v", err)
 	}
 
 	return self
@@ -93,6 +142,31 @@ func (r *Registry) SetSoftPeriod(defaultPeriod time.Duration, periodPerMetrics m
 	logger.V(2).Printf("SoftPeriod contains %d definitions", len(periodPerMetrics))
 }
 
+// SetPendingStatusMetric configure whether a "<metric>_pending_status" metric is emitted.
+// Its value is the Nagios code of the status that will be reached once the soft period is elapsed,
+// or 0 when no status change is pending.
+func (r *Registry) SetPendingStatusMetric(enabled bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.pendingStatus = enabled
+}
+
+// SetStatusMetric configure whether a "<metric>_status" metric is emitted for metrics with
+// thresholds. When disabled, globally or for the metrics in disabledFor, the status is only
+// kept in the annotations of the metric.
+func (r *Registry) SetStatusMetric(enabled bool, disabledFor []string) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.noStatusMetric = !enabled
+	r.noStatusMetricFor = make(map[string]bool, len(disabledFor))
+
+	for _, name := range disabledFor {
+		r.noStatusMetricFor[name] = true
+	}
+}
+
 // SetUnits configure the units.
 func (r *Registry) SetUnits(units map[MetricNameItem]Unit) {
 	r.l.Lock()
@@ -103,6 +177,31 @@ func (r *Registry) SetUnits(units map[MetricNameItem]Unit) {
 	logger.V(2).Printf("Units contains %d definitions", len(units))
 }
 
+// SetUnitsAllItem configure the units which apply to any item of a metric.
+// They are used when no unit is defined by SetUnits for the metric and item.
+func (r *Registry) SetUnitsAllItem(units map[string]Unit) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.unitsAllItem = units
+
+	logger.V(2).Printf("Units contains %d definitions for any item", len(units))
+}
+
+// getUnit returns the unit configured for the metric. When none is configured, the unit
+// annotated on the point is used.
+func (r *Registry) getUnit(key MetricNameItem, annotatedUnit string) Unit {
+	if unit, ok := r.units[key]; ok {
+		return unit
+	}
+
+	if unit, ok := r.unitsAllItem[key.Name]; ok {
+		return unit
+	}
+
+	return UnitFromAnnotation(annotatedUnit)
+}
+
 // MetricNameItem is the couple Name and Item.
 type MetricNameItem struct {
 	Name string
@@ -183,6 +282,40 @@ func (s statusState) Update(newStatus types.Status, period time.Duration, now ti
 	return s
 }
 
+// Pending return the status that will be reached if the current status persists, and when.
+// It must be called after Update with the same period. If no change is pending, it returns StatusUnset.
+func (s statusState) Pending(period time.Duration, now time.Time) (types.Status, time.Duration) {
+	var (
+		status types.Status
+		since  time.Time
+	)
+
+	switch {
+	case period == 0:
+		return types.StatusUnset, 0
+	case s.CurrentStatus == types.StatusOk && !s.WarningSince.IsZero():
+		status = types.StatusWarning
+		since = s.WarningSince
+
+		// Critical since the beginning, critical will be reached directly.
+		if s.CriticalSince.Equal(s.WarningSince) {
+			status = types.StatusCritical
+		}
+	case s.CurrentStatus == types.StatusWarning && !s.CriticalSince.IsZero():
+		status = types.StatusCritical
+		since = s.CriticalSince
+	default:
+		return types.StatusUnset, 0
+	}
+
+	remaining := period - now.Sub(since)
+	if remaining <= 0 {
+		return types.StatusUnset, 0
+	}
+
+	return status, remaining
+}
+
 // Threshold define a min/max thresholds.
 // Use NaN to mark the limit as unset.
 type Threshold struct {
@@ -225,11 +358,60 @@ type Unit struct {
 
 // Possible value for UnitType.
 const (
-	UnitTypeUnit = 0
-	UnitTypeByte = 2
-	UnitTypeBit  = 3
+	UnitTypeUnit    = 0
+	UnitTypePercent = 1
+	UnitTypeByte    = 2
+	UnitTypeBit     = 3
+	UnitTypeSecond  = 6
 )
 
+// UnitFromAnnotation returns the Unit matching a MetricAnnotations.Unit value.
+// An empty or unknown unit is a number without unit.
+func UnitFromAnnotation(unit string) Unit {
+	switch unit {
+	case types.UnitBytes:
+		return Unit{UnitType: UnitTypeByte, UnitText: "Byte"}
+	case types.UnitBits:
+		return Unit{UnitType: UnitTypeBit, UnitText: "bit"}
+	case types.UnitPercent:
+		return Unit{UnitType: UnitTypePercent, UnitText: "%"}
+	case types.UnitSeconds:
+		return Unit{UnitType: UnitTypeSecond, UnitText: "seconds"}
+	default:
+		return Unit{UnitType: UnitTypeUnit}
+	}
+}
+
+// UnitFromInterfaceMap convert a map[string]interface{} to Unit.
+// It expect the key "unit" (one of "unit", "byte" or "bit") and "unit_text".
+func UnitFromInterfaceMap(input map[string]interface{}) (Unit, error) {
+	var result Unit
+
+	if raw, ok := input["unit"]; ok {
+		switch raw {
+		case "unit", "":
+			result.UnitType = UnitTypeUnit
+		case "byte":
+			result.UnitType = UnitTypeByte
+		case "bit":
+			result.UnitType = UnitTypeBit
+		default:
+			return result, fmt.Errorf("%v is not a known unit, expected unit, byte or bit", raw)
+		}
+	}
+
+	if raw, ok := input["unit_text"]; ok {
+		text, ok := raw.(string)
+		if !ok {
+			return result, fmt.Errorf("unit_text %v is not a string", raw)
+		}
+
+		result.UnitText = text
+	}
+
+	return result, nil
+}
+
 // FromInterfaceMap convert a map[string]interface{} to Threshold.
 // It expect the key "low_critical", "low_warning", "high_critical" and "high_warning".
 func FromInterfaceMap(input map[string]interface{}) (Threshold, error) {
@@ -309,6 +491,14 @@ func (r *Registry) GetThreshold(key MetricNameItem) Threshold {
 	return r.getThreshold(key)
 }
 
+// FormatValue formats the value with the unit of the metric, like in status descriptions.
+func (r *Registry) FormatValue(key MetricNameItem, annotatedUnit string, value float64) string {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	return formatValue(value, r.getUnit(key, annotatedUnit))
+}
+
 func (r *Registry) getThreshold(key MetricNameItem) Threshold {
 	if threshold, ok := r.thresholds[key]; ok {
 		return threshold
@@ -361,7 +551,7 @@ func (r *Registry) run(save bool) {
 	jsonList := make([]jsonState, 0, len(r.states))
 
 	for k, v := range r.states {
-		if time.Since(v.LastUpdate) > 60*time.Minute {
+		if time.Since(v.LastUpdate) > stateExpiration {
 			delete(r.states, k)
 		} else {
 			jsonList = append(jsonList, jsonState{
@@ -371,6 +561,12 @@ func (r *Registry) run(save bool) {
 		}
 	}
 
+	for k, v := range r.lastStatus {
+		if time.Since(v.lastUpdate) > stateExpiration {
+			delete(r.lastStatus, k)
+		}
+	}
+
 	if save {
 		_ = r.state.Set(statusCacheKey, jsonList)
 	}
@@ -445,12 +641,21 @@ func (r *Registry) WithPusher(p types.PointPusher) types.PointPusher {
 }
 
 // PushPoints implement PointPusher and do threshold.
+//
+// Items are sanitized first, so thresholds and statuses use the same item as the one sent to Bleemeo.
 func (p pusher) PushPoints(points []types.MetricPoint) {
+	points = sanitizeItems(points)
+
 	p.registry.l.Lock()
 
 	result := make([]types.MetricPoint, 0, len(points))
 
 	for _, point := range points {
+		if value.IsStaleNaN(point.Value) {
+			result = p.addStalePoint(result, point)
+			continue
+		}
+
 		if !point.Annotations.Status.CurrentStatus.IsSet() {
 			key := MetricNameItem{
 				Name: point.Labels[types.LabelName],
@@ -467,8 +672,156 @@ func (p pusher) PushPoints(points []types.MetricPoint) {
 		result = append(result, point)
 	}
 
+	result = p.evaluateRules(points, result)
+	result = p.registry.applyMaintenance(result, time.Now())
+	changes := p.registry.statusChanges(result)
+
 	p.registry.l.Unlock()
 	p.pusher.PushPoints(result)
+
+	if len(changes) > 0 {
+		p.registry.notify(changes)
+	}
+}
+
+// sanitizeItems returns the points with their item sanitized. The points are copied only if needed.
+func sanitizeItems(points []types.MetricPoint) []types.MetricPoint {
+	var result []types.MetricPoint
+
+	for i, point := range points {
+		item := types.SanitizeItem(point.Annotations.BleemeoItem, point.Annotations.ServiceName != "")
+		if item == point.Annotations.BleemeoItem {
+			continue
+		}
+
+		if result == nil {
+			result = make([]types.MetricPoint, len(points))
+			copy(result, points)
+		}
+
+		result[i].Annotations.BleemeoItem = item
+	}
+
+	if result == nil {
+		return points
+	}
+
+	return result
+}
+
+// AddStatusNotifiee add a callback that will be notified of all status changes.
+// Note: AddStatusNotifiee should NOT be called while in the callback.
+func (r *Registry) AddStatusNotifiee(cb func([]StatusChange)) int {
+	r.notifeeLock.Lock()
+	defer r.notifeeLock.Unlock()
+
+	id := 1
+	_, ok := r.notifyCallbacks[id]
+
+	for ok {
+		id++
+		_, ok = r.notifyCallbacks[id]
+	}
+
+	r.notifyCallbacks[id] = cb
+
+	return id
+}
+
+// RemoveStatusNotifiee remove a callback that was notified.
+// Note: RemoveStatusNotifiee should NOT be called while in the callback.
+func (r *Registry) RemoveStatusNotifiee(id int) {
+	r.notifeeLock.Lock()
+	defer r.notifeeLock.Unlock()
+
+	delete(r.notifyCallbacks, id)
+}
+
+func (r *Registry) notify(changes []StatusChange) {
+	r.notifeeLock.Lock()
+	defer r.notifeeLock.Unlock()
+
+	for _, cb := range r.notifyCallbacks {
+		cb(changes)
+	}
+}
+
+// statusChanges returns the points whose status changed since their previous point.
+// The registry lock must be held.
+func (r *Registry) statusChanges(points []types.MetricPoint) []StatusChange {
+	// A metric with a threshold is sent with its "_status" metric, only the later
+	// is considered.
+	statusOf := make(map[string]bool)
+
+	for _, point := range points {
+		if point.Annotations.StatusOf != "" {
+			statusOf[point.Annotations.StatusOf] = true
+		}
+	}
+
+	var changes []StatusChange
+
+	for _, point := range points {
+		status := point.Annotations.Status
+		if !status.CurrentStatus.IsSet() || statusOf[point.Labels[types.LabelName]] {
+			continue
+		}
+
+		key := types.LabelsToText(point.Labels) + "\x00" + point.Annotations.BleemeoItem
+		previous := r.lastStatus[key].status
+		r.lastStatus[key] = lastStatus{status: status.CurrentStatus, lastUpdate: time.Now()}
+
+		if previous == status.CurrentStatus {
+			continue
+		}
+
+		if !previous.IsSet() && status.CurrentStatus == types.StatusOk {
+			continue
+		}
+
+		changes = append(changes, StatusChange{
+			Labels:            point.Labels,
+			Annotations:       point.Annotations,
+			PreviousStatus:    previous,
+			CurrentStatus:     status.CurrentStatus,
+			StatusDescription: status.StatusDescription,
+			Time:              point.Time,
+		})
+	}
+
+	return changes
+}
+
+// addStalePoint forwards a staleness marker, and the marker of its status metric if the metric has a threshold.
+func (p *pusher) addStalePoint(points []types.MetricPoint, point types.MetricPoint) []types.MetricPoint {
+	points = append(points, point)
+
+	key := MetricNameItem{
+		Name: point.Labels[types.LabelName],
+		Item: point.Annotations.BleemeoItem,
+	}
+
+	if point.Annotations.Status.CurrentStatus.IsSet() || p.registry.getThreshold(key).IsZero() {
+		return points
+	}
+
+	if p.registry.noStatusMetric || p.registry.noStatusMetricFor[key.Name] {
+		return points
+	}
+
+	labelsCopy := make(map[string]string, len(point.Labels))
+
+	for k, v := range point.Labels {
+		labelsCopy[k] = v
+	}
+
+	labelsCopy[types.LabelName] += "_status"
+
+	return append(points, types.MetricPoint{
+		Point:       point.Point,
+		Labels:      labelsCopy,
+		Annotations: point.Annotations,
+	})
 }
 
 func (p *pusher) addPointWithThreshold(points []types.MetricPoint, point types.MetricPoint, threshold Threshold, key MetricNameItem) []types.MetricPoint {
@@ -480,10 +833,12 @@ func (p *pusher) addPointWithThreshold(points []types.MetricPoint, point types.M
 		period = tmp
 	}
 
-	newState := previousState.Update(softStatus, period, time.Now())
+	now := time.Now()
+	newState := previousState.Update(softStatus, period, now)
 	p.registry.states[key] = newState
+	pendingStatus, pendingDuration := newState.Pending(period, now)
 
-	unit := p.registry.units[key]
+	unit := p.registry.getUnit(key, point.Annotations.Unit)
 	// Consumer expect status description from threshold to start with "Current value:"
 	statusDescription := fmt.Sprintf("Current value: %s", formatValue(point.Value, unit))
 
@@ -502,6 +857,14 @@ func (p *pusher) addPointWithThreshold(points []types.MetricPoint, point types.M
 		}
 	}
 
+	if pendingStatus.IsSet() {
+		statusDescription += fmt.Sprintf(
+			" (%s pending, will fire in %s)",
+			pendingStatus.String(),
+			formatDuration(pendingDuration),
+		)
+	}
+
 	status := types.StatusDescription{
 		CurrentStatus:     newState.CurrentStatus,
 		StatusDescription: statusDescription,
@@ -515,21 +878,44 @@ func (p *pusher) addPointWithThreshold(points []types.MetricPoint, point types.M
 		Annotations: annotationsCopy,
 	})
 
-	labelsCopy := make(map[string]string, len(point.Labels))
+	if !p.registry.noStatusMetric && !p.registry.noStatusMetricFor[key.Name] {
+		labelsCopy := make(map[string]string, len(point.Labels))
 
-	for k, v := range point.Labels {
-		labelsCopy[k] = v
+		for k, v := range point.Labels {
+			labelsCopy[k] = v
+		}
+
+		labelsCopy[types.LabelName] += "_status"
+
+		annotationsCopy.StatusOf = point.Labels[types.LabelName]
+
+		points = append(points, types.MetricPoint{
+			Point:       types.Point{Time: point.Time, Value: float64(status.CurrentStatus.NagiosCode())},
+			Labels:      labelsCopy,
+			Annotations: annotationsCopy,
+		})
 	}
 
-	labelsCopy[types.LabelName] += "_status"
+	if p.registry.pendingStatus {
+		pendingValue := 0.0
+		if pendingStatus.IsSet() {
+			pendingValue = float64(pendingStatus.NagiosCode())
+		}
 
-	annotationsCopy.StatusOf = point.Labels[types.LabelName]
+		pendingLabels := make(map[string]string, len(point.Labels))
 
-	points = append(points, types.MetricPoint{
-		Point:       types.Point{Time: point.Time, Value: float64(status.CurrentStatus.NagiosCode())},
-		Labels:      labelsCopy,
-		Annotations: annotationsCopy,
-	})
+		for k, v := range point.Labels {
+			pendingLabels[k] = v
+		}
+
+		pendingLabels[types.LabelName] += "_pending_status"
+
+		points = append(points, types.MetricPoint{
+			Point:       types.Point{Time: point.Time, Value: pendingValue},
+			Labels:      pendingLabels,
+			Annotations: point.Annotations,
+		})
+	}
 
 	return points
 }
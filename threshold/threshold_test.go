@@ -17,9 +17,11 @@
 package threshold
 
 import (
+	"encoding/json"
 	"glouton/types"
 	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -33,6 +35,24 @@ func (m mockState) Set(key string, object interface{}) error {
 	return nil
 }
 
+// jsonMockState stores values like the state file, encoded in JSON.
+type jsonMockState map[string][]byte
+
+func (m jsonMockState) Get(key string, result interface{}) error {
+	if buffer, ok := m[key]; ok {
+		return json.Unmarshal(buffer, result)
+	}
+
+	return nil
+}
+
+func (m jsonMockState) Set(key string, object interface{}) error {
+	buffer, err := json.Marshal(object)
+	m[key] = buffer
+
+	return err
+}
+
 type mockStore struct {
 	points []types.MetricPoint
 }
@@ -120,6 +140,34 @@ func TestStateUpdatePeriodChange(t *testing.T) {
 	}
 }
 
+func TestStatePending(t *testing.T) {
+	cases := []struct {
+		timeOffsetSecond int
+		status           types.Status
+		wantStatus       types.Status
+		wantRemaining    time.Duration
+	}{
+		{-10, types.StatusOk, types.StatusUnset, 0},
+		{0, types.StatusWarning, types.StatusWarning, 300 * time.Second},
+		{60, types.StatusCritical, types.StatusWarning, 240 * time.Second},
+		{300, types.StatusCritical, types.StatusCritical, 60 * time.Second},
+		{360, types.StatusCritical, types.StatusUnset, 0},
+		{370, types.StatusOk, types.StatusUnset, 0},
+	}
+	now := time.Now()
+	state := statusState{}
+
+	for _, step := range cases {
+		stepTime := now.Add(time.Duration(step.timeOffsetSecond) * time.Second)
+		state = state.Update(step.status, 300*time.Second, stepTime)
+
+		gotStatus, gotRemaining := state.Pending(300*time.Second, stepTime)
+		if gotStatus != step.wantStatus || gotRemaining != step.wantRemaining {
+			t.Errorf("offset %d: state.Pending() == (%v, %v), want (%v, %v)", step.timeOffsetSecond, gotStatus, gotRemaining, step.wantStatus, step.wantRemaining)
+		}
+	}
+}
+
 func TestFormatValue(t *testing.T) {
 	cases := []struct {
 		value float64
@@ -376,3 +424,505 @@ func TestAccumulatorThreshold(t *testing.T) {
 		}
 	}
 }
+
+func TestAnnotatedUnit(t *testing.T) {
+	db := &mockStore{}
+	threshold := New(mockState{})
+	threshold.SetThresholds(
+		nil,
+		map[string]Threshold{
+			"mem_used":  {HighWarning: 1024, HighCritical: 4096},
+			"disk_used": {HighWarning: 1024, HighCritical: 4096},
+		},
+	)
+	threshold.SetUnitsAllItem(map[string]Unit{"disk_used": {UnitType: 42, UnitText: "blocks"}})
+
+	t0 := time.Date(2020, 2, 24, 15, 1, 0, 0, time.UTC)
+
+	threshold.WithPusher(db).PushPoints([]types.MetricPoint{
+		{
+			Labels:      map[string]string{types.LabelName: "mem_used"},
+			Annotations: types.MetricAnnotations{Unit: types.UnitBytes},
+			Point:       types.Point{Time: t0, Value: 2048},
+		},
+		{
+			Labels:      map[string]string{types.LabelName: "disk_used"},
+			Annotations: types.MetricAnnotations{Unit: types.UnitBytes},
+			Point:       types.Point{Time: t0, Value: 2048},
+		},
+	})
+
+	want := map[string]string{
+		"mem_used":  "Current value: 2.00 KBytes threshold (1.00 KBytes) exceeded over last 5 minutes",
+		"disk_used": "Current value: 2048.00 blocks threshold (1024.00 blocks) exceeded over last 5 minutes",
+	}
+
+	for _, p := range db.points {
+		name := p.Labels[types.LabelName]
+		if wantDescription, ok := want[name]; ok && p.Annotations.Status.StatusDescription != wantDescription {
+			t.Errorf("%s description = %#v, want %#v", name, p.Annotations.Status.StatusDescription, wantDescription)
+		}
+	}
+}
+
+func TestAccumulatorPendingStatus(t *testing.T) {
+	db := &mockStore{}
+	threshold := New(mockState{})
+	threshold.SetThresholds(
+		nil,
+		map[string]Threshold{"cpu_used": {
+			HighWarning:  80,
+			HighCritical: 90,
+		}},
+	)
+	threshold.SetPendingStatusMetric(true)
+
+	pusher := threshold.WithPusher(db)
+	t0 := time.Now()
+
+	for _, value := range []float64{10, 88} {
+		pusher.PushPoints([]types.MetricPoint{
+			{
+				Labels: map[string]string{types.LabelName: "cpu_used"},
+				Point:  types.Point{Time: t0, Value: value},
+			},
+		})
+	}
+
+	if len(db.points) != 6 {
+		t.Fatalf("len(points) == %d, want 6", len(db.points))
+	}
+
+	want := "Current value: 88.00 (warning pending, will fire in 5 minutes)"
+	if got := db.points[4].Annotations.Status.StatusDescription; got != want {
+		t.Errorf("StatusDescription = %#v, want %#v", got, want)
+	}
+
+	pending := db.points[5]
+	if pending.Labels[types.LabelName] != "cpu_used_pending_status" || pending.Value != 1 {
+		t.Errorf("points[5] = %v, want cpu_used_pending_status = 1", pending)
+	}
+
+	if db.points[2].Value != 0 {
+		t.Errorf("first pending status = %v, want 0", db.points[2].Value)
+	}
+}
+
+func TestStatusMetricDisabled(t *testing.T) {
+	db := &mockStore{}
+	threshold := New(mockState{})
+	threshold.SetThresholds(
+		nil,
+		map[string]Threshold{
+			"cpu_used":      {HighWarning: 80, HighCritical: 90},
+			"mem_used_perc": {HighWarning: 80, HighCritical: 90},
+		},
+	)
+	threshold.SetSoftPeriod(0, nil)
+	threshold.SetStatusMetric(true, []string{"cpu_used"})
+
+	var changes []StatusChange
+
+	threshold.AddStatusNotifiee(func(c []StatusChange) {
+		changes = append(changes, c...)
+	})
+
+	threshold.WithPusher(db).PushPoints([]types.MetricPoint{
+		{
+			Labels: map[string]string{types.LabelName: "cpu_used"},
+			Point:  types.Point{Time: time.Now(), Value: 95},
+		},
+		{
+			Labels: map[string]string{types.LabelName: "mem_used_perc"},
+			Point:  types.Point{Time: time.Now(), Value: 10},
+		},
+	})
+
+	names := make([]string, 0, len(db.points))
+
+	for _, p := range db.points {
+		names = append(names, p.Labels[types.LabelName])
+	}
+
+	if want := []string{"cpu_used", "mem_used_perc", "mem_used_perc_status"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("points = %v, want %v", names, want)
+	}
+
+	if got := db.points[0].Annotations.Status.CurrentStatus; got != types.StatusCritical {
+		t.Errorf("cpu_used status = %v, want %v", got, types.StatusCritical)
+	}
+
+	if len(changes) != 1 || changes[0].Labels[types.LabelName] != "cpu_used" {
+		t.Errorf("changes = %v, want the change of cpu_used", changes)
+	}
+
+	db.points = nil
+
+	threshold.SetStatusMetric(false, nil)
+	threshold.WithPusher(db).PushPoints([]types.MetricPoint{
+		{
+			Labels: map[string]string{types.LabelName: "mem_used_perc"},
+			Point:  types.Point{Time: time.Now(), Value: 10},
+		},
+	})
+
+	if len(db.points) != 1 {
+		t.Errorf("len(points) = %d, want 1", len(db.points))
+	}
+}
+
+func TestStatusNotifiee(t *testing.T) {
+	db := &mockStore{}
+	threshold := New(mockState{})
+	threshold.SetThresholds(
+		nil,
+		map[string]Threshold{"cpu_used": {
+			HighWarning:  80,
+			HighCritical: 90,
+		}},
+	)
+	threshold.SetSoftPeriod(0, nil)
+
+	var changes []StatusChange
+
+	id := threshold.AddStatusNotifiee(func(c []StatusChange) {
+		changes = append(changes, c...)
+	})
+
+	pusher := threshold.WithPusher(db)
+	t0 := time.Now()
+
+	for _, value := range []float64{10, 95, 96, 20} {
+		pusher.PushPoints([]types.MetricPoint{
+			{
+				Labels: map[string]string{types.LabelName: "cpu_used"},
+				Point:  types.Point{Time: t0, Value: value},
+			},
+			{
+				Labels:      map[string]string{types.LabelName: "service_status"},
+				Annotations: types.MetricAnnotations{Status: types.StatusDescription{CurrentStatus: types.StatusOk}},
+				Point:       types.Point{Time: t0},
+			},
+		})
+	}
+
+	want := []struct {
+		name     string
+		previous types.Status
+		current  types.Status
+	}{
+		{name: "cpu_used_status", previous: types.StatusOk, current: types.StatusCritical},
+		{name: "cpu_used_status", previous: types.StatusCritical, current: types.StatusOk},
+	}
+
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %d changes", changes, len(want))
+	}
+
+	for i, w := range want {
+		if changes[i].Labels[types.LabelName] != w.name || changes[i].PreviousStatus != w.previous || changes[i].CurrentStatus != w.current {
+			t.Errorf("changes[%d] = %v, want %v", i, changes[i], w)
+		}
+	}
+
+	threshold.RemoveStatusNotifiee(id)
+
+	pusher.PushPoints([]types.MetricPoint{
+		{
+			Labels: map[string]string{types.LabelName: "cpu_used"},
+			Point:  types.Point{Time: t0, Value: 99},
+		},
+	})
+
+	if len(changes) != len(want) {
+		t.Errorf("changes = %v, want no change after RemoveStatusNotifiee", changes)
+	}
+}
+
+// TestLastStatusExpiration checks that the last status of metrics no longer updated is forgotten.
+func TestLastStatusExpiration(t *testing.T) {
+	threshold := New(mockState{})
+	pusher := threshold.WithPusher(&mockStore{})
+
+	for _, name := range []string{"old_status", "recent_status"} {
+		pusher.PushPoints([]types.MetricPoint{
+			{
+				Labels:      map[string]string{types.LabelName: name},
+				Annotations: types.MetricAnnotations{Status: types.StatusDescription{CurrentStatus: types.StatusCritical}},
+				Point:       types.Point{Time: time.Now(), Value: 2},
+			},
+		})
+	}
+
+	for k, v := range threshold.lastStatus {
+		if strings.Contains(k, "old_status") {
+			v.lastUpdate = time.Now().Add(-2 * stateExpiration)
+			threshold.lastStatus[k] = v
+		}
+	}
+
+	threshold.run(false)
+
+	if len(threshold.lastStatus) != 1 {
+		t.Errorf("lastStatus = %v, want only recent_status", threshold.lastStatus)
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	db := &mockStore{}
+	threshold := New(mockState{})
+	threshold.SetThresholds(
+		nil,
+		map[string]Threshold{"cpu_used": {
+			HighWarning:  80,
+			HighCritical: 90,
+		}},
+	)
+	threshold.SetSoftPeriod(0, nil)
+
+	t0 := time.Now()
+
+	threshold.SetMaintenanceWindows([]MaintenanceWindow{
+		{Metric: "cpu_*", Start: t0.Add(-time.Minute), End: t0.Add(time.Hour), Reason: "upgrade"},
+		{Service: "mysql", Start: t0.Add(time.Hour), End: t0.Add(2 * time.Hour)},
+	})
+
+	var changes []StatusChange
+
+	threshold.AddStatusNotifiee(func(c []StatusChange) {
+		changes = append(changes, c...)
+	})
+
+	pusher := threshold.WithPusher(db)
+	pusher.PushPoints([]types.MetricPoint{
+		{
+			Labels: map[string]string{types.LabelName: "cpu_used"},
+			Point:  types.Point{Time: t0, Value: 95},
+		},
+		{
+			Labels: map[string]string{types.LabelName: "mysql_status"},
+			Annotations: types.MetricAnnotations{
+				ServiceName: "mysql",
+				Status:      types.StatusDescription{CurrentStatus: types.StatusCritical},
+			},
+			Point: types.Point{Time: t0, Value: 2},
+		},
+	})
+
+	want := map[string]struct {
+		status types.Status
+		value  float64
+	}{
+		"cpu_used":        {status: types.StatusOk, value: 95},
+		"cpu_used_status": {status: types.StatusOk, value: 0},
+		"mysql_status":    {status: types.StatusCritical, value: 2},
+	}
+
+	for _, p := range db.points {
+		name := p.Labels[types.LabelName]
+
+		if w := want[name]; p.Annotations.Status.CurrentStatus != w.status || p.Value != w.value {
+			t.Errorf("%s = %v (%v), want %v (%v)", name, p.Annotations.Status.CurrentStatus, p.Value, w.status, w.value)
+		}
+	}
+
+	if len(changes) != 1 || changes[0].Labels[types.LabelName] != "mysql_status" {
+		t.Errorf("changes = %v, want only mysql_status", changes)
+	}
+
+	window, err := threshold.AddMaintenanceWindow(MaintenanceWindow{Service: "mysql", Start: t0, End: t0.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(threshold.MaintenanceWindows()); got != 3 {
+		t.Errorf("len(MaintenanceWindows()) = %d, want 3", got)
+	}
+
+	if !threshold.RemoveMaintenanceWindow(window.ID) {
+		t.Errorf("RemoveMaintenanceWindow(%s) = false, want true", window.ID)
+	}
+
+	if threshold.RemoveMaintenanceWindow("config-0") {
+		t.Error("RemoveMaintenanceWindow(config-0) = true, want false for a configured window")
+	}
+
+	if _, err := threshold.AddMaintenanceWindow(MaintenanceWindow{Start: t0, End: t0}); err == nil {
+		t.Error("AddMaintenanceWindow() succeeded with an empty window, want an error")
+	}
+}
+
+// TestApplyMaintenanceCopy checks that applyMaintenance doesn't modify the points of the caller.
+func TestApplyMaintenanceCopy(t *testing.T) {
+	threshold := New(mockState{})
+	t0 := time.Now()
+
+	threshold.SetMaintenanceWindows([]MaintenanceWindow{
+		{Metric: "mysql_status", Start: t0.Add(-time.Minute), End: t0.Add(time.Hour)},
+	})
+
+	points := []types.MetricPoint{
+		{
+			Labels:      map[string]string{types.LabelName: "mysql_status"},
+			Annotations: types.MetricAnnotations{Status: types.StatusDescription{CurrentStatus: types.StatusCritical}},
+			Point:       types.Point{Time: t0, Value: 2},
+		},
+	}
+
+	result := threshold.applyMaintenance(points, t0)
+
+	if result[0].Annotations.Status.CurrentStatus != types.StatusOk || result[0].Value != 0 {
+		t.Errorf("result status = %v with value %v, want ok with 0", result[0].Annotations.Status.CurrentStatus, result[0].Value)
+	}
+
+	if points[0].Annotations.Status.CurrentStatus != types.StatusCritical || points[0].Value != 2 {
+		t.Errorf("caller status = %v with value %v, want unchanged critical with 2", points[0].Annotations.Status.CurrentStatus, points[0].Value)
+	}
+}
+
+func TestNewRule(t *testing.T) {
+	cases := []struct {
+		expr    string
+		values  map[string]float64
+		want    bool
+		wantErr bool
+	}{
+		{
+			expr:   "mem_available_perc < 5 AND swap_used_perc > 80",
+			values: map[string]float64{"mem_available_perc": 3, "swap_used_perc": 90},
+			want:   true,
+		},
+		{
+			expr:   "mem_available_perc < 5 AND swap_used_perc > 80",
+			values: map[string]float64{"mem_available_perc": 3, "swap_used_perc": 10},
+			want:   false,
+		},
+		{
+			expr:   "a>1 or b>1 and c>1",
+			values: map[string]float64{"a": 2, "b": 0, "c": 0},
+			want:   true,
+		},
+		{
+			expr:   "(a>1 OR b>1) AND c>1",
+			values: map[string]float64{"a": 2, "b": 0, "c": 0},
+			want:   false,
+		},
+		{
+			expr:   "temperature <= -10.5 OR temperature != 20",
+			values: map[string]float64{"temperature": 20},
+			want:   false,
+		},
+		{expr: "", wantErr: true},
+		{expr: "cpu_used > ", wantErr: true},
+		{expr: "cpu_used = 5", wantErr: true},
+		{expr: "(cpu_used > 5", wantErr: true},
+		{expr: "cpu_used > 5 mem_used > 5", wantErr: true},
+		{expr: "cpu_used > 5 AND", wantErr: true},
+	}
+
+	for _, c := range cases {
+		rule, err := NewRule("test", c.expr, types.StatusWarning, 0)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("NewRule(%#v) succeeded, want an error", c.expr)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("NewRule(%#v) failed: %v", c.expr, err)
+			continue
+		}
+
+		got, err := rule.expr.eval(c.values)
+		if err != nil {
+			t.Errorf("eval(%#v) failed: %v", c.expr, err)
+		} else if got != c.want {
+			t.Errorf("eval(%#v) = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestRuleStatus(t *testing.T) {
+	db := &mockStore{}
+	threshold := New(mockState{})
+
+	rule, err := NewRule("memory_pressure", "mem_available_perc < 5 AND swap_used_perc > 80", types.StatusCritical, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threshold.SetRules([]Rule{rule})
+
+	pusher := threshold.WithPusher(db)
+	now := time.Now()
+
+	pusher.PushPoints([]types.MetricPoint{
+		{
+			Labels: map[string]string{types.LabelName: "mem_available_perc"},
+			Point:  types.Point{Time: now, Value: 3},
+		},
+	})
+
+	if len(db.points) != 1 {
+		t.Fatalf("len(points) = %d, want 1 (rule can't be evaluated without swap_used_perc)", len(db.points))
+	}
+
+	pusher.PushPoints([]types.MetricPoint{
+		{
+			Labels: map[string]string{types.LabelName: "swap_used_perc"},
+			Point:  types.Point{Time: now, Value: 90},
+		},
+	})
+
+	if len(db.points) != 3 {
+		t.Fatalf("len(points) = %d, want 3", len(db.points))
+	}
+
+	got := db.points[2]
+	if got.Labels[types.LabelName] != "memory_pressure" || got.Annotations.Status.CurrentStatus != types.StatusCritical {
+		t.Errorf("points[2] = %v, want memory_pressure critical", got)
+	}
+
+	wantDescription := "mem_available_perc < 5 AND swap_used_perc > 80 is true (mem_available_perc=3.00, swap_used_perc=90.00)"
+	if got.Annotations.Status.StatusDescription != wantDescription {
+		t.Errorf("description = %#v, want %#v", got.Annotations.Status.StatusDescription, wantDescription)
+	}
+}
+
+func TestStateRestored(t *testing.T) {
+	key := MetricNameItem{Name: "cpu_used"}
+	state := jsonMockState{}
+	now := time.Now()
+
+	err := state.Set(statusCacheKey, []jsonState{
+		{
+			MetricNameItem: key,
+			statusState: statusState{
+				CurrentStatus: types.StatusOk,
+				WarningSince:  now.Add(-time.Minute),
+				LastUpdate:    now,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := New(state)
+
+	restored, ok := registry.states[key]
+	if !ok {
+		t.Fatalf("state of %v isn't restored", key)
+	}
+
+	// The restored time must carry a monotonic clock reading to be immune to wall clock jumps.
+	if restored.WarningSince == restored.WarningSince.Round(0) {
+		t.Errorf("WarningSince has no monotonic clock reading")
+	}
+
+	if d := time.Since(restored.WarningSince); d < time.Minute || d > 2*time.Minute {
+		t.Errorf("time.Since(WarningSince) = %v, want 1m", d)
+	}
+}
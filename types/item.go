@@ -0,0 +1,67 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Maximal length of items. Longer items are truncated and suffixed by a hash of the
+// full item, so two long items sharing the same prefix stay distinct.
+const (
+	ItemMaxLength        = 100
+	ItemMaxLengthService = 50
+	itemHashLength       = 8
+)
+
+// SanitizeItem returns the item without control characters and invalid UTF-8, truncated
+// to the maximal length of an item. The result is stable: sanitizing it again returns it unchanged.
+//
+// Items of services are limited to ItemMaxLengthService, the others to ItemMaxLength.
+func SanitizeItem(item string, isService bool) string {
+	maxLength := ItemMaxLength
+	if isService {
+		maxLength = ItemMaxLengthService
+	}
+
+	item = strings.Map(func(r rune) rune {
+		// strings.Map gives utf8.RuneError for invalid UTF-8.
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return '_'
+		}
+
+		return r
+	}, strings.TrimSpace(item))
+
+	if len(item) <= maxLength {
+		return item
+	}
+
+	sum := sha256.Sum256([]byte(item))
+	suffix := "_" + hex.EncodeToString(sum[:])[:itemHashLength]
+	cut := maxLength - len(suffix)
+
+	for cut > 0 && !utf8.RuneStart(item[cut]) {
+		cut--
+	}
+
+	return item[:cut] + suffix
+}
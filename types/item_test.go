@@ -0,0 +1,72 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeItem(t *testing.T) {
+	long := strings.Repeat("a", 120)
+
+	cases := []struct {
+		name      string
+		item      string
+		isService bool
+		want      string
+	}{
+		{name: "unchanged", item: "/home", want: "/home"},
+		{name: "control", item: "web\n1\t", want: "web_1"},
+		{name: "invalid-utf8", item: "disk\xff", want: "disk_"},
+		{name: "exact-length", item: long[:ItemMaxLength], want: long[:ItemMaxLength]},
+	}
+
+	for _, c := range cases {
+		if got := SanitizeItem(c.item, c.isService); got != c.want {
+			t.Errorf("%s: SanitizeItem(%#v) = %#v, want %#v", c.name, c.item, got, c.want)
+		}
+	}
+
+	for _, isService := range []bool{false, true} {
+		want := ItemMaxLength
+		if isService {
+			want = ItemMaxLengthService
+		}
+
+		got1 := SanitizeItem(long+"1", isService)
+		got2 := SanitizeItem(long+"2", isService)
+
+		if len(got1) != want || len(got2) != want {
+			t.Errorf("len(SanitizeItem()) = %d, %d, want %d", len(got1), len(got2), want)
+		}
+
+		if got1 == got2 {
+			t.Errorf("SanitizeItem() returned %#v for two distinct items", got1)
+		}
+
+		if again := SanitizeItem(got1, isService); again != got1 {
+			t.Errorf("SanitizeItem(%#v) = %#v, want it unchanged", got1, again)
+		}
+	}
+
+	got := SanitizeItem(strings.Repeat("é", 60), false)
+	if !utf8.ValidString(got) || len(got) > ItemMaxLength {
+		t.Errorf("SanitizeItem() = %#v, want a valid UTF-8 string of at most %d bytes", got, ItemMaxLength)
+	}
+}
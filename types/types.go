@@ -106,6 +106,9 @@ const (
 	LabelInstance             = "instance"
 	LabelJob                  = "job"
 	LabelContainerName        = "container_name"
+	LabelComposeProject       = "compose_project"
+	LabelStack                = "stack"
+	LabelService              = "service"
 	LabelGloutonJob           = "glouton_job"
 )
 
@@ -179,8 +182,18 @@ type MetricAnnotations struct {
 	// store the agent for which we want to emit the metric
 	BleemeoAgentID string
 	Status         StatusDescription
+	// Unit is the unit of the metric value, one of the Unit* constants or empty when unknown.
+	Unit string
 }
 
+// Possible values for MetricAnnotations.Unit.
+const (
+	UnitBytes   = "bytes"
+	UnitBits    = "bits"
+	UnitPercent = "percent"
+	UnitSeconds = "seconds"
+)
+
 // Point is the value of one metric at a given time.
 type Point struct {
 	Time  time.Time
@@ -266,4 +279,6 @@ type Monitor struct {
 	ExpectedContent         string
 	ExpectedResponseCode    int
 	ForbiddenContent        string
+	// Headers are added to the requests of HTTP monitors.
+	Headers map[string]string
 }
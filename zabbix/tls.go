@@ -0,0 +1,171 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zabbix
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// Connection types accepted by the server, like the Zabbix agent TLSAccept parameter.
+const (
+	AcceptUnencrypted = "unencrypted"
+	AcceptCert        = "cert"
+	AcceptPSK         = "psk"
+)
+
+// tlsRecordHandshake is the first byte of a TLS connection, an unencrypted one starts with "ZBXD".
+const tlsRecordHandshake = 0x16
+
+var errPSKUnsupported = errors.New("TLS with pre-shared key isn't supported, use certificates")
+
+// TLSOptions configures the encryption of the connections, with the same meaning as the
+// Zabbix agent TLS* parameters. An empty Accept only accepts unencrypted connections.
+type TLSOptions struct {
+	Accept   []string
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// ServerCertIssuer and ServerCertSubject, when set, must match the certificate of the
+	// Zabbix server, e.g. "CN=Zabbix server,O=Zabbix SIA".
+	ServerCertIssuer  string
+	ServerCertSubject string
+	PSKIdentity       string
+	PSKFile           string
+}
+
+// config returns the TLS configuration, nil when encrypted connections aren't accepted,
+// and whether unencrypted connections are accepted.
+func (o TLSOptions) config() (*tls.Config, bool, error) {
+	if len(o.Accept) == 0 {
+		return nil, true, nil
+	}
+
+	var (
+		unencrypted bool
+		cert        bool
+	)
+
+	for _, accept := range o.Accept {
+		switch accept {
+		case AcceptUnencrypted:
+			unencrypted = true
+		case AcceptCert:
+			cert = true
+		case AcceptPSK:
+			return nil, false, errPSKUnsupported
+		default:
+			return nil, false, fmt.Errorf("unknown TLS accept value %#v", accept)
+		}
+	}
+
+	if !cert {
+		return nil, unencrypted, nil
+	}
+
+	if o.CAFile == "" || o.CertFile == "" || o.KeyFile == "" {
+		return nil, false, errors.New("certificate connections require the CA, certificate and key files")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	caPEM, err := ioutil.ReadFile(o.CAFile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, false, fmt.Errorf("no certificate found in %s", o.CAFile)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return o.verifyServerCert(verifiedChains)
+		},
+	}
+
+	return config, unencrypted, nil
+}
+
+// verifyServerCert checks the issuer and the subject of the Zabbix server certificate.
+func (o TLSOptions) verifyServerCert(verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return errors.New("no verified certificate")
+	}
+
+	leaf := verifiedChains[0][0]
+
+	if o.ServerCertIssuer != "" && leaf.Issuer.String() != o.ServerCertIssuer {
+		return fmt.Errorf("certificate issuer %#v doesn't match %#v", leaf.Issuer.String(), o.ServerCertIssuer)
+	}
+
+	if o.ServerCertSubject != "" && leaf.Subject.String() != o.ServerCertSubject {
+		return fmt.Errorf("certificate subject %#v doesn't match %#v", leaf.Subject.String(), o.ServerCertSubject)
+	}
+
+	return nil
+}
+
+// peekedConn is a connection whose first bytes were already read in a buffer.
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// wrapConnection returns the connection to use with the client: a TLS connection when the
+// client starts a TLS handshake. Connections not using an accepted mode are refused.
+func (s Server) wrapConnection(c net.Conn) (net.Conn, error) {
+	if s.tlsConfig == nil {
+		return c, nil
+	}
+
+	reader := bufio.NewReader(c)
+
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := peekedConn{Conn: c, reader: reader}
+
+	if first[0] != tlsRecordHandshake {
+		if !s.acceptUnencrypted {
+			return nil, errors.New("unencrypted connection refused")
+		}
+
+		return conn, nil
+	}
+
+	return tls.Server(conn, s.tlsConfig), nil
+}
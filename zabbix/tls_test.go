@@ -0,0 +1,232 @@
+// Copyright 2015-2019 Bleemeo
+//
+// bleemeo.com an infrastructure monitoring solution in the Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zabbix
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testCert{cert: cert, key: key, der: der}
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// query sends an agent.ping query over the connection and returns the answer.
+func query(conn net.Conn) (string, error) {
+	if _, err := conn.Write([]byte("ZBXD\x01\x0a\x00\x00\x00\x00\x00\x00\x00agent.ping")); err != nil {
+		return "", err
+	}
+
+	answer, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	if len(answer) < 13 {
+		return "", errors.New("answer too short")
+	}
+
+	return string(answer[13:]), nil
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glouton-zabbix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "Zabbix CA", nil)
+	agent := newTestCert(t, "glouton", &ca)
+	server := newTestCert(t, "Zabbix server", &ca)
+
+	agentKey, err := x509.MarshalECPrivateKey(agent.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", ca.der)
+	writePEM(t, filepath.Join(dir, "agent.crt"), "CERTIFICATE", agent.der)
+	writePEM(t, filepath.Join(dir, "agent.key"), "EC PRIVATE KEY", agentKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	clientConfig := &tls.Config{
+		RootCAs:    pool,
+		ServerName: "glouton",
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{server.der},
+			PrivateKey:  server.key,
+		}},
+	}
+
+	options := TLSOptions{
+		Accept:   []string{AcceptCert},
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "agent.crt"),
+		KeyFile:  filepath.Join(dir, "agent.key"),
+	}
+
+	cases := []struct {
+		name              string
+		serverCertSubject string
+		encrypted         bool
+		wantOk            bool
+	}{
+		{name: "cert", encrypted: true, wantOk: true},
+		{name: "matching-subject", serverCertSubject: "CN=Zabbix server", encrypted: true, wantOk: true},
+		{name: "wrong-subject", serverCertSubject: "CN=Other", encrypted: true, wantOk: false},
+		{name: "unencrypted-refused", encrypted: false, wantOk: false},
+	}
+
+	for _, c := range cases {
+		options.ServerCertSubject = c.serverCertSubject
+
+		s, err := New("127.0.0.1:0", options, func(key string, args []string) (string, error) {
+			return "1", nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			serverConn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			_ = serverConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			conn, err := s.wrapConnection(serverConn)
+			if err != nil {
+				serverConn.Close()
+
+				return
+			}
+
+			handleConnection(conn, s.callback)
+		}()
+
+		clientConn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var conn net.Conn = clientConn
+		if c.encrypted {
+			conn = tls.Client(clientConn, clientConfig)
+		}
+
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		got, err := query(conn)
+
+		conn.Close()
+		l.Close()
+
+		if c.wantOk && (err != nil || got != "1") {
+			t.Errorf("%s: query() = %#v, %v, want \"1\"", c.name, got, err)
+		}
+
+		if !c.wantOk && err == nil && got == "1" {
+			t.Errorf("%s: query() succeeded, want a refused connection", c.name)
+		}
+	}
+}
+
+func TestTLSOptionsInvalid(t *testing.T) {
+	cases := []TLSOptions{
+		{Accept: []string{AcceptPSK}, PSKIdentity: "glouton", PSKFile: "/etc/zabbix/psk"},
+		{Accept: []string{AcceptCert}},
+		{Accept: []string{"everything"}},
+	}
+
+	for i, c := range cases {
+		if _, err := New("127.0.0.1:0", c, nil); err == nil {
+			t.Errorf("case %d: New() succeeded, want an error", i)
+		}
+	}
+}
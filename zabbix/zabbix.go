@@ -19,6 +19,7 @@ package zabbix
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -32,17 +33,30 @@ import (
 
 // Server is a Zabbix server than use Callback for reply to queries.
 type Server struct {
-	callback    callback
-	bindAddress string
+	callback          callback
+	bindAddress       string
+	tlsConfig         *tls.Config
+	acceptUnencrypted bool
 }
 
 // New returns a Zabbix server
 // callback is the function responsible to generate the response for a given query.
-func New(bindAddress string, callback callback) Server {
-	return Server{
-		callback:    callback,
-		bindAddress: bindAddress,
+func New(bindAddress string, tlsOptions TLSOptions, callback callback) (Server, error) {
+	tlsConfig, acceptUnencrypted, err := tlsOptions.config()
+	if err != nil {
+		return Server{}, err
 	}
+
+	if tlsConfig == nil && !acceptUnencrypted {
+		return Server{}, errors.New("no connection type is accepted")
+	}
+
+	return Server{
+		callback:          callback,
+		bindAddress:       bindAddress,
+		tlsConfig:         tlsConfig,
+		acceptUnencrypted: acceptUnencrypted,
+	}, nil
 }
 
 type packetStruct struct {
@@ -85,7 +99,7 @@ func handleConnection(c io.ReadWriteCloser, cb callback) {
 func decode(r io.Reader) (packetStruct, error) {
 	packetHead := make([]byte, 13)
 
-	_, err := r.Read(packetHead)
+	_, err := io.ReadFull(r, packetHead)
 	if err != nil {
 		return packetStruct{}, err
 	}
@@ -116,7 +130,7 @@ func decode(r io.Reader) (packetStruct, error) {
 
 	packetData := make([]byte, dataLength)
 
-	_, err = r.Read(packetData)
+	_, err = io.ReadFull(r, packetData)
 	if err != nil {
 		err = fmt.Errorf("r.Read failed for data: %v", err)
 		return decodedPacket, err
@@ -330,7 +344,16 @@ func (s Server) Run(ctx context.Context) error {
 
 		go func() {
 			defer wg.Done()
-			handleConnection(c, s.callback)
+
+			conn, err := s.wrapConnection(c)
+			if err != nil {
+				logger.V(1).Printf("Zabbix connection from %v refused: %v", c.RemoteAddr(), err)
+				c.Close()
+
+				return
+			}
+
+			handleConnection(conn, s.callback)
 		}()
 	}
 